@@ -27,14 +27,16 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 
 	"github.com/RedHatInsights/insights-results-aggregator/consumer"
-	"github.com/RedHatInsights/insights-results-aggregator/content"
+	"github.com/RedHatInsights/insights-results-aggregator/mirror"
 	"github.com/RedHatInsights/insights-results-aggregator/server"
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
 )
@@ -48,6 +50,12 @@ const (
 	ExitStatusConsumerError
 	// ExitStatusServerError is returned in case of any REST API server-related error
 	ExitStatusServerError
+	// ExitStatusLoadGeneratorError is returned when the load generator can't be started or fails
+	ExitStatusLoadGeneratorError
+	// ExitStatusImportReportsError is returned when the import of reports fails or any report can't be imported
+	ExitStatusImportReportsError
+	// ExitStatusPartitionReportsError is returned when partitioning of the report table fails
+	ExitStatusPartitionReportsError
 	defaultConfigFilename = "config"
 
 	databasePreparationMessage = "database preparation existed with error code %v"
@@ -57,9 +65,13 @@ const (
 var (
 	serverInstance   *server.HTTPServer
 	consumerInstance consumer.Consumer
+	// consumerWatchdog detects stuck consumer loop, it's nil when the check is disabled
+	consumerWatchdog *consumer.Watchdog
+	// backgroundLoops manages all periodic tasks running alongside consumer and server
+	backgroundLoops = newLifecycleManager()
 )
 
-func startStorageConnection() (*storage.DBStorage, error) {
+func startStorageConnection() (storage.Storage, error) {
 	storageCfg := getStorageConfiguration()
 
 	dbStorage, err := storage.New(storageCfg)
@@ -71,9 +83,9 @@ func startStorageConnection() (*storage.DBStorage, error) {
 	return dbStorage, nil
 }
 
-// closeStorage closes specified DBStorage with proper error checking
+// closeStorage closes specified storage with proper error checking
 // whether the close operation was successful or not.
-func closeStorage(storage *storage.DBStorage) {
+func closeStorage(storage storage.Storage) {
 	err := storage.Close()
 	if err != nil {
 		log.Error().Err(err).Msg("Error during closing storage connection")
@@ -89,6 +101,31 @@ func closeConsumer(consumerInstance consumer.Consumer) {
 	}
 }
 
+// startMirror starts mirroring of consumed messages when it's enabled, nil is returned
+// when it's disabled or when it can't be started because mirroring must not affect the consumer
+func startMirror(brokerAddress string) *mirror.Mirror {
+	mirrorCfg := getMirrorConfiguration()
+	if !mirrorCfg.Enabled {
+		return nil
+	}
+
+	messageMirror, err := mirror.New(mirrorCfg, brokerAddress)
+	if err != nil {
+		log.Error().Err(err).Msg("Mirror of consumed messages initialization error, consuming without mirror")
+		return nil
+	}
+
+	return messageMirror
+}
+
+// closeMirror closes the mirror of consumed messages
+func closeMirror(messageMirror *mirror.Mirror) {
+	err := messageMirror.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("Error during closing mirror of consumed messages")
+	}
+}
+
 // prepareDB migrates the DB to the latest version
 // and loads all available rule content into it.
 func prepareDB() int {
@@ -106,15 +143,8 @@ func prepareDB() int {
 		return ExitStatusPrepareDbError
 	}
 
-	ruleContentDirPath := getContentPathConfiguration()
-	contentDir, err := content.ParseRuleContentDir(ruleContentDirPath)
-	if err != nil {
-		log.Error().Err(err).Msg("Rules parsing error")
-		return ExitStatusPrepareDbError
-	}
-
-	if err := dbStorage.LoadRuleContent(contentDir); err != nil {
-		log.Error().Err(err).Msg("Rules content loading error")
+	fetcher := localContentFetcher{path: getContentPathConfiguration()}
+	if err := updateRuleContent(fetcher, dbStorage, contentReloadTriggerStartup); err != nil {
 		return ExitStatusPrepareDbError
 	}
 
@@ -137,14 +167,30 @@ func startConsumer() int {
 		return ExitStatusOK
 	}
 
-	consumerInstance, err = consumer.New(brokerCfg, dbStorage)
+	kafkaConsumer, err := consumer.New(brokerCfg, dbStorage)
 	if err != nil {
 		log.Error().Err(err).Msg("Broker initialization error")
 		return ExitStatusConsumerError
 	}
+	consumerInstance = kafkaConsumer
 
 	defer closeConsumer(consumerInstance)
-	consumerInstance.Serve()
+
+	if consumerWatchdog != nil {
+		kafkaConsumer.Watchdog = consumerWatchdog
+	}
+
+	// the mirror is closed after the consumer, so all mirrored messages are written
+	if messageMirror := startMirror(brokerCfg.Address); messageMirror != nil {
+		defer closeMirror(messageMirror)
+		kafkaConsumer.Mirror = messageMirror
+	}
+
+	err = consumerInstance.Serve()
+	if err != nil {
+		log.Error().Err(err).Msg("Consumer stopped because of fatal error")
+		return ExitStatusConsumerError
+	}
 
 	return ExitStatusOK
 }
@@ -159,6 +205,9 @@ func startServer() int {
 
 	serverCfg := getServerConfiguration()
 	serverInstance = server.New(serverCfg, dbStorage)
+	if consumerWatchdog != nil {
+		serverInstance.Liveness = consumerWatchdog
+	}
 	err = serverInstance.Start()
 	if err != nil {
 		log.Error().Err(err).Msg("HTTP(s) start error")
@@ -179,6 +228,56 @@ func startService() int {
 		exitCode += prepDbExitCode
 	}
 
+	// cleanup of old reports and processing errors is run in background, but only if it's configured
+	cleanupCfg := getCleanupConfiguration()
+	if cleanupCfg.enabled() {
+		backgroundLoops.Register(func(ctx context.Context) {
+			startReportCleanup(ctx, cleanupCfg)
+		})
+	}
+
+	// consistency check of reports is run in background, but only if it's configured
+	consistencyCheckCfg := getConsistencyCheckConfiguration()
+	if consistencyCheckCfg.Interval > 0 {
+		backgroundLoops.Register(func(ctx context.Context) {
+			startConsistencyCheck(ctx, consistencyCheckCfg)
+		})
+	}
+
+	// number of stale clusters is refreshed in background, but only if it's configured
+	staleClustersCfg := getStaleClustersConfiguration()
+	if staleClustersCfg.Interval > 0 && staleClustersCfg.Threshold > 0 {
+		backgroundLoops.Register(func(ctx context.Context) {
+			startStaleClustersRefresh(ctx, staleClustersCfg)
+		})
+	}
+
+	// maintenance of the database is run in background, but only if it's configured
+	maintenanceCfg := getMaintenanceConfiguration()
+	if maintenanceCfg.Interval > 0 {
+		backgroundLoops.Register(func(ctx context.Context) {
+			startMaintenance(ctx, maintenanceCfg)
+		})
+	}
+
+	// numbers of rows of the database tables are collected in background, but only if it's configured
+	storageMetricsCfg := getStorageMetricsConfiguration()
+	if storageMetricsCfg.Interval > 0 {
+		backgroundLoops.Register(func(ctx context.Context) {
+			startStorageMetricsCollection(ctx, storageMetricsCfg)
+		})
+	}
+
+	// the watchdog of the consumer loop is monitored in background, but only if it's configured
+	if threshold := getConsumerLivenessThreshold(); threshold > 0 {
+		watchdog := consumer.NewWatchdog(threshold)
+		backgroundLoops.Register(func(ctx context.Context) {
+			runPeriodically(ctx, watchdog.Interval(), watchdog.Monitor)
+		})
+		consumerWatchdog = watchdog
+	}
+	backgroundLoops.Start()
+
 	waitGroup.Add(1)
 	// consumer is run in its own thread
 	go func() {
@@ -186,6 +285,9 @@ func startService() int {
 		if consumerExitCode != 0 {
 			log.Info().Msg(fmt.Sprintf(consumerExitedErrorMessage, prepDbExitCode))
 			exitCode += consumerExitCode
+			// consumer can't be initialized or it failed with fatal error,
+			// so the whole service has to exit with its error code
+			stopServer()
 		}
 
 		waitGroup.Done()
@@ -199,6 +301,7 @@ func startService() int {
 	}
 
 	waitGroup.Wait()
+	backgroundLoops.Stop()
 
 	return exitCode
 }
@@ -221,17 +324,24 @@ func waitForServiceToStart() {
 	}
 }
 
-func stopService() int {
-	errCode := 0
+// stopServer stops the server if it has been started, 1 is returned on error
+func stopServer() int {
+	if serverInstance == nil {
+		return 0
+	}
 
-	if serverInstance != nil {
-		err := serverInstance.Stop(context.TODO())
-		if err != nil {
-			log.Error().Err(err).Msg("HTTP(s) server stop error")
-			errCode++
-		}
+	err := serverInstance.Stop(context.TODO())
+	if err != nil {
+		log.Error().Err(err).Msg("HTTP(s) server stop error")
+		return 1
 	}
 
+	return 0
+}
+
+func stopService() int {
+	errCode := stopServer()
+
 	if consumerInstance != nil {
 		err := consumerInstance.Close()
 		if err != nil {
@@ -240,15 +350,44 @@ func stopService() int {
 		}
 	}
 
+	// all background loops exit before the service is considered stopped
+	backgroundLoops.Stop()
+
 	return errCode
 }
 
+// stopServiceOnSignal stops the service gracefully when SIGINT or SIGTERM is received
+func stopServiceOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		receivedSignal := <-signals
+		log.Info().Str("signal", receivedSignal.String()).Msg("Stopping the service")
+		stopService()
+	}()
+}
+
 func main() {
 	err := loadConfiguration(defaultConfigFilename)
 	if err != nil {
 		panic(err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == loadGeneratorCommand {
+		os.Exit(runLoadGenerator(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == importReportsCommand {
+		os.Exit(runImportReports(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == partitionReportsCommand {
+		os.Exit(runPartitionReports(os.Args[2:]))
+	}
+
+	stopServiceOnSignal()
+
 	errCode := startService()
 	if errCode != 0 {
 		os.Exit(errCode)
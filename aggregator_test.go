@@ -17,14 +17,31 @@ limitations under the License.
 package main_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	prom_models "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 
 	main "github.com/RedHatInsights/insights-results-aggregator"
+	"github.com/RedHatInsights/insights-results-aggregator/archive"
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
 	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 const (
@@ -55,3 +72,430 @@ func TestStartService(t *testing.T) {
 		assert.Equal(t, 0, errCode)
 	}, testsTimeout)
 }
+
+type fakeContentFetcher struct {
+	path string
+	err  error
+}
+
+func (fetcher fakeContentFetcher) FetchRuleContent() (string, error) {
+	return fetcher.path, fetcher.err
+}
+
+func getContentReloadObservations(t *testing.T, trigger, phase string) uint64 {
+	pb := &prom_models.Metric{}
+	err := metrics.ContentReloadDuration.WithLabelValues(trigger, phase).(prometheus.Metric).Write(pb)
+	helpers.FailOnError(t, err)
+
+	return pb.GetHistogram().GetSampleCount()
+}
+
+func getGaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	pb := &prom_models.Metric{}
+	helpers.FailOnError(t, gauge.Write(pb))
+
+	return pb.GetGauge().GetValue()
+}
+
+func TestUpdateRuleContentRecordsAllPhases(t *testing.T) {
+	const trigger = "test-all-phases"
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := main.UpdateRuleContent(fakeContentFetcher{path: "./tests/content/ok"}, mockStorage, trigger)
+	helpers.FailOnError(t, err)
+
+	for _, phase := range []string{"fetch", "parse", "load", "total"} {
+		assert.Equal(t, uint64(1), getContentReloadObservations(t, trigger, phase), phase)
+	}
+	assert.Equal(t, 1.0, getGaugeValue(t, metrics.ContentRulesLoaded))
+}
+
+func TestUpdateRuleContentFetchError(t *testing.T) {
+	const trigger = "test-fetch-error"
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	fetchErr := errors.New("fetch error")
+	err := main.UpdateRuleContent(fakeContentFetcher{err: fetchErr}, mockStorage, trigger)
+	assert.Equal(t, fetchErr, err)
+
+	assert.Equal(t, uint64(1), getContentReloadObservations(t, trigger, "fetch"))
+	assert.Equal(t, uint64(0), getContentReloadObservations(t, trigger, "parse"))
+	assert.Equal(t, uint64(0), getContentReloadObservations(t, trigger, "load"))
+	assert.Equal(t, uint64(1), getContentReloadObservations(t, trigger, "total"))
+}
+
+func TestUpdateRuleContentParseError(t *testing.T) {
+	const trigger = "test-parse-error"
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := main.UpdateRuleContent(fakeContentFetcher{path: "./tests/content/bad_plugin"}, mockStorage, trigger)
+	assert.Error(t, err)
+
+	assert.Equal(t, uint64(1), getContentReloadObservations(t, trigger, "fetch"))
+	assert.Equal(t, uint64(1), getContentReloadObservations(t, trigger, "parse"))
+	assert.Equal(t, uint64(0), getContentReloadObservations(t, trigger, "load"))
+}
+
+type fakeOldReportsCleaner struct {
+	deleted   int
+	err       error
+	olderThan time.Duration
+}
+
+func (cleaner *fakeOldReportsCleaner) CleanupOldReports(olderThan time.Duration) (int, error) {
+	cleaner.olderThan = olderThan
+	return cleaner.deleted, cleaner.err
+}
+
+func getCounterValue(t *testing.T, counter prometheus.Counter) float64 {
+	pb := &prom_models.Metric{}
+	helpers.FailOnError(t, counter.Write(pb))
+
+	return pb.GetCounter().GetValue()
+}
+
+func TestCleanupOldReports(t *testing.T) {
+	deletedBefore := getCounterValue(t, metrics.OldReportsDeleted)
+
+	cleaner := &fakeOldReportsCleaner{deleted: 3}
+	main.CleanupOldReports(cleaner, 24*time.Hour)
+
+	assert.Equal(t, 24*time.Hour, cleaner.olderThan)
+	assert.Equal(t, deletedBefore+3, getCounterValue(t, metrics.OldReportsDeleted))
+
+	cleaner = &fakeOldReportsCleaner{deleted: 5, err: errors.New("cleanup error")}
+	main.CleanupOldReports(cleaner, 24*time.Hour)
+
+	assert.Equal(t, deletedBefore+3, getCounterValue(t, metrics.OldReportsDeleted))
+}
+
+type fakeConsumerErrorsCleaner struct {
+	deleted   int
+	err       error
+	olderThan time.Duration
+}
+
+func (cleaner *fakeConsumerErrorsCleaner) CleanupConsumerErrors(olderThan time.Duration) (int, error) {
+	cleaner.olderThan = olderThan
+	return cleaner.deleted, cleaner.err
+}
+
+func TestCleanupConsumerErrors(t *testing.T) {
+	deletedBefore := getCounterValue(t, metrics.OldConsumerErrorsDeleted)
+
+	cleaner := &fakeConsumerErrorsCleaner{deleted: 2}
+	main.CleanupConsumerErrors(cleaner, 168*time.Hour)
+
+	assert.Equal(t, 168*time.Hour, cleaner.olderThan)
+	assert.Equal(t, deletedBefore+2, getCounterValue(t, metrics.OldConsumerErrorsDeleted))
+
+	cleaner = &fakeConsumerErrorsCleaner{deleted: 4, err: errors.New("cleanup error")}
+	main.CleanupConsumerErrors(cleaner, 168*time.Hour)
+
+	assert.Equal(t, deletedBefore+2, getCounterValue(t, metrics.OldConsumerErrorsDeleted))
+}
+
+// TestPurgeSoftDeletedReports checks that soft-deleted reports are purged only after the retention period
+// and that the metric is kept when the storage fails
+func TestPurgeSoftDeletedReports(t *testing.T) {
+	purgedBefore := getCounterValue(t, metrics.SoftDeletedReportsPurged)
+
+	mockStorage := storage.NewInMemory()
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	_, err = mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	// the report has been soft-deleted just now
+	main.PurgeSoftDeletedReports(mockStorage, time.Hour)
+	assert.Equal(t, purgedBefore, getCounterValue(t, metrics.SoftDeletedReportsPurged))
+
+	// negative retention purges also the reports soft-deleted just now
+	main.PurgeSoftDeletedReports(mockStorage, -time.Hour)
+	assert.Equal(t, purgedBefore+1, getCounterValue(t, metrics.SoftDeletedReportsPurged))
+
+	err = mockStorage.RestoreCluster(testdata.ClusterName)
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+}
+
+// TestRefreshStaleClusters checks that the metric counts only clusters not checked for longer than the threshold
+// and that it's kept when the storage fails
+func TestRefreshStaleClusters(t *testing.T) {
+	const oldClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+
+	main.RefreshStaleClusters(mockStorage, 24*time.Hour)
+	assert.Equal(t, 0.0, getGaugeValue(t, metrics.StaleClusters))
+
+	for clusterName, lastChecked := range map[types.ClusterName]time.Time{
+		testdata.ClusterName: time.Now(),
+		oldClusterName:       time.Now().Add(-48 * time.Hour),
+	} {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, clusterName, testdata.Report3Rules, lastChecked, types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+	}
+
+	main.RefreshStaleClusters(mockStorage, 24*time.Hour)
+	assert.Equal(t, 1.0, getGaugeValue(t, metrics.StaleClusters))
+
+	helpers.MustCloseStorage(t, mockStorage)
+
+	main.RefreshStaleClusters(mockStorage, 24*time.Hour)
+	assert.Equal(t, 1.0, getGaugeValue(t, metrics.StaleClusters))
+}
+
+// TestCollectStorageMetrics checks that numbers of rows of the tables are exposed
+// and that they're kept when the storage fails
+func TestCollectStorageMetrics(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	dbStorage := mockStorage.(*storage.DBStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	main.CollectStorageMetrics(dbStorage)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DBReportRows))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.DBFeedbackRows))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.DBRuleRows))
+
+	helpers.MustCloseStorage(t, mockStorage)
+
+	main.CollectStorageMetrics(dbStorage)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DBReportRows))
+}
+
+// fakeMaintenanceRunner returns the given error instead of maintenance of the database
+// and the given number of deleted orphaned feedbacks or the error of their deletion
+type fakeMaintenanceRunner struct {
+	err                 error
+	orphanedFeedback    int
+	orphanedFeedbackErr error
+}
+
+func (runner fakeMaintenanceRunner) CleanupOrphanedFeedback() (int, error) {
+	return runner.orphanedFeedback, runner.orphanedFeedbackErr
+}
+
+func (runner fakeMaintenanceRunner) RunMaintenance() error {
+	return runner.err
+}
+
+func getMaintenanceObservations(t *testing.T) uint64 {
+	pb := &prom_models.Metric{}
+	helpers.FailOnError(t, metrics.StorageMaintenanceDuration.(prometheus.Metric).Write(pb))
+
+	return pb.GetHistogram().GetSampleCount()
+}
+
+// TestRunMaintenance checks that results of maintenance runs are counted
+// and that durations of skipped runs are not observed
+func TestRunMaintenance(t *testing.T) {
+	for _, result := range []struct {
+		label    string
+		err      error
+		observed uint64
+	}{
+		{label: "success", err: nil, observed: 1},
+		{label: "error", err: errors.New("vacuum error"), observed: 1},
+		{label: "skipped", err: storage.ErrMaintenanceInProgress, observed: 0},
+	} {
+		runs := metrics.StorageMaintenanceRuns.WithLabelValues(result.label)
+		runsBefore := getCounterValue(t, runs)
+		observationsBefore := getMaintenanceObservations(t)
+
+		main.RunMaintenance(fakeMaintenanceRunner{err: result.err})
+
+		assert.Equal(t, runsBefore+1, getCounterValue(t, runs), result.label)
+		assert.Equal(t, observationsBefore+result.observed, getMaintenanceObservations(t), result.label)
+	}
+}
+
+// TestRunMaintenanceOrphanedFeedback checks that deleted orphaned feedback is counted
+// and that the maintenance runs even when the feedback can't be deleted
+func TestRunMaintenanceOrphanedFeedback(t *testing.T) {
+	runs := metrics.StorageMaintenanceRuns.WithLabelValues("success")
+	runsBefore := getCounterValue(t, runs)
+	deletedBefore := getCounterValue(t, metrics.OrphanedFeedbackDeleted)
+
+	main.RunMaintenance(fakeMaintenanceRunner{orphanedFeedback: 3})
+	assert.Equal(t, deletedBefore+3, getCounterValue(t, metrics.OrphanedFeedbackDeleted))
+
+	main.RunMaintenance(fakeMaintenanceRunner{orphanedFeedbackErr: errors.New("delete error")})
+	assert.Equal(t, deletedBefore+3, getCounterValue(t, metrics.OrphanedFeedbackDeleted))
+
+	assert.Equal(t, runsBefore+2, getCounterValue(t, runs))
+}
+
+// failingArchiver stores archives into the directory, but fails for archives with the given prefix
+type failingArchiver struct {
+	archive.FilesystemArchiver
+	failingPrefix string
+}
+
+func (archiver failingArchiver) Upload(name string, data io.Reader) error {
+	if strings.HasPrefix(name, archiver.failingPrefix) {
+		return errors.New("upload error")
+	}
+
+	return archiver.FilesystemArchiver.Upload(name, data)
+}
+
+// TestArchiveAndCleanupOldReportsPartialFailure checks that reports whose archive upload failed are kept
+func TestArchiveAndCleanupOldReportsPartialFailure(t *testing.T) {
+	const (
+		archivedOrgID   = types.OrgID(2)
+		archivedCluster = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+	)
+
+	dir, err := ioutil.TempDir("", "archive")
+	helpers.FailOnError(t, err)
+	defer func() {
+		helpers.FailOnError(t, os.RemoveAll(dir))
+	}()
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	oldTime := time.Now().Add(-48 * time.Hour)
+	helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, oldTime, types.UnknownKafkaOffset,
+	))
+	helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+		archivedOrgID, archivedCluster, testdata.Report0Rules, oldTime, types.UnknownKafkaOffset,
+	))
+
+	deletedBefore := getCounterValue(t, metrics.OldReportsDeleted)
+	errorsBefore := getCounterValue(t, metrics.OldReportsArchiveErrors)
+
+	archiver := failingArchiver{
+		FilesystemArchiver: archive.FilesystemArchiver{Directory: dir},
+		failingPrefix:      fmt.Sprint(testdata.OrgID) + "/",
+	}
+	main.ArchiveAndCleanupOldReports(mockStorage, archiver, 24*time.Hour)
+
+	assert.Equal(t, deletedBefore+1, getCounterValue(t, metrics.OldReportsDeleted))
+	assert.Equal(t, errorsBefore+1, getCounterValue(t, metrics.OldReportsArchiveErrors))
+
+	// report of the organization which failed to be archived is kept for the next run
+	existing, err := mockStorage.GetExistingClusters([]types.ClusterName{testdata.ClusterName, archivedCluster})
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{testdata.ClusterName}, existing)
+
+	archives, err := filepath.Glob(filepath.Join(dir, "*", "*.ndjson.gz"))
+	helpers.FailOnError(t, err)
+	assert.Len(t, archives, 1)
+	assert.Equal(t, fmt.Sprint(archivedOrgID), filepath.Base(filepath.Dir(archives[0])))
+}
+
+// fakeArchivingCleaner passes the reports and fails with the error afterwards
+type fakeArchivingCleaner struct {
+	reports []types.ArchivedReport
+	err     error
+}
+
+func (cleaner fakeArchivingCleaner) ForEachReportCheckedBefore(
+	_ time.Time, process func(types.ArchivedReport) error,
+) error {
+	for _, report := range cleaner.reports {
+		if err := process(report); err != nil {
+			return err
+		}
+	}
+
+	return cleaner.err
+}
+
+func (cleaner fakeArchivingCleaner) CleanupClustersCheckedBefore(time.Time, []types.ClusterName) (int, error) {
+	return 0, errors.New("no report should be deleted")
+}
+
+func TestArchiveAndCleanupOldReportsReadError(t *testing.T) {
+	errorsBefore := getCounterValue(t, metrics.OldReportsArchiveErrors)
+
+	main.ArchiveAndCleanupOldReports(
+		fakeArchivingCleaner{err: errors.New("read error")}, archive.FilesystemArchiver{}, 24*time.Hour,
+	)
+
+	assert.Equal(t, errorsBefore+1, getCounterValue(t, metrics.OldReportsArchiveErrors))
+}
+
+// TestArchiveAndCleanupOldReportsReadErrorDuringArchiving checks that no incomplete archive is stored
+// when reading of the reports fails while they are being archived
+func TestArchiveAndCleanupOldReportsReadErrorDuringArchiving(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	helpers.FailOnError(t, err)
+	defer func() {
+		helpers.FailOnError(t, os.RemoveAll(dir))
+	}()
+
+	errorsBefore := getCounterValue(t, metrics.OldReportsArchiveErrors)
+
+	cleaner := fakeArchivingCleaner{
+		reports: []types.ArchivedReport{{OrgID: testdata.OrgID, ClusterName: testdata.ClusterName}},
+		err:     errors.New("read error"),
+	}
+	main.ArchiveAndCleanupOldReports(cleaner, archive.FilesystemArchiver{Directory: dir}, 24*time.Hour)
+
+	assert.Equal(t, errorsBefore+1, getCounterValue(t, metrics.OldReportsArchiveErrors))
+
+	archives, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	helpers.FailOnError(t, err)
+	assert.Empty(t, archives)
+}
+
+// waitForNumberOfGoroutines waits a while for goroutines to finish and returns their number
+func waitForNumberOfGoroutines(expected int) int {
+	for i := 0; i < 50 && runtime.NumGoroutine() > expected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return runtime.NumGoroutine()
+}
+
+func TestLifecycleManagerNoGoroutineLeak(t *testing.T) {
+	goroutinesBefore := runtime.NumGoroutine()
+
+	manager := main.NewLifecycleManager()
+
+	var started sync.WaitGroup
+	stopped := make(chan struct{}, 3)
+
+	for i := 0; i < 3; i++ {
+		started.Add(1)
+		manager.Register(func(ctx context.Context) {
+			started.Done()
+			<-ctx.Done()
+			stopped <- struct{}{}
+		})
+	}
+
+	manager.Start()
+	// starting it again must not start the loops twice
+	manager.Start()
+	started.Wait()
+
+	manager.Stop()
+	// all loops have to be finished once Stop returns
+	assert.Len(t, stopped, 3)
+
+	assert.Equal(t, goroutinesBefore, waitForNumberOfGoroutines(goroutinesBefore))
+
+	// stopping stopped manager does nothing
+	manager.Stop()
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package archive contains archiving of old reports before they are deleted by the periodic cleanup.
+// Reports are archived as gzip compressed newline delimited JSON (NDJSON), one report per line.
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// Archiver uploads archives of old reports to a persistent storage
+type Archiver interface {
+	// Upload stores the data read from the reader as an archive with the given name, name parts
+	// are separated by slash. Nothing is stored when the data can't be read completely.
+	Upload(name string, data io.Reader) error
+}
+
+// FilesystemArchiver stores archives as files in the directory
+type FilesystemArchiver struct {
+	Directory string
+}
+
+// Upload writes the archive into the file, subdirectories are created when needed.
+// The data are written into a temporary file first, so an incomplete archive is never left behind.
+func (archiver FilesystemArchiver) Upload(name string, data io.Reader) error {
+	archivePath := filepath.Join(archiver.Directory, filepath.FromSlash(name))
+
+	err := os.MkdirAll(filepath.Dir(archivePath), 0750)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(archivePath), ".archive-")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), archivePath)
+}
+
+// ArchiveName returns name of the archive of organization's reports deleted at the given time
+func ArchiveName(orgID types.OrgID, deletedAt time.Time) string {
+	return path.Join(fmt.Sprint(orgID), deletedAt.UTC().Format("20060102T150405Z")+".ndjson.gz")
+}
+
+// ReportStream encodes reports into gzip compressed NDJSON and streams them to the archiver
+// while they're added, so the archive doesn't have to fit in memory
+type ReportStream struct {
+	pipe     *io.PipeWriter
+	gzip     *gzip.Writer
+	encoder  *json.Encoder
+	uploaded chan error
+}
+
+// NewReportStream starts upload of the archive with the given name by the archiver,
+// the upload is finished by Close or cancelled by Abort
+func NewReportStream(archiver Archiver, name string) *ReportStream {
+	reader, pipe := io.Pipe()
+
+	stream := &ReportStream{
+		pipe:     pipe,
+		gzip:     gzip.NewWriter(pipe),
+		uploaded: make(chan error, 1),
+	}
+	// Encode writes a newline after each report
+	stream.encoder = json.NewEncoder(stream.gzip)
+
+	go func() {
+		err := archiver.Upload(name, reader)
+		// reports not read by the archiver anymore fail to be added instead of blocking
+		_ = reader.CloseWithError(err)
+		stream.uploaded <- err
+	}()
+
+	return stream
+}
+
+// Add appends the report to the archive, an error is returned when the upload has failed already
+func (stream *ReportStream) Add(report types.ArchivedReport) error {
+	return stream.encoder.Encode(report)
+}
+
+// Close finishes the archive and waits until it's uploaded
+func (stream *ReportStream) Close() error {
+	err := stream.gzip.Close()
+	_ = stream.pipe.CloseWithError(err)
+
+	if uploadErr := <-stream.uploaded; uploadErr != nil {
+		return uploadErr
+	}
+
+	return err
+}
+
+// Abort cancels the upload, so no archive is stored, the reason is returned by the archiver's reads
+func (stream *ReportStream) Abort(reason error) {
+	_ = stream.pipe.CloseWithError(reason)
+	<-stream.uploaded
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/archive"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+var testReports = []types.ArchivedReport{
+	{
+		OrgID:         testdata.OrgID,
+		ClusterName:   testdata.ClusterName,
+		Report:        testdata.Report3Rules,
+		LastCheckedAt: types.NewOptionalTimestamp(testdata.LastCheckedAt),
+		History: []types.ReportHistoryEntry{
+			{Report: testdata.Report0Rules, LastCheckedAt: types.NewOptionalTimestamp(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))},
+		},
+	},
+	{
+		OrgID:         testdata.OrgID,
+		ClusterName:   "52ab955f-b769-444d-8170-4b676c5d3c85",
+		Report:        testdata.Report0Rules,
+		LastCheckedAt: types.NewOptionalTimestamp(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)),
+		History:       []types.ReportHistoryEntry{},
+	},
+}
+
+func mustTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	helpers.FailOnError(t, err)
+
+	return dir
+}
+
+// decodeReports decompresses the archive and decodes one report from each line
+func decodeReports(t *testing.T, data []byte) []types.ArchivedReport {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	helpers.FailOnError(t, err)
+
+	var reports []types.ArchivedReport
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+
+	for scanner.Scan() {
+		var report types.ArchivedReport
+		helpers.FailOnError(t, json.Unmarshal(scanner.Bytes(), &report))
+		reports = append(reports, report)
+	}
+	helpers.FailOnError(t, scanner.Err())
+
+	return reports
+}
+
+// memoryArchiver keeps uploaded archives in memory
+type memoryArchiver struct {
+	archives map[string][]byte
+}
+
+func (archiver *memoryArchiver) Upload(name string, data io.Reader) error {
+	archived, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	archiver.archives[name] = archived
+	return nil
+}
+
+// failingArchiver fails without reading the archive
+type failingArchiver struct{}
+
+func (failingArchiver) Upload(string, io.Reader) error {
+	return errors.New("upload error")
+}
+
+func TestReportStream(t *testing.T) {
+	archiver := &memoryArchiver{archives: make(map[string][]byte)}
+
+	stream := archive.NewReportStream(archiver, "1/archive.ndjson.gz")
+	for _, report := range testReports {
+		helpers.FailOnError(t, stream.Add(report))
+	}
+	helpers.FailOnError(t, stream.Close())
+
+	assert.Equal(t, testReports, decodeReports(t, archiver.archives["1/archive.ndjson.gz"]))
+}
+
+func TestReportStreamEmpty(t *testing.T) {
+	archiver := &memoryArchiver{archives: make(map[string][]byte)}
+
+	stream := archive.NewReportStream(archiver, "1/archive.ndjson.gz")
+	helpers.FailOnError(t, stream.Close())
+
+	assert.Empty(t, decodeReports(t, archiver.archives["1/archive.ndjson.gz"]))
+}
+
+func TestReportStreamUploadError(t *testing.T) {
+	stream := archive.NewReportStream(failingArchiver{}, "1/archive.ndjson.gz")
+
+	// gzip header is written with the first report, so the failed upload is noticed right away
+	assert.EqualError(t, stream.Add(testReports[0]), "upload error")
+
+	assert.EqualError(t, stream.Close(), "upload error")
+}
+
+func TestReportStreamAbort(t *testing.T) {
+	archiver := &memoryArchiver{archives: make(map[string][]byte)}
+
+	stream := archive.NewReportStream(archiver, "1/archive.ndjson.gz")
+	helpers.FailOnError(t, stream.Add(testReports[0]))
+	stream.Abort(errors.New("read error"))
+
+	assert.Empty(t, archiver.archives)
+}
+
+func TestArchiveName(t *testing.T) {
+	deletedAt := time.Date(2020, 4, 1, 12, 30, 45, 0, time.FixedZone("CEST", 2*60*60))
+
+	assert.Equal(t, "1/20200401T103045Z.ndjson.gz", archive.ArchiveName(1, deletedAt))
+}
+
+// encodeReports encodes the reports into an archive kept in memory
+func encodeReports(t *testing.T, reports []types.ArchivedReport) []byte {
+	archiver := &memoryArchiver{archives: make(map[string][]byte)}
+
+	stream := archive.NewReportStream(archiver, "archive")
+	for _, report := range reports {
+		helpers.FailOnError(t, stream.Add(report))
+	}
+	helpers.FailOnError(t, stream.Close())
+
+	return archiver.archives["archive"]
+}
+
+func TestFilesystemArchiverUpload(t *testing.T) {
+	dir := mustTempDir(t)
+	defer func() {
+		helpers.FailOnError(t, os.RemoveAll(dir))
+	}()
+
+	data := encodeReports(t, testReports)
+
+	archiver := archive.FilesystemArchiver{Directory: dir}
+	helpers.FailOnError(t, archiver.Upload("1/archive.ndjson.gz", bytes.NewReader(data)))
+
+	stored, err := ioutil.ReadFile(filepath.Join(dir, "1", "archive.ndjson.gz"))
+	helpers.FailOnError(t, err)
+	assert.Equal(t, data, stored)
+	assert.Equal(t, testReports, decodeReports(t, stored))
+
+	// no temporary file is left behind
+	files, err := ioutil.ReadDir(filepath.Join(dir, "1"))
+	helpers.FailOnError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestFilesystemArchiverUploadError(t *testing.T) {
+	dir := mustTempDir(t)
+	defer func() {
+		helpers.FailOnError(t, os.RemoveAll(dir))
+	}()
+
+	// the directory for the archive can't be created, because there is a file with the same name
+	helpers.FailOnError(t, ioutil.WriteFile(filepath.Join(dir, "1"), []byte{}, 0600))
+
+	archiver := archive.FilesystemArchiver{Directory: dir}
+	err := archiver.Upload("1/archive.ndjson.gz", strings.NewReader("data"))
+	assert.Error(t, err)
+}
+
+// TestFilesystemArchiverUploadReadError checks that no archive is left behind when the data can't be read
+func TestFilesystemArchiverUploadReadError(t *testing.T) {
+	dir := mustTempDir(t)
+	defer func() {
+		helpers.FailOnError(t, os.RemoveAll(dir))
+	}()
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte("partial data"))
+		_ = writer.CloseWithError(errors.New("read error"))
+	}()
+
+	archiver := archive.FilesystemArchiver{Directory: dir}
+	err := archiver.Upload("1/archive.ndjson.gz", reader)
+	assert.EqualError(t, err, "read error")
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "1"))
+	helpers.FailOnError(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,240 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3SigningAlgorithm = "AWS4-HMAC-SHA256"
+	s3Service          = "s3"
+	s3DateFormat       = "20060102"
+	s3TimeFormat       = "20060102T150405Z"
+
+	// maxS3ErrorLength is the maximal length of the response body reported as an error of the upload
+	maxS3ErrorLength = 1024
+)
+
+// S3Configuration represents configuration of S3 compatible object storage (AWS S3, MinIO, Ceph)
+// archives are uploaded to, objects are stored into the bucket under the optional prefix
+type S3Configuration struct {
+	Endpoint        string `mapstructure:"endpoint" toml:"endpoint"`
+	Region          string `mapstructure:"region" toml:"region"`
+	Bucket          string `mapstructure:"bucket" toml:"bucket"`
+	Prefix          string `mapstructure:"prefix" toml:"prefix"`
+	AccessKeyID     string `mapstructure:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key" toml:"secret_access_key"`
+}
+
+// S3Archiver stores archives as objects in S3 compatible object storage. Objects are addressed
+// path-style, i.e. <endpoint>/<bucket>/<prefix>/<name>, and requests are signed by AWS Signature Version 4.
+type S3Archiver struct {
+	S3Configuration
+	// Client sends the requests, http.DefaultClient is used when it's nil
+	Client *http.Client
+}
+
+// Upload stores the archive as an object. S3 requires length and checksum of the object
+// before its upload, so the data are spooled into a temporary file first instead of memory.
+func (archiver S3Archiver) Upload(name string, data io.Reader) error {
+	file, err := ioutil.TempFile("", "archive-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	checksum := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(file, checksum), data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPut, archiver.objectURL(name), ioutil.NopCloser(file))
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", "application/gzip")
+
+	archiver.signRequest(request, hex.EncodeToString(checksum.Sum(nil)), time.Now())
+
+	client := archiver.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxS3ErrorLength))
+		return fmt.Errorf("upload of archive %v failed with status %v: %s", name, response.Status, body)
+	}
+
+	return nil
+}
+
+// objectURL returns URL of the object of the archive with the given name
+func (archiver S3Archiver) objectURL(name string) string {
+	return strings.TrimSuffix(archiver.Endpoint, "/") + "/" + path.Join(archiver.Bucket, archiver.Prefix, name)
+}
+
+// signRequest adds x-amz-date, x-amz-content-sha256 and Authorization headers to the request
+// according to AWS Signature Version 4, all headers set on the request so far are signed
+func (archiver S3Archiver) signRequest(request *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	request.Header.Set("x-amz-date", now.Format(s3TimeFormat))
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalHeaders, signedHeaders := s3CanonicalHeaders(request)
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s3CanonicalURI(request.URL),
+		s3CanonicalQuery(request.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(s3DateFormat), archiver.Region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3SigningAlgorithm, now.Format(s3TimeFormat), scope, sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := []byte("AWS4" + archiver.SecretAccessKey)
+	for _, part := range []string{now.Format(s3DateFormat), archiver.Region, s3Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"%v Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s3SigningAlgorithm, archiver.AccessKeyID, scope, signedHeaders,
+		hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+}
+
+// s3CanonicalHeaders returns the canonical headers of the request including its host
+// and semicolon separated list of their names
+func s3CanonicalHeaders(request *http.Request) (string, string) {
+	host := request.Host
+	if host == "" {
+		host = request.URL.Host
+	}
+
+	values := map[string]string{"host": host}
+	for name, headerValues := range request.Header {
+		trimmed := make([]string, len(headerValues))
+		for i, value := range headerValues {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		values[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + values[name] + "\n")
+	}
+
+	return canonical.String(), strings.Join(names, ";")
+}
+
+// s3CanonicalURI returns the URI-encoded path of the URL, slashes are kept
+func s3CanonicalURI(u *url.URL) string {
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+
+	canonical := strings.Join(segments, "/")
+	if canonical == "" {
+		return "/"
+	}
+
+	return canonical
+}
+
+// s3CanonicalQuery returns the URI-encoded query parameters sorted by name
+func s3CanonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// s3Escape URI-encodes every byte except the unreserved characters
+func s3Escape(s string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
+
+func sha256Hex(s string) string {
+	checksum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(checksum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
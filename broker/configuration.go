@@ -17,15 +17,29 @@ limitations under the License.
 package broker
 
 import (
+	"time"
+
 	"github.com/deckarep/golang-set"
 )
 
 // Configuration represents configuration of Kafka broker
+//
+// MaxConsecutiveFailures - consumer stops with fatal error when this number of consecutive
+// messages can't be processed, 0 disables the check
+//
+// MaxTimeoutRetries - number of retries of storing the report when the storage operation
+// times out, 0 disables the retries
+//
+// LivenessThreshold - the consumer is considered stuck (and the liveness endpoint fails)
+// when it doesn't record a heartbeat for this time while messages are waiting, 0 disables the check
 type Configuration struct {
-	Address      string     `mapstructure:"address" toml:"address"`
-	Topic        string     `mapstructure:"topic" toml:"topic"`
-	PublishTopic string     `mapstructure:"publish_topic" toml:"publish_topic"`
-	Group        string     `mapstructure:"group" toml:"group"`
-	Enabled      bool       `mapstructure:"enabled" toml:"enabled"`
-	OrgWhitelist mapset.Set `mapstructure:"org_white_list" toml:"org_white_list"`
+	Address                string        `mapstructure:"address" toml:"address"`
+	Topic                  string        `mapstructure:"topic" toml:"topic"`
+	PublishTopic           string        `mapstructure:"publish_topic" toml:"publish_topic"`
+	Group                  string        `mapstructure:"group" toml:"group"`
+	Enabled                bool          `mapstructure:"enabled" toml:"enabled"`
+	OrgWhitelist           mapset.Set    `mapstructure:"org_white_list" toml:"org_white_list"`
+	MaxConsecutiveFailures int           `mapstructure:"max_consecutive_failures" toml:"max_consecutive_failures"`
+	MaxTimeoutRetries      int           `mapstructure:"max_timeout_retries" toml:"max_timeout_retries"`
+	LivenessThreshold      time.Duration `mapstructure:"liveness_threshold" toml:"liveness_threshold"`
 }
@@ -27,13 +27,16 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	mapset "github.com/deckarep/golang-set"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 
+	"github.com/RedHatInsights/insights-results-aggregator/archive"
 	"github.com/RedHatInsights/insights-results-aggregator/broker"
+	"github.com/RedHatInsights/insights-results-aggregator/mirror"
 	"github.com/RedHatInsights/insights-results-aggregator/server"
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
 	"github.com/RedHatInsights/insights-results-aggregator/types"
@@ -56,6 +59,64 @@ var config struct {
 	Content struct {
 		ContentPath string `mapstructure:"path" toml:"path"`
 	} `mapstructure:"content" toml:"content"`
+	Cleanup          cleanupConfiguration          `mapstructure:"cleanup" toml:"cleanup"`
+	ConsistencyCheck consistencyCheckConfiguration `mapstructure:"consistency_check" toml:"consistency_check"`
+	StaleClusters    staleClustersConfiguration    `mapstructure:"stale_clusters" toml:"stale_clusters"`
+	Maintenance      maintenanceConfiguration      `mapstructure:"maintenance" toml:"maintenance"`
+	StorageMetrics   storageMetricsConfiguration   `mapstructure:"storage_metrics" toml:"storage_metrics"`
+	Mirror           mirror.Configuration          `mapstructure:"mirror" toml:"mirror"`
+}
+
+// cleanupConfiguration represents configuration of periodic cleanup of old reports,
+// the cleanup is disabled when Interval or Retention is not set.
+// Old reports are archived into the bucket of ArchiveS3 object storage if it's set or into ArchiveDirectory
+// otherwise before their deletion.
+// Failures of processing of consumed messages older than ConsumerErrorRetention and reports
+// soft-deleted longer than SoftDeletedRetention ago are deleted by the same periodic cleanup
+// when they're set.
+type cleanupConfiguration struct {
+	Interval               time.Duration           `mapstructure:"interval" toml:"interval"`
+	Retention              time.Duration           `mapstructure:"retention" toml:"retention"`
+	ArchiveDirectory       string                  `mapstructure:"archive_directory" toml:"archive_directory"`
+	ArchiveS3              archive.S3Configuration `mapstructure:"archive_s3" toml:"archive_s3"`
+	ConsumerErrorRetention time.Duration           `mapstructure:"consumer_error_retention" toml:"consumer_error_retention"`
+	SoftDeletedRetention   time.Duration           `mapstructure:"soft_deleted_retention" toml:"soft_deleted_retention"`
+}
+
+// enabled checks whether the periodic cleanup has anything to delete
+func (cleanupCfg cleanupConfiguration) enabled() bool {
+	return cleanupCfg.Interval > 0 &&
+		(cleanupCfg.Retention > 0 || cleanupCfg.ConsumerErrorRetention > 0 || cleanupCfg.SoftDeletedRetention > 0)
+}
+
+// consistencyCheckConfiguration represents configuration of periodic check of consistency
+// of rule hits with reports, the check is disabled when Interval is not set.
+// Reports are checked by batches of BatchSize reports and the inconsistent ones are repaired
+// only when Repair is set.
+type consistencyCheckConfiguration struct {
+	Interval  time.Duration `mapstructure:"interval" toml:"interval"`
+	BatchSize int           `mapstructure:"batch_size" toml:"batch_size"`
+	Repair    bool          `mapstructure:"repair" toml:"repair"`
+}
+
+// staleClustersConfiguration represents configuration of periodic refresh of the number of clusters
+// whose reports were not checked for longer than Threshold, the refresh is disabled when Interval
+// or Threshold is not set.
+type staleClustersConfiguration struct {
+	Interval  time.Duration `mapstructure:"interval" toml:"interval"`
+	Threshold time.Duration `mapstructure:"threshold" toml:"threshold"`
+}
+
+// maintenanceConfiguration represents configuration of periodic maintenance of the database
+// (VACUUM and ANALYZE), the maintenance is disabled when Interval is not set.
+type maintenanceConfiguration struct {
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
+}
+
+// storageMetricsConfiguration represents configuration of periodic collection of numbers of rows
+// of the main tables of the database, the collection is disabled when Interval is not set.
+type storageMetricsConfiguration struct {
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
 }
 
 // loadConfiguration loads configuration from defaultConfigFile, file set in configFileEnvVariableName or from env
@@ -141,9 +202,57 @@ func getServerConfiguration() server.Configuration {
 		log.Fatal().Err(err).Msg("All customer facing APIs MUST serve the current OpenAPI specification")
 	}
 
+	if config.Server.ReportUpload {
+		config.Server.OrgWhitelist = getOrganizationWhitelist()
+	}
+
 	return config.Server
 }
 
+// getConsumerLivenessThreshold returns the time after which the consumer loop without heartbeats
+// is considered stuck, zero is returned when the broker or the check is disabled
+func getConsumerLivenessThreshold() time.Duration {
+	if !config.Broker.Enabled || config.Broker.LivenessThreshold <= 0 {
+		return 0
+	}
+
+	return config.Broker.LivenessThreshold
+}
+
+// getCleanupConfiguration returns configuration of periodic cleanup of old reports
+func getCleanupConfiguration() cleanupConfiguration {
+	return config.Cleanup
+}
+
+// getConsistencyCheckConfiguration returns configuration of periodic consistency check
+func getConsistencyCheckConfiguration() consistencyCheckConfiguration {
+	if config.ConsistencyCheck.BatchSize <= 0 {
+		config.ConsistencyCheck.BatchSize = storage.DefaultConsistencyCheckBatchSize
+	}
+
+	return config.ConsistencyCheck
+}
+
+// getStaleClustersConfiguration returns configuration of periodic refresh of stale clusters
+func getStaleClustersConfiguration() staleClustersConfiguration {
+	return config.StaleClusters
+}
+
+// getMaintenanceConfiguration returns configuration of periodic maintenance of the database
+func getMaintenanceConfiguration() maintenanceConfiguration {
+	return config.Maintenance
+}
+
+// getStorageMetricsConfiguration returns configuration of periodic collection of metrics of the database
+func getStorageMetricsConfiguration() storageMetricsConfiguration {
+	return config.StorageMetrics
+}
+
+// getMirrorConfiguration returns configuration of mirroring of consumed messages
+func getMirrorConfiguration() mirror.Configuration {
+	return config.Mirror
+}
+
 // getContentPathConfiguration get the path to the content files from the configuration
 func getContentPathConfiguration() string {
 	if len(config.Content.ContentPath) == 0 {
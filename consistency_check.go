@@ -0,0 +1,70 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Implementation of periodic consistency check of reports for aggregator
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+)
+
+// checkConsistency checks consistency of all reports, the check is interrupted
+// when the context is cancelled and it's skipped in legacy write mode
+func checkConsistency(
+	ctx context.Context, checker storage.ConsistencyChecker, checkCfg consistencyCheckConfiguration,
+) {
+	summary, err := storage.CheckConsistency(ctx, checker, checkCfg.BatchSize, checkCfg.Repair)
+	if errors.Is(err, storage.ErrConsistencyCheckInLegacyMode) {
+		log.Info().Msg("Consistency check of reports skipped, rule hits are not stored in legacy write mode")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Int("checked", summary.Checked).Msg("Consistency check of reports has not finished")
+		return
+	}
+
+	log.Info().
+		Int("checked", summary.Checked).
+		Int("issues", len(summary.Issues)).
+		Int("null_timestamps", summary.NullTimestamps).
+		Msg("Consistency check of reports finished")
+}
+
+// startConsistencyCheck opens the storage connection and runs periodic consistency check of reports
+// until the context is cancelled
+func startConsistencyCheck(ctx context.Context, checkCfg consistencyCheckConfiguration) {
+	dbStorage, err := startStorageConnection()
+	if err != nil {
+		log.Error().Err(err).Msg("Periodic consistency check of reports can't be started")
+		return
+	}
+	defer closeStorage(dbStorage)
+
+	log.Info().
+		Str("interval", checkCfg.Interval.String()).
+		Int("batch_size", checkCfg.BatchSize).
+		Bool("repair", checkCfg.Repair).
+		Msg("Periodic consistency check of reports has been started")
+
+	runPeriodically(ctx, checkCfg.Interval, func() {
+		checkConsistency(ctx, dbStorage, checkCfg)
+	})
+}
@@ -21,10 +21,14 @@ package consumer
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
+	mapset "github.com/deckarep/golang-set"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/RedHatInsights/insights-results-aggregator/broker"
@@ -46,24 +50,44 @@ const (
 	clusterKey = "cluster"
 )
 
+// MessageProcessor processes single messages consumed from any broker,
+// it's also used to inject messages without the broker, for example by the load generator
+type MessageProcessor interface {
+	ProcessMessage(msg *sarama.ConsumerMessage) error
+}
+
+// MessageMirror receives copies of all messages consumed from the broker,
+// it must never block the consumer
+type MessageMirror interface {
+	Send(msg *sarama.ConsumerMessage)
+}
+
 // Consumer represents any consumer of insights-rules messages
 type Consumer interface {
-	Serve()
+	MessageProcessor
+	Serve() error
 	Close() error
-	ProcessMessage(msg *sarama.ConsumerMessage) error
 }
 
-// KafkaConsumer in an implementation of Consumer interface
+// KafkaConsumer in an implementation of Consumer interface,
+// consumed messages are passed to Mirror before processing when it's set.
+// Heartbeats of the consumer loop are recorded by Watchdog when it's set.
+// latestStoredOffset is the highest offset of messages whose reports were stored.
+// nextOffset is the offset of the next message to be processed, it's accessed atomically.
 type KafkaConsumer struct {
 	Configuration                        broker.Configuration
 	Consumer                             sarama.Consumer
 	PartitionConsumer                    sarama.PartitionConsumer
-	Storage                              storage.Storage
+	Storage                              storage.ReportWriter
+	Mirror                               MessageMirror
+	Watchdog                             *Watchdog
 	numberOfSuccessfullyConsumedMessages uint64
 	numberOfErrorsConsumingMessages      uint64
 	offsetManager                        sarama.OffsetManager
 	partitionOffsetManager               sarama.PartitionOffsetManager
 	client                               sarama.Client
+	latestStoredOffset                   types.KafkaOffset
+	nextOffset                           int64
 }
 
 // Report represents report send in a message consumed from any broker
@@ -78,15 +102,36 @@ type incomingMessage struct {
 	LastChecked string `json:"LastChecked"`
 }
 
-// New constructs new implementation of Consumer interface
-func New(brokerCfg broker.Configuration, storage storage.Storage) (*KafkaConsumer, error) {
-	return NewWithSaramaConfig(brokerCfg, storage, nil, true)
+// StoredReport describes the report stored by ProcessReportMessage
+type StoredReport struct {
+	OrgID       types.OrgID       `json:"org_id"`
+	ClusterName types.ClusterName `json:"cluster"`
+	LastChecked types.Timestamp   `json:"last_checked_at"`
+}
+
+// ValidationError is returned by ProcessReportMessage when the message doesn't pass validation
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// New constructs new implementation of Consumer interface.
+// Any error returned means that the consumer can't be used at all.
+func New(brokerCfg broker.Configuration, storage storage.ReportWriter) (*KafkaConsumer, error) {
+	saramaConfig := sarama.NewConfig()
+	// errors are classified and handled by Serve
+	saramaConfig.Consumer.Return.Errors = true
+
+	return NewWithSaramaConfig(brokerCfg, storage, saramaConfig, true)
 }
 
 // NewWithSaramaConfig constructs new implementation of Consumer interface with custom sarama config
 func NewWithSaramaConfig(
 	brokerCfg broker.Configuration,
-	storage storage.Storage,
+	storage storage.ReportWriter,
 	saramaConfig *sarama.Config,
 	saveOffset bool,
 ) (*KafkaConsumer, error) {
@@ -138,7 +183,7 @@ func NewWithSaramaConfig(
 		return nil, err
 	}
 
-	return &KafkaConsumer{
+	kafkaConsumer := &KafkaConsumer{
 		Configuration:          brokerCfg,
 		Consumer:               consumer,
 		PartitionConsumer:      partitionConsumer,
@@ -146,7 +191,64 @@ func NewWithSaramaConfig(
 		offsetManager:          offsetManager,
 		partitionOffsetManager: partitionOffsetManager,
 		client:                 client,
-	}, nil
+	}
+	if nextOffset >= 0 {
+		kafkaConsumer.nextOffset = nextOffset
+	}
+	kafkaConsumer.logStoredOffsetLag(partitions[0])
+
+	return kafkaConsumer, nil
+}
+
+// logStoredOffsetLag logs how far behind the newest offset of the partition the latest offset
+// stored with reports is. Errors are only logged, because the lag is just informative.
+func (consumer *KafkaConsumer) logStoredOffsetLag(partition int32) {
+	storedOffset, err := consumer.Storage.GetLatestKafkaOffset()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read the latest Kafka offset stored with reports")
+		return
+	}
+
+	consumer.latestStoredOffset = storedOffset
+	metrics.LatestStoredKafkaOffset.Set(float64(storedOffset))
+
+	// the newest offset is offset of the next message produced into the partition
+	newestOffset, err := consumer.client.GetOffset(consumer.Configuration.Topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read the newest offset of the partition")
+		return
+	}
+
+	lag := newestOffset - 1 - int64(storedOffset)
+	if lag < 0 {
+		lag = 0
+	}
+
+	log.Info().
+		Str(topicKey, consumer.Configuration.Topic).
+		Int64("stored_offset", int64(storedOffset)).
+		Int64("newest_offset", newestOffset).
+		Int64("lag", lag).
+		Msg("Latest Kafka offset stored with reports")
+}
+
+// updateLatestStoredOffset remembers the offset of the message whose report was stored
+func (consumer *KafkaConsumer) updateLatestStoredOffset(offset types.KafkaOffset) {
+	if offset <= consumer.latestStoredOffset {
+		return
+	}
+
+	consumer.latestStoredOffset = offset
+	metrics.LatestStoredKafkaOffset.Set(float64(offset))
+}
+
+// NewMessageProcessor constructs processor of messages which validates them and stores their
+// reports into the storage in the same way as the consumer, but without connecting to the broker
+func NewMessageProcessor(brokerCfg broker.Configuration, storage storage.ReportWriter) MessageProcessor {
+	return &KafkaConsumer{
+		Configuration: brokerCfg,
+		Storage:       storage,
+	}
 }
 
 func getOffsetManagers(
@@ -193,35 +295,49 @@ func parseMessage(messageValue []byte) (incomingMessage, error) {
 		return deserialized, err
 	}
 
+	if err := checkMessageAttributes(deserialized); err != nil {
+		return deserialized, &invalidMessageError{Err: err}
+	}
+
+	return deserialized, nil
+}
+
+// checkMessageAttributes checks that all required attributes of the parsed message are set and valid
+func checkMessageAttributes(deserialized incomingMessage) error {
 	if deserialized.Organization == nil {
-		return deserialized, errors.New("missing required attribute 'OrgID'")
+		return errors.New("missing required attribute 'OrgID'")
+	}
+	if *deserialized.Organization == 0 {
+		return errors.New("attribute 'OrgID' has to be positive")
+	}
+	if *deserialized.Organization > types.MaxOrgID {
+		return fmt.Errorf("attribute 'OrgID' can't be higher than %v", types.MaxOrgID)
 	}
 	if deserialized.ClusterName == nil {
-		return deserialized, errors.New("missing required attribute 'ClusterName'")
+		return errors.New("missing required attribute 'ClusterName'")
 	}
 	if deserialized.Report == nil {
-		return deserialized, errors.New("missing required attribute 'Report'")
+		return errors.New("missing required attribute 'Report'")
 	}
 
-	_, err = uuid.Parse(string(*deserialized.ClusterName))
+	_, err := uuid.Parse(string(*deserialized.ClusterName))
 
 	if err != nil {
-		return deserialized, errors.New("cluster name is not a UUID")
+		return errors.New("cluster name is not a UUID")
 	}
 
 	err = checkReportStructure(*deserialized.Report)
 	if err != nil {
 		log.Print("Deserialized report read from message with improper structure:")
 		log.Print(*deserialized.Report)
-		return deserialized, err
+		return err
 	}
 
-	return deserialized, nil
+	return nil
 }
 
 // organizationAllowed checks whether the given organization is on whitelist or not
-func organizationAllowed(consumer *KafkaConsumer, orgID types.OrgID) bool {
-	whitelist := consumer.Configuration.OrgWhitelist
+func organizationAllowed(whitelist mapset.Set, orgID types.OrgID) bool {
 	if whitelist == nil {
 		return false
 	}
@@ -232,98 +348,227 @@ func organizationAllowed(consumer *KafkaConsumer, orgID types.OrgID) bool {
 }
 
 // Serve starts listening for messages and processing them. It blocks current thread
-func (consumer *KafkaConsumer) Serve() {
+// until the consumer is closed (nil is returned then) or until a fatal error occurs.
+// Errors of single messages are only counted, but the consumer fails with FatalError
+// when the number of consecutive failures reaches the configured limit. Transient errors,
+// like storage timeouts, are not counted as consecutive failures.
+func (consumer *KafkaConsumer) Serve() error {
 	log.Printf("Consumer has been started, waiting for messages send to topic %s", consumer.Configuration.Topic)
 
-	for msg := range consumer.PartitionConsumer.Messages() {
-		err := consumer.ProcessMessage(msg)
-		if err != nil {
+	consecutiveFailures := 0
+
+	// heartbeats are recorded periodically when there are no messages to process
+	var idleHeartbeats <-chan time.Time
+	if consumer.Watchdog != nil {
+		ticker := time.NewTicker(consumer.Watchdog.Interval())
+		defer ticker.Stop()
+		idleHeartbeats = ticker.C
+
+		consumer.Watchdog.assign(consumer.waitingMessages)
+		defer consumer.Watchdog.assign(nil)
+	}
+
+	for {
+		select {
+		case <-idleHeartbeats:
+			consumer.Watchdog.Heartbeat()
+		case msg, ok := <-consumer.PartitionConsumer.Messages():
+			if !ok {
+				return nil
+			}
+
+			if consumer.Mirror != nil {
+				consumer.Mirror.Send(msg)
+			}
+
+			// the message is waiting until it's processed
+			atomic.StoreInt64(&consumer.nextOffset, msg.Offset)
+			err := consumer.ProcessMessage(msg)
+			atomic.StoreInt64(&consumer.nextOffset, msg.Offset+1)
+			if consumer.Watchdog != nil {
+				consumer.Watchdog.Heartbeat()
+			}
+
+			if err == nil {
+				consumer.numberOfSuccessfullyConsumedMessages++
+				consecutiveFailures = 0
+				continue
+			}
+
 			log.Error().Err(err).Msg("Error processing message consumed from Kafka")
 			consumer.numberOfErrorsConsumingMessages++
-		} else {
-			consumer.numberOfSuccessfullyConsumedMessages++
+			if isTransientError(err) {
+				continue
+			}
+			consecutiveFailures++
+
+			if err := consumer.checkConsecutiveFailures(consecutiveFailures, err); err != nil {
+				return err
+			}
+		case consumerError, ok := <-consumer.PartitionConsumer.Errors():
+			if !ok {
+				return nil
+			}
+
+			log.Error().Err(consumerError.Err).Msg("Error consuming messages from Kafka")
+			if isFatalBrokerError(consumerError.Err) {
+				return &FatalError{Err: consumerError.Err}
+			}
+
+			consumer.numberOfErrorsConsumingMessages++
+			consecutiveFailures++
+
+			if err := consumer.checkConsecutiveFailures(consecutiveFailures, consumerError.Err); err != nil {
+				return err
+			}
 		}
 	}
 }
 
-func logMessageInfo(consumer *KafkaConsumer, originalMessage *sarama.ConsumerMessage, parsedMessage incomingMessage, event string) {
-	log.Info().
-		Int(offsetKey, int(originalMessage.Offset)).
-		Str(topicKey, consumer.Configuration.Topic).
+// waitingMessages returns number of messages in the assigned partition which were not processed yet
+func (consumer *KafkaConsumer) waitingMessages() int64 {
+	waiting := consumer.PartitionConsumer.HighWaterMarkOffset() - atomic.LoadInt64(&consumer.nextOffset)
+	if waiting < 0 {
+		return 0
+	}
+
+	return waiting
+}
+
+// checkConsecutiveFailures returns FatalError when number of consecutive failures
+// reached the limit set in configuration
+func (consumer *KafkaConsumer) checkConsecutiveFailures(consecutiveFailures int, lastErr error) error {
+	maxFailures := consumer.Configuration.MaxConsecutiveFailures
+	if maxFailures <= 0 || consecutiveFailures < maxFailures {
+		return nil
+	}
+
+	return &FatalError{
+		Err: fmt.Errorf("%v consecutive failures, the last one: %v", consecutiveFailures, lastErr),
+	}
+}
+
+func logMessageInfo(logger zerolog.Logger, parsedMessage incomingMessage, event string) {
+	logger.Info().
 		Int(organizationKey, int(*parsedMessage.Organization)).
 		Str(clusterKey, string(*parsedMessage.ClusterName)).
 		Msg(event)
 }
 
-func logUnparsedMessageError(consumer *KafkaConsumer, originalMessage *sarama.ConsumerMessage, event string, err error) {
-	log.Error().
-		Int(offsetKey, int(originalMessage.Offset)).
-		Str(topicKey, consumer.Configuration.Topic).
+func logUnparsedMessageError(logger zerolog.Logger, event string, err error) {
+	logger.Error().
 		Err(err).
 		Msg(event)
 }
 
-func logMessageError(consumer *KafkaConsumer, originalMessage *sarama.ConsumerMessage, parsedMessage incomingMessage, event string, err error) {
-	log.Error().
-		Int(offsetKey, int(originalMessage.Offset)).
-		Str(topicKey, consumer.Configuration.Topic).
+func logMessageError(logger zerolog.Logger, parsedMessage incomingMessage, event string, err error) {
+	logger.Error().
 		Int(organizationKey, int(*parsedMessage.Organization)).
 		Str(clusterKey, string(*parsedMessage.ClusterName)).
 		Err(err).
 		Msg(event)
 }
 
-// ProcessMessage processes an incoming message
-func (consumer *KafkaConsumer) ProcessMessage(msg *sarama.ConsumerMessage) error {
-	log.Info().Int(offsetKey, int(msg.Offset)).Str(topicKey, consumer.Configuration.Topic).Str(groupKey, consumer.Configuration.Group).Msg("Consumed")
-	message, err := parseMessage(msg.Value)
-	if err != nil {
-		logUnparsedMessageError(consumer, msg, "Error parsing message from Kafka", err)
-		return err
-	}
-	metrics.ConsumedMessages.Inc()
-
-	logMessageInfo(consumer, msg, message, "Read")
+// checkMessage checks that the parsed message can be stored and prepares the report
+// and the time of the last check for storing
+func checkMessage(
+	logger zerolog.Logger, whitelist mapset.Set, message incomingMessage,
+) (types.ClusterReport, time.Time, error) {
+	logMessageInfo(logger, message, "Read")
 
-	if ok := organizationAllowed(consumer, *message.Organization); !ok {
-		const cause = "organization ID is not whitelisted"
+	if ok := organizationAllowed(whitelist, *message.Organization); !ok {
 		// now we have all required information about the incoming message,
 		// the right time to record structured log entry
-		logMessageError(consumer, msg, message, cause, err)
-		return errors.New(cause)
+		logMessageError(logger, message, errOrganizationNotAllowed.Error(), errOrganizationNotAllowed)
+		return "", time.Time{}, errOrganizationNotAllowed
 	}
 
-	logMessageInfo(consumer, msg, message, "Organization whitelisted")
+	logMessageInfo(logger, message, "Organization whitelisted")
 
 	reportAsStr, err := json.Marshal(*message.Report)
 	if err != nil {
-		logMessageError(consumer, msg, message, "Error marshalling report", err)
-		return err
+		logMessageError(logger, message, "Error marshalling report", err)
+		return "", time.Time{}, err
 	}
 
-	logMessageInfo(consumer, msg, message, "Marshalled")
+	logMessageInfo(logger, message, "Marshalled")
 
 	lastCheckedTime, err := time.Parse(time.RFC3339Nano, message.LastChecked)
 	if err != nil {
-		logMessageError(consumer, msg, message, "Error parsing date from message", err)
-		return err
+		logMessageError(logger, message, "Error parsing date from message", err)
+		return "", time.Time{}, err
 	}
 
-	logMessageInfo(consumer, msg, message, "Time ok")
+	logMessageInfo(logger, message, "Time ok")
+
+	return types.ClusterReport(reportAsStr), lastCheckedTime, nil
+}
 
-	err = consumer.Storage.WriteReportForCluster(
+// storeReport writes the checked report into the storage, the report consumed from an already
+// processed Kafka message is not written, but it's not considered to be an error
+func storeReport(
+	logger zerolog.Logger,
+	dbStorage storage.ReportWriter,
+	message incomingMessage,
+	report types.ClusterReport,
+	lastCheckedTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	err := dbStorage.WriteReportForCluster(
 		*message.Organization,
 		*message.ClusterName,
-		types.ClusterReport(reportAsStr),
+		report,
 		lastCheckedTime,
+		kafkaOffset,
 	)
+	if err == storage.ErrOldReport {
+		logMessageInfo(logger, message, "Report from the same or newer offset already stored, skipped")
+		return nil
+	}
+	if _, ok := err.(*storage.InvalidReportError); ok {
+		logMessageError(logger, message, "Invalid report, not stored", err)
+		return err
+	}
 	if err != nil {
-		logMessageError(consumer, msg, message, "Error writing report to database", err)
+		logMessageError(logger, message, "Error writing report to database", err)
+		return err
+	}
+	logMessageInfo(logger, message, "Stored")
+
+	return nil
+}
+
+// ProcessMessage processes an incoming message
+func (consumer *KafkaConsumer) ProcessMessage(msg *sarama.ConsumerMessage) error {
+	log.Info().Int(offsetKey, int(msg.Offset)).Str(topicKey, consumer.Configuration.Topic).Str(groupKey, consumer.Configuration.Group).Msg("Consumed")
+	logger := log.With().Int(offsetKey, int(msg.Offset)).Str(topicKey, consumer.Configuration.Topic).Logger()
+
+	message, err := parseMessage(msg.Value)
+	if err != nil {
+		logUnparsedMessageError(logger, "Error parsing message from Kafka", err)
+		consumer.writeConsumerError(logger, msg, message, err)
+		return err
+	}
+	metrics.ConsumedMessages.Inc()
+
+	report, lastCheckedTime, err := checkMessage(logger, consumer.Configuration.OrgWhitelist, message)
+	if err == errOrganizationNotAllowed {
+		// messages of other organizations are not processed by this instance at all
+		return err
+	}
+	if err != nil {
+		consumer.writeConsumerError(logger, msg, message, err)
+		return err
+	}
+
+	err = consumer.storeReportWithRetries(logger, message, report, lastCheckedTime, types.KafkaOffset(msg.Offset))
+	if err != nil {
+		consumer.writeConsumerError(logger, msg, message, err)
 		return err
 	}
-	logMessageInfo(consumer, msg, message, "Stored")
 
 	// message has been parsed and stored into storage
+	consumer.updateLatestStoredOffset(types.KafkaOffset(msg.Offset))
 
 	// remember offset
 	if consumer.partitionOffsetManager != nil {
@@ -333,6 +578,86 @@ func (consumer *KafkaConsumer) ProcessMessage(msg *sarama.ConsumerMessage) error
 	return nil
 }
 
+// writeConsumerError stores the failure of processing of the message, so it can be shown
+// to the owner of the cluster. Organization and cluster are stored only when they could be
+// read from the message, the message itself is stored for debugging (truncated by the storage).
+// Failures of storing the error are only logged.
+func (consumer *KafkaConsumer) writeConsumerError(
+	logger zerolog.Logger, msg *sarama.ConsumerMessage, message incomingMessage, processingErr error,
+) {
+	consumerError := storage.ConsumerError{
+		Topic:      consumer.Configuration.Topic,
+		Partition:  msg.Partition,
+		Offset:     types.KafkaOffset(msg.Offset),
+		ConsumedAt: time.Now(),
+		Category:   processingErrorCategory(processingErr),
+		Error:      processingErr.Error(),
+		Key:        string(msg.Key),
+		Message:    string(msg.Value),
+	}
+	if message.Organization != nil {
+		consumerError.OrgID = *message.Organization
+	}
+	if message.ClusterName != nil {
+		if _, err := uuid.Parse(string(*message.ClusterName)); err == nil {
+			consumerError.ClusterName = *message.ClusterName
+		}
+	}
+
+	if err := consumer.Storage.WriteConsumerError(consumerError); err != nil {
+		logger.Error().Err(err).Msg("Unable to store the error of message processing")
+	}
+}
+
+// storeReportWithRetries stores the report and retries storing it at most MaxTimeoutRetries times
+// when the storage operation fails because of a transient error like timeout
+func (consumer *KafkaConsumer) storeReportWithRetries(
+	logger zerolog.Logger,
+	message incomingMessage,
+	report types.ClusterReport,
+	lastCheckedTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	err := storeReport(logger, consumer.Storage, message, report, lastCheckedTime, kafkaOffset)
+
+	for retry := 1; retry <= consumer.Configuration.MaxTimeoutRetries && isTransientError(err); retry++ {
+		logger.Warn().Int("retry", retry).Msg("Retrying to store the report")
+		err = storeReport(logger, consumer.Storage, message, report, lastCheckedTime, kafkaOffset)
+	}
+
+	return err
+}
+
+// ProcessReportMessage processes the message in the same format as messages consumed
+// from the broker (for example uploaded over REST API) by the same validation and
+// processing as consumer uses and writes the report into the storage.
+// ValidationError is returned when the message doesn't pass validation.
+func ProcessReportMessage(
+	dbStorage storage.ReportWriter, whitelist mapset.Set, messageValue []byte, logger zerolog.Logger,
+) (StoredReport, error) {
+	message, err := parseMessage(messageValue)
+	if err != nil {
+		logUnparsedMessageError(logger, "Error parsing report message", err)
+		return StoredReport{}, &ValidationError{Err: err}
+	}
+
+	report, lastCheckedTime, err := checkMessage(logger, whitelist, message)
+	if err != nil {
+		return StoredReport{}, &ValidationError{Err: err}
+	}
+
+	err = storeReport(logger, dbStorage, message, report, lastCheckedTime, types.UnknownKafkaOffset)
+	if err != nil {
+		return StoredReport{}, err
+	}
+
+	return StoredReport{
+		OrgID:       *message.Organization,
+		ClusterName: *message.ClusterName,
+		LastChecked: types.NewTimestamp(lastCheckedTime),
+	}, nil
+}
+
 // Close method closes all resources used by consumer
 func (consumer *KafkaConsumer) Close() error {
 	err := consumer.PartitionConsumer.Close()
@@ -30,6 +30,7 @@ import (
 
 	"github.com/RedHatInsights/insights-results-aggregator/broker"
 	"github.com/RedHatInsights/insights-results-aggregator/consumer"
+	"github.com/RedHatInsights/insights-results-aggregator/mirror"
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
 	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
 	"github.com/RedHatInsights/insights-results-aggregator/types"
@@ -113,6 +114,60 @@ func TestParseProperMessageWrongClusterName(t *testing.T) {
 	assert.EqualError(t, err, "cluster name is not a UUID")
 }
 
+func TestParseMessageOrgIDAbove31Bits(t *testing.T) {
+	message := `{
+		"OrgID": 3000000000,
+		"ClusterName": "` + string(testdata.ClusterName) + `",
+		"Report": ` + testdata.ConsumerReport + `
+	}`
+	parsed, err := consumer.ParseMessage([]byte(message))
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.OrgID(3000000000), *parsed.Organization)
+}
+
+func TestParseMessageOrgIDAbove32Bits(t *testing.T) {
+	message := `{
+		"OrgID": 9223372036854775807,
+		"ClusterName": "` + string(testdata.ClusterName) + `",
+		"Report": ` + testdata.ConsumerReport + `
+	}`
+	parsed, err := consumer.ParseMessage([]byte(message))
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.MaxOrgID, *parsed.Organization)
+}
+
+func TestParseMessageOrgIDAboveMax(t *testing.T) {
+	message := `{
+		"OrgID": 9223372036854775808,
+		"ClusterName": "` + string(testdata.ClusterName) + `",
+		"Report": ` + testdata.ConsumerReport + `
+	}`
+	_, err := consumer.ParseMessage([]byte(message))
+	assert.EqualError(t, err, "attribute 'OrgID' can't be higher than 9223372036854775807")
+}
+
+func TestParseMessageOrgIDOutOfRange(t *testing.T) {
+	for _, orgID := range []string{"-1", "18446744073709551616"} {
+		message := `{
+			"OrgID": ` + orgID + `,
+			"ClusterName": "` + string(testdata.ClusterName) + `",
+			"Report": ` + testdata.ConsumerReport + `
+		}`
+		_, err := consumer.ParseMessage([]byte(message))
+		assert.Error(t, err, "OrgID %v has to be rejected", orgID)
+	}
+}
+
+func TestParseMessageZeroOrgID(t *testing.T) {
+	message := `{
+		"OrgID": 0,
+		"ClusterName": "` + string(testdata.ClusterName) + `",
+		"Report": ` + testdata.ConsumerReport + `
+	}`
+	_, err := consumer.ParseMessage([]byte(message))
+	assert.EqualError(t, err, "attribute 'OrgID' has to be positive")
+}
+
 func TestParseMessageWithoutOrgID(t *testing.T) {
 	message := `{
 		"ClusterName": "` + string(testdata.ClusterName) + `",
@@ -219,6 +274,15 @@ func TestProcessCorrectMessage(t *testing.T) {
 	assert.Equal(t, 1, count)
 }
 
+// TestProcessCorrectMessageNoopStorage checks that consumer processes messages when running against noop storage
+func TestProcessCorrectMessageNoopStorage(t *testing.T) {
+	c := dummyConsumer(storage.NewNoopStorage(), true)
+
+	message := sarama.ConsumerMessage{}
+	message.Value = []byte(testdata.ConsumerMessage)
+	helpers.FailOnError(t, c.ProcessMessage(&message))
+}
+
 func consumerProcessMessage(mockConsumer consumer.Consumer, message string) error {
 	saramaMessage := sarama.ConsumerMessage{}
 	saramaMessage.Value = []byte(message)
@@ -256,6 +320,60 @@ func TestProcessingMessageWithWrongDateFormat(t *testing.T) {
 	}
 }
 
+// TestProcessingMessageStoresProcessingError checks that failures of processing are stored
+// for the cluster read from the message together with the category of the error
+func TestProcessingMessageStoresProcessingError(t *testing.T) {
+	for _, testCase := range []struct {
+		name             string
+		whitelist        bool
+		messageValue     string
+		expectedCategory string
+	}{
+		{"malformed message", true, `{
+			"OrgID": "not a number",
+			"ClusterName": "` + string(testdata.ClusterName) + `",
+			"Report": ` + testdata.ConsumerReport + `
+		}`, consumer.ProcessingErrorMalformedMessage},
+		{"missing report", true, `{
+			"OrgID": ` + fmt.Sprint(testdata.OrgID) + `,
+			"ClusterName": "` + string(testdata.ClusterName) + `"
+		}`, consumer.ProcessingErrorInvalidReport},
+		{"wrong date format", true, `{
+			"OrgID": ` + fmt.Sprint(testdata.OrgID) + `,
+			"ClusterName": "` + string(testdata.ClusterName) + `",
+			"Report": ` + testdata.ConsumerReport + `,
+			"LastChecked": "2020.01.23 16:15:59"
+		}`, consumer.ProcessingErrorInvalidTimestamp},
+		// messages of organizations which are not whitelisted are not recorded
+		{"organization not whitelisted", false, testdata.ConsumerMessage, ""},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			mockStorage := helpers.MustGetMockStorage(t, true)
+			defer helpers.MustCloseStorage(t, mockStorage)
+
+			err := consumerProcessMessage(dummyConsumer(mockStorage, testCase.whitelist), testCase.messageValue)
+			assert.Error(t, err)
+
+			processingErrors, err := mockStorage.GetProcessingErrorsForCluster(testdata.ClusterName, 10)
+			helpers.FailOnError(t, err)
+
+			if testCase.expectedCategory == "" {
+				assert.Empty(t, processingErrors)
+				return
+			}
+
+			assert.Len(t, processingErrors, 1)
+			assert.Equal(t, testCase.expectedCategory, processingErrors[0].Category)
+
+			// the message itself is kept for debugging
+			consumerErrors, err := mockStorage.ListConsumerErrors(10)
+			helpers.FailOnError(t, err)
+			assert.Len(t, consumerErrors, 1)
+			assert.Equal(t, testCase.messageValue, consumerErrors[0].Message)
+		})
+	}
+}
+
 func TestKafkaConsumerMockOK(t *testing.T) {
 	helpers.RunTestWithTimeout(t, func(t *testing.T) {
 		mockConsumer := helpers.MustGetMockKafkaConsumerWithExpectedMessages(
@@ -306,7 +424,7 @@ func TestKafkaConsumerMockWritingToClosedStorage(t *testing.T) {
 			t, testTopicName, testOrgWhiteList, []string{testdata.ConsumerMessage},
 		)
 
-		err := mockConsumer.Storage.Close()
+		err := mockConsumer.Storage.(storage.Storage).Close()
 		helpers.FailOnError(t, err)
 
 		go mockConsumer.Serve()
@@ -422,3 +540,456 @@ func TestKafkaConsumer_Close_MockBrokerError(t *testing.T) {
 		assert.EqualError(t, err, "kafka: tried to use a client that was closed")
 	}, testCaseTimeLimit)
 }
+
+// fakeConsumerEvent is either message or error produced by fakePartitionConsumer
+type fakeConsumerEvent struct {
+	message string
+	err     error
+}
+
+// fakePartitionConsumer is a message source producing given events one by one in order,
+// with the high water mark offset set by the test
+type fakePartitionConsumer struct {
+	messages      chan *sarama.ConsumerMessage
+	errors        chan *sarama.ConsumerError
+	done          chan struct{}
+	highWaterMark int64
+}
+
+func newFakePartitionConsumer(events []fakeConsumerEvent) *fakePartitionConsumer {
+	partitionConsumer := &fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan *sarama.ConsumerError),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(partitionConsumer.messages)
+		defer close(partitionConsumer.errors)
+
+		for offset, event := range events {
+			if event.err != nil {
+				select {
+				case partitionConsumer.errors <- &sarama.ConsumerError{Topic: testTopicName, Err: event.err}:
+				case <-partitionConsumer.done:
+					return
+				}
+				continue
+			}
+
+			message := &sarama.ConsumerMessage{Offset: int64(offset), Value: []byte(event.message)}
+			select {
+			case partitionConsumer.messages <- message:
+			case <-partitionConsumer.done:
+				return
+			}
+		}
+	}()
+
+	return partitionConsumer
+}
+
+func (partitionConsumer *fakePartitionConsumer) AsyncClose() {
+	close(partitionConsumer.done)
+}
+
+func (partitionConsumer *fakePartitionConsumer) Close() error {
+	partitionConsumer.AsyncClose()
+	return nil
+}
+
+func (partitionConsumer *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return partitionConsumer.messages
+}
+
+func (partitionConsumer *fakePartitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	return partitionConsumer.errors
+}
+
+func (partitionConsumer *fakePartitionConsumer) HighWaterMarkOffset() int64 {
+	return partitionConsumer.highWaterMark
+}
+
+// serveFakeEvents runs Serve of the consumer reading the events and returns its result
+func serveFakeEvents(
+	t *testing.T, maxConsecutiveFailures int, events []fakeConsumerEvent,
+) (*consumer.KafkaConsumer, error) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	return serveFakeEventsWithStorage(t, mockStorage, maxConsecutiveFailures, events)
+}
+
+// serveFakeEventsWithStorage runs Serve of the consumer using the storage reading the events
+// and returns its result
+func serveFakeEventsWithStorage(
+	t *testing.T, mockStorage storage.Storage, maxConsecutiveFailures int, events []fakeConsumerEvent,
+) (*consumer.KafkaConsumer, error) {
+	partitionConsumer := newFakePartitionConsumer(events)
+	defer func() {
+		helpers.FailOnError(t, partitionConsumer.Close())
+	}()
+
+	mockConsumer := dummyConsumer(mockStorage, true).(*consumer.KafkaConsumer)
+	mockConsumer.PartitionConsumer = partitionConsumer
+	mockConsumer.Configuration.MaxConsecutiveFailures = maxConsecutiveFailures
+
+	return mockConsumer, mockConsumer.Serve()
+}
+
+func TestKafkaConsumerServeMessageErrorsAreNotFatal(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		mockConsumer, err := serveFakeEvents(t, 0, []fakeConsumerEvent{
+			{message: "bad message"},
+			{message: "bad message"},
+			{message: testdata.ConsumerMessage},
+			{err: sarama.ErrNotLeaderForPartition},
+			{message: "bad message"},
+		})
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, uint64(1), mockConsumer.GetNumberOfSuccessfullyConsumedMessages())
+		assert.Equal(t, uint64(4), mockConsumer.GetNumberOfErrorsConsumingMessages())
+	}, testCaseTimeLimit)
+}
+
+func TestKafkaConsumerServeTooManyConsecutiveFailures(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		mockConsumer, err := serveFakeEvents(t, 2, []fakeConsumerEvent{
+			{message: "bad message"},
+			// successfully processed message resets the counter
+			{message: testdata.ConsumerMessage},
+			{err: sarama.ErrNotLeaderForPartition},
+			{message: "bad message"},
+			{message: testdata.ConsumerMessage},
+		})
+		assert.True(t, consumer.IsFatalError(err), "expected fatal error, got %+v", err)
+		assert.EqualError(
+			t, err, "2 consecutive failures, the last one: invalid character 'b' looking for beginning of value",
+		)
+
+		assert.Equal(t, uint64(1), mockConsumer.GetNumberOfSuccessfullyConsumedMessages())
+		assert.Equal(t, uint64(3), mockConsumer.GetNumberOfErrorsConsumingMessages())
+	}, testCaseTimeLimit)
+}
+
+// timeoutStorage is a storage in which writing of reports always times out
+type timeoutStorage struct {
+	storage.Storage
+}
+
+func (timeoutStorage) WriteReportForCluster(
+	types.OrgID, types.ClusterName, types.ClusterReport, time.Time, types.KafkaOffset,
+) error {
+	return &storage.QueryTimeoutError{Operation: "WriteReportForCluster", Timeout: time.Second}
+}
+
+func TestKafkaConsumerServeStorageTimeoutsAreNotConsecutiveFailures(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		mockStorage := helpers.MustGetMockStorage(t, true)
+		defer helpers.MustCloseStorage(t, mockStorage)
+
+		mockConsumer, err := serveFakeEventsWithStorage(t, timeoutStorage{mockStorage}, 2, []fakeConsumerEvent{
+			{message: testdata.ConsumerMessage},
+			{message: testdata.ConsumerMessage},
+			{message: testdata.ConsumerMessage},
+		})
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, uint64(0), mockConsumer.GetNumberOfSuccessfullyConsumedMessages())
+		assert.Equal(t, uint64(3), mockConsumer.GetNumberOfErrorsConsumingMessages())
+	}, testCaseTimeLimit)
+}
+
+// flakyTimeoutStorage is a storage in which the first timeouts writes of reports time out
+type flakyTimeoutStorage struct {
+	storage.Storage
+	timeouts int
+	writes   int
+}
+
+func (s *flakyTimeoutStorage) WriteReportForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	s.writes++
+	if s.writes <= s.timeouts {
+		return &storage.QueryTimeoutError{Operation: "WriteReportForCluster", Timeout: time.Second}
+	}
+
+	return s.Storage.WriteReportForCluster(orgID, clusterName, report, lastChecked, kafkaOffset)
+}
+
+func TestKafkaConsumerProcessMessageRetriesStorageTimeouts(t *testing.T) {
+	for _, testCase := range []struct {
+		name           string
+		maxRetries     int
+		expectedWrites int
+		expectedErr    bool
+	}{
+		{"retries are enough", 2, 3, false},
+		{"retries are exhausted", 1, 2, true},
+		{"retries are disabled", 0, 1, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			mockStorage := helpers.MustGetMockStorage(t, true)
+			defer helpers.MustCloseStorage(t, mockStorage)
+
+			flakyStorage := &flakyTimeoutStorage{Storage: mockStorage, timeouts: 2}
+
+			mockConsumer := dummyConsumer(flakyStorage, true).(*consumer.KafkaConsumer)
+			mockConsumer.Configuration.MaxTimeoutRetries = testCase.maxRetries
+
+			err := consumerProcessMessage(mockConsumer, testdata.ConsumerMessage)
+			if testCase.expectedErr {
+				assert.IsType(t, &storage.QueryTimeoutError{}, err)
+			} else {
+				helpers.FailOnError(t, err)
+			}
+			assert.Equal(t, testCase.expectedWrites, flakyStorage.writes)
+		})
+	}
+}
+
+// countingStorage is a storage counting reports actually written
+type countingStorage struct {
+	storage.Storage
+	writes int
+}
+
+func (s *countingStorage) WriteReportForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	err := s.Storage.WriteReportForCluster(orgID, clusterName, report, lastChecked, kafkaOffset)
+	if err == nil {
+		s.writes++
+	}
+
+	return err
+}
+
+func TestKafkaConsumerProcessMessageReplayedOffsets(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	countingStorage := &countingStorage{Storage: mockStorage}
+	mockConsumer := dummyConsumer(countingStorage, true)
+
+	for _, offset := range []int64{5, 6, 5} {
+		// the replayed message is ignored without an error
+		err := mockConsumer.ProcessMessage(&sarama.ConsumerMessage{
+			Topic:  testTopicName,
+			Offset: offset,
+			Value:  []byte(testdata.ConsumerMessage),
+		})
+		helpers.FailOnError(t, err)
+	}
+
+	assert.Equal(t, 2, countingStorage.writes)
+}
+
+func TestKafkaConsumerServeFatalBrokerError(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		mockConsumer, err := serveFakeEvents(t, 0, []fakeConsumerEvent{
+			{message: testdata.ConsumerMessage},
+			{err: sarama.ErrSASLAuthenticationFailed},
+			{message: testdata.ConsumerMessage},
+		})
+		assert.True(t, consumer.IsFatalError(err), "expected fatal error, got %+v", err)
+		assert.EqualError(t, err, sarama.ErrSASLAuthenticationFailed.Error())
+
+		assert.Equal(t, uint64(1), mockConsumer.GetNumberOfSuccessfullyConsumedMessages())
+		assert.Equal(t, uint64(0), mockConsumer.GetNumberOfErrorsConsumingMessages())
+	}, testCaseTimeLimit)
+}
+
+// blockedMirrorTarget is a mirror target whose writes are blocked until release is closed
+type blockedMirrorTarget struct {
+	release chan struct{}
+	written int
+}
+
+func (target *blockedMirrorTarget) Write(mirror.Message) error {
+	<-target.release
+	target.written++
+	return nil
+}
+
+func (target *blockedMirrorTarget) Close() error {
+	return nil
+}
+
+func TestKafkaConsumerServeNotBlockedByMirror(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		mockStorage := helpers.MustGetMockStorage(t, true)
+		defer helpers.MustCloseStorage(t, mockStorage)
+
+		partitionConsumer := newFakePartitionConsumer([]fakeConsumerEvent{
+			{message: testdata.ConsumerMessage},
+			{message: "bad message"},
+			{message: testdata.ConsumerMessage},
+		})
+		defer func() {
+			helpers.FailOnError(t, partitionConsumer.Close())
+		}()
+
+		target := &blockedMirrorTarget{release: make(chan struct{})}
+		messageMirror := mirror.NewMirror(target, 1)
+
+		mockConsumer := dummyConsumer(mockStorage, true).(*consumer.KafkaConsumer)
+		mockConsumer.PartitionConsumer = partitionConsumer
+		mockConsumer.Mirror = messageMirror
+
+		// all messages are processed while the mirror target is blocked
+		helpers.FailOnError(t, mockConsumer.Serve())
+		assert.Equal(t, uint64(2), mockConsumer.GetNumberOfSuccessfullyConsumedMessages())
+		assert.Equal(t, uint64(1), mockConsumer.GetNumberOfErrorsConsumingMessages())
+		assert.True(t, messageMirror.Dropped() > 0, "expected dropped messages")
+
+		close(target.release)
+		helpers.FailOnError(t, messageMirror.Close())
+		assert.Equal(t, 3, target.written+int(messageMirror.Dropped()))
+	}, testCaseTimeLimit)
+}
+
+const watchdogThreshold = 50 * time.Millisecond
+
+// blockingStorage is a storage in which writing of reports is blocked until release is closed,
+// entered is closed when the first write is started
+type blockingStorage struct {
+	storage.Storage
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStorage) WriteReportForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	select {
+	case <-s.entered:
+	default:
+		close(s.entered)
+	}
+	<-s.release
+
+	return s.Storage.WriteReportForCluster(orgID, clusterName, report, lastChecked, kafkaOffset)
+}
+
+func TestKafkaConsumerWatchdogDetectsStuckLoop(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		mockStorage := helpers.MustGetMockStorage(t, true)
+		defer helpers.MustCloseStorage(t, mockStorage)
+
+		blockedStorage := &blockingStorage{
+			Storage: mockStorage, entered: make(chan struct{}), release: make(chan struct{}),
+		}
+
+		partitionConsumer := newFakePartitionConsumer([]fakeConsumerEvent{
+			{message: testdata.ConsumerMessage},
+		})
+		partitionConsumer.highWaterMark = 1
+		defer func() {
+			helpers.FailOnError(t, partitionConsumer.Close())
+		}()
+
+		watchdog := consumer.NewWatchdog(watchdogThreshold)
+
+		mockConsumer := dummyConsumer(blockedStorage, true).(*consumer.KafkaConsumer)
+		mockConsumer.PartitionConsumer = partitionConsumer
+		mockConsumer.Watchdog = watchdog
+
+		served := make(chan error)
+		go func() {
+			served <- mockConsumer.Serve()
+		}()
+
+		<-blockedStorage.entered
+		time.Sleep(2 * watchdogThreshold)
+
+		err := watchdog.CheckLiveness()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "consumer loop is stuck")
+		assert.Contains(t, err.Error(), "with 1 messages waiting")
+		assert.True(t, watchdog.SinceHeartbeat() > watchdogThreshold)
+
+		close(blockedStorage.release)
+		helpers.FailOnError(t, <-served)
+
+		// the message has been processed
+		helpers.FailOnError(t, watchdog.CheckLiveness())
+		assert.Equal(t, uint64(1), mockConsumer.GetNumberOfSuccessfullyConsumedMessages())
+	}, testCaseTimeLimit)
+}
+
+func TestKafkaConsumerWatchdogIdleTopic(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		mockStorage := helpers.MustGetMockStorage(t, true)
+		defer helpers.MustCloseStorage(t, mockStorage)
+
+		// no messages are produced, but the partition is not closed
+		partitionConsumer := &fakePartitionConsumer{
+			messages: make(chan *sarama.ConsumerMessage),
+			errors:   make(chan *sarama.ConsumerError),
+			done:     make(chan struct{}),
+		}
+
+		watchdog := consumer.NewWatchdog(watchdogThreshold)
+
+		mockConsumer := dummyConsumer(mockStorage, true).(*consumer.KafkaConsumer)
+		mockConsumer.PartitionConsumer = partitionConsumer
+		mockConsumer.Watchdog = watchdog
+
+		served := make(chan error)
+		go func() {
+			served <- mockConsumer.Serve()
+		}()
+
+		time.Sleep(3 * watchdogThreshold)
+
+		// heartbeats are recorded while the consumer is idle
+		helpers.FailOnError(t, watchdog.CheckLiveness())
+		assert.True(t, watchdog.SinceHeartbeat() < watchdogThreshold)
+
+		close(partitionConsumer.messages)
+		helpers.FailOnError(t, <-served)
+	}, testCaseTimeLimit)
+}
+
+func TestWatchdogWithoutWaitingMessages(t *testing.T) {
+	watchdog := consumer.NewWatchdog(watchdogThreshold / 5)
+
+	// no partition is assigned
+	time.Sleep(watchdogThreshold)
+	helpers.FailOnError(t, watchdog.CheckLiveness())
+
+	// no messages are waiting in the partition
+	consumer.AssignWatchdog(watchdog, func() int64 { return 0 })
+	time.Sleep(watchdogThreshold)
+	helpers.FailOnError(t, watchdog.CheckLiveness())
+
+	consumer.AssignWatchdog(watchdog, func() int64 { return 3 })
+	time.Sleep(watchdogThreshold)
+	err := watchdog.CheckLiveness()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "with 3 messages waiting")
+
+	watchdog.Heartbeat()
+	helpers.FailOnError(t, watchdog.CheckLiveness())
+}
+
+func TestIsFatalError(t *testing.T) {
+	assert.True(t, consumer.IsFatalError(&consumer.FatalError{Err: sarama.ErrOutOfBrokers}))
+	assert.False(t, consumer.IsFatalError(sarama.ErrOutOfBrokers))
+	assert.False(t, consumer.IsFatalError(nil))
+}
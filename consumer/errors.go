@@ -0,0 +1,111 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+)
+
+// Categories of errors of message processing stored with the failures of processing,
+// only these categories are shown to users, never the errors themselves
+const (
+	// ProcessingErrorMalformedMessage means that the message is not a valid JSON
+	// or its attributes have wrong types
+	ProcessingErrorMalformedMessage = "malformed_message"
+	// ProcessingErrorInvalidReport means that the message is missing required attributes
+	// or the report doesn't have the expected structure
+	ProcessingErrorInvalidReport = "invalid_report"
+	// ProcessingErrorInvalidTimestamp means that the time of the last check is not in RFC 3339 format
+	ProcessingErrorInvalidTimestamp = "invalid_timestamp"
+	// ProcessingErrorInternal means that the report couldn't be stored because of an error of aggregator
+	ProcessingErrorInternal = "internal_error"
+)
+
+// errOrganizationNotAllowed is returned for messages of organizations which are not whitelisted
+var errOrganizationNotAllowed = errors.New("organization ID is not whitelisted")
+
+// invalidMessageError is returned by parseMessage when the message is a valid JSON,
+// but its attributes don't pass validation
+type invalidMessageError struct {
+	Err error
+}
+
+func (e *invalidMessageError) Error() string {
+	return e.Err.Error()
+}
+
+// processingErrorCategory maps the error of message processing to the category shown to users,
+// errors of storage can contain internal details, so all of them fall into ProcessingErrorInternal
+func processingErrorCategory(err error) string {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return ProcessingErrorMalformedMessage
+	case *invalidMessageError, *storage.InvalidReportError:
+		return ProcessingErrorInvalidReport
+	case *time.ParseError:
+		return ProcessingErrorInvalidTimestamp
+	default:
+		return ProcessingErrorInternal
+	}
+}
+
+// FatalError is returned by Serve when the consumer can't continue consuming messages,
+// for example when it can't connect or authenticate to the broker or when too many
+// consecutive messages failed. Any other error is related to single message only
+// and the consumer keeps running.
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string {
+	return e.Err.Error()
+}
+
+// IsFatalError checks whether the error prevents consumer from consuming next messages
+func IsFatalError(err error) bool {
+	_, ok := err.(*FatalError)
+	return ok
+}
+
+// isFatalBrokerError checks whether the error reported by broker means that the consumer
+// can't consume any more messages without fixing its configuration
+func isFatalBrokerError(err error) bool {
+	switch err {
+	case sarama.ErrOutOfBrokers,
+		sarama.ErrClosedClient,
+		sarama.ErrSASLAuthenticationFailed,
+		sarama.ErrTopicAuthorizationFailed,
+		sarama.ErrGroupAuthorizationFailed,
+		sarama.ErrClusterAuthorizationFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTransientError checks whether the message failed because of a temporary problem,
+// like overloaded database, which is not counted as a consecutive failure
+func isTransientError(err error) bool {
+	_, ok := err.(*storage.QueryTimeoutError)
+	return ok
+}
@@ -22,3 +22,7 @@ package consumer
 // https://medium.com/@robiplus/golang-trick-export-for-test-aa16cbd7b8cd
 // to see why this trick is needed.
 var ParseMessage = parseMessage
+
+func AssignWatchdog(watchdog *Watchdog, lag func() int64) {
+	watchdog.assign(lag)
+}
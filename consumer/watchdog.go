@@ -0,0 +1,122 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+)
+
+// Watchdog detects consumer loop which stopped processing messages, for example because
+// it's deadlocked in the storage. The consumer records heartbeats after each processed
+// message and periodically when it's idle. The loop is considered stuck when the last
+// heartbeat is older than the threshold while a partition is assigned and there are
+// messages waiting to be processed, so idle topics can't be mistaken for a stuck loop.
+type Watchdog struct {
+	threshold time.Duration
+	mutex     sync.Mutex
+	heartbeat time.Time
+	lag       func() int64
+	stuck     bool
+}
+
+// NewWatchdog constructs watchdog considering the consumer loop stuck when it has not
+// recorded a heartbeat for longer than threshold
+func NewWatchdog(threshold time.Duration) *Watchdog {
+	return &Watchdog{
+		threshold: threshold,
+		heartbeat: time.Now(),
+	}
+}
+
+// Interval returns how often the idle consumer records heartbeats and how often
+// the watchdog should be monitored
+func (watchdog *Watchdog) Interval() time.Duration {
+	return watchdog.threshold / 4
+}
+
+// Heartbeat records that the consumer loop is not stuck
+func (watchdog *Watchdog) Heartbeat() {
+	watchdog.mutex.Lock()
+	defer watchdog.mutex.Unlock()
+
+	watchdog.heartbeat = time.Now()
+}
+
+// assign sets the function returning number of messages waiting in the assigned partition,
+// nil means that no partition is assigned
+func (watchdog *Watchdog) assign(lag func() int64) {
+	watchdog.mutex.Lock()
+	defer watchdog.mutex.Unlock()
+
+	watchdog.lag = lag
+	watchdog.heartbeat = time.Now()
+}
+
+// SinceHeartbeat returns time elapsed since the last heartbeat
+func (watchdog *Watchdog) SinceHeartbeat() time.Duration {
+	watchdog.mutex.Lock()
+	defer watchdog.mutex.Unlock()
+
+	return time.Since(watchdog.heartbeat)
+}
+
+// CheckLiveness returns an error when the consumer loop is stuck
+func (watchdog *Watchdog) CheckLiveness() error {
+	watchdog.mutex.Lock()
+	lag := watchdog.lag
+	sinceHeartbeat := time.Since(watchdog.heartbeat)
+	watchdog.mutex.Unlock()
+
+	if lag == nil || sinceHeartbeat <= watchdog.threshold {
+		return nil
+	}
+
+	// lag is read without holding the mutex, because it's read from the partition consumer
+	if waiting := lag(); waiting > 0 {
+		return fmt.Errorf(
+			"consumer loop is stuck, no heartbeat for %v with %v messages waiting",
+			sinceHeartbeat.Round(time.Second), waiting,
+		)
+	}
+
+	return nil
+}
+
+// Monitor exports time elapsed since the last heartbeat and logs when the consumer loop
+// gets stuck or recovers, it's supposed to be called periodically
+func (watchdog *Watchdog) Monitor() {
+	metrics.ConsumerSecondsSinceHeartbeat.Set(watchdog.SinceHeartbeat().Seconds())
+
+	err := watchdog.CheckLiveness()
+
+	watchdog.mutex.Lock()
+	defer watchdog.mutex.Unlock()
+
+	switch {
+	case err != nil && !watchdog.stuck:
+		log.Error().Err(err).Msg("Consumer loop is stuck")
+	case err == nil && watchdog.stuck:
+		log.Info().Msg("Consumer loop is not stuck anymore")
+	}
+	watchdog.stuck = err != nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// checksumOf computes hex encoded SHA-256 checksum of JSON representation of the value.
+// JSON encoding sorts map keys, so the checksum doesn't depend on the order of map iteration.
+func checksumOf(value interface{}) (string, error) {
+	serialized, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(serialized)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RuleChecksums computes checksum of content of each rule in the directory.
+// The checksums are keyed by rule ID (python module of the rule).
+func RuleChecksums(contentDir RuleContentDirectory) (map[types.RuleID]string, error) {
+	checksums := make(map[types.RuleID]string, len(contentDir))
+
+	for _, ruleContent := range contentDir {
+		checksum, err := checksumOf(ruleContent)
+		if err != nil {
+			return nil, err
+		}
+
+		checksums[types.RuleID(ruleContent.Plugin.PythonModule)] = checksum
+	}
+
+	return checksums, nil
+}
+
+// Checksum computes checksum of the whole rule content from checksums of its rules
+func Checksum(ruleChecksums map[types.RuleID]string) (string, error) {
+	return checksumOf(ruleChecksums)
+}
+
+// DiffRuleChecksums finds rules that were added, removed or modified between
+// two versions of rule content described by checksums of their rules
+func DiffRuleChecksums(from, to map[types.RuleID]string) types.ContentChanges {
+	changes := types.ContentChanges{
+		Added:    []types.RuleID{},
+		Removed:  []types.RuleID{},
+		Modified: []types.RuleID{},
+	}
+
+	for ruleID, toChecksum := range to {
+		fromChecksum, found := from[ruleID]
+		if !found {
+			changes.Added = append(changes.Added, ruleID)
+		} else if fromChecksum != toChecksum {
+			changes.Modified = append(changes.Modified, ruleID)
+		}
+	}
+
+	for ruleID := range from {
+		if _, found := to[ruleID]; !found {
+			changes.Removed = append(changes.Removed, ruleID)
+		}
+	}
+
+	for _, ruleIDs := range [][]types.RuleID{changes.Added, changes.Removed, changes.Modified} {
+		sort.Slice(ruleIDs, func(i, j int) bool { return ruleIDs[i] < ruleIDs[j] })
+	}
+
+	return changes
+}
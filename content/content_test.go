@@ -21,7 +21,12 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+
 	"github.com/RedHatInsights/insights-results-aggregator/content"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 const errYAMLBadToken = "yaml: line 14: found character that cannot start any token"
@@ -96,3 +101,79 @@ func TestContentParseBadMetadataYAML(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestRuleChecksums checks that only checksum of the modified rule changes
+func TestRuleChecksums(t *testing.T) {
+	checksums1, err := content.RuleChecksums(testdata.RuleContentVersion1)
+	helpers.FailOnError(t, err)
+
+	checksums2, err := content.RuleChecksums(testdata.RuleContentVersion2)
+	helpers.FailOnError(t, err)
+
+	assert.Len(t, checksums1, 2)
+	assert.Len(t, checksums2, 3)
+	assert.NotEqual(t, checksums1[testdata.Rule1ID], checksums2[testdata.Rule1ID])
+	assert.Equal(t, checksums1[testdata.Rule2ID], checksums2[testdata.Rule2ID])
+	assert.Len(t, checksums2[testdata.Rule3ID], 64)
+}
+
+// TestChecksumIsStable checks that checksum of the same content doesn't change
+func TestChecksumIsStable(t *testing.T) {
+	checksums, err := content.RuleChecksums(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	checksum, err := content.Checksum(checksums)
+	helpers.FailOnError(t, err)
+
+	for i := 0; i < 10; i++ {
+		checksums, err := content.RuleChecksums(testdata.RuleContent3Rules)
+		helpers.FailOnError(t, err)
+
+		anotherChecksum, err := content.Checksum(checksums)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, checksum, anotherChecksum)
+	}
+
+	otherChecksums, err := content.RuleChecksums(testdata.RuleContentVersion2)
+	helpers.FailOnError(t, err)
+
+	otherChecksum, err := content.Checksum(otherChecksums)
+	helpers.FailOnError(t, err)
+	assert.NotEqual(t, checksum, otherChecksum)
+}
+
+// TestDiffRuleChecksums checks changes between successive versions of rule content
+func TestDiffRuleChecksums(t *testing.T) {
+	var versions []map[types.RuleID]string
+	for _, contentDir := range []content.RuleContentDirectory{
+		testdata.RuleContentVersion1, testdata.RuleContentVersion2, testdata.RuleContentVersion3,
+	} {
+		checksums, err := content.RuleChecksums(contentDir)
+		helpers.FailOnError(t, err)
+		versions = append(versions, checksums)
+	}
+
+	assert.Equal(t, types.ContentChanges{
+		Added:    []types.RuleID{testdata.Rule3ID},
+		Removed:  []types.RuleID{},
+		Modified: []types.RuleID{testdata.Rule1ID},
+	}, content.DiffRuleChecksums(versions[0], versions[1]))
+
+	assert.Equal(t, types.ContentChanges{
+		Added:    []types.RuleID{},
+		Removed:  []types.RuleID{testdata.Rule2ID},
+		Modified: []types.RuleID{},
+	}, content.DiffRuleChecksums(versions[1], versions[2]))
+
+	assert.Equal(t, types.ContentChanges{
+		Added:    []types.RuleID{testdata.Rule3ID},
+		Removed:  []types.RuleID{testdata.Rule2ID},
+		Modified: []types.RuleID{testdata.Rule1ID},
+	}, content.DiffRuleChecksums(versions[0], versions[2]))
+
+	assert.Equal(t, types.ContentChanges{
+		Added:    []types.RuleID{testdata.Rule1ID, testdata.Rule3ID},
+		Removed:  []types.RuleID{},
+		Modified: []types.RuleID{},
+	}, content.DiffRuleChecksums(map[types.RuleID]string{}, versions[2]))
+}
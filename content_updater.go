@@ -0,0 +1,132 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Implementation of rule content updater for aggregator
+package main
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/content"
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+)
+
+// trigger sources and phases of rule content reload used as metric labels
+const (
+	contentReloadTriggerStartup = "startup"
+
+	contentReloadPhaseFetch = "fetch"
+	contentReloadPhaseParse = "parse"
+	contentReloadPhaseLoad  = "load"
+	contentReloadPhaseTotal = "total"
+)
+
+// ruleContentFetcher makes the rule content available in local directory
+// and returns path to that directory
+type ruleContentFetcher interface {
+	FetchRuleContent() (string, error)
+}
+
+// ruleContentLoader loads parsed rule content, it's usually the storage
+type ruleContentLoader interface {
+	LoadRuleContent(contentDir content.RuleContentDirectory) error
+}
+
+// localContentFetcher provides rule content that is already stored in local directory
+type localContentFetcher struct {
+	path string
+}
+
+// FetchRuleContent returns path to the local directory with rule content
+func (fetcher localContentFetcher) FetchRuleContent() (string, error) {
+	return fetcher.path, nil
+}
+
+// measureContentReloadPhase runs one phase of the rule content reload and records its duration
+func measureContentReloadPhase(trigger, phase string, run func() error) error {
+	startTime := time.Now()
+	err := run()
+	metrics.ContentReloadDuration.WithLabelValues(trigger, phase).Observe(time.Since(startTime).Seconds())
+
+	return err
+}
+
+// countRuleContentWarnings logs and counts suspicious parts of the parsed rule content
+// that don't prevent it from being loaded
+func countRuleContentWarnings(contentDir content.RuleContentDirectory) int {
+	warnings := 0
+
+	for ruleName, ruleContent := range contentDir {
+		if len(ruleContent.ErrorKeys) == 0 {
+			log.Warn().Str("rule", ruleName).Msg("Rule content without any error key")
+			warnings++
+		}
+
+		for errorKey, errorKeyContent := range ruleContent.ErrorKeys {
+			if len(errorKeyContent.Metadata.Description) == 0 {
+				log.Warn().Str("rule", ruleName).Str("error_key", errorKey).Msg("Error key content without description")
+				warnings++
+			}
+		}
+	}
+
+	return warnings
+}
+
+// updateRuleContent fetches, parses and loads the rule content while recording
+// duration of each phase labeled by the trigger source
+func updateRuleContent(fetcher ruleContentFetcher, loader ruleContentLoader, trigger string) error {
+	return measureContentReloadPhase(trigger, contentReloadPhaseTotal, func() error {
+		var (
+			contentDirPath string
+			contentDir     content.RuleContentDirectory
+		)
+
+		err := measureContentReloadPhase(trigger, contentReloadPhaseFetch, func() (err error) {
+			contentDirPath, err = fetcher.FetchRuleContent()
+			return err
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Rules fetching error")
+			return err
+		}
+
+		err = measureContentReloadPhase(trigger, contentReloadPhaseParse, func() (err error) {
+			contentDir, err = content.ParseRuleContentDir(contentDirPath)
+			return err
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Rules parsing error")
+			return err
+		}
+
+		metrics.ContentParseWarnings.WithLabelValues(trigger).Add(float64(countRuleContentWarnings(contentDir)))
+
+		err = measureContentReloadPhase(trigger, contentReloadPhaseLoad, func() error {
+			return loader.LoadRuleContent(contentDir)
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Rules content loading error")
+			return err
+		}
+
+		metrics.ContentRulesLoaded.Set(float64(len(contentDir)))
+
+		return nil
+	})
+}
@@ -34,4 +34,13 @@ var (
 	WaitForServiceToStart       = waitForServiceToStart
 	LoadWhitelistFromCSV        = loadWhitelistFromCSV
 	ConfigFileEnvVariableName   = configFileEnvVariableName
+	UpdateRuleContent           = updateRuleContent
+	CleanupOldReports           = cleanupOldReports
+	CleanupConsumerErrors       = cleanupConsumerErrors
+	PurgeSoftDeletedReports     = purgeSoftDeletedReports
+	ArchiveAndCleanupOldReports = archiveAndCleanupOldReports
+	RefreshStaleClusters        = refreshStaleClusters
+	RunMaintenance              = runMaintenance
+	CollectStorageMetrics       = collectStorageMetrics
+	NewLifecycleManager         = newLifecycleManager
 )
@@ -26,7 +26,9 @@ require (
 	github.com/prometheus/common v0.7.0
 	github.com/rs/zerolog v1.18.0
 	github.com/spf13/viper v1.6.2
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.7.0
 	github.com/verdverm/frisby v0.0.0-20170604211311-b16556248a9a
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
 )
@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Import of reports from a file produced by the export of reports
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// importReportsCommand is the name of CLI subcommand importing reports from a file
+const importReportsCommand = "import-reports"
+
+// runImportReports imports reports from the file given as the only argument and prints
+// the stats of the import, it returns exit code. Failure of any line of the file is
+// reported by the exit code too, but the remaining lines are imported anyway.
+func runImportReports(args []string) int {
+	if len(args) != 1 {
+		log.Error().Msgf("Usage: %v <file>", importReportsCommand)
+		return ExitStatusImportReportsError
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to open file with reports")
+		return ExitStatusImportReportsError
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	dbStorage, err := startStorageConnection()
+	if err != nil {
+		return ExitStatusImportReportsError
+	}
+	defer closeStorage(dbStorage)
+
+	stats, err := dbStorage.ImportReports(file)
+
+	fmt.Printf("inserted: %v, skipped as older: %v, failed: %v\n", stats.Inserted, stats.SkippedAsOlder, stats.Failed)
+	for _, lineErr := range stats.Errors {
+		fmt.Println(lineErr)
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read file with reports")
+		return ExitStatusImportReportsError
+	}
+
+	if stats.Failed > 0 {
+		return ExitStatusImportReportsError
+	}
+
+	return ExitStatusOK
+}
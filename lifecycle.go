@@ -0,0 +1,101 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Implementation of lifecycle manager for background loops of aggregator
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backgroundLoop is a long running function which has to return as soon as the context is cancelled
+type backgroundLoop func(ctx context.Context)
+
+// lifecycleManager starts registered background loops and stops them all,
+// so no loop is running when the resources it uses (for example storage) are closed
+type lifecycleManager struct {
+	mutex     sync.Mutex
+	loops     []backgroundLoop
+	cancel    context.CancelFunc
+	waitGroup sync.WaitGroup
+}
+
+// newLifecycleManager constructs lifecycle manager without any registered loop
+func newLifecycleManager() *lifecycleManager {
+	return &lifecycleManager{}
+}
+
+// Register adds the loop to be started by Start
+func (manager *lifecycleManager) Register(loop backgroundLoop) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	manager.loops = append(manager.loops, loop)
+}
+
+// Start runs all registered loops, each one in its own goroutine,
+// it does nothing if the loops have been started already
+func (manager *lifecycleManager) Start() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if manager.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	manager.cancel = cancel
+
+	for _, loop := range manager.loops {
+		manager.waitGroup.Add(1)
+		go func(loop backgroundLoop) {
+			defer manager.waitGroup.Done()
+			loop(ctx)
+		}(loop)
+	}
+}
+
+// Stop cancels context of all running loops and waits until they return,
+// it does nothing if the loops are not running
+func (manager *lifecycleManager) Stop() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if manager.cancel == nil {
+		return
+	}
+
+	manager.cancel()
+	manager.waitGroup.Wait()
+	manager.cancel = nil
+}
+
+// runPeriodically runs the task in the given interval until the context is cancelled
+func runPeriodically(ctx context.Context, interval time.Duration, task func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			task()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
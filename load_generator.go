@@ -0,0 +1,126 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Load generator feeding synthetic messages to the consumer
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/consumer"
+	"github.com/RedHatInsights/insights-results-aggregator/loadgen"
+	"github.com/RedHatInsights/insights-results-aggregator/producer"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// loadGeneratorCommand is the name of CLI subcommand running the load generator
+const loadGeneratorCommand = "loadgen"
+
+// parseLoadGeneratorFlags reads configuration of the load generator from CLI arguments
+func parseLoadGeneratorFlags(args []string) (loadgen.Configuration, bool, error) {
+	var (
+		configuration loadgen.Configuration
+		orgID         uint64
+	)
+
+	flags := flag.NewFlagSet(loadGeneratorCommand, flag.ContinueOnError)
+	flags.IntVar(&configuration.Messages, "messages", 10000, "number of generated messages")
+	flags.Float64Var(&configuration.Rate, "rate", 0, "messages per second, 0 means as fast as possible")
+	flags.IntVar(&configuration.Clusters, "clusters", 100, "number of distinct clusters in generated messages")
+	flags.Uint64Var(&orgID, "org", 1, "organization of generated messages, it has to be whitelisted")
+	flags.Int64Var(&configuration.Seed, "seed", 1, "seed of the generator, the same seed generates the same messages")
+	direct := flags.Bool("direct", false, "inject messages directly into the consumer instead of publishing them")
+
+	if err := flags.Parse(args); err != nil {
+		return configuration, false, err
+	}
+
+	configuration.OrgID = types.OrgID(orgID)
+
+	return configuration, *direct, nil
+}
+
+// runLoadGenerator runs the load generator and prints its summary, it returns exit code
+func runLoadGenerator(args []string) int {
+	configuration, direct, err := parseLoadGeneratorFlags(args)
+	if err != nil {
+		return ExitStatusLoadGeneratorError
+	}
+
+	// the run is interrupted by SIGINT or SIGTERM, summary of messages fed so far is printed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stats := loadgen.NewStats()
+	brokerCfg := getBrokerConfiguration()
+
+	var sink loadgen.Sink
+
+	if direct {
+		dbStorage, err := startStorageConnection()
+		if err != nil {
+			return ExitStatusLoadGeneratorError
+		}
+		defer closeStorage(dbStorage)
+
+		timedStorage := loadgen.TimedStorage{Storage: dbStorage, Stats: stats}
+		sink = &loadgen.ProcessorSink{Processor: consumer.NewMessageProcessor(brokerCfg, timedStorage)}
+	} else {
+		// messages are published to the topic consumed by the aggregator
+		brokerCfg.PublishTopic = brokerCfg.Topic
+
+		kafkaProducer, err := producer.New(brokerCfg)
+		if err != nil {
+			return ExitStatusLoadGeneratorError
+		}
+		defer func() {
+			_ = kafkaProducer.Close()
+		}()
+
+		sink = loadgen.ProducerSink{Producer: kafkaProducer}
+	}
+
+	summary, err := loadgen.Run(ctx, configuration, sink, stats)
+	if err != nil {
+		log.Error().Err(err).Msg("Load generator failed")
+		return ExitStatusLoadGeneratorError
+	}
+
+	// p99 storage latency is zero when messages are published to the broker,
+	// the storage isn't reached by the load generator then
+	fmt.Println(summary)
+
+	return ExitStatusOK
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loadgen generates synthetic messages in the same format as messages consumed
+// from the broker and feeds them either to the broker or directly to the consumer,
+// so the end-to-end ingestion can be benchmarked without real data.
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// MaxRulesPerReport is the maximal number of rules hit in a generated report
+const MaxRulesPerReport = 10
+
+// BaseLastChecked is the time of the last check in the first generated message,
+// each next message is checked one second later
+var BaseLastChecked = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Generator generates a sequence of synthetic messages. Generators created with the same
+// arguments generate the same sequence, so the load is repeatable.
+type Generator struct {
+	orgID     types.OrgID
+	clusters  int
+	random    *rand.Rand
+	generated int
+}
+
+// generatedRuleHit is a rule hit in the generated report
+type generatedRuleHit struct {
+	Component string                 `json:"component"`
+	Key       string                 `json:"key"`
+	Details   map[string]interface{} `json:"details"`
+}
+
+// generatedReport contains all keys required in the report by the consumer
+type generatedReport struct {
+	System       map[string]interface{} `json:"system"`
+	Reports      []generatedRuleHit     `json:"reports"`
+	Fingerprints []interface{}          `json:"fingerprints"`
+	Skips        []interface{}          `json:"skips"`
+	Info         []interface{}          `json:"info"`
+}
+
+// generatedMessage has the same format as messages consumed from the broker
+type generatedMessage struct {
+	OrgID       types.OrgID       `json:"OrgID"`
+	ClusterName types.ClusterName `json:"ClusterName"`
+	Report      generatedReport   `json:"Report"`
+	LastChecked string            `json:"LastChecked"`
+}
+
+// NewGenerator constructs generator of messages of the organization
+// with reports of the given number of clusters
+func NewGenerator(seed int64, orgID types.OrgID, clusters int) *Generator {
+	if clusters < 1 {
+		clusters = 1
+	}
+
+	return &Generator{
+		orgID:    orgID,
+		clusters: clusters,
+		random:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// ClusterName returns name (UUID) of the cluster with the given index
+func ClusterName(index int) types.ClusterName {
+	return types.ClusterName(fmt.Sprintf("00000000-0000-4000-8000-%012x", index))
+}
+
+// Next generates the next message of the sequence
+func (generator *Generator) Next() ([]byte, error) {
+	cluster := generator.random.Intn(generator.clusters)
+	rulesCount := generator.random.Intn(MaxRulesPerReport + 1)
+
+	hits := make([]generatedRuleHit, rulesCount)
+	for i := range hits {
+		rule := generator.random.Intn(MaxRulesPerReport)
+		errorKey := fmt.Sprintf("RULE_%d_ERROR_KEY", rule)
+
+		hits[i] = generatedRuleHit{
+			Component: fmt.Sprintf("ccx_rules_ocp.external.rules.rule_%d.report", rule),
+			Key:       errorKey,
+			Details: map[string]interface{}{
+				"type":      "rule",
+				"error_key": errorKey,
+			},
+		}
+	}
+
+	lastChecked := BaseLastChecked.Add(time.Duration(generator.generated) * time.Second)
+	generator.generated++
+
+	return json.Marshal(generatedMessage{
+		OrgID:       generator.orgID,
+		ClusterName: ClusterName(cluster),
+		Report: generatedReport{
+			System:       map[string]interface{}{"metadata": map[string]interface{}{}, "hostname": nil},
+			Reports:      hits,
+			Fingerprints: []interface{}{},
+			Skips:        []interface{}{},
+			Info:         []interface{}{},
+		},
+		LastChecked: lastChecked.Format(time.RFC3339Nano),
+	})
+}
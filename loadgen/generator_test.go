@@ -0,0 +1,91 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen_test
+
+import (
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/broker"
+	"github.com/RedHatInsights/insights-results-aggregator/consumer"
+	"github.com/RedHatInsights/insights-results-aggregator/loadgen"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const generatedMessagesCount = 50
+
+func generateMessages(t *testing.T, generator *loadgen.Generator) []string {
+	messages := make([]string, generatedMessagesCount)
+	for i := range messages {
+		message, err := generator.Next()
+		helpers.FailOnError(t, err)
+		messages[i] = string(message)
+	}
+
+	return messages
+}
+
+func TestGeneratorDeterministic(t *testing.T) {
+	first := generateMessages(t, loadgen.NewGenerator(42, testdata.OrgID, 10))
+	second := generateMessages(t, loadgen.NewGenerator(42, testdata.OrgID, 10))
+
+	assert.Equal(t, first, second)
+}
+
+func TestGeneratorSeedChangesMessages(t *testing.T) {
+	first := generateMessages(t, loadgen.NewGenerator(1, testdata.OrgID, 10))
+	second := generateMessages(t, loadgen.NewGenerator(2, testdata.OrgID, 10))
+
+	assert.NotEqual(t, first, second)
+}
+
+// TestGeneratorMessagesStored checks that generated messages pass validation of the consumer
+// and that reports are written only for the configured number of clusters
+func TestGeneratorMessagesStored(t *testing.T) {
+	const clusters = 5
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	processor := consumer.NewMessageProcessor(broker.Configuration{
+		OrgWhitelist: mapset.NewSetWith(testdata.OrgID),
+	}, mockStorage)
+	sink := &loadgen.ProcessorSink{Processor: processor}
+
+	for _, message := range generateMessages(t, loadgen.NewGenerator(1, testdata.OrgID, clusters)) {
+		helpers.FailOnError(t, sink.Send([]byte(message)))
+	}
+
+	clusterNames, err := mockStorage.ListOfClustersForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+
+	assert.NotEmpty(t, clusterNames)
+	assert.True(t, len(clusterNames) <= clusters)
+	for _, clusterName := range clusterNames {
+		assert.Contains(t, []types.ClusterName{
+			loadgen.ClusterName(0),
+			loadgen.ClusterName(1),
+			loadgen.ClusterName(2),
+			loadgen.ClusterName(3),
+			loadgen.ClusterName(4),
+		}, clusterName)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/consumer"
+	"github.com/RedHatInsights/insights-results-aggregator/producer"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// Configuration represents configuration of the load generator
+//
+// Rate - number of messages per second, 0 means as fast as possible
+//
+// Clusters - number of distinct clusters reported in generated messages
+type Configuration struct {
+	Messages int
+	Rate     float64
+	Clusters int
+	OrgID    types.OrgID
+	Seed     int64
+}
+
+// Sink receives messages generated by the load generator
+type Sink interface {
+	Send(message []byte) error
+}
+
+// ProducerSink publishes messages to the broker
+type ProducerSink struct {
+	Producer producer.Producer
+}
+
+// Send publishes the message to the broker
+func (sink ProducerSink) Send(message []byte) error {
+	_, _, err := sink.Producer.ProduceMessage(string(message))
+	return err
+}
+
+// ProcessorSink injects messages directly into the message processing of the consumer
+type ProcessorSink struct {
+	Processor consumer.MessageProcessor
+}
+
+// Send processes the message like it has been consumed from the broker. The message has
+// unknown Kafka offset, so its report is always written, even by repeated runs.
+func (sink *ProcessorSink) Send(message []byte) error {
+	msg := &sarama.ConsumerMessage{Value: message, Offset: int64(types.UnknownKafkaOffset)}
+
+	return sink.Processor.ProcessMessage(msg)
+}
+
+// TimedStorage records latencies of reports written into the storage
+type TimedStorage struct {
+	storage.Storage
+	Stats *Stats
+}
+
+// WriteReportForCluster writes the report into the wrapped storage and records the latency
+func (timedStorage TimedStorage) WriteReportForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastCheckedTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	started := time.Now()
+	err := timedStorage.Storage.WriteReportForCluster(orgID, clusterName, report, lastCheckedTime, kafkaOffset)
+	timedStorage.Stats.RecordStorageLatency(time.Since(started))
+
+	return err
+}
+
+// Run generates configured number of messages at configured rate and sends them to the sink,
+// all messages are accounted in stats. The run stops early when the context is done.
+// Errors of single messages don't stop the run, only error of the generator does.
+func Run(ctx context.Context, configuration Configuration, sink Sink, stats *Stats) (Summary, error) {
+	generator := NewGenerator(configuration.Seed, configuration.OrgID, configuration.Clusters)
+
+	var tick <-chan time.Time
+	if configuration.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / configuration.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	started := time.Now()
+
+	for n := 0; n < configuration.Messages; n++ {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return stats.Summary(time.Since(started)), nil
+			case <-tick:
+			}
+		} else if ctx.Err() != nil {
+			return stats.Summary(time.Since(started)), nil
+		}
+
+		message, err := generator.Next()
+		if err != nil {
+			return stats.Summary(time.Since(started)), err
+		}
+
+		err = sink.Send(message)
+		if err != nil {
+			log.Error().Err(err).Msg("Generated message can't be processed")
+		}
+		stats.RecordMessage(err)
+	}
+
+	return stats.Summary(time.Since(started)), nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats accounts messages fed by the load generator and latencies of storage writes.
+// It's safe for concurrent use.
+type Stats struct {
+	mutex            sync.Mutex
+	messages         int
+	errors           int
+	storageLatencies []time.Duration
+}
+
+// Summary contains results of the load generator run
+type Summary struct {
+	Messages          int           `json:"messages"`
+	Errors            int           `json:"errors"`
+	Elapsed           time.Duration `json:"elapsed"`
+	Throughput        float64       `json:"throughput"`
+	StorageLatencyP99 time.Duration `json:"storage_latency_p99"`
+}
+
+// NewStats constructs empty stats
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// RecordMessage accounts the message, err is the error of feeding the message
+func (stats *Stats) RecordMessage(err error) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	stats.messages++
+	if err != nil {
+		stats.errors++
+	}
+}
+
+// RecordStorageLatency accounts latency of one storage write
+func (stats *Stats) RecordStorageLatency(latency time.Duration) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	stats.storageLatencies = append(stats.storageLatencies, latency)
+}
+
+// Summary summarizes the run which took elapsed time. The throughput is the number
+// of messages fed without error per second. The 99th percentile of storage latencies
+// is computed by nearest-rank method, it's zero when no latencies were recorded.
+func (stats *Stats) Summary(elapsed time.Duration) Summary {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	summary := Summary{
+		Messages:          stats.messages,
+		Errors:            stats.errors,
+		Elapsed:           elapsed,
+		StorageLatencyP99: percentile(stats.storageLatencies, 99),
+	}
+
+	if elapsed > 0 {
+		summary.Throughput = float64(stats.messages-stats.errors) / elapsed.Seconds()
+	}
+
+	return summary
+}
+
+// String formats the summary for the report printed at the end of the run
+func (summary Summary) String() string {
+	return fmt.Sprintf(
+		"messages: %d, errors: %d, elapsed: %v, throughput: %.2f msg/s, p99 storage latency: %v",
+		summary.Messages, summary.Errors, summary.Elapsed, summary.Throughput, summary.StorageLatencyP99,
+	)
+}
+
+// percentile returns the p-th percentile of latencies by nearest-rank method
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration{}, latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/loadgen"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+)
+
+// failingSink fails every failEvery-th message
+type failingSink struct {
+	failEvery int
+	received  int
+}
+
+func (sink *failingSink) Send(message []byte) error {
+	sink.received++
+	if sink.received%sink.failEvery == 0 {
+		return errors.New("sink error")
+	}
+
+	return nil
+}
+
+func TestStatsSummary(t *testing.T) {
+	stats := loadgen.NewStats()
+
+	for i := 1; i <= 100; i++ {
+		stats.RecordStorageLatency(time.Duration(i) * time.Millisecond)
+	}
+	for i := 0; i < 8; i++ {
+		stats.RecordMessage(nil)
+	}
+	stats.RecordMessage(errors.New("error"))
+	stats.RecordMessage(errors.New("error"))
+
+	assert.Equal(t, loadgen.Summary{
+		Messages:          10,
+		Errors:            2,
+		Elapsed:           2 * time.Second,
+		Throughput:        4,
+		StorageLatencyP99: 99 * time.Millisecond,
+	}, stats.Summary(2*time.Second))
+}
+
+func TestStatsSummaryEmpty(t *testing.T) {
+	assert.Equal(t, loadgen.Summary{}, loadgen.NewStats().Summary(0))
+}
+
+func TestStatsP99OfFewLatencies(t *testing.T) {
+	stats := loadgen.NewStats()
+	stats.RecordStorageLatency(3 * time.Millisecond)
+	stats.RecordStorageLatency(time.Millisecond)
+
+	assert.Equal(t, 3*time.Millisecond, stats.Summary(time.Second).StorageLatencyP99)
+}
+
+func TestRunAccountsAllMessages(t *testing.T) {
+	sink := &failingSink{failEvery: 4}
+
+	summary, err := loadgen.Run(context.Background(), loadgen.Configuration{
+		Messages: 20,
+		Clusters: 3,
+		OrgID:    testdata.OrgID,
+	}, sink, loadgen.NewStats())
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, 20, sink.received)
+	assert.Equal(t, 20, summary.Messages)
+	assert.Equal(t, 5, summary.Errors)
+}
+
+func TestRunStoppedByContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	summary, err := loadgen.Run(ctx, loadgen.Configuration{
+		Messages: 20,
+		Rate:     1000,
+		Clusters: 3,
+		OrgID:    testdata.OrgID,
+	}, &failingSink{failEvery: 4}, loadgen.NewStats())
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, 0, summary.Messages)
+}
@@ -26,6 +26,48 @@ limitations under the License.
 // produced_messages - total number of produced messages
 //
 // written_reports - total number of reports written into the storage (cache)
+//
+// stale_reports_served_total - total number of reports served while older than the staleness threshold
+//
+// content_reload_duration_seconds - duration of rule content reload phases
+//
+// content_rules_loaded - number of rules loaded by the latest rule content reload
+//
+// content_parse_warnings_total - total number of warnings found while parsing rule content
+//
+// old_reports_deleted_total - total number of reports deleted by the retention cleanup
+//
+// old_reports_archive_errors_total - total number of failures to archive old reports before the cleanup
+//
+// old_consumer_errors_deleted_total - total number of failures of processing of messages deleted by the cleanup
+//
+// soft_deleted_reports_purged_total - total number of soft-deleted reports deleted for good by the cleanup
+//
+// sql_query_duration_seconds - duration of storage operations per method
+//
+// sql_query_errors_total - total number of storage operations failed because of database errors per method
+//
+// latest_stored_kafka_offset - the highest Kafka offset stored with reports
+//
+// org_id_cache_hits_total - total number of lookups of organization of a cluster served from the cache
+//
+// org_id_cache_misses_total - total number of lookups of organization of a cluster not found in the cache
+//
+// stale_clusters_total - number of clusters whose reports were not checked for longer than the threshold
+//
+// storage_maintenance_runs_total - total number of periodic maintenance runs of the database per result
+//
+// storage_maintenance_duration_seconds - duration of periodic maintenance of the database
+//
+// orphaned_feedback_deleted_total - total number of users' feedbacks on clusters without reports deleted by maintenance
+//
+// mirrored_messages_dropped_total - total number of consumed messages which were not mirrored
+//
+// db_report_rows - number of rows of the report table
+//
+// db_feedback_rows - number of rows of the table with users' votes on rules
+//
+// db_rule_rows - number of rows of the rule table
 package metrics
 
 import (
@@ -65,8 +107,193 @@ var WrittenReports = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "The total number of reports written to the storage",
 })
 
+// DuplicateReportsSkipped shows number of reports which were not rewritten,
+// because they were identical to the stored ones
+var DuplicateReportsSkipped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "duplicate_reports_skipped_total",
+	Help: "The total number of reports identical to the stored ones which were not rewritten",
+})
+
+// RuleHitsReadComparisons shows number of sampled reads of rule hits compared
+// with rule hits read from the other source
+var RuleHitsReadComparisons = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "rule_hits_read_comparisons_total",
+	Help: "The total number of reads of rule hits compared with the other source",
+})
+
+// RuleHitsReadDivergences shows number of compared reads of rule hits
+// which differ from rule hits read from the other source
+var RuleHitsReadDivergences = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "rule_hits_read_divergences_total",
+	Help: "The total number of reads of rule hits which differ from the other source",
+})
+
 // FeedbackOnRules shows how many times users left feedback on rules
 var FeedbackOnRules = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "feedback_on_rules",
 	Help: "The total number of left feedback",
 })
+
+// FeedbackOnRulesDeleted shows how many times users deleted their feedback on rules
+var FeedbackOnRulesDeleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "feedback_on_rules_deleted_total",
+	Help: "The total number of deleted feedback",
+})
+
+// StaleReportsServed shows how many times a report older than the staleness threshold was served
+var StaleReportsServed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "stale_reports_served_total",
+	Help: "The total number of served reports older than the staleness threshold",
+})
+
+// ContentReloadDuration collects durations of rule content reload per trigger source and phase
+var ContentReloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "content_reload_duration_seconds",
+	Help:    "Duration of rule content reload phases in seconds",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+}, []string{"trigger", "phase"})
+
+// ContentRulesLoaded shows number of rules loaded into the storage by the latest content reload
+var ContentRulesLoaded = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "content_rules_loaded",
+	Help: "The number of rules loaded by the latest rule content reload",
+})
+
+// ContentParseWarnings shows number of warnings found while parsing rule content per trigger source
+var ContentParseWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "content_parse_warnings_total",
+	Help: "The total number of warnings found while parsing rule content",
+}, []string{"trigger"})
+
+// OldReportsDeleted shows number of reports deleted by the periodic cleanup of old reports
+var OldReportsDeleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "old_reports_deleted_total",
+	Help: "The total number of reports deleted because they were not updated for the retention period",
+})
+
+// OldConsumerErrorsDeleted shows number of failures of processing of consumed messages
+// deleted by the periodic cleanup
+var OldConsumerErrorsDeleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "old_consumer_errors_deleted_total",
+	Help: "The total number of failures of processing of consumed messages deleted after their retention period",
+})
+
+// SoftDeletedReportsPurged shows number of soft-deleted reports deleted for good by the periodic cleanup
+var SoftDeletedReportsPurged = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "soft_deleted_reports_purged_total",
+	Help: "The total number of soft-deleted reports deleted after their retention period",
+})
+
+// OldReportsArchiveErrors shows number of failures to archive old reports before their deletion
+var OldReportsArchiveErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "old_reports_archive_errors_total",
+	Help: "The total number of failures to archive old reports, such reports are kept until the next cleanup",
+})
+
+// ConsistencyIssues shows number of reports found inconsistent with their rule hits by the consistency check
+var ConsistencyIssues = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "consistency_issues_total",
+	Help: "The total number of reports whose rule hits don't match the report",
+})
+
+// SQLQueryDuration collects durations of storage operations per method of the storage
+var SQLQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "sql_query_duration_seconds",
+	Help:    "Duration of storage operations in seconds",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+}, []string{"method"})
+
+// SQLQueryErrors shows number of storage operations failed because of database errors per method of the storage,
+// operations rejected because of invalid input or not found items are not counted
+var SQLQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sql_query_errors_total",
+	Help: "The total number of storage operations failed because of database errors",
+}, []string{"method"})
+
+// ConsistencyIssuesRepaired shows number of inconsistent reports repaired by the consistency check
+var ConsistencyIssuesRepaired = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "consistency_issues_repaired_total",
+	Help: "The total number of inconsistent reports whose rule hits were rewritten from the report",
+})
+
+// LatestStoredKafkaOffset shows the highest offset of Kafka messages whose reports are stored,
+// it's read from the storage when the consumer starts and updated by stored reports
+var LatestStoredKafkaOffset = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "latest_stored_kafka_offset",
+	Help: "The highest offset of Kafka messages whose reports are stored",
+})
+
+// OrgIDCacheHits shows number of lookups of organization of a cluster served from the cache of the storage
+var OrgIDCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "org_id_cache_hits_total",
+	Help: "The total number of lookups of organization of a cluster served from the cache",
+})
+
+// OrgIDCacheMisses shows number of lookups of organization of a cluster which had to be read from the database
+var OrgIDCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "org_id_cache_misses_total",
+	Help: "The total number of lookups of organization of a cluster not found in the cache",
+})
+
+// StaleClusters shows number of clusters whose reports were not checked for longer than the configured threshold,
+// it's refreshed periodically from the storage
+var StaleClusters = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "stale_clusters_total",
+	Help: "The number of clusters whose reports were not checked for longer than the threshold",
+})
+
+// StorageMaintenanceRuns shows number of periodic maintenance runs of the database (VACUUM and ANALYZE)
+// per result: succeeded, failed or skipped because another maintenance was in progress
+var StorageMaintenanceRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "storage_maintenance_runs_total",
+	Help: "The total number of periodic maintenance runs of the database per result",
+}, []string{"result"})
+
+// StorageMaintenanceDuration collects durations of periodic maintenance of the database,
+// skipped runs are not observed
+var StorageMaintenanceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "storage_maintenance_duration_seconds",
+	Help:    "Duration of periodic maintenance of the database in seconds",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+})
+
+// OrphanedFeedbackDeleted shows number of users' feedbacks on clusters which don't have any report anymore
+// deleted by the periodic maintenance of the database
+var OrphanedFeedbackDeleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "orphaned_feedback_deleted_total",
+	Help: "The total number of users' feedbacks on clusters without reports deleted by maintenance",
+})
+
+// ConsumerSecondsSinceHeartbeat shows time elapsed since the consumer loop recorded its last heartbeat,
+// the loop records heartbeats after each processed message and periodically when it's idle
+var ConsumerSecondsSinceHeartbeat = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "consumer_seconds_since_heartbeat",
+	Help: "Time elapsed since the last heartbeat of the consumer loop in seconds",
+})
+
+// MirroredMessagesDropped shows number of consumed messages which were not mirrored
+// because the buffer of the mirror was full or because they couldn't be written
+var MirroredMessagesDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "mirrored_messages_dropped_total",
+	Help: "The total number of consumed messages which were not mirrored",
+})
+
+// DBReportRows shows number of rows of the report table (soft-deleted reports included),
+// it's refreshed periodically from the storage
+var DBReportRows = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "db_report_rows",
+	Help: "The number of rows of the report table",
+})
+
+// DBFeedbackRows shows number of rows of the table with users' votes on rules,
+// it's refreshed periodically from the storage
+var DBFeedbackRows = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "db_feedback_rows",
+	Help: "The number of rows of the table with users' votes on rules",
+})
+
+// DBRuleRows shows number of rows of the rule table, it's refreshed periodically from the storage
+var DBRuleRows = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "db_rule_rows",
+	Help: "The number of rows of the rule table",
+})
@@ -26,6 +26,8 @@ import (
 
 	"github.com/RedHatInsights/insights-results-aggregator/metrics"
 	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 func getCounterValue(counter prometheus.Counter) float64 {
@@ -47,6 +49,29 @@ func getCounterVecValue(counterVec *prometheus.CounterVec, labels map[string]str
 	return getCounterValue(counter)
 }
 
+// gatherMetric scrapes the default registry and returns the metric of the family with the label set to the value,
+// nil is returned when there is no such metric
+func gatherMetric(t *testing.T, familyName, labelName, labelValue string) *prom_models.Metric {
+	families, err := prometheus.DefaultGatherer.Gather()
+	helpers.FailOnError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != familyName {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == labelName && label.GetValue() == labelValue {
+					return metric
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 const (
 	testTopicName     = "ccx.ocp.results"
 	testOrgID         = 1
@@ -87,6 +112,51 @@ func TestConsumedMessagesMetric(t *testing.T) {
 	}, testCaseTimeLimit)
 }
 
+// TestSQLQueryDurationMetric checks that durations of storage operations are exposed per method
+func TestSQLQueryDurationMetric(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	_, err = mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	for _, method := range []string{"WriteReportForCluster", "ReadReportForCluster"} {
+		metric := gatherMetric(t, "sql_query_duration_seconds", "method", method)
+		if assert.NotNil(t, metric, method) {
+			assert.True(t, metric.GetHistogram().GetSampleCount() > 0, method)
+		}
+	}
+}
+
+// TestSQLQueryErrorsMetric checks that only storage operations failed because of the database are counted
+func TestSQLQueryErrorsMetric(t *testing.T) {
+	labels := map[string]string{"method": "ReadReportForCluster"}
+	initialErrors := getCounterVecValue(metrics.SQLQueryErrors, labels)
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+
+	// report of unknown cluster is not found, but no query has failed
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	assert.Error(t, err)
+	assert.Equal(t, initialErrors, getCounterVecValue(metrics.SQLQueryErrors, labels))
+
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err = mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	assert.EqualError(t, err, "sql: database is closed")
+	assert.Equal(t, initialErrors+1, getCounterVecValue(metrics.SQLQueryErrors, labels))
+
+	metric := gatherMetric(t, "sql_query_errors_total", "method", "ReadReportForCluster")
+	if assert.NotNil(t, metric) {
+		assert.Equal(t, initialErrors+1, metric.GetCounter().GetValue())
+	}
+}
+
 // TODO: metrics.APIRequests
 // TODO: metrics.APIResponsesTime
 // TODO: metrics.ProducedMessages
@@ -15,12 +15,17 @@
 package migration_test
 
 import (
+	"database/sql"
+	sql_driver "database/sql/driver"
+	"fmt"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/RedHatInsights/insights-results-aggregator/migration"
 	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 func TestAllMigrations(t *testing.T) {
@@ -30,7 +35,7 @@ func TestAllMigrations(t *testing.T) {
 	err := migration.InitInfoTable(db)
 	helpers.FailOnError(t, err)
 
-	err = migration.SetDBVersion(db, migration.GetMaxVersion())
+	err = migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	helpers.FailOnError(t, err)
 }
 
@@ -49,11 +54,18 @@ func TestMigrationsOneByOne(t *testing.T) {
 		err := migration.InitInfoTable(db)
 		helpers.FailOnError(t, err)
 
-		err = migration.SetDBVersion(db, migration.GetMaxVersion())
+		err = migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 		helpers.FailOnError(t, err)
 	}
 }
 
+// assertTableKept checks that the table created before the migrations still has its original column
+func assertTableKept(t *testing.T, db *sql.DB, table string) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(c) FROM " + table).Scan(&count)
+	helpers.FailOnError(t, err)
+}
+
 func TestAllMigrations_Migration1TableReportAlreadyExists(t *testing.T) {
 	db := prepareDBAndInfo(t)
 	defer closeDB(t, db)
@@ -61,7 +73,7 @@ func TestAllMigrations_Migration1TableReportAlreadyExists(t *testing.T) {
 	_, err := db.Exec(`CREATE TABLE report(c INTEGER);`)
 	helpers.FailOnError(t, err)
 
-	err = migration.SetDBVersion(db, migration.GetMaxVersion())
+	err = migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	assert.EqualError(t, err, "table report already exists")
 }
 
@@ -70,14 +82,14 @@ func TestAllMigrations_Migration1TableReportDoesNotExist(t *testing.T) {
 	defer closeDB(t, db)
 
 	// set to the latest version
-	err := migration.SetDBVersion(db, migration.GetMaxVersion())
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	helpers.FailOnError(t, err)
 
 	_, err = db.Exec(`DROP TABLE report;`)
 	helpers.FailOnError(t, err)
 
 	// try to set to the first version
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	assert.EqualError(t, err, "no such table: report")
 }
 
@@ -88,7 +100,7 @@ func TestAllMigrations_Migration2TableRuleAlreadyExists(t *testing.T) {
 	_, err := db.Exec(`CREATE TABLE rule(c INTEGER);`)
 	helpers.FailOnError(t, err)
 
-	err = migration.SetDBVersion(db, migration.GetMaxVersion())
+	err = migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	assert.EqualError(t, err, "table rule already exists")
 }
 
@@ -97,14 +109,14 @@ func TestAllMigrations_Migration2TableRuleDoesNotExist(t *testing.T) {
 	defer closeDB(t, db)
 
 	// set to the latest version
-	err := migration.SetDBVersion(db, migration.GetMaxVersion())
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	helpers.FailOnError(t, err)
 
 	_, err = db.Exec(`DROP TABLE rule;`)
 	helpers.FailOnError(t, err)
 
 	// try to set to the first version
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	assert.EqualError(t, err, "no such table: rule")
 }
 
@@ -115,7 +127,7 @@ func TestAllMigrations_Migration2TableRuleErrorKeyAlreadyExists(t *testing.T) {
 	_, err := db.Exec(`CREATE TABLE rule_error_key(c INTEGER);`)
 	helpers.FailOnError(t, err)
 
-	err = migration.SetDBVersion(db, migration.GetMaxVersion())
+	err = migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	assert.EqualError(t, err, "table rule_error_key already exists")
 }
 
@@ -124,14 +136,14 @@ func TestAllMigrations_Migration2TableRuleErrorKeyDoesNotExist(t *testing.T) {
 	defer closeDB(t, db)
 
 	// set to the latest version
-	err := migration.SetDBVersion(db, migration.GetMaxVersion())
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	helpers.FailOnError(t, err)
 
 	_, err = db.Exec(`DROP TABLE rule_error_key;`)
 	helpers.FailOnError(t, err)
 
 	// try to set to the first version
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	assert.EqualError(t, err, "no such table: rule_error_key")
 }
 
@@ -142,7 +154,7 @@ func TestAllMigrations_Migration3TableClusterRuleUserFeedbackAlreadyExists(t *te
 	_, err := db.Exec(`CREATE TABLE cluster_rule_user_feedback(c INTEGER);`)
 	helpers.FailOnError(t, err)
 
-	err = migration.SetDBVersion(db, migration.GetMaxVersion())
+	err = migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	assert.EqualError(t, err, "table cluster_rule_user_feedback already exists")
 }
 
@@ -151,13 +163,796 @@ func TestAllMigrations_Migration3TableClusterRuleUserFeedbackDoesNotExist(t *tes
 	defer closeDB(t, db)
 
 	// set to the latest version
-	err := migration.SetDBVersion(db, migration.GetMaxVersion())
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	helpers.FailOnError(t, err)
 
 	_, err = db.Exec(`DROP TABLE cluster_rule_user_feedback;`)
 	helpers.FailOnError(t, err)
 
 	// try to set to the first version
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	assert.EqualError(t, err, "no such table: cluster_rule_user_feedback")
 }
+
+func TestAllMigrations_Migration5PostgresReportJSONB(t *testing.T) {
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report ALTER COLUMN report TYPE JSONB USING report::JSONB").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig5.StepUp(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report ALTER COLUMN report TYPE VARCHAR USING report::VARCHAR").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err = migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig5.StepDown(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+}
+
+func TestAllMigrations_Migration8PostgresOrgIDBigint(t *testing.T) {
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	for _, table := range []string{"report", "report_history", "rule_hit"} {
+		expects.ExpectExecWithArgs("ALTER TABLE " + table + " ALTER COLUMN org_id TYPE BIGINT").
+			WillReturnResult(sql_driver.ResultNoRows)
+	}
+	expects.ExpectCommit()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig8.StepUp(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+
+	expects.ExpectBegin()
+	for _, table := range []string{"report", "report_history", "rule_hit"} {
+		expects.ExpectExecWithArgs("ALTER TABLE " + table + " ALTER COLUMN org_id TYPE INTEGER").
+			WillReturnResult(sql_driver.ResultNoRows)
+	}
+	expects.ExpectCommit()
+
+	err = migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig8.StepDown(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+}
+
+func TestAllMigrations_Migration8PostgresOrgIDBigintError(t *testing.T) {
+	const errStr = "column org_id can't be altered"
+
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report ALTER COLUMN org_id TYPE BIGINT").
+		WillReturnError(fmt.Errorf(errStr))
+	expects.ExpectRollback()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig8.StepUp(tx, types.DBDriverPostgres)
+	})
+	assert.EqualError(t, err, errStr)
+}
+
+func TestAllMigrations_Migration6TableReportHistoryAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	_, err := db.Exec(`CREATE TABLE report_history(c INTEGER);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 6)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "report_history")
+}
+
+func TestAllMigrations_Migration6TableReportHistoryDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE report_history;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: report_history")
+}
+
+func TestAllMigrations_Migration7TableRuleHitAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	_, err := db.Exec(`CREATE TABLE rule_hit(c INTEGER);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 7)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "rule_hit")
+}
+
+func TestAllMigrations_Migration7TableRuleHitDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE rule_hit;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: rule_hit")
+}
+
+func TestAllMigrations_Migration9TableContentVersionAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	_, err := db.Exec(`CREATE TABLE content_version(c INTEGER);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 9)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "content_version")
+}
+
+func TestAllMigrations_Migration9TableContentVersionDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE content_version;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: content_version")
+}
+
+func TestAllMigrations_Migration10TableRuleAckAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	_, err := db.Exec(`CREATE TABLE rule_ack(c INTEGER);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 10)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "rule_ack")
+}
+
+func TestAllMigrations_Migration10TableRuleAckDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE rule_ack;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: rule_ack")
+}
+
+func TestAllMigrations_Migration11TableConsistencyIssueAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	_, err := db.Exec(`CREATE TABLE consistency_issue(c INTEGER);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 11)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "consistency_issue")
+}
+
+func TestAllMigrations_Migration11TableConsistencyIssueDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE consistency_issue;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: consistency_issue")
+}
+
+func TestAllMigrations_Migration12TableRuleDisableOrgAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	_, err := db.Exec(`CREATE TABLE rule_disable_org(c INTEGER);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 12)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "rule_disable_org")
+}
+
+func TestAllMigrations_Migration12TableRuleDisableOrgDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE rule_disable_org;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: rule_disable_org")
+}
+
+// TestAllMigrations_Migration13MovesFeedbackMessages checks that messages are moved
+// into cluster_rule_user_message and back without loss
+func TestAllMigrations_Migration13MovesFeedbackMessages(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	err := migration.SetDBVersion(db, dbDriver, 12)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`
+		INSERT INTO cluster_rule_user_feedback
+		(cluster_id, rule_id, user_id, message, user_vote, added_at, updated_at)
+		VALUES
+		('cluster', 'rule1', 'user', 'message', 1, '2020-01-01', '2020-01-02'),
+		('cluster', 'rule2', 'user', '', -1, '2020-01-01', '2020-01-02')
+	`)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 13)
+	helpers.FailOnError(t, err)
+
+	var (
+		ruleID  string
+		message string
+	)
+
+	err = db.QueryRow("SELECT rule_id, message FROM cluster_rule_user_message").Scan(&ruleID, &message)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "rule1", ruleID)
+	assert.Equal(t, "message", message)
+
+	var votes int
+	err = db.QueryRow("SELECT COUNT(*) FROM cluster_rule_user_feedback").Scan(&votes)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 2, votes)
+
+	_, err = db.Exec("SELECT message FROM cluster_rule_user_feedback")
+	assert.EqualError(t, err, "no such column: message")
+
+	// messages are moved back by the migration down
+	err = migration.SetDBVersion(db, dbDriver, 12)
+	helpers.FailOnError(t, err)
+
+	messages := make(map[string]string)
+
+	rows, err := db.Query("SELECT rule_id, message FROM cluster_rule_user_feedback")
+	helpers.FailOnError(t, err)
+	defer func() {
+		helpers.FailOnError(t, rows.Close())
+	}()
+
+	for rows.Next() {
+		helpers.FailOnError(t, rows.Scan(&ruleID, &message))
+		messages[ruleID] = message
+	}
+	helpers.FailOnError(t, rows.Err())
+
+	assert.Equal(t, map[string]string{"rule1": "message", "rule2": ""}, messages)
+}
+
+// TestAllMigrations_Migration14SQLiteKafkaOffsetKept checks that the kafka_offset column kept
+// in SQLite by the migration down doesn't break the migration up
+func TestAllMigrations_Migration14SQLiteKafkaOffsetKept(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	err := migration.SetDBVersion(db, dbDriver, 14)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec("SELECT kafka_offset FROM report")
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 13)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 14)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec("SELECT kafka_offset FROM report")
+	helpers.FailOnError(t, err)
+}
+
+func TestAllMigrations_Migration14PostgresKafkaOffset(t *testing.T) {
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report ADD COLUMN kafka_offset BIGINT").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig14.StepUp(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report DROP COLUMN kafka_offset").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err = migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig14.StepDown(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+}
+
+// TestAllMigrations_Migration15SQLiteReportChecksumKept checks that the report_checksum column kept
+// in SQLite by the migration down doesn't break the migration up
+func TestAllMigrations_Migration15SQLiteReportChecksumKept(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	err := migration.SetDBVersion(db, dbDriver, 15)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 14)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 15)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec("SELECT report_checksum FROM report")
+	helpers.FailOnError(t, err)
+}
+
+func TestAllMigrations_Migration15PostgresReportChecksum(t *testing.T) {
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report ADD COLUMN report_checksum VARCHAR").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig15.StepUp(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report DROP COLUMN report_checksum").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err = migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig15.StepDown(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+}
+
+func TestAllMigrations_Migration16TableConsumerErrorAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// columns of the index created by the migration are required
+	_, err := db.Exec(`CREATE TABLE consumer_error(c INTEGER, cluster VARCHAR, consumed_at TIMESTAMP);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 16)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "consumer_error")
+}
+
+func TestAllMigrations_Migration16TableConsumerErrorDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE consumer_error;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: consumer_error")
+}
+
+// TestAllMigrations_Migration17ReportIndex checks that the index of reports
+// by organization and time of the last check is created and dropped
+func TestAllMigrations_Migration17ReportIndex(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	countIndexes := func() int {
+		var count int
+		err := db.QueryRow(
+			`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'report_org_last_checked_idx'`,
+		).Scan(&count)
+		helpers.FailOnError(t, err)
+		return count
+	}
+
+	err := migration.SetDBVersion(db, dbDriver, 17)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, countIndexes())
+
+	err = migration.SetDBVersion(db, dbDriver, 16)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, countIndexes())
+}
+
+// TestAllMigrations_Migration18ConsumerErrorMessage checks that the columns with the message
+// are kept by the migration down in SQLite, so the migration up can be applied again
+func TestAllMigrations_Migration18ConsumerErrorMessage(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	err := migration.SetDBVersion(db, dbDriver, 18)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`
+		INSERT INTO consumer_error(topic, partition, topic_offset, consumed_at, category, error, message_key, message)
+		VALUES ('topic', 0, 1, CURRENT_TIMESTAMP, 'malformed_message', 'error', 'key', 'message')`,
+	)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 17)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 18)
+	helpers.FailOnError(t, err)
+
+	var message string
+	err = db.QueryRow(`SELECT message FROM consumer_error WHERE topic_offset = 1`).Scan(&message)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "message", message)
+}
+
+// TestAllMigrations_Migration19ReportDeletedAt checks that reports soft-deleted before
+// the migration down are live again after the migration up in SQLite
+func TestAllMigrations_Migration19ReportDeletedAt(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	err := migration.SetDBVersion(db, dbDriver, 19)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`
+		INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at, deleted_at)
+		VALUES (1, 'cluster', '{}', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+	)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 18)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 19)
+	helpers.FailOnError(t, err)
+
+	var deletedAt sql.NullTime
+	err = db.QueryRow(`SELECT deleted_at FROM report WHERE cluster = 'cluster'`).Scan(&deletedAt)
+	helpers.FailOnError(t, err)
+	assert.False(t, deletedAt.Valid)
+}
+
+func TestAllMigrations_Migration19PostgresReportDeletedAt(t *testing.T) {
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report ADD COLUMN deleted_at TIMESTAMP").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig19.StepUp(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE report DROP COLUMN deleted_at").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err = migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig19.StepDown(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+}
+
+// TestAllMigrations_Migration20FeedbackErrorKey checks that the feedback is kept by the migration up
+// and that only the feedback on the whole rules is kept by the migration down
+func TestAllMigrations_Migration20FeedbackErrorKey(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	err := migration.SetDBVersion(db, dbDriver, 19)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`
+		INSERT INTO cluster_rule_user_feedback(cluster_id, rule_id, user_id, user_vote, added_at, updated_at)
+		VALUES ('cluster', 'rule', 'user', 1, '2020-01-01', '2020-01-02')`,
+	)
+	helpers.FailOnError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO cluster_rule_user_message(cluster_id, rule_id, user_id, message, updated_at)
+		VALUES ('cluster', 'rule', 'user', 'message', '2020-01-02')`,
+	)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 20)
+	helpers.FailOnError(t, err)
+
+	var (
+		errorKey string
+		message  string
+	)
+	err = db.QueryRow(`SELECT error_key, message FROM cluster_rule_user_message`).Scan(&errorKey, &message)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "", errorKey)
+	assert.Equal(t, "message", message)
+
+	// feedback on the error key of the same rule coexists with the feedback on the whole rule
+	_, err = db.Exec(`
+		INSERT INTO cluster_rule_user_feedback(cluster_id, rule_id, error_key, user_id, user_vote, added_at, updated_at)
+		VALUES ('cluster', 'rule', 'ERROR_KEY', 'user', -1, '2020-01-01', '2020-01-02')`,
+	)
+	helpers.FailOnError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO cluster_rule_user_message(cluster_id, rule_id, error_key, user_id, message, updated_at)
+		VALUES ('cluster', 'rule', 'ERROR_KEY', 'user', 'error key message', '2020-01-02')`,
+	)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 19)
+	helpers.FailOnError(t, err)
+
+	var votes int
+	err = db.QueryRow(`SELECT COUNT(*) FROM cluster_rule_user_feedback`).Scan(&votes)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, votes)
+
+	var userVote int
+	err = db.QueryRow(`
+		SELECT feedback.user_vote, msg.message FROM cluster_rule_user_feedback feedback
+		JOIN cluster_rule_user_message msg ON msg.cluster_id = feedback.cluster_id
+			AND msg.rule_id = feedback.rule_id AND msg.user_id = feedback.user_id`,
+	).Scan(&userVote, &message)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, userVote)
+	assert.Equal(t, "message", message)
+}
+
+func TestAllMigrations_Migration20PostgresFeedbackErrorKey(t *testing.T) {
+	const constraintsQuery = `SELECT conname FROM pg_constraint WHERE conrelid = $1::regclass AND contype = $2`
+
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_feedback ADD COLUMN error_key VARCHAR NOT NULL DEFAULT ''`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_message ADD COLUMN error_key VARCHAR NOT NULL DEFAULT ''`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectQueryWithArgs(constraintsQuery, "cluster_rule_user_message", "f").
+		WillReturnRows(sqlmock.NewRows([]string{"conname"}).AddRow("cluster_rule_user_message_fkey"))
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_message DROP CONSTRAINT "cluster_rule_user_message_fkey"`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectQueryWithArgs(constraintsQuery, "cluster_rule_user_message", "p").
+		WillReturnRows(sqlmock.NewRows([]string{"conname"}).AddRow("cluster_rule_user_message_pkey"))
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_message DROP CONSTRAINT "cluster_rule_user_message_pkey"`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectQueryWithArgs(constraintsQuery, "cluster_rule_user_feedback", "p").
+		WillReturnRows(sqlmock.NewRows([]string{"conname"}).AddRow("cluster_rule_user_feedback_pkey1"))
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_feedback DROP CONSTRAINT "cluster_rule_user_feedback_pkey1"`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(
+		`ALTER TABLE cluster_rule_user_feedback ADD PRIMARY KEY (cluster_id, rule_id, error_key, user_id)`,
+	).WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(
+		`ALTER TABLE cluster_rule_user_message ADD PRIMARY KEY (cluster_id, rule_id, error_key, user_id)`,
+	).WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`
+		ALTER TABLE cluster_rule_user_message ADD FOREIGN KEY (cluster_id, rule_id, error_key, user_id)
+			REFERENCES cluster_rule_user_feedback(cluster_id, rule_id, error_key, user_id)
+			ON DELETE CASCADE`,
+	).WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig20.StepUp(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs(`DELETE FROM cluster_rule_user_message WHERE error_key <> ''`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`DELETE FROM cluster_rule_user_feedback WHERE error_key <> ''`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_message DROP COLUMN error_key`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_feedback DROP COLUMN error_key`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_feedback ADD PRIMARY KEY (cluster_id, rule_id, user_id)`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`ALTER TABLE cluster_rule_user_message ADD PRIMARY KEY (cluster_id, rule_id, user_id)`).
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`
+		ALTER TABLE cluster_rule_user_message ADD FOREIGN KEY (cluster_id, rule_id, user_id)
+			REFERENCES cluster_rule_user_feedback(cluster_id, rule_id, user_id)
+			ON DELETE CASCADE`,
+	).WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err = migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig20.StepDown(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+}
+
+func TestAllMigrations_Migration21TableAuditLogAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// columns of the index created by the migration are required
+	_, err := db.Exec(`CREATE TABLE audit_log(c INTEGER, created_at TIMESTAMP);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 21)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "audit_log")
+}
+
+func TestAllMigrations_Migration21TableAuditLogDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE audit_log;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: audit_log")
+}
+
+func TestAllMigrations_Migration22TableClusterRuleToggleAlreadyExists(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	_, err := db.Exec(`CREATE TABLE cluster_rule_toggle(c INTEGER);`)
+	helpers.FailOnError(t, err)
+
+	// the existing table is kept by the migration instead of failing it
+	err = migration.SetDBVersion(db, dbDriver, 22)
+	helpers.FailOnError(t, err)
+	assertTableKept(t, db, "cluster_rule_toggle")
+}
+
+func TestAllMigrations_Migration22TableClusterRuleToggleDoesNotExist(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	// set to the latest version
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`DROP TABLE cluster_rule_toggle;`)
+	helpers.FailOnError(t, err)
+
+	// try to set to the first version
+	err = migration.SetDBVersion(db, dbDriver, 0)
+	assert.EqualError(t, err, "no such table: cluster_rule_toggle")
+}
+
+// TestAllMigrations_Migration23ToggleJustification checks that justifications of toggles are kept
+// by the migration down and up in SQLite and that toggles written before the migration have empty one
+func TestAllMigrations_Migration23ToggleJustification(t *testing.T) {
+	db := prepareDBAndInfo(t)
+	defer closeDB(t, db)
+
+	err := migration.SetDBVersion(db, dbDriver, 22)
+	helpers.FailOnError(t, err)
+
+	_, err = db.Exec(`
+		INSERT INTO cluster_rule_toggle(cluster_id, rule_id, user_id, disabled, updated_at)
+		VALUES ('cluster', 'rule', 'user', 1, CURRENT_TIMESTAMP)`,
+	)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 23)
+	helpers.FailOnError(t, err)
+
+	var justification string
+	err = db.QueryRow(`SELECT justification FROM cluster_rule_toggle WHERE cluster_id = 'cluster'`).
+		Scan(&justification)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "", justification)
+
+	_, err = db.Exec(`UPDATE cluster_rule_toggle SET justification = 'justification'`)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 22)
+	helpers.FailOnError(t, err)
+
+	err = migration.SetDBVersion(db, dbDriver, 23)
+	helpers.FailOnError(t, err)
+
+	err = db.QueryRow(`SELECT justification FROM cluster_rule_toggle WHERE cluster_id = 'cluster'`).
+		Scan(&justification)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "justification", justification)
+}
+
+func TestAllMigrations_Migration23PostgresToggleJustification(t *testing.T) {
+	db, expects := helpers.MustGetMockDBWithStrictExpects(t)
+	defer helpers.MustCloseMockDBWithExpects(t, db, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs(
+		"ALTER TABLE cluster_rule_toggle ADD COLUMN justification VARCHAR NOT NULL DEFAULT ''",
+	).WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err := migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig23.StepUp(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs("ALTER TABLE cluster_rule_toggle DROP COLUMN justification").
+		WillReturnResult(sql_driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	err = migration.WithTransaction(db, func(tx *sql.Tx) error {
+		return migration.Mig23.StepDown(tx, types.DBDriverPostgres)
+	})
+	helpers.FailOnError(t, err)
+}
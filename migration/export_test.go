@@ -20,4 +20,11 @@ package migration
 var (
 	Migrations      = &migrations
 	WithTransaction = withTransaction
+	Mig5            = mig5
+	Mig8            = mig8
+	Mig14           = mig14
+	Mig15           = mig15
+	Mig19           = mig19
+	Mig20           = mig20
+	Mig23           = mig23
 )
@@ -25,6 +25,8 @@ package migration
 import (
 	"database/sql"
 	"fmt"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 // Version represents a version of the database.
@@ -32,7 +34,8 @@ type Version uint
 
 // Step represents an action performed to either increase
 // or decrease the migration version of the database.
-type Step func(tx *sql.Tx) error
+// The driver can be used to run statements specific to the database in use.
+type Step func(tx *sql.Tx, driver types.DBDriver) error
 
 // Migration type describes a single Migration.
 type Migration struct {
@@ -47,6 +50,25 @@ var migrations = []Migration{
 	mig2,
 	mig3,
 	mig4,
+	mig5,
+	mig6,
+	mig7,
+	mig8,
+	mig9,
+	mig10,
+	mig11,
+	mig12,
+	mig13,
+	mig14,
+	mig15,
+	mig16,
+	mig17,
+	mig18,
+	mig19,
+	mig20,
+	mig21,
+	mig22,
+	mig23,
 }
 
 // GetMaxVersion returns the highest available migration version.
@@ -103,7 +125,7 @@ func GetDBVersion(db *sql.DB) (Version, error) {
 
 // SetDBVersion attempts to get the database into the specified
 // target version using available migration steps.
-func SetDBVersion(db *sql.DB, targetVer Version) error {
+func SetDBVersion(db *sql.DB, dbDriver types.DBDriver, targetVer Version) error {
 	maxVer := GetMaxVersion()
 	if targetVer > maxVer {
 		return fmt.Errorf("invalid target version (available version range is 0-%d)", maxVer)
@@ -120,7 +142,7 @@ func SetDBVersion(db *sql.DB, targetVer Version) error {
 		return fmt.Errorf("current version (%d) is outside of available migration boundaries", currentVer)
 	}
 
-	return execStepsInTx(db, currentVer, targetVer)
+	return execStepsInTx(db, dbDriver, currentVer, targetVer)
 }
 
 // updateVersionInDB updates the migration version number in the migration info table.
@@ -145,7 +167,7 @@ func updateVersionInDB(tx *sql.Tx, newVersion Version) error {
 }
 
 // execStepsInTx executes the necessary migration steps in a single transaction.
-func execStepsInTx(db *sql.DB, currentVer, targetVer Version) error {
+func execStepsInTx(db *sql.DB, dbDriver types.DBDriver, currentVer, targetVer Version) error {
 	// Already at target version.
 	if currentVer == targetVer {
 		return nil
@@ -154,7 +176,7 @@ func execStepsInTx(db *sql.DB, currentVer, targetVer Version) error {
 	return withTransaction(db, func(tx *sql.Tx) error {
 		// Upgrade to target version.
 		for currentVer < targetVer {
-			if err := migrations[currentVer].StepUp(tx); err != nil {
+			if err := migrations[currentVer].StepUp(tx, dbDriver); err != nil {
 				return err
 			}
 			currentVer++
@@ -162,7 +184,7 @@ func execStepsInTx(db *sql.DB, currentVer, targetVer Version) error {
 
 		// Downgrade to target version.
 		for currentVer > targetVer {
-			if err := migrations[currentVer-1].StepDown(tx); err != nil {
+			if err := migrations[currentVer-1].StepDown(tx, dbDriver); err != nil {
 				return err
 			}
 			currentVer--
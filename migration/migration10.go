@@ -0,0 +1,50 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration10 adds table rule_ack with rules acknowledged by organizations for all their clusters.
+org_id is BIGINT right away, because PostgreSQL INTEGER can't hold all organization IDs (see migration8)
+*/
+
+var mig10 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS rule_ack (
+				org_id        BIGINT NOT NULL,
+				rule_id       VARCHAR NOT NULL,
+				user_id       VARCHAR NOT NULL,
+				justification VARCHAR NOT NULL,
+				created_at    TIMESTAMP NOT NULL,
+				updated_at    TIMESTAMP NOT NULL,
+
+				PRIMARY KEY(org_id, rule_id)
+			)
+		`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`DROP TABLE rule_ack`)
+		return err
+	},
+}
@@ -0,0 +1,49 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration11 adds table consistency_issue with reports whose data derived from the report blob
+(currently rows of rule_hit table) have been found inconsistent with it by the consistency check.
+*/
+
+var mig11 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS consistency_issue (
+				org_id      BIGINT NOT NULL,
+				cluster     VARCHAR NOT NULL,
+				description VARCHAR NOT NULL,
+				repaired    BOOLEAN NOT NULL,
+				detected_at TIMESTAMP NOT NULL,
+
+				PRIMARY KEY(org_id, cluster)
+			)
+		`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`DROP TABLE consistency_issue`)
+		return err
+	},
+}
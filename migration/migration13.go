@@ -0,0 +1,116 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration13 moves feedback messages from cluster_rule_user_feedback into a new table
+cluster_rule_user_message, so changing the vote doesn't rewrite the message.
+Only non-empty messages are moved. The migration down puts messages back
+(empty string for feedback without message).
+*/
+
+var mig13 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		// it's better to use ALTER TABLE table_name DROP COLUMN but sqlite doesn't support it
+		return execStatements(tx, []string{
+			`ALTER TABLE cluster_rule_user_feedback RENAME TO cluster_rule_user_feedback_tmp;`,
+			`CREATE TABLE cluster_rule_user_feedback (
+				cluster_id VARCHAR NOT NULL,
+				rule_id VARCHAR NOT NULL,
+				user_id VARCHAR NOT NULL,
+				user_vote SMALLINT NOT NULL,
+				added_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL,
+
+				PRIMARY KEY(cluster_id, rule_id, user_id),
+				FOREIGN KEY (cluster_id)
+					REFERENCES report(cluster)
+					ON DELETE CASCADE,
+				FOREIGN KEY (rule_id)
+					REFERENCES rule(module)
+					ON DELETE CASCADE
+			);`,
+			`INSERT INTO cluster_rule_user_feedback
+				SELECT cluster_id, rule_id, user_id, user_vote, added_at, updated_at
+				FROM cluster_rule_user_feedback_tmp;`,
+			`CREATE TABLE cluster_rule_user_message (
+				cluster_id VARCHAR NOT NULL,
+				rule_id VARCHAR NOT NULL,
+				user_id VARCHAR NOT NULL,
+				message VARCHAR NOT NULL,
+				updated_at TIMESTAMP NOT NULL,
+
+				PRIMARY KEY(cluster_id, rule_id, user_id),
+				FOREIGN KEY (cluster_id, rule_id, user_id)
+					REFERENCES cluster_rule_user_feedback(cluster_id, rule_id, user_id)
+					ON DELETE CASCADE
+			);`,
+			`INSERT INTO cluster_rule_user_message
+				SELECT cluster_id, rule_id, user_id, message, updated_at
+				FROM cluster_rule_user_feedback_tmp
+				WHERE message <> '';`,
+			`DROP TABLE cluster_rule_user_feedback_tmp;`,
+		})
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		return execStatements(tx, []string{
+			`ALTER TABLE cluster_rule_user_feedback RENAME TO cluster_rule_user_feedback_tmp;`,
+			`CREATE TABLE cluster_rule_user_feedback (
+				cluster_id VARCHAR NOT NULL,
+				rule_id VARCHAR NOT NULL,
+				user_id VARCHAR NOT NULL,
+				message VARCHAR NOT NULL,
+				user_vote SMALLINT NOT NULL,
+				added_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL,
+
+				PRIMARY KEY(cluster_id, rule_id, user_id),
+				FOREIGN KEY (cluster_id)
+					REFERENCES report(cluster)
+					ON DELETE CASCADE,
+				FOREIGN KEY (rule_id)
+					REFERENCES rule(module)
+					ON DELETE CASCADE
+			);`,
+			`INSERT INTO cluster_rule_user_feedback
+				SELECT feedback.cluster_id, feedback.rule_id, feedback.user_id, COALESCE(msg.message, ''),
+					feedback.user_vote, feedback.added_at, feedback.updated_at
+				FROM cluster_rule_user_feedback_tmp feedback
+				LEFT JOIN cluster_rule_user_message msg ON msg.cluster_id = feedback.cluster_id
+					AND msg.rule_id = feedback.rule_id AND msg.user_id = feedback.user_id;`,
+			`DROP TABLE cluster_rule_user_message;`,
+			`DROP TABLE cluster_rule_user_feedback_tmp;`,
+		})
+	},
+}
+
+// execStatements executes the statements one by one in the transaction, the first error is returned
+func execStatements(tx *sql.Tx, statements []string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
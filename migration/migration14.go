@@ -0,0 +1,84 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration14 adds kafka_offset column to report table. The column contains offset of the Kafka
+message the report was consumed from, so already processed messages aren't written again.
+The column is NULL for reports which were not consumed from Kafka. SQLite doesn't support
+dropping of columns, so the column is kept there by the migration down (it's not used by older
+versions of the storage) and the migration up adds it only when it doesn't exist.
+*/
+
+var mig14 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver == types.DBDriverSQLite3 {
+			exists, err := sqliteColumnExists(tx, "report", "kafka_offset")
+			if err != nil || exists {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report ADD COLUMN kafka_offset BIGINT`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver != types.DBDriverPostgres {
+			return nil
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report DROP COLUMN kafka_offset`)
+		return err
+	},
+}
+
+// sqliteColumnExists checks whether the table has the column in SQLite database
+func sqliteColumnExists(tx *sql.Tx, table, column string) (exists bool, err error) {
+	rows, err := tx.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			name    string
+			ignored interface{}
+		)
+
+		err := rows.Scan(&ignored, &name, &ignored, &ignored, &ignored, &ignored)
+		if err != nil {
+			return false, err
+		}
+
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
@@ -0,0 +1,52 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration15 adds report_checksum column to report table. The column contains SHA-256 checksum
+of the report, so identical reports sent repeatedly by the same cluster aren't rewritten.
+The column is NULL for reports written before the migration. Like in migration14, the column
+is kept in SQLite by the migration down and it's added only when it doesn't exist.
+*/
+
+var mig15 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver == types.DBDriverSQLite3 {
+			exists, err := sqliteColumnExists(tx, "report", "report_checksum")
+			if err != nil || exists {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report ADD COLUMN report_checksum VARCHAR`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver != types.DBDriverPostgres {
+			return nil
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report DROP COLUMN report_checksum`)
+		return err
+	},
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration16 adds table consumer_error which keeps failures of processing of messages consumed
+from Kafka. Organization and cluster are NULL when they can't be read from the message.
+The category column contains sanitized category of the error which can be shown to users,
+while the error column contains the original error for debugging purposes.
+*/
+
+var mig16 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		return execStatements(tx, []string{
+			`CREATE TABLE IF NOT EXISTS consumer_error (
+				topic        VARCHAR NOT NULL,
+				partition    INTEGER NOT NULL,
+				topic_offset BIGINT NOT NULL,
+				org_id       BIGINT,
+				cluster      VARCHAR,
+				consumed_at  TIMESTAMP NOT NULL,
+				category     VARCHAR NOT NULL,
+				error        VARCHAR NOT NULL,
+
+				PRIMARY KEY(topic, partition, topic_offset)
+			);`,
+			`CREATE INDEX IF NOT EXISTS consumer_error_cluster_idx ON consumer_error (cluster, consumed_at);`,
+		})
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`DROP TABLE consumer_error`)
+		return err
+	},
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration18 adds message_key and message columns to consumer_error table. The columns
+contain key and (truncated) value of the message which failed to be processed, so the
+message can be inspected later. Index of the failures by the time of consumption is added
+to list the latest failures and to delete the old ones. SQLite doesn't support dropping
+of columns, so the columns are kept there by the migration down.
+*/
+
+var mig18 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		for _, column := range []string{"message_key", "message"} {
+			if driver == types.DBDriverSQLite3 {
+				exists, err := sqliteColumnExists(tx, "consumer_error", column)
+				if err != nil {
+					return err
+				}
+				if exists {
+					continue
+				}
+			}
+
+			if _, err := tx.Exec(`ALTER TABLE consumer_error ADD COLUMN ` + column + ` VARCHAR`); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS consumer_error_consumed_at_idx ON consumer_error (consumed_at);`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		if _, err := tx.Exec(`DROP INDEX IF EXISTS consumer_error_consumed_at_idx`); err != nil {
+			return err
+		}
+
+		if driver != types.DBDriverPostgres {
+			return nil
+		}
+
+		return execStatements(tx, []string{
+			`ALTER TABLE consumer_error DROP COLUMN message`,
+			`ALTER TABLE consumer_error DROP COLUMN message_key`,
+		})
+	},
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration19 adds deleted_at column to report table. The column contains the time when
+the report was soft-deleted, it's NULL for live reports. Soft-deleted reports are not visible
+to reads of the storage. SQLite doesn't support dropping of columns, so the column is kept
+there by the migration down. Soft-deleted reports become visible again to older versions
+of the storage in both databases, so the kept column is reset by the migration up in SQLite.
+*/
+
+var mig19 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver == types.DBDriverSQLite3 {
+			exists, err := sqliteColumnExists(tx, "report", "deleted_at")
+			if err != nil {
+				return err
+			}
+			if exists {
+				_, err = tx.Exec(`UPDATE report SET deleted_at = NULL`)
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report ADD COLUMN deleted_at TIMESTAMP`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver != types.DBDriverPostgres {
+			return nil
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report DROP COLUMN deleted_at`)
+		return err
+	},
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration20 adds error_key column to cluster_rule_user_feedback and cluster_rule_user_message tables
+and makes it a part of their primary keys, so users can vote on and comment on the individual error keys
+of a rule. Empty error key means that the feedback is on the whole rule, which is the case of all the existing
+feedback. The migration down deletes feedback on error keys. SQLite doesn't support changing of primary keys,
+so the tables are recreated there, while PostgreSQL tables are altered and foreign keys referencing
+the report table are kept as they are (they don't exist when the report table is partitioned).
+*/
+
+var mig20 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver == types.DBDriverPostgres {
+			return mig20PostgresStepUp(tx)
+		}
+
+		return recreateFeedbackTables(tx, feedbackTablesWithErrorKey, `
+			INSERT INTO cluster_rule_user_feedback
+				(cluster_id, rule_id, error_key, user_id, user_vote, added_at, updated_at)
+			SELECT cluster_id, rule_id, '', user_id, user_vote, added_at, updated_at
+			FROM cluster_rule_user_feedback_tmp;`, `
+			INSERT INTO cluster_rule_user_message (cluster_id, rule_id, error_key, user_id, message, updated_at)
+			SELECT cluster_id, rule_id, '', user_id, message, updated_at
+			FROM cluster_rule_user_message_tmp;`,
+		)
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver == types.DBDriverPostgres {
+			return execStatements(tx, []string{
+				`DELETE FROM cluster_rule_user_message WHERE error_key <> ''`,
+				`DELETE FROM cluster_rule_user_feedback WHERE error_key <> ''`,
+				// primary keys and the foreign key including the column are dropped together with it
+				`ALTER TABLE cluster_rule_user_message DROP COLUMN error_key`,
+				`ALTER TABLE cluster_rule_user_feedback DROP COLUMN error_key`,
+				`ALTER TABLE cluster_rule_user_feedback ADD PRIMARY KEY (cluster_id, rule_id, user_id)`,
+				`ALTER TABLE cluster_rule_user_message ADD PRIMARY KEY (cluster_id, rule_id, user_id)`,
+				`ALTER TABLE cluster_rule_user_message ADD FOREIGN KEY (cluster_id, rule_id, user_id)
+					REFERENCES cluster_rule_user_feedback(cluster_id, rule_id, user_id)
+					ON DELETE CASCADE`,
+			})
+		}
+
+		return recreateFeedbackTables(tx, feedbackTablesWithoutErrorKey, `
+			INSERT INTO cluster_rule_user_feedback (cluster_id, rule_id, user_id, user_vote, added_at, updated_at)
+			SELECT cluster_id, rule_id, user_id, user_vote, added_at, updated_at
+			FROM cluster_rule_user_feedback_tmp WHERE error_key = '';`, `
+			INSERT INTO cluster_rule_user_message (cluster_id, rule_id, user_id, message, updated_at)
+			SELECT cluster_id, rule_id, user_id, message, updated_at
+			FROM cluster_rule_user_message_tmp WHERE error_key = '';`,
+		)
+	},
+}
+
+// feedbackTablesWithErrorKey creates feedback tables with error_key column
+var feedbackTablesWithErrorKey = []string{
+	`CREATE TABLE cluster_rule_user_feedback (
+		cluster_id VARCHAR NOT NULL,
+		rule_id VARCHAR NOT NULL,
+		error_key VARCHAR NOT NULL DEFAULT '',
+		user_id VARCHAR NOT NULL,
+		user_vote SMALLINT NOT NULL,
+		added_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+
+		PRIMARY KEY(cluster_id, rule_id, error_key, user_id),
+		FOREIGN KEY (cluster_id)
+			REFERENCES report(cluster)
+			ON DELETE CASCADE,
+		FOREIGN KEY (rule_id)
+			REFERENCES rule(module)
+			ON DELETE CASCADE
+	);`,
+	`CREATE TABLE cluster_rule_user_message (
+		cluster_id VARCHAR NOT NULL,
+		rule_id VARCHAR NOT NULL,
+		error_key VARCHAR NOT NULL DEFAULT '',
+		user_id VARCHAR NOT NULL,
+		message VARCHAR NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+
+		PRIMARY KEY(cluster_id, rule_id, error_key, user_id),
+		FOREIGN KEY (cluster_id, rule_id, error_key, user_id)
+			REFERENCES cluster_rule_user_feedback(cluster_id, rule_id, error_key, user_id)
+			ON DELETE CASCADE
+	);`,
+}
+
+// feedbackTablesWithoutErrorKey creates feedback tables as they were created by migration13
+var feedbackTablesWithoutErrorKey = []string{
+	`CREATE TABLE cluster_rule_user_feedback (
+		cluster_id VARCHAR NOT NULL,
+		rule_id VARCHAR NOT NULL,
+		user_id VARCHAR NOT NULL,
+		user_vote SMALLINT NOT NULL,
+		added_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+
+		PRIMARY KEY(cluster_id, rule_id, user_id),
+		FOREIGN KEY (cluster_id)
+			REFERENCES report(cluster)
+			ON DELETE CASCADE,
+		FOREIGN KEY (rule_id)
+			REFERENCES rule(module)
+			ON DELETE CASCADE
+	);`,
+	`CREATE TABLE cluster_rule_user_message (
+		cluster_id VARCHAR NOT NULL,
+		rule_id VARCHAR NOT NULL,
+		user_id VARCHAR NOT NULL,
+		message VARCHAR NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+
+		PRIMARY KEY(cluster_id, rule_id, user_id),
+		FOREIGN KEY (cluster_id, rule_id, user_id)
+			REFERENCES cluster_rule_user_feedback(cluster_id, rule_id, user_id)
+			ON DELETE CASCADE
+	);`,
+}
+
+// recreateFeedbackTables replaces feedback tables by the ones created by the given statements,
+// the rows are copied from the original tables by copyFeedback and copyMessages statements
+func recreateFeedbackTables(tx *sql.Tx, createTables []string, copyFeedback, copyMessages string) error {
+	statements := []string{
+		`ALTER TABLE cluster_rule_user_message RENAME TO cluster_rule_user_message_tmp;`,
+		`ALTER TABLE cluster_rule_user_feedback RENAME TO cluster_rule_user_feedback_tmp;`,
+	}
+	statements = append(statements, createTables...)
+	statements = append(statements,
+		copyFeedback,
+		copyMessages,
+		`DROP TABLE cluster_rule_user_message_tmp;`,
+		`DROP TABLE cluster_rule_user_feedback_tmp;`,
+	)
+
+	return execStatements(tx, statements)
+}
+
+// mig20PostgresStepUp adds error_key column into primary keys of feedback tables in PostgreSQL,
+// names of the constraints are looked up, because they differ between databases
+// depending on the history of the tables
+func mig20PostgresStepUp(tx *sql.Tx) error {
+	err := execStatements(tx, []string{
+		`ALTER TABLE cluster_rule_user_feedback ADD COLUMN error_key VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE cluster_rule_user_message ADD COLUMN error_key VARCHAR NOT NULL DEFAULT ''`,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the foreign key of messages references the primary key of votes, so it goes first
+	for _, constraint := range []struct{ table, constraintType string }{
+		{"cluster_rule_user_message", "f"},
+		{"cluster_rule_user_message", "p"},
+		{"cluster_rule_user_feedback", "p"},
+	} {
+		if err := dropPostgresConstraints(tx, constraint.table, constraint.constraintType); err != nil {
+			return err
+		}
+	}
+
+	return execStatements(tx, []string{
+		`ALTER TABLE cluster_rule_user_feedback ADD PRIMARY KEY (cluster_id, rule_id, error_key, user_id)`,
+		`ALTER TABLE cluster_rule_user_message ADD PRIMARY KEY (cluster_id, rule_id, error_key, user_id)`,
+		`ALTER TABLE cluster_rule_user_message ADD FOREIGN KEY (cluster_id, rule_id, error_key, user_id)
+			REFERENCES cluster_rule_user_feedback(cluster_id, rule_id, error_key, user_id)
+			ON DELETE CASCADE`,
+	})
+}
+
+// dropPostgresConstraints drops all constraints of the given type ("p" for primary key, "f" for foreign keys)
+// of the table in PostgreSQL
+func dropPostgresConstraints(tx *sql.Tx, table, constraintType string) error {
+	rows, err := tx.Query(
+		`SELECT conname FROM pg_constraint WHERE conrelid = $1::regclass AND contype = $2`,
+		table, constraintType,
+	)
+	if err != nil {
+		return err
+	}
+
+	var statements []string
+	for rows.Next() {
+		var constraint string
+		if err := rows.Scan(&constraint); err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		statements = append(statements, `ALTER TABLE `+table+` DROP CONSTRAINT "`+constraint+`"`)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return execStatements(tx, statements)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration21 adds table audit_log which keeps the trail of destructive operations of the storage,
+i.e. deletions of reports of organizations and clusters, of rules and of rules acked or disabled
+by organizations. Organization, cluster and rule are NULL when the operation doesn't affect them,
+the user is empty when the requesting user is not known.
+*/
+
+var mig21 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		return execStatements(tx, []string{
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				operation    VARCHAR NOT NULL,
+				org_id       BIGINT,
+				cluster      VARCHAR,
+				rule_id      VARCHAR,
+				user_id      VARCHAR NOT NULL,
+				deleted_rows INTEGER NOT NULL,
+				created_at   TIMESTAMP NOT NULL
+			);`,
+			`CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);`,
+		})
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`DROP TABLE audit_log`)
+		return err
+	},
+}
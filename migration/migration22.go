@@ -0,0 +1,49 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration22 adds table cluster_rule_toggle with rules enabled or disabled for single clusters,
+the toggle of the cluster takes precedence over the rule disabled for the whole organization
+*/
+
+var mig22 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS cluster_rule_toggle (
+				cluster_id VARCHAR NOT NULL,
+				rule_id    VARCHAR NOT NULL,
+				user_id    VARCHAR NOT NULL,
+				disabled   SMALLINT NOT NULL,
+				updated_at TIMESTAMP NOT NULL,
+
+				PRIMARY KEY(cluster_id, rule_id)
+			)
+		`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`DROP TABLE cluster_rule_toggle`)
+		return err
+	},
+}
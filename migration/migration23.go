@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration23 adds justification column to cluster_rule_toggle table with the reason why the user
+toggled the rule for the cluster, it's empty for the existing toggles. SQLite doesn't support dropping
+of columns, so the column is kept there by the migration down and it isn't added again by the migration up.
+*/
+
+var mig23 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver == types.DBDriverSQLite3 {
+			exists, err := sqliteColumnExists(tx, "cluster_rule_toggle", "justification")
+			if err != nil || exists {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`ALTER TABLE cluster_rule_toggle ADD COLUMN justification VARCHAR NOT NULL DEFAULT ''`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver != types.DBDriverPostgres {
+			return nil
+		}
+
+		_, err := tx.Exec(`ALTER TABLE cluster_rule_toggle DROP COLUMN justification`)
+		return err
+	},
+}
@@ -18,10 +18,12 @@ package migration
 
 import (
 	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 var mig3 = Migration{
-	StepUp: func(tx *sql.Tx) error {
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
 		_, err := tx.Exec(`
 			CREATE TABLE cluster_rule_user_feedback (
 				cluster_id VARCHAR NOT NULL,
@@ -36,7 +38,7 @@ var mig3 = Migration{
 			)`)
 		return err
 	},
-	StepDown: func(tx *sql.Tx) error {
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
 		_, err := tx.Exec(`DROP TABLE cluster_rule_user_feedback`)
 		return err
 	},
@@ -18,6 +18,8 @@ package migration
 
 import (
 	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 /*
@@ -26,7 +28,7 @@ migration4 adds foreign keys to cluster_rule_user_feedback
 
 // TODO: write tests for this one
 var mig4 = Migration{
-	StepUp: func(tx *sql.Tx) error {
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
 		// it's better to use ALTER TABLE table_name ADD CONSTRAINT but sqlite doesn't support it
 
 		_, err := tx.Exec(`ALTER TABLE cluster_rule_user_feedback RENAME TO cluster_rule_user_feedback_tmp;`)
@@ -69,7 +71,7 @@ var mig4 = Migration{
 
 		return nil
 	},
-	StepDown: func(tx *sql.Tx) error {
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
 		_, err := tx.Exec(`ALTER TABLE cluster_rule_user_feedback RENAME TO cluster_rule_user_feedback_tmp;`)
 		if err != nil {
 			return err
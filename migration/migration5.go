@@ -0,0 +1,47 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration5 changes type of report column to JSONB on PostgreSQL
+so it's possible to query inside the report. Other databases are not changed.
+*/
+
+var mig5 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver != types.DBDriverPostgres {
+			return nil
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report ALTER COLUMN report TYPE JSONB USING report::JSONB`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		if driver != types.DBDriverPostgres {
+			return nil
+		}
+
+		_, err := tx.Exec(`ALTER TABLE report ALTER COLUMN report TYPE VARCHAR USING report::VARCHAR`)
+		return err
+	},
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration6 adds table report_history which keeps older reports for each cluster
+*/
+
+var mig6 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS report_history (
+				org_id          INTEGER NOT NULL,
+				cluster         VARCHAR NOT NULL,
+				report          VARCHAR NOT NULL,
+				last_checked_at TIMESTAMP NOT NULL,
+
+				PRIMARY KEY(org_id, cluster, last_checked_at)
+			)
+		`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`DROP TABLE report_history`)
+		return err
+	},
+}
@@ -0,0 +1,48 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration7 adds table rule_hit with one row for each rule hit by the latest report of the cluster
+*/
+
+var mig7 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS rule_hit (
+				org_id        INTEGER NOT NULL,
+				cluster       VARCHAR NOT NULL,
+				rule_fqdn     VARCHAR NOT NULL,
+				error_key     VARCHAR NOT NULL,
+				template_data VARCHAR NOT NULL,
+
+				PRIMARY KEY(org_id, cluster, rule_fqdn, error_key)
+			)
+		`)
+		return err
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		_, err := tx.Exec(`DROP TABLE rule_hit`)
+		return err
+	},
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+/*
+migration8 changes type of org_id columns to BIGINT on PostgreSQL,
+because PostgreSQL INTEGER is only 32-bit signed and can't hold organization IDs above 2^31.
+INTEGER is already 64-bit in SQLite, so other databases are not changed.
+*/
+
+// tablesWithOrgID contains all tables having org_id column
+var tablesWithOrgID = []string{"report", "report_history", "rule_hit"}
+
+var mig8 = Migration{
+	StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
+		return alterOrgIDColumnsType(tx, driver, "BIGINT")
+	},
+	StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
+		return alterOrgIDColumnsType(tx, driver, "INTEGER")
+	},
+}
+
+func alterOrgIDColumnsType(tx *sql.Tx, driver types.DBDriver, columnType string) error {
+	if driver != types.DBDriverPostgres {
+		return nil
+	}
+
+	for _, table := range tablesWithOrgID {
+		_, err := tx.Exec("ALTER TABLE " + table + " ALTER COLUMN org_id TYPE " + columnType)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -28,30 +28,32 @@ import (
 
 	"github.com/RedHatInsights/insights-results-aggregator/migration"
 	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
 const (
 	dbClosedErrorMsg    = "sql: database is closed"
 	noSuchTableErrorMsg = "no such table: migration_info"
 	stepErrorMsg        = "migration Step Error"
+	dbDriver            = types.DBDriverSQLite3
 )
 
 var (
-	stepNoopFn = func(tx *sql.Tx) error {
+	stepNoopFn = func(tx *sql.Tx, driver types.DBDriver) error {
 		return nil
 	}
-	stepErrorFn = func(tx *sql.Tx) error {
+	stepErrorFn = func(tx *sql.Tx, driver types.DBDriver) error {
 		return fmt.Errorf(stepErrorMsg)
 	}
-	stepRollbackFn = func(tx *sql.Tx) error {
+	stepRollbackFn = func(tx *sql.Tx, driver types.DBDriver) error {
 		return tx.Rollback()
 	}
 	testMigration = migration.Migration{
-		StepUp: func(tx *sql.Tx) error {
+		StepUp: func(tx *sql.Tx, driver types.DBDriver) error {
 			_, err := tx.Exec("CREATE TABLE migration_test_table (col INTEGER)")
 			return err
 		},
-		StepDown: func(tx *sql.Tx) error {
+		StepDown: func(tx *sql.Tx, driver types.DBDriver) error {
 			_, err := tx.Exec("DROP TABLE migration_test_table")
 			return err
 		},
@@ -99,14 +101,14 @@ func TestMigrationFull(t *testing.T) {
 }
 
 func stepUpAndDown(t *testing.T, db *sql.DB, upVer, downVer migration.Version) {
-	err := migration.SetDBVersion(db, upVer)
+	err := migration.SetDBVersion(db, dbDriver, upVer)
 	helpers.FailOnError(t, err)
 
 	currentVer, err := migration.GetDBVersion(db)
 	helpers.FailOnError(t, err)
 	assert.Equal(t, upVer, currentVer, "unexpected version")
 
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	helpers.FailOnError(t, err)
 
 	currentVer, err = migration.GetDBVersion(db)
@@ -220,7 +222,7 @@ func TestMigrationSetVersion(t *testing.T) {
 	defer closeDB(t, db)
 
 	// Step-up from 0 to 1.
-	err := migration.SetDBVersion(db, 1)
+	err := migration.SetDBVersion(db, dbDriver, 1)
 	helpers.FailOnError(t, err)
 
 	version, err := migration.GetDBVersion(db)
@@ -229,7 +231,7 @@ func TestMigrationSetVersion(t *testing.T) {
 	assert.Equal(t, migration.Version(1), version, "unexpected database version")
 
 	// Step-down from 1 to 0.
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	helpers.FailOnError(t, err)
 
 	version, err = migration.GetDBVersion(db)
@@ -255,11 +257,11 @@ func TestMigrationSetVersionSame(t *testing.T) {
 	defer closeDB(t, db)
 
 	// Step-up from 0 to 1.
-	err := migration.SetDBVersion(db, 1)
+	err := migration.SetDBVersion(db, dbDriver, 1)
 	helpers.FailOnError(t, err)
 
 	// Set version to.
-	err = migration.SetDBVersion(db, 1)
+	err = migration.SetDBVersion(db, dbDriver, 1)
 	helpers.FailOnError(t, err)
 
 	version, err := migration.GetDBVersion(db)
@@ -273,7 +275,7 @@ func TestMigrationSetVersionTargetTooHigh(t *testing.T) {
 	defer closeDB(t, db)
 
 	// Step-up from 0 to 2 (impossible -- only 1 migration is available).
-	err := migration.SetDBVersion(db, 2)
+	err := migration.SetDBVersion(db, dbDriver, 2)
 	assert.EqualError(t, err, "invalid target version (available version range is 0-1)")
 }
 
@@ -289,7 +291,7 @@ func TestMigrationSetVersionUpError(t *testing.T) {
 		},
 	}
 
-	err := migration.SetDBVersion(db, 1)
+	err := migration.SetDBVersion(db, dbDriver, 1)
 	assert.EqualError(t, err, stepErrorMsg)
 }
 
@@ -306,10 +308,10 @@ func TestMigrationSetVersionDownError(t *testing.T) {
 	}
 
 	// First we need to step-up before we can step-down.
-	err := migration.SetDBVersion(db, 1)
+	err := migration.SetDBVersion(db, dbDriver, 1)
 	helpers.FailOnError(t, err)
 
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	assert.EqualError(t, err, stepErrorMsg)
 }
 
@@ -323,7 +325,7 @@ func TestMigrationSetVersionCurrentTooHighError(t *testing.T) {
 	helpers.FailOnError(t, err)
 
 	const expectedErrStr = "current version (10) is outside of available migration boundaries"
-	err = migration.SetDBVersion(db, 0)
+	err = migration.SetDBVersion(db, dbDriver, 0)
 	assert.EqualError(t, err, expectedErrStr)
 }
 
@@ -350,7 +352,7 @@ func TestMigrationSetVersionClosedDB(t *testing.T) {
 	// Intentionally no `defer` here.
 	closeDB(t, db)
 
-	err := migration.SetDBVersion(db, 0)
+	err := migration.SetDBVersion(db, dbDriver, 0)
 	assert.EqualError(t, err, dbClosedErrorMsg)
 }
 
@@ -364,7 +366,7 @@ func TestMigrationInitRollbackStep(t *testing.T) {
 	}}
 
 	const expectedErrStr = "sql: transaction has already been committed or rolled back"
-	err := migration.SetDBVersion(db, 1)
+	err := migration.SetDBVersion(db, dbDriver, 1)
 	assert.EqualError(t, err, expectedErrStr)
 }
 
@@ -459,7 +461,7 @@ func TestUpdateVersionInDB_RowsAffectedError(t *testing.T) {
 		WithArgs(1).
 		WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf(errStr)))
 
-	err := migration.SetDBVersion(db, migration.GetMaxVersion())
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	assert.EqualError(t, err, errStr)
 }
 
@@ -474,7 +476,7 @@ func TestUpdateVersionInDB_MoreThan1RowAffected(t *testing.T) {
 	// set test migrations
 	*migration.Migrations = []migration.Migration{testMigration}
 
-	err := migration.SetDBVersion(db, migration.GetMaxVersion())
+	err := migration.SetDBVersion(db, dbDriver, migration.GetMaxVersion())
 	assert.EqualError(
 		t, err, "unexpected number of affected rows in migration info table (expected: 1, reality: 2)",
 	)
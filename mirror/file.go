@@ -0,0 +1,200 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	mirrorFilePrefix = "mirror-"
+	mirrorFileSuffix = ".ndjson"
+)
+
+// mirrorFile is a file written by FileTarget
+type mirrorFile struct {
+	name string
+	size int64
+}
+
+// FileTarget writes mirrored messages into NDJSON files in the directory. A new file is started
+// when the current one would exceed maxFileSize and the oldest files are deleted when size
+// of all files would exceed maxDiskUsage. Files are numbered, so the numbering continues
+// after restart and files written before the restart count into the disk usage.
+type FileTarget struct {
+	directory    string
+	maxFileSize  int64
+	maxDiskUsage int64
+	// files are ordered from the oldest, the last one is the current file when it's open
+	files     []mirrorFile
+	usage     int64
+	current   *os.File
+	nextIndex uint64
+}
+
+// NewFileTarget constructs target writing into files in the directory, the directory
+// is created when it doesn't exist
+func NewFileTarget(directory string, maxFileSize, maxDiskUsage int64) (*FileTarget, error) {
+	if maxFileSize <= 0 {
+		return nil, fmt.Errorf("max size of mirror file has to be positive, got %v", maxFileSize)
+	}
+	if maxDiskUsage < maxFileSize {
+		return nil, fmt.Errorf(
+			"max disk usage of mirror (%v) can't be lower than max size of mirror file (%v)",
+			maxDiskUsage, maxFileSize,
+		)
+	}
+
+	err := os.MkdirAll(directory, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &FileTarget{
+		directory:    directory,
+		maxFileSize:  maxFileSize,
+		maxDiskUsage: maxDiskUsage,
+	}
+
+	// ReadDir sorts the files by name and their indexes have the same number of digits
+	fileInfos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileInfo := range fileInfos {
+		index, ok := mirrorFileIndex(fileInfo.Name())
+		if !ok || !fileInfo.Mode().IsRegular() {
+			continue
+		}
+
+		target.files = append(target.files, mirrorFile{name: fileInfo.Name(), size: fileInfo.Size()})
+		target.usage += fileInfo.Size()
+		target.nextIndex = index + 1
+	}
+
+	return target, nil
+}
+
+// mirrorFileName returns name of the mirror file with the index
+func mirrorFileName(index uint64) string {
+	return fmt.Sprintf("%v%020d%v", mirrorFilePrefix, index, mirrorFileSuffix)
+}
+
+// mirrorFileIndex returns index of the mirror file, false is returned for other files
+func mirrorFileIndex(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, mirrorFilePrefix) || !strings.HasSuffix(name, mirrorFileSuffix) {
+		return 0, false
+	}
+
+	digits := strings.TrimSuffix(strings.TrimPrefix(name, mirrorFilePrefix), mirrorFileSuffix)
+	index, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return index, true
+}
+
+// Write appends the message as a line of the current file
+func (target *FileTarget) Write(msg Message) error {
+	record, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	record = append(record, '\n')
+
+	recordSize := int64(len(record))
+	if recordSize > target.maxFileSize {
+		return fmt.Errorf("mirrored message of %v bytes exceeds max size of mirror file", recordSize)
+	}
+
+	if target.current == nil || target.files[len(target.files)-1].size+recordSize > target.maxFileSize {
+		if err := target.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if err := target.evict(recordSize); err != nil {
+		return err
+	}
+
+	written, err := target.current.Write(record)
+	target.files[len(target.files)-1].size += int64(written)
+	target.usage += int64(written)
+
+	return err
+}
+
+// rotate closes the current file and starts a new one
+func (target *FileTarget) rotate() error {
+	if err := target.closeCurrent(); err != nil {
+		return err
+	}
+
+	name := mirrorFileName(target.nextIndex)
+	file, err := os.OpenFile(filepath.Join(target.directory, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+
+	target.current = file
+	target.files = append(target.files, mirrorFile{name: name})
+	target.nextIndex++
+
+	return nil
+}
+
+// evict deletes the oldest files until the record fits into the max disk usage,
+// the current file is never deleted, but it always leaves enough space for the record
+func (target *FileTarget) evict(recordSize int64) error {
+	for target.usage+recordSize > target.maxDiskUsage && len(target.files) > 1 {
+		oldest := target.files[0]
+
+		err := os.Remove(filepath.Join(target.directory, oldest.name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		target.usage -= oldest.size
+		target.files = target.files[1:]
+	}
+
+	return nil
+}
+
+func (target *FileTarget) closeCurrent() error {
+	if target.current == nil {
+		return nil
+	}
+
+	err := target.current.Close()
+	target.current = nil
+
+	return err
+}
+
+// Close closes the current file
+func (target *FileTarget) Close() error {
+	return target.closeCurrent()
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/mirror"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+)
+
+func mustTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "mirror")
+	helpers.FailOnError(t, err)
+
+	return dir
+}
+
+// testMessage returns message whose record has the same size for offsets with the same number of digits
+func testMessage(offset int64) mirror.Message {
+	return mirror.Message{
+		Topic:     "ccx.ocp.results",
+		Partition: 1,
+		Offset:    offset,
+		Value:     []byte(`{"OrgID": 1}`),
+	}
+}
+
+func recordSize(t *testing.T) int64 {
+	record, err := json.Marshal(testMessage(100))
+	helpers.FailOnError(t, err)
+
+	return int64(len(record) + 1)
+}
+
+func mustWriteMessages(t *testing.T, target *mirror.FileTarget, offsets ...int64) {
+	for _, offset := range offsets {
+		helpers.FailOnError(t, target.Write(testMessage(offset)))
+	}
+}
+
+// readMirrorFiles returns sizes of mirror files in the directory and offsets of the records in them
+func readMirrorFiles(t *testing.T, dir string) ([]int64, []int64) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	helpers.FailOnError(t, err)
+
+	var (
+		sizes   []int64
+		offsets []int64
+	)
+
+	for _, fileInfo := range fileInfos {
+		if filepath.Ext(fileInfo.Name()) != ".ndjson" {
+			continue
+		}
+		sizes = append(sizes, fileInfo.Size())
+
+		file, err := os.Open(filepath.Join(dir, fileInfo.Name()))
+		helpers.FailOnError(t, err)
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var msg mirror.Message
+			helpers.FailOnError(t, json.Unmarshal(scanner.Bytes(), &msg))
+			offsets = append(offsets, msg.Offset)
+		}
+		helpers.FailOnError(t, scanner.Err())
+		helpers.FailOnError(t, file.Close())
+	}
+
+	return sizes, offsets
+}
+
+func TestFileTargetRotation(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	size := recordSize(t)
+	target, err := mirror.NewFileTarget(dir, 3*size, 100*size)
+	helpers.FailOnError(t, err)
+
+	mustWriteMessages(t, target, 100, 101, 102, 103, 104, 105, 106)
+	helpers.FailOnError(t, target.Close())
+
+	sizes, offsets := readMirrorFiles(t, dir)
+	assert.Equal(t, []int64{3 * size, 3 * size, size}, sizes)
+	assert.Equal(t, []int64{100, 101, 102, 103, 104, 105, 106}, offsets)
+}
+
+func TestFileTargetDiskUsageCap(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	size := recordSize(t)
+	target, err := mirror.NewFileTarget(dir, 2*size, 5*size)
+	helpers.FailOnError(t, err)
+
+	for offset := int64(100); offset < 120; offset++ {
+		mustWriteMessages(t, target, offset)
+
+		sizes, _ := readMirrorFiles(t, dir)
+		var usage int64
+		for _, fileSize := range sizes {
+			usage += fileSize
+		}
+		assert.True(t, usage <= 5*size, "disk usage %v exceeds the cap %v", usage, 5*size)
+	}
+	helpers.FailOnError(t, target.Close())
+
+	// the oldest files are deleted, the newest messages are kept
+	_, offsets := readMirrorFiles(t, dir)
+	assert.Equal(t, []int64{116, 117, 118, 119}, offsets)
+}
+
+func TestFileTargetContinuesAfterRestart(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	size := recordSize(t)
+	target, err := mirror.NewFileTarget(dir, 2*size, 4*size)
+	helpers.FailOnError(t, err)
+	mustWriteMessages(t, target, 100, 101, 102)
+	helpers.FailOnError(t, target.Close())
+
+	target, err = mirror.NewFileTarget(dir, 2*size, 4*size)
+	helpers.FailOnError(t, err)
+	mustWriteMessages(t, target, 103, 104)
+	helpers.FailOnError(t, target.Close())
+
+	// files written before the restart count into the disk usage
+	sizes, offsets := readMirrorFiles(t, dir)
+	assert.Equal(t, []int64{size, 2 * size}, sizes)
+	assert.Equal(t, []int64{102, 103, 104}, offsets)
+}
+
+func TestFileTargetIgnoresOtherFiles(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	size := recordSize(t)
+	otherFile := filepath.Join(dir, "notes.txt")
+	helpers.FailOnError(t, ioutil.WriteFile(otherFile, make([]byte, 10*size), 0600))
+
+	target, err := mirror.NewFileTarget(dir, size, size)
+	helpers.FailOnError(t, err)
+	mustWriteMessages(t, target, 100, 101)
+	helpers.FailOnError(t, target.Close())
+
+	_, err = os.Stat(otherFile)
+	helpers.FailOnError(t, err)
+
+	sizes, _ := readMirrorFiles(t, dir)
+	assert.Equal(t, []int64{size}, sizes)
+}
+
+func TestFileTargetMessageTooLarge(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	target, err := mirror.NewFileTarget(dir, recordSize(t)-1, 1000)
+	helpers.FailOnError(t, err)
+	defer func() {
+		helpers.FailOnError(t, target.Close())
+	}()
+
+	err = target.Write(testMessage(100))
+	assert.EqualError(t, err, "mirrored message of 117 bytes exceeds max size of mirror file")
+}
+
+func TestNewFileTargetInvalidLimits(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := mirror.NewFileTarget(dir, 0, 100)
+	assert.EqualError(t, err, "max size of mirror file has to be positive, got 0")
+
+	_, err = mirror.NewFileTarget(dir, 100, 10)
+	assert.EqualError(t, err, "max disk usage of mirror (10) can't be lower than max size of mirror file (100)")
+}
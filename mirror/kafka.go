@@ -0,0 +1,62 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaTarget writes mirrored messages into the Kafka topic
+type KafkaTarget struct {
+	Topic    string
+	Producer sarama.SyncProducer
+}
+
+// NewKafkaTarget constructs target producing mirrored messages into the topic of the broker
+func NewKafkaTarget(address, topic string) (*KafkaTarget, error) {
+	producer, err := sarama.NewSyncProducer([]string{address}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaTarget{
+		Topic:    topic,
+		Producer: producer,
+	}, nil
+}
+
+// Write produces the message as JSON record into the topic
+func (target *KafkaTarget) Write(msg Message) error {
+	record, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = target.Producer.SendMessage(&sarama.ProducerMessage{
+		Topic: target.Topic,
+		Value: sarama.ByteEncoder(record),
+	})
+
+	return err
+}
+
+// Close closes the producer
+func (target *KafkaTarget) Close() error {
+	return target.Producer.Close()
+}
@@ -0,0 +1,184 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mirror contains mirroring of raw messages consumed from the broker for debugging
+// of producers. Every mirrored message is written as a JSON record containing its raw value
+// (base64 encoded) and its topic, partition and offset either to a secondary Kafka topic
+// or to size-rotated newline delimited JSON (NDJSON) files with a limited total size.
+//
+// Mirroring never blocks the consumer: messages are buffered and written by a background
+// goroutine, messages which don't fit into the full buffer or can't be written are dropped.
+package mirror
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+)
+
+const (
+	// DefaultMaxFileSize is the size of mirror files used when it's not configured
+	DefaultMaxFileSize = 10 * 1024 * 1024
+	// DefaultMaxDiskUsage is the total size of mirror files used when it's not configured
+	DefaultMaxDiskUsage = 100 * 1024 * 1024
+	// DefaultBufferSize is the number of buffered messages used when it's not configured
+	DefaultBufferSize = 1000
+)
+
+// Configuration represents configuration of mirroring of consumed messages,
+// exactly one of Topic and Directory has to be set when the mirroring is enabled.
+//
+// MaxFileSize and MaxDiskUsage limit size of a single file and of all files in Directory
+// in bytes, the oldest files are deleted when the limit would be exceeded.
+//
+// BufferSize is the number of messages waiting for being written, messages received
+// when the buffer is full are dropped.
+type Configuration struct {
+	Enabled      bool   `mapstructure:"enabled" toml:"enabled"`
+	Topic        string `mapstructure:"topic" toml:"topic"`
+	Directory    string `mapstructure:"directory" toml:"directory"`
+	MaxFileSize  int64  `mapstructure:"max_file_size" toml:"max_file_size"`
+	MaxDiskUsage int64  `mapstructure:"max_disk_usage" toml:"max_disk_usage"`
+	BufferSize   int    `mapstructure:"buffer_size" toml:"buffer_size"`
+}
+
+// Message is the record written for every mirrored message
+type Message struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Value     []byte    `json:"value"`
+}
+
+// Target writes mirrored messages, it's used only by a single goroutine
+type Target interface {
+	Write(msg Message) error
+	Close() error
+}
+
+// Mirror passes copies of messages to the target without blocking the caller
+type Mirror struct {
+	// dropped is the first field to be 64-bit aligned for atomic operations
+	dropped  uint64
+	target   Target
+	messages chan Message
+	done     chan struct{}
+}
+
+// New constructs the mirror writing into the target configured in the configuration,
+// the broker address is used by the Kafka topic target
+func New(mirrorCfg Configuration, brokerAddress string) (*Mirror, error) {
+	if mirrorCfg.MaxFileSize <= 0 {
+		mirrorCfg.MaxFileSize = DefaultMaxFileSize
+	}
+	if mirrorCfg.MaxDiskUsage <= 0 {
+		mirrorCfg.MaxDiskUsage = DefaultMaxDiskUsage
+	}
+	if mirrorCfg.BufferSize <= 0 {
+		mirrorCfg.BufferSize = DefaultBufferSize
+	}
+
+	var (
+		target Target
+		err    error
+	)
+
+	switch {
+	case mirrorCfg.Topic != "" && mirrorCfg.Directory != "":
+		return nil, errors.New("only one of mirror topic and directory can be set")
+	case mirrorCfg.Topic != "":
+		target, err = NewKafkaTarget(brokerAddress, mirrorCfg.Topic)
+	case mirrorCfg.Directory != "":
+		target, err = NewFileTarget(mirrorCfg.Directory, mirrorCfg.MaxFileSize, mirrorCfg.MaxDiskUsage)
+	default:
+		return nil, errors.New("mirror topic or directory has to be set")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMirror(target, mirrorCfg.BufferSize), nil
+}
+
+// NewMirror constructs the mirror writing into the target with buffer for bufferSize messages
+func NewMirror(target Target, bufferSize int) *Mirror {
+	mirror := &Mirror{
+		target:   target,
+		messages: make(chan Message, bufferSize),
+		done:     make(chan struct{}),
+	}
+
+	go mirror.run()
+
+	return mirror
+}
+
+// run writes buffered messages into the target until the mirror is closed
+func (mirror *Mirror) run() {
+	defer close(mirror.done)
+
+	for msg := range mirror.messages {
+		if err := mirror.target.Write(msg); err != nil {
+			log.Error().Err(err).Int64("offset", msg.Offset).Msg("Unable to mirror message")
+			mirror.drop()
+		}
+	}
+}
+
+// Send passes copy of the consumed message to the mirror, the message is dropped
+// when the buffer is full. It never blocks.
+func (mirror *Mirror) Send(consumed *sarama.ConsumerMessage) {
+	msg := Message{
+		Topic:     consumed.Topic,
+		Partition: consumed.Partition,
+		Offset:    consumed.Offset,
+		Timestamp: consumed.Timestamp,
+		Value:     append([]byte(nil), consumed.Value...),
+	}
+
+	select {
+	case mirror.messages <- msg:
+	default:
+		mirror.drop()
+	}
+}
+
+func (mirror *Mirror) drop() {
+	atomic.AddUint64(&mirror.dropped, 1)
+	metrics.MirroredMessagesDropped.Inc()
+}
+
+// Dropped returns number of messages which were not mirrored because the buffer
+// was full or because they couldn't be written
+func (mirror *Mirror) Dropped() uint64 {
+	return atomic.LoadUint64(&mirror.dropped)
+}
+
+// Close writes the buffered messages and closes the target. Send mustn't be called after Close.
+func (mirror *Mirror) Close() error {
+	close(mirror.messages)
+	<-mirror.done
+
+	log.Info().Uint64("dropped", mirror.Dropped()).Msg("Mirror of consumed messages closed")
+
+	return mirror.target.Close()
+}
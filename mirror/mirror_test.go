@@ -0,0 +1,178 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/mirror"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+)
+
+const testCaseTimeLimit = 10 * time.Second
+
+// recordingTarget remembers written messages, writes are blocked until release is closed
+type recordingTarget struct {
+	release  chan struct{}
+	mutex    sync.Mutex
+	messages []mirror.Message
+	err      error
+}
+
+func newRecordingTarget(blocked bool) *recordingTarget {
+	target := &recordingTarget{release: make(chan struct{})}
+	if !blocked {
+		close(target.release)
+	}
+
+	return target
+}
+
+func (target *recordingTarget) Write(msg mirror.Message) error {
+	<-target.release
+
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+
+	if target.err != nil {
+		return target.err
+	}
+	target.messages = append(target.messages, msg)
+
+	return nil
+}
+
+func (target *recordingTarget) Close() error {
+	return nil
+}
+
+func (target *recordingTarget) written() []mirror.Message {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+
+	return target.messages
+}
+
+func consumedMessage(offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     "ccx.ocp.results",
+		Partition: 1,
+		Offset:    offset,
+		Timestamp: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+		Value:     []byte(`{"OrgID": 1}`),
+	}
+}
+
+func TestMirrorWritesMessages(t *testing.T) {
+	target := newRecordingTarget(false)
+	messageMirror := mirror.NewMirror(target, 10)
+
+	consumed := consumedMessage(1)
+	messageMirror.Send(consumed)
+	messageMirror.Send(consumedMessage(2))
+	// the mirror keeps its own copy of the value
+	consumed.Value[0] = 'X'
+
+	helpers.FailOnError(t, messageMirror.Close())
+
+	assert.Equal(t, []mirror.Message{
+		{
+			Topic:     "ccx.ocp.results",
+			Partition: 1,
+			Offset:    1,
+			Timestamp: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+			Value:     []byte(`{"OrgID": 1}`),
+		},
+		{
+			Topic:     "ccx.ocp.results",
+			Partition: 1,
+			Offset:    2,
+			Timestamp: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+			Value:     []byte(`{"OrgID": 1}`),
+		},
+	}, target.written())
+	assert.Equal(t, uint64(0), messageMirror.Dropped())
+}
+
+func TestMirrorDoesNotBlockWhenTargetBlocks(t *testing.T) {
+	helpers.RunTestWithTimeout(t, func(t *testing.T) {
+		const (
+			bufferSize = 2
+			messages   = 10
+		)
+
+		target := newRecordingTarget(true)
+		messageMirror := mirror.NewMirror(target, bufferSize)
+
+		for offset := int64(0); offset < messages; offset++ {
+			messageMirror.Send(consumedMessage(offset))
+		}
+
+		// one message can be already taken by the blocked writer
+		dropped := messageMirror.Dropped()
+		assert.True(t, dropped >= messages-bufferSize-1 && dropped <= messages-bufferSize, "dropped %v", dropped)
+
+		close(target.release)
+		helpers.FailOnError(t, messageMirror.Close())
+
+		assert.Equal(t, messages, len(target.written())+int(messageMirror.Dropped()))
+	}, testCaseTimeLimit)
+}
+
+func TestMirrorCountsWriteErrorsAsDropped(t *testing.T) {
+	target := newRecordingTarget(false)
+	target.err = errors.New("disk full")
+	messageMirror := mirror.NewMirror(target, 10)
+
+	messageMirror.Send(consumedMessage(1))
+	messageMirror.Send(consumedMessage(2))
+
+	helpers.FailOnError(t, messageMirror.Close())
+
+	assert.Equal(t, uint64(2), messageMirror.Dropped())
+}
+
+func TestNewMirrorDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror")
+	helpers.FailOnError(t, err)
+	defer os.RemoveAll(dir)
+
+	messageMirror, err := mirror.New(mirror.Configuration{Enabled: true, Directory: dir}, "")
+	helpers.FailOnError(t, err)
+
+	messageMirror.Send(consumedMessage(100))
+	helpers.FailOnError(t, messageMirror.Close())
+
+	_, offsets := readMirrorFiles(t, dir)
+	assert.Equal(t, []int64{100}, offsets)
+}
+
+func TestNewMirrorInvalidConfiguration(t *testing.T) {
+	_, err := mirror.New(mirror.Configuration{Enabled: true}, "")
+	assert.EqualError(t, err, "mirror topic or directory has to be set")
+
+	_, err = mirror.New(mirror.Configuration{Enabled: true, Topic: "mirror", Directory: "/tmp/mirror"}, "")
+	assert.EqualError(t, err, "only one of mirror topic and directory can be set")
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Partitioning of the report table of an already used PostgreSQL database
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// partitionReportsCommand is the name of CLI subcommand partitioning the report table
+const partitionReportsCommand = "partition-reports"
+
+// reportsPartitioner copies reports into the partitioned report table, it's usually the SQL storage
+type reportsPartitioner interface {
+	PartitionReports(batchSize int) (int, error)
+}
+
+// runPartitionReports copies reports into the report table partitioned by hash of org_id
+// in batches of the size given as the optional argument and returns exit code
+func runPartitionReports(args []string) int {
+	if len(args) > 1 {
+		log.Error().Msgf("Usage: %v [batch size]", partitionReportsCommand)
+		return ExitStatusPartitionReportsError
+	}
+
+	// zero selects the default batch size
+	batchSize := 0
+	if len(args) == 1 {
+		var err error
+		batchSize, err = strconv.Atoi(args[0])
+		if err != nil || batchSize <= 0 {
+			log.Error().Msgf("Batch size has to be a positive number, got %v", args[0])
+			return ExitStatusPartitionReportsError
+		}
+	}
+
+	dbStorage, err := startStorageConnection()
+	if err != nil {
+		return ExitStatusPartitionReportsError
+	}
+	defer closeStorage(dbStorage)
+
+	partitioner, ok := dbStorage.(reportsPartitioner)
+	if !ok {
+		log.Error().Msg("Storage doesn't use any database, there's no report table to partition")
+		return ExitStatusPartitionReportsError
+	}
+
+	copied, err := partitioner.PartitionReports(batchSize)
+
+	fmt.Printf("copied: %v\n", copied)
+
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to partition the report table")
+		return ExitStatusPartitionReportsError
+	}
+
+	return ExitStatusOK
+}
@@ -0,0 +1,228 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Implementation of periodic cleanup of old reports for aggregator
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/archive"
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// oldReportsCleaner deletes reports not updated for the given time, it's usually the storage
+type oldReportsCleaner interface {
+	CleanupOldReports(olderThan time.Duration) (int, error)
+}
+
+// cleanupOldReports deletes reports not updated for longer than the retention period
+func cleanupOldReports(cleaner oldReportsCleaner, retention time.Duration) {
+	deleted, err := cleaner.CleanupOldReports(retention)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to delete old reports")
+		return
+	}
+
+	metrics.OldReportsDeleted.Add(float64(deleted))
+	log.Info().Int("deleted", deleted).Msgf("Reports not updated for %v deleted", retention)
+}
+
+// consumerErrorsCleaner deletes old failures of processing of consumed messages, it's usually the storage
+type consumerErrorsCleaner interface {
+	CleanupConsumerErrors(olderThan time.Duration) (int, error)
+}
+
+// cleanupConsumerErrors deletes failures of processing of messages consumed longer than
+// the retention period ago
+func cleanupConsumerErrors(cleaner consumerErrorsCleaner, retention time.Duration) {
+	deleted, err := cleaner.CleanupConsumerErrors(retention)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to delete old processing errors")
+		return
+	}
+
+	metrics.OldConsumerErrorsDeleted.Add(float64(deleted))
+	log.Info().Int("deleted", deleted).Msgf("Processing errors older than %v deleted", retention)
+}
+
+// softDeletedReportsPurger deletes reports soft-deleted for the given time, it's usually the storage
+type softDeletedReportsPurger interface {
+	PurgeSoftDeleted(olderThan time.Duration) (int, error)
+}
+
+// purgeSoftDeletedReports deletes reports soft-deleted longer than the retention period ago,
+// so they can't be restored anymore
+func purgeSoftDeletedReports(purger softDeletedReportsPurger, retention time.Duration) {
+	purged, err := purger.PurgeSoftDeleted(retention)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to purge soft-deleted reports")
+		return
+	}
+
+	metrics.SoftDeletedReportsPurged.Add(float64(purged))
+	log.Info().Int("purged", purged).Msgf("Reports soft-deleted for %v purged", retention)
+}
+
+// oldReportsArchivingCleaner provides old reports for archiving and deletes only the archived ones,
+// it's usually the storage
+type oldReportsArchivingCleaner interface {
+	ForEachReportCheckedBefore(cutoff time.Time, process func(types.ArchivedReport) error) error
+	CleanupClustersCheckedBefore(cutoff time.Time, clusterNames []types.ClusterName) (int, error)
+}
+
+// orgArchive is an archive of reports of a single organization being uploaded
+type orgArchive struct {
+	orgID        types.OrgID
+	stream       *archive.ReportStream
+	clusterNames []types.ClusterName
+	err          error
+}
+
+// archiveAndCleanupOldReports archives reports not updated for longer than the retention period and deletes them.
+// Reports are archived per organization while they're read from the storage, only cluster names are kept
+// in memory. Only reports whose archive was uploaded successfully are deleted, the rest is kept for the next run.
+func archiveAndCleanupOldReports(
+	cleaner oldReportsArchivingCleaner, archiver archive.Archiver, retention time.Duration,
+) {
+	now := time.Now()
+	cutoff := now.Add(-retention)
+
+	var (
+		current  *orgArchive
+		archived []*orgArchive
+	)
+
+	finishArchive := func() {
+		if current == nil {
+			return
+		}
+
+		if current.err != nil {
+			current.stream.Abort(current.err)
+		} else {
+			current.err = current.stream.Close()
+		}
+
+		if current.err != nil {
+			log.Error().Err(current.err).Msgf("Unable to archive old reports for organization %v, they are kept", current.orgID)
+			metrics.OldReportsArchiveErrors.Inc()
+		} else {
+			archived = append(archived, current)
+		}
+	}
+
+	err := cleaner.ForEachReportCheckedBefore(cutoff, func(report types.ArchivedReport) error {
+		if current == nil || current.orgID != report.OrgID {
+			finishArchive()
+			current = &orgArchive{
+				orgID:  report.OrgID,
+				stream: archive.NewReportStream(archiver, archive.ArchiveName(report.OrgID, now)),
+			}
+		}
+
+		// the rest of the organization's reports is skipped once its archive failed
+		if current.err == nil {
+			current.err = current.stream.Add(report)
+			current.clusterNames = append(current.clusterNames, report.ClusterName)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read old reports")
+		metrics.OldReportsArchiveErrors.Inc()
+
+		// the archive of the organization being read is incomplete
+		if current != nil {
+			current.stream.Abort(err)
+			current = nil
+		}
+	}
+	finishArchive()
+
+	deleted := 0
+
+	for _, orgArchive := range archived {
+		orgDeleted, err := cleaner.CleanupClustersCheckedBefore(cutoff, orgArchive.clusterNames)
+		if err != nil {
+			log.Error().Err(err).Msgf("Unable to delete archived reports for organization %v", orgArchive.orgID)
+			continue
+		}
+
+		deleted += orgDeleted
+	}
+
+	metrics.OldReportsDeleted.Add(float64(deleted))
+	log.Info().Int("deleted", deleted).Msgf("Reports not updated for %v archived and deleted", retention)
+}
+
+// startReportCleanup opens the storage connection and runs periodic cleanup of old reports,
+// old processing errors and soft-deleted reports until the context is cancelled, each of them
+// only when its retention is set
+func startReportCleanup(ctx context.Context, cleanupCfg cleanupConfiguration) {
+	dbStorage, err := startStorageConnection()
+	if err != nil {
+		log.Error().Err(err).Msg("Periodic cleanup of old reports can't be started")
+		return
+	}
+	defer closeStorage(dbStorage)
+
+	cleanupReports := func() {
+		cleanupOldReports(dbStorage, cleanupCfg.Retention)
+	}
+
+	var archiver archive.Archiver
+	switch {
+	case cleanupCfg.ArchiveS3.Bucket != "":
+		archiver = archive.S3Archiver{S3Configuration: cleanupCfg.ArchiveS3}
+	case cleanupCfg.ArchiveDirectory != "":
+		archiver = archive.FilesystemArchiver{Directory: cleanupCfg.ArchiveDirectory}
+	}
+
+	if archiver != nil {
+		cleanupReports = func() {
+			archiveAndCleanupOldReports(dbStorage, archiver, cleanupCfg.Retention)
+		}
+	}
+
+	cleanup := func() {
+		if cleanupCfg.Retention > 0 {
+			cleanupReports()
+		}
+		if cleanupCfg.ConsumerErrorRetention > 0 {
+			cleanupConsumerErrors(dbStorage, cleanupCfg.ConsumerErrorRetention)
+		}
+		if cleanupCfg.SoftDeletedRetention > 0 {
+			purgeSoftDeletedReports(dbStorage, cleanupCfg.SoftDeletedRetention)
+		}
+	}
+
+	log.Info().
+		Str("interval", cleanupCfg.Interval.String()).
+		Str("retention", cleanupCfg.Retention.String()).
+		Str("archive_directory", cleanupCfg.ArchiveDirectory).
+		Str("archive_s3_bucket", cleanupCfg.ArchiveS3.Bucket).
+		Str("consumer_error_retention", cleanupCfg.ConsumerErrorRetention.String()).
+		Str("soft_deleted_retention", cleanupCfg.SoftDeletedRetention.String()).
+		Msg("Periodic cleanup of old reports has been started")
+
+	runPeriodically(ctx, cleanupCfg.Interval, cleanup)
+}
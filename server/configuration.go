@@ -16,12 +16,35 @@ limitations under the License.
 
 package server
 
+import (
+	"time"
+
+	mapset "github.com/deckarep/golang-set"
+)
+
 // Configuration represents configuration of REST API HTTP server
+//
+// ReportStalenessThreshold - reports last checked earlier than this are marked as stale, 0 disables the check
+//
+// ReportUpload - enables endpoint for uploading reports over HTTP, ReportUploadMaxBodySize (in bytes)
+// and ReportUploadRateLimit (uploads per minute) use default values when not set
+//
+// RuleVerification - which rules users can leave feedback on, "content" (default) accepts only rules
+// from the loaded rule content, "report" accepts also rules hit in the cluster's report and "disabled"
+// accepts any rule
+//
+// OrgWhitelist - organizations allowed to upload reports, it's not read from the configuration file directly
 type Configuration struct {
-	Address     string `mapstructure:"address" toml:"address"`
-	APIPrefix   string `mapstructure:"api_prefix" toml:"api_prefix"`
-	APISpecFile string `mapstructure:"api_spec_file" toml:"api_spec_file"`
-	Debug       bool   `mapstructure:"debug" toml:"debug"`
-	Auth        bool   `mapstructure:"auth" toml:"auth"`
-	AuthType    string `mapstructure:"auth_type" toml:"auth_type"`
+	Address                  string        `mapstructure:"address" toml:"address"`
+	APIPrefix                string        `mapstructure:"api_prefix" toml:"api_prefix"`
+	APISpecFile              string        `mapstructure:"api_spec_file" toml:"api_spec_file"`
+	Debug                    bool          `mapstructure:"debug" toml:"debug"`
+	Auth                     bool          `mapstructure:"auth" toml:"auth"`
+	AuthType                 string        `mapstructure:"auth_type" toml:"auth_type"`
+	ReportStalenessThreshold time.Duration `mapstructure:"report_staleness_threshold" toml:"report_staleness_threshold"`
+	ReportUpload             bool          `mapstructure:"report_upload" toml:"report_upload"`
+	ReportUploadMaxBodySize  int64         `mapstructure:"report_upload_max_body_size" toml:"report_upload_max_body_size"`
+	ReportUploadRateLimit    int           `mapstructure:"report_upload_rate_limit" toml:"report_upload_rate_limit"`
+	RuleVerification         string        `mapstructure:"rule_verification" toml:"rule_verification"`
+	OrgWhitelist             mapset.Set    `mapstructure:"org_white_list" toml:"org_white_list"`
 }
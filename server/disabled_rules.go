@@ -0,0 +1,117 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/csv"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-operator-utils/responses"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+)
+
+// csvContentType is the MIME type of responses sent as comma separated values
+const csvContentType = "text/csv"
+
+// disabledRulesCSVHeader is the header of the CSV with summaries of disabled rules
+var disabledRulesCSVHeader = []string{
+	"rule_id", "disabled_for_org", "acked", "cluster_count", "users", "justification", "updated_at",
+}
+
+// acceptsCSV checks whether the client asks for CSV in the Accept header of the request
+func acceptsCSV(request *http.Request) bool {
+	for _, mediaRange := range strings.Split(request.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err == nil && mediaType == csvContentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeDisabledRulesCSV writes the summaries of disabled rules as CSV with the header,
+// users are separated by semicolons and zero update time is written as empty value
+func writeDisabledRulesCSV(writer io.Writer, summaries []storage.DisabledRuleSummary) error {
+	csvWriter := csv.NewWriter(writer)
+
+	if err := csvWriter.Write(disabledRulesCSVHeader); err != nil {
+		return err
+	}
+
+	for _, summary := range summaries {
+		users := make([]string, len(summary.Users))
+		for i, userID := range summary.Users {
+			users[i] = string(userID)
+		}
+
+		updatedAt := ""
+		if !summary.UpdatedAt.IsZero() {
+			updatedAt = summary.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+
+		err := csvWriter.Write([]string{
+			string(summary.RuleID),
+			strconv.FormatBool(summary.DisabledForOrg),
+			strconv.FormatBool(summary.Acked),
+			strconv.Itoa(summary.ClusterCount),
+			strings.Join(users, ";"),
+			summary.Justification,
+			updatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// listDisabledRulesForOrganization sends summaries of rules disabled or acked for the organization
+// or disabled for its clusters, they're sent as CSV when the client accepts it and as JSON otherwise
+func (server *HTTPServer) listDisabledRulesForOrganization(writer http.ResponseWriter, request *http.Request) {
+	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	summaries, err := server.storageFor(request).ListDisabledRulesForOrg(organizationID)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to list disabled rules for organization")
+		handleServerError(writer, err)
+		return
+	}
+
+	if acceptsCSV(request) {
+		writer.Header().Set("Content-Type", csvContentType)
+		err = writeDisabledRulesCSV(writer, summaries)
+	} else {
+		err = responses.SendResponse(writer, responses.BuildOkResponseWithData("disabled_rules", summaries))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/server"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// mustPrepareDisabledRules writes the report of the cluster with a rule disabled for it
+// and a rule disabled for the whole organization
+func mustPrepareDisabledRules(t *testing.T, mockStorage storage.Storage) {
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+	helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, `noisy, "flaky"`,
+	))
+	helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID))
+}
+
+func TestListDisabledRulesForOrganization(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustPrepareDisabledRules(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.DisabledRulesForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			var response struct {
+				DisabledRules []storage.DisabledRuleSummary `json:"disabled_rules"`
+				Status        string                        `json:"status"`
+			}
+			helpers.FailOnError(t, json.Unmarshal([]byte(got), &response))
+
+			assert.Equal(t, "ok", response.Status)
+			assert.Len(t, response.DisabledRules, 2)
+
+			toggled := response.DisabledRules[0]
+			assert.Equal(t, testdata.Rule1ID, toggled.RuleID)
+			assert.Equal(t, 1, toggled.ClusterCount)
+			assert.False(t, toggled.DisabledForOrg)
+			assert.Equal(t, []types.UserID{testdata.UserID}, toggled.Users)
+			assert.Equal(t, `noisy, "flaky"`, toggled.Justification)
+
+			disabled := response.DisabledRules[1]
+			assert.Equal(t, testdata.Rule2ID, disabled.RuleID)
+			assert.Equal(t, 0, disabled.ClusterCount)
+			assert.True(t, disabled.DisabledForOrg)
+			assert.Equal(t, []types.UserID{testdata.UserID}, disabled.Users)
+		},
+	})
+}
+
+func TestListDisabledRulesForOrganizationCSV(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustPrepareDisabledRules(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.DisabledRulesForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+		Headers:      map[string]string{"Accept": "application/json;q=0.5, text/csv"},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/csv"},
+		BodyChecker: func(t *testing.T, _, got string) {
+			lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+			assert.Len(t, lines, 3)
+			assert.Equal(t, "rule_id,disabled_for_org,acked,cluster_count,users,justification,updated_at", lines[0])
+			// the justification with separator and quotes is quoted
+			assert.Regexp(t, `^test\.rule1,false,false,1,1,"noisy, ""flaky""",\d{4}-\d\d-\d\dT`, lines[1])
+			assert.Regexp(t, `^test\.rule2,true,false,0,1,,\d{4}-\d\d-\d\dT`, lines[2])
+		},
+	})
+}
+
+func TestListDisabledRulesForOrganizationCSVEmpty(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.DisabledRulesForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+		Headers:      map[string]string{"Accept": "text/csv"},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/csv"},
+		BodyChecker: func(t *testing.T, _, got string) {
+			assert.Equal(t, "rule_id,disabled_for_org,acked,cluster_count,users,justification,updated_at\n", got)
+		},
+	})
+}
+
+func TestListDisabledRulesForOrganizationDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.DisabledRulesForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+		Headers:      map[string]string{"Accept": "text/csv"},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
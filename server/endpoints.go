@@ -26,6 +26,30 @@ const (
 	DeleteOrganizationsEndpoint = "organizations/{organizations}"
 	// DeleteClustersEndpoint deletes all {clusters}(comma separated array). DEBUG only
 	DeleteClustersEndpoint = "clusters/{clusters}"
+	// RestoreClusterEndpoint restores (PUT) soft-deleted report of {cluster}. DEBUG only
+	RestoreClusterEndpoint = "clusters/{cluster}/restore"
+	// DeleteClustersBatchEndpoint deletes all clusters from request body {"clusters": [...]}. DEBUG only
+	DeleteClustersBatchEndpoint = "admin/clusters"
+	// ClustersCountPerOrgEndpoint returns number of clusters for each organization. DEBUG only
+	ClustersCountPerOrgEndpoint = "admin/organizations/clusters_count"
+	// ExportReportsForOrganizationEndpoint streams all reports of {organization} as newline delimited JSON. DEBUG only
+	ExportReportsForOrganizationEndpoint = "admin/organizations/{organization}/reports/export"
+	// FeedbacksForClusterEndpoint returns feedback of all users on rules for {cluster}. DEBUG only
+	FeedbacksForClusterEndpoint = "admin/clusters/{cluster}/feedbacks"
+	// RulesEndpoint returns rules of the loaded rule content filtered by query parameters `active`,
+	// `module_prefix`, `limit` and `offset`. DEBUG only
+	RulesEndpoint = "admin/rules"
+	// ConsistencyCheckEndpoint checks (POST) consistency of rule hits with all reports, inconsistent reports
+	// are repaired when query parameter `repair` is set. DEBUG only
+	ConsistencyCheckEndpoint = "admin/consistency_check"
+	// ConsistencyIssuesEndpoint returns reports found inconsistent by the consistency check. DEBUG only
+	ConsistencyIssuesEndpoint = "admin/consistency_issues"
+	// ConsumerErrorsEndpoint returns the most recent failures of processing of consumed messages,
+	// their number is set by query parameter `limit`. DEBUG only
+	ConsumerErrorsEndpoint = "admin/consumer_errors"
+	// AuditLogEndpoint returns the most recent destructive operations of the storage run since
+	// the time set by query parameter `since`, their number is set by query parameter `limit`. DEBUG only
+	AuditLogEndpoint = "admin/audit_log"
 	// OrganizationsEndpoint returns all organizations
 	OrganizationsEndpoint = "organizations"
 	// ReportEndpoint returns report for provided {organization} and {cluster}
@@ -36,8 +60,39 @@ const (
 	DislikeRuleEndpoint = "clusters/{cluster}/rules/{rule_id}/dislike"
 	// ResetVoteOnRuleEndpoint resets vote on rule with {rule_id} for {cluster} using current user(from auth header)
 	ResetVoteOnRuleEndpoint = "clusters/{cluster}/rules/{rule_id}/reset_vote"
+	// FeedbackOnRuleEndpoint adds (POST) or deletes (DELETE) feedback on rule with {rule_id} for {cluster}
+	// left by current user(from auth header), the message is sent in request body {"message": "..."}
+	FeedbackOnRuleEndpoint = "clusters/{cluster}/rules/{rule_id}/feedback"
+	// UploadReportEndpoint stores report for {cluster} from request body in the same format as Kafka message.
+	// Enabled only by report_upload option
+	UploadReportEndpoint = "clusters/{cluster}/report"
 	// ClustersForOrganizationEndpoint returns all clusters for {organization}
 	ClustersForOrganizationEndpoint = "organizations/{organization}/clusters"
+	// RuleFeedbackStatsForOrganizationEndpoint returns likes, dislikes and number of commenting users
+	// for each rule with feedback on clusters of {organization}, the most disliked rules go first
+	RuleFeedbackStatsForOrganizationEndpoint = "organizations/{organization}/rules/feedback-stats"
+	// OrganizationOverviewEndpoint returns number of clusters of {organization}, times of their
+	// oldest and newest reports and numbers of rules disabled and acked for them
+	OrganizationOverviewEndpoint = "organizations/{organization}/overview"
+	// DisabledRulesForOrganizationEndpoint returns rules disabled or acked for {organization} or disabled
+	// for its clusters with numbers of the clusters, users and justifications, as CSV when it's accepted
+	DisabledRulesForOrganizationEndpoint = "organizations/{organization}/rules/disabled"
+	// RuleHitsForClusterEndpoint returns rules hit by the latest report for {organization} and {cluster}
+	RuleHitsForClusterEndpoint = "organizations/{organization}/clusters/{cluster}/rules"
+	// ReportMetainfoEndpoint returns times, Kafka offset and number of rules hit of the latest report
+	// for {organization} and {cluster} without the report itself
+	ReportMetainfoEndpoint = "organizations/{organization}/clusters/{cluster}/report/info"
+	// HitsHistoryForClusterEndpoint returns number of rules hit by {cluster} for each of the last `days` days
+	HitsHistoryForClusterEndpoint = "clusters/{cluster}/hits_history"
+	// ProcessingErrorsForClusterEndpoint returns the most recent failures of processing of reports consumed for {cluster}
+	ProcessingErrorsForClusterEndpoint = "clusters/{cluster}/report/processing_errors"
+	// ContentChangesEndpoint returns rules added, removed or modified between two versions of rule content
+	// identified by checksums in query parameters `from` and `to`
+	ContentChangesEndpoint = "content/changes"
+	// ReadyEndpoint returns status ok when the storage is reachable, 503 otherwise
+	ReadyEndpoint = "ready"
+	// LiveEndpoint returns status ok unless the consumer running in the same process is stuck, 503 otherwise
+	LiveEndpoint = "live"
 	// MetricsEndpoint returns prometheus metrics
 	MetricsEndpoint = "metrics"
 )
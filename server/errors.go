@@ -15,11 +15,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/RedHatInsights/insights-operator-utils/responses"
+	"github.com/RedHatInsights/insights-results-aggregator/consumer"
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 	"github.com/rs/zerolog/log"
 )
 
@@ -49,6 +52,24 @@ func (e *RouterParsingError) Error() string {
 	)
 }
 
+// RouterBodyError happens when request body can't be parsed or contains invalid data
+type RouterBodyError struct {
+	errString string
+}
+
+func (e *RouterBodyError) Error() string {
+	return fmt.Sprintf("Invalid request body: %v", e.errString)
+}
+
+// RateLimitError happens when too many requests have been made in a short time
+type RateLimitError struct {
+	errString string
+}
+
+func (e *RateLimitError) Error() string {
+	return e.errString
+}
+
 // AuthenticationError happens during auth problems, for example malformed token
 type AuthenticationError struct {
 	errString string
@@ -58,17 +79,58 @@ func (e *AuthenticationError) Error() string {
 	return e.errString
 }
 
+// RuleNotFoundError happens when feedback is left on a rule which is not known to the aggregator
+type RuleNotFoundError struct {
+	ruleID types.RuleID
+}
+
+func (e *RuleNotFoundError) Error() string {
+	return fmt.Sprintf("Rule with ID %v does not exist", e.ruleID)
+}
+
+// storageErrorOf returns the error of the storage wrapped in err, so wrapped errors
+// are mapped to the same responses as errors returned directly by the storage
+func storageErrorOf(err error) error {
+	var (
+		notFound *storage.ItemNotFoundError
+		timeout  *storage.QueryTimeoutError
+	)
+
+	switch {
+	case errors.As(err, &notFound):
+		return notFound
+	case errors.As(err, &timeout):
+		return timeout
+	default:
+		return err
+	}
+}
+
 // handleServerError handles separate server errors and sends appropriate responses
 func handleServerError(writer http.ResponseWriter, err error) {
 	var respErr error
 
-	switch err := err.(type) {
+	switch err := storageErrorOf(err).(type) {
 	case *RouterMissingParamError:
 		respErr = responses.SendError(writer, err.Error())
 	case *RouterParsingError:
 		respErr = responses.SendError(writer, err.Error())
+	case *RouterBodyError:
+		respErr = responses.SendError(writer, err.Error())
+	case *consumer.ValidationError:
+		respErr = responses.SendError(writer, err.Error())
+	case *storage.InvalidReportError:
+		respErr = responses.SendError(writer, err.Error())
+	case *storage.ValidationError:
+		respErr = responses.SendError(writer, err.Error())
+	case *RateLimitError:
+		respErr = responses.Send(http.StatusTooManyRequests, writer, responses.BuildResponse(err.Error()))
 	case *storage.ItemNotFoundError:
 		respErr = responses.SendNotFound(writer, err.Error())
+	case *storage.QueryTimeoutError:
+		respErr = responses.Send(http.StatusServiceUnavailable, writer, responses.BuildResponse(err.Error()))
+	case *RuleNotFoundError:
+		respErr = responses.SendNotFound(writer, err.Error())
 	case *AuthenticationError:
 		respErr = responses.SendForbidden(writer, err.Error())
 	default:
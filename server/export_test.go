@@ -14,6 +14,8 @@
 
 package server
 
+import "time"
+
 // Please look into the following blogpost:
 // https://medium.com/@robiplus/golang-trick-export-for-test-aa16cbd7b8cd
 // to see why this trick is needed.
@@ -24,4 +26,17 @@ var (
 	ReadClusterNames          = readClusterNames
 	GetRouterPositiveIntParam = getRouterPositiveIntParam
 	ReadRuleID                = readRuleID
+	ResolveTemplate           = resolveTemplate
+	NewStreamingWriter        = newStreamingWriter
+	HandleServerError         = handleServerError
 )
+
+// SetTimeNow replaces the clock used by the server and returns a function restoring the original one
+func SetTimeNow(now func() time.Time) func() {
+	original := timeNow
+	timeNow = now
+
+	return func() {
+		timeNow = original
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows at most limit events in each time window of the given interval
+type rateLimiter struct {
+	mutex       sync.Mutex
+	limit       int
+	interval    time.Duration
+	windowStart time.Time
+	count       int
+}
+
+// newRateLimiter constructs rate limiter allowing limit events per interval
+func newRateLimiter(limit int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:    limit,
+		interval: interval,
+	}
+}
+
+// allow registers new event and returns false when the limit for the current window has been reached
+func (limiter *rateLimiter) allow() bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := timeNow()
+	if now.Sub(limiter.windowStart) >= limiter.interval {
+		limiter.windowStart = now
+		limiter.count = 0
+	}
+
+	if limiter.count >= limiter.limit {
+		return false
+	}
+
+	limiter.count++
+	return true
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ndjsonContentType is the MIME type of responses streamed as newline delimited JSON
+const ndjsonContentType = "application/x-ndjson"
+
+// exportFlushThreshold is the number of bytes written to the streamed response
+// after which the response is flushed to the client
+const exportFlushThreshold = 64 * 1024
+
+// streamingWriter writes the streamed response and flushes it whenever the number of
+// unflushed bytes reaches the threshold. Writing fails as soon as the request is cancelled,
+// so the producer of the response stops when the client disconnects.
+type streamingWriter struct {
+	ctx       context.Context
+	writer    http.ResponseWriter
+	threshold int
+	written   int
+	unflushed int
+}
+
+// newStreamingWriter creates streamingWriter for the response to the request
+func newStreamingWriter(request *http.Request, writer http.ResponseWriter, threshold int) *streamingWriter {
+	return &streamingWriter{
+		ctx:       request.Context(),
+		writer:    writer,
+		threshold: threshold,
+	}
+}
+
+// Write writes data to the response, the response is flushed when the threshold is reached
+func (w *streamingWriter) Write(data []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := w.writer.Write(data)
+	w.written += n
+	w.unflushed += n
+	if err != nil {
+		return n, err
+	}
+
+	if w.unflushed >= w.threshold {
+		w.Flush()
+	}
+
+	return n, nil
+}
+
+// Flush sends all data written so far to the client
+func (w *streamingWriter) Flush() {
+	if flusher, ok := w.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	w.unflushed = 0
+}
+
+// exportReportsForOrganization streams all reports of the organization as newline delimited JSON.
+// Errors are sent the usual way only when nothing has been streamed yet, otherwise the response
+// is just cut off, because its status can't be changed anymore.
+func (server *HTTPServer) exportReportsForOrganization(writer http.ResponseWriter, request *http.Request) {
+	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	writer.Header().Set("Content-Type", ndjsonContentType)
+	stream := newStreamingWriter(request, writer, exportFlushThreshold)
+
+	err = server.storageFor(request).ExportReportsForOrg(organizationID, stream)
+	switch {
+	case err == nil:
+		stream.Flush()
+	case stream.written == 0:
+		log.Error().Err(err).Msg("Unable to export reports")
+		handleServerError(writer, err)
+	default:
+		log.Warn().Err(err).Msgf(
+			"Export of reports of organization %v aborted after %v bytes", organizationID, stream.written,
+		)
+	}
+}
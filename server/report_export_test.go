@@ -0,0 +1,150 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/server"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const exportedClusterName = types.ClusterName("0a1b2c3d-0dd8-49cd-9d4d-f6646df3a5bc")
+
+// assertNDJSONClusters checks that every line of the body is JSON object
+// of exported report and the reports are of the expected clusters
+func assertNDJSONClusters(t *testing.T, body string, expected ...types.ClusterName) {
+	assert.True(t, strings.HasSuffix(body, "\n"), "every line has to be terminated")
+
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	assert.Len(t, lines, len(expected))
+
+	for i, line := range lines {
+		var report storage.ExportedReport
+		helpers.FailOnError(t, json.Unmarshal([]byte(line), &report))
+		assert.Equal(t, expected[i], report.Cluster)
+		assert.NotEmpty(t, report.Report)
+		assert.False(t, report.LastCheckedAt.IsZero())
+	}
+}
+
+func TestExportReportsForOrganization(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	for _, clusterName := range []types.ClusterName{testdata.ClusterName, exportedClusterName} {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, clusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+	}
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ExportReportsForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/x-ndjson"},
+		BodyChecker: func(t *testing.T, _, got string) {
+			assertNDJSONClusters(t, got, exportedClusterName, testdata.ClusterName)
+		},
+	})
+}
+
+func TestExportReportsForOrganizationEmpty(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ExportReportsForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/x-ndjson"},
+		BodyChecker: func(t *testing.T, _, got string) {
+			assert.Empty(t, got)
+		},
+	})
+}
+
+func TestExportReportsForOrganizationBadOrgID(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ExportReportsForOrganizationEndpoint,
+		EndpointArgs: []interface{}{"bad"},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+	})
+}
+
+// TestExportReportsForOrganizationDBError checks that the usual error response
+// is sent when the export fails before anything is streamed
+func TestExportReportsForOrganizationDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ExportReportsForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+func TestStreamingWriterFlushesAtThreshold(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	writer := server.NewStreamingWriter(request, recorder, 10)
+
+	_, err := writer.Write([]byte("12345"))
+	helpers.FailOnError(t, err)
+	assert.False(t, recorder.Flushed)
+
+	_, err = writer.Write([]byte("67890"))
+	helpers.FailOnError(t, err)
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, "1234567890", recorder.Body.String())
+}
+
+// TestStreamingWriterClientDisconnected checks that writing fails
+// once the request is cancelled, so the export stops
+func TestStreamingWriterClientDisconnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	writer := server.NewStreamingWriter(request, recorder, 10)
+
+	_, err := writer.Write([]byte("line\n"))
+	helpers.FailOnError(t, err)
+
+	cancel()
+
+	_, err = writer.Write([]byte("line\n"))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, "line\n", recorder.Body.String())
+}
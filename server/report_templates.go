@@ -0,0 +1,175 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const (
+	// maxTemplatePlaceholders is the maximum number of placeholders resolved in one text,
+	// the rest of them is removed
+	maxTemplatePlaceholders = 100
+	// maxTemplateValueLength is the maximum length (in runes) of a value substituted for a placeholder
+	maxTemplateValueLength = 1024
+)
+
+var (
+	// templatePlaceholderRegex matches placeholders like {{?pydata.foo}} or {{=pydata.nodes.0}}
+	templatePlaceholderRegex = regexp.MustCompile(`\{\{(.*?)\}\}`)
+	// templatePathRegex matches the only supported expression - path into the template data
+	templatePathRegex = regexp.MustCompile(`^[?=]?\s*pydata((?:\.[A-Za-z0-9_-]+)+)$`)
+	// templateDelimitersReplacer removes template delimiters
+	templateDelimitersReplacer = strings.NewReplacer("{{", "", "}}", "")
+)
+
+// ruleContentKey identifies rule content by rule module and error key
+type ruleContentKey struct {
+	module   string
+	errorKey string
+}
+
+// resolveRuleContentTemplates substitutes placeholders in the rule content by the template data
+// of the corresponding rule hits. ResolutionIncomplete is set for rules with placeholders which
+// couldn't be resolved.
+func resolveRuleContentTemplates(rules []types.RuleContentResponse, hitRules []types.RuleOnReport) {
+	templateData := make(map[ruleContentKey]interface{}, len(hitRules))
+	for _, hitRule := range hitRules {
+		module := strings.TrimSuffix(hitRule.Module, ".report")
+		templateData[ruleContentKey{module: module, errorKey: hitRule.ErrorKey}] = hitRule.TemplateData
+	}
+
+	for i := range rules {
+		data := templateData[ruleContentKey{module: rules[i].RuleModule, errorKey: rules[i].ErrorKey}]
+
+		var descriptionComplete, genericComplete bool
+		rules[i].Description, descriptionComplete = resolveTemplate(rules[i].Description, data)
+		rules[i].Generic, genericComplete = resolveTemplate(rules[i].Generic, data)
+		rules[i].ResolutionIncomplete = !descriptionComplete || !genericComplete
+	}
+}
+
+// resolveTemplate substitutes placeholders in the text by values from the template data.
+// The placeholders are never evaluated, only paths into the template data are looked up,
+// and the substituted values are not expanded again. Placeholders which can't be resolved
+// are removed together with any other template syntax and false is returned.
+func resolveTemplate(text string, templateData interface{}) (string, bool) {
+	var resolved strings.Builder
+	complete := true
+	last := 0
+
+	matches := templatePlaceholderRegex.FindAllStringSubmatchIndex(text, -1)
+	for i, match := range matches {
+		value, found := "", false
+		if i < maxTemplatePlaceholders {
+			value, found = lookupTemplateValue(text[match[2]:match[3]], templateData)
+		}
+
+		before := text[last:match[0]]
+		if !found {
+			complete = false
+			// avoid double space in place of the removed placeholder
+			if strings.HasSuffix(before, " ") && match[1] < len(text) && text[match[1]] == ' ' {
+				before = before[:len(before)-1]
+			}
+		}
+
+		resolved.WriteString(before)
+		resolved.WriteString(value)
+		last = match[1]
+	}
+	resolved.WriteString(text[last:])
+
+	result := resolved.String()
+	if strings.Contains(result, "{{") || strings.Contains(result, "}}") {
+		complete = false
+		result = removeTemplateDelimiters(result)
+	}
+
+	return result, complete
+}
+
+// lookupTemplateValue returns value from the template data referenced by the placeholder expression
+func lookupTemplateValue(expression string, templateData interface{}) (string, bool) {
+	match := templatePathRegex.FindStringSubmatch(strings.TrimSpace(expression))
+	if match == nil {
+		return "", false
+	}
+
+	value := templateData
+	for _, key := range strings.Split(match[1][1:], ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			var found bool
+			if value, found = node[key]; !found {
+				return "", false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", false
+			}
+			value = node[index]
+		default:
+			return "", false
+		}
+	}
+
+	return formatTemplateValue(value)
+}
+
+// formatTemplateValue converts value from the template data to text
+func formatTemplateValue(value interface{}) (string, bool) {
+	var text string
+
+	switch typedValue := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		text = typedValue
+	case float64:
+		text = strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case bool:
+		text = strconv.FormatBool(typedValue)
+	default:
+		encoded, err := json.Marshal(typedValue)
+		if err != nil {
+			return "", false
+		}
+		text = string(encoded)
+	}
+
+	if runes := []rune(text); len(runes) > maxTemplateValueLength {
+		text = string(runes[:maxTemplateValueLength])
+	}
+
+	return removeTemplateDelimiters(text), true
+}
+
+// removeTemplateDelimiters removes all template delimiters from the text
+func removeTemplateDelimiters(text string) string {
+	for strings.Contains(text, "{{") || strings.Contains(text, "}}") {
+		text = templateDelimitersReplacer.Replace(text)
+	}
+
+	return text
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/server"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+)
+
+const templateData = `{
+	"kind": "Node",
+	"count": 3,
+	"degraded": true,
+	"missing": null,
+	"nodes": [{"name": "node1", "roles": ["master"]}, {"name": "node2"}],
+	"versions": {"current": "4.3.1", "desired": {"major": 4, "minor": 4}},
+	"injected": "{{?pydata.kind}}"
+}`
+
+func TestResolveTemplate(t *testing.T) {
+	var data interface{}
+	err := json.Unmarshal([]byte(templateData), &data)
+	helpers.FailOnError(t, err)
+
+	for _, testCase := range []struct {
+		name             string
+		text             string
+		expectedText     string
+		expectedComplete bool
+	}{
+		{"no placeholders", "Cluster is fine.", "Cluster is fine.", true},
+		{"present", "{{?pydata.kind}} is degraded.", "Node is degraded.", true},
+		{"interpolation", "{{=pydata.kind}} is degraded.", "Node is degraded.", true},
+		{"spaces", "{{? pydata.kind }} is degraded.", "Node is degraded.", true},
+		{"number and bool", "{{?pydata.count}} {{?pydata.degraded}}", "3 true", true},
+		{"nested map", "Upgrade to {{?pydata.versions.desired.minor}}.", "Upgrade to 4.", true},
+		{"nested array", "Node {{?pydata.nodes.1.name}} is {{?pydata.nodes.0.roles.0}}.", "Node node2 is master.", true},
+		{"compound value", "Desired {{?pydata.versions.desired}}", `Desired {"major":4,"minor":4}`, true},
+		{"missing", "Node {{?pydata.node}} is degraded.", "Node is degraded.", false},
+		{"missing nested", "Node {{?pydata.nodes.5.name}} is degraded.", "Node is degraded.", false},
+		{"null value", "Value {{?pydata.missing}}", "Value ", false},
+		{"path through scalar", "{{?pydata.kind.name}}", "", false},
+		{"negative index", "{{?pydata.nodes.-1}}", "", false},
+		{"not pydata", "Kind: {{= it.constructor('return 1')() }}.", "Kind: .", false},
+		{"conditional end", "{{?pydata.kind}}{{?}} done", "Node done", false},
+		{"unterminated", "Kind {{?pydata.kind is", "Kind ?pydata.kind is", false},
+		{"stray closing", "Kind }} is", "Kind  is", false},
+		{"value is not expanded", "{{?pydata.injected}}", "?pydata.kind", true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			text, complete := server.ResolveTemplate(testCase.text, data)
+			assert.Equal(t, testCase.expectedText, text)
+			assert.Equal(t, testCase.expectedComplete, complete)
+		})
+	}
+}
+
+func TestResolveTemplateNoData(t *testing.T) {
+	text, complete := server.ResolveTemplate("Node {{?pydata.kind}} is degraded.", nil)
+	assert.Equal(t, "Node is degraded.", text)
+	assert.False(t, complete)
+}
+
+// TestResolveTemplateBoundedExpansion checks that the size of the resolved text is bounded
+func TestResolveTemplateBoundedExpansion(t *testing.T) {
+	data := map[string]interface{}{"long": strings.Repeat("x", 10000)}
+
+	text, complete := server.ResolveTemplate(strings.Repeat("{{?pydata.long}}", 1000), data)
+	assert.False(t, complete)
+	// only limited number of placeholders is resolved and values are truncated
+	assert.Equal(t, 100*1024, len(text))
+	assert.NotContains(t, text, "{{")
+}
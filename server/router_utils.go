@@ -15,17 +15,21 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
 	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
@@ -67,6 +71,20 @@ func getRouterPositiveIntParam(request *http.Request, paramName string) (uint64,
 	return uintValue, nil
 }
 
+// checkOrgIDRange checks that the organization ID read from parameter is positive
+// and not higher than types.MaxOrgID, so it can be stored
+func checkOrgIDRange(paramName, paramValue string, orgID uint64) error {
+	if orgID == 0 || types.OrgID(orgID) > types.MaxOrgID {
+		return &RouterParsingError{
+			paramName:  paramName,
+			paramValue: paramValue,
+			errString:  fmt.Sprintf("organization ID in range 1..%v expected", uint64(types.MaxOrgID)),
+		}
+	}
+
+	return nil
+}
+
 // validateClusterName checks that the cluster name is a valid UUID.
 // Converted cluster name is returned if everything is okay, otherwise an error is returned.
 func validateClusterName(writer http.ResponseWriter, clusterName string) (types.ClusterName, error) {
@@ -124,6 +142,9 @@ func readClusterName(writer http.ResponseWriter, request *http.Request) (types.C
 // if it's not possible, it writes http error to the writer and returns error
 func readOrganizationID(writer http.ResponseWriter, request *http.Request, auth bool) (types.OrgID, error) {
 	organizationID, err := getRouterPositiveIntParam(request, "organization")
+	if err == nil {
+		err = checkOrgIDRange("organization", strconv.FormatUint(organizationID, 10), organizationID)
+	}
 	if err != nil {
 		handleOrgIDError(writer, err)
 		return 0, err
@@ -173,6 +194,78 @@ func readClusterNames(writer http.ResponseWriter, request *http.Request) ([]type
 	return clusterNamesConverted, nil
 }
 
+// readClusterNamesFromBody reads list of cluster names from request body in format {"clusters": [...]}
+// if it's not possible or there are more than maxClusters, it writes http error to the writer and returns error
+func readClusterNamesFromBody(
+	writer http.ResponseWriter, request *http.Request, maxClusters int,
+) ([]types.ClusterName, error) {
+	var body struct {
+		Clusters []string `json:"clusters"`
+	}
+
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		bodyErr := &RouterBodyError{errString: err.Error()}
+		handleServerError(writer, bodyErr)
+		return []types.ClusterName{}, bodyErr
+	}
+
+	if len(body.Clusters) == 0 || len(body.Clusters) > maxClusters {
+		bodyErr := &RouterBodyError{
+			errString: fmt.Sprintf("between 1 and %v clusters expected, got %v", maxClusters, len(body.Clusters)),
+		}
+		handleServerError(writer, bodyErr)
+		return []types.ClusterName{}, bodyErr
+	}
+
+	clusterNamesConverted := make([]types.ClusterName, 0, len(body.Clusters))
+	for _, clusterName := range body.Clusters {
+		convertedName, err := validateClusterName(writer, clusterName)
+		if err != nil {
+			handleServerError(writer, err)
+			return []types.ClusterName{}, err
+		}
+
+		clusterNamesConverted = append(clusterNamesConverted, convertedName)
+	}
+
+	return clusterNamesConverted, nil
+}
+
+// readReportMessageFromBody reads whole request body with uploaded report limited to maxBodySize bytes,
+// if it's not possible, it writes http error to the writer and returns error
+// readFeedbackMessageFromBody reads feedback message from request body in format {"message": "..."}
+// if it's not possible, it writes http error to the writer and returns error
+func readFeedbackMessageFromBody(writer http.ResponseWriter, request *http.Request) (string, error) {
+	var body struct {
+		Message *string `json:"message"`
+	}
+
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		bodyErr := &RouterBodyError{errString: err.Error()}
+		handleServerError(writer, bodyErr)
+		return "", bodyErr
+	}
+
+	if body.Message == nil {
+		bodyErr := &RouterBodyError{errString: "missing required attribute 'message'"}
+		handleServerError(writer, bodyErr)
+		return "", bodyErr
+	}
+
+	return *body.Message, nil
+}
+
+func readReportMessageFromBody(writer http.ResponseWriter, request *http.Request, maxBodySize int64) ([]byte, error) {
+	messageValue, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, maxBodySize))
+	if err != nil {
+		bodyErr := &RouterBodyError{errString: err.Error()}
+		handleServerError(writer, bodyErr)
+		return nil, bodyErr
+	}
+
+	return messageValue, nil
+}
+
 // readOrganizationIDs does the same as `readOrganizationID`, except for multiple organizations.
 func readOrganizationIDs(writer http.ResponseWriter, request *http.Request) ([]types.OrgID, error) {
 	organizationsParam, err := getRouterParam(request, "organizations")
@@ -192,6 +285,12 @@ func readOrganizationIDs(writer http.ResponseWriter, request *http.Request) ([]t
 			})
 			return []types.OrgID{}, err
 		}
+
+		if err := checkOrgIDRange("organizations", orgStr, orgInt); err != nil {
+			handleServerError(writer, err)
+			return []types.OrgID{}, err
+		}
+
 		organizationsConverted = append(organizationsConverted, types.OrgID(orgInt))
 	}
 
@@ -224,3 +323,285 @@ func readRuleID(writer http.ResponseWriter, request *http.Request) (types.RuleID
 
 	return types.RuleID(ruleID), nil
 }
+
+// readErrorKey retrieves optional error key of the rule from the query string, empty error key
+// is returned when it's not set, which means the whole rule. If it's invalid,
+// it writes http error to the writer and returns error
+func readErrorKey(writer http.ResponseWriter, request *http.Request) (types.ErrorKey, error) {
+	errorKey := request.URL.Query().Get("error_key")
+	if len(errorKey) == 0 {
+		return "", nil
+	}
+
+	errorKeyValidator := regexp.MustCompile(`^[a-zA-Z_0-9]+$`)
+
+	if !errorKeyValidator.MatchString(errorKey) {
+		err := &RouterParsingError{
+			paramName:  "error_key",
+			paramValue: errorKey,
+			errString:  "invalid error key, it must contain only from latin characters, number or underscores",
+		}
+		handleServerError(writer, err)
+		return "", err
+	}
+
+	return types.ErrorKey(errorKey), nil
+}
+
+// readRequiredQueryParam retrieves value of the query parameter,
+// if it's missing, it writes http error to the writer and returns error
+func readRequiredQueryParam(writer http.ResponseWriter, request *http.Request, paramName string) (string, error) {
+	value := request.URL.Query().Get(paramName)
+	if len(value) == 0 {
+		err := &RouterMissingParamError{paramName: paramName}
+		handleServerError(writer, err)
+		return "", err
+	}
+
+	return value, nil
+}
+
+// readStalenessThreshold retrieves optional staleness threshold from the query string
+// and returns defaultThreshold if it's not provided
+// if it's not possible to parse it, it writes http error to the writer and returns error
+func readStalenessThreshold(
+	writer http.ResponseWriter, request *http.Request, defaultThreshold time.Duration,
+) (time.Duration, error) {
+	value := request.URL.Query().Get("staleness_threshold")
+	if len(value) == 0 {
+		return defaultThreshold, nil
+	}
+
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold < 0 {
+		err := &RouterParsingError{
+			paramName:  "staleness_threshold",
+			paramValue: value,
+			errString:  "non-negative duration expected",
+		}
+		handleServerError(writer, err)
+		return 0, err
+	}
+
+	return threshold, nil
+}
+
+// readNonNegativeIntQueryParam retrieves optional non-negative integer from the query string,
+// zero is returned if it's not provided
+func readNonNegativeIntQueryParam(writer http.ResponseWriter, request *http.Request, paramName string) (int, error) {
+	value := request.URL.Query().Get(paramName)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		err := &RouterParsingError{
+			paramName:  paramName,
+			paramValue: value,
+			errString:  "non-negative integer expected",
+		}
+		handleServerError(writer, err)
+		return 0, err
+	}
+
+	return number, nil
+}
+
+// readHitsHistoryDays retrieves optional number of days of the hits count history from the query string,
+// defaultHitsHistoryDays is returned if it's not provided and the number is capped at
+// storage.MaxHitsCountHistoryDays, if it's not a positive integer, it writes http error
+// to the writer and returns error
+func readHitsHistoryDays(writer http.ResponseWriter, request *http.Request) (int, error) {
+	value := request.URL.Query().Get("days")
+	if len(value) == 0 {
+		return defaultHitsHistoryDays, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days <= 0 {
+		err := &RouterParsingError{
+			paramName:  "days",
+			paramValue: value,
+			errString:  "positive integer expected",
+		}
+		handleServerError(writer, err)
+		return 0, err
+	}
+
+	if days > storage.MaxHitsCountHistoryDays {
+		days = storage.MaxHitsCountHistoryDays
+	}
+
+	return days, nil
+}
+
+// readConsumerErrorsLimit retrieves optional number of failures of processing of consumed messages
+// from the query string, defaultConsumerErrorsLimit is returned if it's not provided and the number
+// is capped at maxConsumerErrorsLimit, if it's not a positive integer, it writes http error
+// to the writer and returns error
+func readConsumerErrorsLimit(writer http.ResponseWriter, request *http.Request) (int, error) {
+	value := request.URL.Query().Get("limit")
+	if len(value) == 0 {
+		return defaultConsumerErrorsLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		err := &RouterParsingError{
+			paramName:  "limit",
+			paramValue: value,
+			errString:  "positive integer expected",
+		}
+		handleServerError(writer, err)
+		return 0, err
+	}
+
+	if limit > maxConsumerErrorsLimit {
+		limit = maxConsumerErrorsLimit
+	}
+
+	return limit, nil
+}
+
+// readAuditLogSince retrieves optional time in RFC3339 format from which entries of the audit log
+// are returned from the query string, zero time is returned if it's not provided, if it's not valid,
+// it writes http error to the writer and returns error
+func readAuditLogSince(writer http.ResponseWriter, request *http.Request) (time.Time, error) {
+	value := request.URL.Query().Get("since")
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		err := &RouterParsingError{
+			paramName:  "since",
+			paramValue: value,
+			errString:  "RFC3339 timestamp expected",
+		}
+		handleServerError(writer, err)
+		return time.Time{}, err
+	}
+
+	return since, nil
+}
+
+// readAuditLogLimit retrieves optional number of entries of the audit log from the query string,
+// defaultAuditLogLimit is returned if it's not provided and the number is capped at maxAuditLogLimit,
+// if it's not a positive integer, it writes http error to the writer and returns error
+func readAuditLogLimit(writer http.ResponseWriter, request *http.Request) (int, error) {
+	value := request.URL.Query().Get("limit")
+	if len(value) == 0 {
+		return defaultAuditLogLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		err := &RouterParsingError{
+			paramName:  "limit",
+			paramValue: value,
+			errString:  "positive integer expected",
+		}
+		handleServerError(writer, err)
+		return 0, err
+	}
+
+	if limit > maxAuditLogLimit {
+		limit = maxAuditLogLimit
+	}
+
+	return limit, nil
+}
+
+// readMinRisk retrieves optional minimal total risk of rules from the query string,
+// zero is returned if it's not provided, if it's not one of known total risks,
+// it writes http error to the writer and returns error
+func readMinRisk(writer http.ResponseWriter, request *http.Request) (int, error) {
+	value := request.URL.Query().Get("min_risk")
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	minRisk, err := strconv.Atoi(value)
+	if err != nil || minRisk < minTotalRisk || minRisk > maxTotalRisk {
+		err := &RouterParsingError{
+			paramName:  "min_risk",
+			paramValue: value,
+			errString:  fmt.Sprintf("allowed values are %v-%v", minTotalRisk, maxTotalRisk),
+		}
+		handleServerError(writer, err)
+		return 0, err
+	}
+
+	return minRisk, nil
+}
+
+// readOptionalBoolQueryParam retrieves boolean query parameter, nil is returned when it's not set,
+// if it's not possible to parse it, it writes http error to the writer and returns error
+func readOptionalBoolQueryParam(writer http.ResponseWriter, request *http.Request, paramName string) (*bool, error) {
+	value := request.URL.Query().Get(paramName)
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		err := &RouterParsingError{
+			paramName:  paramName,
+			paramValue: value,
+			errString:  "boolean expected",
+		}
+		handleServerError(writer, err)
+		return nil, err
+	}
+
+	return &boolValue, nil
+}
+
+// readIncludeTimestamps retrieves optional include query parameter of the list of clusters,
+// true is returned when the timestamps of the reports should be included, if it has any other
+// value, it writes http error to the writer and returns error
+func readIncludeTimestamps(writer http.ResponseWriter, request *http.Request) (bool, error) {
+	value := request.URL.Query().Get("include")
+	switch value {
+	case "":
+		return false, nil
+	case "timestamps":
+		return true, nil
+	default:
+		err := &RouterParsingError{
+			paramName:  "include",
+			paramValue: value,
+			errString:  "allowed value is timestamps",
+		}
+		handleServerError(writer, err)
+		return false, err
+	}
+}
+
+// readRuleFilter retrieves filter of rules from the query string,
+// if it's not possible to parse it, it writes http error to the writer and returns error
+func readRuleFilter(writer http.ResponseWriter, request *http.Request) (storage.RuleFilter, error) {
+	var filter storage.RuleFilter
+
+	var err error
+	filter.Active, err = readOptionalBoolQueryParam(writer, request, "active")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.ModulePrefix = request.URL.Query().Get("module_prefix")
+
+	filter.Limit, err = readNonNegativeIntQueryParam(writer, request, "limit")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Offset, err = readNonNegativeIntQueryParam(writer, request, "offset")
+	if err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
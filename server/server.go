@@ -30,6 +30,9 @@ limitations under the License.
 //
 // API_PREFIX/rule/{cluster}/{rule_id}/reset_vote- reset vote for a rule for cluster with current user (from auth token)
 //
+// API_PREFIX/clusters/{cluster}/report - upload report for cluster in the same format as Kafka message (HTTP POST),
+// available only when report upload is enabled in configuration
+//
 // Please note that API_PREFIX is part of server configuration (see Configuration). Also please note that
 // JSON format is used to transfer data between server and clients.
 //
@@ -45,8 +48,10 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -55,23 +60,103 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/RedHatInsights/insights-operator-utils/responses"
+	"github.com/RedHatInsights/insights-results-aggregator/consumer"
 	"github.com/RedHatInsights/insights-results-aggregator/metrics"
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
 	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
-// HTTPServer in an implementation of Server interface
+// maxClustersInDeletionBatch is the maximum number of clusters deleted by one request,
+// it also keeps number of SQL parameters under the SQLite limit
+const maxClustersInDeletionBatch = 500
+
+// defaultHitsHistoryDays is the number of days of the hits count history returned
+// when it's not specified in the request
+const defaultHitsHistoryDays = 30
+
+// number of failures of processing of consumed messages returned when it's not specified
+// in the request and the maximum number of them returned at once
+const (
+	defaultConsumerErrorsLimit = 100
+	maxConsumerErrorsLimit     = 1000
+)
+
+// number of entries of the audit log returned when it's not specified in the request
+// and the maximum number of them returned at once
+const (
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+// range of total risk of rules, it's the average of impact and likelihood of the error key
+const (
+	minTotalRisk = 1
+	maxTotalRisk = 4
+)
+
+// staleReportWarning is sent in Warning header together with reports older than the staleness threshold
+const staleReportWarning = `110 - "Response is Stale"`
+
+// values of rule verification configured for feedback endpoints
+const (
+	// RuleVerificationContent accepts only rules from the loaded rule content
+	RuleVerificationContent = "content"
+	// RuleVerificationReport accepts also rules hit in the cluster's report whose content is missing
+	RuleVerificationReport = "report"
+	// RuleVerificationDisabled accepts any rule
+	RuleVerificationDisabled = "disabled"
+)
+
+// default limits for uploading reports over HTTP
+const (
+	defaultReportUploadMaxBodySize = 10 * 1024 * 1024
+	defaultReportUploadRateLimit   = 60
+)
+
+// timeNow returns the current time, it can be replaced in tests to control the clock
+var timeNow = time.Now
+
+// LivenessCheck reports that a component running in the same process as the server is stuck
+type LivenessCheck interface {
+	CheckLiveness() error
+}
+
+// Storage is the part of the storage used by the server, the server doesn't initialize
+// the storage and it doesn't manage rules acked or disabled by organizations, it only hides
+// the disabled rules in reports of clusters
+type Storage interface {
+	Ping() error
+	storage.ReportReader
+	storage.ReportWriter
+	storage.ReportCleaner
+	storage.RuleToggleReader
+	storage.FeedbackStorage
+	storage.RuleContentStorage
+	storage.ConsistencyStorage
+	storage.AuditLogStorage
+}
+
+// HTTPServer in an implementation of Server interface,
+// the liveness endpoint fails when Liveness is set and it reports an error
 type HTTPServer struct {
-	Config  Configuration
-	Storage storage.Storage
-	Serv    *http.Server
+	Config        Configuration
+	Storage       Storage
+	Serv          *http.Server
+	Liveness      LivenessCheck
+	uploadLimiter *rateLimiter
 }
 
 // New constructs new implementation of Server interface
-func New(config Configuration, storage storage.Storage) *HTTPServer {
+func New(config Configuration, storage Storage) *HTTPServer {
+	uploadRateLimit := config.ReportUploadRateLimit
+	if uploadRateLimit <= 0 {
+		uploadRateLimit = defaultReportUploadRateLimit
+	}
+
 	return &HTTPServer{
-		Config:  config,
-		Storage: storage,
+		Config:        config,
+		Storage:       storage,
+		uploadLimiter: newRateLimiter(uploadRateLimit, time.Minute),
 	}
 }
 
@@ -79,10 +164,18 @@ func logRequestHandler(writer http.ResponseWriter, request *http.Request, nextHa
 	log.Print("Request URI: " + request.RequestURI)
 	log.Print("Request method: " + request.Method)
 	metrics.APIRequests.With(prometheus.Labels{"url": request.RequestURI}).Inc()
+	request, calls := withStorageCalls(request)
 	startTime := time.Now()
 	nextHandler.ServeHTTP(writer, request)
 	duration := time.Since(startTime)
 	metrics.APIResponsesTime.With(prometheus.Labels{"url": request.RequestURI}).Observe(float64(duration.Microseconds()))
+	log.Info().
+		Str("method", request.Method).
+		Str("uri", request.RequestURI).
+		Dur("duration", duration).
+		Int64("storage_calls", calls.Count()).
+		Dur("storage_duration", calls.Duration()).
+		Msg("Request served")
 }
 
 // LogRequest - middleware for logging requests
@@ -93,41 +186,441 @@ func (server *HTTPServer) LogRequest(nextHandler http.Handler) http.Handler {
 		})
 }
 
-func (server *HTTPServer) mainEndpoint(writer http.ResponseWriter, _ *http.Request) {
-	err := responses.SendResponse(writer, responses.BuildOkResponse())
+func (server *HTTPServer) mainEndpoint(writer http.ResponseWriter, _ *http.Request) {
+	err := responses.SendResponse(writer, responses.BuildOkResponse())
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// readyEndpoint checks that the storage is reachable, it's used by readiness probes
+func (server *HTTPServer) readyEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if err := server.storageFor(request).Ping(); err != nil {
+		log.Error().Err(err).Msg("Storage is not reachable")
+		err = responses.Send(http.StatusServiceUnavailable, writer, responses.BuildResponse(err.Error()))
+		if err != nil {
+			log.Error().Err(err).Msg(responseDataError)
+		}
+		return
+	}
+
+	err := responses.SendResponse(writer, responses.BuildOkResponse())
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// liveEndpoint checks that no component of the service is stuck, it's used by liveness probes
+func (server *HTTPServer) liveEndpoint(writer http.ResponseWriter, _ *http.Request) {
+	if server.Liveness != nil {
+		if err := server.Liveness.CheckLiveness(); err != nil {
+			log.Error().Err(err).Msg("Service is not alive")
+			err = responses.Send(http.StatusServiceUnavailable, writer, responses.BuildResponse(err.Error()))
+			if err != nil {
+				log.Error().Err(err).Msg(responseDataError)
+			}
+			return
+		}
+	}
+
+	err := responses.SendResponse(writer, responses.BuildOkResponse())
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+func (server *HTTPServer) listOfOrganizations(writer http.ResponseWriter, request *http.Request) {
+	organizations, err := server.storageFor(request).ListOfOrgs()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get list of organizations")
+		handleServerError(writer, err)
+		return
+	}
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("organizations", organizations))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+func (server *HTTPServer) clustersCountPerOrg(writer http.ResponseWriter, request *http.Request) {
+	counts, err := server.storageFor(request).ClustersCountPerOrg()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get number of clusters per organization")
+		handleServerError(writer, err)
+		return
+	}
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("clusters_count", counts))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// listFeedbacksForCluster returns feedback left by all users on rules for the cluster
+func (server *HTTPServer) listFeedbacksForCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName, err := readClusterName(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	feedbacks, err := server.storageFor(request).ListFeedbacksForCluster(clusterName)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get feedbacks for cluster")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("feedbacks", feedbacks))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// listRules returns rules of the loaded rule content matching the filter from the query string
+func (server *HTTPServer) listRules(writer http.ResponseWriter, request *http.Request) {
+	filter, err := readRuleFilter(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	rules, err := server.storageFor(request).ListRules(filter)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to list rules")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("rules", rules))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// checkConsistency checks consistency of rule hits with all reports on demand,
+// the check is interrupted when the client disconnects
+func (server *HTTPServer) checkConsistency(writer http.ResponseWriter, request *http.Request) {
+	repair, err := readOptionalBoolQueryParam(writer, request, "repair")
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	summary, err := storage.CheckConsistency(
+		request.Context(), server.storageFor(request), storage.DefaultConsistencyCheckBatchSize, repair != nil && *repair,
+	)
+	if errors.Is(err, storage.ErrConsistencyCheckInLegacyMode) {
+		err = responses.SendError(writer, err.Error())
+		if err != nil {
+			log.Error().Err(err).Msg(responseDataError)
+		}
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to check consistency of reports")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("consistency_check", summary))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// listConsistencyIssues returns reports found inconsistent by the consistency check
+func (server *HTTPServer) listConsistencyIssues(writer http.ResponseWriter, request *http.Request) {
+	issues, err := server.storageFor(request).ListConsistencyIssues()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to list consistency issues")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("issues", issues))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// listConsumerErrors returns the most recent failures of processing of consumed messages
+// including the original errors and the messages
+func (server *HTTPServer) listConsumerErrors(writer http.ResponseWriter, request *http.Request) {
+	limit, err := readConsumerErrorsLimit(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	consumerErrors, err := server.storageFor(request).ListConsumerErrors(limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to list consumer errors")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("consumer_errors", consumerErrors))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// listAuditLog returns the most recent destructive operations of the storage
+func (server *HTTPServer) listAuditLog(writer http.ResponseWriter, request *http.Request) {
+	since, err := readAuditLogSince(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	limit, err := readAuditLogLimit(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	entries, err := server.storageFor(request).ListAuditLog(since, limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to list audit log")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("audit_log", entries))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// getContentChanges returns rules that changed between two versions of rule content,
+// 404 is returned when any of the versions is no longer kept in the content history
+func (server *HTTPServer) getContentChanges(writer http.ResponseWriter, request *http.Request) {
+	fromChecksum, err := readRequiredQueryParam(writer, request, "from")
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	toChecksum, err := readRequiredQueryParam(writer, request, "to")
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	changes, err := server.storageFor(request).GetContentChanges(fromChecksum, toChecksum)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get changes of rule content")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("changes", changes))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// readHitsHistoryForCluster returns number of rules hit by the cluster for each of the last days,
+// unknown cluster is not found, while the cluster which sent only empty reports has no hits
+func (server *HTTPServer) readHitsHistoryForCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName, err := readClusterName(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	days, err := readHitsHistoryDays(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	// history of the unknown cluster would be the same as the history of the cluster without hits
+	exists, err := server.storageFor(request).DoesClusterExist(clusterName)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to check whether the cluster exists")
+		handleServerError(writer, err)
+		return
+	}
+	if !exists {
+		notFound := &storage.ItemNotFoundError{Kind: storage.ItemKindCluster, IDs: []interface{}{clusterName}}
+		handleServerError(writer, notFound)
+		return
+	}
+
+	history, err := server.storageFor(request).GetHitsCountHistory(clusterName, days)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read hits count history for cluster")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("history", history))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// readProcessingErrorsForCluster returns the most recent failures of processing of reports
+// consumed for the cluster, so users can see why the report of the cluster is not updated
+func (server *HTTPServer) readProcessingErrorsForCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName, err := readClusterName(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	processingErrors, err := server.storageFor(request).GetProcessingErrorsForCluster(
+		clusterName, storage.MaxProcessingErrors,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read processing errors for cluster")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("processing_errors", processingErrors))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+func (server *HTTPServer) listOfClustersForOrganization(writer http.ResponseWriter, request *http.Request) {
+	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
+
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	includeTimestamps, err := readIncludeTimestamps(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	var clusters interface{}
+	if includeTimestamps {
+		clusters, err = server.storageFor(request).ListOfClustersForOrgWithTimestamps(organizationID)
+	} else {
+		clusters, err = server.storageFor(request).ListOfClustersForOrg(organizationID)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get list of clusters")
+		handleServerError(writer, err)
+		return
+	}
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("clusters", clusters))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+func (server *HTTPServer) readRuleFeedbackStatsForOrganization(writer http.ResponseWriter, request *http.Request) {
+	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	stats, err := server.storageFor(request).GetRuleFeedbackStatsForOrg(organizationID)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read feedback stats of rules for organization")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("feedback_stats", stats))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// organizationOverview contains statistics of clusters of the organization
+// and of rules silenced for them
+type organizationOverview struct {
+	types.OrgStats
+	Silenced storage.SilencingStats `json:"silenced"`
+}
+
+func (server *HTTPServer) readOrganizationOverview(writer http.ResponseWriter, request *http.Request) {
+	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	var overview organizationOverview
+
+	overview.OrgStats, err = server.storageFor(request).GetOrgStatistics(organizationID)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read statistics of organization")
+		handleServerError(writer, err)
+		return
+	}
+
+	overview.Silenced, err = server.storageFor(request).GetSilencingStatsForOrg(organizationID)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read silencing statistics of organization")
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("overview", overview))
 	if err != nil {
 		log.Error().Err(err).Msg(responseDataError)
 	}
 }
 
-func (server *HTTPServer) listOfOrganizations(writer http.ResponseWriter, _ *http.Request) {
-	organizations, err := server.Storage.ListOfOrgs()
+func (server *HTTPServer) readRuleHitsForCluster(writer http.ResponseWriter, request *http.Request) {
+	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
 	if err != nil {
-		log.Error().Err(err).Msg("Unable to get list of organizations")
+		// everything has been handled already
+		return
+	}
+
+	clusterName, err := readClusterName(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	ruleHits, err := server.storageFor(request).GetRuleHitsForCluster(organizationID, clusterName)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read rule hits for cluster")
 		handleServerError(writer, err)
 		return
 	}
-	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("organizations", organizations))
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("rules", ruleHits))
 	if err != nil {
 		log.Error().Err(err).Msg(responseDataError)
 	}
 }
 
-func (server *HTTPServer) listOfClustersForOrganization(writer http.ResponseWriter, request *http.Request) {
+// readReportMetainfo returns times, Kafka offset and number of rules hit of the latest report of the cluster,
+// the report itself is not read
+func (server *HTTPServer) readReportMetainfo(writer http.ResponseWriter, request *http.Request) {
 	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
 
+	clusterName, err := readClusterName(writer, request)
 	if err != nil {
 		// everything has been handled already
 		return
 	}
 
-	clusters, err := server.Storage.ListOfClustersForOrg(organizationID)
+	metainfo, err := server.storageFor(request).ReadReportMetainfo(organizationID, clusterName)
 	if err != nil {
-		log.Error().Err(err).Msg("Unable to get list of clusters")
+		log.Error().Err(err).Msg("Unable to read report info for cluster")
 		handleServerError(writer, err)
 		return
 	}
-	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("clusters", clusters))
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("info", metainfo))
 	if err != nil {
 		log.Error().Err(err).Msg(responseDataError)
 	}
@@ -143,6 +636,7 @@ func getTotalRuleCount(reportRules types.ReportRules) int {
 // getContentForRules returns the hit rules from the report, as well as total count of all rules (skipped, ..)
 func (server *HTTPServer) getContentForRules(
 	writer http.ResponseWriter,
+	request *http.Request,
 	report types.ClusterReport,
 ) ([]types.RuleContentResponse, int, error) {
 	var reportRules types.ReportRules
@@ -156,16 +650,37 @@ func (server *HTTPServer) getContentForRules(
 
 	totalRules := getTotalRuleCount(reportRules)
 
-	hitRules, err := server.Storage.GetContentForRules(reportRules)
+	hitRules, err := server.storageFor(request).GetContentForRules(reportRules)
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to retrieve rules content from database")
 		handleServerError(writer, err)
 		return nil, 0, err
 	}
 
+	resolveRuleContentTemplates(hitRules, reportRules.HitRules)
+
 	return hitRules, totalRules, nil
 }
 
+// lastProcessingErrorAfter returns time of the most recent failure of processing of a report
+// consumed for the cluster when it's newer than the report checked at lastChecked, nil
+// is returned otherwise. The time is just a hint, so errors of the storage are only logged.
+func (server *HTTPServer) lastProcessingErrorAfter(
+	request *http.Request, clusterName types.ClusterName, lastChecked time.Time,
+) *types.Timestamp {
+	processingErrors, err := server.storageFor(request).GetProcessingErrorsForCluster(clusterName, 1)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read processing errors for cluster")
+		return nil
+	}
+
+	if len(processingErrors) == 0 || !processingErrors[0].FailedAt.After(lastChecked) {
+		return nil
+	}
+
+	return &processingErrors[0].FailedAt
+}
+
 func (server *HTTPServer) readReportForCluster(writer http.ResponseWriter, request *http.Request) {
 	organizationID, err := readOrganizationID(writer, request, server.Config.Auth)
 	if err != nil {
@@ -179,18 +694,39 @@ func (server *HTTPServer) readReportForCluster(writer http.ResponseWriter, reque
 		return
 	}
 
-	report, lastChecked, err := server.Storage.ReadReportForCluster(organizationID, clusterName)
+	stalenessThreshold, err := readStalenessThreshold(writer, request, server.Config.ReportStalenessThreshold)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	minRisk, err := readMinRisk(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	storedReport, err := server.storageFor(request).ReadReportForCluster(organizationID, clusterName)
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to read report for cluster")
 		handleServerError(writer, err)
 		return
 	}
 
-	rulesContent, rulesCount, err := server.getContentForRules(writer, report)
+	rulesContent, rulesCount, err := server.getContentForRules(writer, request, storedReport.Report)
 	if err != nil {
 		// everything has been handled already
 		return
 	}
+
+	disabledRules, err := server.storageFor(request).GetDisabledRulesForCluster(organizationID, clusterName)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read rules disabled for cluster")
+		handleServerError(writer, err)
+		return
+	}
+
+	rulesContent = filterDisabledRules(rulesContent, disabledRules)
 	hitRulesCount := len(rulesContent)
 	// -1 as count in response means there are no rules for this cluster
 	// as opposed to no rules hit for the cluster
@@ -200,20 +736,82 @@ func (server *HTTPServer) readReportForCluster(writer http.ResponseWriter, reque
 		rulesCount = hitRulesCount
 	}
 
+	stale := isReportStale(storedReport.LastCheckedAt, stalenessThreshold)
+	if stale {
+		writer.Header().Set("Warning", staleReportWarning)
+		metrics.StaleReportsServed.Inc()
+	}
+
 	response := types.ReportResponse{
 		Meta: types.ReportResponseMeta{
 			Count:         rulesCount,
-			LastCheckedAt: lastChecked,
+			LastCheckedAt: types.NewOptionalTimestamp(storedReport.LastCheckedAt),
+			ReportedAt:    types.NewOptionalTimestamp(storedReport.ReportedAt),
+			Stale:         stale,
 		},
 		Rules: rulesContent,
 	}
 
+	response.Meta.LastProcessingErrorAt = server.lastProcessingErrorAfter(request, clusterName, storedReport.LastCheckedAt)
+
+	if minRisk > 0 {
+		response.Rules = filterRulesByMinRisk(rulesContent, minRisk)
+		filteredCount := len(response.Rules)
+		response.Meta.FilteredCount = &filteredCount
+	}
+
 	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("report", response))
 	if err != nil {
 		log.Error().Err(err).Msg(responseDataError)
 	}
 }
 
+// filterRulesByMinRisk returns rules whose total risk is at least minRisk
+func filterRulesByMinRisk(rules []types.RuleContentResponse, minRisk int) []types.RuleContentResponse {
+	filtered := make([]types.RuleContentResponse, 0, len(rules))
+
+	for _, rule := range rules {
+		if rule.TotalRisk >= minRisk {
+			filtered = append(filtered, rule)
+		}
+	}
+
+	return filtered
+}
+
+// filterDisabledRules returns the rules which are not disabled for the cluster
+func filterDisabledRules(rules []types.RuleContentResponse, disabledRules []types.RuleID) []types.RuleContentResponse {
+	if len(disabledRules) == 0 {
+		return rules
+	}
+
+	disabled := make(map[types.RuleID]bool, len(disabledRules))
+	for _, ruleID := range disabledRules {
+		disabled[ruleID] = true
+	}
+
+	filtered := make([]types.RuleContentResponse, 0, len(rules))
+
+	for _, rule := range rules {
+		if !disabled[types.RuleID(rule.RuleModule)] {
+			filtered = append(filtered, rule)
+		}
+	}
+
+	return filtered
+}
+
+// isReportStale checks whether the report last checked at given time is older than the threshold,
+// threshold 0 means that reports are never considered stale. Reports with unknown time of the last
+// check (NULL in the storage) are not considered stale either, their staleness is unknown.
+func isReportStale(lastChecked time.Time, threshold time.Duration) bool {
+	if threshold <= 0 || lastChecked.IsZero() {
+		return false
+	}
+
+	return timeNow().Sub(lastChecked) > threshold
+}
+
 // likeRule likes the rule for current user
 func (server *HTTPServer) likeRule(writer http.ResponseWriter, request *http.Request) {
 	server.voteOnRule(writer, request, storage.UserVoteLike)
@@ -231,7 +829,7 @@ func (server *HTTPServer) resetVoteOnRule(writer http.ResponseWriter, request *h
 
 func (server *HTTPServer) checkVotePermissions(writer http.ResponseWriter, request *http.Request, clusterID types.ClusterName) error {
 	if server.Config.Auth {
-		orgID, err := server.Storage.GetOrgIDByClusterID(clusterID)
+		orgID, err := server.storageFor(request).GetOrgIDByClusterID(clusterID)
 		if err != nil {
 			log.Error().Err(err).Msg("Unable to get org id")
 			handleServerError(writer, err)
@@ -246,47 +844,192 @@ func (server *HTTPServer) checkVotePermissions(writer http.ResponseWriter, reque
 	return nil
 }
 
+// checkRuleExists checks that the rule is present in the loaded rule content according
+// to the configured rule verification, RuleNotFoundError is returned for unknown rules
+func (server *HTTPServer) checkRuleExists(
+	request *http.Request, ruleID types.RuleID, report types.ClusterReport,
+) error {
+	if server.Config.RuleVerification == RuleVerificationDisabled {
+		return nil
+	}
+
+	_, err := server.storageFor(request).GetRuleByID(ruleID)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	// content of a rule can be missing for a while after the rule starts being reported
+	if server.Config.RuleVerification == RuleVerificationReport && isRuleInReport(ruleID, report) {
+		return nil
+	}
+
+	return &RuleNotFoundError{ruleID: ruleID}
+}
+
+// isRuleInReport checks whether the rule was hit in the report
+func isRuleInReport(ruleID types.RuleID, report types.ClusterReport) bool {
+	var reportRules types.ReportRules
+
+	err := json.Unmarshal([]byte(report), &reportRules)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to parse cluster report")
+		return false
+	}
+
+	for _, rule := range reportRules.HitRules {
+		if types.RuleID(strings.TrimSuffix(rule.Module, ".report")) == ruleID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// feedbackTarget identifies the rule or its error key hit in the cluster
+// the feedback of the user is left on, empty error key means the whole rule
+type feedbackTarget struct {
+	clusterID types.ClusterName
+	ruleID    types.RuleID
+	errorKey  types.ErrorKey
+	userID    types.UserID
+}
+
+// readFeedbackIDs reads cluster, rule, optional error key and current user the feedback is left for,
+// if it's not possible, it writes http error to the writer and returns error
+func (server *HTTPServer) readFeedbackIDs(
+	writer http.ResponseWriter, request *http.Request,
+) (target feedbackTarget, err error) {
+	target.clusterID, err = readClusterName(writer, request)
+	if err != nil {
+		return target, err
+	}
+
+	target.ruleID, err = readRuleID(writer, request)
+	if err != nil {
+		return target, err
+	}
+
+	target.errorKey, err = readErrorKey(writer, request)
+	if err != nil {
+		return target, err
+	}
+
+	target.userID, err = server.GetCurrentUserID(request)
+	if err != nil {
+		const message = "Unable to get user id"
+		log.Error().Err(err).Msg(message)
+		handleServerError(writer, err)
+		return target, err
+	}
+
+	return target, nil
+}
+
+// readFeedbackTarget reads cluster, rule, optional error key and current user the feedback is left for
+// and checks that both cluster and rule exist and that the user has access to the cluster,
+// if it's not possible, it writes http error to the writer and returns error
+func (server *HTTPServer) readFeedbackTarget(
+	writer http.ResponseWriter, request *http.Request,
+) (feedbackTarget, error) {
+	target, err := server.readFeedbackIDs(writer, request)
+	if err != nil {
+		return target, err
+	}
+
+	// it's gonna raise an error if cluster does not exist
+	storedReport, err := server.storageFor(request).ReadReportForClusterByClusterName(target.clusterID)
+	if err != nil {
+		handleServerError(writer, err)
+		return target, err
+	}
+
+	err = server.checkRuleExists(request, target.ruleID, storedReport.Report)
+	if err != nil {
+		handleServerError(writer, err)
+		return target, err
+	}
+
+	err = server.checkVotePermissions(writer, request, target.clusterID)
+	if err != nil {
+		return target, err
+	}
+
+	return target, nil
+}
+
 func (server *HTTPServer) voteOnRule(writer http.ResponseWriter, request *http.Request, userVote storage.UserVote) {
-	clusterID, err := readClusterName(writer, request)
+	target, err := server.readFeedbackTarget(writer, request)
 	if err != nil {
 		// everything has been handled already
 		return
 	}
 
-	ruleID, err := readRuleID(writer, request)
+	if userVote == storage.UserVoteNone {
+		err = server.storageFor(request).ResetVoteOnRule(
+			target.clusterID, target.ruleID, target.errorKey, target.userID,
+		)
+	} else {
+		err = server.storageFor(request).VoteOnRule(
+			target.clusterID, target.ruleID, target.errorKey, target.userID, userVote,
+		)
+	}
+	if err != nil {
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponse())
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// addFeedbackOnRule stores message left by current user on the rule or its error key,
+// the vote is kept unchanged
+func (server *HTTPServer) addFeedbackOnRule(writer http.ResponseWriter, request *http.Request) {
+	target, err := server.readFeedbackTarget(writer, request)
 	if err != nil {
 		// everything has been handled already
 		return
 	}
 
-	userID, err := server.GetCurrentUserID(request)
+	message, err := readFeedbackMessageFromBody(writer, request)
 	if err != nil {
-		const message = "Unable to get user id"
-		log.Error().Err(err).Msg(message)
-		handleServerError(writer, err)
+		// everything has been handled already
 		return
 	}
 
-	// it's gonna raise an error if cluster does not exist
-	_, _, err = server.Storage.ReadReportForClusterByClusterName(clusterID)
+	err = server.storageFor(request).AddOrUpdateFeedbackOnRule(
+		target.clusterID, target.ruleID, target.errorKey, target.userID, message,
+	)
 	if err != nil {
 		handleServerError(writer, err)
 		return
 	}
 
-	_, err = server.Storage.GetRuleByID(ruleID)
+	err = responses.SendResponse(writer, responses.BuildOkResponse())
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// deleteFeedbackOnRule deletes vote and message left by current user on the rule or its error key
+func (server *HTTPServer) deleteFeedbackOnRule(writer http.ResponseWriter, request *http.Request) {
+	target, err := server.readFeedbackIDs(writer, request)
 	if err != nil {
-		handleServerError(writer, err)
+		// everything has been handled already
 		return
 	}
 
-	err = server.checkVotePermissions(writer, request, clusterID)
+	err = server.checkVotePermissions(writer, request, target.clusterID)
 	if err != nil {
 		// everything has been handled already
 		return
 	}
 
-	err = server.Storage.VoteOnRule(clusterID, ruleID, userID, userVote)
+	err = server.storageFor(request).DeleteUserFeedbackOnRule(
+		target.clusterID, target.ruleID, target.errorKey, target.userID,
+	)
 	if err != nil {
 		handleServerError(writer, err)
 		return
@@ -298,6 +1041,9 @@ func (server *HTTPServer) voteOnRule(writer http.ResponseWriter, request *http.R
 	}
 }
 
+// deleteOrganizations deletes reports of all organizations from the path
+// and responds with numbers of deleted rows for each organization. Reports
+// are only marked as deleted when query parameter `soft` is set
 func (server *HTTPServer) deleteOrganizations(writer http.ResponseWriter, request *http.Request) {
 	orgIds, err := readOrganizationIDs(writer, request)
 	if err != nil {
@@ -305,20 +1051,35 @@ func (server *HTTPServer) deleteOrganizations(writer http.ResponseWriter, reques
 		return
 	}
 
+	soft, err := readOptionalBoolQueryParam(writer, request, "soft")
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	deleted := make(map[types.OrgID]storage.DeletedRows, len(orgIds))
 	for _, org := range orgIds {
-		if err := server.Storage.DeleteReportsForOrg(org); err != nil {
+		if soft != nil && *soft {
+			deleted[org], err = softDeletedRows(server.storageFor(request).SoftDeleteReportsForOrg(org))
+		} else {
+			deleted[org], err = server.storageFor(request).DeleteReportsForOrg(org)
+		}
+		if err != nil {
 			log.Error().Err(err).Msg("Unable to delete reports")
 			handleServerError(writer, err)
 			return
 		}
 	}
 
-	err = responses.SendResponse(writer, responses.BuildOkResponse())
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("deleted", deleted))
 	if err != nil {
 		log.Error().Err(err).Msg(responseDataError)
 	}
 }
 
+// deleteClusters deletes reports of all clusters from the path
+// and responds with numbers of deleted rows for each cluster. Reports
+// are only marked as deleted when query parameter `soft` is set
 func (server *HTTPServer) deleteClusters(writer http.ResponseWriter, request *http.Request) {
 	clusterNames, err := readClusterNames(writer, request)
 	if err != nil {
@@ -326,20 +1087,153 @@ func (server *HTTPServer) deleteClusters(writer http.ResponseWriter, request *ht
 		return
 	}
 
+	soft, err := readOptionalBoolQueryParam(writer, request, "soft")
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	deleted := make(map[types.ClusterName]storage.DeletedRows, len(clusterNames))
 	for _, cluster := range clusterNames {
-		if err := server.Storage.DeleteReportsForCluster(cluster); err != nil {
+		if soft != nil && *soft {
+			deleted[cluster], err = softDeletedRows(server.storageFor(request).SoftDeleteReportsForCluster(cluster))
+		} else {
+			deleted[cluster], err = server.storageFor(request).DeleteReportsForCluster(cluster)
+		}
+		if err != nil {
 			log.Error().Err(err).Msg("Unable to delete reports")
 			handleServerError(writer, err)
 			return
 		}
 	}
 
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("deleted", deleted))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// softDeletedRows converts number of soft-deleted reports to the response of delete endpoints,
+// rows of other tables are kept until the soft-deleted reports are purged
+func softDeletedRows(reports int, err error) (storage.DeletedRows, error) {
+	return storage.DeletedRows{Reports: reports}, err
+}
+
+// restoreCluster restores soft-deleted report of the cluster from the path
+func (server *HTTPServer) restoreCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName, err := readClusterName(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	err = server.storageFor(request).RestoreCluster(clusterName)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to restore cluster")
+		handleServerError(writer, err)
+		return
+	}
+
 	err = responses.SendResponse(writer, responses.BuildOkResponse())
 	if err != nil {
 		log.Error().Err(err).Msg(responseDataError)
 	}
 }
 
+// deleteClustersBatch deletes reports for all clusters from request body in one batch
+// and responds with the result for each cluster
+func (server *HTTPServer) deleteClustersBatch(writer http.ResponseWriter, request *http.Request) {
+	clusterNames, err := readClusterNamesFromBody(writer, request, maxClustersInDeletionBatch)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	deletedClusters, err := server.storageFor(request).DeleteReportsForClusters(clusterNames)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to delete reports")
+		handleServerError(writer, err)
+		return
+	}
+
+	results := make(map[types.ClusterName]types.BatchItemStatus, len(clusterNames))
+	for _, cluster := range clusterNames {
+		results[cluster] = types.BatchItemNotFound
+	}
+	for _, cluster := range deletedClusters {
+		results[cluster] = types.BatchItemDeleted
+	}
+
+	err = responses.SendResponse(writer, responses.BuildOkResponseWithData("clusters", results))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// uploadReportForCluster stores the report uploaded in request body, the body has the same
+// format as Kafka message and it's processed in the same way as by consumer
+func (server *HTTPServer) uploadReportForCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName, err := readClusterName(writer, request)
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	if server.uploadLimiter != nil && !server.uploadLimiter.allow() {
+		handleServerError(writer, &RateLimitError{errString: "Too many reports uploaded, try again later"})
+		return
+	}
+
+	messageValue, err := readReportMessageFromBody(writer, request, server.reportUploadMaxBodySize())
+	if err != nil {
+		// everything has been handled already
+		return
+	}
+
+	// the envelope is fully validated later, only IDs are needed to check the permissions
+	var envelope struct {
+		OrgID       *types.OrgID       `json:"OrgID"`
+		ClusterName *types.ClusterName `json:"ClusterName"`
+	}
+	if json.Unmarshal(messageValue, &envelope) == nil {
+		if envelope.ClusterName != nil && *envelope.ClusterName != clusterName {
+			handleServerError(writer, &RouterBodyError{errString: "cluster name doesn't match the cluster in URL"})
+			return
+		}
+
+		if envelope.OrgID != nil {
+			err = checkPermissions(writer, request, *envelope.OrgID, server.Config.Auth)
+			if err != nil {
+				// everything has been handled already
+				return
+			}
+		}
+	}
+
+	logger := log.With().Str("source", "http").Logger()
+	storedReport, err := consumer.ProcessReportMessage(
+		server.storageFor(request), server.Config.OrgWhitelist, messageValue, logger,
+	)
+	if err != nil {
+		handleServerError(writer, err)
+		return
+	}
+
+	err = responses.SendCreated(writer, responses.BuildOkResponseWithData("report", storedReport))
+	if err != nil {
+		log.Error().Err(err).Msg(responseDataError)
+	}
+}
+
+// reportUploadMaxBodySize returns configured maximum size of uploaded report or the default one
+func (server *HTTPServer) reportUploadMaxBodySize() int64 {
+	if server.Config.ReportUploadMaxBodySize <= 0 {
+		return defaultReportUploadMaxBodySize
+	}
+
+	return server.Config.ReportUploadMaxBodySize
+}
+
 // serveAPISpecFile serves an OpenAPI specifications file specified in config file
 func (server HTTPServer) serveAPISpecFile(writer http.ResponseWriter, request *http.Request) {
 	absPath, err := filepath.Abs(server.Config.APISpecFile)
@@ -363,6 +1257,8 @@ func (server *HTTPServer) Initialize(address string) http.Handler {
 	apiPrefix := server.Config.APIPrefix
 
 	metricsURL := apiPrefix + MetricsEndpoint
+	readyURL := apiPrefix + ReadyEndpoint
+	liveURL := apiPrefix + LiveEndpoint
 	openAPIURL := apiPrefix + filepath.Base(server.Config.APISpecFile)
 
 	// enable authentication, but only if it is setup in configuration
@@ -374,6 +1270,8 @@ func (server *HTTPServer) Initialize(address string) http.Handler {
 		noAuthURLs := []string{
 			metricsURL,
 			openAPIURL,
+			readyURL,
+			liveURL,
 		}
 		router.Use(func(next http.Handler) http.Handler { return server.Authentication(next, noAuthURLs) })
 	}
@@ -383,15 +1281,50 @@ func (server *HTTPServer) Initialize(address string) http.Handler {
 		router.HandleFunc(apiPrefix+OrganizationsEndpoint, server.listOfOrganizations).Methods(http.MethodGet)
 		router.HandleFunc(apiPrefix+DeleteOrganizationsEndpoint, server.deleteOrganizations).Methods(http.MethodDelete)
 		router.HandleFunc(apiPrefix+DeleteClustersEndpoint, server.deleteClusters).Methods(http.MethodDelete)
+		router.HandleFunc(apiPrefix+DeleteClustersBatchEndpoint, server.deleteClustersBatch).Methods(http.MethodDelete)
+		router.HandleFunc(apiPrefix+RestoreClusterEndpoint, server.restoreCluster).Methods(http.MethodPut)
+		router.HandleFunc(apiPrefix+ClustersCountPerOrgEndpoint, server.clustersCountPerOrg).Methods(http.MethodGet)
+		router.HandleFunc(
+			apiPrefix+ExportReportsForOrganizationEndpoint, server.exportReportsForOrganization,
+		).Methods(http.MethodGet)
+		router.HandleFunc(apiPrefix+FeedbacksForClusterEndpoint, server.listFeedbacksForCluster).Methods(http.MethodGet)
+		router.HandleFunc(apiPrefix+RulesEndpoint, server.listRules).Methods(http.MethodGet)
+		router.HandleFunc(apiPrefix+ConsistencyCheckEndpoint, server.checkConsistency).Methods(http.MethodPost)
+		router.HandleFunc(apiPrefix+ConsistencyIssuesEndpoint, server.listConsistencyIssues).Methods(http.MethodGet)
+		router.HandleFunc(apiPrefix+ConsumerErrorsEndpoint, server.listConsumerErrors).Methods(http.MethodGet)
+		router.HandleFunc(apiPrefix+AuditLogEndpoint, server.listAuditLog).Methods(http.MethodGet)
+	}
+
+	// report upload for environments without access to Kafka
+	if server.Config.ReportUpload {
+		router.HandleFunc(apiPrefix+UploadReportEndpoint, server.uploadReportForCluster).Methods(http.MethodPost)
 	}
 
 	// common REST API endpoints
 	router.HandleFunc(apiPrefix+MainEndpoint, server.mainEndpoint).Methods(http.MethodGet)
+	router.HandleFunc(readyURL, server.readyEndpoint).Methods(http.MethodGet)
+	router.HandleFunc(liveURL, server.liveEndpoint).Methods(http.MethodGet)
 	router.HandleFunc(apiPrefix+ReportEndpoint, server.readReportForCluster).Methods(http.MethodGet)
 	router.HandleFunc(apiPrefix+LikeRuleEndpoint, server.likeRule).Methods(http.MethodPut)
 	router.HandleFunc(apiPrefix+DislikeRuleEndpoint, server.dislikeRule).Methods(http.MethodPut)
 	router.HandleFunc(apiPrefix+ResetVoteOnRuleEndpoint, server.resetVoteOnRule).Methods(http.MethodPut)
+	router.HandleFunc(apiPrefix+FeedbackOnRuleEndpoint, server.addFeedbackOnRule).Methods(http.MethodPost)
+	router.HandleFunc(apiPrefix+FeedbackOnRuleEndpoint, server.deleteFeedbackOnRule).Methods(http.MethodDelete)
 	router.HandleFunc(apiPrefix+ClustersForOrganizationEndpoint, server.listOfClustersForOrganization).Methods(http.MethodGet)
+	router.HandleFunc(apiPrefix+RuleHitsForClusterEndpoint, server.readRuleHitsForCluster).Methods(http.MethodGet)
+	router.HandleFunc(apiPrefix+ReportMetainfoEndpoint, server.readReportMetainfo).Methods(http.MethodGet)
+	router.HandleFunc(
+		apiPrefix+RuleFeedbackStatsForOrganizationEndpoint, server.readRuleFeedbackStatsForOrganization,
+	).Methods(http.MethodGet)
+	router.HandleFunc(apiPrefix+OrganizationOverviewEndpoint, server.readOrganizationOverview).Methods(http.MethodGet)
+	router.HandleFunc(
+		apiPrefix+DisabledRulesForOrganizationEndpoint, server.listDisabledRulesForOrganization,
+	).Methods(http.MethodGet)
+	router.HandleFunc(apiPrefix+HitsHistoryForClusterEndpoint, server.readHitsHistoryForCluster).Methods(http.MethodGet)
+	router.HandleFunc(
+		apiPrefix+ProcessingErrorsForClusterEndpoint, server.readProcessingErrorsForCluster,
+	).Methods(http.MethodGet)
+	router.HandleFunc(apiPrefix+ContentChangesEndpoint, server.getContentChanges).Methods(http.MethodGet)
 
 	// Prometheus metrics
 	router.Handle(apiPrefix+MetricsEndpoint, promhttp.Handler()).Methods(http.MethodGet)
@@ -23,6 +23,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/RedHatInsights/insights-results-aggregator/content"
 	"github.com/RedHatInsights/insights-results-aggregator/server"
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
 	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
@@ -80,12 +81,51 @@ func TestReadNonExistingReport(t *testing.T) {
 	})
 }
 
+// TestReadReportNoopStorage checks that server serves requests when running against noop storage
+func TestReadReportNoopStorage(t *testing.T) {
+	helpers.AssertAPIRequest(t, storage.NewNoopStorage(), &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body: fmt.Sprintf(
+			`{"status":"Item with ID %v/%v was not found in the storage"}`, testdata.OrgID, testdata.ClusterName,
+		),
+	})
+}
+
+// timeoutStorage is a storage in which reading of reports always times out
+type timeoutStorage struct {
+	storage.Storage
+}
+
+func (timeoutStorage) ReadReportForCluster(
+	types.OrgID, types.ClusterName,
+) (types.StoredReport, error) {
+	return types.StoredReport{}, &storage.QueryTimeoutError{Operation: "ReadReportForCluster", Timeout: time.Second}
+}
+
+func TestReadReportStorageTimeout(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, timeoutStorage{mockStorage}, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       `{"status": "Storage operation ReadReportForCluster has not finished in 1s"}`,
+	})
+}
+
 func TestHttpServer_readReportForCluster_NoContent(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
 	err := mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
 	)
 	helpers.FailOnError(t, err)
 
@@ -100,11 +140,12 @@ func TestHttpServer_readReportForCluster_NoContent(t *testing.T) {
 			"report": {
 				"meta": {
 					"count": 0,
-					"last_checked_at": "` + testdata.LastCheckedAt.Format(time.RFC3339) + `"
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `"
 				},
 				"data":[]
 			}
 		}`,
+		BodyChecker: assertReportResponsesEqual,
 	})
 }
 
@@ -113,7 +154,7 @@ func TestHttpServer_readReportForCluster_NoRules(t *testing.T) {
 	defer helpers.MustCloseStorage(t, mockStorage)
 
 	err := mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
 	)
 	helpers.FailOnError(t, err)
 
@@ -128,11 +169,56 @@ func TestHttpServer_readReportForCluster_NoRules(t *testing.T) {
 			"report": {
 				"meta": {
 					"count": -1,
-					"last_checked_at": "` + testdata.LastCheckedAt.Format(time.RFC3339) + `"
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `"
 				},
 				"data":[]
 			}
 		}`,
+		BodyChecker: assertReportResponsesEqual,
+	})
+}
+
+// TestHttpServer_readReportForCluster_EmptyReport checks that the empty report of known cluster
+// is returned with its timestamps, while the report of unknown cluster is not found
+func TestHttpServer_readReportForCluster_EmptyReport(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, "{}", testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"status":"ok",
+			"report": {
+				"meta": {
+					"count": -1,
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `"
+				},
+				"data":[]
+			}
+		}`,
+		BodyChecker: assertReportResponsesEqual,
+	})
+
+	const unknownCluster = "00000000-0000-0000-0000-000000000000"
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, unknownCluster},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body: fmt.Sprintf(
+			`{"status":"Item with ID %v/%v was not found in the storage"}`, testdata.OrgID, unknownCluster,
+		),
 	})
 }
 
@@ -165,7 +251,7 @@ func TestHttpServer_readReportForCluster_getContentForRule_DBError(t *testing.T)
 	helpers.FailOnError(t, err)
 
 	err = mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
 	)
 	helpers.FailOnError(t, err)
 
@@ -182,11 +268,19 @@ func TestHttpServer_readReportForCluster_getContentForRule_DBError(t *testing.T)
 func TestHttpServer_readReportForCluster_getContentForRule_BadReport(t *testing.T) {
 	const badReport = "not-json"
 
-	mockStorage := helpers.MustGetMockStorage(t, true)
+	connection, err := sql.Open("sqlite3", ":memory:")
+	helpers.FailOnError(t, err)
+
+	mockStorage := storage.NewFromConnection(connection, storage.DBDriverSQLite3)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	err := mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, badReport, testdata.LastCheckedAt,
+	err = mockStorage.Init()
+	helpers.FailOnError(t, err)
+
+	// the storage refuses to write invalid JSON, so it needs to be inserted directly
+	_, err = connection.Exec(
+		"INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at) VALUES ($1, $2, $3, $4, $5)",
+		testdata.OrgID, testdata.ClusterName, badReport, time.Now(), testdata.LastCheckedAt,
 	)
 	helpers.FailOnError(t, err)
 
@@ -217,6 +311,10 @@ func assertReportResponsesEqual(t *testing.T, expected, got string) {
 		"status is empty(probably json is completely wrong and unmarshal didn't do anything useful)",
 	)
 	assert.Equal(t, expectedResponse.Status, gotResponse.Status)
+	// reported_at is the time when the test wrote the report unless the expected response contains it
+	if expectedResponse.Report.Meta.ReportedAt == nil {
+		gotResponse.Report.Meta.ReportedAt = nil
+	}
 	assert.Equal(t, expectedResponse.Report.Meta, gotResponse.Report.Meta)
 	// ignore the order
 	assert.ElementsMatch(t, expectedResponse.Report.Rules, gotResponse.Report.Rules)
@@ -231,6 +329,7 @@ func TestReadReportWithContent(t *testing.T) {
 		testdata.ClusterName,
 		testdata.Report3Rules,
 		testdata.LastCheckedAt,
+		types.UnknownKafkaOffset,
 	)
 	helpers.FailOnError(t, err)
 
@@ -248,3 +347,546 @@ func TestReadReportWithContent(t *testing.T) {
 		BodyChecker: assertReportResponsesEqual,
 	})
 }
+
+// assertReportRuleIDs reads the report of the cluster and checks IDs of returned rules
+func assertReportRuleIDs(
+	t *testing.T, mockStorage storage.Storage, clusterName types.ClusterName, expectedRuleIDs ...types.RuleID,
+) {
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, clusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			var response struct {
+				Status string               `json:"status"`
+				Report types.ReportResponse `json:"report"`
+			}
+			helpers.FailOnError(t, helpers.JSONUnmarshalStrict([]byte(got), &response))
+
+			assert.Equal(t, len(expectedRuleIDs), response.Report.Meta.Count)
+
+			ruleIDs := make([]types.RuleID, 0)
+			for _, rule := range response.Report.Rules {
+				ruleIDs = append(ruleIDs, types.RuleID(rule.RuleModule))
+			}
+			assert.ElementsMatch(t, expectedRuleIDs, ruleIDs)
+		},
+	})
+}
+
+// TestReadReportWithRuleDisabledForOrg checks that the rule disabled for the organization is hidden
+// in reports of its clusters unless the cluster enables the rule
+func TestReadReportWithRuleDisabledForOrg(t *testing.T) {
+	const otherClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	for _, clusterName := range []types.ClusterName{testdata.ClusterName, otherClusterName} {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, clusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		))
+	}
+
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContent3Rules))
+	helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+	helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+		otherClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleEnable, "",
+	))
+
+	// the cluster without its own toggle follows the organization
+	assertReportRuleIDs(t, mockStorage, testdata.ClusterName, testdata.Rule2ID, testdata.Rule3ID)
+	// the toggle of the cluster takes precedence over the organization
+	assertReportRuleIDs(t, mockStorage, otherClusterName, testdata.Rule1ID, testdata.Rule2ID, testdata.Rule3ID)
+}
+
+// assertReportRisks reads the report of the cluster filtered by min_risk
+// and checks counts and total risks of returned rules
+func assertReportRisks(
+	t *testing.T, mockStorage storage.Storage, minRisk string, expectedCount, expectedFilteredCount int,
+	expectedRisks ...int,
+) {
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint + "?min_risk=" + minRisk,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			var response struct {
+				Status string               `json:"status"`
+				Report types.ReportResponse `json:"report"`
+			}
+			helpers.FailOnError(t, helpers.JSONUnmarshalStrict([]byte(got), &response))
+
+			assert.Equal(t, expectedCount, response.Report.Meta.Count)
+			if assert.NotNil(t, response.Report.Meta.FilteredCount) {
+				assert.Equal(t, expectedFilteredCount, *response.Report.Meta.FilteredCount)
+			}
+
+			risks := make([]int, 0)
+			for _, rule := range response.Report.Rules {
+				risks = append(risks, rule.TotalRisk)
+			}
+			assert.ElementsMatch(t, expectedRisks, risks)
+		},
+	})
+}
+
+func TestReadReportWithContentMinRisk(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	assertReportRisks(t, mockStorage, "1", 3, 3, 3, 4, 2)
+	assertReportRisks(t, mockStorage, "3", 3, 2, 3, 4)
+	assertReportRisks(t, mockStorage, "4", 3, 1, 4)
+}
+
+// TestReadReportWithContentMinRiskNoRules checks that filtered count is zero
+// when the filter removes every rule
+func TestReadReportWithContentMinRiskNoRules(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	lowRiskContent := content.RuleContentDirectory{}
+	for name, rule := range testdata.RuleContent3Rules {
+		errorKeys := make(map[string]content.RuleErrorKeyContent)
+		for key, errorKey := range rule.ErrorKeys {
+			errorKey.Metadata.Impact, errorKey.Metadata.Likelihood = 1, 1
+			errorKeys[key] = errorKey
+		}
+		rule.ErrorKeys = errorKeys
+		lowRiskContent[name] = rule
+	}
+
+	err = mockStorage.LoadRuleContent(lowRiskContent)
+	helpers.FailOnError(t, err)
+
+	assertReportRisks(t, mockStorage, "2", 3, 0)
+}
+
+func TestReadReportBadMinRisk(t *testing.T) {
+	for _, value := range []string{"0", "5", "high"} {
+		helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+			Method:       http.MethodGet,
+			Endpoint:     server.ReportEndpoint + "?min_risk=" + value,
+			EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body: `{"status": "Error during parsing param 'min_risk' with value '` + value +
+				`'. Error: 'allowed values are 1-4'"}`,
+		})
+	}
+}
+
+func assertReportStaleness(
+	t *testing.T, serverConfig *server.Configuration, endpoint string, now time.Time, expectedStale bool,
+) {
+	defer server.SetTimeNow(func() time.Time { return now })()
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	staleMeta, expectedWarning := "", ""
+	if expectedStale {
+		staleMeta = `, "stale": true`
+		expectedWarning = `110 - "Response is Stale"`
+	}
+
+	helpers.AssertAPIRequest(t, mockStorage, serverConfig, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     endpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"status":"ok",
+			"report": {
+				"meta": {
+					"count": -1,
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `"` + staleMeta + `
+				},
+				"data":[]
+			}
+		}`,
+		BodyChecker: assertReportResponsesEqual,
+		Headers:     map[string]string{"Warning": expectedWarning},
+	})
+}
+
+func TestReadReportForClusterStaleness(t *testing.T) {
+	const threshold = 24 * time.Hour
+
+	staleConfig := config
+	staleConfig.ReportStalenessThreshold = threshold
+
+	for _, testCase := range []struct {
+		name          string
+		config        server.Configuration
+		endpoint      string
+		now           time.Time
+		expectedStale bool
+	}{
+		{"disabled", config, server.ReportEndpoint, testdata.LastCheckedAt.Add(100 * threshold), false},
+		{"fresh", staleConfig, server.ReportEndpoint, testdata.LastCheckedAt.Add(threshold / 2), false},
+		{"at threshold", staleConfig, server.ReportEndpoint, testdata.LastCheckedAt.Add(threshold), false},
+		{"after threshold", staleConfig, server.ReportEndpoint, testdata.LastCheckedAt.Add(threshold + time.Second), true},
+		{
+			"query param override fresh",
+			staleConfig,
+			server.ReportEndpoint + "?staleness_threshold=48h",
+			testdata.LastCheckedAt.Add(threshold + time.Second),
+			false,
+		},
+		{
+			"query param override stale",
+			config,
+			server.ReportEndpoint + "?staleness_threshold=1h",
+			testdata.LastCheckedAt.Add(time.Hour + time.Second),
+			true,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			assertReportStaleness(t, &testCase.config, testCase.endpoint, testCase.now, testCase.expectedStale)
+		})
+	}
+}
+
+func TestReadReportForClusterBadStalenessThreshold(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint + "?staleness_threshold=week",
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body: `{
+			"status": "Error during parsing param 'staleness_threshold' with value 'week'. Error: 'non-negative duration expected'"
+		}`,
+	})
+}
+
+// withGenericContent returns copy of the rule content with generic text of all error keys replaced
+func withGenericContent(ruleContent content.RuleContent, generic string) content.RuleContent {
+	errorKeys := make(map[string]content.RuleErrorKeyContent, len(ruleContent.ErrorKeys))
+	for errorKey, errorKeyContent := range ruleContent.ErrorKeys {
+		errorKeyContent.Generic = []byte(generic)
+		errorKeys[errorKey] = errorKeyContent
+	}
+	ruleContent.ErrorKeys = errorKeys
+
+	return ruleContent
+}
+
+func TestReadReportWithContentTemplates(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	report := `{
+		"reports": [
+			{"component": "` + string(testdata.Rule1ID) + `.report", "key": "` + testdata.ErrorKey1 + `",
+			 "details": {"nodes": [{"name": "node1"}]}},
+			{"component": "` + string(testdata.Rule2ID) + `.report", "key": "` + testdata.ErrorKey2 + `"}
+		]
+	}`
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, types.ClusterReport(report), testdata.LastCheckedAt,
+		types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	const generic = "Node {{?pydata.nodes.0.name}} is degraded."
+	ruleContent := content.RuleContentDirectory{
+		"rc1": withGenericContent(testdata.RuleContent3Rules["rc1"], generic),
+		"rc2": withGenericContent(testdata.RuleContent3Rules["rc2"], generic),
+	}
+
+	err = mockStorage.LoadRuleContent(ruleContent)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"report": {
+				"meta": {
+					"count": 2,
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `"
+				},
+				"data": [
+					{
+						"rule_id": "` + string(testdata.Rule1ID) + `",
+						"description": "` + testdata.Rule1Description + `",
+						"details": "Node node1 is degraded.",
+						"created_at": "` + testdata.Rule1CreatedAt + `",
+						"total_risk": 3,
+						"risk_of_change": 0
+					},
+					{
+						"rule_id": "` + string(testdata.Rule2ID) + `",
+						"description": "` + testdata.Rule2Description + `",
+						"details": "Node is degraded.",
+						"created_at": "` + testdata.Rule2CreatedAt + `",
+						"total_risk": 4,
+						"risk_of_change": 0,
+						"resolution_incomplete": true
+					}
+				]
+			},
+			"status": "ok"
+		}`,
+		BodyChecker: assertReportResponsesEqual,
+	})
+}
+
+// mustGetStorageWithNullTimestamps returns storage with the report of the cluster written
+// without timestamps, like the rows written by older versions
+func mustGetStorageWithNullTimestamps(t *testing.T, report types.ClusterReport) storage.Storage {
+	connection, err := sql.Open("sqlite3", ":memory:")
+	helpers.FailOnError(t, err)
+
+	mockStorage := storage.NewFromConnection(connection, storage.DBDriverSQLite3)
+	helpers.FailOnError(t, mockStorage.Init())
+
+	_, err = connection.Exec(
+		"INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at) VALUES ($1, $2, $3, NULL, NULL)",
+		testdata.OrgID, testdata.ClusterName, string(report),
+	)
+	helpers.FailOnError(t, err)
+
+	return mockStorage
+}
+
+// TestReadReportForClusterNullLastChecked checks that unknown time of the last check is omitted
+// and the report isn't considered stale
+func TestReadReportForClusterNullLastChecked(t *testing.T) {
+	mockStorage := mustGetStorageWithNullTimestamps(t, testdata.Report0Rules)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	staleConfig := config
+	staleConfig.ReportStalenessThreshold = time.Hour
+
+	helpers.AssertAPIRequest(t, mockStorage, &staleConfig, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok", "report": {"meta": {"count": -1}, "data": []}}`,
+		Headers:    map[string]string{"Warning": ""},
+	})
+}
+
+// TestReadReportForClusterReportedAt checks that both the time of the last check
+// and the time when the report was stored are sent in the meta of the report
+func TestReadReportForClusterReportedAt(t *testing.T) {
+	reportedAt := testdata.LastCheckedAt.Add(time.Minute)
+
+	connection, err := sql.Open("sqlite3", ":memory:")
+	helpers.FailOnError(t, err)
+
+	mockStorage := storage.NewFromConnection(connection, storage.DBDriverSQLite3)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	helpers.FailOnError(t, mockStorage.Init())
+
+	_, err = connection.Exec(
+		"INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at) VALUES ($1, $2, $3, $4, $5)",
+		testdata.OrgID, testdata.ClusterName, string(testdata.Report0Rules), reportedAt, testdata.LastCheckedAt,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"status":"ok",
+			"report": {
+				"meta": {
+					"count": -1,
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `",
+					"reported_at": "` + reportedAt.UTC().Format(time.RFC3339) + `"
+				},
+				"data":[]
+			}
+		}`,
+	})
+}
+
+func TestListOfClustersForOrganizationNullTimestamps(t *testing.T) {
+	mockStorage := mustGetStorageWithNullTimestamps(t, testdata.Report0Rules)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok", "clusters": ["` + string(testdata.ClusterName) + `"]}`,
+	})
+}
+
+func TestRuleFeedbackVoteNullTimestamps(t *testing.T) {
+	mockStorage := mustGetStorageWithNullTimestamps(t, testdata.Report3Rules)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContent3Rules))
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.LikeRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+}
+
+// mustGetStorageWithProcessingError returns storage with the report of the cluster
+// and a failure of processing of the next report of the cluster consumed at failedAt
+func mustGetStorageWithProcessingError(t *testing.T, failedAt time.Time) storage.Storage {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.WriteConsumerError(storage.ConsumerError{
+		Topic:       "topic",
+		Offset:      1,
+		OrgID:       testdata.OrgID,
+		ClusterName: testdata.ClusterName,
+		ConsumedAt:  failedAt,
+		Category:    "invalid_report",
+		Error:       "Improper report structure, missing key fingerprints",
+	})
+	helpers.FailOnError(t, err)
+
+	return mockStorage
+}
+
+// TestReadProcessingErrorsForCluster checks that only categories of failures are sent to users
+func TestReadProcessingErrorsForCluster(t *testing.T) {
+	failedAt := testdata.LastCheckedAt.Add(time.Hour)
+
+	mockStorage := mustGetStorageWithProcessingError(t, failedAt)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ProcessingErrorsForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"status": "ok",
+			"processing_errors": [
+				{"category": "invalid_report", "failed_at": "` + failedAt.UTC().Format(time.RFC3339) + `"}
+			]
+		}`,
+	})
+}
+
+func TestReadProcessingErrorsForClusterEmpty(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ProcessingErrorsForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok", "processing_errors": []}`,
+	})
+}
+
+func TestReadProcessingErrorsForClusterBadClusterName(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ProcessingErrorsForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.BadClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       `{"status": "Error during parsing param 'cluster' with value 'aaaa'. Error: 'invalid UUID length: 4'"}`,
+	})
+}
+
+// TestReadReportForClusterLastProcessingError checks that time of the failure newer
+// than the report is sent in the meta of the report
+func TestReadReportForClusterLastProcessingError(t *testing.T) {
+	failedAt := testdata.LastCheckedAt.Add(time.Hour)
+
+	mockStorage := mustGetStorageWithProcessingError(t, failedAt)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"status":"ok",
+			"report": {
+				"meta": {
+					"count": -1,
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `",
+					"last_processing_error_at": "` + failedAt.UTC().Format(time.RFC3339) + `"
+				},
+				"data":[]
+			}
+		}`,
+		BodyChecker: assertReportResponsesEqual,
+	})
+}
+
+// TestReadReportForClusterOlderProcessingError checks that failures older than the report are not mentioned
+func TestReadReportForClusterOlderProcessingError(t *testing.T) {
+	mockStorage := mustGetStorageWithProcessingError(t, testdata.LastCheckedAt.Add(-time.Hour))
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"status":"ok",
+			"report": {
+				"meta": {
+					"count": -1,
+					"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `"
+				},
+				"data":[]
+			}
+		}`,
+		BodyChecker: assertReportResponsesEqual,
+	})
+}
@@ -18,21 +18,31 @@ package server_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Shopify/sarama"
+	mapset "github.com/deckarep/golang-set"
 
+	"github.com/RedHatInsights/insights-results-aggregator/broker"
+	"github.com/RedHatInsights/insights-results-aggregator/consumer"
+	"github.com/RedHatInsights/insights-results-aggregator/content"
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
 
 	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
 
 	"github.com/RedHatInsights/insights-results-aggregator/server"
 	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,7 +84,7 @@ func TestListOfClustersForOrganizationOK(t *testing.T) {
 	defer helpers.MustCloseStorage(t, mockStorage)
 
 	err := mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
 	)
 	helpers.FailOnError(t, err)
 
@@ -88,94 +98,1040 @@ func TestListOfClustersForOrganizationOK(t *testing.T) {
 	})
 }
 
+func TestListOfClustersForOrganizationWithTimestamps(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint + "?include=timestamps",
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			var response struct {
+				Clusters []types.ClusterListItem `json:"clusters"`
+			}
+			helpers.FailOnError(t, json.Unmarshal([]byte(got), &response))
+
+			assert.Len(t, response.Clusters, 1)
+			assert.Equal(t, testdata.ClusterName, response.Clusters[0].Name)
+			assert.Equal(t, types.NewOptionalTimestamp(testdata.LastCheckedAt), response.Clusters[0].LastCheckedAt)
+			assert.NotNil(t, response.Clusters[0].ReportedAt)
+		},
+	})
+}
+
+func TestListOfClustersForOrganizationBadInclude(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint + "?include=reports",
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body: `{
+			"status": "Error during parsing param 'include' with value 'reports'. Error: 'allowed value is timestamps'"
+		}`,
+	})
+}
+
 // TestListOfClustersForOrganizationDBError expects db error
 // because the storage is closed before the query
 func TestListOfClustersForOrganizationDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	helpers.MustCloseStorage(t, mockStorage)
 
-	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
-		Method:       http.MethodGet,
-		Endpoint:     server.ClustersForOrganizationEndpoint,
-		EndpointArgs: []interface{}{testdata.OrgID},
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+func TestListOfClustersForOrganizationNegativeID(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{-1},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body: `{
+			"status": "Error during parsing param 'organization' with value '-1'. Error: 'unsigned integer expected'"
+		}`,
+	})
+}
+
+func TestListOfClustersForOrganizationOutOfRangeID(t *testing.T) {
+	// 2^63 doesn't fit into BIGINT org_id column
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{uint64(1) << 63},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body: `{
+			"status": "Error during parsing param 'organization' with value '9223372036854775808'. Error: 'organization ID in range 1..9223372036854775807 expected'"
+		}`,
+	})
+}
+
+func TestListOfClustersForOrganizationAbove32Bits(t *testing.T) {
+	const orgID = types.OrgID(1<<32 + 1)
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		orgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{orgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"clusters":["` + string(testdata.ClusterName) + `"],"status":"ok"}`,
+	})
+}
+
+func TestListOfClustersForOrganizationNonIntID(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{"non-int"},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body: `{
+			"status": "Error during parsing param 'organization' with value 'non-int'. Error: 'unsigned integer expected'"
+		}`,
+	})
+}
+
+func TestReadRuleHitsForCluster(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.RuleHitsForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"rules": [
+				{"component": "` + string(testdata.Rule1ID) + `.report", "key": "` + testdata.ErrorKey1 + `", "details": null},
+				{"component": "` + string(testdata.Rule2ID) + `.report", "key": "` + testdata.ErrorKey2 + `", "details": null},
+				{"component": "` + string(testdata.Rule3ID) + `.report", "key": "` + testdata.ErrorKey3 + `", "details": null}
+			],
+			"status": "ok"
+		}`,
+	})
+}
+
+func TestReadRuleHitsForClusterNoRules(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.RuleHitsForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"rules": [], "status": "ok"}`,
+	})
+}
+
+func TestReadRuleHitsForNonExistingCluster(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.RuleHitsForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body: fmt.Sprintf(
+			`{"status": "Item with ID %v/%v was not found in the storage"}`,
+			testdata.OrgID, testdata.ClusterName,
+		),
+	})
+}
+
+func TestReadRuleHitsForClusterBadClusterName(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.RuleHitsForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.BadClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       `{"status": "Error during parsing param 'cluster' with value 'aaaa'. Error: 'invalid UUID length: 4'"}`,
+	})
+}
+
+func TestReadReportMetainfo(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 42,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportMetainfoEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			var response struct {
+				Status string               `json:"status"`
+				Info   types.ReportMetainfo `json:"info"`
+			}
+			helpers.FailOnError(t, helpers.JSONUnmarshalStrict([]byte(got), &response))
+
+			assert.Equal(t, "ok", response.Status)
+			// the time of storing the report is set by the storage
+			assert.NotNil(t, response.Info.ReportedAt)
+			assert.True(t, testdata.LastCheckedAt.Equal(response.Info.LastCheckedAt.Time))
+			assert.Equal(t, types.KafkaOffset(42), response.Info.KafkaOffset)
+			assert.Equal(t, 3, response.Info.RuleHitsCount)
+		},
+	})
+}
+
+func TestReadReportMetainfoForNonExistingCluster(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportMetainfoEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body: fmt.Sprintf(
+			`{"status": "Item with ID %v/%v was not found in the storage"}`,
+			testdata.OrgID, testdata.ClusterName,
+		),
+	})
+}
+
+func TestReadRuleFeedbackStatsForOrganization(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+	helpers.FailOnError(t, mockStorage.VoteOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteDislike,
+	))
+	helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message",
+	))
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.RuleFeedbackStatsForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"feedback_stats": [
+				{"rule_id": "` + string(testdata.Rule1ID) + `", "likes": 0, "dislikes": 1, "commenting_users": 1}
+			],
+			"status": "ok"
+		}`,
+	})
+}
+
+func TestReadRuleFeedbackStatsForOrganizationDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.RuleFeedbackStatsForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+func TestReadOrganizationOverview(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+	helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+	))
+	helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID, "ack"))
+
+	lastCheckedAt := testdata.LastCheckedAt.UTC().Format(time.RFC3339)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.OrganizationOverviewEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"overview": {
+				"cluster_count": 1,
+				"oldest_last_checked_at": "` + lastCheckedAt + `",
+				"newest_last_checked_at": "` + lastCheckedAt + `",
+				"silenced": {
+					"disabled_rules": 1,
+					"clusters_with_disabled_rules": 1,
+					"acked_rules": 1,
+					"silenced_rules": 2
+				}
+			},
+			"status": "ok"
+		}`,
+	})
+}
+
+func TestReadOrganizationOverviewDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.OrganizationOverviewEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+// assertHitsHistoryResponse checks that the response contains zero-filled history of the given number of days
+func assertHitsHistoryResponse(t *testing.T, got string, days int) {
+	var response struct {
+		History []types.DailyHitsCount `json:"history"`
+		Status  string                 `json:"status"`
+	}
+	helpers.FailOnError(t, json.Unmarshal([]byte(got), &response))
+
+	assert.Equal(t, "ok", response.Status)
+	assert.Len(t, response.History, days)
+	assert.Equal(t, time.Now().UTC().Format("2006-01-02"), response.History[len(response.History)-1].Date)
+	for _, day := range response.History {
+		assert.Equal(t, 0, day.HitsCount)
+	}
+}
+
+func TestReadHitsHistoryForCluster(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	// the cluster which sent only an empty report has no hits
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, "{}", testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	for _, testCase := range []struct {
+		query string
+		days  int
+	}{
+		{"", 30},
+		{"?days=7", 7},
+		{"?days=1000", storage.MaxHitsCountHistoryDays},
+	} {
+		days := testCase.days
+
+		helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+			Method:       http.MethodGet,
+			Endpoint:     server.HitsHistoryForClusterEndpoint + testCase.query,
+			EndpointArgs: []interface{}{testdata.ClusterName},
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusOK,
+			BodyChecker: func(t *testing.T, _, got string) {
+				assertHitsHistoryResponse(t, got, days)
+			},
+		})
+	}
+}
+
+// TestReadHitsHistoryForUnknownCluster checks that history of unknown cluster is not found
+func TestReadHitsHistoryForUnknownCluster(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.HitsHistoryForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       fmt.Sprintf(`{"status":"Item with ID %v was not found in the storage"}`, testdata.ClusterName),
+	})
+}
+
+func TestReadHitsHistoryForClusterBadDays(t *testing.T) {
+	for _, value := range []string{"0", "-1", "month"} {
+		helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+			Method:       http.MethodGet,
+			Endpoint:     server.HitsHistoryForClusterEndpoint + "?days=" + value,
+			EndpointArgs: []interface{}{testdata.ClusterName},
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body: `{"status": "Error during parsing param 'days' with value '` + value +
+				`'. Error: 'positive integer expected'"}`,
+		})
+	}
+}
+
+func TestReadHitsHistoryForClusterDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.HitsHistoryForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+func TestMainEndpoint(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.MainEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+}
+
+func TestReadyEndpoint(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ReadyEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+}
+
+// TestReadyEndpointClosedStorage expects the service to be unavailable
+// because the storage is closed before the request
+func TestReadyEndpointClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ReadyEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       `{"status": "sql: database is closed"}`,
+	})
+}
+
+// livenessCheck is a liveness check always returning the same error
+type livenessCheck struct {
+	err error
+}
+
+func (check livenessCheck) CheckLiveness() error {
+	return check.err
+}
+
+// assertLiveEndpoint checks the response of the liveness endpoint of the server using the liveness check
+func assertLiveEndpoint(t *testing.T, liveness server.LivenessCheck, statusCode int, body string) {
+	testServer := server.New(config, nil)
+	testServer.Liveness = liveness
+
+	req, err := http.NewRequest(http.MethodGet, server.MakeURLToEndpoint(config.APIPrefix, server.LiveEndpoint), nil)
+	helpers.FailOnError(t, err)
+
+	response := helpers.ExecuteRequest(testServer, req, &config).Result()
+
+	assert.Equal(t, statusCode, response.StatusCode, "Expected different status code")
+	helpers.CheckResponseBodyJSON(t, body, response.Body)
+}
+
+func TestLiveEndpoint(t *testing.T) {
+	assertLiveEndpoint(t, nil, http.StatusOK, `{"status": "ok"}`)
+	assertLiveEndpoint(t, livenessCheck{}, http.StatusOK, `{"status": "ok"}`)
+}
+
+// TestLiveEndpointStuck expects the service to be unavailable because the liveness check fails
+func TestLiveEndpointStuck(t *testing.T) {
+	assertLiveEndpoint(
+		t, livenessCheck{err: errors.New("consumer loop is stuck")},
+		http.StatusServiceUnavailable, `{"status": "consumer loop is stuck"}`,
+	)
+}
+
+func TestListOfOrganizationsEmpty(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.OrganizationsEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"organizations":[],"status":"ok"}`,
+	})
+}
+
+func TestListOfOrganizationsOK(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		1, "8083c377-8a05-4922-af8d-e7d0970c1f49", "{}", time.Now(), types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.WriteReportForCluster(
+		5, "52ab955f-b769-444d-8170-4b676c5d3c85", "{}", time.Now(), types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.OrganizationsEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"organizations":[1, 5],"status":"ok"}`,
+	})
+}
+
+func TestListOfOrganizationsDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.OrganizationsEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+func TestClustersCountPerOrg(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ClustersCountPerOrgEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"clusters_count": {}, "status": "ok"}`,
+	})
+
+	err := mockStorage.WriteReportForCluster(
+		1, "8083c377-8a05-4922-af8d-e7d0970c1f49", "{}", time.Now(), types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.WriteReportForCluster(
+		5, "52ab955f-b769-444d-8170-4b676c5d3c85", "{}", time.Now(), types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.WriteReportForCluster(
+		5, "2a3f0b6c-0b2b-4d4c-9d5f-3b8c8b1f6e7d", "{}", time.Now(), types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ClustersCountPerOrgEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"clusters_count": {"1": 1, "5": 2}, "status": "ok"}`,
+	})
+}
+
+func TestClustersCountPerOrgDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ClustersCountPerOrgEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+func TestListFeedbacksForCluster(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", "1", "message")
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule2ID, "", "2", storage.UserVoteLike)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.FeedbacksForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			var response struct {
+				Feedbacks []storage.UserFeedbackOnRule `json:"feedbacks"`
+				Status    string                       `json:"status"`
+			}
+			helpers.FailOnError(t, json.Unmarshal([]byte(got), &response))
+
+			assert.Equal(t, "ok", response.Status)
+			assert.Len(t, response.Feedbacks, 2)
+
+			for _, feedback := range response.Feedbacks {
+				assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
+
+				switch feedback.UserID {
+				case "1":
+					assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
+					assert.Equal(t, "message", feedback.Message)
+					assert.Equal(t, storage.UserVoteNone, feedback.UserVote)
+				case "2":
+					assert.Equal(t, testdata.Rule2ID, feedback.RuleID)
+					assert.Equal(t, "", feedback.Message)
+					assert.Equal(t, storage.UserVoteLike, feedback.UserVote)
+				default:
+					t.Errorf("unexpected feedback %+v", feedback)
+				}
+			}
+		},
+	})
+}
+
+func TestListFeedbacksForClusterDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.FeedbacksForClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+// assertRulesResponse checks that response lists rules with the modules
+func assertRulesResponse(t *testing.T, got string, expected ...types.RuleID) {
+	var response struct {
+		Rules  []types.Rule `json:"rules"`
+		Status string       `json:"status"`
+	}
+	helpers.FailOnError(t, json.Unmarshal([]byte(got), &response))
+
+	assert.Equal(t, "ok", response.Status)
+
+	modules := make([]types.RuleID, 0, len(response.Rules))
+	for _, rule := range response.Rules {
+		modules = append(modules, rule.Module)
+	}
+	assert.Equal(t, expected, modules)
+}
+
+func TestListRules(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.RulesEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			assertRulesResponse(t, got, testdata.Rule1ID, testdata.Rule2ID, testdata.Rule3ID)
+		},
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.RulesEndpoint + "?active=true&module_prefix={prefix}&limit=1&offset=1",
+		EndpointArgs: []interface{}{"test."},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			assertRulesResponse(t, got, testdata.Rule2ID)
+		},
+	})
+}
+
+func TestListRulesBadQueryParams(t *testing.T) {
+	for _, testCase := range []struct {
+		query    string
+		expected string
+	}{
+		{"?active=maybe", "Error during parsing param 'active' with value 'maybe'. Error: 'boolean expected'"},
+		{"?limit=-1", "Error during parsing param 'limit' with value '-1'. Error: 'non-negative integer expected'"},
+		{"?offset=x", "Error during parsing param 'offset' with value 'x'. Error: 'non-negative integer expected'"},
+	} {
+		helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+			Method:   http.MethodGet,
+			Endpoint: server.RulesEndpoint + testCase.query,
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"status": "` + testCase.expected + `"}`,
+		})
+	}
+}
+
+// TestListConsumerErrors checks that the most recent failures of processing are returned
+// including the original errors and the messages
+func TestListConsumerErrors(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	consumedAt := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	for offset := types.KafkaOffset(1); offset <= 2; offset++ {
+		err := mockStorage.WriteConsumerError(storage.ConsumerError{
+			Topic:      "topic",
+			Offset:     offset,
+			ConsumedAt: consumedAt.Add(time.Duration(offset) * time.Hour),
+			Category:   "malformed_message",
+			Error:      "invalid character 'x' looking for beginning of value",
+			Key:        "key",
+			Message:    "xyz",
+		})
+		helpers.FailOnError(t, err)
+	}
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ConsumerErrorsEndpoint + "?limit=1",
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{"consumer_errors": [{
+			"topic": "topic", "partition": 0, "offset": 2, "org_id": 0, "cluster": "",
+			"consumed_at": "2020-03-01T14:00:00Z", "category": "malformed_message",
+			"error": "invalid character 'x' looking for beginning of value", "key": "key", "message": "xyz"
+		}], "status": "ok"}`,
+	})
+}
+
+func TestListConsumerErrorsBadLimit(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ConsumerErrorsEndpoint + "?limit=0",
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       `{"status": "Error during parsing param 'limit' with value '0'. Error: 'positive integer expected'"}`,
+	})
+}
+
+// TestListAuditLog checks that the most recent destructive operations are returned
+func TestListAuditLog(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	_, err = mockStorage.DeleteReportsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.AuditLogEndpoint + "?limit=1&since=2020-03-01T12:00:00Z",
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		BodyChecker: func(t *testing.T, _, got string) {
+			var response struct {
+				Status   string                  `json:"status"`
+				AuditLog []storage.AuditLogEntry `json:"audit_log"`
+			}
+			helpers.FailOnError(t, helpers.JSONUnmarshalStrict([]byte(got), &response))
+
+			assert.Equal(t, "ok", response.Status)
+			if assert.Len(t, response.AuditLog, 1) {
+				assert.Equal(t, "DeleteReportsForOrg", response.AuditLog[0].Operation)
+				assert.Equal(t, testdata.OrgID, response.AuditLog[0].OrgID)
+			}
+		},
+	})
+
+	// operations run before the time are not returned
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.AuditLogEndpoint + "?since=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"audit_log": [], "status": "ok"}`,
+	})
+}
+
+func TestListAuditLogBadQueryParams(t *testing.T) {
+	for _, testCase := range []struct {
+		query    string
+		expected string
+	}{
+		{"?limit=0", "Error during parsing param 'limit' with value '0'. Error: 'positive integer expected'"},
+		{"?since=yesterday", "Error during parsing param 'since' with value 'yesterday'. Error: 'RFC3339 timestamp expected'"},
+	} {
+		helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+			Method:   http.MethodGet,
+			Endpoint: server.AuditLogEndpoint + testCase.query,
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"status": "` + testCase.expected + `"}`,
+		})
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodPost,
+		Endpoint: server.ConsistencyCheckEndpoint + "?repair=true",
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"consistency_check": {"checked": 1, "issues": []}, "status": "ok"}`,
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.ConsistencyIssuesEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"issues": [], "status": "ok"}`,
+	})
+}
+
+// legacyWriteModeStorage is a storage which doesn't store rule hits, so they can't be checked
+type legacyWriteModeStorage struct {
+	storage.Storage
+}
+
+func (legacyWriteModeStorage) CheckReportsConsistency(
+	after storage.ReportKey, _ int, _ bool,
+) (storage.ConsistencyCheckBatch, error) {
+	return storage.ConsistencyCheckBatch{Last: after}, storage.ErrConsistencyCheckInLegacyMode
+}
+
+func TestCheckConsistencyLegacyWriteMode(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, legacyWriteModeStorage{mockStorage}, &config, &helpers.APIRequest{
+		Method:   http.MethodPost,
+		Endpoint: server.ConsistencyCheckEndpoint + "?repair=true",
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       `{"status": "rule hits are not checked for consistency in legacy write mode"}`,
+	})
+}
+
+// inconsistentStorage is a storage in which the consistency check always finds an issue
+type inconsistentStorage struct {
+	storage.Storage
+}
+
+func (inconsistentStorage) CheckReportsConsistency(
+	after storage.ReportKey, limit int, repair bool,
+) (storage.ConsistencyCheckBatch, error) {
+	return storage.ConsistencyCheckBatch{
+		Checked: 1,
+		Last:    storage.ReportKey{OrgID: testdata.OrgID, ClusterName: testdata.ClusterName},
+		Issues: []storage.ConsistencyIssue{{
+			OrgID:       testdata.OrgID,
+			ClusterName: testdata.ClusterName,
+			Description: "missing rule hits: test.rule1.report|ek1",
+			Repaired:    repair,
+			DetectedAt:  testdata.LastCheckedAt,
+		}},
+	}, nil
+}
+
+func TestCheckConsistencyIssueFound(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, inconsistentStorage{mockStorage}, &config, &helpers.APIRequest{
+		Method:   http.MethodPost,
+		Endpoint: server.ConsistencyCheckEndpoint,
 	}, &helpers.APIResponse{
-		StatusCode: http.StatusInternalServerError,
-		Body:       `{"status": "Internal Server Error"}`,
+		StatusCode: http.StatusOK,
+		Body: fmt.Sprintf(`{"consistency_check": {"checked": 1, "issues": [{
+			"org_id": %v,
+			"cluster": "%v",
+			"description": "missing rule hits: test.rule1.report|ek1",
+			"repaired": false,
+			"detected_at": "%v"
+		}]}, "status": "ok"}`, testdata.OrgID, testdata.ClusterName, testdata.LastCheckedAt.Format(time.RFC3339)),
 	})
 }
 
-func TestListOfClustersForOrganizationNegativeID(t *testing.T) {
+func TestCheckConsistencyBadRepairParam(t *testing.T) {
 	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
-		Method:       http.MethodGet,
-		Endpoint:     server.ClustersForOrganizationEndpoint,
-		EndpointArgs: []interface{}{-1},
+		Method:   http.MethodPost,
+		Endpoint: server.ConsistencyCheckEndpoint + "?repair=maybe",
 	}, &helpers.APIResponse{
 		StatusCode: http.StatusBadRequest,
-		Body: `{
-			"status": "Error during parsing param 'organization' with value '-1'. Error: 'unsigned integer expected'"
-		}`,
+		Body:       `{"status": "Error during parsing param 'repair' with value 'maybe'. Error: 'boolean expected'"}`,
 	})
 }
 
-func TestListOfClustersForOrganizationNonIntID(t *testing.T) {
-	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
-		Method:       http.MethodGet,
-		Endpoint:     server.ClustersForOrganizationEndpoint,
-		EndpointArgs: []interface{}{"non-int"},
+func TestCheckConsistencyDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodPost,
+		Endpoint: server.ConsistencyCheckEndpoint,
 	}, &helpers.APIResponse{
-		StatusCode: http.StatusBadRequest,
-		Body: `{
-			"status": "Error during parsing param 'organization' with value 'non-int'. Error: 'unsigned integer expected'"
-		}`,
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
 	})
 }
 
-func TestMainEndpoint(t *testing.T) {
-	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+// contentChecksum returns checksum of the rule content as it's stored in the content history
+func contentChecksum(t *testing.T, contentDir content.RuleContentDirectory) string {
+	ruleChecksums, err := content.RuleChecksums(contentDir)
+	helpers.FailOnError(t, err)
+
+	checksum, err := content.Checksum(ruleChecksums)
+	helpers.FailOnError(t, err)
+
+	return checksum
+}
+
+func TestGetContentChanges(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	for _, contentDir := range []content.RuleContentDirectory{
+		testdata.RuleContentVersion1, testdata.RuleContentVersion2, testdata.RuleContentVersion3,
+	} {
+		helpers.FailOnError(t, mockStorage.LoadRuleContent(contentDir))
+	}
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
 		Method:   http.MethodGet,
-		Endpoint: server.MainEndpoint,
+		Endpoint: server.ContentChangesEndpoint + "?from={from}&to={to}",
+		EndpointArgs: []interface{}{
+			contentChecksum(t, testdata.RuleContentVersion1), contentChecksum(t, testdata.RuleContentVersion2),
+		},
 	}, &helpers.APIResponse{
 		StatusCode: http.StatusOK,
-		Body:       `{"status": "ok"}`,
+		Body: `{
+			"changes": {
+				"added": ["` + string(testdata.Rule3ID) + `"],
+				"removed": [],
+				"modified": ["` + string(testdata.Rule1ID) + `"]
+			},
+			"status": "ok"
+		}`,
 	})
-}
 
-func TestListOfOrganizationsEmpty(t *testing.T) {
-	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
 		Method:   http.MethodGet,
-		Endpoint: server.OrganizationsEndpoint,
+		Endpoint: server.ContentChangesEndpoint + "?from={from}&to={to}",
+		EndpointArgs: []interface{}{
+			contentChecksum(t, testdata.RuleContentVersion2), contentChecksum(t, testdata.RuleContentVersion3),
+		},
 	}, &helpers.APIResponse{
 		StatusCode: http.StatusOK,
-		Body:       `{"organizations":[],"status":"ok"}`,
+		Body: `{
+			"changes": {
+				"added": [],
+				"removed": ["` + string(testdata.Rule2ID) + `"],
+				"modified": []
+			},
+			"status": "ok"
+		}`,
 	})
 }
 
-func TestListOfOrganizationsOK(t *testing.T) {
+func TestGetContentChangesVersionNotRetained(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	err := mockStorage.WriteReportForCluster(1, "8083c377-8a05-4922-af8d-e7d0970c1f49", "{}", time.Now())
-	helpers.FailOnError(t, err)
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContentVersion3))
 
-	err = mockStorage.WriteReportForCluster(5, "52ab955f-b769-444d-8170-4b676c5d3c85", "{}", time.Now())
-	helpers.FailOnError(t, err)
+	fromChecksum := contentChecksum(t, testdata.RuleContentVersion1)
 
 	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
-		Method:   http.MethodGet,
-		Endpoint: server.OrganizationsEndpoint,
+		Method:       http.MethodGet,
+		Endpoint:     server.ContentChangesEndpoint + "?from={from}&to={to}",
+		EndpointArgs: []interface{}{fromChecksum, contentChecksum(t, testdata.RuleContentVersion3)},
 	}, &helpers.APIResponse{
-		StatusCode: http.StatusOK,
-		Body:       `{"organizations":[1, 5],"status":"ok"}`,
+		StatusCode: http.StatusNotFound,
+		Body:       `{"status": "Item with ID ` + fromChecksum + ` was not found in the storage"}`,
 	})
 }
 
-func TestListOfOrganizationsDBError(t *testing.T) {
+func TestGetContentChangesMissingChecksum(t *testing.T) {
+	for endpoint, paramName := range map[string]string{
+		server.ContentChangesEndpoint:                 "from",
+		server.ContentChangesEndpoint + "?to=abc":     "from",
+		server.ContentChangesEndpoint + "?from=abc":   "to",
+		server.ContentChangesEndpoint + "?from=&to=a": "from",
+	} {
+		helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+			Method:   http.MethodGet,
+			Endpoint: endpoint,
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"status": "Missing required param from request: ` + paramName + `"}`,
+		})
+	}
+}
+
+func TestGetContentChangesDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	helpers.MustCloseStorage(t, mockStorage)
 
 	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
 		Method:   http.MethodGet,
-		Endpoint: server.OrganizationsEndpoint,
+		Endpoint: server.ContentChangesEndpoint + "?from=abc&to=def",
 	}, &helpers.APIResponse{
 		StatusCode: http.StatusInternalServerError,
 		Body:       `{"status": "Internal Server Error"}`,
@@ -288,12 +1244,16 @@ func TestRuleFeedbackVote(t *testing.T) {
 
 			err := mockStorage.WriteReportForCluster(
 				testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+				types.UnknownKafkaOffset,
 			)
 			helpers.FailOnError(t, err)
 
 			err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
 			helpers.FailOnError(t, err)
 
+			err = mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike)
+			helpers.FailOnError(t, err)
+
 			helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
 				Method:       http.MethodPut,
 				Endpoint:     endpoint,
@@ -304,16 +1264,279 @@ func TestRuleFeedbackVote(t *testing.T) {
 				Body:       `{"status": "ok"}`,
 			})
 
-			feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, testdata.UserID)
+			feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+			if expectedVote == storage.UserVoteNone {
+				// feedback without any message is removed when the vote is reset
+				if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+					t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+				}
+				return
+			}
 			helpers.FailOnError(t, err)
 
-			assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
-			assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
-			assert.Equal(t, testdata.UserID, feedback.UserID)
-			assert.Equal(t, "", feedback.Message)
-			assert.Equal(t, expectedVote, feedback.UserVote)
-		}(endpoint)
-	}
+			assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
+			assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
+			assert.Equal(t, testdata.UserID, feedback.UserID)
+			assert.Equal(t, "", feedback.Message)
+			assert.Equal(t, expectedVote, feedback.UserVote)
+		}(endpoint)
+	}
+}
+
+func TestDeleteFeedbackOnRule(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.FeedbackOnRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	_, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+
+	// the feedback is gone, so the second attempt fails
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.FeedbackOnRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body: fmt.Sprintf(
+			`{"status": "Item with ID %v/%v/%v was not found in the storage"}`,
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID,
+		),
+	})
+}
+
+func TestAddFeedbackOnRule(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPost,
+		Endpoint:     server.FeedbackOnRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+		Body:         `{"message": "test feedback"}`,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, "test feedback", feedback.Message)
+	assert.Equal(t, storage.UserVoteLike, feedback.UserVote)
+}
+
+// TestFeedbackOnErrorKey checks that the feedback on the error key given by the query parameter
+// is kept apart from the feedback on the whole rule
+func TestFeedbackOnErrorKey(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.DislikeRuleEndpoint + "?error_key=" + testdata.ErrorKey1,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPost,
+		Endpoint:     server.FeedbackOnRuleEndpoint + "?error_key=" + testdata.ErrorKey1,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+		Body:         `{"message": "test feedback"}`,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	feedback, err := mockStorage.GetUserFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey1, testdata.UserID,
+	)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "test feedback", feedback.Message)
+	assert.Equal(t, storage.UserVoteDislike, feedback.UserVote)
+
+	feedback, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "", feedback.Message)
+	assert.Equal(t, storage.UserVoteLike, feedback.UserVote)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.FeedbackOnRuleEndpoint + "?error_key=" + testdata.ErrorKey1,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	_, err = mockStorage.GetUserFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey1, testdata.UserID,
+	)
+	if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+
+	_, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	helpers.FailOnError(t, err)
+}
+
+func TestFeedbackOnErrorKey_BadErrorKey(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.LikeRuleEndpoint + "?error_key=bad-key",
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body: `{
+			"status": "Error during parsing param 'error_key' with value 'bad-key'. ` +
+			`Error: 'invalid error key, it must contain only from latin characters, number or underscores'"
+		}`,
+	})
+}
+
+func TestAddFeedbackOnRule_BadRequest(t *testing.T) {
+	tooLongMessage := strings.Repeat("ř", storage.DefaultMaxFeedbackMessageLength+1)
+
+	for body, expectedStatus := range map[string]string{
+		`{"message": "` + tooLongMessage + `"}`: "Invalid value of 'message': at most 2048 characters expected, got 2049",
+		`{}`:                                    "Invalid request body: missing required attribute 'message'",
+		`not json`:                              "Invalid request body: invalid character 'o' in literal null (expecting 'u')",
+	} {
+		mockStorage := helpers.MustGetMockStorage(t, true)
+
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+			types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+		helpers.FailOnError(t, err)
+
+		helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+			Method:       http.MethodPost,
+			Endpoint:     server.FeedbackOnRuleEndpoint,
+			EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+			UserID:       testdata.UserID,
+			Body:         body,
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"status": "` + expectedStatus + `"}`,
+		})
+
+		_, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+
+		helpers.MustCloseStorage(t, mockStorage)
+	}
+}
+
+func TestDeleteFeedbackOnRule_DBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.FeedbackOnRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       `{"status": "Internal Server Error"}`,
+	})
+}
+
+func TestRuleFeedbackResetVoteKeepsMessage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteDislike)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "test feedback")
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.ResetVoteOnRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, "test feedback", feedback.Message)
+	assert.Equal(t, storage.UserVoteNone, feedback.UserVote)
 }
 
 func TestRuleFeedbackVote_CheckIfRuleExists_DBError(t *testing.T) {
@@ -373,7 +1596,8 @@ func TestRuleFeedbackVote_DBError(t *testing.T) {
 			),
 		)
 
-	expects.ExpectPrepare("INSERT INTO").
+	expects.ExpectPrepare("INSERT INTO cluster_rule_user_feedback")
+	expects.ExpectBegin().
 		WillReturnError(fmt.Errorf(errStr))
 
 	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
@@ -411,7 +1635,7 @@ func TestHTTPServer_UserFeedback_RuleDoesNotExistError(t *testing.T) {
 	defer helpers.MustCloseStorage(t, mockStorage)
 
 	err := mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
 	)
 	helpers.FailOnError(t, err)
 
@@ -425,14 +1649,93 @@ func TestHTTPServer_UserFeedback_RuleDoesNotExistError(t *testing.T) {
 			UserID:       testdata.UserID,
 		}, &helpers.APIResponse{
 			StatusCode: http.StatusNotFound,
-			Body: fmt.Sprintf(
-				`{"status": "Item with ID %v was not found in the storage"}`,
-				testdata.Rule1ID,
-			),
+			Body:       fmt.Sprintf(`{"status": "Rule with ID %v does not exist"}`, testdata.Rule1ID),
 		})
 	}
 }
 
+func TestHTTPServer_UserFeedback_RuleExists(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.LikeRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+}
+
+func TestHTTPServer_UserFeedback_RuleVerificationReport(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	const unknownRuleID = "test.rule_unknown"
+
+	reportConfig := config
+	reportConfig.RuleVerification = server.RuleVerificationReport
+
+	// content is not loaded, but the rule was hit in the cluster's report
+	helpers.AssertAPIRequest(t, mockStorage, &reportConfig, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.LikeRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &reportConfig, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.LikeRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, unknownRuleID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       fmt.Sprintf(`{"status": "Rule with ID %v does not exist"}`, unknownRuleID),
+	})
+}
+
+func TestHTTPServer_UserFeedback_RuleVerificationDisabled(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	disabledConfig := config
+	disabledConfig.RuleVerification = server.RuleVerificationDisabled
+
+	helpers.AssertAPIRequest(t, mockStorage, &disabledConfig, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.DislikeRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+}
+
 func TestRuleFeedbackErrorBadClusterName(t *testing.T) {
 	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
 		Method:       http.MethodPut,
@@ -490,6 +1793,11 @@ func TestRuleFeedbackErrorClosedStorage(t *testing.T) {
 	})
 }
 
+// noDeletedRows is the JSON of storage.DeletedRows when nothing is deleted
+const noDeletedRows = `{"report": 0, "report_history": 0, "rule_hit": 0, "consumer_error": 0,
+	"cluster_rule_user_feedback": 0, "cluster_rule_user_message": 0, "rule_ack": 0, "rule_disable_org": 0,
+	"cluster_rule_toggle": 0}`
+
 func TestHTTPServer_deleteOrganizationsOK(t *testing.T) {
 	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
 		Method:       http.MethodDelete,
@@ -497,7 +1805,7 @@ func TestHTTPServer_deleteOrganizationsOK(t *testing.T) {
 		EndpointArgs: []interface{}{1},
 	}, &helpers.APIResponse{
 		StatusCode: http.StatusOK,
-		Body:       `{"status": "ok"}`,
+		Body:       `{"deleted": {"1": ` + noDeletedRows + `}, "status": "ok"}`,
 	})
 }
 
@@ -512,6 +1820,20 @@ func TestHTTPServer_deleteOrganizations_NonIntOrgID(t *testing.T) {
 	})
 }
 
+func TestHTTPServer_deleteOrganizations_OrgIDOutOfRange(t *testing.T) {
+	for _, orgID := range []string{"0", "9223372036854775808"} {
+		helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+			Method:       http.MethodDelete,
+			Endpoint:     server.DeleteOrganizationsEndpoint,
+			EndpointArgs: []interface{}{"1," + orgID},
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body: `{"status": "Error during parsing param 'organizations' with value '` + orgID +
+				`'. Error: 'organization ID in range 1..9223372036854775807 expected'"}`,
+		})
+	}
+}
+
 func TestHTTPServer_deleteOrganizations_DBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	helpers.MustCloseStorage(t, mockStorage)
@@ -533,8 +1855,34 @@ func TestHTTPServer_deleteClusters(t *testing.T) {
 		EndpointArgs: []interface{}{testdata.ClusterName},
 	}, &helpers.APIResponse{
 		StatusCode: http.StatusOK,
-		Body:       `{"status": "ok"}`,
+		Body:       `{"deleted": {"` + string(testdata.ClusterName) + `": ` + noDeletedRows + `}, "status": "ok"}`,
+	})
+}
+
+// TestHTTPServer_deleteClustersAuditLog checks that the user requesting
+// the deletion is recorded in the audit log
+func TestHTTPServer_deleteClustersAuditLog(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	since := time.Now().UTC()
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.DeleteClustersEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"deleted": {"` + string(testdata.ClusterName) + `": ` + noDeletedRows + `}, "status": "ok"}`,
 	})
+
+	entries, err := mockStorage.ListAuditLog(since, 10)
+	helpers.FailOnError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "DeleteReportsForCluster", entries[0].Operation)
+	assert.Equal(t, testdata.ClusterName, entries[0].ClusterName)
+	assert.Equal(t, testdata.UserID, entries[0].UserID)
 }
 
 func TestHTTPServer_deleteClusters_DBError(t *testing.T) {
@@ -564,3 +1912,320 @@ func TestHTTPServer_deleteClusters_BadClusterName(t *testing.T) {
 		Body:       `{"status": "Error during parsing param 'cluster' with value 'aaaa'. Error: 'invalid UUID length: 4'"}`,
 	})
 }
+
+func TestHTTPServer_softDeleteClustersAndRestore(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.DeleteClustersEndpoint + "?soft=true",
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{"deleted": {"` + string(testdata.ClusterName) + `": {"report": 1, "report_history": 0, "rule_hit": 0,
+			"consumer_error": 0, "cluster_rule_user_feedback": 0, "cluster_rule_user_message": 0, "rule_ack": 0,
+			"rule_disable_org": 0, "cluster_rule_toggle": 0}}, "status": "ok"}`,
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"clusters":[],"status":"ok"}`,
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.RestoreClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ClustersForOrganizationEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"clusters":["` + string(testdata.ClusterName) + `"],"status":"ok"}`,
+	})
+}
+
+func TestHTTPServer_softDeleteOrganizations(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.DeleteOrganizationsEndpoint + "?soft=true",
+		EndpointArgs: []interface{}{testdata.OrgID},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{"deleted": {"` + fmt.Sprint(testdata.OrgID) + `": {"report": 1, "report_history": 0, "rule_hit": 0,
+			"consumer_error": 0, "cluster_rule_user_feedback": 0, "cluster_rule_user_message": 0, "rule_ack": 0,
+			"rule_disable_org": 0, "cluster_rule_toggle": 0}}, "status": "ok"}`,
+	})
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodGet,
+		Endpoint:     server.ReportEndpoint,
+		EndpointArgs: []interface{}{testdata.OrgID, testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+	})
+}
+
+func TestHTTPServer_softDeleteClusters_BadParam(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodDelete,
+		Endpoint:     server.DeleteClustersEndpoint + "?soft=maybe",
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       `{"status": "Error during parsing param 'soft' with value 'maybe'. Error: 'boolean expected'"}`,
+	})
+}
+
+func TestHTTPServer_restoreCluster_NotFound(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.RestoreClusterEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       `{"status": "Item with ID ` + string(testdata.ClusterName) + ` was not found in the storage"}`,
+	})
+}
+
+func TestDeleteClustersBatch(t *testing.T) {
+	const unknownClusterName = "52ab955f-b769-444d-8170-4b676c5d3c85"
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:   http.MethodDelete,
+		Endpoint: server.DeleteClustersBatchEndpoint,
+		Body:     `{"clusters": ["` + string(testdata.ClusterName) + `", "` + unknownClusterName + `"]}`,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body: `{
+			"clusters": {
+				"` + string(testdata.ClusterName) + `": "deleted",
+				"` + unknownClusterName + `": "not_found"
+			},
+			"status": "ok"
+		}`,
+	})
+
+	count, err := mockStorage.ReportsCount()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestDeleteClustersBatchBadBody(t *testing.T) {
+	tooManyClusters := make([]string, 501)
+	for i := range tooManyClusters {
+		tooManyClusters[i] = `"` + string(testdata.ClusterName) + `"`
+	}
+
+	for _, testCase := range []struct {
+		body           string
+		expectedStatus string
+	}{
+		{`not-json`, "Invalid request body: invalid character 'o' in literal null (expecting 'u')"},
+		{`{"clusters": []}`, "Invalid request body: between 1 and 500 clusters expected, got 0"},
+		{
+			`{"clusters": [` + strings.Join(tooManyClusters, ",") + `]}`,
+			"Invalid request body: between 1 and 500 clusters expected, got 501",
+		},
+		{
+			`{"clusters": ["` + string(testdata.BadClusterName) + `"]}`,
+			"Error during parsing param 'cluster' with value 'aaaa'. Error: 'invalid UUID length: 4'",
+		},
+	} {
+		helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+			Method:   http.MethodDelete,
+			Endpoint: server.DeleteClustersBatchEndpoint,
+			Body:     testCase.body,
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"status": "` + testCase.expectedStatus + `"}`,
+		})
+	}
+}
+
+func reportUploadConfig() server.Configuration {
+	uploadConfig := config
+	uploadConfig.ReportUpload = true
+	uploadConfig.OrgWhitelist = mapset.NewSetWith(testdata.OrgID)
+
+	return uploadConfig
+}
+
+func TestUploadReportDisabledByDefault(t *testing.T) {
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:       http.MethodPost,
+		Endpoint:     server.UploadReportEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+		Body:         testdata.ConsumerMessage,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+	})
+}
+
+// TestUploadReportSameAsConsumer checks that the report uploaded over HTTP
+// is stored exactly the same as the report consumed from Kafka
+func TestUploadReportSameAsConsumer(t *testing.T) {
+	uploadConfig := reportUploadConfig()
+
+	httpStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, httpStorage)
+
+	helpers.AssertAPIRequest(t, httpStorage, &uploadConfig, &helpers.APIRequest{
+		Method:       http.MethodPost,
+		Endpoint:     server.UploadReportEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+		Body:         testdata.ConsumerMessage,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusCreated,
+		Body: `{
+			"report": {
+				"org_id": ` + fmt.Sprint(testdata.OrgID) + `,
+				"cluster": "` + string(testdata.ClusterName) + `",
+				"last_checked_at": "` + testdata.LastCheckedAt.UTC().Format(time.RFC3339) + `"
+			},
+			"status": "ok"
+		}`,
+	})
+
+	kafkaStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, kafkaStorage)
+
+	kafkaConsumer := &consumer.KafkaConsumer{
+		Configuration: broker.Configuration{OrgWhitelist: uploadConfig.OrgWhitelist},
+		Storage:       kafkaStorage,
+	}
+	err := kafkaConsumer.ProcessMessage(&sarama.ConsumerMessage{Value: []byte(testdata.ConsumerMessage)})
+	helpers.FailOnError(t, err)
+
+	httpReport, err := httpStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	kafkaReport, err := kafkaStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, kafkaReport.Report, httpReport.Report)
+	assert.Equal(t, kafkaReport.LastCheckedAt, httpReport.LastCheckedAt)
+}
+
+func TestUploadReportBadMessage(t *testing.T) {
+	const otherClusterName = "52ab955f-b769-444d-8170-4b676c5d3c85"
+
+	uploadConfig := reportUploadConfig()
+
+	for _, testCase := range []struct {
+		clusterName    types.ClusterName
+		body           string
+		expectedStatus string
+	}{
+		{
+			testdata.ClusterName,
+			`{"OrgID": 1, "ClusterName": "` + string(testdata.ClusterName) + `"}`,
+			"missing required attribute 'Report'",
+		},
+		{
+			testdata.ClusterName,
+			strings.Replace(testdata.ConsumerMessage, `"OrgID": 1`, `"OrgID": 2`, 1),
+			"organization ID is not whitelisted",
+		},
+		{
+			otherClusterName,
+			testdata.ConsumerMessage,
+			"Invalid request body: cluster name doesn't match the cluster in URL",
+		},
+	} {
+		helpers.AssertAPIRequest(t, nil, &uploadConfig, &helpers.APIRequest{
+			Method:       http.MethodPost,
+			Endpoint:     server.UploadReportEndpoint,
+			EndpointArgs: []interface{}{testCase.clusterName},
+			Body:         testCase.body,
+		}, &helpers.APIResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"status": "` + testCase.expectedStatus + `"}`,
+		})
+	}
+}
+
+func TestUploadReportBodyTooLarge(t *testing.T) {
+	uploadConfig := reportUploadConfig()
+	uploadConfig.ReportUploadMaxBodySize = 10
+
+	helpers.AssertAPIRequest(t, nil, &uploadConfig, &helpers.APIRequest{
+		Method:       http.MethodPost,
+		Endpoint:     server.UploadReportEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName},
+		Body:         testdata.ConsumerMessage,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       `{"status": "Invalid request body: http: request body too large"}`,
+	})
+}
+
+func TestUploadReportRateLimit(t *testing.T) {
+	uploadConfig := reportUploadConfig()
+	uploadConfig.ReportUploadRateLimit = 1
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	testServer := server.New(uploadConfig, mockStorage)
+	url := server.MakeURLToEndpoint(uploadConfig.APIPrefix, server.UploadReportEndpoint, testdata.ClusterName)
+
+	for _, expectedStatusCode := range []int{http.StatusCreated, http.StatusTooManyRequests} {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(testdata.ConsumerMessage))
+		helpers.FailOnError(t, err)
+
+		response := helpers.ExecuteRequest(testServer, req, &uploadConfig).Result()
+		checkResponseCode(t, expectedStatusCode, response.StatusCode)
+	}
+}
+
+// TestHandleServerErrorWrappedNotFound checks that not found errors of the storage are mapped to 404
+// even when they are wrapped
+func TestHandleServerErrorWrappedNotFound(t *testing.T) {
+	notFound := &storage.ItemNotFoundError{
+		Kind: storage.ItemKindReport,
+		IDs:  []interface{}{testdata.OrgID, testdata.ClusterName},
+	}
+
+	for _, err := range []error{notFound, fmt.Errorf("reading report: %w", notFound)} {
+		recorder := httptest.NewRecorder()
+		server.HandleServerError(recorder, err)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.JSONEq(t, fmt.Sprintf(
+			`{"status": "Item with ID %v/%v was not found in the storage"}`, testdata.OrgID, testdata.ClusterName,
+		), recorder.Body.String())
+	}
+}
@@ -0,0 +1,495 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/content"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// contextKeyStorageCalls is the key of storage calls made by the request in its context
+const contextKeyStorageCalls = contextKey("storage_calls")
+
+// storageCalls collects number and total duration of storage calls made by a request
+type storageCalls struct {
+	count    int64
+	duration int64
+}
+
+// record adds the storage call started at the given time
+func (calls *storageCalls) record(started time.Time) {
+	atomic.AddInt64(&calls.count, 1)
+	atomic.AddInt64(&calls.duration, int64(time.Since(started)))
+}
+
+// Count returns number of storage calls made by the request
+func (calls *storageCalls) Count() int64 {
+	return atomic.LoadInt64(&calls.count)
+}
+
+// Duration returns total duration of storage calls made by the request
+func (calls *storageCalls) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&calls.duration))
+}
+
+// withStorageCalls returns the request with new collector of storage calls in its context
+func withStorageCalls(request *http.Request) (*http.Request, *storageCalls) {
+	calls := &storageCalls{}
+	return request.WithContext(context.WithValue(request.Context(), contextKeyStorageCalls, calls)), calls
+}
+
+// requestedStorage records the user requesting destructive operations in the audit log,
+// it's usually the SQL storage
+type requestedStorage interface {
+	RequestedBy(userID types.UserID) storage.Storage
+}
+
+// storageFor returns the storage used to handle the request, the user of the request
+// is recorded as the requester of destructive operations when it's known and calls
+// of the storage are recorded in the collector of the request when it has one
+func (server *HTTPServer) storageFor(request *http.Request) Storage {
+	var requestStorage Storage = server.Storage
+
+	if requested, ok := server.Storage.(requestedStorage); ok {
+		if userID, err := server.GetCurrentUserID(request); err == nil {
+			requestStorage = requested.RequestedBy(userID)
+		}
+	}
+
+	calls, ok := request.Context().Value(contextKeyStorageCalls).(*storageCalls)
+	if !ok {
+		return requestStorage
+	}
+
+	return instrumentedStorage{storage: requestStorage, calls: calls}
+}
+
+// instrumentedStorage records all calls of the wrapped storage in the collector,
+// results (including errors) are returned unchanged
+type instrumentedStorage struct {
+	storage Storage
+	calls   *storageCalls
+}
+
+func (wrapper instrumentedStorage) Ping() error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.Ping()
+}
+
+func (wrapper instrumentedStorage) ListOfOrgs() ([]types.OrgID, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListOfOrgs()
+}
+
+func (wrapper instrumentedStorage) ListOfClustersForOrg(orgID types.OrgID) ([]types.ClusterName, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListOfClustersForOrg(orgID)
+}
+
+func (wrapper instrumentedStorage) ListOfClustersForOrgWithTimestamps(
+	orgID types.OrgID,
+) ([]types.ClusterListItem, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListOfClustersForOrgWithTimestamps(orgID)
+}
+
+func (wrapper instrumentedStorage) ListClustersCheckedInRange(
+	orgID types.OrgID, from, to time.Time,
+) ([]types.ClusterNameWithTimestamp, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListClustersCheckedInRange(orgID, from, to)
+}
+
+func (wrapper instrumentedStorage) ListStaleClusters(olderThan time.Duration) ([]types.ClusterName, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListStaleClusters(olderThan)
+}
+
+func (wrapper instrumentedStorage) ClustersCountPerOrg() (map[types.OrgID]int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ClustersCountPerOrg()
+}
+
+func (wrapper instrumentedStorage) ReadReportForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+) (types.StoredReport, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ReadReportForCluster(orgID, clusterName)
+}
+
+func (wrapper instrumentedStorage) ReadReportMetainfo(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+) (*types.ReportMetainfo, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ReadReportMetainfo(orgID, clusterName)
+}
+
+func (wrapper instrumentedStorage) ReadReportForClusterByClusterName(
+	clusterName types.ClusterName,
+) (types.StoredReport, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ReadReportForClusterByClusterName(clusterName)
+}
+
+func (wrapper instrumentedStorage) WriteReportForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	collectedAtTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.WriteReportForCluster(orgID, clusterName, report, collectedAtTime, kafkaOffset)
+}
+
+func (wrapper instrumentedStorage) WriteReportsForClusters(reports []types.ReportItem) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.WriteReportsForClusters(reports)
+}
+
+func (wrapper instrumentedStorage) ReadReportHistoryForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	limit int,
+) ([]types.ReportHistoryEntry, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ReadReportHistoryForCluster(orgID, clusterName, limit)
+}
+
+func (wrapper instrumentedStorage) GetHitsCountHistory(
+	clusterName types.ClusterName,
+	days int,
+) ([]types.DailyHitsCount, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetHitsCountHistory(clusterName, days)
+}
+
+func (wrapper instrumentedStorage) GetProcessingErrorsForCluster(
+	clusterName types.ClusterName,
+	limit int,
+) ([]types.ProcessingError, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetProcessingErrorsForCluster(clusterName, limit)
+}
+
+func (wrapper instrumentedStorage) ListConsumerErrors(limit int) ([]storage.ConsumerError, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListConsumerErrors(limit)
+}
+
+func (wrapper instrumentedStorage) GetRuleHitsForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+) ([]types.RuleOnReport, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetRuleHitsForCluster(orgID, clusterName)
+}
+
+func (wrapper instrumentedStorage) ReportsCount() (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ReportsCount()
+}
+
+func (wrapper instrumentedStorage) GetLatestKafkaOffset() (types.KafkaOffset, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetLatestKafkaOffset()
+}
+
+func (wrapper instrumentedStorage) WriteConsumerError(consumerError storage.ConsumerError) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.WriteConsumerError(consumerError)
+}
+
+func (wrapper instrumentedStorage) ReportsCountForOrg(orgID types.OrgID) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ReportsCountForOrg(orgID)
+}
+
+func (wrapper instrumentedStorage) GetOrgStatistics(orgID types.OrgID) (types.OrgStats, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetOrgStatistics(orgID)
+}
+
+func (wrapper instrumentedStorage) GetClustersHittingRule(ruleID types.RuleID) ([]types.ClusterName, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetClustersHittingRule(ruleID)
+}
+
+func (wrapper instrumentedStorage) VoteOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+	userVote storage.UserVote,
+) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.VoteOnRule(clusterID, ruleID, errorKey, userID, userVote)
+}
+
+func (wrapper instrumentedStorage) AddOrUpdateFeedbackOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+	message string,
+) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.AddOrUpdateFeedbackOnRule(clusterID, ruleID, errorKey, userID, message)
+}
+
+func (wrapper instrumentedStorage) GetUserFeedbackOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+) (*storage.UserFeedbackOnRule, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetUserFeedbackOnRule(clusterID, ruleID, errorKey, userID)
+}
+
+func (wrapper instrumentedStorage) ResetVoteOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ResetVoteOnRule(clusterID, ruleID, errorKey, userID)
+}
+
+func (wrapper instrumentedStorage) DeleteUserFeedbackOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.DeleteUserFeedbackOnRule(clusterID, ruleID, errorKey, userID)
+}
+
+func (wrapper instrumentedStorage) ListFeedbacksForCluster(
+	clusterID types.ClusterName,
+) ([]storage.UserFeedbackOnRule, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListFeedbacksForCluster(clusterID)
+}
+
+func (wrapper instrumentedStorage) GetUserFeedbackOnRules(
+	clusterID types.ClusterName,
+	ruleIDs []types.RuleID,
+	userID types.UserID,
+) (map[types.RuleID]storage.UserVote, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetUserFeedbackOnRules(clusterID, ruleIDs, userID)
+}
+
+func (wrapper instrumentedStorage) GetVotesForRule(ruleID types.RuleID) (likes int, dislikes int, err error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetVotesForRule(ruleID)
+}
+
+func (wrapper instrumentedStorage) GetVotesForRuleByOrg(
+	orgID types.OrgID,
+	ruleID types.RuleID,
+) (likes int, dislikes int, err error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetVotesForRuleByOrg(orgID, ruleID)
+}
+
+func (wrapper instrumentedStorage) GetRuleFeedbackStatsForOrg(orgID types.OrgID) ([]storage.RuleFeedbackStats, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetRuleFeedbackStatsForOrg(orgID)
+}
+
+func (wrapper instrumentedStorage) GetContentForRules(rules types.ReportRules) ([]types.RuleContentResponse, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetContentForRules(rules)
+}
+
+func (wrapper instrumentedStorage) DeleteReportsForOrg(orgID types.OrgID) (storage.DeletedRows, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.DeleteReportsForOrg(orgID)
+}
+
+func (wrapper instrumentedStorage) DeleteReportsForCluster(
+	clusterName types.ClusterName,
+) (storage.DeletedRows, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.DeleteReportsForCluster(clusterName)
+}
+
+func (wrapper instrumentedStorage) DeleteReportsForClusters(
+	clusterNames []types.ClusterName,
+) ([]types.ClusterName, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.DeleteReportsForClusters(clusterNames)
+}
+
+func (wrapper instrumentedStorage) GetExistingClusters(clusterNames []types.ClusterName) ([]types.ClusterName, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetExistingClusters(clusterNames)
+}
+
+func (wrapper instrumentedStorage) CleanupOldReports(olderThan time.Duration) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.CleanupOldReports(olderThan)
+}
+
+func (wrapper instrumentedStorage) SoftDeleteReportsForOrg(orgID types.OrgID) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.SoftDeleteReportsForOrg(orgID)
+}
+
+func (wrapper instrumentedStorage) SoftDeleteReportsForCluster(clusterName types.ClusterName) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.SoftDeleteReportsForCluster(clusterName)
+}
+
+func (wrapper instrumentedStorage) RestoreCluster(clusterName types.ClusterName) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.RestoreCluster(clusterName)
+}
+
+func (wrapper instrumentedStorage) PurgeSoftDeleted(olderThan time.Duration) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.PurgeSoftDeleted(olderThan)
+}
+
+func (wrapper instrumentedStorage) CleanupOrphanedFeedback() (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.CleanupOrphanedFeedback()
+}
+
+func (wrapper instrumentedStorage) CleanupConsumerErrors(olderThan time.Duration) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.CleanupConsumerErrors(olderThan)
+}
+
+func (wrapper instrumentedStorage) ForEachReportCheckedBefore(
+	cutoff time.Time, process func(types.ArchivedReport) error,
+) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ForEachReportCheckedBefore(cutoff, process)
+}
+
+func (wrapper instrumentedStorage) CleanupClustersCheckedBefore(
+	cutoff time.Time,
+	clusterNames []types.ClusterName,
+) (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.CleanupClustersCheckedBefore(cutoff, clusterNames)
+}
+
+func (wrapper instrumentedStorage) GetDisabledRulesForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) ([]types.RuleID, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetDisabledRulesForCluster(orgID, clusterName)
+}
+
+func (wrapper instrumentedStorage) GetSilencingStatsForOrg(orgID types.OrgID) (storage.SilencingStats, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetSilencingStatsForOrg(orgID)
+}
+
+func (wrapper instrumentedStorage) ListDisabledRulesForOrg(orgID types.OrgID) ([]storage.DisabledRuleSummary, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListDisabledRulesForOrg(orgID)
+}
+
+func (wrapper instrumentedStorage) LoadRuleContent(contentDir content.RuleContentDirectory) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.LoadRuleContent(contentDir)
+}
+
+func (wrapper instrumentedStorage) GetContentChanges(fromChecksum, toChecksum string) (types.ContentChanges, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetContentChanges(fromChecksum, toChecksum)
+}
+
+func (wrapper instrumentedStorage) GetRuleByID(ruleID types.RuleID) (*types.Rule, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetRuleByID(ruleID)
+}
+
+func (wrapper instrumentedStorage) ListRules(filter storage.RuleFilter) ([]types.Rule, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListRules(filter)
+}
+
+func (wrapper instrumentedStorage) DeleteRule(ruleID types.RuleID) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.DeleteRule(ruleID)
+}
+
+func (wrapper instrumentedStorage) DeleteRuleErrorKey(ruleID types.RuleID, errorKey types.ErrorKey) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.DeleteRuleErrorKey(ruleID, errorKey)
+}
+
+func (wrapper instrumentedStorage) GetOrgIDByClusterID(cluster types.ClusterName) (types.OrgID, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.GetOrgIDByClusterID(cluster)
+}
+
+func (wrapper instrumentedStorage) DoesClusterExist(clusterName types.ClusterName) (bool, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.DoesClusterExist(clusterName)
+}
+
+func (wrapper instrumentedStorage) ExportReportsForOrg(orgID types.OrgID, writer io.Writer) error {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ExportReportsForOrg(orgID, writer)
+}
+
+func (wrapper instrumentedStorage) ImportReports(reader io.Reader) (storage.ImportStats, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ImportReports(reader)
+}
+
+func (wrapper instrumentedStorage) CheckReportsConsistency(
+	after storage.ReportKey,
+	limit int,
+	repair bool,
+) (storage.ConsistencyCheckBatch, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.CheckReportsConsistency(after, limit, repair)
+}
+
+func (wrapper instrumentedStorage) CountReportsWithNullTimestamps() (int, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.CountReportsWithNullTimestamps()
+}
+
+func (wrapper instrumentedStorage) ListConsistencyIssues() ([]storage.ConsistencyIssue, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListConsistencyIssues()
+}
+
+func (wrapper instrumentedStorage) ListAuditLog(since time.Time, limit int) ([]storage.AuditLogEntry, error) {
+	defer wrapper.calls.record(time.Now())
+	return wrapper.storage.ListAuditLog(since, limit)
+}
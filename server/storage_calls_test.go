@@ -0,0 +1,148 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/server"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// servedRequestLog is the part of access log entry written when the request is served
+type servedRequestLog struct {
+	Message         string  `json:"message"`
+	URI             string  `json:"uri"`
+	StorageCalls    int64   `json:"storage_calls"`
+	StorageDuration float64 `json:"storage_duration"`
+}
+
+// captureLog redirects the global logger to the returned buffer until the returned function is called
+func captureLog() (*bytes.Buffer, func()) {
+	buf := new(bytes.Buffer)
+	originalLogger := log.Logger
+	log.Logger = zerolog.New(buf)
+
+	return buf, func() {
+		log.Logger = originalLogger
+	}
+}
+
+// mustGetServedRequestLog returns the only access log entry of served request from the log
+func mustGetServedRequestLog(t *testing.T, buf *bytes.Buffer) servedRequestLog {
+	var entries []servedRequestLog
+
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var entry servedRequestLog
+		helpers.FailOnError(t, json.Unmarshal(scanner.Bytes(), &entry))
+
+		if entry.Message == "Request served" {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("expected exactly one served request in the log, got %v", len(entries))
+	}
+
+	return entries[0]
+}
+
+// TestRequestLogStorageCalls checks that access log contains storage calls made by the request,
+// voting on rule reads the report and the rule and then it writes the vote
+func TestRequestLogStorageCalls(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+	helpers.FailOnError(t, err)
+
+	buf, restoreLog := captureLog()
+	defer restoreLog()
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.LikeRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	entry := mustGetServedRequestLog(t, buf)
+	assert.Contains(t, entry.URI, "like")
+	assert.Equal(t, int64(3), entry.StorageCalls)
+	assert.True(t, entry.StorageDuration > 0)
+}
+
+// TestRequestLogStorageCallsError checks that errors of storage calls reach the handler unchanged,
+// voting on rule of unknown cluster stops after reading the report
+func TestRequestLogStorageCallsError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	buf, restoreLog := captureLog()
+	defer restoreLog()
+
+	helpers.AssertAPIRequest(t, mockStorage, &config, &helpers.APIRequest{
+		Method:       http.MethodPut,
+		Endpoint:     server.LikeRuleEndpoint,
+		EndpointArgs: []interface{}{testdata.ClusterName, testdata.Rule1ID},
+		UserID:       testdata.UserID,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       fmt.Sprintf(`{"status": "Item with ID %v was not found in the storage"}`, testdata.ClusterName),
+	})
+
+	entry := mustGetServedRequestLog(t, buf)
+	assert.Equal(t, int64(1), entry.StorageCalls)
+}
+
+// TestRequestLogNoStorageCalls checks that requests not touching the storage are logged with no calls
+func TestRequestLogNoStorageCalls(t *testing.T) {
+	buf, restoreLog := captureLog()
+	defer restoreLog()
+
+	helpers.AssertAPIRequest(t, nil, &config, &helpers.APIRequest{
+		Method:   http.MethodGet,
+		Endpoint: server.LiveEndpoint,
+	}, &helpers.APIResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"status": "ok"}`,
+	})
+
+	entry := mustGetServedRequestLog(t, buf)
+	assert.Equal(t, int64(0), entry.StorageCalls)
+}
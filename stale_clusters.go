@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Implementation of periodic refresh of the number of stale clusters for aggregator
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// staleClustersLister lists clusters whose reports were not checked for a long time,
+// it's usually the storage
+type staleClustersLister interface {
+	ListStaleClusters(olderThan time.Duration) ([]types.ClusterName, error)
+}
+
+// refreshStaleClusters sets the stale clusters metric to the number of clusters
+// not checked for longer than the threshold, the metric is kept when the storage fails
+func refreshStaleClusters(lister staleClustersLister, threshold time.Duration) {
+	clusters, err := lister.ListStaleClusters(threshold)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read stale clusters")
+		return
+	}
+
+	metrics.StaleClusters.Set(float64(len(clusters)))
+	log.Debug().Int("stale_clusters", len(clusters)).Msgf("Clusters not checked for %v counted", threshold)
+}
+
+// startStaleClustersRefresh opens the storage connection and periodically refreshes the number
+// of stale clusters until the context is cancelled
+func startStaleClustersRefresh(ctx context.Context, staleCfg staleClustersConfiguration) {
+	dbStorage, err := startStorageConnection()
+	if err != nil {
+		log.Error().Err(err).Msg("Periodic refresh of stale clusters can't be started")
+		return
+	}
+	defer closeStorage(dbStorage)
+
+	log.Info().
+		Str("interval", staleCfg.Interval.String()).
+		Str("threshold", staleCfg.Threshold.String()).
+		Msg("Periodic refresh of stale clusters has been started")
+
+	// the metric is set right away, not only after the first interval
+	refreshStaleClusters(dbStorage, staleCfg.Threshold)
+	runPeriodically(ctx, staleCfg.Interval, func() {
+		refreshStaleClusters(dbStorage, staleCfg.Threshold)
+	})
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// AuditLogEntry describes a destructive operation run by the storage. OrgID, ClusterName and RuleID
+// are zero when the operation doesn't affect them, UserID is empty when the requesting user is not known.
+// DeletedRows is the total number of rows deleted by the operation from all tables.
+type AuditLogEntry struct {
+	Operation   string            `json:"operation"`
+	OrgID       types.OrgID       `json:"org_id"`
+	ClusterName types.ClusterName `json:"cluster"`
+	RuleID      types.RuleID      `json:"rule_id"`
+	UserID      types.UserID      `json:"user_id"`
+	DeletedRows int               `json:"deleted_rows"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// total returns the number of rows deleted from all tables
+func (deleted DeletedRows) total() int {
+	return deleted.Reports + deleted.ReportHistory + deleted.RuleHits + deleted.ConsumerErrors +
+		deleted.Feedback + deleted.FeedbackMessages + deleted.RuleAcks + deleted.DisabledRules + deleted.Toggles
+}
+
+// RequestedBy returns the storage recording the user as the requester of destructive operations
+// in the audit log. The returned storage shares the connection with this one, so it must not be closed.
+func (storage DBStorage) RequestedBy(userID types.UserID) Storage {
+	storage.requester = userID
+	return &storage
+}
+
+// nullableOrgID returns value of the nullable org_id column, zero organization ID is stored as NULL.
+// The ID is passed to the driver as it is, so the driver rejects the ID not fitting into BIGINT
+// instead of storing it wrapped around to a negative number.
+func nullableOrgID(orgID types.OrgID) interface{} {
+	if orgID == 0 {
+		return nil
+	}
+
+	return orgID
+}
+
+// recordAudit is the hook run after the destructive operation has succeeded, it writes the entry
+// to the audit log outside of the transaction of the operation. Failure of the write is only logged,
+// the operation is done already, so it doesn't fail because of that.
+func (storage DBStorage) recordAudit(ctx context.Context, entry AuditLogEntry) {
+	if entry.UserID == "" {
+		entry.UserID = storage.requester
+	}
+
+	orgID := nullableOrgID(entry.OrgID)
+	clusterName := sql.NullString{String: string(entry.ClusterName), Valid: entry.ClusterName != ""}
+	ruleID := sql.NullString{String: string(entry.RuleID), Valid: entry.RuleID != ""}
+
+	_, err := storage.connection.ExecContext(ctx, `
+		INSERT INTO audit_log (operation, org_id, cluster, rule_id, user_id, deleted_rows, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		entry.Operation, orgID, clusterName, ruleID, entry.UserID, entry.DeletedRows, time.Now().UTC(),
+	)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("operation", entry.Operation).
+			Uint64("org_id", uint64(entry.OrgID)).
+			Str("cluster", string(entry.ClusterName)).
+			Str("rule_id", string(entry.RuleID)).
+			Str("user_id", string(entry.UserID)).
+			Int("deleted_rows", entry.DeletedRows).
+			Msg("Unable to record destructive operation in audit log")
+	}
+}
+
+// ListAuditLog returns at most limit of the most recent destructive operations run since the given time,
+// the most recent operation goes first
+func (storage DBStorage) ListAuditLog(since time.Time, limit int) (_ []AuditLogEntry, err error) {
+	op := storage.startOperation("ListAuditLog", fastRead)
+	defer op.finish(&err)
+
+	entries := make([]AuditLogEntry, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT operation, org_id, cluster, rule_id, user_id, deleted_rows, created_at
+		  FROM audit_log
+		 WHERE created_at >= $1
+		 ORDER BY created_at DESC
+		 LIMIT $2`, since.UTC(), limit)
+	if err != nil {
+		return entries, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			entry       AuditLogEntry
+			orgID       sql.NullInt64
+			clusterName sql.NullString
+			ruleID      sql.NullString
+		)
+
+		err := rows.Scan(
+			&entry.Operation, &orgID, &clusterName, &ruleID, &entry.UserID, &entry.DeletedRows,
+			scanTimestamp(&entry.CreatedAt),
+		)
+		if err != nil {
+			return entries, err
+		}
+
+		entry.OrgID = types.OrgID(orgID.Int64)
+		entry.ClusterName = types.ClusterName(clusterName.String)
+		entry.RuleID = types.RuleID(ruleID.String)
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,322 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const adminUserID = types.UserID("admin")
+
+// totalDeletedRows returns the number of rows deleted from all tables
+func totalDeletedRows(deleted storage.DeletedRows) int {
+	return deleted.Reports + deleted.ReportHistory + deleted.RuleHits + deleted.ConsumerErrors +
+		deleted.Feedback + deleted.FeedbackMessages + deleted.RuleAcks + deleted.DisabledRules + deleted.Toggles
+}
+
+// mustListAuditLog returns all entries of the audit log written since the given time,
+// times of the entries are checked and cleared, so the entries can be compared
+func mustListAuditLog(t *testing.T, mockStorage storage.Storage, since time.Time) []storage.AuditLogEntry {
+	entries, err := mockStorage.ListAuditLog(since, 100)
+	helpers.FailOnError(t, err)
+
+	for i := range entries {
+		assert.False(t, entries[i].CreatedAt.Before(since), "entry written before %v", since)
+		entries[i].CreatedAt = time.Time{}
+	}
+
+	return entries
+}
+
+// TestDBStorageAuditLogOfDestructiveOperations checks that every destructive operation
+// is recorded in the audit log together with the user who requested it
+func TestDBStorageAuditLogOfDestructiveOperations(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	since := time.Now().UTC()
+
+	mustWriteReport3Rules(t, mockStorage)
+	helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID, "ack"))
+	helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID))
+	errorKeys := countRuleErrorKeys(t, mockStorage, testdata.Rule3ID)
+
+	requested := mockStorage.(*storage.DBStorage).RequestedBy(adminUserID)
+
+	helpers.FailOnError(t, requested.DeleteAckForOrg(testdata.OrgID, testdata.Rule1ID))
+	// the user enabling the rule is recorded instead of the requester
+	helpers.FailOnError(t, requested.EnableRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID))
+	helpers.FailOnError(t, requested.DeleteRule(testdata.Rule3ID))
+
+	deletedForCluster, err := requested.DeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, deletedForCluster.Reports)
+
+	deletedForOrg, err := requested.DeleteReportsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, []storage.AuditLogEntry{
+		{
+			Operation:   "DeleteReportsForOrg",
+			OrgID:       testdata.OrgID,
+			UserID:      adminUserID,
+			DeletedRows: totalDeletedRows(deletedForOrg),
+		},
+		{
+			Operation:   "DeleteReportsForCluster",
+			ClusterName: testdata.ClusterName,
+			UserID:      adminUserID,
+			DeletedRows: totalDeletedRows(deletedForCluster),
+		},
+		{
+			Operation:   "DeleteRule",
+			RuleID:      testdata.Rule3ID,
+			UserID:      adminUserID,
+			DeletedRows: errorKeys + 1,
+		},
+		{
+			Operation:   "EnableRuleForOrg",
+			OrgID:       testdata.OrgID,
+			RuleID:      testdata.Rule2ID,
+			UserID:      testdata.UserID,
+			DeletedRows: 1,
+		},
+		{
+			Operation:   "DeleteAckForOrg",
+			OrgID:       testdata.OrgID,
+			RuleID:      testdata.Rule1ID,
+			UserID:      adminUserID,
+			DeletedRows: 1,
+		},
+	}, mustListAuditLog(t, mockStorage, since))
+}
+
+// TestDBStorageAuditLogUnknownRequester checks that operations of the storage without
+// the requester are recorded with empty user
+func TestDBStorageAuditLogUnknownRequester(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	since := time.Now().UTC()
+
+	_, err := mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, []storage.AuditLogEntry{
+		{Operation: "DeleteReportsForCluster", ClusterName: testdata.ClusterName},
+	}, mustListAuditLog(t, mockStorage, since))
+}
+
+// TestDBStorageAuditLogHighestOrgID checks that the highest organization ID which can be stored
+// is recorded in the audit log unchanged
+func TestDBStorageAuditLogHighestOrgID(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+		types.MaxOrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	))
+
+	since := time.Now().UTC()
+
+	deleted, err := mockStorage.DeleteReportsForOrg(types.MaxOrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, deleted.Reports)
+
+	assert.Equal(t, []storage.AuditLogEntry{
+		{Operation: "DeleteReportsForOrg", OrgID: types.MaxOrgID, DeletedRows: totalDeletedRows(deleted)},
+	}, mustListAuditLog(t, mockStorage, since))
+}
+
+// TestDBStorageAuditLogOfBatchDeletion checks that deletion of a batch of clusters
+// is recorded in the audit log by a single entry summarizing all deleted rows
+func TestDBStorageAuditLogOfBatchDeletion(t *testing.T) {
+	const otherClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	for _, clusterName := range []types.ClusterName{testdata.ClusterName, otherClusterName} {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, clusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		))
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			clusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+		))
+	}
+
+	// the same rows are deleted for both clusters
+	deletedForCluster, err := mockStorage.DeleteReportsForCluster(otherClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, deletedForCluster.Toggles)
+
+	since := time.Now().UTC()
+
+	deletedClusters, err := mockStorage.(*storage.DBStorage).RequestedBy(adminUserID).DeleteReportsForClusters(
+		[]types.ClusterName{testdata.ClusterName, otherClusterName},
+	)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{testdata.ClusterName}, deletedClusters)
+
+	assert.Equal(t, []storage.AuditLogEntry{
+		{Operation: "DeleteReportsForClusters", UserID: adminUserID, DeletedRows: totalDeletedRows(deletedForCluster)},
+	}, mustListAuditLog(t, mockStorage, since))
+}
+
+// TestDBStorageAuditLogOfSoftDeletion checks that soft-deletions, restores and purges of reports
+// are recorded in the audit log
+func TestDBStorageAuditLogOfSoftDeletion(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	since := time.Now().UTC()
+
+	mustWriteReport3Rules(t, mockStorage)
+
+	requested := mockStorage.(*storage.DBStorage).RequestedBy(adminUserID)
+
+	deleted, err := requested.SoftDeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	helpers.FailOnError(t, requested.RestoreCluster(testdata.ClusterName))
+
+	deleted, err = requested.SoftDeleteReportsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	purged, err := mockStorage.PurgeSoftDeleted(-time.Hour)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, purged)
+
+	entries := mustListAuditLog(t, mockStorage, since)
+	if assert.Len(t, entries, 4) {
+		// rule hits and history of the report are purged together with it
+		assert.Equal(t, "PurgeSoftDeleted", entries[0].Operation)
+		assert.Greater(t, entries[0].DeletedRows, purged)
+		entries[0].DeletedRows = 0
+	}
+
+	assert.Equal(t, []storage.AuditLogEntry{
+		{Operation: "PurgeSoftDeleted"},
+		{Operation: "SoftDeleteReportsForOrg", OrgID: testdata.OrgID, UserID: adminUserID, DeletedRows: 1},
+		{Operation: "RestoreCluster", ClusterName: testdata.ClusterName, UserID: adminUserID},
+		{
+			Operation:   "SoftDeleteReportsForCluster",
+			ClusterName: testdata.ClusterName,
+			UserID:      adminUserID,
+			DeletedRows: 1,
+		},
+	}, entries)
+}
+
+// TestDBStorageAuditLogFailedOperations checks that operations which didn't delete anything
+// because the item doesn't exist are not recorded
+func TestDBStorageAuditLogFailedOperations(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	since := time.Now().UTC()
+
+	err := mockStorage.DeleteAckForOrg(testdata.OrgID, testdata.Rule1ID)
+	assertItemNotFound(t, err, storage.ItemKindToggle, testdata.OrgID, testdata.Rule1ID)
+
+	err = mockStorage.DeleteRule(testdata.Rule1ID)
+	assertItemNotFound(t, err, storage.ItemKindRule, testdata.Rule1ID)
+
+	assert.Empty(t, mustListAuditLog(t, mockStorage, since))
+}
+
+// TestDBStorageAuditLogWriteError checks that the destructive operation doesn't fail
+// when it can't be recorded in the audit log, the failure is logged instead
+func TestDBStorageAuditLogWriteError(t *testing.T) {
+	buf := new(bytes.Buffer)
+	originalLogger := log.Logger
+	log.Logger = zerolog.New(buf)
+	defer func() {
+		log.Logger = originalLogger
+	}()
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustWriteReport3Rules(t, mockStorage)
+
+	_, err := storage.GetConnection(mockStorage.(*storage.DBStorage)).Exec("DROP TABLE audit_log")
+	helpers.FailOnError(t, err)
+
+	deleted, err := mockStorage.(*storage.DBStorage).RequestedBy(adminUserID).DeleteReportsForCluster(
+		testdata.ClusterName,
+	)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, deleted.Reports)
+	assertNumberOfReports(t, mockStorage, 0)
+
+	assert.Contains(t, buf.String(), "Unable to record destructive operation in audit log")
+	assert.Contains(t, buf.String(), `"operation":"DeleteReportsForCluster"`)
+	assert.Contains(t, buf.String(), `"user_id":"admin"`)
+}
+
+// TestDBStorageListAuditLogSinceAndLimit checks that only the most recent entries
+// written since the given time are returned
+func TestDBStorageListAuditLogSinceAndLimit(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.DeleteReportsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+
+	since := time.Now().UTC()
+
+	for _, orgID := range []types.OrgID{2, 3, 4} {
+		_, err := mockStorage.DeleteReportsForOrg(orgID)
+		helpers.FailOnError(t, err)
+	}
+
+	entries, err := mockStorage.ListAuditLog(since, 2)
+	helpers.FailOnError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, types.OrgID(4), entries[0].OrgID)
+	assert.Equal(t, types.OrgID(3), entries[1].OrgID)
+
+	entries, err = mockStorage.ListAuditLog(since, 10)
+	helpers.FailOnError(t, err)
+	assert.Len(t, entries, 3)
+
+	entries, err = mockStorage.ListAuditLog(time.Now().Add(time.Hour), 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDBStorageListAuditLogDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.ListAuditLog(time.Time{}, 10)
+	assert.EqualError(t, err, "sql: database is closed")
+}
@@ -0,0 +1,402 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// DefaultReportsBatchSize is the number of reports written by a single upsert
+// of WriteReportsForClusters when it's not configured
+const DefaultReportsBatchSize = 500
+
+// batchedReport is a report prepared for writing by WriteReportsForClusters,
+// the report is compressed already when compression of reports is enabled
+type batchedReport struct {
+	item     types.ReportItem
+	report   types.ClusterReport
+	checksum string
+	rules    types.ReportRules
+}
+
+// batchedWrite is a report of the batch together with the way it's written,
+// it's outdated or duplicate in the same sense as in ReportWrite
+type batchedWrite struct {
+	batchedReport
+	outdated  bool
+	duplicate bool
+}
+
+// storedReportState is the part of the stored report deciding how a report
+// of the same cluster is written
+type storedReportState struct {
+	lastCheckedAt time.Time
+	kafkaOffset   sql.NullInt64
+	checksum      string
+}
+
+// WriteReportsForClusters writes reports of many clusters at once, e.g. when reports are backfilled.
+// Reports are written in batches by multi-row upserts, each batch in its own transaction. Like
+// in WriteReportForCluster, the stored report is replaced only by a report which is not older,
+// reports consumed from the same or older Kafka offset than the stored one are skipped and only
+// the time of the last check of identical reports is updated. When the batch can't be written,
+// its reports are written one by one, so only reports which can't be written at all are not written.
+// Number of written reports is returned together with BatchWriteError listing clusters
+// whose reports were not written.
+func (storage DBStorage) WriteReportsForClusters(reports []types.ReportItem) (int, error) {
+	failed := make(map[types.ClusterName]error)
+	prepared := make([]batchedReport, 0, len(reports))
+
+	for _, item := range reports {
+		report, err := storage.prepareBatchedReport(item)
+		if err != nil {
+			failed[item.ClusterName] = err
+			continue
+		}
+		prepared = append(prepared, report)
+	}
+
+	written := 0
+	batchSize := storage.reportsPerBatch()
+
+	for start := 0; start < len(prepared); start += batchSize {
+		end := start + batchSize
+		if end > len(prepared) {
+			end = len(prepared)
+		}
+
+		written += storage.writeReportsBatchOrEach(prepared[start:end], failed)
+	}
+
+	if len(failed) > 0 {
+		return written, &BatchWriteError{Errors: failed}
+	}
+
+	return written, nil
+}
+
+// reportsPerBatch returns the number of reports written by a single upsert, it's limited,
+// so the upsert doesn't exceed the maximal number of arguments of a query in the database
+func (storage DBStorage) reportsPerBatch() int {
+	batchSize := storage.reportsBatchSize
+	if limit := storage.dialect().maxPlaceholders() / len(reportUpsert.columns); batchSize > limit {
+		batchSize = limit
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return batchSize
+}
+
+// prepareBatchedReport parses the report to fail early if it's malformed,
+// computes its checksum and compresses and encrypts it when compression and encryption of reports are enabled
+func (storage DBStorage) prepareBatchedReport(item types.ReportItem) (batchedReport, error) {
+	prepared := batchedReport{item: item, report: item.Report}
+
+	if err := json.Unmarshal([]byte(item.Report), &prepared.rules); err != nil {
+		return prepared, &InvalidReportError{OrgID: item.OrgID, ClusterName: item.ClusterName}
+	}
+
+	// the checksum is computed from the plain report, so it doesn't depend on the compression and encryption
+	prepared.checksum = reportChecksum(item.Report)
+
+	encodedReport, err := storage.encodeReport(item.Report)
+	if err != nil {
+		return prepared, err
+	}
+	prepared.report = encodedReport
+
+	return prepared, nil
+}
+
+// writeReportsBatchOrEach writes the batch of reports, its reports are written one by one
+// when the batch fails, failures are recorded for clusters of reports which can't be written.
+// Number of written reports is returned.
+func (storage DBStorage) writeReportsBatchOrEach(batch []batchedReport, failed map[types.ClusterName]error) int {
+	written, err := storage.writeReportsBatch(batch)
+	if err == nil {
+		return written
+	}
+
+	if len(batch) == 1 {
+		failed[batch[0].item.ClusterName] = err
+		return 0
+	}
+
+	log.Warn().Err(err).Int("reports", len(batch)).Msg("Unable to write batch of reports, writing them one by one")
+
+	written = 0
+	for i := range batch {
+		reportWritten, err := storage.writeReportsBatch(batch[i : i+1])
+		if err != nil {
+			failed[batch[i].item.ClusterName] = err
+			continue
+		}
+		written += reportWritten
+	}
+
+	return written
+}
+
+// writeReportsBatch writes the batch of reports in a single transaction,
+// the write is retried when it fails because of a transient error
+func (storage DBStorage) writeReportsBatch(batch []batchedReport) (written int, err error) {
+	op := storage.startOperation("WriteReportsForClusters", write)
+	defer op.finish(&err)
+
+	err = storage.withRetries(op.ctx, "WriteReportsForClusters", func() error {
+		var err error
+		written, err = storage.writeReportsInTransaction(op.ctx, batch)
+		return err
+	})
+
+	return written, err
+}
+
+// writeReportsInTransaction writes the batch of reports and runs write hooks for each of them
+// in a single transaction, write hooks get all reports of the batch in its order
+func (storage DBStorage) writeReportsInTransaction(ctx context.Context, batch []batchedReport) (int, error) {
+	tx, err := storage.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	stored, err := storage.readStoredReportStates(ctx, tx, batch)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	writes, latest := planBatchedWrites(batch, stored)
+
+	written, err := storage.writeLatestReports(ctx, tx, writes, latest)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	for _, batched := range writes {
+		err = storage.runWriteHooks(ctx, tx, ReportWrite{
+			OrgID:           batched.item.OrgID,
+			ClusterName:     batched.item.ClusterName,
+			Report:          batched.report,
+			Rules:           batched.rules,
+			LastCheckedTime: batched.item.LastCheckedAt,
+			KafkaOffset:     batched.item.KafkaOffset,
+			Outdated:        batched.outdated,
+			Duplicate:       batched.duplicate,
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	outdated := 0
+	for _, batched := range writes {
+		switch {
+		case batched.outdated:
+			outdated++
+		case batched.duplicate:
+			metrics.DuplicateReportsSkipped.Inc()
+		}
+
+		// the cluster could be unknown for the organization, so the cached organization may not be the lowest one
+		storage.orgIDs.forgetUnlessOrg(batched.item.ClusterName, batched.item.OrgID)
+	}
+
+	if outdated > 0 {
+		log.Warn().Int("outdated", outdated).Msg("Database already contains more recent reports of clusters from the batch")
+	}
+
+	return written, nil
+}
+
+// readStoredReportStates reads states of reports stored for clusters of the batch
+func (storage DBStorage) readStoredReportStates(
+	ctx context.Context, tx *sql.Tx, batch []batchedReport,
+) (map[ReportKey]storedReportState, error) {
+	clusters := make([]interface{}, 0, len(batch))
+	for _, report := range batch {
+		clusters = append(clusters, report.item.ClusterName)
+	}
+
+	args := storage.dialect().newQueryArgs()
+	query := "SELECT org_id, cluster, last_checked_at, kafka_offset, report_checksum FROM report WHERE cluster IN " +
+		args.addList(clusters...)
+
+	rows, err := tx.QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	stored := make(map[ReportKey]storedReportState, len(batch))
+
+	for rows.Next() {
+		var (
+			key      ReportKey
+			state    storedReportState
+			checksum sql.NullString
+		)
+
+		err := rows.Scan(&key.OrgID, &key.ClusterName, scanTimestamp(&state.lastCheckedAt), &state.kafkaOffset, &checksum)
+		if err != nil {
+			return nil, err
+		}
+
+		state.checksum = checksum.String
+		stored[key] = state
+	}
+
+	return stored, rows.Err()
+}
+
+// planBatchedWrites decides how each report of the batch is written, reports are applied
+// to the stored states in the order of the batch, so a cluster can have more reports in the batch.
+// Reports consumed from the same or older Kafka offset than the stored report are skipped.
+// Indexes of the latest written reports of clusters are returned together with whether
+// their content changes, only the time of the last check changes otherwise.
+func planBatchedWrites(
+	batch []batchedReport, stored map[ReportKey]storedReportState,
+) ([]batchedWrite, map[ReportKey]latestWrite) {
+	writes := make([]batchedWrite, 0, len(batch))
+	latest := make(map[ReportKey]latestWrite)
+
+	for _, report := range batch {
+		key := ReportKey{OrgID: report.item.OrgID, ClusterName: report.item.ClusterName}
+		state, found := stored[key]
+
+		if found && report.item.KafkaOffset >= 0 && state.kafkaOffset.Valid &&
+			state.kafkaOffset.Int64 >= int64(report.item.KafkaOffset) {
+			continue
+		}
+
+		newer := !found || !state.lastCheckedAt.After(report.item.LastCheckedAt)
+		batched := batchedWrite{
+			batchedReport: report,
+			outdated:      !newer,
+			duplicate:     found && newer && state.checksum == report.checksum,
+		}
+
+		if newer {
+			stored[key] = storedReportState{
+				lastCheckedAt: report.item.LastCheckedAt,
+				kafkaOffset:   kafkaOffsetValue(report.item.KafkaOffset),
+				checksum:      report.checksum,
+			}
+			latest[key] = latestWrite{
+				index:   len(writes),
+				changed: latest[key].changed || !batched.duplicate,
+			}
+		}
+
+		writes = append(writes, batched)
+	}
+
+	return writes, latest
+}
+
+// latestWrite points to the latest written report of the cluster in the batch,
+// changed is set when any report of the cluster in the batch changes the stored content
+type latestWrite struct {
+	index   int
+	changed bool
+}
+
+// writeLatestReports writes the latest reports of clusters of the batch, reports with changed
+// content are upserted by a single statement, only the time of the last check is updated otherwise.
+// The error is returned when any report isn't written as planned, because it has been changed
+// concurrently, so the whole batch can be rolled back. Number of written reports is returned.
+func (storage DBStorage) writeLatestReports(
+	ctx context.Context, tx *sql.Tx, writes []batchedWrite, latest map[ReportKey]latestWrite,
+) (int, error) {
+	written := 0
+	upserted := 0
+	args := make([]interface{}, 0, len(reportUpsert.columns)*len(latest))
+	reportedAtTime := time.Now()
+
+	for i, batched := range writes {
+		if !batched.outdated {
+			written++
+		}
+
+		key := ReportKey{OrgID: batched.item.OrgID, ClusterName: batched.item.ClusterName}
+		if current, found := latest[key]; !found || current.index != i {
+			continue
+		}
+
+		if !latest[key].changed {
+			updated, err := updateDuplicateReport(
+				ctx, tx, key.OrgID, key.ClusterName, batched.checksum, batched.item.LastCheckedAt, batched.item.KafkaOffset,
+			)
+			if err != nil {
+				return 0, err
+			}
+			if !updated {
+				return 0, fmt.Errorf("report of cluster %v has been changed concurrently", key.ClusterName)
+			}
+			continue
+		}
+
+		args = append(args,
+			key.OrgID, key.ClusterName, batched.report, reportedAtTime, batched.item.LastCheckedAt,
+			kafkaOffsetValue(batched.item.KafkaOffset), batched.checksum,
+		)
+		upserted++
+	}
+
+	if upserted == 0 {
+		return written, nil
+	}
+
+	upsertQuery, ok := storage.dialect().upsertRows(reportUpsert, upserted)
+	if !ok {
+		return 0, fmt.Errorf("writing reports with DB %v is not supported", storage.dbDriverType)
+	}
+
+	result, err := tx.ExecContext(ctx, upsertQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// the upsert doesn't change rows of more recent reports written concurrently
+	if int(affected) != upserted {
+		return 0, fmt.Errorf("%v of %v reports have been changed concurrently", upserted-int(affected), upserted)
+	}
+
+	return written, nil
+}
@@ -0,0 +1,330 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// reportItem returns report of the cluster of the test organization written in bulk
+func reportItem(
+	clusterName types.ClusterName, report types.ClusterReport, lastCheckedAt time.Time, kafkaOffset types.KafkaOffset,
+) types.ReportItem {
+	return types.ReportItem{
+		OrgID:         testdata.OrgID,
+		ClusterName:   clusterName,
+		Report:        report,
+		LastCheckedAt: lastCheckedAt,
+		KafkaOffset:   kafkaOffset,
+	}
+}
+
+// assertStoredReport checks the report stored for the cluster together with the time of its last check
+func assertStoredReport(
+	t *testing.T, mockStorage storage.Storage, clusterName types.ClusterName,
+	report types.ClusterReport, lastCheckedAt time.Time,
+) {
+	stored, err := mockStorage.ReadReportForCluster(testdata.OrgID, clusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, report, stored.Report)
+	assert.True(t, lastCheckedAt.Equal(stored.LastCheckedAt), "expected %v, got %v", lastCheckedAt, stored.LastCheckedAt)
+}
+
+func TestWriteReportsForClusters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		reports := make([]types.ReportItem, 0, 5)
+		for i := 0; i < 5; i++ {
+			reports = append(reports, reportItem(
+				benchmarkClusterName(i), testdata.Report3Rules, testdata.LastCheckedAt, types.KafkaOffset(i),
+			))
+		}
+
+		written, err := mockStorage.WriteReportsForClusters(reports)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 5, written)
+
+		count, err := mockStorage.ReportsCount()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 5, count)
+
+		for i := 0; i < 5; i++ {
+			assertStoredReport(t, mockStorage, benchmarkClusterName(i), testdata.Report3Rules, testdata.LastCheckedAt)
+
+			ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, benchmarkClusterName(i))
+			helpers.FailOnError(t, err)
+			assert.Len(t, ruleHits, 3)
+		}
+
+		offset, err := mockStorage.GetLatestKafkaOffset()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.KafkaOffset(4), offset)
+	})
+}
+
+// TestWriteReportsForClustersNewerWins checks that the stored report is replaced only
+// by a more recent report, even when the batch contains more reports of the same cluster
+func TestWriteReportsForClustersNewerWins(t *testing.T) {
+	older := testdata.LastCheckedAt.Add(-time.Hour)
+	newer := testdata.LastCheckedAt.Add(time.Hour)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, benchmarkClusterName(0), testdata.Report3Rules, testdata.LastCheckedAt,
+			types.UnknownKafkaOffset,
+		))
+
+		written, err := mockStorage.WriteReportsForClusters([]types.ReportItem{
+			// outdated by the stored report
+			reportItem(benchmarkClusterName(0), testdata.Report0Rules, older, types.UnknownKafkaOffset),
+			// the newer report of the same cluster wins regardless of the order
+			reportItem(benchmarkClusterName(1), testdata.Report0Rules, newer, types.UnknownKafkaOffset),
+			reportItem(benchmarkClusterName(1), testdata.Report3Rules, older, types.UnknownKafkaOffset),
+			reportItem(benchmarkClusterName(2), testdata.Report3Rules, older, types.UnknownKafkaOffset),
+			reportItem(benchmarkClusterName(2), testdata.Report0Rules, newer, types.UnknownKafkaOffset),
+		})
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 3, written)
+
+		assertStoredReport(t, mockStorage, benchmarkClusterName(0), testdata.Report3Rules, testdata.LastCheckedAt)
+		assertStoredReport(t, mockStorage, benchmarkClusterName(1), testdata.Report0Rules, newer)
+		assertStoredReport(t, mockStorage, benchmarkClusterName(2), testdata.Report0Rules, newer)
+
+		// rule hits are the ones of the stored reports
+		ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, benchmarkClusterName(0))
+		helpers.FailOnError(t, err)
+		assert.Len(t, ruleHits, 3)
+
+		ruleHits, err = mockStorage.GetRuleHitsForCluster(testdata.OrgID, benchmarkClusterName(2))
+		helpers.FailOnError(t, err)
+		assert.Empty(t, ruleHits)
+	})
+}
+
+// TestWriteReportsForClustersDuplicateAndOldOffset checks that identical report only updates the time
+// of the last check and that reports consumed from already written offsets are skipped
+func TestWriteReportsForClustersDuplicateAndOldOffset(t *testing.T) {
+	newer := testdata.LastCheckedAt.Add(time.Hour)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		for i := 0; i < 2; i++ {
+			helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+				testdata.OrgID, benchmarkClusterName(i), testdata.Report3Rules, testdata.LastCheckedAt, 10,
+			))
+		}
+
+		written, err := mockStorage.WriteReportsForClusters([]types.ReportItem{
+			reportItem(benchmarkClusterName(0), testdata.Report3Rules, newer, 11),
+			reportItem(benchmarkClusterName(1), testdata.Report0Rules, newer, 10),
+		})
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, written)
+
+		assertStoredReport(t, mockStorage, benchmarkClusterName(0), testdata.Report3Rules, newer)
+		assertStoredReport(t, mockStorage, benchmarkClusterName(1), testdata.Report3Rules, testdata.LastCheckedAt)
+
+		offset, err := mockStorage.GetLatestKafkaOffset()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.KafkaOffset(11), offset)
+	})
+}
+
+// TestWriteReportsForClustersFailedClusters checks that reports which can't be written are reported
+// and that the remaining reports are written
+func TestWriteReportsForClustersFailedClusters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		written, err := mockStorage.WriteReportsForClusters([]types.ReportItem{
+			reportItem(benchmarkClusterName(0), testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset),
+			reportItem(benchmarkClusterName(1), "{not a report", testdata.LastCheckedAt, types.UnknownKafkaOffset),
+			reportItem(benchmarkClusterName(2), testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset),
+		})
+		assert.Equal(t, 2, written)
+
+		var batchErr *storage.BatchWriteError
+		if !errors.As(err, &batchErr) {
+			t.Fatalf("expected BatchWriteError, got %T, %+v", err, err)
+		}
+		assert.Equal(t, []types.ClusterName{benchmarkClusterName(1)}, batchErr.FailedClusters())
+		assert.IsType(t, &storage.InvalidReportError{}, batchErr.Errors[benchmarkClusterName(1)])
+
+		count, err := mockStorage.ReportsCount()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 2, count)
+	})
+}
+
+// TestDBStorageWriteReportsForClustersInBatches checks that reports are written by batches
+// of the configured size and that the failed batch is written report by report
+func TestDBStorageWriteReportsForClustersInBatches(t *testing.T) {
+	const otherOrgID = types.OrgID(2)
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	storage.SetReportsBatchSize(mockStorage.(*storage.DBStorage), 2)
+
+	// the cluster already belongs to another organization, so its report can't be written
+	helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+		otherOrgID, benchmarkClusterName(3), testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	))
+
+	reports := make([]types.ReportItem, 0, 5)
+	for i := 0; i < 5; i++ {
+		reports = append(reports, reportItem(
+			benchmarkClusterName(i), testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		))
+	}
+
+	written, err := mockStorage.WriteReportsForClusters(reports)
+	assert.Equal(t, 4, written)
+
+	var batchErr *storage.BatchWriteError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected BatchWriteError, got %T, %+v", err, err)
+	}
+	assert.Equal(t, []types.ClusterName{benchmarkClusterName(3)}, batchErr.FailedClusters())
+
+	clusters, err := mockStorage.ListOfClustersForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{
+		benchmarkClusterName(0), benchmarkClusterName(1), benchmarkClusterName(2), benchmarkClusterName(4),
+	}, clusters)
+}
+
+// TestDBStorageReportsPerBatchLimited checks that the batch doesn't exceed
+// the maximal number of arguments of a query in the database
+func TestDBStorageReportsPerBatchLimited(t *testing.T) {
+	sqliteStorage := storage.NewFromConnection(nil, storage.DBDriverSQLite3)
+	assert.Equal(t, 999/7, storage.GetReportsPerBatch(sqliteStorage))
+
+	postgresStorage := storage.NewFromConnection(nil, storage.DBDriverPostgres)
+	assert.Equal(t, storage.DefaultReportsBatchSize, storage.GetReportsPerBatch(postgresStorage))
+
+	storage.SetReportsBatchSize(postgresStorage, 100000)
+	assert.Equal(t, 65535/7, storage.GetReportsPerBatch(postgresStorage))
+}
+
+func TestDBStorageWriteReportsForClustersFakePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	const otherClusterName = types.ClusterName("9b4c1a3e-7c6d-4f0e-8a2b-3d5e6f7a8b9c")
+	checksum := helpers.ReportChecksum(testdata.Report0Rules)
+
+	expects.ExpectBegin()
+	expects.ExpectQueryWithArgs(`
+		SELECT org_id, cluster, last_checked_at, kafka_offset, report_checksum FROM report
+		WHERE cluster IN ($1, $2)`,
+		testdata.ClusterName, otherClusterName,
+	).WillReturnRows(
+		sqlmock.NewRows([]string{"org_id", "cluster", "last_checked_at", "kafka_offset", "report_checksum"}),
+	).RowsWillBeClosed()
+	expects.ExpectExecWithArgs(`
+		INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at, kafka_offset, report_checksum)
+		VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (org_id, cluster)
+		DO UPDATE SET report = excluded.report, reported_at = excluded.reported_at,
+			last_checked_at = excluded.last_checked_at, kafka_offset = excluded.kafka_offset,
+			report_checksum = excluded.report_checksum, deleted_at = NULL
+		WHERE report.last_checked_at IS NULL OR report.last_checked_at <= excluded.last_checked_at`,
+		testdata.OrgID, testdata.ClusterName, string(testdata.Report0Rules), helpers.RecentTime(),
+		helpers.TimeEqual(testdata.LastCheckedAt), int64(5), checksum,
+		testdata.OrgID, otherClusterName, string(testdata.Report0Rules), helpers.RecentTime(),
+		helpers.TimeEqual(testdata.LastCheckedAt), nil, checksum,
+	).WillReturnResult(sqlmock.NewResult(0, 2))
+	for _, clusterName := range []types.ClusterName{testdata.ClusterName, otherClusterName} {
+		expects.ExpectExecWithArgs(
+			`DELETE FROM rule_hit WHERE org_id = $1 AND cluster = $2`, testdata.OrgID, clusterName,
+		).WillReturnResult(driver.ResultNoRows)
+	}
+	expects.ExpectCommit()
+
+	written, err := mockStorage.WriteReportsForClusters([]types.ReportItem{
+		reportItem(testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt, 5),
+		reportItem(otherClusterName, testdata.Report0Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset),
+	})
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 2, written)
+}
+
+func TestNoopStorageWriteReportsForClusters(t *testing.T) {
+	written, err := storage.NewNoopStorage().WriteReportsForClusters([]types.ReportItem{
+		reportItem(testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset),
+	})
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, written)
+}
+
+// BenchmarkWriteReportsForClusters compares writing of reports one by one
+// with writing them in batches on SQLite
+func BenchmarkWriteReportsForClusters(b *testing.B) {
+	reports := make([]types.ReportItem, benchmarkReportsCount)
+	for i := range reports {
+		reports[i] = reportItem(benchmarkClusterName(i), syntheticReport(i, 5), time.Now(), types.UnknownKafkaOffset)
+	}
+
+	for _, benchmark := range []struct {
+		name  string
+		write func(mockStorage storage.Storage) error
+	}{
+		{"single", func(mockStorage storage.Storage) error {
+			for _, report := range reports {
+				err := mockStorage.WriteReportForCluster(
+					report.OrgID, report.ClusterName, report.Report, report.LastCheckedAt, report.KafkaOffset,
+				)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"batched", func(mockStorage storage.Storage) error {
+			_, err := mockStorage.WriteReportsForClusters(reports)
+			return err
+		}},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				mockStorage, err := helpers.GetMockStorage(true)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if err := benchmark.write(mockStorage); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				if err := mockStorage.Close(); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// Capabilities describes features of the storage backend, operations
+// needing a feature which is not available fail with "not supported" error
+type Capabilities struct {
+	// Upsert is set when INSERT ... ON CONFLICT ... DO UPDATE statement is supported
+	Upsert bool `json:"upsert"`
+	// InsertOrReplace is set when INSERT OR REPLACE statement is supported,
+	// it's preferred over Upsert when rows are replaced as a whole
+	InsertOrReplace bool `json:"insert_or_replace"`
+	// JSONOperators is set when reports can be searched by JSON operators in the database
+	JSONOperators bool `json:"json_operators"`
+	// ApproximateCounts is set when the number of rows in the table can be estimated
+	// from statistics of the database without counting them
+	ApproximateCounts bool `json:"approximate_counts"`
+}
+
+// capabilitiesOfDriver returns capabilities of the storage using the driver,
+// no capabilities are returned for unknown drivers
+func capabilitiesOfDriver(driverType DBDriver) Capabilities {
+	switch driverType {
+	case DBDriverSQLite3:
+		return Capabilities{
+			Upsert:          true,
+			InsertOrReplace: true,
+		}
+	case DBDriverPostgres:
+		return Capabilities{
+			Upsert:            true,
+			JSONOperators:     true,
+			ApproximateCounts: true,
+		}
+	case DBDriverGeneral:
+		// only the standard SQL is expected from the general driver
+		return Capabilities{
+			Upsert: true,
+		}
+	default:
+		return Capabilities{}
+	}
+}
+
+// Capabilities returns features supported by the database of the storage
+func (storage DBStorage) Capabilities() Capabilities {
+	return storage.capabilities
+}
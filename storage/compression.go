@@ -0,0 +1,79 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// compressedReportPrefix is the beginning of every compressed report.
+// Compressed reports are stored as base64 encoded gzip data inside JSON string,
+// so they are accepted by VARCHAR as well as by JSONB column. "H4sI" is base64
+// encoded gzip magic number followed by deflate compression method.
+const compressedReportPrefix = `"H4sI`
+
+// compressReport compresses the report by gzip and encodes it to be stored in report column
+func compressReport(report types.ClusterReport) (types.ClusterReport, error) {
+	var buffer bytes.Buffer
+
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(report)); err != nil {
+		return "", err
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return types.ClusterReport(`"` + base64.StdEncoding.EncodeToString(buffer.Bytes()) + `"`), nil
+}
+
+// isReportCompressed checks whether the stored report has been compressed by compressReport
+func isReportCompressed(report types.ClusterReport) bool {
+	return strings.HasPrefix(string(report), compressedReportPrefix)
+}
+
+// decompressReport decompresses report compressed by compressReport,
+// uncompressed reports are returned unchanged
+func decompressReport(report types.ClusterReport) (types.ClusterReport, error) {
+	if !isReportCompressed(report) {
+		return report, nil
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(strings.Trim(string(report), `"`))
+	if err != nil {
+		return "", err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	return types.ClusterReport(decompressed), reader.Close()
+}
@@ -0,0 +1,236 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const benchmarkReportsCount = 2000
+
+func mustGetCompressingStorage(t testing.TB, compress bool) storage.Storage {
+	mockStorage, err := helpers.GetMockStorage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.SetCompressReports(mockStorage.(*storage.DBStorage), compress)
+
+	return mockStorage
+}
+
+func readStoredReport(t testing.TB, mockStorage storage.Storage, clusterName types.ClusterName) string {
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
+
+	var report string
+	err := connection.QueryRow("SELECT report FROM report WHERE cluster = $1", clusterName).Scan(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return report
+}
+
+// TestDBStorageWriteReportForClusterCompressed checks that the compressed report
+// is stored in compressed form and read back unchanged
+func TestDBStorageWriteReportForClusterCompressed(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	stored := readStoredReport(t, mockStorage, testdata.ClusterName)
+	assert.True(t, strings.HasPrefix(stored, `"H4sI`))
+
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	storedReport, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+}
+
+// TestDBStorageReadReportsMixedCompression checks that compressed and uncompressed
+// reports stored in the same table are both read correctly
+func TestDBStorageReadReportsMixedCompression(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	const compressedClusterName = types.ClusterName("a0f7eedc-0dd8-49cd-9d4d-f6646df3a5bc")
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	storage.SetCompressReports(mockStorage.(*storage.DBStorage), true)
+	writeReportForCluster(t, mockStorage, testdata.OrgID, compressedClusterName, testdata.Report0Rules)
+
+	assert.Equal(t, string(testdata.Report3Rules), readStoredReport(t, mockStorage, testdata.ClusterName))
+	assert.NotEqual(t, string(testdata.Report0Rules), readStoredReport(t, mockStorage, compressedClusterName))
+
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+	checkReportForCluster(t, mockStorage, testdata.OrgID, compressedClusterName, testdata.Report0Rules)
+
+	count, err := mockStorage.ReportsCount()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 2, count)
+
+	clusters, err := mockStorage.GetClustersHittingRule(testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{testdata.ClusterName}, clusters)
+}
+
+// TestDBStorageGetClustersHittingRuleCompressed checks that compressed reports are searched on SQLite
+func TestDBStorageGetClustersHittingRuleCompressed(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	clusters, err := mockStorage.GetClustersHittingRule(testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{testdata.ClusterName}, clusters)
+}
+
+// TestDBStorageReadReportCorruptedCompression checks that corrupted compressed report leads to error
+func TestDBStorageReadReportCorruptedCompression(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
+	mustWriteReport(t, connection, testdata.OrgID, testdata.ClusterName, `"H4sI not base64"`)
+
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	assert.Error(t, err)
+}
+
+// syntheticReport returns report with nRules rule hits that resembles the real reports
+func syntheticReport(clusterIndex, nRules int) types.ClusterReport {
+	hits := make([]string, nRules)
+	for i := range hits {
+		hits[i] = fmt.Sprintf(`{
+			"component": "ccx_rules_ocp.external.rules.rule_%d.report",
+			"key": "RULE_%d_ERROR_KEY",
+			"details": {
+				"type": "rule",
+				"error_key": "RULE_%d_ERROR_KEY",
+				"nodes": [{"name": "node-%d", "role": "master", "memory": 8.16, "memory_req": 16}]
+			},
+			"links": {"kcs": ["https://access.redhat.com/solutions/%d"]}
+		}`, i, i, i, clusterIndex, 4000000+i)
+	}
+
+	return types.ClusterReport(`{
+		"system": {"metadata": {}, "hostname": null},
+		"reports": [` + strings.Join(hits, ",") + `],
+		"fingerprints": [],
+		"skips": [],
+		"info": []
+	}`)
+}
+
+func benchmarkClusterName(i int) types.ClusterName {
+	return types.ClusterName(fmt.Sprintf("%08d-0dd8-49cd-9d4d-f6646df3a5bc", i))
+}
+
+func benchmarkWriteReports(b *testing.B, compress bool) {
+	reports := make([]types.ClusterReport, benchmarkReportsCount)
+	for i := range reports {
+		reports[i] = syntheticReport(i, 5)
+	}
+
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		mockStorage := mustGetCompressingStorage(b, compress)
+		b.StartTimer()
+
+		for i, report := range reports {
+			err := mockStorage.WriteReportForCluster(
+				testdata.OrgID, benchmarkClusterName(i), report, time.Now(), types.UnknownKafkaOffset,
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.StopTimer()
+		var size int64
+		err := storage.GetConnection(mockStorage.(*storage.DBStorage)).
+			QueryRow("SELECT SUM(LENGTH(report)) FROM report").Scan(&size)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(float64(size)/benchmarkReportsCount, "stored-bytes/report")
+		if err := mockStorage.Close(); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+func benchmarkReadReports(b *testing.B, compress bool) {
+	mockStorage := mustGetCompressingStorage(b, compress)
+	defer func() {
+		if err := mockStorage.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+
+	for i := 0; i < benchmarkReportsCount; i++ {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, benchmarkClusterName(i), syntheticReport(i, 5), time.Now(), types.UnknownKafkaOffset,
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, err := mockStorage.ReadReportForCluster(testdata.OrgID, benchmarkClusterName(n%benchmarkReportsCount))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteReportsUncompressed measures writing of uncompressed reports and their stored size
+func BenchmarkWriteReportsUncompressed(b *testing.B) {
+	benchmarkWriteReports(b, false)
+}
+
+// BenchmarkWriteReportsCompressed measures writing of compressed reports and their stored size
+func BenchmarkWriteReportsCompressed(b *testing.B) {
+	benchmarkWriteReports(b, true)
+}
+
+// BenchmarkReadReportUncompressed measures reading of uncompressed report
+func BenchmarkReadReportUncompressed(b *testing.B) {
+	benchmarkReadReports(b, false)
+}
+
+// BenchmarkReadReportCompressed measures reading and decompression of compressed report
+func BenchmarkReadReportCompressed(b *testing.B) {
+	benchmarkReadReports(b, true)
+}
@@ -16,15 +16,86 @@ limitations under the License.
 
 package storage
 
+import "time"
+
 // Configuration represents configuration of data storage
+//
+// SQLiteJournalMode (WAL by default) and SQLiteBusyTimeout (5s by default) are applied to each
+// connection to SQLite database together with enforcement of foreign keys, which can be turned
+// off by SQLiteDisableForeignKeys
+//
+// FastReadTimeout, HeavyAggregationTimeout, WriteTimeout and MaintenanceTimeout limit duration
+// of the respective classes of storage operations. QueryTimeout is used for classes without
+// their own timeout, default values are used when neither of them is set
+//
+// MaxRetries is the number of retries of writes failed because of transient errors of the database,
+// RetryBackoff is the delay before the first retry, it's doubled for each next retry
+//
+// MaxOpenConnections, MaxIdleConnections and ConnectionMaxLifetime tune the pool of connections
+// to the database, defaults of database/sql package are kept when they are not set
+//
+// SlowQueryThreshold - storage operations taking longer are logged as warnings independently
+// of LogSQLQueries, 0 disables the logging
+//
+// WriteMode selects whether rule hits are written into rule_hit table together with reports
+// (dual_write, default) or not (legacy), ReadSource selects whether rule hits are read from
+// rule_hit table (default) or from reports. ReadComparisonSampleRate is the fraction of reads
+// of rule hits compared with the other source, divergences are logged
+//
+// ReportsBatchSize is the number of reports written by a single statement of WriteReportsForClusters,
+// DefaultReportsBatchSize is used when it's not set. It's lowered when the statement would exceed
+// the maximal number of arguments of a query in the database
+//
+// PGReplicaHost selects read replica of PostgreSQL database used by read-only operations,
+// PGReplicaPort, PGReplicaUsername and PGReplicaPassword default to the ones of the primary
+// database. Reads use the primary database when the replica isn't reachable at startup
+//
+// PGPartitionReports enables partitioning of the report table by hash of org_id into PGReportPartitions
+// partitions (DefaultReportPartitions when it's not set) on PostgreSQL, it's ignored by other databases
+//
+// ReportEncryption configures encryption of reports stored in the database, reports are stored
+// unencrypted when no encryption key is configured
 type Configuration struct {
-	Driver           string `mapstructure:"db_driver" toml:"db_driver"`
-	SQLiteDataSource string `mapstructure:"sqlite_datasource" toml:"sqlite_datasource"`
-	LogSQLQueries    bool   `mapstructure:"log_sql_queries" toml:"log_sql_queries"`
-	PGUsername       string `mapstructure:"pg_username" toml:"pg_username"`
-	PGPassword       string `mapstructure:"pg_password" toml:"pg_password"`
-	PGHost           string `mapstructure:"pg_host" toml:"pg_host"`
-	PGPort           int    `mapstructure:"pg_port" toml:"pg_port"`
-	PGDBName         string `mapstructure:"pg_db_name" toml:"pg_db_name"`
-	PGParams         string `mapstructure:"pg_params" toml:"pg_params"`
+	Driver                   string                        `mapstructure:"db_driver" toml:"db_driver"`
+	SQLiteDataSource         string                        `mapstructure:"sqlite_datasource" toml:"sqlite_datasource"`
+	SQLiteJournalMode        string                        `mapstructure:"sqlite_journal_mode" toml:"sqlite_journal_mode"`
+	SQLiteBusyTimeout        time.Duration                 `mapstructure:"sqlite_busy_timeout" toml:"sqlite_busy_timeout"`
+	SQLiteDisableForeignKeys bool                          `mapstructure:"sqlite_disable_foreign_keys" toml:"sqlite_disable_foreign_keys"`
+	LogSQLQueries            bool                          `mapstructure:"log_sql_queries" toml:"log_sql_queries"`
+	LogSQLQueriesWithArgs    bool                          `mapstructure:"log_sql_queries_with_args" toml:"log_sql_queries_with_args"`
+	LogSQLQueriesMaxLength   int                           `mapstructure:"log_sql_queries_max_length" toml:"log_sql_queries_max_length"`
+	PGUsername               string                        `mapstructure:"pg_username" toml:"pg_username"`
+	PGPassword               string                        `mapstructure:"pg_password" toml:"pg_password"`
+	PGHost                   string                        `mapstructure:"pg_host" toml:"pg_host"`
+	PGPort                   int                           `mapstructure:"pg_port" toml:"pg_port"`
+	PGDBName                 string                        `mapstructure:"pg_db_name" toml:"pg_db_name"`
+	PGParams                 string                        `mapstructure:"pg_params" toml:"pg_params"`
+	PGReplicaUsername        string                        `mapstructure:"pg_replica_username" toml:"pg_replica_username"`
+	PGReplicaPassword        string                        `mapstructure:"pg_replica_password" toml:"pg_replica_password"`
+	PGReplicaHost            string                        `mapstructure:"pg_replica_host" toml:"pg_replica_host"`
+	PGReplicaPort            int                           `mapstructure:"pg_replica_port" toml:"pg_replica_port"`
+	PGPartitionReports       bool                          `mapstructure:"pg_partition_reports" toml:"pg_partition_reports"`
+	PGReportPartitions       int                           `mapstructure:"pg_report_partitions" toml:"pg_report_partitions"`
+	CompressReports          bool                          `mapstructure:"compress_reports" toml:"compress_reports"`
+	ReportHistoryDepth       int                           `mapstructure:"report_history_depth" toml:"report_history_depth"`
+	MaxFeedbackMessageLength int                           `mapstructure:"max_feedback_message_length" toml:"max_feedback_message_length"`
+	ContentHistoryDepth      int                           `mapstructure:"content_history_depth" toml:"content_history_depth"`
+	QueryTimeout             time.Duration                 `mapstructure:"query_timeout" toml:"query_timeout"`
+	FastReadTimeout          time.Duration                 `mapstructure:"fast_read_timeout" toml:"fast_read_timeout"`
+	HeavyAggregationTimeout  time.Duration                 `mapstructure:"heavy_aggregation_timeout" toml:"heavy_aggregation_timeout"`
+	WriteTimeout             time.Duration                 `mapstructure:"write_timeout" toml:"write_timeout"`
+	MaintenanceTimeout       time.Duration                 `mapstructure:"maintenance_timeout" toml:"maintenance_timeout"`
+	MaxRetries               int                           `mapstructure:"max_retries" toml:"max_retries"`
+	RetryBackoff             time.Duration                 `mapstructure:"retry_backoff" toml:"retry_backoff"`
+	MaxOpenConnections       int                           `mapstructure:"max_open_connections" toml:"max_open_connections"`
+	MaxIdleConnections       int                           `mapstructure:"max_idle_connections" toml:"max_idle_connections"`
+	ConnectionMaxLifetime    time.Duration                 `mapstructure:"connection_max_lifetime" toml:"connection_max_lifetime"`
+	SlowQueryThreshold       time.Duration                 `mapstructure:"slow_query_threshold" toml:"slow_query_threshold"`
+	WriteMode                string                        `mapstructure:"write_mode" toml:"write_mode"`
+	ReadSource               string                        `mapstructure:"read_source" toml:"read_source"`
+	ReadComparisonSampleRate float64                       `mapstructure:"read_comparison_sample_rate" toml:"read_comparison_sample_rate"`
+	OrgIDCacheSize           int                           `mapstructure:"org_id_cache_size" toml:"org_id_cache_size"`
+	OrgIDCacheTTL            time.Duration                 `mapstructure:"org_id_cache_ttl" toml:"org_id_cache_ttl"`
+	ReportsBatchSize         int                           `mapstructure:"reports_batch_size" toml:"reports_batch_size"`
+	ReportEncryption         ReportEncryptionConfiguration `mapstructure:"report_encryption" toml:"report_encryption"`
 }
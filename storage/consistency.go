@@ -0,0 +1,410 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// DefaultConsistencyCheckBatchSize is the number of reports checked by a single
+// call of CheckReportsConsistency when the batch size is not configured
+const DefaultConsistencyCheckBatchSize = 100
+
+// ErrConsistencyCheckInLegacyMode is returned by CheckReportsConsistency in legacy write mode,
+// rule hits are not stored in that mode, so there's nothing to compare with reports and to repair
+var ErrConsistencyCheckInLegacyMode = errors.New("rule hits are not checked for consistency in legacy write mode")
+
+// ReportKey identifies the report of the cluster, reports are checked for consistency
+// in the order of their keys
+type ReportKey struct {
+	OrgID       types.OrgID
+	ClusterName types.ClusterName
+}
+
+// ConsistencyIssue describes the report whose derived data (rows of rule_hit table)
+// don't match the report itself
+type ConsistencyIssue struct {
+	OrgID       types.OrgID       `json:"org_id"`
+	ClusterName types.ClusterName `json:"cluster"`
+	Description string            `json:"description"`
+	Repaired    bool              `json:"repaired"`
+	DetectedAt  time.Time         `json:"detected_at"`
+}
+
+// ConsistencyCheckBatch is the result of checking a single batch of reports,
+// Last is the key of the last checked report to continue with
+type ConsistencyCheckBatch struct {
+	Checked int
+	Last    ReportKey
+	Issues  []ConsistencyIssue
+}
+
+// ConsistencyCheckSummary is the result of checking all reports,
+// NullTimestamps is the number of reports missing the time of the last check or of reporting
+type ConsistencyCheckSummary struct {
+	Checked        int                `json:"checked"`
+	Issues         []ConsistencyIssue `json:"issues"`
+	NullTimestamps int                `json:"null_timestamps,omitempty"`
+}
+
+// ConsistencyChecker checks consistency of reports batch by batch, it's usually the storage
+type ConsistencyChecker interface {
+	CheckReportsConsistency(after ReportKey, limit int, repair bool) (ConsistencyCheckBatch, error)
+	CountReportsWithNullTimestamps() (int, error)
+}
+
+// CheckConsistency checks all reports by batches of batchSize reports and repairs
+// the inconsistent ones if repair is set. Reports with NULL timestamps are counted
+// when all reports are checked. The check is interrupted between batches
+// when the context is done, the summary of already checked reports is returned
+// together with the error of the context in such case.
+func CheckConsistency(
+	ctx context.Context, checker ConsistencyChecker, batchSize int, repair bool,
+) (ConsistencyCheckSummary, error) {
+	summary := ConsistencyCheckSummary{Issues: make([]ConsistencyIssue, 0)}
+
+	if batchSize <= 0 {
+		batchSize = DefaultConsistencyCheckBatchSize
+	}
+
+	var after ReportKey
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		batch, err := checker.CheckReportsConsistency(after, batchSize, repair)
+		if err != nil {
+			return summary, err
+		}
+
+		summary.Checked += batch.Checked
+		summary.Issues = append(summary.Issues, batch.Issues...)
+
+		if batch.Checked < batchSize {
+			summary.NullTimestamps, err = checker.CountReportsWithNullTimestamps()
+			return summary, err
+		}
+
+		after = batch.Last
+	}
+}
+
+// checkedReport is a report read by the consistency check
+type checkedReport struct {
+	key    ReportKey
+	report types.ClusterReport
+}
+
+// CheckReportsConsistency compares rows of rule_hit table with rules hit by at most limit
+// reports following the report identified by after. Inconsistent reports are recorded
+// in consistency_issue table and their rule hits are rewritten from the report if repair is set.
+// ErrConsistencyCheckInLegacyMode is returned in legacy write mode, which doesn't store rule hits.
+func (storage DBStorage) CheckReportsConsistency(
+	after ReportKey, limit int, repair bool,
+) (_ ConsistencyCheckBatch, err error) {
+	op := storage.startOperation("CheckReportsConsistency", maintenance)
+	defer op.finish(&err)
+
+	batch := ConsistencyCheckBatch{Last: after, Issues: make([]ConsistencyIssue, 0)}
+
+	if storage.writeMode == WriteModeLegacy {
+		return batch, ErrConsistencyCheckInLegacyMode
+	}
+
+	reports, err := storage.readReportsAfter(op.ctx, after, limit)
+	if err != nil {
+		return batch, err
+	}
+
+	for _, report := range reports {
+		issue, err := storage.checkReportConsistency(op.ctx, report, repair)
+		if err != nil {
+			return batch, err
+		}
+
+		batch.Checked++
+		batch.Last = report.key
+
+		if issue != nil {
+			batch.Issues = append(batch.Issues, *issue)
+		}
+	}
+
+	return batch, nil
+}
+
+// CountReportsWithNullTimestamps returns the number of reports missing the time of the last check
+// or of reporting, such reports were written by older versions or fixed manually.
+// Soft-deleted reports are not counted.
+func (storage DBStorage) CountReportsWithNullTimestamps() (count int, err error) {
+	op := storage.startOperation("CountReportsWithNullTimestamps", maintenance)
+	defer op.finish(&err)
+
+	err = storage.connection.QueryRowContext(
+		op.ctx,
+		"SELECT COUNT(*) FROM report WHERE (last_checked_at IS NULL OR reported_at IS NULL) AND deleted_at IS NULL",
+	).Scan(&count)
+
+	return count, err
+}
+
+// readReportsAfter reads at most limit reports following the report identified by after, soft-deleted
+// reports are skipped. All rows are read at once, so the connection is free for other queries of the check
+func (storage DBStorage) readReportsAfter(
+	ctx context.Context, after ReportKey, limit int,
+) ([]checkedReport, error) {
+	rows, err := storage.connection.QueryContext(ctx, `
+		SELECT org_id, cluster, report FROM report
+		WHERE (org_id > $1 OR (org_id = $1 AND cluster > $2)) AND deleted_at IS NULL
+		ORDER BY org_id, cluster
+		LIMIT $3`,
+		after.OrgID, after.ClusterName, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	var reports []checkedReport
+
+	for rows.Next() {
+		var report checkedReport
+
+		if err := rows.Scan(&report.key.OrgID, &report.key.ClusterName, &report.report); err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+// checkReportConsistency compares rule hits of the report with rule_hit rows of its cluster,
+// nil is returned for consistent report
+func (storage DBStorage) checkReportConsistency(
+	ctx context.Context, report checkedReport, repair bool,
+) (*ConsistencyIssue, error) {
+	decodedReport, err := storage.decodeReport(report.report)
+	if err != nil {
+		return storage.recordConsistencyIssue(ctx, report.key, fmt.Sprintf("report can't be decoded: %v", err), false)
+	}
+
+	var reportRules types.ReportRules
+	if err := json.Unmarshal([]byte(decodedReport), &reportRules); err != nil {
+		return storage.recordConsistencyIssue(ctx, report.key, fmt.Sprintf("report can't be parsed: %v", err), false)
+	}
+
+	expected, err := ruleHitsTemplateData(reportRules.HitRules)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := storage.readRuleHitsTemplateData(ctx, report.key)
+	if err != nil {
+		return nil, err
+	}
+
+	description := describeRuleHitsMismatch(expected, actual)
+	if len(description) == 0 {
+		return nil, nil
+	}
+
+	repaired := false
+
+	if repair {
+		tx, err := storage.connection.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		err = storage.updateRuleHits(ctx, tx, report.key.OrgID, report.key.ClusterName, reportRules.HitRules)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		repaired = true
+	}
+
+	return storage.recordConsistencyIssue(ctx, report.key, description, repaired)
+}
+
+// readRuleHitsTemplateData reads template data of rules hit by the cluster keyed by rule and error key
+func (storage DBStorage) readRuleHitsTemplateData(ctx context.Context, key ReportKey) (map[string]string, error) {
+	rows, err := storage.connection.QueryContext(
+		ctx,
+		"SELECT rule_fqdn, error_key, template_data FROM rule_hit WHERE org_id = $1 AND cluster = $2",
+		key.OrgID, key.ClusterName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	ruleHits := make(map[string]string)
+
+	for rows.Next() {
+		var ruleFQDN, errorKey, templateData string
+
+		if err := rows.Scan(&ruleFQDN, &errorKey, &templateData); err != nil {
+			return nil, err
+		}
+
+		ruleHits[ruleFQDN+"|"+errorKey] = templateData
+	}
+
+	return ruleHits, rows.Err()
+}
+
+// describeRuleHitsMismatch returns human readable differences between expected and actual
+// rule hits or empty string when they are the same
+func describeRuleHitsMismatch(expected, actual map[string]string) string {
+	var missing, unexpected, changed []string
+
+	for ruleHit, templateData := range expected {
+		actualTemplateData, found := actual[ruleHit]
+		switch {
+		case !found:
+			missing = append(missing, ruleHit)
+		case actualTemplateData != templateData:
+			changed = append(changed, ruleHit)
+		}
+	}
+
+	for ruleHit := range actual {
+		if _, found := expected[ruleHit]; !found {
+			unexpected = append(unexpected, ruleHit)
+		}
+	}
+
+	var differences []string
+
+	for _, difference := range []struct {
+		name     string
+		ruleHits []string
+	}{
+		{"missing rule hits", missing},
+		{"unexpected rule hits", unexpected},
+		{"rule hits with different template data", changed},
+	} {
+		if len(difference.ruleHits) > 0 {
+			sort.Strings(difference.ruleHits)
+			differences = append(differences, difference.name+": "+strings.Join(difference.ruleHits, ", "))
+		}
+	}
+
+	return strings.Join(differences, "; ")
+}
+
+// consistencyIssueUpsert writes the issue of the report, there is at most one issue per report
+var consistencyIssueUpsert = upsertStatement{
+	table:           "consistency_issue",
+	columns:         []string{"org_id", "cluster", "description", "repaired", "detected_at"},
+	conflictColumns: []string{"org_id", "cluster"},
+	updates:         []string{"description = $3", "repaired = $4", "detected_at = $5"},
+}
+
+// recordConsistencyIssue stores the issue of the report into consistency_issue table,
+// the previously detected issue of the same report is overwritten
+func (storage DBStorage) recordConsistencyIssue(
+	ctx context.Context, key ReportKey, description string, repaired bool,
+) (*ConsistencyIssue, error) {
+	query, ok := storage.dialect().upsert(consistencyIssueUpsert)
+	if !ok {
+		return nil, fmt.Errorf("writing consistency issues with DB %v is not supported", storage.dbDriverType)
+	}
+
+	issue := ConsistencyIssue{
+		OrgID:       key.OrgID,
+		ClusterName: key.ClusterName,
+		Description: description,
+		Repaired:    repaired,
+		DetectedAt:  time.Now(),
+	}
+
+	log.Warn().
+		Uint64("org_id", uint64(issue.OrgID)).
+		Str("cluster", string(issue.ClusterName)).
+		Bool("repaired", issue.Repaired).
+		Msgf("Inconsistent report: %v", issue.Description)
+
+	_, err := storage.connection.ExecContext(
+		ctx, query, issue.OrgID, issue.ClusterName, issue.Description, issue.Repaired, issue.DetectedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ConsistencyIssues.Inc()
+	if issue.Repaired {
+		metrics.ConsistencyIssuesRepaired.Inc()
+	}
+
+	return &issue, nil
+}
+
+// ListConsistencyIssues returns all recorded consistency issues, the most recent ones go first
+func (storage DBStorage) ListConsistencyIssues() (_ []ConsistencyIssue, err error) {
+	op := storage.startOperation("ListConsistencyIssues", fastRead)
+	defer op.finish(&err)
+
+	issues := make([]ConsistencyIssue, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT org_id, cluster, description, repaired, detected_at FROM consistency_issue
+		ORDER BY detected_at DESC, org_id, cluster`,
+	)
+	if err != nil {
+		return issues, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var issue ConsistencyIssue
+
+		err = rows.Scan(
+			&issue.OrgID,
+			&issue.ClusterName,
+			&issue.Description,
+			&issue.Repaired,
+			scanTimestamp(&issue.DetectedAt),
+		)
+		if err != nil {
+			return issues, err
+		}
+
+		issues = append(issues, issue)
+	}
+
+	return issues, rows.Err()
+}
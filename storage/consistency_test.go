@@ -0,0 +1,251 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// clusters of the consistency check tests in the order they are checked
+var consistencyCheckClusters = []types.ClusterName{
+	"11111111-0dd8-49cd-9d4d-f6646df3a5bc",
+	"22222222-0dd8-49cd-9d4d-f6646df3a5bc",
+	"33333333-0dd8-49cd-9d4d-f6646df3a5bc",
+}
+
+// mustGetStorageWithConsistencyCheckReports returns storage with Report3Rules written for all
+// consistencyCheckClusters and its connection for seeding of inconsistencies
+func mustGetStorageWithConsistencyCheckReports(t *testing.T) (storage.Storage, *sql.DB) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+
+	for _, clusterName := range consistencyCheckClusters {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, clusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		))
+	}
+
+	return mockStorage, storage.GetConnection(mockStorage.(*storage.DBStorage))
+}
+
+// mustSeedRuleHitsDrift makes rule hits of each of consistencyCheckClusters inconsistent in a different way
+func mustSeedRuleHitsDrift(t *testing.T, connection *sql.DB) {
+	_, err := connection.Exec(
+		"DELETE FROM rule_hit WHERE cluster = $1 AND rule_fqdn = $2",
+		consistencyCheckClusters[0], string(testdata.Rule1ID)+".report",
+	)
+	helpers.FailOnError(t, err)
+
+	_, err = connection.Exec(
+		"INSERT INTO rule_hit(org_id, cluster, rule_fqdn, error_key, template_data) VALUES ($1, $2, $3, $4, $5)",
+		testdata.OrgID, consistencyCheckClusters[1], "test.rule4.report", "ek4", "null",
+	)
+	helpers.FailOnError(t, err)
+
+	_, err = connection.Exec(
+		"UPDATE rule_hit SET template_data = $1 WHERE cluster = $2 AND rule_fqdn = $3",
+		`{"changed": true}`, consistencyCheckClusters[2], string(testdata.Rule3ID)+".report",
+	)
+	helpers.FailOnError(t, err)
+}
+
+// expectedDriftDescriptions are descriptions of issues seeded by mustSeedRuleHitsDrift
+var expectedDriftDescriptions = []string{
+	"missing rule hits: test.rule1.report|ek1",
+	"unexpected rule hits: test.rule4.report|ek4",
+	"rule hits with different template data: test.rule3.report|ek3",
+}
+
+func assertConsistencyIssues(t *testing.T, issues []storage.ConsistencyIssue, repaired bool) {
+	assert.Len(t, issues, len(expectedDriftDescriptions))
+
+	for i, issue := range issues {
+		assert.Equal(t, testdata.OrgID, issue.OrgID)
+		assert.Equal(t, consistencyCheckClusters[i], issue.ClusterName)
+		assert.Equal(t, expectedDriftDescriptions[i], issue.Description)
+		assert.Equal(t, repaired, issue.Repaired)
+	}
+}
+
+func TestDBStorageCheckReportsConsistencyNoIssues(t *testing.T) {
+	mockStorage, _ := mustGetStorageWithConsistencyCheckReports(t)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	summary, err := storage.CheckConsistency(context.Background(), mockStorage, 2, false)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, len(consistencyCheckClusters), summary.Checked)
+	assert.Empty(t, summary.Issues)
+
+	issues, err := mockStorage.ListConsistencyIssues()
+	helpers.FailOnError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestDBStorageCheckReportsConsistencyDetectsDrift(t *testing.T) {
+	mockStorage, connection := mustGetStorageWithConsistencyCheckReports(t)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustSeedRuleHitsDrift(t, connection)
+
+	summary, err := storage.CheckConsistency(context.Background(), mockStorage, 2, false)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, len(consistencyCheckClusters), summary.Checked)
+	assertConsistencyIssues(t, summary.Issues, false)
+
+	// the issues are recorded, but nothing is repaired
+	_, err = storage.CheckConsistency(context.Background(), mockStorage, 2, false)
+	helpers.FailOnError(t, err)
+
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, consistencyCheckClusters[0])
+	helpers.FailOnError(t, err)
+	assert.Len(t, ruleHits, 2)
+
+	issues, err := mockStorage.ListConsistencyIssues()
+	helpers.FailOnError(t, err)
+	assert.Len(t, issues, len(consistencyCheckClusters))
+}
+
+func TestDBStorageCheckReportsConsistencyRepair(t *testing.T) {
+	mockStorage, connection := mustGetStorageWithConsistencyCheckReports(t)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustSeedRuleHitsDrift(t, connection)
+
+	summary, err := storage.CheckConsistency(context.Background(), mockStorage, 2, true)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, len(consistencyCheckClusters), summary.Checked)
+	assertConsistencyIssues(t, summary.Issues, true)
+
+	for _, clusterName := range consistencyCheckClusters {
+		ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, clusterName)
+		helpers.FailOnError(t, err)
+		assert.Len(t, ruleHits, 3)
+	}
+
+	// repaired reports are consistent
+	summary, err = storage.CheckConsistency(context.Background(), mockStorage, 2, false)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, summary.Issues)
+
+	issues, err := mockStorage.ListConsistencyIssues()
+	helpers.FailOnError(t, err)
+	assert.Len(t, issues, len(consistencyCheckClusters))
+	for _, issue := range issues {
+		assert.True(t, issue.Repaired)
+	}
+}
+
+func TestDBStorageCheckReportsConsistencyBatches(t *testing.T) {
+	mockStorage, _ := mustGetStorageWithConsistencyCheckReports(t)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	batch, err := mockStorage.CheckReportsConsistency(storage.ReportKey{}, 2, false)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 2, batch.Checked)
+	assert.Equal(t, storage.ReportKey{OrgID: testdata.OrgID, ClusterName: consistencyCheckClusters[1]}, batch.Last)
+
+	batch, err = mockStorage.CheckReportsConsistency(batch.Last, 2, false)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, batch.Checked)
+	assert.Equal(t, storage.ReportKey{OrgID: testdata.OrgID, ClusterName: consistencyCheckClusters[2]}, batch.Last)
+
+	batch, err = mockStorage.CheckReportsConsistency(batch.Last, 2, false)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, batch.Checked)
+}
+
+func TestDBStorageCheckReportsConsistencyUnparsableReport(t *testing.T) {
+	mockStorage, connection := mustGetStorageWithConsistencyCheckReports(t)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := connection.Exec("UPDATE report SET report = 'not a report' WHERE cluster = $1", consistencyCheckClusters[1])
+	helpers.FailOnError(t, err)
+
+	summary, err := storage.CheckConsistency(context.Background(), mockStorage, 2, true)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, len(consistencyCheckClusters), summary.Checked)
+	assert.Len(t, summary.Issues, 1)
+	assert.Equal(t, consistencyCheckClusters[1], summary.Issues[0].ClusterName)
+	assert.Contains(t, summary.Issues[0].Description, "report can't be parsed")
+	// rule hits can't be repaired from unparsable report
+	assert.False(t, summary.Issues[0].Repaired)
+}
+
+// TestDBStorageCheckReportsConsistencyLegacyWriteMode checks that reports are not checked
+// and rule hits are not written by the repair in legacy write mode, which doesn't store them
+func TestDBStorageCheckReportsConsistencyLegacyWriteMode(t *testing.T) {
+	mockStorage := mustGetStorageWithReadWriteModes(t, storage.WriteModeLegacy, storage.ReadSourceReport, 0)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	summary, err := storage.CheckConsistency(context.Background(), mockStorage, 2, true)
+	assert.Equal(t, storage.ErrConsistencyCheckInLegacyMode, err)
+	assert.Equal(t, 0, summary.Checked)
+	assert.Empty(t, summary.Issues)
+
+	assert.Equal(t, 0, countRuleHitRows(t, mockStorage))
+
+	issues, err := mockStorage.ListConsistencyIssues()
+	helpers.FailOnError(t, err)
+	assert.Empty(t, issues)
+}
+
+// cancellingChecker cancels the context of the consistency check after the first batch
+type cancellingChecker struct {
+	storage.ConsistencyChecker
+	cancel context.CancelFunc
+}
+
+func (checker cancellingChecker) CheckReportsConsistency(
+	after storage.ReportKey, limit int, repair bool,
+) (storage.ConsistencyCheckBatch, error) {
+	defer checker.cancel()
+	return checker.ConsistencyChecker.CheckReportsConsistency(after, limit, repair)
+}
+
+func TestCheckConsistencyInterrupted(t *testing.T) {
+	mockStorage, _ := mustGetStorageWithConsistencyCheckReports(t)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	summary, err := storage.CheckConsistency(ctx, cancellingChecker{mockStorage, cancel}, 1, false)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, summary.Checked)
+}
+
+func TestDBStorageCheckReportsConsistencyDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := storage.CheckConsistency(context.Background(), mockStorage, 2, false)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, err = mockStorage.ListConsistencyIssues()
+	assert.EqualError(t, err, "sql: database is closed")
+}
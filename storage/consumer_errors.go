@@ -0,0 +1,219 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// MaxProcessingErrors is the maximum number of processing errors of the cluster
+// returned by GetProcessingErrorsForCluster
+const MaxProcessingErrors = 10
+
+// MaxConsumerErrorMessageLength is the maximum length in bytes of the message stored
+// with the failure of its processing, longer messages are truncated
+const MaxConsumerErrorMessageLength = 16 * 1024
+
+// ConsumerError describes failure of processing of a message consumed from Kafka.
+// OrgID and ClusterName are zero when they couldn't be read from the message.
+// Category is sanitized category of the error which can be shown to users,
+// Error is the original error which is kept only for debugging purposes.
+// Key and Message are the key and the value of the consumed message.
+type ConsumerError struct {
+	Topic       string            `json:"topic"`
+	Partition   int32             `json:"partition"`
+	Offset      types.KafkaOffset `json:"offset"`
+	OrgID       types.OrgID       `json:"org_id"`
+	ClusterName types.ClusterName `json:"cluster"`
+	ConsumedAt  time.Time         `json:"consumed_at"`
+	Category    string            `json:"category"`
+	Error       string            `json:"error"`
+	Key         string            `json:"key"`
+	Message     string            `json:"message"`
+}
+
+// truncateConsumedMessage truncates the message to MaxConsumerErrorMessageLength bytes,
+// invalid UTF-8 sequences (including the rune split by the truncation) are dropped,
+// so the message can be stored in VARCHAR column
+func truncateConsumedMessage(message string) string {
+	if len(message) > MaxConsumerErrorMessageLength {
+		message = message[:MaxConsumerErrorMessageLength]
+	}
+
+	return strings.ToValidUTF8(message, "")
+}
+
+// consumerErrorUpsert writes the failure of processing of the message
+var consumerErrorUpsert = upsertStatement{
+	table: "consumer_error",
+	columns: []string{
+		"topic", "partition", "topic_offset", "org_id", "cluster", "consumed_at", "category", "error",
+		"message_key", "message",
+	},
+	conflictColumns: []string{"topic", "partition", "topic_offset"},
+	updates: []string{
+		"org_id = $4", "cluster = $5", "consumed_at = $6", "category = $7", "error = $8",
+		"message_key = $9", "message = $10",
+	},
+}
+
+// WriteConsumerError stores the failure of processing of the consumed message,
+// the failure of the message processed repeatedly overwrites the previous one.
+// The message is truncated to MaxConsumerErrorMessageLength bytes.
+func (storage DBStorage) WriteConsumerError(consumerError ConsumerError) (err error) {
+	op := storage.startOperation("WriteConsumerError", write).forCluster(consumerError.ClusterName)
+	defer op.finish(&err)
+
+	query, ok := storage.dialect().upsert(consumerErrorUpsert)
+	if !ok {
+		return fmt.Errorf("writing consumer errors with DB %v is not supported", storage.dbDriverType)
+	}
+
+	orgID := nullableOrgID(consumerError.OrgID)
+	clusterName := sql.NullString{String: string(consumerError.ClusterName), Valid: consumerError.ClusterName != ""}
+
+	err = storage.withRetries(op.ctx, "WriteConsumerError", func() error {
+		_, err := storage.connection.ExecContext(
+			op.ctx, query,
+			consumerError.Topic, consumerError.Partition, consumerError.Offset, orgID, clusterName,
+			consumerError.ConsumedAt, consumerError.Category, consumerError.Error,
+			truncateConsumedMessage(consumerError.Key), truncateConsumedMessage(consumerError.Message),
+		)
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("WriteConsumerError")
+		return err
+	}
+
+	return nil
+}
+
+// GetProcessingErrorsForCluster returns at most limit of the most recent failures of processing
+// of messages consumed for the cluster, the most recent failure goes first. Only sanitized
+// categories of the errors are returned, the original errors never leave the storage.
+func (storage DBStorage) GetProcessingErrorsForCluster(
+	clusterName types.ClusterName, limit int,
+) (_ []types.ProcessingError, err error) {
+	op := storage.startOperation("GetProcessingErrorsForCluster", fastRead).forCluster(clusterName)
+	defer op.finish(&err)
+
+	processingErrors := make([]types.ProcessingError, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT category, consumed_at FROM consumer_error
+		 WHERE cluster = $1
+		 ORDER BY consumed_at DESC
+		 LIMIT $2`, clusterName, limit)
+	if err != nil {
+		return processingErrors, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			category   string
+			consumedAt time.Time
+		)
+
+		if err := rows.Scan(&category, scanTimestamp(&consumedAt)); err != nil {
+			return processingErrors, err
+		}
+
+		processingErrors = append(processingErrors, types.ProcessingError{
+			Category: category,
+			FailedAt: types.NewTimestamp(consumedAt),
+		})
+	}
+
+	return processingErrors, rows.Err()
+}
+
+// ListConsumerErrors returns at most limit of the most recent failures of processing
+// of consumed messages including the original errors and the messages, the most recent
+// failure goes first. It's meant for debugging purposes only.
+func (storage DBStorage) ListConsumerErrors(limit int) (_ []ConsumerError, err error) {
+	op := storage.startOperation("ListConsumerErrors", fastRead)
+	defer op.finish(&err)
+
+	consumerErrors := make([]ConsumerError, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT topic, partition, topic_offset, org_id, cluster, consumed_at, category, error,
+		       message_key, message
+		  FROM consumer_error
+		 ORDER BY consumed_at DESC, topic, partition, topic_offset DESC
+		 LIMIT $1`, limit)
+	if err != nil {
+		return consumerErrors, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			consumerError ConsumerError
+			orgID         sql.NullInt64
+			clusterName   sql.NullString
+			key           sql.NullString
+			message       sql.NullString
+		)
+
+		err := rows.Scan(
+			&consumerError.Topic, &consumerError.Partition, &consumerError.Offset, &orgID, &clusterName,
+			scanTimestamp(&consumerError.ConsumedAt), &consumerError.Category, &consumerError.Error,
+			&key, &message,
+		)
+		if err != nil {
+			return consumerErrors, err
+		}
+
+		consumerError.OrgID = types.OrgID(orgID.Int64)
+		consumerError.ClusterName = types.ClusterName(clusterName.String)
+		consumerError.Key = key.String
+		consumerError.Message = message.String
+
+		consumerErrors = append(consumerErrors, consumerError)
+	}
+
+	return consumerErrors, rows.Err()
+}
+
+// CleanupConsumerErrors deletes failures of processing of messages consumed longer than
+// olderThan ago and returns number of deleted failures
+func (storage DBStorage) CleanupConsumerErrors(olderThan time.Duration) (_ int, err error) {
+	op := storage.startOperation("CleanupConsumerErrors", maintenance)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx, "DELETE FROM consumer_error WHERE consumed_at < $1", time.Now().Add(-olderThan),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(deleted), nil
+}
@@ -0,0 +1,248 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// consumerErrorAt returns failure of processing of the message for the cluster consumed at the time
+func consumerErrorAt(
+	offset types.KafkaOffset, clusterName types.ClusterName, consumedAt time.Time, category string,
+) storage.ConsumerError {
+	return storage.ConsumerError{
+		Topic:       "topic",
+		Partition:   0,
+		Offset:      offset,
+		OrgID:       testdata.OrgID,
+		ClusterName: clusterName,
+		ConsumedAt:  consumedAt,
+		Category:    category,
+		Error:       "internal details of error",
+	}
+}
+
+func TestDBStorageGetProcessingErrorsForCluster(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	const otherClusterName = types.ClusterName("9b4c1a3e-7c6d-4f0e-8a2b-3d5e6f7a8b9c")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, consumerError := range []storage.ConsumerError{
+		consumerErrorAt(1, testdata.ClusterName, now.Add(-2*time.Hour), "invalid_report"),
+		consumerErrorAt(2, testdata.ClusterName, now.Add(-time.Hour), "invalid_timestamp"),
+		consumerErrorAt(3, otherClusterName, now, "invalid_report"),
+		// cluster of the message is unknown
+		consumerErrorAt(4, "", now, "malformed_message"),
+	} {
+		helpers.FailOnError(t, mockStorage.WriteConsumerError(consumerError))
+	}
+
+	processingErrors, err := mockStorage.GetProcessingErrorsForCluster(testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ProcessingError{
+		{Category: "invalid_timestamp", FailedAt: types.NewTimestamp(now.Add(-time.Hour))},
+		{Category: "invalid_report", FailedAt: types.NewTimestamp(now.Add(-2 * time.Hour))},
+	}, processingErrors)
+
+	processingErrors, err = mockStorage.GetProcessingErrorsForCluster(testdata.ClusterName, 1)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ProcessingError{
+		{Category: "invalid_timestamp", FailedAt: types.NewTimestamp(now.Add(-time.Hour))},
+	}, processingErrors)
+}
+
+func TestDBStorageGetProcessingErrorsForClusterEmpty(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	processingErrors, err := mockStorage.GetProcessingErrorsForCluster(testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, processingErrors)
+}
+
+// TestDBStorageWriteConsumerErrorSameMessage checks that failure of the message processed
+// repeatedly overwrites the previous failure
+func TestDBStorageWriteConsumerErrorSameMessage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	helpers.FailOnError(t, mockStorage.WriteConsumerError(
+		consumerErrorAt(1, testdata.ClusterName, now.Add(-time.Hour), "internal_error"),
+	))
+	helpers.FailOnError(t, mockStorage.WriteConsumerError(
+		consumerErrorAt(1, testdata.ClusterName, now, "invalid_report"),
+	))
+
+	processingErrors, err := mockStorage.GetProcessingErrorsForCluster(testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ProcessingError{
+		{Category: "invalid_report", FailedAt: types.NewTimestamp(now)},
+	}, processingErrors)
+}
+
+func TestDBStorageDeleteReportsForClusterDeletesProcessingErrors(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.FailOnError(t, mockStorage.WriteConsumerError(
+		consumerErrorAt(1, testdata.ClusterName, time.Now(), "invalid_report"),
+	))
+
+	_, err := mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	processingErrors, err := mockStorage.GetProcessingErrorsForCluster(testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, processingErrors)
+}
+
+// TestDBStorageCleanupOldReportsDeletesProcessingErrors checks that only failures consumed
+// before the cutoff time are deleted by the cleanup
+func TestDBStorageCleanupOldReportsDeletesProcessingErrors(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	helpers.FailOnError(t, mockStorage.WriteConsumerError(
+		consumerErrorAt(1, testdata.ClusterName, now.Add(-48*time.Hour), "invalid_report"),
+	))
+	helpers.FailOnError(t, mockStorage.WriteConsumerError(
+		consumerErrorAt(2, testdata.ClusterName, now, "invalid_timestamp"),
+	))
+
+	_, err := mockStorage.CleanupOldReports(24 * time.Hour)
+	helpers.FailOnError(t, err)
+
+	processingErrors, err := mockStorage.GetProcessingErrorsForCluster(testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ProcessingError{
+		{Category: "invalid_timestamp", FailedAt: types.NewTimestamp(now)},
+	}, processingErrors)
+}
+
+// TestDBStorageListConsumerErrors checks that the most recent failures go first
+// and that at most limit failures are returned
+func TestDBStorageListConsumerErrors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		now := time.Now().UTC().Truncate(time.Second)
+
+		for _, consumerError := range []storage.ConsumerError{
+			consumerErrorAt(1, testdata.ClusterName, now.Add(-2*time.Hour), "invalid_report"),
+			consumerErrorAt(2, "", now, "malformed_message"),
+			consumerErrorAt(3, testdata.ClusterName, now.Add(-time.Hour), "invalid_timestamp"),
+		} {
+			consumerError.Key = "key"
+			consumerError.Message = `{"OrgID": "not a number"}`
+			helpers.FailOnError(t, mockStorage.WriteConsumerError(consumerError))
+		}
+
+		consumerErrors, err := mockStorage.ListConsumerErrors(10)
+		helpers.FailOnError(t, err)
+		assert.Len(t, consumerErrors, 3)
+
+		var offsets []types.KafkaOffset
+		for _, consumerError := range consumerErrors {
+			offsets = append(offsets, consumerError.Offset)
+		}
+		assert.Equal(t, []types.KafkaOffset{2, 3, 1}, offsets)
+
+		assert.Equal(t, storage.ConsumerError{
+			Topic:       "topic",
+			Partition:   0,
+			Offset:      2,
+			ConsumedAt:  now,
+			Category:    "malformed_message",
+			Error:       "internal details of error",
+			Key:         "key",
+			Message:     `{"OrgID": "not a number"}`,
+			OrgID:       testdata.OrgID,
+			ClusterName: "",
+		}, consumerErrors[0])
+
+		consumerErrors, err = mockStorage.ListConsumerErrors(1)
+		helpers.FailOnError(t, err)
+		assert.Len(t, consumerErrors, 1)
+		assert.Equal(t, types.KafkaOffset(2), consumerErrors[0].Offset)
+	})
+}
+
+// TestDBStorageWriteConsumerErrorTruncatesMessage checks that huge messages are truncated
+// and that the rune split by the truncation is dropped
+func TestDBStorageWriteConsumerErrorTruncatesMessage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		consumerError := consumerErrorAt(1, testdata.ClusterName, time.Now(), "malformed_message")
+		// the two bytes long rune is split by the truncation
+		consumerError.Message = strings.Repeat("x", storage.MaxConsumerErrorMessageLength-1) + "é" +
+			strings.Repeat("x", 1024*1024)
+		helpers.FailOnError(t, mockStorage.WriteConsumerError(consumerError))
+
+		consumerErrors, err := mockStorage.ListConsumerErrors(10)
+		helpers.FailOnError(t, err)
+		assert.Len(t, consumerErrors, 1)
+		assert.Equal(t, strings.Repeat("x", storage.MaxConsumerErrorMessageLength-1), consumerErrors[0].Message)
+	})
+}
+
+// TestDBStorageCleanupConsumerErrors checks that only failures consumed before
+// the retention period are deleted
+func TestDBStorageCleanupConsumerErrors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		now := time.Now().UTC().Truncate(time.Second)
+
+		helpers.FailOnError(t, mockStorage.WriteConsumerError(
+			consumerErrorAt(1, testdata.ClusterName, now.Add(-48*time.Hour), "invalid_report"),
+		))
+		helpers.FailOnError(t, mockStorage.WriteConsumerError(
+			consumerErrorAt(2, testdata.ClusterName, now, "invalid_timestamp"),
+		))
+
+		deleted, err := mockStorage.CleanupConsumerErrors(24 * time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		consumerErrors, err := mockStorage.ListConsumerErrors(10)
+		helpers.FailOnError(t, err)
+		assert.Len(t, consumerErrors, 1)
+		assert.Equal(t, types.KafkaOffset(2), consumerErrors[0].Offset)
+	})
+}
+
+func TestDBStorageListConsumerErrorsClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	// we need to close storage right now
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.ListConsumerErrors(10)
+	expectErrorClosedStorage(t, err)
+
+	_, err = mockStorage.CleanupConsumerErrors(time.Hour)
+	expectErrorClosedStorage(t, err)
+}
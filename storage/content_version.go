@@ -0,0 +1,136 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/content"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// contentVersionUpsert writes the rule content version, loading the same content again
+// makes its version the most recent one
+var contentVersionUpsert = upsertStatement{
+	table:           "content_version",
+	columns:         []string{"checksum", "rule_checksums", "loaded_at"},
+	conflictColumns: []string{"checksum"},
+	updates:         []string{"loaded_at = $3"},
+	replaceable:     true,
+}
+
+// writeContentVersion stores checksums of rules of the loaded rule content and removes
+// the oldest versions exceeding the configured content history depth
+func (storage DBStorage) writeContentVersion(
+	ctx context.Context, tx *sql.Tx, contentDir content.RuleContentDirectory,
+) error {
+	ruleChecksums, err := content.RuleChecksums(contentDir)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := content.Checksum(ruleChecksums)
+	if err != nil {
+		return err
+	}
+
+	ruleChecksumsJSON, err := json.Marshal(ruleChecksums)
+	if err != nil {
+		return err
+	}
+
+	insertQuery, ok := storage.dialect().upsert(contentVersionUpsert)
+	if !ok {
+		return fmt.Errorf("writing content version with DB %v is not supported", storage.dbDriverType)
+	}
+
+	_, err = tx.ExecContext(ctx, insertQuery, checksum, string(ruleChecksumsJSON), time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM content_version
+		 WHERE checksum NOT IN (
+			SELECT checksum FROM content_version
+			 ORDER BY loaded_at DESC
+			 LIMIT $1
+		 )`, storage.contentHistoryDepth)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("checksum", checksum).Int("rules", len(ruleChecksums)).Msg("Rule content version stored")
+
+	return nil
+}
+
+// readRuleChecksums reads checksums of rules of the rule content version with the checksum
+func readRuleChecksums(ctx context.Context, tx *sql.Tx, checksum string) (map[types.RuleID]string, error) {
+	var ruleChecksumsJSON string
+
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT rule_checksums FROM content_version WHERE checksum = $1", checksum,
+	).Scan(&ruleChecksumsJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, newItemNotFoundError(ItemKindContentVersion, checksum).withCause(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleChecksums map[types.RuleID]string
+	if err := json.Unmarshal([]byte(ruleChecksumsJSON), &ruleChecksums); err != nil {
+		return nil, err
+	}
+
+	return ruleChecksums, nil
+}
+
+// GetContentChanges returns rules added, removed or modified between two versions
+// of rule content identified by their checksums. ItemNotFoundError is returned
+// when any of the versions is not kept in the content history. Both versions are read
+// in one read-only transaction, so a concurrent load of rule content trimming the history
+// can't remove one of them between the reads.
+func (storage DBStorage) GetContentChanges(fromChecksum, toChecksum string) (_ types.ContentChanges, err error) {
+	op := storage.startOperation("GetContentChanges", fastRead)
+	defer op.finish(&err)
+
+	var from, to map[types.RuleID]string
+
+	err = storage.readOnlyTx(op.ctx, func(tx *sql.Tx) error {
+		if from, err = readRuleChecksums(op.ctx, tx, fromChecksum); err != nil {
+			return err
+		}
+
+		to, err = readRuleChecksums(op.ctx, tx, toChecksum)
+		return err
+	})
+	if err != nil {
+		return types.ContentChanges{}, err
+	}
+
+	return content.DiffRuleChecksums(from, to), nil
+}
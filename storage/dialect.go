@@ -0,0 +1,195 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sqlDialect builds the parts of SQL statements which differ between databases
+// or which are tedious to write by hand (placeholders, upserts, IN lists, LIMIT and OFFSET),
+// so storage methods can use a single query template for all supported databases
+type sqlDialect struct {
+	driverType   DBDriver
+	capabilities Capabilities
+}
+
+// dialect returns SQL dialect of the database of the storage
+func (storage DBStorage) dialect() sqlDialect {
+	return sqlDialect{driverType: storage.dbDriverType, capabilities: storage.capabilities}
+}
+
+// placeholder returns placeholder of the n-th argument of the query, numbering starts at 1.
+// Numbered placeholders are accepted by both PostgreSQL and SQLite, so the same argument
+// can be referenced more than once in a single query.
+func (dialect sqlDialect) placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// placeholderList returns comma separated placeholders of count arguments starting with the first one
+func (dialect sqlDialect) placeholderList(first, count int) string {
+	placeholders := make([]string, count)
+	for i := range placeholders {
+		placeholders[i] = dialect.placeholder(first + i)
+	}
+
+	return strings.Join(placeholders, ", ")
+}
+
+// maxPlaceholders returns the maximal number of arguments of a single query
+func (dialect sqlDialect) maxPlaceholders() int {
+	switch dialect.driverType {
+	case DBDriverPostgres:
+		return 65535
+	default:
+		// the default limit of SQLite older than 3.32 is the lowest one
+		return 999
+	}
+}
+
+// tablesQuery returns query listing names of tables of the database (without internal tables
+// of the database itself), false is returned when tables of the database can't be listed
+func (dialect sqlDialect) tablesQuery() (string, bool) {
+	switch dialect.driverType {
+	case DBDriverPostgres:
+		return "SELECT tablename FROM pg_tables WHERE schemaname = current_schema()", true
+	case DBDriverSQLite3:
+		return "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'", true
+	default:
+		return "", false
+	}
+}
+
+// upsertStatement describes INSERT of a row which updates the already stored row
+// with the same key instead of failing
+type upsertStatement struct {
+	table   string
+	columns []string
+	// values are expressions of the inserted values, placeholders of all columns are used when empty
+	values []string
+	// conflictColumns are columns of the key identifying the already stored row
+	conflictColumns []string
+	// updates are assignments to the stored row, the statement fails on conflict when there are none
+	updates []string
+	// where limits which stored rows are updated
+	where string
+	// replaceable is set when the stored row can be replaced by the inserted one as a whole,
+	// INSERT OR REPLACE is used in that case when the database supports it
+	replaceable bool
+}
+
+// upsert builds the upsert statement, false is returned when the database doesn't support upserts
+func (dialect sqlDialect) upsert(statement upsertStatement) (string, bool) {
+	values := strings.Join(statement.values, ", ")
+	if len(statement.values) == 0 {
+		values = dialect.placeholderList(1, len(statement.columns))
+	}
+
+	return dialect.upsertValues(statement, "("+values+")")
+}
+
+// upsertRows builds the upsert statement inserting count rows at once, placeholders of each row
+// follow the ones of the previous row. Expressions of the inserted values are not supported,
+// false is returned when the database doesn't support upserts
+func (dialect sqlDialect) upsertRows(statement upsertStatement, count int) (string, bool) {
+	columns := len(statement.columns)
+
+	rows := make([]string, count)
+	for i := range rows {
+		rows[i] = "(" + dialect.placeholderList(i*columns+1, columns) + ")"
+	}
+
+	return dialect.upsertValues(statement, strings.Join(rows, ", "))
+}
+
+// upsertValues builds the upsert statement inserting the parenthesized rows of values
+func (dialect sqlDialect) upsertValues(statement upsertStatement, rows string) (string, bool) {
+	insert := fmt.Sprintf("INTO %v(%v) VALUES %v", statement.table, strings.Join(statement.columns, ", "), rows)
+
+	switch {
+	case statement.replaceable && dialect.capabilities.InsertOrReplace:
+		return "INSERT OR REPLACE " + insert, true
+	case !dialect.capabilities.Upsert:
+		return "", false
+	}
+
+	query := "INSERT " + insert
+	if len(statement.updates) > 0 {
+		query += fmt.Sprintf(
+			" ON CONFLICT (%v) DO UPDATE SET %v",
+			strings.Join(statement.conflictColumns, ", "), strings.Join(statement.updates, ", "),
+		)
+		if len(statement.where) > 0 {
+			query += " WHERE " + statement.where
+		}
+	}
+
+	return query, true
+}
+
+// queryArgs collects arguments of a query built piece by piece,
+// each added argument gets the next placeholder
+type queryArgs struct {
+	dialect sqlDialect
+	values  []interface{}
+}
+
+// newQueryArgs creates arguments of the query, the initial values get placeholders from the first one
+func (dialect sqlDialect) newQueryArgs(values ...interface{}) *queryArgs {
+	return &queryArgs{dialect: dialect, values: values}
+}
+
+// add adds the argument and returns its placeholder
+func (args *queryArgs) add(value interface{}) string {
+	args.values = append(args.values, value)
+	return args.dialect.placeholder(len(args.values))
+}
+
+// addList adds all the values and returns parenthesized list of their placeholders for IN clause
+func (args *queryArgs) addList(values ...interface{}) string {
+	first := len(args.values) + 1
+	args.values = append(args.values, values...)
+
+	return "(" + args.dialect.placeholderList(first, len(values)) + ")"
+}
+
+// limitOffset adds arguments of LIMIT and OFFSET clauses and returns the clauses, zero limit
+// or offset means no limit or no offset. False is returned when the offset can't be used
+// without limit in the database.
+func (args *queryArgs) limitOffset(limit, offset int) (string, bool) {
+	var clauses string
+
+	switch {
+	case limit > 0:
+		clauses += " LIMIT " + args.add(limit)
+	case offset > 0:
+		// both databases require LIMIT clause together with OFFSET
+		switch args.dialect.driverType {
+		case DBDriverSQLite3:
+			clauses += " LIMIT -1"
+		case DBDriverPostgres:
+			clauses += " LIMIT ALL"
+		default:
+			return "", false
+		}
+	}
+
+	if offset > 0 {
+		clauses += " OFFSET " + args.add(offset)
+	}
+
+	return clauses, true
+}
@@ -0,0 +1,286 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+)
+
+var (
+	sqlWhitespace            = regexp.MustCompile(`\s+`)
+	sqlWhitespaceAroundParen = regexp.MustCompile(` ?([()]) ?`)
+)
+
+// normalizeSQL removes differences in formatting of SQL statements which don't change their meaning
+func normalizeSQL(query string) string {
+	query = sqlWhitespace.ReplaceAllString(strings.TrimSpace(query), " ")
+	return sqlWhitespaceAroundParen.ReplaceAllString(query, "$1")
+}
+
+func assertSameSQL(t *testing.T, expected, got string) {
+	assert.Equal(t, normalizeSQL(expected), normalizeSQL(got))
+}
+
+// TestDialectUpserts checks that the upserts built by the dialect are the same
+// as the statements written by hand for each database before
+func TestDialectUpserts(t *testing.T) {
+	for _, testCase := range []struct {
+		name      string
+		statement storage.UpsertStatement
+		sqlite    string
+		postgres  string
+	}{
+		{
+			name:      "consumer error",
+			statement: storage.ConsumerErrorUpsert,
+			sqlite: `
+				INSERT INTO consumer_error(
+					topic, partition, topic_offset, org_id, cluster, consumed_at, category, error,
+					message_key, message
+				)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+				ON CONFLICT (topic, partition, topic_offset)
+				DO UPDATE SET org_id = $4, cluster = $5, consumed_at = $6, category = $7, error = $8,
+					message_key = $9, message = $10`,
+		},
+		{
+			name:      "consistency issue",
+			statement: storage.ConsistencyIssueUpsert,
+			sqlite: `
+				INSERT INTO consistency_issue(org_id, cluster, description, repaired, detected_at)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (org_id, cluster)
+				DO UPDATE SET description = $3, repaired = $4, detected_at = $5`,
+		},
+		{
+			name:      "content version",
+			statement: storage.ContentVersionUpsert,
+			sqlite: `INSERT OR REPLACE INTO content_version(checksum, rule_checksums, loaded_at)
+				VALUES ($1, $2, $3)`,
+			postgres: `INSERT INTO content_version(checksum, rule_checksums, loaded_at)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (checksum)
+				DO UPDATE SET loaded_at = $3`,
+		},
+		{
+			name:      "report history",
+			statement: storage.ReportHistoryUpsert,
+			sqlite: `INSERT OR REPLACE INTO report_history(org_id, cluster, report, last_checked_at)
+				VALUES ($1, $2, $3, $4)`,
+			postgres: `INSERT INTO report_history(org_id, cluster, report, last_checked_at)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (org_id, cluster, last_checked_at)
+				DO UPDATE SET report = $3`,
+		},
+		{
+			name:      "report",
+			statement: storage.ReportUpsert,
+			sqlite: `INSERT INTO report(
+					org_id, cluster, report, reported_at, last_checked_at, kafka_offset, report_checksum
+				) VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (org_id, cluster)
+				DO UPDATE SET report = excluded.report, reported_at = excluded.reported_at,
+					last_checked_at = excluded.last_checked_at, kafka_offset = excluded.kafka_offset,
+					report_checksum = excluded.report_checksum, deleted_at = NULL
+				WHERE report.last_checked_at IS NULL OR report.last_checked_at <= excluded.last_checked_at`,
+		},
+		{
+			name:      "newer report",
+			statement: storage.NewerReportUpsert,
+			sqlite: `INSERT INTO report(
+					org_id, cluster, report, reported_at, last_checked_at, kafka_offset, report_checksum
+				) VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (org_id, cluster)
+				DO UPDATE SET report = excluded.report, reported_at = excluded.reported_at,
+					last_checked_at = excluded.last_checked_at, kafka_offset = excluded.kafka_offset,
+					report_checksum = excluded.report_checksum, deleted_at = NULL
+				WHERE (report.last_checked_at IS NULL OR report.last_checked_at <= excluded.last_checked_at)
+				AND (report.kafka_offset IS NULL OR excluded.kafka_offset IS NULL
+					OR report.kafka_offset < excluded.kafka_offset)`,
+		},
+		{
+			name:      "rule ack",
+			statement: storage.RuleAckUpsert,
+			sqlite: `
+				INSERT INTO rule_ack(org_id, rule_id, user_id, justification, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $5)
+				ON CONFLICT (org_id, rule_id)
+				DO UPDATE SET user_id = $3, justification = $4, updated_at = $5`,
+		},
+		{
+			name:      "rule disable",
+			statement: storage.RuleDisableUpsert,
+			sqlite: `
+				INSERT INTO rule_disable_org(org_id, rule_id, user_id, disabled_at)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (org_id, rule_id)
+				DO UPDATE SET user_id = $3, disabled_at = $4`,
+		},
+		{
+			name:      "rule hit",
+			statement: storage.RuleHitUpsert,
+			sqlite: `INSERT OR REPLACE INTO rule_hit(org_id, cluster, rule_fqdn, error_key, template_data)
+				VALUES ($1, $2, $3, $4, $5)`,
+			postgres: `INSERT INTO rule_hit(org_id, cluster, rule_fqdn, error_key, template_data)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (org_id, cluster, rule_fqdn, error_key)
+				DO UPDATE SET template_data = excluded.template_data`,
+		},
+		{
+			name:      "user message",
+			statement: storage.UserMessageUpsert,
+			sqlite: `
+				INSERT INTO cluster_rule_user_message(cluster_id, rule_id, error_key, user_id, message, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (cluster_id, rule_id, error_key, user_id) DO UPDATE SET message = $5, updated_at = $6`,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			// statements without INSERT OR REPLACE alternative are the same for both databases
+			if testCase.postgres == "" {
+				testCase.postgres = testCase.sqlite
+			}
+
+			query, ok := storage.Upsert(storage.DBDriverSQLite3, testCase.statement)
+			assert.True(t, ok)
+			assertSameSQL(t, testCase.sqlite, query)
+
+			query, ok = storage.Upsert(storage.DBDriverPostgres, testCase.statement)
+			assert.True(t, ok)
+			assertSameSQL(t, testCase.postgres, query)
+
+			// only the standard upsert is expected from the general driver
+			query, ok = storage.Upsert(storage.DBDriverGeneral, testCase.statement)
+			assert.True(t, ok)
+			assertSameSQL(t, testCase.postgres, query)
+
+			_, ok = storage.Upsert(-1, testCase.statement)
+			assert.False(t, ok)
+		})
+	}
+}
+
+// TestDialectUpsertRows checks that placeholders of each row of multi-row upsert
+// follow the ones of the previous row
+func TestDialectUpsertRows(t *testing.T) {
+	const expected = `
+		INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at, kafka_offset, report_checksum)
+		VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (org_id, cluster) DO UPDATE SET report = excluded.report, reported_at = excluded.reported_at,
+			last_checked_at = excluded.last_checked_at, kafka_offset = excluded.kafka_offset,
+			report_checksum = excluded.report_checksum, deleted_at = NULL
+		WHERE report.last_checked_at IS NULL OR report.last_checked_at <= excluded.last_checked_at`
+
+	for _, driverType := range []storage.DBDriver{storage.DBDriverSQLite3, storage.DBDriverPostgres} {
+		query, ok := storage.UpsertRows(driverType, storage.ReportUpsert, 2)
+		assert.True(t, ok)
+		assertSameSQL(t, expected, query)
+	}
+
+	// a single row is the same as the ordinary upsert
+	query, ok := storage.UpsertRows(storage.DBDriverPostgres, storage.ReportUpsert, 1)
+	assert.True(t, ok)
+	upsert, _ := storage.Upsert(storage.DBDriverPostgres, storage.ReportUpsert)
+	assertSameSQL(t, upsert, query)
+
+	_, ok = storage.UpsertRows(-1, storage.ReportUpsert, 2)
+	assert.False(t, ok)
+}
+
+func TestDialectUpsertClusterRuleUserFeedback(t *testing.T) {
+	const insert = `
+		INSERT INTO cluster_rule_user_feedback
+		(cluster_id, rule_id, error_key, user_id, user_vote, added_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+	`
+
+	for _, testCase := range []struct {
+		name          string
+		updateVote    bool
+		updateMessage bool
+		expected      string
+	}{
+		{"insert only", false, false, insert},
+		{"vote", true, false,
+			insert + "ON CONFLICT (cluster_id, rule_id, error_key, user_id) DO UPDATE SET user_vote = $5, updated_at = $6"},
+		{"message", false, true,
+			insert + "ON CONFLICT (cluster_id, rule_id, error_key, user_id) DO UPDATE SET updated_at = $6"},
+		{"vote and message", true, true,
+			insert + "ON CONFLICT (cluster_id, rule_id, error_key, user_id) DO UPDATE SET user_vote = $5, updated_at = $6"},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			for _, driverType := range []storage.DBDriver{storage.DBDriverSQLite3, storage.DBDriverPostgres} {
+				query, err := storage.ConstructUpsertClusterRuleUserFeedback(
+					driverType, testCase.updateVote, testCase.updateMessage,
+				)
+				helpers.FailOnError(t, err)
+				assertSameSQL(t, testCase.expected, query)
+			}
+		})
+	}
+
+	_, err := storage.ConstructUpsertClusterRuleUserFeedback(-1, true, true)
+	assert.EqualError(t, err, "DB driver -1 is not supported")
+}
+
+func TestDialectInList(t *testing.T) {
+	for _, driverType := range []storage.DBDriver{storage.DBDriverSQLite3, storage.DBDriverPostgres} {
+		inList, args := storage.InList(driverType, nil, "a", "b", "c")
+		assert.Equal(t, "($1, $2, $3)", inList)
+		assert.Equal(t, []interface{}{"a", "b", "c"}, args)
+
+		// placeholders continue after the arguments already in the query
+		inList, args = storage.InList(driverType, []interface{}{"cluster", "user"}, "rule1", "rule2")
+		assert.Equal(t, "($3, $4)", inList)
+		assert.Equal(t, []interface{}{"cluster", "user", "rule1", "rule2"}, args)
+	}
+}
+
+func TestDialectLimitOffset(t *testing.T) {
+	for _, testCase := range []struct {
+		name       string
+		driverType storage.DBDriver
+		limit      int
+		offset     int
+		expected   string
+		args       []interface{}
+	}{
+		{"no paging", storage.DBDriverSQLite3, 0, 0, "", nil},
+		{"limit", storage.DBDriverSQLite3, 10, 0, " LIMIT $1", []interface{}{10}},
+		{"limit and offset", storage.DBDriverPostgres, 10, 20, " LIMIT $1 OFFSET $2", []interface{}{10, 20}},
+		{"offset on sqlite", storage.DBDriverSQLite3, 0, 20, " LIMIT -1 OFFSET $1", []interface{}{20}},
+		{"offset on postgres", storage.DBDriverPostgres, 0, 20, " LIMIT ALL OFFSET $1", []interface{}{20}},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			clauses, args, ok := storage.LimitOffset(testCase.driverType, testCase.limit, testCase.offset)
+			assert.True(t, ok)
+			assert.Equal(t, testCase.expected, clauses)
+			assert.Equal(t, testCase.args, args)
+		})
+	}
+
+	// offset without limit can't be expressed in the standard SQL
+	_, _, ok := storage.LimitOffset(storage.DBDriverGeneral, 0, 20)
+	assert.False(t, ok)
+}
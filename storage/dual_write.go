@@ -0,0 +1,187 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// write modes of the storage, they select which tables are written together with reports
+const (
+	// WriteModeLegacy writes only reports, rule hits are not stored separately
+	WriteModeLegacy = "legacy"
+	// WriteModeDualWrite writes reports together with rule hits derived from them into rule_hit table
+	WriteModeDualWrite = "dual_write"
+)
+
+// sources of rule hits read by the storage
+const (
+	// ReadSourceRuleHit reads rule hits from rule_hit table
+	ReadSourceRuleHit = "rule_hit"
+	// ReadSourceReport reads rule hits from the stored reports
+	ReadSourceReport = "report"
+)
+
+// checkReadWriteModes checks that the write mode and the read source are known
+// and that rule hits are read only from the data written in the write mode
+func checkReadWriteModes(writeMode, readSource string) error {
+	switch writeMode {
+	case WriteModeLegacy, WriteModeDualWrite:
+	default:
+		return fmt.Errorf("unknown write mode %q", writeMode)
+	}
+
+	switch readSource {
+	case ReadSourceReport:
+	case ReadSourceRuleHit:
+		if writeMode == WriteModeLegacy {
+			return fmt.Errorf("rule hits can't be read from %v in %v write mode", readSource, writeMode)
+		}
+	default:
+		return fmt.Errorf("unknown read source %q", readSource)
+	}
+
+	return nil
+}
+
+// readRuleHits reads rule hits of the cluster from the source
+func (storage DBStorage) readRuleHits(
+	ctx context.Context, source string, orgID types.OrgID, clusterName types.ClusterName,
+) ([]types.RuleOnReport, error) {
+	if source == ReadSourceReport {
+		return storage.readRuleHitsFromReport(ctx, orgID, clusterName)
+	}
+
+	return storage.readRuleHitsFromTable(ctx, orgID, clusterName)
+}
+
+// readRuleHitsFromReport reads rule hits of the cluster from its stored report,
+// they are ordered in the same way as rule hits read from rule_hit table
+func (storage DBStorage) readRuleHitsFromReport(
+	ctx context.Context, orgID types.OrgID, clusterName types.ClusterName,
+) ([]types.RuleOnReport, error) {
+	ruleHits := make([]types.RuleOnReport, 0)
+
+	var report string
+	err := storage.reads().QueryRowContext(
+		ctx,
+		"SELECT report FROM report WHERE org_id = $1 AND cluster = $2 AND deleted_at IS NULL", orgID, clusterName,
+	).Scan(&report)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ruleHits, newItemNotFoundError(ItemKindReport, orgID, clusterName).withCause(err)
+	case err != nil:
+		return ruleHits, err
+	}
+
+	decodedReport, err := storage.decodeReport(types.ClusterReport(report))
+	if err != nil {
+		return ruleHits, err
+	}
+
+	var reportRules types.ReportRules
+	if err := json.Unmarshal([]byte(decodedReport), &reportRules); err != nil {
+		return ruleHits, err
+	}
+
+	ruleHits = append(ruleHits, reportRules.HitRules...)
+	sortRuleHits(ruleHits)
+
+	return ruleHits, nil
+}
+
+// sortRuleHits orders rule hits by rule and error key, the same way as rule hits read from rule_hit table
+func sortRuleHits(ruleHits []types.RuleOnReport) {
+	sort.SliceStable(ruleHits, func(i, j int) bool {
+		if ruleHits[i].Module != ruleHits[j].Module {
+			return ruleHits[i].Module < ruleHits[j].Module
+		}
+		return ruleHits[i].ErrorKey < ruleHits[j].ErrorKey
+	})
+}
+
+// sampleReadComparison decides whether the read is compared with the other source
+func (storage DBStorage) sampleReadComparison() bool {
+	return storage.readComparisonSampleRate > 0 && rand.Float64() < storage.readComparisonSampleRate
+}
+
+// compareRuleHits reads rule hits of the cluster from the source which is not configured for reads
+// and logs differences from the rule hits read from the configured source. Errors of the comparison
+// are only logged, they never fail the read.
+func (storage DBStorage) compareRuleHits(
+	ctx context.Context, orgID types.OrgID, clusterName types.ClusterName, ruleHits []types.RuleOnReport,
+) {
+	otherSource := ReadSourceReport
+	if storage.readSource == ReadSourceReport {
+		otherSource = ReadSourceRuleHit
+	}
+
+	otherRuleHits, err := storage.readRuleHits(ctx, otherSource, orgID, clusterName)
+	if err != nil {
+		log.Error().Err(err).Str("source", otherSource).Msg("Unable to read rule hits for comparison")
+		return
+	}
+
+	metrics.RuleHitsReadComparisons.Inc()
+
+	expected, err := ruleHitsTemplateData(ruleHits)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to compare rule hits")
+		return
+	}
+
+	actual, err := ruleHitsTemplateData(otherRuleHits)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to compare rule hits")
+		return
+	}
+
+	if description := describeRuleHitsMismatch(expected, actual); len(description) != 0 {
+		metrics.RuleHitsReadDivergences.Inc()
+		log.Warn().
+			Uint64("org_id", uint64(orgID)).
+			Str("cluster", string(clusterName)).
+			Str("read_source", storage.readSource).
+			Str("compared_source", otherSource).
+			Msgf("Rule hits read from different sources diverge: %v", description)
+	}
+}
+
+// ruleHitsTemplateData returns template data of rule hits keyed by rule and error key
+func ruleHitsTemplateData(ruleHits []types.RuleOnReport) (map[string]string, error) {
+	templateData := make(map[string]string, len(ruleHits))
+
+	for _, ruleHit := range ruleHits {
+		data, err := json.Marshal(ruleHit.TemplateData)
+		if err != nil {
+			return nil, err
+		}
+		templateData[ruleHit.Module+"|"+ruleHit.ErrorKey] = string(data)
+	}
+
+	return templateData, nil
+}
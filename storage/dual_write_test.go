@@ -0,0 +1,188 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prom_models "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+func getCounterValue(t *testing.T, counter prometheus.Counter) float64 {
+	pb := &prom_models.Metric{}
+	helpers.FailOnError(t, counter.Write(pb))
+
+	return pb.GetCounter().GetValue()
+}
+
+// mustGetStorageWithReadWriteModes returns mock storage with the written report of the cluster
+func mustGetStorageWithReadWriteModes(
+	t *testing.T, writeMode, readSource string, comparisonSampleRate float64,
+) *storage.DBStorage {
+	mockStorage := helpers.MustGetMockStorage(t, true).(*storage.DBStorage)
+	storage.SetReadWriteModes(mockStorage, writeMode, readSource, comparisonSampleRate)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	return mockStorage
+}
+
+func countRuleHitRows(t *testing.T, mockStorage *storage.DBStorage) int {
+	var count int
+	err := storage.GetConnection(mockStorage).QueryRow("SELECT COUNT(*) FROM rule_hit").Scan(&count)
+	helpers.FailOnError(t, err)
+
+	return count
+}
+
+// TestDBStorageDualWriteReadSources checks that both sources return the same rule hits in dual-write mode
+func TestDBStorageDualWriteReadSources(t *testing.T) {
+	mockStorage := mustGetStorageWithReadWriteModes(t, storage.WriteModeDualWrite, storage.ReadSourceRuleHit, 0)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	assert.Equal(t, 3, countRuleHitRows(t, mockStorage))
+
+	fromRuleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	storage.SetReadWriteModes(mockStorage, storage.WriteModeDualWrite, storage.ReadSourceReport, 0)
+
+	fromReport, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	assert.Len(t, fromReport, 3)
+	assert.Equal(t, fromRuleHits, fromReport)
+}
+
+// TestDBStorageLegacyWriteMode checks that rule hits are not written in legacy mode
+// and that they are still read from the report
+func TestDBStorageLegacyWriteMode(t *testing.T) {
+	mockStorage := mustGetStorageWithReadWriteModes(t, storage.WriteModeLegacy, storage.ReadSourceReport, 0)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	assert.Equal(t, 0, countRuleHitRows(t, mockStorage))
+
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Len(t, ruleHits, 3)
+}
+
+// TestDBStorageReadFromReportNotFound checks that missing report is reported when reading from reports
+func TestDBStorageReadFromReportNotFound(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true).(*storage.DBStorage)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	storage.SetReadWriteModes(mockStorage, storage.WriteModeLegacy, storage.ReadSourceReport, 0)
+
+	_, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	assert.IsType(t, &storage.ItemNotFoundError{}, err)
+}
+
+// TestDBStorageReadComparisonConsistent checks that compared reads of consistent sources don't diverge
+func TestDBStorageReadComparisonConsistent(t *testing.T) {
+	mockStorage := mustGetStorageWithReadWriteModes(t, storage.WriteModeDualWrite, storage.ReadSourceRuleHit, 1)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	comparisons := getCounterValue(t, metrics.RuleHitsReadComparisons)
+	divergences := getCounterValue(t, metrics.RuleHitsReadDivergences)
+
+	_, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, comparisons+1, getCounterValue(t, metrics.RuleHitsReadComparisons))
+	assert.Equal(t, divergences, getCounterValue(t, metrics.RuleHitsReadDivergences))
+}
+
+// TestDBStorageReadComparisonDivergence checks that divergence of rule_hit table
+// from the report is detected by compared read, while the read itself succeeds
+func TestDBStorageReadComparisonDivergence(t *testing.T) {
+	for _, readSource := range []string{storage.ReadSourceRuleHit, storage.ReadSourceReport} {
+		t.Run(readSource, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			originalLogger := log.Logger
+			log.Logger = zerolog.New(buf)
+			defer func() {
+				log.Logger = originalLogger
+			}()
+
+			mockStorage := mustGetStorageWithReadWriteModes(t, storage.WriteModeDualWrite, readSource, 1)
+			defer helpers.MustCloseStorage(t, mockStorage)
+
+			_, err := storage.GetConnection(mockStorage).Exec(
+				"DELETE FROM rule_hit WHERE rule_fqdn = $1", testdata.Rule1ID+".report",
+			)
+			helpers.FailOnError(t, err)
+
+			divergences := getCounterValue(t, metrics.RuleHitsReadDivergences)
+
+			ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+			helpers.FailOnError(t, err)
+
+			if readSource == storage.ReadSourceRuleHit {
+				assert.Len(t, ruleHits, 2)
+			} else {
+				assert.Len(t, ruleHits, 3)
+			}
+			assert.Equal(t, divergences+1, getCounterValue(t, metrics.RuleHitsReadDivergences))
+			assert.Contains(t, buf.String(), "Rule hits read from different sources diverge")
+		})
+	}
+}
+
+// TestNewStorageReadWriteModes checks validation of configured write mode and read source
+func TestNewStorageReadWriteModes(t *testing.T) {
+	for _, testCase := range []struct {
+		writeMode   string
+		readSource  string
+		expectedErr string
+	}{
+		{"", "", ""},
+		{storage.WriteModeLegacy, storage.ReadSourceReport, ""},
+		{storage.WriteModeDualWrite, storage.ReadSourceReport, ""},
+		{storage.WriteModeLegacy, "", "rule hits can't be read from rule_hit in legacy write mode"},
+		{"single_write", "", `unknown write mode "single_write"`},
+		{"", "cache", `unknown read source "cache"`},
+	} {
+		dbStorage, err := storage.New(storage.Configuration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			WriteMode:        testCase.writeMode,
+			ReadSource:       testCase.readSource,
+		})
+
+		if len(testCase.expectedErr) != 0 {
+			assert.EqualError(t, err, testCase.expectedErr)
+			continue
+		}
+
+		helpers.FailOnError(t, err)
+		helpers.MustCloseStorage(t, dbStorage)
+	}
+}
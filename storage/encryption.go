@@ -0,0 +1,246 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// ReportEncryptionKeyLength is the length in bytes of AES-256 keys encrypting reports
+const ReportEncryptionKeyLength = 32
+
+// encryptedReportPrefix is the beginning of every encrypted report. Encrypted reports are stored
+// as base64 encoded data inside JSON string, so they are accepted by VARCHAR as well as by JSONB column,
+// the "enc:" marker distinguishes them from plain reports and from reports compressed by compressReport.
+const encryptedReportPrefix = `"enc:`
+
+// reportEncryptionVersion is the first byte of data of reports encrypted by the current format,
+// it's followed by ID of the key, random nonce and the report sealed by AES-256-GCM
+const reportEncryptionVersion byte = 1
+
+// reportKeyIDLength is the number of bytes of SHA-256 checksum of the key identifying the key
+const reportKeyIDLength = 4
+
+// ReportEncryptionConfiguration configures encryption of stored reports by AES-256-GCM.
+// Keys are base64 encoded 32 bytes long keys, KeyFile is the path to the file containing
+// additional keys in the same encoding, one key per line. The first of Keys (or of keys
+// in KeyFile when Keys are empty) encrypts written reports, all the keys decrypt stored reports,
+// so keys can be rotated by adding a new key at the beginning. Reports are stored unencrypted
+// when no key is configured.
+type ReportEncryptionConfiguration struct {
+	Keys    []string `mapstructure:"keys" toml:"keys"`
+	KeyFile string   `mapstructure:"key_file" toml:"key_file"`
+}
+
+// ReportDecryptionError shows that the stored report can't be decrypted, because it has been
+// encrypted by a key which is not configured or its stored data are corrupted
+type ReportDecryptionError struct {
+	Reason string
+}
+
+// Error returns error string
+func (e *ReportDecryptionError) Error() string {
+	return "unable to decrypt report: " + e.Reason
+}
+
+// reportKey is the key decrypting reports encrypted by the key with the same ID
+type reportKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// reportEncryption encrypts reports by the first key and decrypts reports encrypted by any of the keys,
+// nil encryption doesn't encrypt reports and it fails to decrypt encrypted ones
+type reportEncryption struct {
+	keys []reportKey
+}
+
+// readReportEncryptionKeys returns the configured keys in the order of their priority
+func readReportEncryptionKeys(configuration ReportEncryptionConfiguration) ([]string, error) {
+	keys := append([]string{}, configuration.Keys...)
+
+	if configuration.KeyFile == "" {
+		return keys, nil
+	}
+
+	file, err := os.Open(configuration.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, scanner.Err()
+}
+
+// newReportEncryption creates the encryption using the configured keys, nil is returned when no key is configured
+func newReportEncryption(configuration ReportEncryptionConfiguration) (*reportEncryption, error) {
+	encodedKeys, err := readReportEncryptionKeys(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encodedKeys) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]reportKey, 0, len(encodedKeys))
+
+	for i, encodedKey := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("report encryption key #%d is not base64 encoded: %v", i+1, err)
+		}
+
+		if len(key) != ReportEncryptionKeyLength {
+			return nil, fmt.Errorf(
+				"report encryption key #%d has %d bytes, %d bytes expected", i+1, len(key), ReportEncryptionKeyLength,
+			)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		checksum := sha256.Sum256(key)
+		keys = append(keys, reportKey{id: string(checksum[:reportKeyIDLength]), aead: aead})
+	}
+
+	return &reportEncryption{keys: keys}, nil
+}
+
+// isReportEncrypted checks whether the stored report has been encrypted by reportEncryption
+func isReportEncrypted(report types.ClusterReport) bool {
+	return strings.HasPrefix(string(report), encryptedReportPrefix)
+}
+
+// encrypt encrypts the report by the first key with a random nonce,
+// the report is returned unchanged by nil encryption
+func (encryption *reportEncryption) encrypt(report types.ClusterReport) (types.ClusterReport, error) {
+	if encryption == nil {
+		return report, nil
+	}
+
+	key := encryption.keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	data := make([]byte, 0, 1+reportKeyIDLength+len(nonce)+len(report)+key.aead.Overhead())
+	data = append(data, reportEncryptionVersion)
+	data = append(data, key.id...)
+	data = append(data, nonce...)
+	data = key.aead.Seal(data, nonce, []byte(report), nil)
+
+	return types.ClusterReport(encryptedReportPrefix + base64.StdEncoding.EncodeToString(data) + `"`), nil
+}
+
+// decrypt decrypts the report encrypted by any of the keys,
+// reports which are not encrypted are returned unchanged
+func (encryption *reportEncryption) decrypt(report types.ClusterReport) (types.ClusterReport, error) {
+	if !isReportEncrypted(report) {
+		return report, nil
+	}
+
+	encoded := strings.TrimSuffix(strings.TrimPrefix(string(report), encryptedReportPrefix), `"`)
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", &ReportDecryptionError{Reason: "report is not base64 encoded"}
+	}
+
+	if len(data) < 1+reportKeyIDLength || data[0] != reportEncryptionVersion {
+		return "", &ReportDecryptionError{Reason: "unknown format of encrypted report"}
+	}
+
+	if encryption == nil {
+		return "", &ReportDecryptionError{Reason: "no encryption key is configured"}
+	}
+
+	keyID, data := string(data[1:1+reportKeyIDLength]), data[1+reportKeyIDLength:]
+
+	for _, key := range encryption.keys {
+		if key.id != keyID {
+			continue
+		}
+
+		if len(data) < key.aead.NonceSize() {
+			return "", &ReportDecryptionError{Reason: "encrypted report is truncated"}
+		}
+
+		nonce, sealed := data[:key.aead.NonceSize()], data[key.aead.NonceSize():]
+
+		decrypted, err := key.aead.Open(nil, nonce, sealed, nil)
+		if err != nil {
+			return "", &ReportDecryptionError{Reason: "report can't be authenticated by its key"}
+		}
+
+		return types.ClusterReport(decrypted), nil
+	}
+
+	return "", &ReportDecryptionError{Reason: "report is encrypted by unknown key"}
+}
+
+// encodeReport compresses and encrypts the report written to the database as configured
+func (storage DBStorage) encodeReport(report types.ClusterReport) (types.ClusterReport, error) {
+	if storage.compressReports {
+		compressedReport, err := compressReport(report)
+		if err != nil {
+			return "", err
+		}
+		report = compressedReport
+	}
+
+	return storage.reportEncryption.encrypt(report)
+}
+
+// decodeReport decrypts and decompresses the report read from the database,
+// reports which are neither encrypted nor compressed are returned unchanged
+func (storage DBStorage) decodeReport(report types.ClusterReport) (types.ClusterReport, error) {
+	decryptedReport, err := storage.reportEncryption.decrypt(report)
+	if err != nil {
+		return "", err
+	}
+
+	return decompressReport(decryptedReport)
+}
@@ -0,0 +1,245 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const (
+	encryptedClusterName = types.ClusterName("b5a1bbd4-5a4f-4a6c-9e7d-3c4a7a2f1e90")
+	corruptedClusterName = types.ClusterName("0e8f7a6b-2c1d-4e3f-9a8b-7c6d5e4f3a2b")
+)
+
+// encryptionKey returns base64 encoded key filled by the given character
+func encryptionKey(c string) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(c, storage.ReportEncryptionKeyLength)))
+}
+
+func mustSetReportEncryption(t *testing.T, mockStorage storage.Storage, keys ...string) {
+	err := storage.SetReportEncryption(
+		mockStorage.(*storage.DBStorage), storage.ReportEncryptionConfiguration{Keys: keys},
+	)
+	helpers.FailOnError(t, err)
+}
+
+// assertReportDecryptionError checks that the report of the cluster can't be read because it can't be decrypted
+func assertReportDecryptionError(t *testing.T, mockStorage storage.Storage, clusterName types.ClusterName) {
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, clusterName)
+
+	var decryptionError *storage.ReportDecryptionError
+	assert.True(t, errors.As(err, &decryptionError), "expected ReportDecryptionError, got %T, %+v", err, err)
+}
+
+// TestDBStorageWriteReportForClusterEncrypted checks that the encrypted report
+// is stored in encrypted form and read back unchanged by all read paths
+func TestDBStorageWriteReportForClusterEncrypted(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		mockStorage := mustGetCompressingStorage(t, compress)
+		mustSetReportEncryption(t, mockStorage, encryptionKey("a"))
+
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+		stored := readStoredReport(t, mockStorage, testdata.ClusterName)
+		assert.True(t, strings.HasPrefix(stored, `"enc:`))
+		assert.NotContains(t, stored, string(testdata.Rule1ID))
+
+		checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+		storedReport, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+
+		clusters, err := mockStorage.GetClustersHittingRule(testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterName{testdata.ClusterName}, clusters)
+
+		helpers.MustCloseStorage(t, mockStorage)
+	}
+}
+
+// TestDBStorageEncryptedReportsDiffer checks that the same report is encrypted differently
+// each time, because a random nonce is used
+func TestDBStorageEncryptedReportsDiffer(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("a"))
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+	writeReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report3Rules)
+
+	assert.NotEqual(
+		t, readStoredReport(t, mockStorage, testdata.ClusterName), readStoredReport(t, mockStorage, encryptedClusterName),
+	)
+}
+
+// TestDBStorageReadReportWrongKey checks that the report encrypted by a key which is not configured
+// can't be read and the typed error is returned
+func TestDBStorageReadReportWrongKey(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("a"))
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("b"))
+	assertReportDecryptionError(t, mockStorage, testdata.ClusterName)
+
+	// the encrypted report can't be read without any key either
+	mustSetReportEncryption(t, mockStorage)
+	assertReportDecryptionError(t, mockStorage, testdata.ClusterName)
+}
+
+// TestDBStorageReadReportCorruptedEncryption checks that corrupted encrypted report leads to the typed error
+func TestDBStorageReadReportCorruptedEncryption(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("a"))
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	// the sealed report is modified, so it can't be authenticated
+	stored := readStoredReport(t, mockStorage, testdata.ClusterName)
+	data, err := base64.StdEncoding.DecodeString(strings.Trim(strings.TrimPrefix(stored, `"enc:`), `"`))
+	helpers.FailOnError(t, err)
+	data[len(data)-1] ^= 0xff
+
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
+	mustWriteReport(t, connection, testdata.OrgID, encryptedClusterName, `"enc:`+base64.StdEncoding.EncodeToString(data)+`"`)
+	assertReportDecryptionError(t, mockStorage, encryptedClusterName)
+
+	mustWriteReport(t, connection, testdata.OrgID, corruptedClusterName, `"enc:not base64"`)
+	assertReportDecryptionError(t, mockStorage, corruptedClusterName)
+}
+
+// TestDBStorageReadReportsMixedEncryption checks that encrypted and plain reports
+// stored in the same table are both read correctly
+func TestDBStorageReadReportsMixedEncryption(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("a"))
+	writeReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report3Rules)
+
+	assert.Equal(t, string(testdata.Report3Rules), readStoredReport(t, mockStorage, testdata.ClusterName))
+	assert.True(t, strings.HasPrefix(readStoredReport(t, mockStorage, encryptedClusterName), `"enc:`))
+
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+	checkReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report3Rules)
+
+	clusters, err := mockStorage.GetClustersHittingRule(testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.ElementsMatch(t, []types.ClusterName{testdata.ClusterName, encryptedClusterName}, clusters)
+}
+
+// TestDBStorageReportEncryptionKeyRotation checks that reports encrypted by older keys
+// are still read, while new reports are encrypted by the first key
+func TestDBStorageReportEncryptionKeyRotation(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("a"))
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("b"), encryptionKey("a"))
+	writeReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report0Rules)
+
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+	checkReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report0Rules)
+
+	// the new report is encrypted by the new key only
+	mustSetReportEncryption(t, mockStorage, encryptionKey("b"))
+	checkReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report0Rules)
+	assertReportDecryptionError(t, mockStorage, testdata.ClusterName)
+}
+
+// TestDBStorageReportEncryptionKeyFile checks that keys are read from the key file after the listed keys
+func TestDBStorageReportEncryptionKeyFile(t *testing.T) {
+	keyFile, err := ioutil.TempFile("", "report-keys")
+	helpers.FailOnError(t, err)
+	defer func() {
+		helpers.FailOnError(t, os.Remove(keyFile.Name()))
+	}()
+
+	_, err = keyFile.WriteString(encryptionKey("b") + "\n\n" + encryptionKey("c") + "\n")
+	helpers.FailOnError(t, err)
+	helpers.FailOnError(t, keyFile.Close())
+
+	mockStorage := mustGetCompressingStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	// the first key of the file encrypts reports when no key is listed
+	err = storage.SetReportEncryption(
+		mockStorage.(*storage.DBStorage), storage.ReportEncryptionConfiguration{KeyFile: keyFile.Name()},
+	)
+	helpers.FailOnError(t, err)
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("b"))
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	// the listed key encrypts reports, the keys from the file decrypt them
+	err = storage.SetReportEncryption(
+		mockStorage.(*storage.DBStorage),
+		storage.ReportEncryptionConfiguration{Keys: []string{encryptionKey("a")}, KeyFile: keyFile.Name()},
+	)
+	helpers.FailOnError(t, err)
+	writeReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report0Rules)
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	mustSetReportEncryption(t, mockStorage, encryptionKey("a"))
+	checkReportForCluster(t, mockStorage, testdata.OrgID, encryptedClusterName, testdata.Report0Rules)
+}
+
+func TestNewStorageInvalidReportEncryptionKeys(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		configuration storage.ReportEncryptionConfiguration
+		expectedError string
+	}{
+		{"not base64", storage.ReportEncryptionConfiguration{Keys: []string{encryptionKey("a"), "not base64"}},
+			"report encryption key #2 is not base64 encoded: illegal base64 data at input byte 3"},
+		{"short key", storage.ReportEncryptionConfiguration{Keys: []string{base64.StdEncoding.EncodeToString([]byte("key"))}},
+			"report encryption key #1 has 3 bytes, 32 bytes expected"},
+		{"missing key file", storage.ReportEncryptionConfiguration{KeyFile: "/nonexistent/report-keys"},
+			"open /nonexistent/report-keys: no such file or directory"},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := storage.New(storage.Configuration{
+				Driver:           "sqlite3",
+				SQLiteDataSource: ":memory:",
+				ReportEncryption: testCase.configuration,
+			})
+			assert.EqualError(t, err, testCase.expectedError)
+		})
+	}
+}
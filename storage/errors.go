@@ -17,15 +17,134 @@ limitations under the License.
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// ErrOldReport shows that the report was not written, because the stored report
+// was consumed from the same or newer Kafka offset
+var ErrOldReport = errors.New("report from the same or newer Kafka offset is already stored")
+
+// ErrNotFound is matched by errors.Is for all errors of items not found in the storage
+var ErrNotFound = errors.New("item was not found in the storage")
+
+// ItemKind identifies the kind of item which wasn't found in the storage
+type ItemKind string
+
+const (
+	// ItemKindReport is a report identified by organization and cluster or by cluster only
+	ItemKindReport ItemKind = "report"
+	// ItemKindCluster is a cluster whose organization was looked up, identified by cluster
+	ItemKindCluster ItemKind = "cluster"
+	// ItemKindRule is a rule identified by its module
+	ItemKindRule ItemKind = "rule"
+	// ItemKindErrorKey is an error key of a rule identified by the rule module and the error key
+	ItemKindErrorKey ItemKind = "error_key"
+	// ItemKindFeedback is user's feedback identified by cluster, rule, optional error key and user
+	ItemKindFeedback ItemKind = "feedback"
+	// ItemKindToggle is a rule acked by an organization identified by organization and rule
+	ItemKindToggle ItemKind = "toggle"
+	// ItemKindContentVersion is a version of rule content identified by its checksum
+	ItemKindContentVersion ItemKind = "content_version"
 )
 
-// ItemNotFoundError shows that item with id ItemID wasn't found in the storage
+// ItemNotFoundError shows that item of the Kind identified by IDs wasn't found in the storage,
+// Err is the error of the database driver when it reported the missing item
 type ItemNotFoundError struct {
-	ItemID interface{}
+	Kind ItemKind
+	IDs  []interface{}
+	Err  error
+}
+
+// newItemNotFoundError creates ItemNotFoundError of the item of the kind identified by ids
+func newItemNotFoundError(kind ItemKind, ids ...interface{}) *ItemNotFoundError {
+	return &ItemNotFoundError{Kind: kind, IDs: ids}
+}
+
+// withCause sets the error of the database driver which reported the missing item
+func (e *ItemNotFoundError) withCause(err error) *ItemNotFoundError {
+	e.Err = err
+	return e
 }
 
 // Error returns error string
 func (e *ItemNotFoundError) Error() string {
-	return fmt.Sprintf("Item with ID %+v was not found in the storage", e.ItemID)
+	ids := make([]string, 0, len(e.IDs))
+	for _, id := range e.IDs {
+		ids = append(ids, fmt.Sprintf("%+v", id))
+	}
+
+	return fmt.Sprintf("Item with ID %v was not found in the storage", strings.Join(ids, "/"))
+}
+
+// Is matches ErrNotFound, so errors.Is(err, ErrNotFound) works for all kinds of items
+func (e *ItemNotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// Unwrap returns the error of the database driver, if any
+func (e *ItemNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError shows that the data can't be stored, because they don't pass validation
+type ValidationError struct {
+	ParamName string
+	ErrString string
+}
+
+// Error returns error string
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid value of '%v': %v", e.ParamName, e.ErrString)
+}
+
+// InvalidReportError shows that report is not a valid JSON and can't be stored
+type InvalidReportError struct {
+	OrgID       types.OrgID
+	ClusterName types.ClusterName
+}
+
+// Error returns error string
+func (e *InvalidReportError) Error() string {
+	return fmt.Sprintf("Report for organization %v and cluster %v is not a valid JSON", e.OrgID, e.ClusterName)
+}
+
+// QueryTimeoutError shows that the storage operation hasn't finished in time,
+// it's usually caused by overloaded database and the operation can be retried later
+type QueryTimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+// Error returns error string
+func (e *QueryTimeoutError) Error() string {
+	return fmt.Sprintf("Storage operation %v has not finished in %v", e.Operation, e.Timeout)
+}
+
+// BatchWriteError shows that reports of some clusters written by WriteReportsForClusters
+// were not written, Errors contains the reason of the failure for each such cluster
+type BatchWriteError struct {
+	Errors map[types.ClusterName]error
+}
+
+// Error returns error string
+func (e *BatchWriteError) Error() string {
+	return fmt.Sprintf("Reports of %v clusters were not written: %v", len(e.Errors), e.FailedClusters())
+}
+
+// FailedClusters returns sorted names of clusters whose reports were not written
+func (e *BatchWriteError) FailedClusters() []types.ClusterName {
+	clusters := make([]types.ClusterName, 0, len(e.Errors))
+	for cluster := range e.Errors {
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i] < clusters[j] })
+
+	return clusters
 }
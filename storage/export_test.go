@@ -17,7 +17,9 @@ limitations under the License.
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"time"
 )
 
 // Export for testing
@@ -26,12 +28,217 @@ import (
 // https://medium.com/@robiplus/golang-trick-export-for-test-aa16cbd7b8cd
 // to see why this trick is needed.
 type SQLHooks = sqlHooks
+type OperationClass = operationClass
 
 const (
 	LogFormatterString        = logFormatterString
 	SQLHooksKeyQueryBeginTime = sqlHooksKeyQueryBeginTime
+
+	FastRead         = fastRead
+	HeavyAggregation = heavyAggregation
+	Write            = write
+	Maintenance      = maintenance
 )
 
 func GetConnection(storage *DBStorage) *sql.DB {
 	return storage.connection
 }
+
+func SetCompressReports(storage *DBStorage, compress bool) {
+	storage.compressReports = compress
+}
+
+var CompressReport = compressReport
+
+func SetReportsBatchSize(storage *DBStorage, batchSize int) {
+	storage.reportsBatchSize = batchSize
+}
+
+func GetReportsPerBatch(storage *DBStorage) int {
+	return storage.reportsPerBatch()
+}
+
+func SetReportHistoryDepth(storage Storage, depth int) {
+	switch s := storage.(type) {
+	case *DBStorage:
+		s.reportHistoryDepth = depth
+	case *InMemoryStorage:
+		s.reportHistoryDepth = depth
+	}
+}
+
+func SetMaxFeedbackMessageLength(storage Storage, length int) {
+	switch s := storage.(type) {
+	case *DBStorage:
+		s.maxFeedbackMessageLength = length
+	case *InMemoryStorage:
+		s.maxFeedbackMessageLength = length
+	}
+}
+
+func CleanupReportsCheckedBefore(storage *DBStorage, cutoff time.Time) (int, error) {
+	return storage.cleanupReportsCheckedBefore(context.Background(), cutoff, nil)
+}
+
+func SetContentHistoryDepth(storage Storage, depth int) {
+	switch s := storage.(type) {
+	case *DBStorage:
+		s.contentHistoryDepth = depth
+	case *InMemoryStorage:
+		s.contentHistoryDepth = depth
+	}
+}
+
+func ScanTimestamp(dest *time.Time) sql.Scanner {
+	return scanTimestamp(dest)
+}
+
+func GetOperationTimeout(storage *DBStorage, class OperationClass) time.Duration {
+	return storage.timeouts[class]
+}
+
+func SetOperationTimeout(storage *DBStorage, class OperationClass, timeout time.Duration) {
+	storage.timeouts[class] = timeout
+}
+
+func SetRetries(storage *DBStorage, maxRetries int, backoff time.Duration) {
+	storage.maxRetries = maxRetries
+	storage.retryBackoff = backoff
+}
+
+func IsTransientDBError(err error) bool {
+	return isTransientDBError(err)
+}
+
+func CloseStatements(storage *DBStorage) error {
+	return storage.statements.close()
+}
+
+func SetSlowQueryThreshold(storage *DBStorage, threshold time.Duration) {
+	storage.slowQueryThreshold = threshold
+}
+
+func AddWriteHook(storage *DBStorage, hook WriteHook) {
+	storage.writeHooks = append(storage.writeHooks, hook)
+}
+
+func SetWriteHooks(storage *DBStorage, hooks []WriteHook) {
+	storage.writeHooks = hooks
+}
+
+func SetReadWriteModes(storage *DBStorage, writeMode, readSource string, comparisonSampleRate float64) {
+	storage.writeMode = writeMode
+	storage.readSource = readSource
+	storage.readComparisonSampleRate = comparisonSampleRate
+}
+
+type UpsertStatement = upsertStatement
+
+var (
+	ConsumerErrorUpsert    = consumerErrorUpsert
+	ConsistencyIssueUpsert = consistencyIssueUpsert
+	ContentVersionUpsert   = contentVersionUpsert
+	NewerReportUpsert      = newerReportUpsert
+	ReportHistoryUpsert    = reportHistoryUpsert
+	ReportUpsert           = reportUpsert
+	RuleAckUpsert          = ruleAckUpsert
+	RuleDisableUpsert      = ruleDisableUpsert
+	RuleHitUpsert          = ruleHitUpsert
+	UserMessageUpsert      = userMessageUpsert
+)
+
+func dialectOfDriver(driverType DBDriver) sqlDialect {
+	return NewFromConnection(nil, driverType).dialect()
+}
+
+func Upsert(driverType DBDriver, statement UpsertStatement) (string, bool) {
+	return dialectOfDriver(driverType).upsert(statement)
+}
+
+func UpsertRows(driverType DBDriver, statement UpsertStatement, count int) (string, bool) {
+	return dialectOfDriver(driverType).upsertRows(statement, count)
+}
+
+func ConstructUpsertClusterRuleUserFeedback(driverType DBDriver, updateVote, updateMessage bool) (string, error) {
+	return NewFromConnection(nil, driverType).constructUpsertClusterRuleUserFeedback(updateVote, updateMessage)
+}
+
+func InList(driverType DBDriver, initial []interface{}, values ...interface{}) (string, []interface{}) {
+	args := dialectOfDriver(driverType).newQueryArgs(initial...)
+	inList := args.addList(values...)
+
+	return inList, args.values
+}
+
+func LimitOffset(driverType DBDriver, limit, offset int) (string, []interface{}, bool) {
+	args := dialectOfDriver(driverType).newQueryArgs()
+	clauses, ok := args.limitOffset(limit, offset)
+
+	return clauses, args.values, ok
+}
+
+func SetOrgIDCache(storage *DBStorage, size int, ttl time.Duration, now func() time.Time) {
+	storage.orgIDs = newOrgIDCache(size, ttl)
+	storage.orgIDs.now = now
+}
+
+func SetReplica(storage *DBStorage, replica *sql.DB) {
+	storage.replica = replica
+}
+
+func ReplicaDataSource(configuration Configuration) string {
+	return replicaDataSource(configuration)
+}
+
+func ReachableReplica(replica *sql.DB, pingTimeout time.Duration) *sql.DB {
+	return reachableReplica(replica, pingTimeout)
+}
+
+func SetMaintenanceInProgress(inProgress bool) {
+	if inProgress {
+		maintenanceInProgress = 1
+	} else {
+		maintenanceInProgress = 0
+	}
+}
+
+func ReportPartitionsOf(configuration Configuration, driverType DBDriver) int {
+	return reportPartitionsOf(configuration, driverType)
+}
+
+func PartitionedReportTableStatements(table string, partitions int) []string {
+	return partitionedReportTableStatements(table, partitions)
+}
+
+func ReplaceReportTableStatements() []string {
+	return replaceReportTableStatements()
+}
+
+func SetReportPartitions(storage *DBStorage, partitions int) {
+	storage.reportPartitions = partitions
+}
+
+func InitReportPartitions(storage *DBStorage) error {
+	return storage.initReportPartitions()
+}
+
+// SetTimeNow replaces the clock used by the storage and returns a function restoring the original one
+func SetTimeNow(now func() time.Time) func() {
+	original := timeNow
+	timeNow = now
+
+	return func() {
+		timeNow = original
+	}
+}
+
+// SetReportEncryption configures encryption of reports written and read by the storage
+func SetReportEncryption(storage *DBStorage, configuration ReportEncryptionConfiguration) error {
+	encryption, err := newReportEncryption(configuration)
+	if err != nil {
+		return err
+	}
+
+	storage.reportEncryption = encryption
+	return nil
+}
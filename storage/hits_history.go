@@ -0,0 +1,116 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// MaxHitsCountHistoryDays is the maximum number of days returned by GetHitsCountHistory,
+// longer history is cut to this number of days
+const MaxHitsCountHistoryDays = 90
+
+// hitsCountHistoryDateFormat is the format of days in the history of hits count
+const hitsCountHistoryDateFormat = "2006-01-02"
+
+// GetHitsCountHistory returns the number of rules hit by the cluster for each of the last days
+// (including today) computed from the latest report kept in the report history for that day.
+// The oldest day goes first and days without any report have zero hits count. Days are
+// bucketed in UTC by the storage itself, so the same query works for all databases.
+// Only reports still kept in the history (see report_history_depth) are taken into account.
+func (storage DBStorage) GetHitsCountHistory(
+	clusterName types.ClusterName, days int,
+) (_ []types.DailyHitsCount, err error) {
+	op := storage.startOperation("GetHitsCountHistory", fastRead).forCluster(clusterName)
+	defer op.finish(&err)
+
+	since, today, err := hitsCountHistoryPeriod(days)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT report, last_checked_at FROM report_history
+		 WHERE cluster = $1 AND last_checked_at >= $2
+		   AND cluster NOT IN (SELECT cluster FROM report WHERE deleted_at IS NOT NULL)
+		 ORDER BY last_checked_at`, clusterName, since)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	// the later report of the same day overwrites the earlier one
+	hitsCountPerDay := make(map[string]int)
+
+	for rows.Next() {
+		var (
+			report      types.ClusterReport
+			lastChecked time.Time
+		)
+
+		if err := rows.Scan(&report, scanTimestamp(&lastChecked)); err != nil {
+			return nil, err
+		}
+
+		report, err = storage.decodeReport(report)
+		if err != nil {
+			return nil, err
+		}
+
+		var reportRules types.ReportRules
+		if err := json.Unmarshal([]byte(report), &reportRules); err != nil {
+			return nil, err
+		}
+
+		hitsCountPerDay[lastChecked.UTC().Format(hitsCountHistoryDateFormat)] = len(reportRules.HitRules)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return dailyHitsCounts(since, today, hitsCountPerDay), nil
+}
+
+// hitsCountHistoryPeriod returns the first and the last day (today) of the history of hits count
+// for the number of days, ValidationError is returned for non-positive number of days
+func hitsCountHistoryPeriod(days int) (since, today time.Time, err error) {
+	if days <= 0 {
+		return since, today, &ValidationError{ParamName: "days", ErrString: "positive integer expected"}
+	}
+	if days > MaxHitsCountHistoryDays {
+		days = MaxHitsCountHistoryDays
+	}
+
+	now := time.Now().UTC()
+	today = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	since = today.AddDate(0, 0, 1-days)
+
+	return since, today, nil
+}
+
+// dailyHitsCounts returns hits count for each day of the period, the oldest day goes first
+// and days missing in hitsCountPerDay have zero hits count
+func dailyHitsCounts(since, today time.Time, hitsCountPerDay map[string]int) []types.DailyHitsCount {
+	history := make([]types.DailyHitsCount, 0)
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format(hitsCountHistoryDateFormat)
+		history = append(history, types.DailyHitsCount{Date: date, HitsCount: hitsCountPerDay[date]})
+	}
+
+	return history
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// listTables returns names of tables of the database, nil is returned when
+// tables of the database can't be listed
+func (storage DBStorage) listTables() (map[string]bool, error) {
+	query, ok := storage.dialect().tablesQuery()
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := storage.connection.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+
+		tables[table] = true
+	}
+
+	return tables, rows.Err()
+}
+
+// logInitializedTables logs which tables were created by Init and which ones
+// existed already before it, nothing is logged when tables can't be listed
+func logInitializedTables(existingTables, tables map[string]bool) {
+	if tables == nil {
+		return
+	}
+
+	created := []string{}
+	skipped := []string{}
+	for table := range tables {
+		if existingTables[table] {
+			skipped = append(skipped, table)
+		} else {
+			created = append(created, table)
+		}
+	}
+
+	sort.Strings(created)
+	sort.Strings(skipped)
+
+	log.Info().
+		Strs("created", created).
+		Strs("skipped", skipped).
+		Msgf("Database initialized, %d tables created and %d already existing tables kept", len(created), len(skipped))
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrMaintenanceInProgress is returned by RunMaintenance when another maintenance
+// of the database is already running in the process
+var ErrMaintenanceInProgress = errors.New("maintenance of the database is already in progress")
+
+// maintenanceInProgress is set while maintenance of any storage of the process is running,
+// background loops use their own storages, so it can't be a field of DBStorage
+var maintenanceInProgress int32
+
+// maintenanceStatements returns statements reclaiming space of deleted rows and refreshing
+// statistics of the query planner, they can't be run inside a transaction
+func (storage DBStorage) maintenanceStatements() []string {
+	if storage.dbDriverType == DBDriverPostgres {
+		return []string{"VACUUM (ANALYZE)"}
+	}
+
+	return []string{"VACUUM", "ANALYZE"}
+}
+
+// RunMaintenance reclaims space left by deleted rows and refreshes statistics of the query planner,
+// it should be run after large deletions. ErrMaintenanceInProgress is returned without touching
+// the database when another maintenance is running.
+func (storage DBStorage) RunMaintenance() (err error) {
+	if !atomic.CompareAndSwapInt32(&maintenanceInProgress, 0, 1) {
+		return ErrMaintenanceInProgress
+	}
+	defer atomic.StoreInt32(&maintenanceInProgress, 0)
+
+	op := storage.startOperation("RunMaintenance", maintenance)
+	defer op.finish(&err)
+
+	for _, statement := range storage.maintenanceStatements() {
+		if _, err := storage.connection.ExecContext(op.ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
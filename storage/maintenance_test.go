@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+)
+
+func TestDBStorageRunMaintenanceSQLite(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustWriteReport3Rules(t, mockStorage)
+	_, err := mockStorage.DeleteReportsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+
+	helpers.FailOnError(t, mockStorage.(*storage.DBStorage).RunMaintenance())
+}
+
+func TestDBStorageRunMaintenancePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectExec(`VACUUM \(ANALYZE\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	helpers.FailOnError(t, mockStorage.(*storage.DBStorage).RunMaintenance())
+}
+
+// TestDBStorageRunMaintenanceInProgress checks that the database is not touched
+// when another maintenance is in progress
+func TestDBStorageRunMaintenanceInProgress(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	storage.SetMaintenanceInProgress(true)
+	defer storage.SetMaintenanceInProgress(false)
+
+	err := mockStorage.(*storage.DBStorage).RunMaintenance()
+	assert.Equal(t, storage.ErrMaintenanceInProgress, err)
+}
+
+func TestDBStorageRunMaintenanceClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.(*storage.DBStorage).RunMaintenance()
+	expectErrorClosedStorage(t, err)
+}
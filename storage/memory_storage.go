@@ -0,0 +1,2339 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/content"
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// InMemoryStorage is an implementation of Storage interface keeping all data in maps
+// guarded by a mutex, it's meant for unit tests and for running the aggregator in demo
+// mode without any database. It follows the semantics of DBStorage, but the data are lost
+// when the storage is dropped. Rule hits are always read from the stored reports,
+// so the reports are consistent by definition. Timestamps are kept in UTC, because
+// DBStorage returns them in UTC too.
+type InMemoryStorage struct {
+	mutex                    sync.RWMutex
+	reportHistoryDepth       int
+	maxFeedbackMessageLength int
+	contentHistoryDepth      int
+	reports                  map[ReportKey]memoryReport
+	softDeletedReports       map[ReportKey]memorySoftDeletedReport
+	reportHistory            map[ReportKey][]memoryHistoryEntry
+	consumerErrors           map[memoryConsumerErrorKey]ConsumerError
+	feedbacks                map[memoryFeedbackKey]UserFeedbackOnRule
+	acks                     map[memoryOrgRuleKey]RuleAck
+	disabledRules            map[memoryOrgRuleKey]memoryRuleDisable
+	clusterRuleToggles       map[memoryClusterRuleKey]memoryRuleToggle
+	rules                    map[types.RuleID]types.Rule
+	ruleErrorKeys            map[types.RuleID]map[types.ErrorKey]memoryErrorKey
+	contentVersions          []memoryContentVersion
+}
+
+// memoryReport is the report of the cluster kept by InMemoryStorage,
+// kafkaOffset is negative when the report wasn't consumed from Kafka
+type memoryReport struct {
+	report        types.ClusterReport
+	checksum      string
+	reportedAt    time.Time
+	lastCheckedAt time.Time
+	kafkaOffset   types.KafkaOffset
+}
+
+// stored returns the report with its times and the number of rules hit by it
+func (report memoryReport) stored() types.StoredReport {
+	return newStoredReport(report.report, report.reportedAt, report.lastCheckedAt)
+}
+
+// memorySoftDeletedReport is the report of the cluster soft-deleted at deletedAt, soft-deleted
+// reports are kept apart from the live ones, so they are not visible to reads
+type memorySoftDeletedReport struct {
+	report    memoryReport
+	deletedAt time.Time
+}
+
+// memoryHistoryEntry is a single report kept in the history of the cluster
+type memoryHistoryEntry struct {
+	report        types.ClusterReport
+	lastCheckedAt time.Time
+}
+
+// memoryConsumerErrorKey identifies the consumed message whose processing failed
+type memoryConsumerErrorKey struct {
+	topic     string
+	partition int32
+	offset    types.KafkaOffset
+}
+
+// memoryFeedbackKey identifies feedback of the user on the rule or its error key hit in the cluster
+type memoryFeedbackKey struct {
+	clusterID types.ClusterName
+	ruleID    types.RuleID
+	errorKey  types.ErrorKey
+	userID    types.UserID
+}
+
+// memoryOrgRuleKey identifies the rule acked or disabled by the organization
+type memoryOrgRuleKey struct {
+	orgID  types.OrgID
+	ruleID types.RuleID
+}
+
+// memoryRuleDisable is the rule disabled by the organization with the user who disabled it
+type memoryRuleDisable struct {
+	userID     types.UserID
+	disabledAt time.Time
+}
+
+// memoryClusterRuleKey identifies the rule toggled for the cluster
+type memoryClusterRuleKey struct {
+	clusterName types.ClusterName
+	ruleID      types.RuleID
+}
+
+// memoryRuleToggle is the toggle of the rule for the cluster with the user who toggled it and why
+type memoryRuleToggle struct {
+	toggle        RuleToggle
+	userID        types.UserID
+	justification string
+	updatedAt     time.Time
+}
+
+// memoryErrorKey is the content of the error key of the rule
+type memoryErrorKey struct {
+	description string
+	generic     string
+	publishDate string
+	impact      int
+	likelihood  int
+	active      bool
+}
+
+// memoryContentVersion is the version of loaded rule content identified by its checksum
+type memoryContentVersion struct {
+	checksum      string
+	ruleChecksums map[types.RuleID]string
+	loadedAt      time.Time
+}
+
+// NewInMemory creates a new empty instance of InMemoryStorage, the history of reports
+// is not kept and the other limits have the same defaults as in DBStorage
+func NewInMemory() *InMemoryStorage {
+	return &InMemoryStorage{
+		maxFeedbackMessageLength: DefaultMaxFeedbackMessageLength,
+		contentHistoryDepth:      DefaultContentHistoryDepth,
+		reports:                  make(map[ReportKey]memoryReport),
+		softDeletedReports:       make(map[ReportKey]memorySoftDeletedReport),
+		reportHistory:            make(map[ReportKey][]memoryHistoryEntry),
+		consumerErrors:           make(map[memoryConsumerErrorKey]ConsumerError),
+		feedbacks:                make(map[memoryFeedbackKey]UserFeedbackOnRule),
+		acks:                     make(map[memoryOrgRuleKey]RuleAck),
+		disabledRules:            make(map[memoryOrgRuleKey]memoryRuleDisable),
+		clusterRuleToggles:       make(map[memoryClusterRuleKey]memoryRuleToggle),
+		rules:                    make(map[types.RuleID]types.Rule),
+		ruleErrorKeys:            make(map[types.RuleID]map[types.ErrorKey]memoryErrorKey),
+	}
+}
+
+// newInMemoryFromConfiguration creates a new instance of InMemoryStorage with limits
+// taken from the configuration, options related to databases are ignored
+func newInMemoryFromConfiguration(configuration Configuration) *InMemoryStorage {
+	storage := NewInMemory()
+
+	storage.reportHistoryDepth = configuration.ReportHistoryDepth
+	if configuration.MaxFeedbackMessageLength > 0 {
+		storage.maxFeedbackMessageLength = configuration.MaxFeedbackMessageLength
+	}
+	if configuration.ContentHistoryDepth > 0 {
+		storage.contentHistoryDepth = configuration.ContentHistoryDepth
+	}
+
+	return storage
+}
+
+// Init does nothing, there's no schema to initialize
+func (*InMemoryStorage) Init() error {
+	return nil
+}
+
+// Close does nothing, the data are kept until the storage is dropped
+func (*InMemoryStorage) Close() error {
+	return nil
+}
+
+// Ping always succeeds
+func (*InMemoryStorage) Ping() error {
+	return nil
+}
+
+// Capabilities returns that the stored items are upserted,
+// the other capabilities are specific to databases
+func (*InMemoryStorage) Capabilities() Capabilities {
+	return Capabilities{Upsert: true}
+}
+
+// sortedReportKeys returns keys of the stored reports ordered by organization and cluster
+func (storage *InMemoryStorage) sortedReportKeys() []ReportKey {
+	return sortedKeysOf(storage.reports)
+}
+
+// sortedKeysOf returns keys of the reports ordered by organization and cluster
+func sortedKeysOf(reports map[ReportKey]memoryReport) []ReportKey {
+	keys := make([]ReportKey, 0, len(reports))
+	for key := range reports {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return reportKeyLess(keys[i], keys[j])
+	})
+
+	return keys
+}
+
+// allReports returns live reports together with the soft-deleted ones, which are
+// still deleted by the hard deletion and by the cleanup of old reports
+func (storage *InMemoryStorage) allReports() map[ReportKey]memoryReport {
+	reports := make(map[ReportKey]memoryReport, len(storage.reports)+len(storage.softDeletedReports))
+	for key, report := range storage.reports {
+		reports[key] = report
+	}
+	for key, softDeleted := range storage.softDeletedReports {
+		reports[key] = softDeleted.report
+	}
+
+	return reports
+}
+
+// isSoftDeletedCluster checks whether the report of the cluster is soft-deleted in any organization
+func (storage *InMemoryStorage) isSoftDeletedCluster(clusterName types.ClusterName) bool {
+	for key := range storage.softDeletedReports {
+		if key.ClusterName == clusterName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reportKeyLess compares keys of reports by organization and cluster
+func reportKeyLess(a, b ReportKey) bool {
+	if a.OrgID != b.OrgID {
+		return a.OrgID < b.OrgID
+	}
+	return a.ClusterName < b.ClusterName
+}
+
+// ListOfOrgs returns organizations having at least one report ordered by ID
+func (storage *InMemoryStorage) ListOfOrgs() ([]types.OrgID, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	orgs := make([]types.OrgID, 0)
+
+	for _, key := range storage.sortedReportKeys() {
+		if len(orgs) == 0 || orgs[len(orgs)-1] != key.OrgID {
+			orgs = append(orgs, key.OrgID)
+		}
+	}
+
+	return orgs, nil
+}
+
+// ListOfClustersForOrg returns clusters of the organization ordered by name
+func (storage *InMemoryStorage) ListOfClustersForOrg(orgID types.OrgID) ([]types.ClusterName, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	clusters := make([]types.ClusterName, 0)
+
+	for _, key := range storage.sortedReportKeys() {
+		if key.OrgID == orgID {
+			clusters = append(clusters, key.ClusterName)
+		}
+	}
+
+	return clusters, nil
+}
+
+// ListOfClustersForOrgWithTimestamps returns clusters of the organization together with the times
+// when their reports were stored and checked, the clusters checked most recently go first
+func (storage *InMemoryStorage) ListOfClustersForOrgWithTimestamps(
+	orgID types.OrgID,
+) ([]types.ClusterListItem, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	keys := make([]ReportKey, 0)
+	for _, key := range storage.sortedReportKeys() {
+		if key.OrgID == orgID {
+			keys = append(keys, key)
+		}
+	}
+
+	// the keys are sorted by cluster name already
+	sort.SliceStable(keys, func(i, j int) bool {
+		return storage.reports[keys[i]].lastCheckedAt.After(storage.reports[keys[j]].lastCheckedAt)
+	})
+
+	clusters := make([]types.ClusterListItem, 0, len(keys))
+	for _, key := range keys {
+		report := storage.reports[key]
+		clusters = append(clusters, types.ClusterListItem{
+			Name:          key.ClusterName,
+			ReportedAt:    types.NewOptionalTimestamp(report.reportedAt),
+			LastCheckedAt: types.NewOptionalTimestamp(report.lastCheckedAt),
+		})
+	}
+
+	return clusters, nil
+}
+
+// ListClustersCheckedInRange returns clusters of the organization whose reports were last checked
+// between from and to (both inclusive), zero to means until now. The clusters checked most long ago go first.
+func (storage *InMemoryStorage) ListClustersCheckedInRange(
+	orgID types.OrgID, from, to time.Time,
+) ([]types.ClusterNameWithTimestamp, error) {
+	if to.IsZero() {
+		to = timeNow()
+	}
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	type checkedCluster struct {
+		name        types.ClusterName
+		lastChecked time.Time
+	}
+
+	checked := make([]checkedCluster, 0)
+	for _, key := range storage.sortedReportKeys() {
+		lastChecked := storage.reports[key].lastCheckedAt
+		if key.OrgID == orgID && !lastChecked.Before(from) && !lastChecked.After(to) {
+			checked = append(checked, checkedCluster{name: key.ClusterName, lastChecked: lastChecked})
+		}
+	}
+
+	// the keys are sorted by cluster name already
+	sort.SliceStable(checked, func(i, j int) bool {
+		return checked[i].lastChecked.Before(checked[j].lastChecked)
+	})
+
+	clusters := make([]types.ClusterNameWithTimestamp, 0, len(checked))
+	for _, cluster := range checked {
+		clusters = append(clusters, types.ClusterNameWithTimestamp{
+			Name:          cluster.name,
+			LastCheckedAt: types.NewOptionalTimestamp(cluster.lastChecked),
+		})
+	}
+
+	return clusters, nil
+}
+
+// ListStaleClusters returns clusters of all organizations whose reports were last checked
+// longer than olderThan ago, ordered by cluster name
+func (storage *InMemoryStorage) ListStaleClusters(olderThan time.Duration) ([]types.ClusterName, error) {
+	cutoff := timeNow().Add(-olderThan)
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	clusters := make([]types.ClusterName, 0)
+	for key, report := range storage.reports {
+		if report.lastCheckedAt.Before(cutoff) {
+			clusters = append(clusters, key.ClusterName)
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i] < clusters[j]
+	})
+
+	return clusters, nil
+}
+
+// ClustersCountPerOrg returns number of clusters of each organization
+func (storage *InMemoryStorage) ClustersCountPerOrg() (map[types.OrgID]int, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	counts := make(map[types.OrgID]int)
+
+	for key := range storage.reports {
+		counts[key.OrgID]++
+	}
+
+	return counts, nil
+}
+
+// GetOrgIDByClusterID returns the lowest ID of organization having report of the cluster,
+// ItemNotFoundError wrapping sql.ErrNoRows is returned when there's no such report, the same as returned by DBStorage
+func (storage *InMemoryStorage) GetOrgIDByClusterID(cluster types.ClusterName) (types.OrgID, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	for _, key := range storage.sortedReportKeys() {
+		if key.ClusterName == cluster {
+			return key.OrgID, nil
+		}
+	}
+
+	return 0, newItemNotFoundError(ItemKindCluster, cluster).withCause(sql.ErrNoRows)
+}
+
+// DoesClusterExist checks whether the report of the cluster is stored for any organization
+func (storage *InMemoryStorage) DoesClusterExist(clusterName types.ClusterName) (bool, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	for key := range storage.reports {
+		if key.ClusterName == clusterName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ExportReportsForOrg writes all reports of the organization ordered by cluster name
+// to the writer as newline delimited JSON
+func (storage *InMemoryStorage) ExportReportsForOrg(orgID types.OrgID, writer io.Writer) error {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	encoder := json.NewEncoder(writer)
+
+	for _, key := range storage.sortedReportKeys() {
+		if key.OrgID != orgID {
+			continue
+		}
+
+		report := storage.reports[key]
+		if err := writeExportedReport(encoder, key.OrgID, key.ClusterName, report.report, report.lastCheckedAt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportReports imports reports from newline delimited JSON produced by the export of reports
+func (storage *InMemoryStorage) ImportReports(reader io.Reader) (ImportStats, error) {
+	return importReports(storage, reader)
+}
+
+// ReadReportForCluster reads the report of the cluster of the organization
+func (storage *InMemoryStorage) ReadReportForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) (types.StoredReport, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	report, found := storage.reports[ReportKey{OrgID: orgID, ClusterName: clusterName}]
+	if !found {
+		return types.StoredReport{}, newItemNotFoundError(ItemKindReport, orgID, clusterName)
+	}
+
+	return report.stored(), nil
+}
+
+// ReadReportMetainfo reads times of the report of the cluster, Kafka offset it was consumed from
+// and the number of rules hit by it
+func (storage *InMemoryStorage) ReadReportMetainfo(
+	orgID types.OrgID, clusterName types.ClusterName,
+) (*types.ReportMetainfo, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	report, found := storage.reports[ReportKey{OrgID: orgID, ClusterName: clusterName}]
+	if !found {
+		return nil, newItemNotFoundError(ItemKindReport, orgID, clusterName)
+	}
+
+	kafkaOffset := report.kafkaOffset
+	if kafkaOffset < 0 {
+		kafkaOffset = types.UnknownKafkaOffset
+	}
+
+	return &types.ReportMetainfo{
+		ReportedAt:    types.NewOptionalTimestamp(report.reportedAt),
+		LastCheckedAt: types.NewOptionalTimestamp(report.lastCheckedAt),
+		KafkaOffset:   kafkaOffset,
+		RuleHitsCount: report.stored().Count,
+	}, nil
+}
+
+// ReadReportForClusterByClusterName reads the report of the cluster of any organization
+func (storage *InMemoryStorage) ReadReportForClusterByClusterName(
+	clusterName types.ClusterName,
+) (types.StoredReport, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	for _, key := range storage.sortedReportKeys() {
+		if key.ClusterName == clusterName {
+			return storage.reports[key].stored(), nil
+		}
+	}
+
+	return types.StoredReport{}, newItemNotFoundError(ItemKindReport, clusterName)
+}
+
+// WriteReportForCluster writes the report of the cluster with the same rules as DBStorage:
+// ErrOldReport is returned when the stored report was consumed from the same or newer
+// Kafka offset, the identical report only updates the time of the last check and the more
+// recent stored report is never overwritten. All written reports are kept in the history.
+// The report which is not older than the soft-deleted report of the cluster restores it.
+func (storage *InMemoryStorage) WriteReportForCluster(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastCheckedTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	_, err := storage.writeReport(orgID, clusterName, report, lastCheckedTime, kafkaOffset)
+	return err
+}
+
+// WriteReportsForClusters writes reports of many clusters one by one with the same rules
+// as WriteReportForCluster, reports consumed from the same or older Kafka offset than the stored
+// one are skipped. Number of written reports is returned together with BatchWriteError listing
+// clusters whose reports were not written.
+func (storage *InMemoryStorage) WriteReportsForClusters(reports []types.ReportItem) (int, error) {
+	failed := make(map[types.ClusterName]error)
+	written := 0
+
+	for _, item := range reports {
+		stored, err := storage.writeReport(item.OrgID, item.ClusterName, item.Report, item.LastCheckedAt, item.KafkaOffset)
+		switch {
+		case err == ErrOldReport:
+			// skipped like by DBStorage
+		case err != nil:
+			failed[item.ClusterName] = err
+		case stored:
+			written++
+		}
+	}
+
+	if len(failed) > 0 {
+		return written, &BatchWriteError{Errors: failed}
+	}
+
+	return written, nil
+}
+
+// writeReport writes the report of the cluster and returns whether it has been stored,
+// i.e. whether it's not older than the stored report
+func (storage *InMemoryStorage) writeReport(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastCheckedTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) (bool, error) {
+	var reportRules types.ReportRules
+
+	if err := json.Unmarshal([]byte(report), &reportRules); err != nil {
+		return false, &InvalidReportError{OrgID: orgID, ClusterName: clusterName}
+	}
+
+	checksum := reportChecksum(report)
+	key := ReportKey{OrgID: orgID, ClusterName: clusterName}
+	lastCheckedTime = lastCheckedTime.UTC()
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	stored, found := storage.reports[key]
+
+	softDeleted, softDeletedFound := storage.softDeletedReports[key]
+	if softDeletedFound {
+		stored, found = softDeleted.report, true
+	}
+
+	if found && kafkaOffset >= 0 && stored.kafkaOffset >= kafkaOffset {
+		return false, ErrOldReport
+	}
+
+	if kafkaOffset < 0 {
+		kafkaOffset = -1
+	}
+
+	newer := !found || !stored.lastCheckedAt.After(lastCheckedTime)
+	duplicate := found && newer && stored.checksum == checksum
+
+	if softDeletedFound && newer {
+		delete(storage.softDeletedReports, key)
+	}
+
+	switch {
+	case duplicate:
+		stored.lastCheckedAt = lastCheckedTime
+		stored.kafkaOffset = kafkaOffset
+		storage.reports[key] = stored
+		metrics.DuplicateReportsSkipped.Inc()
+	case newer:
+		storage.reports[key] = memoryReport{
+			report:        report,
+			checksum:      checksum,
+			reportedAt:    time.Now().UTC(),
+			lastCheckedAt: lastCheckedTime,
+			kafkaOffset:   kafkaOffset,
+		}
+		metrics.WrittenReports.Inc()
+	default:
+		log.Warn().Msgf("Storage already contains report for organization %d and cluster name %s more recent than %v",
+			orgID, clusterName, lastCheckedTime)
+	}
+
+	storage.writeReportHistory(key, report, lastCheckedTime)
+
+	return newer, nil
+}
+
+// writeReportHistory stores the report into the history of the cluster and removes
+// the oldest entries exceeding the history depth, the entry with the same time
+// of the last check is overwritten
+func (storage *InMemoryStorage) writeReportHistory(
+	key ReportKey, report types.ClusterReport, lastCheckedTime time.Time,
+) {
+	if storage.reportHistoryDepth <= 0 {
+		return
+	}
+
+	history := make([]memoryHistoryEntry, 0, len(storage.reportHistory[key])+1)
+	for _, entry := range storage.reportHistory[key] {
+		if !entry.lastCheckedAt.Equal(lastCheckedTime) {
+			history = append(history, entry)
+		}
+	}
+	history = append(history, memoryHistoryEntry{report: report, lastCheckedAt: lastCheckedTime})
+
+	// the newest entry goes first
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].lastCheckedAt.After(history[j].lastCheckedAt)
+	})
+
+	if len(history) > storage.reportHistoryDepth {
+		history = history[:storage.reportHistoryDepth]
+	}
+
+	storage.reportHistory[key] = history
+}
+
+// ReadReportHistoryForCluster reads at most limit most recent reports kept in the history
+// for the cluster, the newest report goes first
+func (storage *InMemoryStorage) ReadReportHistoryForCluster(
+	orgID types.OrgID, clusterName types.ClusterName, limit int,
+) ([]types.ReportHistoryEntry, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	history := make([]types.ReportHistoryEntry, 0)
+
+	if storage.isSoftDeletedCluster(clusterName) {
+		return history, nil
+	}
+
+	for _, entry := range storage.reportHistory[ReportKey{OrgID: orgID, ClusterName: clusterName}] {
+		if len(history) == limit {
+			break
+		}
+
+		history = append(history, types.ReportHistoryEntry{
+			Report:        entry.report,
+			LastCheckedAt: types.NewOptionalTimestamp(entry.lastCheckedAt),
+		})
+	}
+
+	return history, nil
+}
+
+// clusterHistory returns history of the cluster of all organizations, the newest report goes first
+func (storage *InMemoryStorage) clusterHistory(clusterName types.ClusterName) []types.ReportHistoryEntry {
+	var entries []memoryHistoryEntry
+
+	for key, history := range storage.reportHistory {
+		if key.ClusterName == clusterName {
+			entries = append(entries, history...)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].lastCheckedAt.After(entries[j].lastCheckedAt)
+	})
+
+	history := make([]types.ReportHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		history = append(history, types.ReportHistoryEntry{
+			Report:        entry.report,
+			LastCheckedAt: types.NewOptionalTimestamp(entry.lastCheckedAt),
+		})
+	}
+
+	return history
+}
+
+// GetHitsCountHistory returns the number of rules hit by the cluster for each of the last days
+// computed from the reports kept in the history in the same way as DBStorage does
+func (storage *InMemoryStorage) GetHitsCountHistory(
+	clusterName types.ClusterName, days int,
+) ([]types.DailyHitsCount, error) {
+	since, today, err := hitsCountHistoryPeriod(days)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	if storage.isSoftDeletedCluster(clusterName) {
+		return dailyHitsCounts(since, today, map[string]int{}), nil
+	}
+
+	var entries []memoryHistoryEntry
+
+	for key, history := range storage.reportHistory {
+		if key.ClusterName != clusterName {
+			continue
+		}
+
+		for _, entry := range history {
+			if !entry.lastCheckedAt.Before(since) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	// the later report of the same day overwrites the earlier one
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].lastCheckedAt.Before(entries[j].lastCheckedAt)
+	})
+
+	hitsCountPerDay := make(map[string]int)
+
+	for _, entry := range entries {
+		var reportRules types.ReportRules
+		if err := json.Unmarshal([]byte(entry.report), &reportRules); err != nil {
+			return nil, err
+		}
+
+		hitsCountPerDay[entry.lastCheckedAt.UTC().Format(hitsCountHistoryDateFormat)] = len(reportRules.HitRules)
+	}
+
+	return dailyHitsCounts(since, today, hitsCountPerDay), nil
+}
+
+// GetProcessingErrorsForCluster returns at most limit of the most recent failures of processing
+// of messages consumed for the cluster, the most recent failure goes first
+func (storage *InMemoryStorage) GetProcessingErrorsForCluster(
+	clusterName types.ClusterName, limit int,
+) ([]types.ProcessingError, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	var consumerErrors []ConsumerError
+
+	for _, consumerError := range storage.consumerErrors {
+		if consumerError.ClusterName != "" && consumerError.ClusterName == clusterName {
+			consumerErrors = append(consumerErrors, consumerError)
+		}
+	}
+
+	sort.SliceStable(consumerErrors, func(i, j int) bool {
+		return consumerErrors[i].ConsumedAt.After(consumerErrors[j].ConsumedAt)
+	})
+
+	processingErrors := make([]types.ProcessingError, 0)
+
+	for _, consumerError := range consumerErrors {
+		if len(processingErrors) == limit {
+			break
+		}
+
+		processingErrors = append(processingErrors, types.ProcessingError{
+			Category: consumerError.Category,
+			FailedAt: types.NewTimestamp(consumerError.ConsumedAt),
+		})
+	}
+
+	return processingErrors, nil
+}
+
+// ListConsumerErrors returns at most limit of the most recent failures of processing
+// of consumed messages, the most recent failure goes first
+func (storage *InMemoryStorage) ListConsumerErrors(limit int) ([]ConsumerError, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	consumerErrors := make([]ConsumerError, 0, len(storage.consumerErrors))
+	for _, consumerError := range storage.consumerErrors {
+		consumerErrors = append(consumerErrors, consumerError)
+	}
+
+	sort.Slice(consumerErrors, func(i, j int) bool {
+		first, second := consumerErrors[i], consumerErrors[j]
+		if !first.ConsumedAt.Equal(second.ConsumedAt) {
+			return first.ConsumedAt.After(second.ConsumedAt)
+		}
+		if first.Topic != second.Topic {
+			return first.Topic < second.Topic
+		}
+		if first.Partition != second.Partition {
+			return first.Partition < second.Partition
+		}
+		return first.Offset > second.Offset
+	})
+
+	if len(consumerErrors) > limit {
+		consumerErrors = consumerErrors[:limit]
+	}
+
+	return consumerErrors, nil
+}
+
+// GetRuleHitsForCluster returns rules hit by the latest report of the cluster
+// ordered by rule and error key
+func (storage *InMemoryStorage) GetRuleHitsForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) ([]types.RuleOnReport, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	ruleHits := make([]types.RuleOnReport, 0)
+
+	report, found := storage.reports[ReportKey{OrgID: orgID, ClusterName: clusterName}]
+	if !found {
+		return ruleHits, newItemNotFoundError(ItemKindReport, orgID, clusterName)
+	}
+
+	var reportRules types.ReportRules
+	if err := json.Unmarshal([]byte(report.report), &reportRules); err != nil {
+		return ruleHits, err
+	}
+
+	ruleHits = append(ruleHits, reportRules.HitRules...)
+	sortRuleHits(ruleHits)
+
+	return ruleHits, nil
+}
+
+// ReportsCount returns number of all stored reports, soft-deleted reports are not counted
+func (storage *InMemoryStorage) ReportsCount() (int, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	return len(storage.reports), nil
+}
+
+// GetLatestKafkaOffset returns the highest Kafka offset stored with reports,
+// 0 is returned when there is no report consumed from Kafka
+func (storage *InMemoryStorage) GetLatestKafkaOffset() (types.KafkaOffset, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	var offset types.KafkaOffset
+
+	for _, report := range storage.allReports() {
+		if report.kafkaOffset > offset {
+			offset = report.kafkaOffset
+		}
+	}
+
+	return offset, nil
+}
+
+// WriteConsumerError stores the failure of processing of the consumed message,
+// the failure of the message processed repeatedly overwrites the previous one
+func (storage *InMemoryStorage) WriteConsumerError(consumerError ConsumerError) error {
+	consumerError.ConsumedAt = consumerError.ConsumedAt.UTC()
+	consumerError.Key = truncateConsumedMessage(consumerError.Key)
+	consumerError.Message = truncateConsumedMessage(consumerError.Message)
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.consumerErrors[memoryConsumerErrorKey{
+		topic:     consumerError.Topic,
+		partition: consumerError.Partition,
+		offset:    consumerError.Offset,
+	}] = consumerError
+
+	return nil
+}
+
+// ReportsCountForOrg returns number of reports stored for the organization
+func (storage *InMemoryStorage) ReportsCountForOrg(orgID types.OrgID) (int, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	return storage.reportsCountForOrg(orgID), nil
+}
+
+// reportsCountForOrg implements ReportsCountForOrg without locking the storage
+func (storage *InMemoryStorage) reportsCountForOrg(orgID types.OrgID) int {
+	count := 0
+
+	for key := range storage.reports {
+		if key.OrgID == orgID {
+			count++
+		}
+	}
+
+	return count
+}
+
+// GetOrgStatistics returns number of clusters and the oldest and the newest time
+// of the last check of reports stored for the organization
+func (storage *InMemoryStorage) GetOrgStatistics(orgID types.OrgID) (types.OrgStats, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	var (
+		stats          types.OrgStats
+		oldest, newest time.Time
+	)
+
+	for key, report := range storage.reports {
+		if key.OrgID != orgID {
+			continue
+		}
+
+		if stats.ClusterCount == 0 || report.lastCheckedAt.Before(oldest) {
+			oldest = report.lastCheckedAt
+		}
+		if stats.ClusterCount == 0 || report.lastCheckedAt.After(newest) {
+			newest = report.lastCheckedAt
+		}
+		stats.ClusterCount++
+	}
+
+	if stats.ClusterCount == 0 {
+		return stats, nil
+	}
+
+	stats.OldestLastCheckedAt = types.NewTimestamp(oldest)
+	stats.NewestLastCheckedAt = types.NewTimestamp(newest)
+
+	return stats, nil
+}
+
+// GetClustersHittingRule returns list of all clusters whose latest report contains hit
+// of the specified rule ordered by name
+func (storage *InMemoryStorage) GetClustersHittingRule(ruleID types.RuleID) ([]types.ClusterName, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	clusters := make([]types.ClusterName, 0)
+	ruleModule := string(ruleID) + ".report"
+
+	keys := storage.sortedReportKeys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].ClusterName < keys[j].ClusterName
+	})
+
+	for _, key := range keys {
+		var reportRules types.ReportRules
+
+		if err := json.Unmarshal([]byte(storage.reports[key].report), &reportRules); err != nil {
+			log.Error().Err(err).Msgf("Unable to parse report for cluster %v", key.ClusterName)
+			continue
+		}
+
+		for _, hitRule := range reportRules.HitRules {
+			if hitRule.Module == ruleModule {
+				clusters = append(clusters, key.ClusterName)
+				break
+			}
+		}
+	}
+
+	return clusters, nil
+}
+
+// GetExistingClusters returns those of the specified clusters that have a report stored
+func (storage *InMemoryStorage) GetExistingClusters(clusterNames []types.ClusterName) ([]types.ClusterName, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	clusters := make([]types.ClusterName, 0)
+	requested := clusterSet(clusterNames)
+
+	for _, key := range storage.sortedReportKeys() {
+		if requested[key.ClusterName] {
+			clusters = append(clusters, key.ClusterName)
+		}
+	}
+
+	return clusters, nil
+}
+
+// clusterSet returns set of the cluster names
+func clusterSet(clusterNames []types.ClusterName) map[types.ClusterName]bool {
+	clusters := make(map[types.ClusterName]bool, len(clusterNames))
+	for _, clusterName := range clusterNames {
+		clusters[clusterName] = true
+	}
+
+	return clusters
+}
+
+// VoteOnRule likes or dislikes rule or its error key for cluster by user. If entry exists, it overwrites it
+func (storage *InMemoryStorage) VoteOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+	userVote UserVote,
+) error {
+	key := memoryFeedbackKey{clusterID: clusterID, ruleID: ruleID, errorKey: errorKey, userID: userID}
+
+	return storage.addOrUpdateUserFeedbackOnRuleForCluster(key, &userVote, nil)
+}
+
+// AddOrUpdateFeedbackOnRule adds feedback on rule or its error key for cluster by user.
+// If entry exists, it overwrites it
+func (storage *InMemoryStorage) AddOrUpdateFeedbackOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+	message string,
+) error {
+	key := memoryFeedbackKey{clusterID: clusterID, ruleID: ruleID, errorKey: errorKey, userID: userID}
+
+	return storage.addOrUpdateUserFeedbackOnRuleForCluster(key, nil, &message)
+}
+
+// addOrUpdateUserFeedbackOnRuleForCluster adds or updates feedback,
+// the vote and the message are updated only when their pointers are not nil
+func (storage *InMemoryStorage) addOrUpdateUserFeedbackOnRuleForCluster(
+	key memoryFeedbackKey,
+	userVotePtr *UserVote,
+	messagePtr *string,
+) error {
+	if messagePtr != nil {
+		if length := utf8.RuneCountInString(*messagePtr); length > storage.maxFeedbackMessageLength {
+			return &ValidationError{
+				ParamName: "message",
+				ErrString: fmt.Sprintf(
+					"at most %v characters expected, got %v", storage.maxFeedbackMessageLength, length,
+				),
+			}
+		}
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	now := timeNow().UTC()
+
+	feedback, found := storage.feedbacks[key]
+	if !found {
+		feedback = UserFeedbackOnRule{
+			ClusterID: key.clusterID,
+			RuleID:    key.ruleID,
+			ErrorKey:  key.errorKey,
+			UserID:    key.userID,
+			UserVote:  UserVoteNone,
+			AddedAt:   now,
+		}
+	}
+
+	feedback.UpdatedAt = now
+	if userVotePtr != nil {
+		feedback.UserVote = *userVotePtr
+	}
+	if messagePtr != nil {
+		feedback.Message = *messagePtr
+	}
+
+	storage.feedbacks[key] = feedback
+
+	metrics.FeedbackOnRules.Inc()
+
+	return nil
+}
+
+// GetUserFeedbackOnRule gets user's feedback on rule or its error key for cluster
+func (storage *InMemoryStorage) GetUserFeedbackOnRule(
+	clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+) (*UserFeedbackOnRule, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	key := memoryFeedbackKey{clusterID: clusterID, ruleID: ruleID, errorKey: errorKey, userID: userID}
+
+	feedback, found := storage.feedbacks[key]
+	if !found {
+		return nil, newItemNotFoundError(ItemKindFeedback, feedbackIDs(clusterID, ruleID, errorKey, userID)...)
+	}
+
+	return &feedback, nil
+}
+
+// ResetVoteOnRule takes back user's vote on rule or its error key for cluster. The feedback is deleted
+// when there is no message left by the user, otherwise only the vote is reset to UserVoteNone
+func (storage *InMemoryStorage) ResetVoteOnRule(
+	clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	key := memoryFeedbackKey{clusterID: clusterID, ruleID: ruleID, errorKey: errorKey, userID: userID}
+
+	feedback, found := storage.feedbacks[key]
+	switch {
+	case !found:
+	case feedback.Message == "":
+		delete(storage.feedbacks, key)
+	default:
+		feedback.UserVote = UserVoteNone
+		feedback.UpdatedAt = timeNow().UTC()
+		storage.feedbacks[key] = feedback
+	}
+
+	return nil
+}
+
+// DeleteUserFeedbackOnRule deletes user's feedback (both vote and message) on rule or its error key for cluster
+func (storage *InMemoryStorage) DeleteUserFeedbackOnRule(
+	clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	key := memoryFeedbackKey{clusterID: clusterID, ruleID: ruleID, errorKey: errorKey, userID: userID}
+
+	if _, found := storage.feedbacks[key]; !found {
+		return newItemNotFoundError(ItemKindFeedback, feedbackIDs(clusterID, ruleID, errorKey, userID)...)
+	}
+
+	delete(storage.feedbacks, key)
+
+	metrics.FeedbackOnRulesDeleted.Inc()
+
+	return nil
+}
+
+// ListFeedbacksForCluster returns feedback of all users on all rules for the cluster,
+// the most recently updated feedback goes first
+func (storage *InMemoryStorage) ListFeedbacksForCluster(clusterID types.ClusterName) ([]UserFeedbackOnRule, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	feedbacks := make([]UserFeedbackOnRule, 0)
+
+	for key, feedback := range storage.feedbacks {
+		if key.clusterID == clusterID {
+			feedbacks = append(feedbacks, feedback)
+		}
+	}
+
+	sort.SliceStable(feedbacks, func(i, j int) bool {
+		return feedbacks[i].UpdatedAt.After(feedbacks[j].UpdatedAt)
+	})
+
+	return feedbacks, nil
+}
+
+// GetUserFeedbackOnRules gets user's votes on all specified rules for cluster,
+// UserVoteNone is returned for rules without any feedback. Only votes on the whole rules are returned.
+func (storage *InMemoryStorage) GetUserFeedbackOnRules(
+	clusterID types.ClusterName, ruleIDs []types.RuleID, userID types.UserID,
+) (map[types.RuleID]UserVote, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	votes := make(map[types.RuleID]UserVote, len(ruleIDs))
+
+	for _, ruleID := range ruleIDs {
+		key := memoryFeedbackKey{clusterID: clusterID, ruleID: ruleID, userID: userID}
+		votes[ruleID] = storage.feedbacks[key].UserVote
+	}
+
+	return votes, nil
+}
+
+// GetVotesForRule counts likes and dislikes of the rule from all users for all clusters,
+// votes on error keys of the rule are counted too
+func (storage *InMemoryStorage) GetVotesForRule(ruleID types.RuleID) (likes int, dislikes int, err error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	likes, dislikes = storage.countVotes(ruleID, func(types.ClusterName) bool { return true })
+
+	return likes, dislikes, nil
+}
+
+// GetVotesForRuleByOrg counts likes and dislikes of the rule from all users
+// for clusters of the given organization
+func (storage *InMemoryStorage) GetVotesForRuleByOrg(
+	orgID types.OrgID, ruleID types.RuleID,
+) (likes int, dislikes int, err error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	likes, dislikes = storage.countVotes(ruleID, func(clusterID types.ClusterName) bool {
+		_, found := storage.reports[ReportKey{OrgID: orgID, ClusterName: clusterID}]
+		return found
+	})
+
+	return likes, dislikes, nil
+}
+
+// GetRuleFeedbackStatsForOrg summarizes feedback of users on each rule for clusters of the organization,
+// the most disliked rules go first
+func (storage *InMemoryStorage) GetRuleFeedbackStatsForOrg(orgID types.OrgID) ([]RuleFeedbackStats, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	statsPerRule := make(map[types.RuleID]*RuleFeedbackStats)
+	commentingUsers := make(map[types.RuleID]map[types.UserID]bool)
+
+	for key, feedback := range storage.feedbacks {
+		if _, found := storage.reports[ReportKey{OrgID: orgID, ClusterName: key.clusterID}]; !found {
+			continue
+		}
+
+		ruleStats, found := statsPerRule[key.ruleID]
+		if !found {
+			ruleStats = &RuleFeedbackStats{RuleID: key.ruleID}
+			statsPerRule[key.ruleID] = ruleStats
+			commentingUsers[key.ruleID] = make(map[types.UserID]bool)
+		}
+
+		switch feedback.UserVote {
+		case UserVoteLike:
+			ruleStats.Likes++
+		case UserVoteDislike:
+			ruleStats.Dislikes++
+		}
+
+		if feedback.Message != "" {
+			commentingUsers[key.ruleID][key.userID] = true
+		}
+	}
+
+	stats := make([]RuleFeedbackStats, 0, len(statsPerRule))
+	for ruleID, ruleStats := range statsPerRule {
+		ruleStats.CommentingUsers = len(commentingUsers[ruleID])
+		stats = append(stats, *ruleStats)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Dislikes != stats[j].Dislikes {
+			return stats[i].Dislikes > stats[j].Dislikes
+		}
+		return stats[i].RuleID < stats[j].RuleID
+	})
+
+	return stats, nil
+}
+
+// countVotes counts likes and dislikes of the rule for clusters accepted by the filter
+func (storage *InMemoryStorage) countVotes(
+	ruleID types.RuleID, clusterFilter func(types.ClusterName) bool,
+) (likes int, dislikes int) {
+	for key, feedback := range storage.feedbacks {
+		if key.ruleID != ruleID || !clusterFilter(key.clusterID) {
+			continue
+		}
+
+		switch feedback.UserVote {
+		case UserVoteLike:
+			likes++
+		case UserVoteDislike:
+			dislikes++
+		}
+	}
+
+	return likes, dislikes
+}
+
+// AckRuleForOrg acknowledges the rule for all clusters of the organization. When the rule
+// is already acknowledged, the user and justification are overwritten and created_at is kept
+func (storage *InMemoryStorage) AckRuleForOrg(
+	orgID types.OrgID, ruleID types.RuleID, userID types.UserID, justification string,
+) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	now := time.Now().UTC()
+	key := memoryOrgRuleKey{orgID: orgID, ruleID: ruleID}
+
+	ack, found := storage.acks[key]
+	if !found {
+		ack = RuleAck{OrgID: orgID, RuleID: ruleID, CreatedAt: now}
+	}
+
+	ack.UserID = userID
+	ack.Justification = justification
+	ack.UpdatedAt = now
+	storage.acks[key] = ack
+
+	return nil
+}
+
+// ListAcksForOrg returns all rules acknowledged by the organization,
+// the most recently updated acknowledgement goes first
+func (storage *InMemoryStorage) ListAcksForOrg(orgID types.OrgID) ([]RuleAck, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	acks := make([]RuleAck, 0)
+
+	for key, ack := range storage.acks {
+		if key.orgID == orgID {
+			acks = append(acks, ack)
+		}
+	}
+
+	sort.Slice(acks, func(i, j int) bool {
+		if !acks[i].UpdatedAt.Equal(acks[j].UpdatedAt) {
+			return acks[i].UpdatedAt.After(acks[j].UpdatedAt)
+		}
+		return acks[i].RuleID < acks[j].RuleID
+	})
+
+	return acks, nil
+}
+
+// IsRuleAckedForOrg checks whether the rule is acknowledged by the organization
+func (storage *InMemoryStorage) IsRuleAckedForOrg(orgID types.OrgID, ruleID types.RuleID) (bool, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	_, found := storage.acks[memoryOrgRuleKey{orgID: orgID, ruleID: ruleID}]
+
+	return found, nil
+}
+
+// DeleteAckForOrg takes back the acknowledgement of the rule by the organization
+func (storage *InMemoryStorage) DeleteAckForOrg(orgID types.OrgID, ruleID types.RuleID) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	key := memoryOrgRuleKey{orgID: orgID, ruleID: ruleID}
+
+	if _, found := storage.acks[key]; !found {
+		return newItemNotFoundError(ItemKindToggle, orgID, ruleID)
+	}
+
+	delete(storage.acks, key)
+
+	return nil
+}
+
+// DisableRuleForOrg disables the rule for all clusters of the organization
+func (storage *InMemoryStorage) DisableRuleForOrg(orgID types.OrgID, ruleID types.RuleID, userID types.UserID) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.disabledRules[memoryOrgRuleKey{orgID: orgID, ruleID: ruleID}] = memoryRuleDisable{
+		userID:     userID,
+		disabledAt: time.Now().UTC(),
+	}
+
+	return nil
+}
+
+// EnableRuleForOrg enables the rule disabled for all clusters of the organization,
+// enabling the rule which is not disabled does nothing
+func (storage *InMemoryStorage) EnableRuleForOrg(orgID types.OrgID, ruleID types.RuleID, userID types.UserID) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	delete(storage.disabledRules, memoryOrgRuleKey{orgID: orgID, ruleID: ruleID})
+
+	log.Info().
+		Uint64("org_id", uint64(orgID)).
+		Str("rule_id", string(ruleID)).
+		Str("user_id", string(userID)).
+		Msg("Rule enabled for organization")
+
+	return nil
+}
+
+// ListOrgDisabledRules returns IDs of rules disabled for all clusters of the organization ordered by ID
+func (storage *InMemoryStorage) ListOrgDisabledRules(orgID types.OrgID) ([]types.RuleID, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	return sortedRuleIDs(storage.disabledRulesOf(orgID)), nil
+}
+
+// disabledRulesOf returns set of rules disabled by the organization
+func (storage *InMemoryStorage) disabledRulesOf(orgID types.OrgID) map[types.RuleID]bool {
+	ruleIDs := make(map[types.RuleID]bool)
+
+	for key := range storage.disabledRules {
+		if key.orgID == orgID {
+			ruleIDs[key.ruleID] = true
+		}
+	}
+
+	return ruleIDs
+}
+
+// sortedRuleIDs returns IDs of the set of rules ordered by ID
+func sortedRuleIDs(ruleSet map[types.RuleID]bool) []types.RuleID {
+	ruleIDs := make([]types.RuleID, 0, len(ruleSet))
+	for ruleID := range ruleSet {
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+
+	sort.Slice(ruleIDs, func(i, j int) bool {
+		return ruleIDs[i] < ruleIDs[j]
+	})
+
+	return ruleIDs
+}
+
+// ToggleRuleForCluster enables or disables the rule for the cluster regardless of the rules disabled
+// for its organization, toggling the rule again replaces its previous toggle and justification
+func (storage *InMemoryStorage) ToggleRuleForCluster(
+	clusterName types.ClusterName, ruleID types.RuleID, userID types.UserID, toggle RuleToggle, justification string,
+) error {
+	if err := checkRuleToggle(toggle); err != nil {
+		return err
+	}
+
+	if err := checkJustification(justification, storage.maxFeedbackMessageLength); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.clusterRuleToggles[memoryClusterRuleKey{clusterName: clusterName, ruleID: ruleID}] = memoryRuleToggle{
+		toggle:        toggle,
+		userID:        userID,
+		justification: justification,
+		updatedAt:     timeNow().UTC(),
+	}
+
+	return nil
+}
+
+// AddFeedbackToRuleDisable replaces the justification of the rule disabled for the cluster
+// without changing its toggle, the user and time of the toggle are updated too.
+// ItemNotFoundError is returned when the rule isn't disabled for the cluster.
+func (storage *InMemoryStorage) AddFeedbackToRuleDisable(
+	clusterName types.ClusterName, ruleID types.RuleID, userID types.UserID, justification string,
+) error {
+	if err := checkJustification(justification, storage.maxFeedbackMessageLength); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	key := memoryClusterRuleKey{clusterName: clusterName, ruleID: ruleID}
+
+	toggle, found := storage.clusterRuleToggles[key]
+	if !found || toggle.toggle != RuleToggleDisable {
+		return newItemNotFoundError(ItemKindToggle, clusterName, ruleID)
+	}
+
+	toggle.userID = userID
+	toggle.justification = justification
+	toggle.updatedAt = timeNow().UTC()
+	storage.clusterRuleToggles[key] = toggle
+
+	return nil
+}
+
+// ListDisabledRulesForCluster returns rules disabled for the cluster by its toggles with their
+// justifications, the most recently updated toggle goes first
+func (storage *InMemoryStorage) ListDisabledRulesForCluster(clusterName types.ClusterName) ([]ClusterRuleDisable, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	disables := make([]ClusterRuleDisable, 0)
+
+	for key, toggle := range storage.clusterRuleToggles {
+		if key.clusterName == clusterName && toggle.toggle == RuleToggleDisable {
+			disables = append(disables, ClusterRuleDisable{
+				ClusterName:   clusterName,
+				RuleID:        key.ruleID,
+				UserID:        toggle.userID,
+				Justification: toggle.justification,
+				UpdatedAt:     toggle.updatedAt,
+			})
+		}
+	}
+
+	sort.Slice(disables, func(i, j int) bool {
+		if !disables[i].UpdatedAt.Equal(disables[j].UpdatedAt) {
+			return disables[i].UpdatedAt.After(disables[j].UpdatedAt)
+		}
+		return disables[i].RuleID < disables[j].RuleID
+	})
+
+	return disables, nil
+}
+
+// DeleteRuleToggleForCluster removes the toggle of the rule for the cluster, so the rule follows
+// the organization again. ItemNotFoundError is returned when the rule isn't toggled for the cluster.
+func (storage *InMemoryStorage) DeleteRuleToggleForCluster(clusterName types.ClusterName, ruleID types.RuleID) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	key := memoryClusterRuleKey{clusterName: clusterName, ruleID: ruleID}
+
+	if _, found := storage.clusterRuleToggles[key]; !found {
+		return newItemNotFoundError(ItemKindToggle, clusterName, ruleID)
+	}
+
+	delete(storage.clusterRuleToggles, key)
+
+	return nil
+}
+
+// GetDisabledRulesForCluster returns IDs of rules hidden in the report of the cluster ordered by ID,
+// the toggle of the cluster takes precedence over the rule disabled for the organization
+func (storage *InMemoryStorage) GetDisabledRulesForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) ([]types.RuleID, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	return sortedRuleIDs(storage.disabledRulesForCluster(orgID, clusterName)), nil
+}
+
+// disabledRulesForCluster returns set of rules disabled for the cluster by its toggles
+// or for the whole organization and not enabled by toggles of the cluster
+func (storage *InMemoryStorage) disabledRulesForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) map[types.RuleID]bool {
+	disabled := storage.disabledRulesOf(orgID)
+
+	for key, toggle := range storage.clusterRuleToggles {
+		if key.clusterName == clusterName {
+			disabled[key.ruleID] = toggle.toggle == RuleToggleDisable
+		}
+	}
+
+	for ruleID, isDisabled := range disabled {
+		if !isDisabled {
+			delete(disabled, ruleID)
+		}
+	}
+
+	return disabled
+}
+
+// GetSilencingStatsForOrg returns numbers of rules disabled and acked for the organization
+// and for its clusters and number of clusters with any rule disabled
+func (storage *InMemoryStorage) GetSilencingStatsForOrg(orgID types.OrgID) (SilencingStats, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	var stats SilencingStats
+	silenced := storage.disabledRulesOf(orgID)
+
+	for key := range storage.reports {
+		if key.OrgID != orgID {
+			continue
+		}
+
+		if len(storage.disabledRulesForCluster(orgID, key.ClusterName)) > 0 {
+			stats.ClustersWithDisabledRules++
+		}
+
+		for toggleKey, toggle := range storage.clusterRuleToggles {
+			if toggleKey.clusterName == key.ClusterName && toggle.toggle == RuleToggleDisable {
+				silenced[toggleKey.ruleID] = true
+			}
+		}
+	}
+
+	stats.DisabledRules = len(silenced)
+
+	for key := range storage.acks {
+		if key.orgID == orgID {
+			stats.AckedRules++
+			silenced[key.ruleID] = true
+		}
+	}
+
+	stats.SilencedRules = len(silenced)
+
+	return stats, nil
+}
+
+// ListDisabledRulesForOrg returns summaries of rules disabled for clusters of the organization,
+// disabled for the whole organization or acked by it ordered by rule ID
+func (storage *InMemoryStorage) ListDisabledRulesForOrg(orgID types.OrgID) ([]DisabledRuleSummary, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	var silencings []ruleSilencing
+
+	for key, toggle := range storage.clusterRuleToggles {
+		reportKey := ReportKey{OrgID: orgID, ClusterName: key.clusterName}
+		if _, found := storage.reports[reportKey]; found && toggle.toggle == RuleToggleDisable {
+			silencings = append(silencings, ruleSilencing{
+				kind:          silencedByToggle,
+				ruleID:        key.ruleID,
+				userID:        toggle.userID,
+				justification: toggle.justification,
+				updatedAt:     toggle.updatedAt,
+			})
+		}
+	}
+
+	for key, disable := range storage.disabledRules {
+		if key.orgID == orgID {
+			silencings = append(silencings, ruleSilencing{
+				kind:      silencedForOrg,
+				ruleID:    key.ruleID,
+				userID:    disable.userID,
+				updatedAt: disable.disabledAt,
+			})
+		}
+	}
+
+	for key, ack := range storage.acks {
+		if key.orgID == orgID {
+			silencings = append(silencings, ruleSilencing{
+				kind:          silencedByAck,
+				ruleID:        key.ruleID,
+				userID:        ack.UserID,
+				justification: ack.Justification,
+				updatedAt:     ack.UpdatedAt,
+			})
+		}
+	}
+
+	return summarizeDisabledRules(silencings), nil
+}
+
+// GetContentForRules retrieves content for rules that were hit in the report
+func (storage *InMemoryStorage) GetContentForRules(reportRules types.ReportRules) ([]types.RuleContentResponse, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	rules := make([]types.RuleContentResponse, 0)
+	found := make(map[string]bool)
+
+	for _, rule := range reportRules.HitRules {
+		module := strings.TrimSuffix(rule.Module, ".report")
+
+		errorKey, ok := storage.ruleErrorKeys[types.RuleID(module)][types.ErrorKey(rule.ErrorKey)]
+		if !ok || found[module+"|"+rule.ErrorKey] {
+			continue
+		}
+		found[module+"|"+rule.ErrorKey] = true
+
+		rules = append(rules, types.RuleContentResponse{
+			ErrorKey:    rule.ErrorKey,
+			RuleModule:  module,
+			Description: errorKey.description,
+			Generic:     errorKey.generic,
+			CreatedAt:   errorKey.publishDate,
+			TotalRisk:   (errorKey.impact + errorKey.likelihood) / 2,
+		})
+	}
+
+	return rules, nil
+}
+
+// DeleteReportsForOrg deletes all reports related to the specified organization from the storage
+// together with users' feedback on clusters of the organization, rules toggled for them and rules
+// acked or disabled by it
+func (storage *InMemoryStorage) DeleteReportsForOrg(orgID types.OrgID) (DeletedRows, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	var deleted DeletedRows
+
+	clusters := make(map[types.ClusterName]bool)
+	for key := range storage.allReports() {
+		if key.OrgID == orgID {
+			clusters[key.ClusterName] = true
+		}
+	}
+
+	deleted.Feedback, deleted.FeedbackMessages = storage.deleteFeedbackOfClusters(clusters)
+	deleted.Toggles = storage.deleteTogglesOfClusters(clusters)
+
+	for key := range storage.acks {
+		if key.orgID == orgID {
+			delete(storage.acks, key)
+			deleted.RuleAcks++
+		}
+	}
+
+	for key := range storage.disabledRules {
+		if key.orgID == orgID {
+			delete(storage.disabledRules, key)
+			deleted.DisabledRules++
+		}
+	}
+
+	for key, history := range storage.reportHistory {
+		if key.OrgID == orgID {
+			delete(storage.reportHistory, key)
+			deleted.ReportHistory += len(history)
+		}
+	}
+
+	for key, consumerError := range storage.consumerErrors {
+		if consumerError.OrgID == orgID {
+			delete(storage.consumerErrors, key)
+			deleted.ConsumerErrors++
+		}
+	}
+
+	deleted.Reports, deleted.RuleHits = storage.deleteReportsWithRuleHits(func(key ReportKey) bool {
+		return key.OrgID == orgID
+	})
+
+	return deleted, nil
+}
+
+// DeleteReportsForCluster deletes all reports related to the specified cluster from the storage
+// together with users' feedback on the cluster and rules toggled for it
+func (storage *InMemoryStorage) DeleteReportsForCluster(clusterName types.ClusterName) (DeletedRows, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	var deleted DeletedRows
+
+	clusters := map[types.ClusterName]bool{clusterName: true}
+	deleted.Feedback, deleted.FeedbackMessages = storage.deleteFeedbackOfClusters(clusters)
+	deleted.Toggles = storage.deleteTogglesOfClusters(clusters)
+
+	for key, history := range storage.reportHistory {
+		if key.ClusterName == clusterName {
+			delete(storage.reportHistory, key)
+			deleted.ReportHistory += len(history)
+		}
+	}
+
+	for key, consumerError := range storage.consumerErrors {
+		if consumerError.ClusterName == clusterName {
+			delete(storage.consumerErrors, key)
+			deleted.ConsumerErrors++
+		}
+	}
+
+	deleted.Reports, deleted.RuleHits = storage.deleteReportsWithRuleHits(func(key ReportKey) bool {
+		return key.ClusterName == clusterName
+	})
+
+	return deleted, nil
+}
+
+// deleteFeedbackOfClusters deletes users' feedback on the clusters and returns the number of deleted
+// feedbacks and the number of those of them with a message, as they would be stored in separate tables
+func (storage *InMemoryStorage) deleteFeedbackOfClusters(clusters map[types.ClusterName]bool) (int, int) {
+	feedbacks, messages := 0, 0
+
+	for key, feedback := range storage.feedbacks {
+		if clusters[key.clusterID] {
+			delete(storage.feedbacks, key)
+			feedbacks++
+			if feedback.Message != "" {
+				messages++
+			}
+		}
+	}
+
+	return feedbacks, messages
+}
+
+// deleteTogglesOfClusters deletes rules toggled for the clusters and returns their number
+func (storage *InMemoryStorage) deleteTogglesOfClusters(clusters map[types.ClusterName]bool) int {
+	deleted := 0
+
+	for key := range storage.clusterRuleToggles {
+		if clusters[key.clusterName] {
+			delete(storage.clusterRuleToggles, key)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// deleteReportsWithRuleHits deletes reports accepted by the filter and returns their number
+// together with the number of rules hit by them
+func (storage *InMemoryStorage) deleteReportsWithRuleHits(filter func(ReportKey) bool) (int, int) {
+	ruleHits := 0
+
+	reports := storage.deleteReports(func(key ReportKey, report memoryReport) bool {
+		if !filter(key) {
+			return false
+		}
+
+		var reportRules types.ReportRules
+		// stored reports have been validated already when they were written
+		_ = json.Unmarshal([]byte(report.report), &reportRules)
+		ruleHits += len(reportRules.HitRules)
+
+		return true
+	})
+
+	return reports, ruleHits
+}
+
+// DeleteReportsForClusters deletes reports, their history, processing errors, users' feedback
+// and rule toggles related to all specified clusters and returns clusters whose reports were deleted
+func (storage *InMemoryStorage) DeleteReportsForClusters(clusterNames []types.ClusterName) ([]types.ClusterName, error) {
+	deletedClusters := make([]types.ClusterName, 0)
+	if len(clusterNames) == 0 {
+		return deletedClusters, nil
+	}
+
+	clusters := clusterSet(clusterNames)
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.deleteClusterData(clusters)
+
+	for key, consumerError := range storage.consumerErrors {
+		if clusters[consumerError.ClusterName] {
+			delete(storage.consumerErrors, key)
+		}
+	}
+
+	storage.deleteReports(func(key ReportKey, _ memoryReport) bool {
+		if clusters[key.ClusterName] {
+			deletedClusters = append(deletedClusters, key.ClusterName)
+			return true
+		}
+		return false
+	})
+
+	sort.Slice(deletedClusters, func(i, j int) bool { return deletedClusters[i] < deletedClusters[j] })
+
+	return deletedClusters, nil
+}
+
+// deleteReports deletes reports accepted by the filter including the soft-deleted ones
+// and returns their number
+func (storage *InMemoryStorage) deleteReports(filter func(ReportKey, memoryReport) bool) int {
+	deleted := 0
+
+	for key, report := range storage.reports {
+		if filter(key, report) {
+			delete(storage.reports, key)
+			deleted++
+		}
+	}
+
+	for key, softDeleted := range storage.softDeletedReports {
+		if filter(key, softDeleted.report) {
+			delete(storage.softDeletedReports, key)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// deleteClusterData deletes history of reports, users' feedback and rule toggles of the clusters
+// of all organizations
+func (storage *InMemoryStorage) deleteClusterData(clusters map[types.ClusterName]bool) {
+	for key := range storage.feedbacks {
+		if clusters[key.clusterID] {
+			delete(storage.feedbacks, key)
+		}
+	}
+
+	storage.deleteTogglesOfClusters(clusters)
+
+	for key := range storage.reportHistory {
+		if clusters[key.ClusterName] {
+			delete(storage.reportHistory, key)
+		}
+	}
+}
+
+// SoftDeleteReportsForOrg marks all reports of the organization as deleted, so they are not visible
+// to reads until they're restored or purged, and returns number of soft-deleted reports
+func (storage *InMemoryStorage) SoftDeleteReportsForOrg(orgID types.OrgID) (int, error) {
+	return storage.softDeleteReports(func(key ReportKey) bool {
+		return key.OrgID == orgID
+	}), nil
+}
+
+// SoftDeleteReportsForCluster marks reports of the cluster as deleted, so they are not visible
+// to reads until they're restored or purged, and returns number of soft-deleted reports
+func (storage *InMemoryStorage) SoftDeleteReportsForCluster(clusterName types.ClusterName) (int, error) {
+	return storage.softDeleteReports(func(key ReportKey) bool {
+		return key.ClusterName == clusterName
+	}), nil
+}
+
+// softDeleteReports moves live reports accepted by the filter to the soft-deleted ones
+// and returns their number
+func (storage *InMemoryStorage) softDeleteReports(filter func(ReportKey) bool) int {
+	now := time.Now().UTC()
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	deleted := 0
+
+	for key, report := range storage.reports {
+		if filter(key) {
+			delete(storage.reports, key)
+			storage.softDeletedReports[key] = memorySoftDeletedReport{report: report, deletedAt: now}
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// RestoreCluster restores soft-deleted reports of the cluster, ItemNotFoundError is returned
+// when there is no soft-deleted report of the cluster
+func (storage *InMemoryStorage) RestoreCluster(clusterName types.ClusterName) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	restored := 0
+
+	for key, softDeleted := range storage.softDeletedReports {
+		if key.ClusterName == clusterName {
+			delete(storage.softDeletedReports, key)
+			storage.reports[key] = softDeleted.report
+			restored++
+		}
+	}
+
+	if restored == 0 {
+		return newItemNotFoundError(ItemKindReport, clusterName)
+	}
+
+	return nil
+}
+
+// PurgeSoftDeleted deletes reports soft-deleted longer than olderThan ago together with their history,
+// processing errors and users' feedback and returns number of deleted reports
+func (storage *InMemoryStorage) PurgeSoftDeleted(olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	purgedClusters := make(map[types.ClusterName]bool)
+	for key, softDeleted := range storage.softDeletedReports {
+		if softDeleted.deletedAt.Before(cutoff) {
+			purgedClusters[key.ClusterName] = true
+		}
+	}
+
+	storage.deleteClusterData(purgedClusters)
+
+	for key, consumerError := range storage.consumerErrors {
+		if purgedClusters[consumerError.ClusterName] {
+			delete(storage.consumerErrors, key)
+		}
+	}
+
+	purged := 0
+
+	for key, softDeleted := range storage.softDeletedReports {
+		if softDeleted.deletedAt.Before(cutoff) {
+			delete(storage.softDeletedReports, key)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// CleanupOrphanedFeedback deletes users' feedback on clusters which don't have any report anymore
+// and returns number of deleted feedbacks, feedback on clusters with soft-deleted reports is kept
+func (storage *InMemoryStorage) CleanupOrphanedFeedback() (int, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	reportedClusters := make(map[types.ClusterName]bool)
+	for key := range storage.allReports() {
+		reportedClusters[key.ClusterName] = true
+	}
+
+	deleted := 0
+
+	for key := range storage.feedbacks {
+		if !reportedClusters[key.clusterID] {
+			delete(storage.feedbacks, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// CleanupOldReports deletes reports not checked for longer than olderThan together with their history
+// and users' feedback and returns number of deleted reports
+func (storage *InMemoryStorage) CleanupOldReports(olderThan time.Duration) (int, error) {
+	return storage.cleanupReportsCheckedBefore(timeNow().Add(-olderThan), nil), nil
+}
+
+// CleanupClustersCheckedBefore deletes reports of the specified clusters last checked before the cutoff time
+// together with their history and users' feedback and returns number of deleted reports
+func (storage *InMemoryStorage) CleanupClustersCheckedBefore(
+	cutoff time.Time, clusterNames []types.ClusterName,
+) (int, error) {
+	if len(clusterNames) == 0 {
+		return 0, nil
+	}
+
+	return storage.cleanupReportsCheckedBefore(cutoff, clusterSet(clusterNames)), nil
+}
+
+// cleanupReportsCheckedBefore deletes reports last checked before the cutoff time together with
+// their history and users' feedback, only reports of the specified clusters are deleted when clusters
+// is not nil. Processing errors of messages consumed before the cutoff time are deleted as well.
+func (storage *InMemoryStorage) cleanupReportsCheckedBefore(
+	cutoff time.Time, clusters map[types.ClusterName]bool,
+) int {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	isOld := func(key ReportKey, report memoryReport) bool {
+		return (clusters == nil || clusters[key.ClusterName]) && report.lastCheckedAt.Before(cutoff)
+	}
+
+	oldClusters := make(map[types.ClusterName]bool)
+	for key, report := range storage.allReports() {
+		if isOld(key, report) {
+			oldClusters[key.ClusterName] = true
+		}
+	}
+
+	storage.deleteClusterData(oldClusters)
+
+	for key, consumerError := range storage.consumerErrors {
+		if (clusters == nil || clusters[consumerError.ClusterName]) && consumerError.ConsumedAt.Before(cutoff) {
+			delete(storage.consumerErrors, key)
+		}
+	}
+
+	return storage.deleteReports(isOld)
+}
+
+// CleanupConsumerErrors deletes failures of processing of messages consumed longer than
+// olderThan ago and returns number of deleted failures
+func (storage *InMemoryStorage) CleanupConsumerErrors(olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	deleted := 0
+	for key, consumerError := range storage.consumerErrors {
+		if consumerError.ConsumedAt.Before(cutoff) {
+			delete(storage.consumerErrors, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// ForEachReportCheckedBefore passes reports last checked before the cutoff time together with their history,
+// these are the reports which are going to be deleted by the cleanup, to the process function ordered
+// by organization and cluster. The first error returned by the process function stops the iteration.
+// The storage is locked for reading meanwhile, so the process function must not write into it.
+func (storage *InMemoryStorage) ForEachReportCheckedBefore(
+	cutoff time.Time, process func(types.ArchivedReport) error,
+) error {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	allReports := storage.allReports()
+
+	for _, key := range sortedKeysOf(allReports) {
+		report := allReports[key]
+		if !report.lastCheckedAt.Before(cutoff) {
+			continue
+		}
+
+		err := process(types.ArchivedReport{
+			OrgID:         key.OrgID,
+			ClusterName:   key.ClusterName,
+			Report:        report.report,
+			LastCheckedAt: types.NewOptionalTimestamp(report.lastCheckedAt),
+			History:       storage.clusterHistory(key.ClusterName),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadRuleContent replaces the stored rule content by the parsed one and records checksums
+// of its rules into the content history. The old content is kept when the new one is invalid.
+func (storage *InMemoryStorage) LoadRuleContent(contentDir content.RuleContentDirectory) error {
+	rules := make(map[types.RuleID]types.Rule, len(contentDir))
+	ruleErrorKeys := make(map[types.RuleID]map[types.ErrorKey]memoryErrorKey, len(contentDir))
+
+	for _, rule := range contentDir {
+		module := types.RuleID(rule.Plugin.PythonModule)
+
+		rules[module] = types.Rule{
+			Module:     module,
+			Name:       rule.Plugin.Name,
+			Summary:    string(rule.Summary),
+			Reason:     string(rule.Reason),
+			Resolution: string(rule.Resolution),
+			MoreInfo:   string(rule.MoreInfo),
+		}
+
+		errorKeys := make(map[types.ErrorKey]memoryErrorKey, len(rule.ErrorKeys))
+
+		for errName, errProperties := range rule.ErrorKeys {
+			var active bool
+			switch strings.ToLower(errProperties.Metadata.Status) {
+			case "active":
+				active = true
+			case "inactive":
+				active = false
+			default:
+				return fmt.Errorf("invalid rule error key status: '%s'", errProperties.Metadata.Status)
+			}
+
+			errorKeys[types.ErrorKey(errName)] = memoryErrorKey{
+				description: errProperties.Metadata.Description,
+				generic:     string(errProperties.Generic),
+				publishDate: errProperties.Metadata.PublishDate,
+				impact:      errProperties.Metadata.Impact,
+				likelihood:  errProperties.Metadata.Likelihood,
+				active:      active,
+			}
+		}
+
+		ruleErrorKeys[module] = errorKeys
+	}
+
+	ruleChecksums, err := content.RuleChecksums(contentDir)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := content.Checksum(ruleChecksums)
+	if err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.rules = rules
+	storage.ruleErrorKeys = ruleErrorKeys
+
+	versions := []memoryContentVersion{{checksum: checksum, ruleChecksums: ruleChecksums, loadedAt: time.Now()}}
+	for _, version := range storage.contentVersions {
+		if version.checksum != checksum && len(versions) < storage.contentHistoryDepth {
+			versions = append(versions, version)
+		}
+	}
+	storage.contentVersions = versions
+
+	log.Info().Str("checksum", checksum).Int("rules", len(ruleChecksums)).Msg("Rule content version stored")
+
+	return nil
+}
+
+// GetContentChanges returns rules added, removed or modified between two versions
+// of rule content identified by their checksums. ItemNotFoundError is returned
+// when any of the versions is not kept in the content history.
+func (storage *InMemoryStorage) GetContentChanges(fromChecksum, toChecksum string) (types.ContentChanges, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	from, err := storage.ruleChecksums(fromChecksum)
+	if err != nil {
+		return types.ContentChanges{}, err
+	}
+
+	to, err := storage.ruleChecksums(toChecksum)
+	if err != nil {
+		return types.ContentChanges{}, err
+	}
+
+	return content.DiffRuleChecksums(from, to), nil
+}
+
+// ruleChecksums returns checksums of rules of the rule content version with the checksum
+func (storage *InMemoryStorage) ruleChecksums(checksum string) (map[types.RuleID]string, error) {
+	for _, version := range storage.contentVersions {
+		if version.checksum == checksum {
+			return version.ruleChecksums, nil
+		}
+	}
+
+	return nil, newItemNotFoundError(ItemKindContentVersion, checksum)
+}
+
+// GetRuleByID gets a rule by ID
+func (storage *InMemoryStorage) GetRuleByID(ruleID types.RuleID) (*types.Rule, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	rule, found := storage.rules[ruleID]
+	if !found {
+		return nil, newItemNotFoundError(ItemKindRule, ruleID)
+	}
+
+	return &rule, nil
+}
+
+// ListRules returns rules matching the filter ordered by module
+func (storage *InMemoryStorage) ListRules(filter RuleFilter) ([]types.Rule, error) {
+	rules := make([]types.Rule, 0)
+
+	if filter.Limit < 0 {
+		return rules, &ValidationError{ParamName: "limit", ErrString: "non-negative value expected"}
+	}
+	if filter.Offset < 0 {
+		return rules, &ValidationError{ParamName: "offset", ErrString: "non-negative value expected"}
+	}
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	for ruleID, rule := range storage.rules {
+		if filter.Active != nil && storage.hasActiveErrorKey(ruleID) != *filter.Active {
+			continue
+		}
+		if !strings.HasPrefix(string(ruleID), filter.ModulePrefix) {
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Module < rules[j].Module
+	})
+
+	if filter.Offset >= len(rules) {
+		return rules[:0], nil
+	}
+	rules = rules[filter.Offset:]
+
+	if filter.Limit > 0 && filter.Limit < len(rules) {
+		rules = rules[:filter.Limit]
+	}
+
+	return rules, nil
+}
+
+// hasActiveErrorKey checks whether the rule has at least one active error key
+func (storage *InMemoryStorage) hasActiveErrorKey(ruleID types.RuleID) bool {
+	for _, errorKey := range storage.ruleErrorKeys[ruleID] {
+		if errorKey.active {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeleteRule deletes the rule together with all its error keys
+func (storage *InMemoryStorage) DeleteRule(ruleID types.RuleID) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if _, found := storage.rules[ruleID]; !found {
+		return newItemNotFoundError(ItemKindRule, ruleID)
+	}
+
+	delete(storage.rules, ruleID)
+	delete(storage.ruleErrorKeys, ruleID)
+
+	return nil
+}
+
+// DeleteRuleErrorKey deletes the error key of the rule, the rule itself is kept
+func (storage *InMemoryStorage) DeleteRuleErrorKey(ruleID types.RuleID, errorKey types.ErrorKey) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if _, found := storage.ruleErrorKeys[ruleID][errorKey]; !found {
+		return newItemNotFoundError(ItemKindErrorKey, ruleID, errorKey)
+	}
+
+	delete(storage.ruleErrorKeys[ruleID], errorKey)
+
+	return nil
+}
+
+// CheckReportsConsistency walks at most limit reports following the report identified by after,
+// rule hits are always read from the reports, so no issue is ever found
+func (storage *InMemoryStorage) CheckReportsConsistency(
+	after ReportKey, limit int, _ bool,
+) (ConsistencyCheckBatch, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	batch := ConsistencyCheckBatch{Last: after, Issues: make([]ConsistencyIssue, 0)}
+
+	for _, key := range storage.sortedReportKeys() {
+		if batch.Checked == limit {
+			break
+		}
+		if !reportKeyLess(after, key) {
+			continue
+		}
+
+		batch.Checked++
+		batch.Last = key
+	}
+
+	return batch, nil
+}
+
+// CountReportsWithNullTimestamps returns zero, both timestamps are always set
+func (*InMemoryStorage) CountReportsWithNullTimestamps() (int, error) {
+	return 0, nil
+}
+
+// ListConsistencyIssues returns empty list, no issue is ever found
+func (*InMemoryStorage) ListConsistencyIssues() ([]ConsistencyIssue, error) {
+	return make([]ConsistencyIssue, 0), nil
+}
+
+// ListAuditLog returns empty list, destructive operations are not audited
+// as all data are lost when aggregator stops anyway
+func (*InMemoryStorage) ListAuditLog(time.Time, int) ([]AuditLogEntry, error) {
+	return make([]AuditLogEntry, 0), nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+)
+
+// forEachBackend runs the test against every storage backend implementing
+// the full Storage interface, each one starting empty
+func forEachBackend(t *testing.T, test func(t *testing.T, mockStorage storage.Storage)) {
+	forEachBackendWithReportHistory(t, 0, test)
+}
+
+// forEachBackendWithReportHistory runs the test against every storage backend
+// with report history of the specified depth enabled
+func forEachBackendWithReportHistory(
+	t *testing.T, depth int, test func(t *testing.T, mockStorage storage.Storage),
+) {
+	backends := map[string]func(t *testing.T) storage.Storage{
+		"sqlite": func(t *testing.T) storage.Storage {
+			return helpers.MustGetMockStorage(t, true)
+		},
+		"memory": func(t *testing.T) storage.Storage {
+			return storage.NewInMemory()
+		},
+	}
+
+	for _, name := range []string{"sqlite", "memory"} {
+		newStorage := backends[name]
+		t.Run(name, func(t *testing.T) {
+			mockStorage := newStorage(t)
+			defer helpers.MustCloseStorage(t, mockStorage)
+
+			storage.SetReportHistoryDepth(mockStorage, depth)
+			test(t, mockStorage)
+		})
+	}
+}
+
+func TestNewInMemoryStorageFromConfiguration(t *testing.T) {
+	s, err := storage.New(storage.Configuration{
+		Driver: "memory",
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, s)
+
+	assert.IsType(t, &storage.InMemoryStorage{}, s)
+	helpers.FailOnError(t, s.Init())
+	helpers.FailOnError(t, s.Ping())
+	assert.Equal(t, storage.Capabilities{Upsert: true}, s.Capabilities())
+}
+
+func TestInMemoryStorageConfiguredLimits(t *testing.T) {
+	s, err := storage.New(storage.Configuration{
+		Driver:                   "memory",
+		MaxFeedbackMessageLength: 5,
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, s)
+
+	mustWriteReport3Rules(t, s)
+
+	helpers.FailOnError(t, s.AddOrUpdateFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "12345",
+	))
+
+	err = s.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "123456")
+	assert.EqualError(t, err, "Invalid value of 'message': at most 5 characters expected, got 6")
+}
+
+// TestInMemoryStorageInstancesAreIndependent checks that data written to one
+// in-memory storage is not visible in another one
+func TestInMemoryStorageInstancesAreIndependent(t *testing.T) {
+	first := storage.NewInMemory()
+	second := storage.NewInMemory()
+
+	helpers.FailOnError(t, first.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 1,
+	))
+
+	_, err := second.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	if _, ok := err.(*storage.ItemNotFoundError); !ok {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+}
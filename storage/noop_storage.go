@@ -0,0 +1,444 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"database/sql"
+	"io"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/content"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// NoopStorage is an implementation of Storage interface without any database, it's meant
+// for benchmarks and dry runs. Writes succeed without storing anything, reads of single
+// items return ItemNotFoundError and lists and counts are empty.
+type NoopStorage struct{}
+
+// NewNoopStorage creates a new instance of NoopStorage
+func NewNoopStorage() *NoopStorage {
+	return &NoopStorage{}
+}
+
+// Init does nothing
+func (*NoopStorage) Init() error {
+	return nil
+}
+
+// Close does nothing
+func (*NoopStorage) Close() error {
+	return nil
+}
+
+// Ping always succeeds
+func (*NoopStorage) Ping() error {
+	return nil
+}
+
+// Capabilities returns no capabilities
+func (*NoopStorage) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// ListOfOrgs returns empty list
+func (*NoopStorage) ListOfOrgs() ([]types.OrgID, error) {
+	return make([]types.OrgID, 0), nil
+}
+
+// ListOfClustersForOrg returns empty list
+func (*NoopStorage) ListOfClustersForOrg(types.OrgID) ([]types.ClusterName, error) {
+	return make([]types.ClusterName, 0), nil
+}
+
+// ListOfClustersForOrgWithTimestamps returns empty list
+func (*NoopStorage) ListOfClustersForOrgWithTimestamps(types.OrgID) ([]types.ClusterListItem, error) {
+	return make([]types.ClusterListItem, 0), nil
+}
+
+// ListClustersCheckedInRange returns empty list
+func (*NoopStorage) ListClustersCheckedInRange(
+	types.OrgID, time.Time, time.Time,
+) ([]types.ClusterNameWithTimestamp, error) {
+	return make([]types.ClusterNameWithTimestamp, 0), nil
+}
+
+// ListStaleClusters returns empty list
+func (*NoopStorage) ListStaleClusters(time.Duration) ([]types.ClusterName, error) {
+	return make([]types.ClusterName, 0), nil
+}
+
+// ClustersCountPerOrg returns no counts
+func (*NoopStorage) ClustersCountPerOrg() (map[types.OrgID]int, error) {
+	return make(map[types.OrgID]int), nil
+}
+
+// ReadReportForCluster returns ItemNotFoundError
+func (*NoopStorage) ReadReportForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) (types.StoredReport, error) {
+	return types.StoredReport{}, newItemNotFoundError(ItemKindReport, orgID, clusterName)
+}
+
+// ReadReportMetainfo returns ItemNotFoundError
+func (*NoopStorage) ReadReportMetainfo(
+	orgID types.OrgID, clusterName types.ClusterName,
+) (*types.ReportMetainfo, error) {
+	return nil, newItemNotFoundError(ItemKindReport, orgID, clusterName)
+}
+
+// ReadReportForClusterByClusterName returns ItemNotFoundError
+func (*NoopStorage) ReadReportForClusterByClusterName(
+	clusterName types.ClusterName,
+) (types.StoredReport, error) {
+	return types.StoredReport{}, newItemNotFoundError(ItemKindReport, clusterName)
+}
+
+// WriteReportForCluster succeeds without writing the report
+func (*NoopStorage) WriteReportForCluster(
+	types.OrgID, types.ClusterName, types.ClusterReport, time.Time, types.KafkaOffset,
+) error {
+	return nil
+}
+
+// WriteReportsForClusters succeeds without writing the reports
+func (*NoopStorage) WriteReportsForClusters(reports []types.ReportItem) (int, error) {
+	return len(reports), nil
+}
+
+// ReadReportHistoryForCluster returns empty history
+func (*NoopStorage) ReadReportHistoryForCluster(
+	types.OrgID, types.ClusterName, int,
+) ([]types.ReportHistoryEntry, error) {
+	return make([]types.ReportHistoryEntry, 0), nil
+}
+
+// GetHitsCountHistory returns empty history
+func (*NoopStorage) GetHitsCountHistory(types.ClusterName, int) ([]types.DailyHitsCount, error) {
+	return make([]types.DailyHitsCount, 0), nil
+}
+
+// GetProcessingErrorsForCluster returns empty list
+func (*NoopStorage) GetProcessingErrorsForCluster(types.ClusterName, int) ([]types.ProcessingError, error) {
+	return make([]types.ProcessingError, 0), nil
+}
+
+// ListConsumerErrors returns empty list
+func (*NoopStorage) ListConsumerErrors(int) ([]ConsumerError, error) {
+	return make([]ConsumerError, 0), nil
+}
+
+// GetRuleHitsForCluster returns ItemNotFoundError
+func (*NoopStorage) GetRuleHitsForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) ([]types.RuleOnReport, error) {
+	return make([]types.RuleOnReport, 0), newItemNotFoundError(ItemKindReport, orgID, clusterName)
+}
+
+// ReportsCount returns zero
+func (*NoopStorage) ReportsCount() (int, error) {
+	return 0, nil
+}
+
+// GetLatestKafkaOffset returns zero like the storage without reports
+func (*NoopStorage) GetLatestKafkaOffset() (types.KafkaOffset, error) {
+	return 0, nil
+}
+
+// WriteConsumerError succeeds without storing the error
+func (*NoopStorage) WriteConsumerError(ConsumerError) error {
+	return nil
+}
+
+// ReportsCountForOrg returns zero
+func (*NoopStorage) ReportsCountForOrg(types.OrgID) (int, error) {
+	return 0, nil
+}
+
+// GetOrgStatistics returns statistics of organization without clusters
+func (*NoopStorage) GetOrgStatistics(types.OrgID) (types.OrgStats, error) {
+	return types.OrgStats{}, nil
+}
+
+// GetClustersHittingRule returns empty list
+func (*NoopStorage) GetClustersHittingRule(types.RuleID) ([]types.ClusterName, error) {
+	return make([]types.ClusterName, 0), nil
+}
+
+// VoteOnRule succeeds without storing the vote
+func (*NoopStorage) VoteOnRule(types.ClusterName, types.RuleID, types.ErrorKey, types.UserID, UserVote) error {
+	return nil
+}
+
+// AddOrUpdateFeedbackOnRule succeeds without storing the feedback
+func (*NoopStorage) AddOrUpdateFeedbackOnRule(
+	types.ClusterName, types.RuleID, types.ErrorKey, types.UserID, string,
+) error {
+	return nil
+}
+
+// GetUserFeedbackOnRule returns ItemNotFoundError
+func (*NoopStorage) GetUserFeedbackOnRule(
+	clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+) (*UserFeedbackOnRule, error) {
+	return nil, newItemNotFoundError(ItemKindFeedback, feedbackIDs(clusterID, ruleID, errorKey, userID)...)
+}
+
+// ResetVoteOnRule succeeds without storing anything
+func (*NoopStorage) ResetVoteOnRule(types.ClusterName, types.RuleID, types.ErrorKey, types.UserID) error {
+	return nil
+}
+
+// DeleteUserFeedbackOnRule succeeds without deleting anything
+func (*NoopStorage) DeleteUserFeedbackOnRule(types.ClusterName, types.RuleID, types.ErrorKey, types.UserID) error {
+	return nil
+}
+
+// ListFeedbacksForCluster returns empty list
+func (*NoopStorage) ListFeedbacksForCluster(types.ClusterName) ([]UserFeedbackOnRule, error) {
+	return make([]UserFeedbackOnRule, 0), nil
+}
+
+// GetUserFeedbackOnRules returns no votes
+func (*NoopStorage) GetUserFeedbackOnRules(
+	types.ClusterName, []types.RuleID, types.UserID,
+) (map[types.RuleID]UserVote, error) {
+	return make(map[types.RuleID]UserVote), nil
+}
+
+// GetVotesForRule returns no votes
+func (*NoopStorage) GetVotesForRule(types.RuleID) (likes int, dislikes int, err error) {
+	return 0, 0, nil
+}
+
+// GetVotesForRuleByOrg returns no votes
+func (*NoopStorage) GetVotesForRuleByOrg(types.OrgID, types.RuleID) (likes int, dislikes int, err error) {
+	return 0, 0, nil
+}
+
+// GetRuleFeedbackStatsForOrg returns empty list
+func (*NoopStorage) GetRuleFeedbackStatsForOrg(types.OrgID) ([]RuleFeedbackStats, error) {
+	return make([]RuleFeedbackStats, 0), nil
+}
+
+// AckRuleForOrg succeeds without storing the ack
+func (*NoopStorage) AckRuleForOrg(types.OrgID, types.RuleID, types.UserID, string) error {
+	return nil
+}
+
+// ListAcksForOrg returns empty list
+func (*NoopStorage) ListAcksForOrg(types.OrgID) ([]RuleAck, error) {
+	return make([]RuleAck, 0), nil
+}
+
+// IsRuleAckedForOrg returns that the rule is not acked
+func (*NoopStorage) IsRuleAckedForOrg(types.OrgID, types.RuleID) (bool, error) {
+	return false, nil
+}
+
+// DeleteAckForOrg succeeds without deleting anything
+func (*NoopStorage) DeleteAckForOrg(types.OrgID, types.RuleID) error {
+	return nil
+}
+
+// DisableRuleForOrg succeeds without storing anything
+func (*NoopStorage) DisableRuleForOrg(types.OrgID, types.RuleID, types.UserID) error {
+	return nil
+}
+
+// EnableRuleForOrg succeeds without storing anything
+func (*NoopStorage) EnableRuleForOrg(types.OrgID, types.RuleID, types.UserID) error {
+	return nil
+}
+
+// ListOrgDisabledRules returns empty list
+func (*NoopStorage) ListOrgDisabledRules(types.OrgID) ([]types.RuleID, error) {
+	return make([]types.RuleID, 0), nil
+}
+
+// ToggleRuleForCluster succeeds without storing anything
+func (*NoopStorage) ToggleRuleForCluster(types.ClusterName, types.RuleID, types.UserID, RuleToggle, string) error {
+	return nil
+}
+
+// AddFeedbackToRuleDisable succeeds without storing anything
+func (*NoopStorage) AddFeedbackToRuleDisable(types.ClusterName, types.RuleID, types.UserID, string) error {
+	return nil
+}
+
+// ListDisabledRulesForCluster returns empty list
+func (*NoopStorage) ListDisabledRulesForCluster(types.ClusterName) ([]ClusterRuleDisable, error) {
+	return make([]ClusterRuleDisable, 0), nil
+}
+
+// DeleteRuleToggleForCluster succeeds without deleting anything
+func (*NoopStorage) DeleteRuleToggleForCluster(types.ClusterName, types.RuleID) error {
+	return nil
+}
+
+// GetDisabledRulesForCluster returns empty list
+func (*NoopStorage) GetDisabledRulesForCluster(types.OrgID, types.ClusterName) ([]types.RuleID, error) {
+	return make([]types.RuleID, 0), nil
+}
+
+// GetSilencingStatsForOrg returns zero statistics
+func (*NoopStorage) GetSilencingStatsForOrg(types.OrgID) (SilencingStats, error) {
+	return SilencingStats{}, nil
+}
+
+// ListDisabledRulesForOrg returns empty list
+func (*NoopStorage) ListDisabledRulesForOrg(types.OrgID) ([]DisabledRuleSummary, error) {
+	return make([]DisabledRuleSummary, 0), nil
+}
+
+// GetContentForRules returns no content
+func (*NoopStorage) GetContentForRules(types.ReportRules) ([]types.RuleContentResponse, error) {
+	return make([]types.RuleContentResponse, 0), nil
+}
+
+// DeleteReportsForOrg succeeds without deleting anything
+func (*NoopStorage) DeleteReportsForOrg(types.OrgID) (DeletedRows, error) {
+	return DeletedRows{}, nil
+}
+
+// DeleteReportsForCluster succeeds without deleting anything
+func (*NoopStorage) DeleteReportsForCluster(types.ClusterName) (DeletedRows, error) {
+	return DeletedRows{}, nil
+}
+
+// DeleteReportsForClusters returns that no report was deleted
+func (*NoopStorage) DeleteReportsForClusters([]types.ClusterName) ([]types.ClusterName, error) {
+	return make([]types.ClusterName, 0), nil
+}
+
+// GetExistingClusters returns that none of the clusters exists
+func (*NoopStorage) GetExistingClusters([]types.ClusterName) ([]types.ClusterName, error) {
+	return make([]types.ClusterName, 0), nil
+}
+
+// CleanupOldReports returns that no report was deleted
+func (*NoopStorage) CleanupOldReports(time.Duration) (int, error) {
+	return 0, nil
+}
+
+// SoftDeleteReportsForOrg returns that no report was soft-deleted
+func (*NoopStorage) SoftDeleteReportsForOrg(types.OrgID) (int, error) {
+	return 0, nil
+}
+
+// SoftDeleteReportsForCluster returns that no report was soft-deleted
+func (*NoopStorage) SoftDeleteReportsForCluster(types.ClusterName) (int, error) {
+	return 0, nil
+}
+
+// RestoreCluster returns ItemNotFoundError
+func (*NoopStorage) RestoreCluster(clusterName types.ClusterName) error {
+	return newItemNotFoundError(ItemKindReport, clusterName)
+}
+
+// PurgeSoftDeleted returns that no report was deleted
+func (*NoopStorage) PurgeSoftDeleted(time.Duration) (int, error) {
+	return 0, nil
+}
+
+// CleanupOrphanedFeedback returns that no feedback was deleted
+func (*NoopStorage) CleanupOrphanedFeedback() (int, error) {
+	return 0, nil
+}
+
+// CleanupConsumerErrors returns that no failure was deleted
+func (*NoopStorage) CleanupConsumerErrors(time.Duration) (int, error) {
+	return 0, nil
+}
+
+// ForEachReportCheckedBefore succeeds without passing any report
+func (*NoopStorage) ForEachReportCheckedBefore(time.Time, func(types.ArchivedReport) error) error {
+	return nil
+}
+
+// CleanupClustersCheckedBefore returns that no report was deleted
+func (*NoopStorage) CleanupClustersCheckedBefore(time.Time, []types.ClusterName) (int, error) {
+	return 0, nil
+}
+
+// LoadRuleContent succeeds without storing the content
+func (*NoopStorage) LoadRuleContent(content.RuleContentDirectory) error {
+	return nil
+}
+
+// GetContentChanges returns ItemNotFoundError, because no version of rule content is stored
+func (*NoopStorage) GetContentChanges(fromChecksum, _ string) (types.ContentChanges, error) {
+	return types.ContentChanges{}, newItemNotFoundError(ItemKindContentVersion, fromChecksum)
+}
+
+// GetRuleByID returns ItemNotFoundError
+func (*NoopStorage) GetRuleByID(ruleID types.RuleID) (*types.Rule, error) {
+	return nil, newItemNotFoundError(ItemKindRule, ruleID)
+}
+
+// ListRules returns empty list
+func (*NoopStorage) ListRules(RuleFilter) ([]types.Rule, error) {
+	return make([]types.Rule, 0), nil
+}
+
+// DeleteRule succeeds without deleting anything
+func (*NoopStorage) DeleteRule(types.RuleID) error {
+	return nil
+}
+
+// DeleteRuleErrorKey succeeds without deleting anything
+func (*NoopStorage) DeleteRuleErrorKey(types.RuleID, types.ErrorKey) error {
+	return nil
+}
+
+// GetOrgIDByClusterID returns ItemNotFoundError
+func (*NoopStorage) GetOrgIDByClusterID(cluster types.ClusterName) (types.OrgID, error) {
+	return 0, newItemNotFoundError(ItemKindCluster, cluster).withCause(sql.ErrNoRows)
+}
+
+// DoesClusterExist returns false, there are no clusters
+func (*NoopStorage) DoesClusterExist(types.ClusterName) (bool, error) {
+	return false, nil
+}
+
+// ExportReportsForOrg writes nothing, there are no reports to export
+func (*NoopStorage) ExportReportsForOrg(types.OrgID, io.Writer) error {
+	return nil
+}
+
+// ImportReports reads and validates the reports, every valid report is counted as inserted
+func (storage *NoopStorage) ImportReports(reader io.Reader) (ImportStats, error) {
+	return importReports(storage, reader)
+}
+
+// CheckReportsConsistency returns empty batch, so the check ends immediately
+func (*NoopStorage) CheckReportsConsistency(after ReportKey, _ int, _ bool) (ConsistencyCheckBatch, error) {
+	return ConsistencyCheckBatch{Last: after, Issues: make([]ConsistencyIssue, 0)}, nil
+}
+
+// CountReportsWithNullTimestamps returns zero
+func (*NoopStorage) CountReportsWithNullTimestamps() (int, error) {
+	return 0, nil
+}
+
+// ListConsistencyIssues returns empty list
+func (*NoopStorage) ListConsistencyIssues() ([]ConsistencyIssue, error) {
+	return make([]ConsistencyIssue, 0), nil
+}
+
+// ListAuditLog returns empty list
+func (*NoopStorage) ListAuditLog(time.Time, int) ([]AuditLogEntry, error) {
+	return make([]AuditLogEntry, 0), nil
+}
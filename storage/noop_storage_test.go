@@ -0,0 +1,298 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// assertItemNotFound checks that the error is ItemNotFoundError of the item of the kind identified by ids
+func assertItemNotFound(t *testing.T, err error, kind storage.ItemKind, ids ...interface{}) {
+	assert.True(t, errors.Is(err, storage.ErrNotFound), "expected ErrNotFound, got %T, %+v", err, err)
+
+	var notFound *storage.ItemNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+
+	assert.Equal(t, kind, notFound.Kind)
+	assert.Equal(t, ids, notFound.IDs)
+}
+
+func TestNewNoopStorageFromConfiguration(t *testing.T) {
+	s, err := storage.New(storage.Configuration{
+		Driver: "noop",
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, s)
+
+	assert.IsType(t, &storage.NoopStorage{}, s)
+	helpers.FailOnError(t, s.Init())
+	helpers.FailOnError(t, s.Ping())
+	assert.Equal(t, storage.Capabilities{}, s.Capabilities())
+}
+
+func TestNoopStorageWritesSucceed(t *testing.T) {
+	s := storage.NewNoopStorage()
+
+	helpers.FailOnError(t, s.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 1,
+	))
+	helpers.FailOnError(t, s.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike))
+	helpers.FailOnError(t, s.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "msg"))
+	helpers.FailOnError(t, s.ResetVoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID))
+	helpers.FailOnError(t, s.DeleteUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID))
+	helpers.FailOnError(t, s.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID, "justification"))
+	helpers.FailOnError(t, s.DeleteAckForOrg(testdata.OrgID, testdata.Rule1ID))
+	helpers.FailOnError(t, s.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+	helpers.FailOnError(t, s.EnableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+	helpers.FailOnError(t, s.ToggleRuleForCluster(
+		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+	))
+	helpers.FailOnError(t, s.AddFeedbackToRuleDisable(testdata.ClusterName, testdata.Rule1ID, testdata.UserID, "reason"))
+	helpers.FailOnError(t, s.DeleteRuleToggleForCluster(testdata.ClusterName, testdata.Rule1ID))
+	helpers.FailOnError(t, s.LoadRuleContent(testdata.RuleContent3Rules))
+	helpers.FailOnError(t, s.DeleteRule(testdata.Rule1ID))
+	helpers.FailOnError(t, s.DeleteRuleErrorKey(testdata.Rule1ID, testdata.ErrorKey1))
+	_, err := s.DeleteReportsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	_, err = s.DeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	helpers.FailOnError(t, s.WriteConsumerError(storage.ConsumerError{ClusterName: testdata.ClusterName}))
+
+	deletedClusters, err := s.DeleteReportsForClusters([]types.ClusterName{testdata.ClusterName})
+	helpers.FailOnError(t, err)
+	assert.Empty(t, deletedClusters)
+
+	deleted, err := s.CleanupOldReports(time.Hour)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = s.CleanupClustersCheckedBefore(time.Now(), []types.ClusterName{testdata.ClusterName})
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = s.CleanupConsumerErrors(time.Hour)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = s.SoftDeleteReportsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = s.SoftDeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = s.PurgeSoftDeleted(time.Hour)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	consumerErrors, err := s.ListConsumerErrors(10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, consumerErrors)
+
+	// nothing written is read back
+	count, err := s.ReportsCount()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestNoopStorageReadsNotFound(t *testing.T) {
+	s := storage.NewNoopStorage()
+
+	_, err := s.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+
+	_, err = s.ReadReportForClusterByClusterName(testdata.ClusterName)
+	assertItemNotFound(t, err, storage.ItemKindReport, testdata.ClusterName)
+
+	_, err = s.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
+	assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+
+	_, err = s.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+
+	_, err = s.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	assertItemNotFound(t, err, storage.ItemKindFeedback, testdata.ClusterName, testdata.Rule1ID, testdata.UserID)
+
+	_, err = s.GetRuleByID(testdata.Rule1ID)
+	assertItemNotFound(t, err, storage.ItemKindRule, testdata.Rule1ID)
+
+	_, err = s.GetContentChanges("checksum1", "checksum2")
+	assertItemNotFound(t, err, storage.ItemKindContentVersion, "checksum1")
+
+	_, err = s.GetOrgIDByClusterID(testdata.ClusterName)
+	assertItemNotFound(t, err, storage.ItemKindCluster, testdata.ClusterName)
+	assert.True(t, errors.Is(err, sql.ErrNoRows))
+
+	err = s.RestoreCluster(testdata.ClusterName)
+	assertItemNotFound(t, err, storage.ItemKindReport, testdata.ClusterName)
+}
+
+func TestNoopStorageListsAreEmpty(t *testing.T) {
+	s := storage.NewNoopStorage()
+
+	orgs, err := s.ListOfOrgs()
+	helpers.FailOnError(t, err)
+	assert.Empty(t, orgs)
+
+	clusters, err := s.ListOfClustersForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, clusters)
+
+	clustersWithTimestamps, err := s.ListOfClustersForOrgWithTimestamps(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, clustersWithTimestamps)
+
+	clustersPerOrg, err := s.ClustersCountPerOrg()
+	helpers.FailOnError(t, err)
+	assert.Empty(t, clustersPerOrg)
+
+	history, err := s.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, history)
+
+	hitsHistory, err := s.GetHitsCountHistory(testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, hitsHistory)
+
+	processingErrors, err := s.GetProcessingErrorsForCluster(testdata.ClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, processingErrors)
+
+	clusters, err = s.GetClustersHittingRule(testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, clusters)
+
+	feedbacks, err := s.ListFeedbacksForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, feedbacks)
+
+	votes, err := s.GetUserFeedbackOnRules(testdata.ClusterName, []types.RuleID{testdata.Rule1ID}, testdata.UserID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, votes)
+
+	acks, err := s.ListAcksForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, acks)
+
+	disabledRules, err := s.ListOrgDisabledRules(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, disabledRules)
+
+	disabledRules, err = s.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, disabledRules)
+
+	ruleDisables, err := s.ListDisabledRulesForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, ruleDisables)
+
+	disabledRuleSummaries, err := s.ListDisabledRulesForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, disabledRuleSummaries)
+
+	ruleContent, err := s.GetContentForRules(types.ReportRules{})
+	helpers.FailOnError(t, err)
+	assert.Empty(t, ruleContent)
+
+	clusters, err = s.GetExistingClusters([]types.ClusterName{testdata.ClusterName})
+	helpers.FailOnError(t, err)
+	assert.Empty(t, clusters)
+
+	err = s.ForEachReportCheckedBefore(time.Now(), func(types.ArchivedReport) error {
+		return errors.New("no report expected")
+	})
+	helpers.FailOnError(t, err)
+
+	rules, err := s.ListRules(storage.RuleFilter{})
+	helpers.FailOnError(t, err)
+	assert.Empty(t, rules)
+
+	issues, err := s.ListConsistencyIssues()
+	helpers.FailOnError(t, err)
+	assert.Empty(t, issues)
+
+	auditLog, err := s.ListAuditLog(time.Time{}, 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, auditLog)
+}
+
+func TestNoopStorageCountsAreZero(t *testing.T) {
+	s := storage.NewNoopStorage()
+
+	count, err := s.ReportsCountForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, count)
+
+	count, err = s.CountReportsWithNullTimestamps()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, count)
+
+	offset, err := s.GetLatestKafkaOffset()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.KafkaOffset(0), offset)
+
+	stats, err := s.GetOrgStatistics(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.OrgStats{}, stats)
+
+	likes, dislikes, err := s.GetVotesForRule(testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, likes)
+	assert.Equal(t, 0, dislikes)
+
+	likes, dislikes, err = s.GetVotesForRuleByOrg(testdata.OrgID, testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, likes)
+	assert.Equal(t, 0, dislikes)
+
+	acked, err := s.IsRuleAckedForOrg(testdata.OrgID, testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.False(t, acked)
+
+	exists, err := s.DoesClusterExist(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.False(t, exists)
+
+	silencingStats, err := s.GetSilencingStatsForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, storage.SilencingStats{}, silencingStats)
+}
+
+// TestNoopStorageCheckReportsConsistency checks that the consistency check ends after the first batch
+func TestNoopStorageCheckReportsConsistency(t *testing.T) {
+	s := storage.NewNoopStorage()
+	after := storage.ReportKey{OrgID: testdata.OrgID, ClusterName: testdata.ClusterName}
+
+	batch, err := s.CheckReportsConsistency(after, 100, true)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, batch.Checked)
+	assert.Equal(t, after, batch.Last)
+	assert.Empty(t, batch.Issues)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// orgIDCache keeps organizations of recently looked up clusters, at most size clusters are kept
+// and the least recently used one is evicted first. Entries older than ttl are not used, zero ttl
+// means they don't expire. It's shared by all copies of DBStorage, so it's safe for concurrent use.
+// Nil cache is disabled, it never finds anything.
+type orgIDCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[types.ClusterName]*list.Element
+	order   *list.List
+}
+
+// orgIDCacheEntry is an element of the usage order of the cache, the most recently used is the front one
+type orgIDCacheEntry struct {
+	cluster types.ClusterName
+	orgID   types.OrgID
+	addedAt time.Time
+}
+
+// newOrgIDCache creates an empty cache for size clusters, the cache is disabled (nil) when size is not positive
+func newOrgIDCache(size int, ttl time.Duration) *orgIDCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &orgIDCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[types.ClusterName]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached organization of the cluster, hits and misses are counted by metrics
+func (cache *orgIDCache) get(cluster types.ClusterName) (types.OrgID, bool) {
+	if cache == nil {
+		return 0, false
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, found := cache.entries[cluster]
+	if found {
+		entry := element.Value.(*orgIDCacheEntry)
+		if cache.ttl <= 0 || cache.now().Sub(entry.addedAt) < cache.ttl {
+			cache.order.MoveToFront(element)
+			metrics.OrgIDCacheHits.Inc()
+			return entry.orgID, true
+		}
+
+		cache.removeElement(element)
+	}
+
+	metrics.OrgIDCacheMisses.Inc()
+	return 0, false
+}
+
+// add stores the organization of the cluster and evicts the least recently used cluster when the cache is full
+func (cache *orgIDCache) add(cluster types.ClusterName, orgID types.OrgID) {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, found := cache.entries[cluster]; found {
+		cache.removeElement(element)
+	}
+
+	cache.entries[cluster] = cache.order.PushFront(&orgIDCacheEntry{
+		cluster: cluster,
+		orgID:   orgID,
+		addedAt: cache.now(),
+	})
+
+	if cache.order.Len() > cache.size {
+		cache.removeElement(cache.order.Back())
+	}
+}
+
+// forget removes the clusters from the cache
+func (cache *orgIDCache) forget(clusters ...types.ClusterName) {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for _, cluster := range clusters {
+		if element, found := cache.entries[cluster]; found {
+			cache.removeElement(element)
+		}
+	}
+}
+
+// forgetUnlessOrg removes the cluster from the cache when it's cached for another organization,
+// a report written for the same organization doesn't change the cached value
+func (cache *orgIDCache) forgetUnlessOrg(cluster types.ClusterName, orgID types.OrgID) {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, found := cache.entries[cluster]; found && element.Value.(*orgIDCacheEntry).orgID != orgID {
+		cache.removeElement(element)
+	}
+}
+
+// purge removes all clusters from the cache
+func (cache *orgIDCache) purge() {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries = make(map[types.ClusterName]*list.Element)
+	cache.order.Init()
+}
+
+// removeElement removes the element from both the usage order and the index, the mutex has to be locked
+func (cache *orgIDCache) removeElement(element *list.Element) {
+	cache.order.Remove(element)
+	delete(cache.entries, element.Value.(*orgIDCacheEntry).cluster)
+}
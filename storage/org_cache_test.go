@@ -0,0 +1,148 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	prom_models "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const orgIDQuery = "SELECT org_id FROM report WHERE cluster = \\$1"
+
+func getOrgIDCacheCounterValue(t *testing.T, counter prometheus.Counter) float64 {
+	pb := &prom_models.Metric{}
+	helpers.FailOnError(t, counter.Write(pb))
+
+	return pb.GetCounter().GetValue()
+}
+
+func expectOrgIDQuery(expects sqlmock.Sqlmock, clusterName types.ClusterName, orgID types.OrgID) {
+	expects.ExpectQuery(orgIDQuery).
+		WithArgs(clusterName).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(int64(orgID)))
+}
+
+// TestDBStorageGetOrgIDByClusterIDCached checks that the second lookup of the cluster doesn't query the database
+func TestDBStorageGetOrgIDByClusterIDCached(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+	storage.SetOrgIDCache(mockStorage.(*storage.DBStorage), 10, 0, time.Now)
+
+	hitsBefore := getOrgIDCacheCounterValue(t, metrics.OrgIDCacheHits)
+	missesBefore := getOrgIDCacheCounterValue(t, metrics.OrgIDCacheMisses)
+
+	expectOrgIDQuery(expects, testdata.ClusterName, testdata.OrgID)
+
+	for i := 0; i < 2; i++ {
+		orgID, err := mockStorage.GetOrgIDByClusterID(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.OrgID, orgID)
+	}
+
+	assert.Equal(t, hitsBefore+1, getOrgIDCacheCounterValue(t, metrics.OrgIDCacheHits))
+	assert.Equal(t, missesBefore+1, getOrgIDCacheCounterValue(t, metrics.OrgIDCacheMisses))
+}
+
+// TestDBStorageGetOrgIDByClusterIDNotFoundNotCached checks that unknown clusters are looked up in the database again
+func TestDBStorageGetOrgIDByClusterIDNotFoundNotCached(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+	storage.SetOrgIDCache(mockStorage.(*storage.DBStorage), 10, 0, time.Now)
+
+	for i := 0; i < 2; i++ {
+		expects.ExpectQuery(orgIDQuery).WillReturnError(sql.ErrNoRows)
+
+		_, err := mockStorage.GetOrgIDByClusterID(testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindCluster, testdata.ClusterName)
+	}
+}
+
+// TestDBStorageGetOrgIDByClusterIDCacheExpired checks that clusters are looked up in the database again
+// after the TTL of the cache and when they were evicted by more recently used clusters
+func TestDBStorageGetOrgIDByClusterIDCacheExpired(t *testing.T) {
+	const otherClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	now := testdata.LastCheckedAt
+	storage.SetOrgIDCache(mockStorage.(*storage.DBStorage), 1, time.Minute, func() time.Time { return now })
+
+	expectOrgIDQuery(expects, testdata.ClusterName, testdata.OrgID)
+	expectOrgIDQuery(expects, testdata.ClusterName, testdata.OrgID)
+	expectOrgIDQuery(expects, otherClusterName, testdata.OrgID)
+	expectOrgIDQuery(expects, testdata.ClusterName, testdata.OrgID)
+
+	for _, lookup := range []struct {
+		clusterName types.ClusterName
+		elapsed     time.Duration
+	}{
+		{testdata.ClusterName, 0},
+		{testdata.ClusterName, 59 * time.Second},
+		// expired
+		{testdata.ClusterName, time.Second},
+		// evicts the first cluster
+		{otherClusterName, 0},
+		{testdata.ClusterName, 0},
+	} {
+		now = now.Add(lookup.elapsed)
+
+		_, err := mockStorage.GetOrgIDByClusterID(lookup.clusterName)
+		helpers.FailOnError(t, err)
+	}
+}
+
+// TestDBStorageGetOrgIDByClusterIDCacheInvalidated checks that the cached organization isn't used
+// after the cluster is deleted or its report is written for an organization with lower ID
+func TestDBStorageGetOrgIDByClusterIDCacheInvalidated(t *testing.T) {
+	const higherOrgID = types.OrgID(5)
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	storage.SetOrgIDCache(mockStorage.(*storage.DBStorage), 10, 0, time.Now)
+
+	writeReportForCluster(t, mockStorage, higherOrgID, testdata.ClusterName, testClusterEmptyReport)
+
+	orgID, err := mockStorage.GetOrgIDByClusterID(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, higherOrgID, orgID)
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testClusterEmptyReport)
+
+	orgID, err = mockStorage.GetOrgIDByClusterID(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, testdata.OrgID, orgID)
+
+	_, err = mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	_, err = mockStorage.GetOrgIDByClusterID(testdata.ClusterName)
+	assert.True(t, errors.Is(err, sql.ErrNoRows))
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oteltracing contains tracer of storage operations creating spans of OpenTelemetry,
+// it's kept outside of the storage package, so only its users depend on OpenTelemetry
+package oteltracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanNamePrefix is the prefix of names of spans of storage operations
+const spanNamePrefix = "storage."
+
+// Tracer creates span of OpenTelemetry for each operation of the storage, the span
+// is the child of the span of the context set by DBStorage.WithContext. The span
+// of the failed operation records the error and it has the error status.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns tracer of storage operations creating spans by the tracer of OpenTelemetry
+func NewTracer(tracer trace.Tracer) Tracer {
+	return Tracer{tracer: tracer}
+}
+
+// StartOperation starts the span of the operation
+func (adapter Tracer) StartOperation(ctx context.Context, operation string) context.Context {
+	ctx, _ = adapter.tracer.Start(ctx, spanNamePrefix+operation, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx
+}
+
+// EndOperation ends the span of the operation
+func (adapter Tracer) EndOperation(ctx context.Context, operation string, err error) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,107 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oteltracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/storage/oteltracing"
+)
+
+// check that the adapter can be set as the tracer of the storage
+var _ storage.Tracer = oteltracing.Tracer{}
+
+// recordedSpan is the span started by recordingTracer, methods which aren't recorded
+// are provided by the embedded span doing nothing
+type recordedSpan struct {
+	trace.Span
+	name   string
+	kind   trace.SpanKind
+	errs   []error
+	status codes.Code
+	ended  bool
+}
+
+func (span *recordedSpan) RecordError(err error, _ ...trace.EventOption) {
+	span.errs = append(span.errs, err)
+}
+
+func (span *recordedSpan) SetStatus(code codes.Code, _ string) {
+	span.status = code
+}
+
+func (span *recordedSpan) End(_ ...trace.SpanEndOption) {
+	span.ended = true
+}
+
+// recordingTracer is the tracer of OpenTelemetry recording all started spans
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (tracer *recordingTracer) Start(
+	ctx context.Context, name string, opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	span := &recordedSpan{
+		Span: trace.SpanFromContext(context.Background()),
+		name: name,
+		kind: trace.NewSpanStartConfig(opts...).SpanKind(),
+	}
+	tracer.spans = append(tracer.spans, span)
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// TestTracerSucceededOperation checks that the span of the operation is named after it and it's ended
+func TestTracerSucceededOperation(t *testing.T) {
+	otelTracer := &recordingTracer{}
+	tracer := oteltracing.NewTracer(otelTracer)
+
+	ctx := tracer.StartOperation(context.Background(), "ReadReportForCluster")
+	tracer.EndOperation(ctx, "ReadReportForCluster", nil)
+
+	assert.Len(t, otelTracer.spans, 1)
+	span := otelTracer.spans[0]
+	assert.Equal(t, "storage.ReadReportForCluster", span.name)
+	assert.Equal(t, trace.SpanKindClient, span.kind)
+	assert.Empty(t, span.errs)
+	assert.Equal(t, codes.Unset, span.status)
+	assert.True(t, span.ended)
+}
+
+// TestTracerFailedOperation checks that the span of the failed operation records the error
+func TestTracerFailedOperation(t *testing.T) {
+	otelTracer := &recordingTracer{}
+	tracer := oteltracing.NewTracer(otelTracer)
+	operationErr := errors.New("database is locked")
+
+	ctx := tracer.StartOperation(context.Background(), "WriteReportForCluster")
+	tracer.EndOperation(ctx, "WriteReportForCluster", operationErr)
+
+	assert.Len(t, otelTracer.spans, 1)
+	span := otelTracer.spans[0]
+	assert.Equal(t, []error{operationErr}, span.errs)
+	assert.Equal(t, codes.Error, span.status)
+	assert.True(t, span.ended)
+}
@@ -0,0 +1,413 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// The report table can be partitioned by hash of org_id on PostgreSQL. The partitioned table
+// has the same columns and the same primary key, so all queries work unchanged, but it can't keep
+// the uniqueness of cluster column, because unique constraints of partitioned tables have to include
+// the partition key. Foreign keys referencing report(cluster) are dropped for the same reason,
+// rows referencing reports are deleted explicitly by all deletions of reports anyway.
+//
+// Init creates the partitioned table when the report table is still empty, the reports
+// of an already used database are copied into it by PartitionReports.
+
+// DefaultReportPartitions is the number of partitions of the report table used
+// when its partitioning is enabled, but the number isn't configured
+const DefaultReportPartitions = 16
+
+// DefaultPartitionReportsBatchSize is the number of reports copied by a single statement
+// of PartitionReports when the batch size isn't given
+const DefaultPartitionReportsBatchSize = 10000
+
+const (
+	// partitionedReportTable is the name of the partitioned table until it replaces the report table
+	partitionedReportTable = "report_partitioned"
+	// unpartitionedReportTable is the name the original report table gets when it's replaced,
+	// it's kept, so it's possible to go back to it, and it can be dropped when it's not needed
+	unpartitionedReportTable = "report_unpartitioned"
+)
+
+// ErrReportPartitioningDisabled is returned by PartitionReports when partitioning of the report table
+// isn't enabled in the configuration of the storage
+var ErrReportPartitioningDisabled = errors.New("partitioning of the report table is not enabled")
+
+// reportPartitionsOf returns the configured number of partitions of the report table,
+// zero means the table is not partitioned, which is always the case for other databases than PostgreSQL
+func reportPartitionsOf(configuration Configuration, driverType DBDriver) int {
+	if !configuration.PGPartitionReports || driverType != DBDriverPostgres {
+		return 0
+	}
+
+	if configuration.PGReportPartitions > 0 {
+		return configuration.PGReportPartitions
+	}
+
+	return DefaultReportPartitions
+}
+
+// reportPartitionName returns name of the partition of the report table holding reports
+// of organizations with the given remainder of hash of org_id
+func reportPartitionName(remainder int) string {
+	return fmt.Sprintf("report_p%d", remainder)
+}
+
+// partitionedReportTableStatements returns statements creating the table with the same columns
+// as the report table partitioned by hash of org_id into the given number of partitions,
+// together with indexes of the report table. Already existing tables and indexes are kept.
+func partitionedReportTableStatements(table string, partitions int) []string {
+	statements := []string{
+		fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %v (LIKE report INCLUDING DEFAULTS, PRIMARY KEY (org_id, cluster)) "+
+				"PARTITION BY HASH (org_id)",
+			table,
+		),
+	}
+
+	for remainder := 0; remainder < partitions; remainder++ {
+		statements = append(statements, fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %v PARTITION OF %v FOR VALUES WITH (MODULUS %d, REMAINDER %d)",
+			reportPartitionName(remainder), table, partitions, remainder,
+		))
+	}
+
+	return append(statements, fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS %v_org_last_checked_idx ON %v (org_id, last_checked_at)", table, table,
+	))
+}
+
+// replaceReportTableStatements returns statements renaming the report table to unpartitionedReportTable
+// and the partitioned table to report. Names of the primary key and indexes are swapped too,
+// so they stay the same as the ones created by migrations.
+func replaceReportTableStatements() []string {
+	return []string{
+		"ALTER TABLE report RENAME TO " + unpartitionedReportTable,
+		"ALTER TABLE " + unpartitionedReportTable + " RENAME CONSTRAINT report_pkey TO " + unpartitionedReportTable + "_pkey",
+		"ALTER INDEX IF EXISTS report_org_last_checked_idx RENAME TO " + unpartitionedReportTable + "_org_last_checked_idx",
+		"ALTER TABLE " + partitionedReportTable + " RENAME TO report",
+		"ALTER TABLE report RENAME CONSTRAINT " + partitionedReportTable + "_pkey TO report_pkey",
+		"ALTER INDEX " + partitionedReportTable + "_org_last_checked_idx RENAME TO report_org_last_checked_idx",
+	}
+}
+
+// reportKey is the primary key of the report table
+type reportKey struct {
+	orgID       types.OrgID
+	clusterName types.ClusterName
+}
+
+// reportKeyRange returns condition selecting reports with keys after the first key up to the last one
+// including it, the range isn't limited from the side of the key which is nil
+func reportKeyRange(args *queryArgs, after, upTo *reportKey) string {
+	conditions := []string{"TRUE"}
+
+	if after != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"(org_id, cluster) > (%v, %v)", args.add(after.orgID), args.add(after.clusterName),
+		))
+	}
+	if upTo != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"(org_id, cluster) <= (%v, %v)", args.add(upTo.orgID), args.add(upTo.clusterName),
+		))
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// reportTablePartitioning returns whether the report table is partitioned and the number of its partitions
+func (storage DBStorage) reportTablePartitioning(ctx context.Context) (partitioned bool, partitions int, err error) {
+	err = storage.connection.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'report'::regclass),
+			(SELECT COUNT(*) FROM pg_inherits WHERE inhparent = 'report'::regclass)
+	`).Scan(&partitioned, &partitions)
+
+	return partitioned, partitions, err
+}
+
+// isReportTablePartitioned checks whether the report table is already partitioned
+func (storage DBStorage) isReportTablePartitioned() (partitioned bool, err error) {
+	op := storage.startOperation("IsReportTablePartitioned", fastRead)
+	defer op.finish(&err)
+
+	partitioned, _, err = storage.reportTablePartitioning(op.ctx)
+
+	return partitioned, err
+}
+
+// dropForeignKeysToReports drops foreign keys referencing the report table,
+// the partitioned table doesn't have the unique key of cluster they reference
+func dropForeignKeysToReports(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT conrelid::regclass::text, conname FROM pg_constraint
+		WHERE contype = 'f' AND confrelid = 'report'::regclass
+	`)
+	if err != nil {
+		return err
+	}
+
+	var statements []string
+	for rows.Next() {
+		var table, constraint string
+		if err := rows.Scan(&table, &constraint); err != nil {
+			closeRows(rows)
+			return err
+		}
+
+		statements = append(statements, fmt.Sprintf(
+			"ALTER TABLE %v DROP CONSTRAINT %v", table, pq.QuoteIdentifier(constraint),
+		))
+	}
+	closeRows(rows)
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return execInTx(ctx, tx, statements)
+}
+
+// execInTx executes the statements one by one in the transaction, the first error is returned
+func execInTx(ctx context.Context, tx *sql.Tx, statements []string) error {
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// initReportPartitions partitions the report table when its partitioning is enabled
+// and the table is still empty. A warning is logged when the table has any reports
+// or when it has different number of partitions than configured, the table is used as it is.
+func (storage DBStorage) initReportPartitions() (err error) {
+	if storage.reportPartitions == 0 {
+		return nil
+	}
+
+	op := storage.startOperation("InitReportPartitions", maintenance)
+	defer op.finish(&err)
+
+	partitioned, partitions, err := storage.reportTablePartitioning(op.ctx)
+	if err != nil {
+		return err
+	}
+
+	if partitioned {
+		if partitions != storage.reportPartitions {
+			log.Warn().
+				Int("partitions", partitions).
+				Int("configured_partitions", storage.reportPartitions).
+				Msg("Report table has different number of partitions than configured, it's used as it is")
+		}
+		return nil
+	}
+
+	var hasReports bool
+	err = storage.connection.QueryRowContext(op.ctx, "SELECT EXISTS (SELECT 1 FROM report)").Scan(&hasReports)
+	if err != nil {
+		return err
+	}
+
+	if hasReports {
+		log.Warn().Msg("Report table already has reports and it's not partitioned, use partition-reports command to partition it")
+		return nil
+	}
+
+	err = storage.replaceReportTable(op.ctx, true)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("partitions", storage.reportPartitions).Msg("Report table has been partitioned by hash of org_id")
+
+	return nil
+}
+
+// replaceReportTable creates the partitioned table if it doesn't exist yet and replaces
+// the report table by it in a single transaction. The original table is dropped when dropOriginal is set.
+func (storage DBStorage) replaceReportTable(ctx context.Context, dropOriginal bool) error {
+	tx, err := storage.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	statements := partitionedReportTableStatements(partitionedReportTable, storage.reportPartitions)
+
+	err = execInTx(ctx, tx, statements)
+	if err == nil {
+		err = dropForeignKeysToReports(ctx, tx)
+	}
+	if err == nil {
+		err = execInTx(ctx, tx, replaceReportTableStatements())
+	}
+	if err == nil && dropOriginal {
+		_, err = tx.ExecContext(ctx, "DROP TABLE "+unpartitionedReportTable)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// createPartitionedReportTable creates the partitioned table the reports are copied into
+func (storage DBStorage) createPartitionedReportTable() (err error) {
+	op := storage.startOperation("CreatePartitionedReportTable", maintenance)
+	defer op.finish(&err)
+
+	tx, err := storage.connection.BeginTx(op.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = execInTx(op.ctx, tx, partitionedReportTableStatements(partitionedReportTable, storage.reportPartitions))
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lastCopiedReport returns key of the last report copied into the partitioned table,
+// so an interrupted copy continues where it has stopped, nil is returned when no report was copied
+func (storage DBStorage) lastCopiedReport() (_ *reportKey, err error) {
+	op := storage.startOperation("LastCopiedReport", maintenance)
+	defer op.finish(&err)
+
+	var last reportKey
+	err = storage.connection.QueryRowContext(
+		op.ctx,
+		"SELECT org_id, cluster FROM "+partitionedReportTable+" ORDER BY org_id DESC, cluster DESC LIMIT 1",
+	).Scan(&last.orgID, &last.clusterName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &last, nil
+}
+
+// copyReportsBatch copies at most batchSize reports following the given key in the order of keys
+// into the partitioned table and returns key of the last copied report together with the number
+// of copied reports. Nil key is returned when the batch has reached the end of the report table.
+func (storage DBStorage) copyReportsBatch(after *reportKey, batchSize int) (_ *reportKey, _ int, err error) {
+	op := storage.startOperation("CopyReportsBatch", maintenance)
+	defer op.finish(&err)
+
+	args := storage.dialect().newQueryArgs()
+	query := "SELECT org_id, cluster FROM report WHERE " + reportKeyRange(args, after, nil) +
+		" ORDER BY org_id, cluster LIMIT 1 OFFSET " + args.add(batchSize-1)
+
+	// the last report of the batch, the batch copies the rest of the table when there's none
+	var last reportKey
+	upTo := &last
+	err = storage.connection.QueryRowContext(op.ctx, query, args.values...).Scan(&last.orgID, &last.clusterName)
+	if err == sql.ErrNoRows {
+		upTo = nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	args = storage.dialect().newQueryArgs()
+	result, err := storage.connection.ExecContext(
+		op.ctx,
+		"INSERT INTO "+partitionedReportTable+" SELECT * FROM report WHERE "+reportKeyRange(args, after, upTo)+
+			" ON CONFLICT DO NOTHING",
+		args.values...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	copied, err := result.RowsAffected()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return upTo, int(copied), nil
+}
+
+// finishReportsPartitioning replaces the report table by the partitioned one
+func (storage DBStorage) finishReportsPartitioning() (err error) {
+	op := storage.startOperation("FinishReportsPartitioning", maintenance)
+	defer op.finish(&err)
+
+	return storage.replaceReportTable(op.ctx, false)
+}
+
+// PartitionReports copies reports from the report table into a new table partitioned by hash of org_id
+// in batches of batchSize reports, each batch in its own statement, and then it replaces the report table
+// by the partitioned one. The original table is kept as report_unpartitioned. Reports mustn't be written
+// while the reports are copied. The copy continues after the last copied report when it was interrupted.
+// Number of copied reports is returned, nothing is done when the report table is already partitioned.
+func (storage DBStorage) PartitionReports(batchSize int) (copied int, err error) {
+	if storage.reportPartitions == 0 {
+		return 0, ErrReportPartitioningDisabled
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultPartitionReportsBatchSize
+	}
+
+	partitioned, err := storage.isReportTablePartitioned()
+	if err != nil || partitioned {
+		return 0, err
+	}
+
+	if err := storage.createPartitionedReportTable(); err != nil {
+		return 0, err
+	}
+
+	after, err := storage.lastCopiedReport()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		last, batchCopied, err := storage.copyReportsBatch(after, batchSize)
+		if err != nil {
+			return copied, err
+		}
+
+		copied += batchCopied
+		log.Info().Int("copied", copied).Msg("Reports have been copied into the partitioned table")
+
+		if last == nil {
+			break
+		}
+		after = last
+	}
+
+	return copied, storage.finishReportsPartitioning()
+}
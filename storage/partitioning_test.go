@@ -0,0 +1,250 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const (
+	reportTablePartitioningQuery = `
+		SELECT
+			EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'report'::regclass),
+			(SELECT COUNT(*) FROM pg_inherits WHERE inhparent = 'report'::regclass)`
+	foreignKeysToReportsQuery = `
+		SELECT conrelid::regclass::text, conname FROM pg_constraint
+		WHERE contype = 'f' AND confrelid = 'report'::regclass`
+)
+
+// mustGetPartitionedMockStorage returns storage of mocked PostgreSQL database
+// with the report table partitioned into two partitions
+func mustGetPartitionedMockStorage(t *testing.T) (*storage.DBStorage, *helpers.StrictExpects) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	dbStorage := mockStorage.(*storage.DBStorage)
+	storage.SetReportPartitions(dbStorage, 2)
+
+	return dbStorage, expects
+}
+
+// expectReportTablePartitioning expects the check whether the report table is partitioned
+func expectReportTablePartitioning(expects *helpers.StrictExpects, partitioned bool, partitions int) {
+	expects.ExpectQueryWithArgs(reportTablePartitioningQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"exists", "count"}).AddRow(partitioned, int64(partitions)),
+	)
+}
+
+// expectPartitionedTableCreation expects creation of the partitioned table with two partitions
+func expectPartitionedTableCreation(expects *helpers.StrictExpects) {
+	for _, statement := range storage.PartitionedReportTableStatements("report_partitioned", 2) {
+		expects.ExpectExecWithArgs(statement).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
+
+// expectReportTableReplacement expects replacement of the report table by the partitioned one
+// including removal of the foreign key of users' feedback referencing the report table
+func expectReportTableReplacement(expects *helpers.StrictExpects) {
+	expectPartitionedTableCreation(expects)
+	expects.ExpectQueryWithArgs(foreignKeysToReportsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"conrelid", "conname"}).
+			AddRow("cluster_rule_user_feedback", "cluster_rule_user_feedback_cluster_id_fkey"),
+	).RowsWillBeClosed()
+	expects.ExpectExecWithArgs(
+		`ALTER TABLE cluster_rule_user_feedback DROP CONSTRAINT "cluster_rule_user_feedback_cluster_id_fkey"`,
+	).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, statement := range storage.ReplaceReportTableStatements() {
+		expects.ExpectExecWithArgs(statement).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
+
+func TestReportPartitionsOf(t *testing.T) {
+	enabled := storage.Configuration{PGPartitionReports: true}
+	configured := storage.Configuration{PGPartitionReports: true, PGReportPartitions: 4}
+
+	assert.Equal(t, 0, storage.ReportPartitionsOf(storage.Configuration{PGReportPartitions: 4}, storage.DBDriverPostgres))
+	assert.Equal(t, storage.DefaultReportPartitions, storage.ReportPartitionsOf(enabled, storage.DBDriverPostgres))
+	assert.Equal(t, 4, storage.ReportPartitionsOf(configured, storage.DBDriverPostgres))
+	// SQLite ignores the flag
+	assert.Equal(t, 0, storage.ReportPartitionsOf(configured, storage.DBDriverSQLite3))
+}
+
+func TestPartitionedReportTableStatements(t *testing.T) {
+	assert.Equal(t, []string{
+		"CREATE TABLE IF NOT EXISTS report_partitioned (LIKE report INCLUDING DEFAULTS, PRIMARY KEY (org_id, cluster)) " +
+			"PARTITION BY HASH (org_id)",
+		"CREATE TABLE IF NOT EXISTS report_p0 PARTITION OF report_partitioned FOR VALUES WITH (MODULUS 3, REMAINDER 0)",
+		"CREATE TABLE IF NOT EXISTS report_p1 PARTITION OF report_partitioned FOR VALUES WITH (MODULUS 3, REMAINDER 1)",
+		"CREATE TABLE IF NOT EXISTS report_p2 PARTITION OF report_partitioned FOR VALUES WITH (MODULUS 3, REMAINDER 2)",
+		"CREATE INDEX IF NOT EXISTS report_partitioned_org_last_checked_idx ON report_partitioned (org_id, last_checked_at)",
+	}, storage.PartitionedReportTableStatements("report_partitioned", 3))
+}
+
+func TestReplaceReportTableStatements(t *testing.T) {
+	assert.Equal(t, []string{
+		"ALTER TABLE report RENAME TO report_unpartitioned",
+		"ALTER TABLE report_unpartitioned RENAME CONSTRAINT report_pkey TO report_unpartitioned_pkey",
+		"ALTER INDEX IF EXISTS report_org_last_checked_idx RENAME TO report_unpartitioned_org_last_checked_idx",
+		"ALTER TABLE report_partitioned RENAME TO report",
+		"ALTER TABLE report RENAME CONSTRAINT report_partitioned_pkey TO report_pkey",
+		"ALTER INDEX report_partitioned_org_last_checked_idx RENAME TO report_org_last_checked_idx",
+	}, storage.ReplaceReportTableStatements())
+}
+
+// TestDBStorageInitReportPartitionsDisabled checks that nothing is done when partitioning is not enabled
+func TestDBStorageInitReportPartitionsDisabled(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	helpers.FailOnError(t, storage.InitReportPartitions(mockStorage.(*storage.DBStorage)))
+}
+
+// TestDBStorageInitReportPartitionsEmptyTable checks that empty report table is replaced by the partitioned one
+func TestDBStorageInitReportPartitionsEmptyTable(t *testing.T) {
+	mockStorage, expects := mustGetPartitionedMockStorage(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectReportTablePartitioning(expects, false, 0)
+	expects.ExpectQueryWithArgs("SELECT EXISTS (SELECT 1 FROM report)").WillReturnRows(
+		sqlmock.NewRows([]string{"exists"}).AddRow(false),
+	)
+	expects.ExpectBegin()
+	expectReportTableReplacement(expects)
+	expects.ExpectExecWithArgs("DROP TABLE report_unpartitioned").WillReturnResult(sqlmock.NewResult(0, 0))
+	expects.ExpectCommit()
+
+	helpers.FailOnError(t, storage.InitReportPartitions(mockStorage))
+}
+
+// TestDBStorageInitReportPartitionsWithReports checks that report table with reports is kept as it is
+func TestDBStorageInitReportPartitionsWithReports(t *testing.T) {
+	mockStorage, expects := mustGetPartitionedMockStorage(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectReportTablePartitioning(expects, false, 0)
+	expects.ExpectQueryWithArgs("SELECT EXISTS (SELECT 1 FROM report)").WillReturnRows(
+		sqlmock.NewRows([]string{"exists"}).AddRow(true),
+	)
+
+	helpers.FailOnError(t, storage.InitReportPartitions(mockStorage))
+}
+
+// TestDBStorageInitReportPartitionsAlreadyPartitioned checks that partitioned table is kept as it is
+// even when it has different number of partitions
+func TestDBStorageInitReportPartitionsAlreadyPartitioned(t *testing.T) {
+	mockStorage, expects := mustGetPartitionedMockStorage(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectReportTablePartitioning(expects, true, 4)
+
+	helpers.FailOnError(t, storage.InitReportPartitions(mockStorage))
+}
+
+// TestDBStoragePartitionReports checks that reports are copied in batches
+// and the report table is replaced by the partitioned one at the end
+func TestDBStoragePartitionReports(t *testing.T) {
+	mockStorage, expects := mustGetPartitionedMockStorage(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	const lastClusterOfBatch = types.ClusterName("9b4c1a3e-7c6d-4f0e-8a2b-3d5e6f7a8b9c")
+
+	expectReportTablePartitioning(expects, false, 0)
+	expects.ExpectBegin()
+	expectPartitionedTableCreation(expects)
+	expects.ExpectCommit()
+	expects.ExpectQueryWithArgs(
+		"SELECT org_id, cluster FROM report_partitioned ORDER BY org_id DESC, cluster DESC LIMIT 1",
+	).WillReturnRows(sqlmock.NewRows([]string{"org_id", "cluster"}))
+
+	// the first batch
+	expects.ExpectQueryWithArgs(
+		"SELECT org_id, cluster FROM report WHERE TRUE ORDER BY org_id, cluster LIMIT 1 OFFSET $1", int64(1),
+	).WillReturnRows(sqlmock.NewRows([]string{"org_id", "cluster"}).AddRow(int64(testdata.OrgID), string(lastClusterOfBatch)))
+	expects.ExpectExecWithArgs(`
+		INSERT INTO report_partitioned SELECT * FROM report WHERE TRUE AND (org_id, cluster) <= ($1, $2)
+		ON CONFLICT DO NOTHING`,
+		testdata.OrgID, lastClusterOfBatch,
+	).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// the second batch is the rest of the table
+	expects.ExpectQueryWithArgs(`
+		SELECT org_id, cluster FROM report WHERE TRUE AND (org_id, cluster) > ($1, $2)
+		ORDER BY org_id, cluster LIMIT 1 OFFSET $3`,
+		testdata.OrgID, lastClusterOfBatch, int64(1),
+	).WillReturnRows(sqlmock.NewRows([]string{"org_id", "cluster"}))
+	expects.ExpectExecWithArgs(`
+		INSERT INTO report_partitioned SELECT * FROM report WHERE TRUE AND (org_id, cluster) > ($1, $2)
+		ON CONFLICT DO NOTHING`,
+		testdata.OrgID, lastClusterOfBatch,
+	).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	expects.ExpectBegin()
+	expectReportTableReplacement(expects)
+	expects.ExpectCommit()
+
+	copied, err := mockStorage.PartitionReports(2)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 3, copied)
+}
+
+func TestDBStoragePartitionReportsAlreadyPartitioned(t *testing.T) {
+	mockStorage, expects := mustGetPartitionedMockStorage(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectReportTablePartitioning(expects, true, 2)
+
+	copied, err := mockStorage.PartitionReports(2)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, copied)
+}
+
+func TestDBStoragePartitionReportsDisabled(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.(*storage.DBStorage).PartitionReports(2)
+	assert.Equal(t, storage.ErrReportPartitioningDisabled, err)
+}
+
+// TestDBStorageWriteAndReadPartitionedReportsFakePostgres checks that queries of reports
+// don't change when the report table is partitioned
+func TestDBStorageWriteAndReadPartitionedReportsFakePostgres(t *testing.T) {
+	mockStorage, expects := mustGetPartitionedMockStorage(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectPostgresWriteReport(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+	)
+	expects.ExpectQueryWithArgs(
+		"SELECT org_id FROM report WHERE cluster = $1 AND deleted_at IS NULL ORDER BY org_id", testdata.ClusterName,
+	).WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(int64(testdata.OrgID)))
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+	)
+	helpers.FailOnError(t, err)
+
+	orgID, err := mockStorage.GetOrgIDByClusterID(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, testdata.OrgID, orgID)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reads returns connection used by read-only operations, it's the read replica when it's
+// configured and reachable, the primary database otherwise. Writes, read-write transactions
+// and reads which have to see the latest writes (like the offset the consumer continues from)
+// must always use storage.connection
+func (storage DBStorage) reads() *sql.DB {
+	if storage.replica != nil {
+		return storage.replica
+	}
+
+	return storage.connection
+}
+
+// readOnlyTx runs the reads in a single read-only transaction on the connection used for reads.
+// All statements of the transaction see the same snapshot of the database (PostgreSQL runs it
+// as REPEATABLE READ, SQLite transactions are serializable), so data written together by other
+// transactions are never seen partially. The transaction is always rolled back.
+func (storage DBStorage) readOnlyTx(ctx context.Context, reads func(tx *sql.Tx) error) error {
+	tx, err := storage.reads().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	return reads(tx)
+}
+
+// replicaDataSource returns data source of the read replica, empty string is returned
+// when the replica is not configured. The replica shares database name and parameters
+// with the primary database, credentials and port of the primary database are used
+// when they are not set for the replica
+func replicaDataSource(configuration Configuration) string {
+	if configuration.Driver != "postgres" || len(configuration.PGReplicaHost) == 0 {
+		return ""
+	}
+
+	username := configuration.PGReplicaUsername
+	password := configuration.PGReplicaPassword
+	if len(username) == 0 {
+		username = configuration.PGUsername
+		password = configuration.PGPassword
+	}
+
+	port := configuration.PGReplicaPort
+	if port == 0 {
+		port = configuration.PGPort
+	}
+
+	return fmt.Sprintf(
+		"postgresql://%v:%v@%v:%v/%v?%v",
+		username,
+		password,
+		configuration.PGReplicaHost,
+		port,
+		configuration.PGDBName,
+		configuration.PGParams,
+	)
+}
+
+// openReplica opens connection to the read replica, nil is returned when the replica
+// is not configured or when it's not reachable, all reads go to the primary database then
+func openReplica(driverName string, configuration Configuration, pingTimeout time.Duration) *sql.DB {
+	dataSource := replicaDataSource(configuration)
+	if len(dataSource) == 0 {
+		return nil
+	}
+
+	log.Info().
+		Str("host", configuration.PGReplicaHost).
+		Msg("Making connection to read replica of data storage")
+
+	replica, err := sql.Open(driverName, dataSource)
+	if err != nil {
+		log.Error().Err(err).Msg("Can not connect to read replica, reads will use primary database")
+		return nil
+	}
+
+	configureConnectionPool(replica, configuration)
+
+	return reachableReplica(replica, pingTimeout)
+}
+
+// reachableReplica pings the read replica and returns it when it's reachable,
+// otherwise the connection is closed and nil is returned
+func reachableReplica(replica *sql.DB, pingTimeout time.Duration) *sql.DB {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	if err := replica.PingContext(ctx); err != nil {
+		log.Error().Err(err).Msg("Read replica is not reachable, reads will use primary database")
+		_ = replica.Close()
+		return nil
+	}
+
+	return replica
+}
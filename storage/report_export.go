@@ -0,0 +1,94 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// ExportedReport is a single line of the export of reports of an organization,
+// the same lines are read by the import of reports
+type ExportedReport struct {
+	OrgID         types.OrgID       `json:"org_id"`
+	Cluster       types.ClusterName `json:"cluster"`
+	Report        json.RawMessage   `json:"report"`
+	LastCheckedAt time.Time         `json:"last_checked_at"`
+}
+
+// writeExportedReport writes the report to the export as a single line of JSON
+func writeExportedReport(
+	encoder *json.Encoder,
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastCheckedAt time.Time,
+) error {
+	return encoder.Encode(ExportedReport{
+		OrgID:         orgID,
+		Cluster:       clusterName,
+		Report:        json.RawMessage(report),
+		LastCheckedAt: lastCheckedAt,
+	})
+}
+
+// ExportReportsForOrg writes all reports of the organization ordered by cluster name
+// to the writer as newline delimited JSON. Reports are written one by one as they are
+// read from the database, so the export of large organizations doesn't have to fit
+// in memory. The export is aborted by the first error returned by the writer.
+func (storage DBStorage) ExportReportsForOrg(orgID types.OrgID, writer io.Writer) (err error) {
+	op := storage.startOperation("ExportReportsForOrg", heavyAggregation).forOrg(orgID)
+	defer op.finish(&err)
+
+	rows, err := storage.reads().QueryContext(
+		op.ctx,
+		"SELECT cluster, report, last_checked_at FROM report WHERE org_id = $1 AND deleted_at IS NULL ORDER BY cluster",
+		orgID,
+	)
+	if err != nil {
+		return err
+	}
+	defer closeRows(rows)
+
+	encoder := json.NewEncoder(writer)
+
+	for rows.Next() {
+		var (
+			clusterName   types.ClusterName
+			report        types.ClusterReport
+			lastCheckedAt time.Time
+		)
+
+		err = rows.Scan(&clusterName, &report, scanTimestamp(&lastCheckedAt))
+		if err != nil {
+			return err
+		}
+
+		report, err = storage.decodeReport(report)
+		if err != nil {
+			return err
+		}
+
+		err = writeExportedReport(encoder, orgID, clusterName, report, lastCheckedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,167 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const (
+	exportedClusterName      = types.ClusterName("0a1b2c3d-0dd8-49cd-9d4d-f6646df3a5bc")
+	otherExportedClusterName = types.ClusterName("00c3ecc5-624a-49a5-bab8-4fdc5e51a266")
+	otherExportedOrgID       = types.OrgID(2)
+)
+
+// failingWriter accepts the given number of writes, all the following writes fail
+type failingWriter struct {
+	bytes.Buffer
+	allowedWrites int
+}
+
+func (writer *failingWriter) Write(data []byte) (int, error) {
+	if writer.allowedWrites == 0 {
+		return 0, errors.New("client disconnected")
+	}
+	writer.allowedWrites--
+
+	return writer.Buffer.Write(data)
+}
+
+// readExportedReports parses the export line by line, every line has to be a complete JSON object
+func readExportedReports(t *testing.T, export []byte) []storage.ExportedReport {
+	reports := make([]storage.ExportedReport, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(export))
+	for scanner.Scan() {
+		var report storage.ExportedReport
+		helpers.FailOnError(t, json.Unmarshal(scanner.Bytes(), &report))
+		reports = append(reports, report)
+	}
+	helpers.FailOnError(t, scanner.Err())
+
+	return reports
+}
+
+func TestDBStorageExportReportsForOrg(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+		writeReportForCluster(t, mockStorage, testdata.OrgID, exportedClusterName, testdata.Report0Rules)
+		// reports of other organizations are not exported
+		writeReportForCluster(t, mockStorage, otherExportedOrgID, otherExportedClusterName, testdata.Report3Rules)
+
+		var export bytes.Buffer
+		helpers.FailOnError(t, mockStorage.ExportReportsForOrg(testdata.OrgID, &export))
+
+		// one JSON object per line, including the last one
+		assert.Equal(t, 2, bytes.Count(export.Bytes(), []byte("\n")))
+		assert.True(t, bytes.HasSuffix(export.Bytes(), []byte("}\n")))
+
+		reports := readExportedReports(t, export.Bytes())
+		assert.Len(t, reports, 2)
+
+		// reports are ordered by cluster name
+		assert.Equal(t, exportedClusterName, reports[0].Cluster)
+		assert.JSONEq(t, string(testdata.Report0Rules), string(reports[0].Report))
+		assert.Equal(t, testdata.ClusterName, reports[1].Cluster)
+		assert.JSONEq(t, string(testdata.Report3Rules), string(reports[1].Report))
+
+		for _, report := range reports {
+			storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, report.Cluster)
+			helpers.FailOnError(t, err)
+			assert.True(t, storedReport.LastCheckedAt.Equal(report.LastCheckedAt))
+		}
+	})
+}
+
+func TestDBStorageExportReportsForOrgEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		var export bytes.Buffer
+		helpers.FailOnError(t, mockStorage.ExportReportsForOrg(testdata.OrgID, &export))
+		assert.Empty(t, export.String())
+	})
+}
+
+// TestDBStorageExportReportsForOrgCompressed checks that compressed reports are exported decompressed
+func TestDBStorageExportReportsForOrgCompressed(t *testing.T) {
+	mockStorage := mustGetCompressingStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	var export bytes.Buffer
+	helpers.FailOnError(t, mockStorage.ExportReportsForOrg(testdata.OrgID, &export))
+
+	reports := readExportedReports(t, export.Bytes())
+	assert.Len(t, reports, 1)
+	assert.JSONEq(t, string(testdata.Report3Rules), string(reports[0].Report))
+}
+
+// TestDBStorageExportReportsForOrgWriterError checks that the export stops
+// at the first failed write, e.g. when the client disconnects
+func TestDBStorageExportReportsForOrgWriterError(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+		writeReportForCluster(t, mockStorage, testdata.OrgID, exportedClusterName, testdata.Report0Rules)
+
+		writer := &failingWriter{allowedWrites: 1}
+		err := mockStorage.ExportReportsForOrg(testdata.OrgID, writer)
+		assert.EqualError(t, err, "client disconnected")
+
+		reports := readExportedReports(t, writer.Bytes())
+		assert.Len(t, reports, 1)
+		assert.Equal(t, exportedClusterName, reports[0].Cluster)
+	})
+}
+
+// TestDBStorageExportReportsForOrgStreamsRows checks that every report is written
+// as soon as its row is read, so the rows are never collected in memory. The first
+// report has to be exported already when reading of the second row fails.
+func TestDBStorageExportReportsForOrgStreamsRows(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectQuery(
+		"SELECT cluster, report, last_checked_at FROM report WHERE org_id = \\$1 AND deleted_at IS NULL ORDER BY cluster",
+	).
+		WithArgs(testdata.OrgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"cluster", "report", "last_checked_at"}).
+				AddRow(string(exportedClusterName), string(testdata.Report0Rules), testdata.LastCheckedAt).
+				AddRow(string(testdata.ClusterName), string(testdata.Report3Rules), testdata.LastCheckedAt).
+				RowError(1, errors.New("connection lost")),
+		)
+
+	var export bytes.Buffer
+	err := mockStorage.ExportReportsForOrg(testdata.OrgID, &export)
+	assert.EqualError(t, err, "connection lost")
+
+	reports := readExportedReports(t, export.Bytes())
+	assert.Len(t, reports, 1)
+	assert.Equal(t, exportedClusterName, reports[0].Cluster)
+}
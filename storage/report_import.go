@@ -0,0 +1,134 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// ImportLineError describes a line of the import which couldn't be imported
+type ImportLineError struct {
+	Line int
+	Err  error
+}
+
+// Error returns the reason of the failure together with the number of the line
+func (e ImportLineError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.Line, e.Err)
+}
+
+// ImportStats summarizes the import of reports. Every non-empty line is either inserted,
+// skipped, because a more recent report of the cluster is already stored, or failed.
+type ImportStats struct {
+	Inserted       int
+	SkippedAsOlder int
+	Failed         int
+	Errors         []ImportLineError
+}
+
+// importTarget is the part of the storage used by the import of reports
+type importTarget interface {
+	ReadReportForCluster(types.OrgID, types.ClusterName) (types.StoredReport, error)
+	WriteReportForCluster(types.OrgID, types.ClusterName, types.ClusterReport, time.Time, types.KafkaOffset) error
+}
+
+// importReports reads reports from newline delimited JSON in the format of the export and writes
+// them one by one to the storage. Invalid lines and lines which can't be written are recorded
+// in the stats, the error is returned only when the input can't be read.
+func importReports(target importTarget, reader io.Reader) (ImportStats, error) {
+	stats := ImportStats{Errors: make([]ImportLineError, 0)}
+	input := bufio.NewReader(reader)
+
+	for lineNumber := 1; ; lineNumber++ {
+		// reports can be longer than the maximal line of bufio.Scanner
+		line, err := input.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return stats, err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			skipped, importErr := importReport(target, line)
+			switch {
+			case importErr != nil:
+				stats.Failed++
+				stats.Errors = append(stats.Errors, ImportLineError{Line: lineNumber, Err: importErr})
+			case skipped:
+				stats.SkippedAsOlder++
+			default:
+				stats.Inserted++
+			}
+		}
+
+		if err == io.EOF {
+			return stats, nil
+		}
+	}
+}
+
+// importReport writes the report from a single line of the import,
+// it returns true when the stored report of the cluster is more recent
+func importReport(target importTarget, line []byte) (bool, error) {
+	var record ExportedReport
+
+	if err := json.Unmarshal(line, &record); err != nil {
+		return false, err
+	}
+
+	switch {
+	case record.OrgID == 0:
+		return false, errors.New("missing org_id")
+	case record.Cluster == "":
+		return false, errors.New("missing cluster")
+	case len(record.Report) == 0:
+		return false, errors.New("missing report")
+	case record.LastCheckedAt.IsZero():
+		return false, errors.New("missing last_checked_at")
+	}
+
+	stored, err := target.ReadReportForCluster(record.OrgID, record.Cluster)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+
+	if err == nil && stored.LastCheckedAt.After(record.LastCheckedAt) {
+		return true, nil
+	}
+
+	err = target.WriteReportForCluster(
+		record.OrgID, record.Cluster, types.ClusterReport(record.Report), record.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Unable to import report of cluster %v", record.Cluster)
+		return false, err
+	}
+
+	return false, nil
+}
+
+// ImportReports imports reports from newline delimited JSON produced by the export of reports.
+// Each report is written by WriteReportForCluster, the stored report is kept when it's more recent.
+func (storage DBStorage) ImportReports(reader io.Reader) (ImportStats, error) {
+	return importReports(storage, reader)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// failingReader returns the data and then the error instead of EOF
+type failingReader struct {
+	data *strings.Reader
+}
+
+func (reader failingReader) Read(buffer []byte) (int, error) {
+	if reader.data.Len() == 0 {
+		return 0, errors.New("disk failure")
+	}
+
+	return reader.data.Read(buffer)
+}
+
+// importedReportLine returns the report checked at testdata.LastCheckedAt as a single line of the import
+func importedReportLine(
+	t *testing.T, orgID types.OrgID, clusterName types.ClusterName, report types.ClusterReport,
+) string {
+	var compacted bytes.Buffer
+	helpers.FailOnError(t, json.Compact(&compacted, []byte(report)))
+
+	return fmt.Sprintf(
+		`{"org_id": %v, "cluster": "%v", "report": %v, "last_checked_at": "%v"}`,
+		orgID, clusterName, compacted.String(), testdata.LastCheckedAt.UTC().Format(time.RFC3339),
+	)
+}
+
+// TestDBStorageImportReports imports a file mixing valid lines, invalid lines and a report
+// older than the stored one and checks that the invalid lines don't stop the import
+func TestDBStorageImportReports(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		// the stored report was checked now, so it's more recent than the imported one
+		writeReportForCluster(t, mockStorage, testdata.OrgID, exportedClusterName, testdata.Report0Rules)
+
+		input := strings.Join([]string{
+			importedReportLine(t, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules),
+			`{"org_id": 1, "cluster": `,
+			"",
+			importedReportLine(t, testdata.OrgID, exportedClusterName, testdata.Report3Rules),
+			importedReportLine(t, 0, otherExportedClusterName, testdata.Report3Rules),
+			importedReportLine(t, otherExportedOrgID, otherExportedClusterName, `"not a report"`),
+			// the last line doesn't end by the new line
+			importedReportLine(t, otherExportedOrgID, otherExportedClusterName, testdata.Report0Rules),
+		}, "\n")
+
+		stats, err := mockStorage.ImportReports(strings.NewReader(input))
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, 2, stats.Inserted)
+		assert.Equal(t, 1, stats.SkippedAsOlder)
+		assert.Equal(t, 3, stats.Failed)
+
+		lines := make([]int, 0)
+		for _, lineErr := range stats.Errors {
+			lines = append(lines, lineErr.Line)
+		}
+		assert.Equal(t, []int{2, 5, 6}, lines)
+		assert.EqualError(t, stats.Errors[1], "line 5: missing org_id")
+
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.JSONEq(t, string(testdata.Report3Rules), string(storedReport.Report))
+		assert.True(t, storedReport.LastCheckedAt.Equal(testdata.LastCheckedAt))
+
+		storedReport, err = mockStorage.ReadReportForCluster(otherExportedOrgID, otherExportedClusterName)
+		helpers.FailOnError(t, err)
+		assert.JSONEq(t, string(testdata.Report0Rules), string(storedReport.Report))
+
+		// the more recent stored report is kept
+		storedReport, err = mockStorage.ReadReportForCluster(testdata.OrgID, exportedClusterName)
+		helpers.FailOnError(t, err)
+		assert.JSONEq(t, string(testdata.Report0Rules), string(storedReport.Report))
+		assert.True(t, storedReport.LastCheckedAt.After(testdata.LastCheckedAt))
+	})
+}
+
+// TestDBStorageImportReportsOfExport checks that the export of reports can be imported back
+func TestDBStorageImportReportsOfExport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+		writeReportForCluster(t, mockStorage, testdata.OrgID, exportedClusterName, testdata.Report0Rules)
+
+		var export bytes.Buffer
+		helpers.FailOnError(t, mockStorage.ExportReportsForOrg(testdata.OrgID, &export))
+		_, err := mockStorage.DeleteReportsForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+
+		stats, err := mockStorage.ImportReports(&export)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, storage.ImportStats{Inserted: 2, Errors: []storage.ImportLineError{}}, stats)
+
+		for _, clusterName := range []types.ClusterName{testdata.ClusterName, exportedClusterName} {
+			storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, clusterName)
+			helpers.FailOnError(t, err)
+			assert.WithinDuration(t, time.Now(), storedReport.LastCheckedAt, time.Minute)
+		}
+	})
+}
+
+// TestDBStorageImportReportsReadError checks that the import stops when the input can't be read
+func TestDBStorageImportReportsReadError(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		input := importedReportLine(t, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules) + "\n"
+
+		stats, err := mockStorage.ImportReports(failingReader{data: strings.NewReader(input)})
+		assert.EqualError(t, err, "disk failure")
+		assert.Equal(t, 1, stats.Inserted)
+	})
+}
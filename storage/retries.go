@@ -0,0 +1,77 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRetryBackoff is the delay before the first retry of the write failed
+// because of a transient error used when it's not configured, it's doubled for each next retry
+const DefaultRetryBackoff = 100 * time.Millisecond
+
+// transientPQErrorCodes are codes of PostgreSQL errors which are likely to disappear
+// when the same statement is repeated
+var transientPQErrorCodes = map[pq.ErrorCode]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"57P03": true, // cannot_connect_now, the database system is starting up
+}
+
+// isTransientDBError checks whether the error is caused by a temporary state of the database,
+// like failover or concurrent transaction, so the failed write can be retried
+func isTransientDBError(err error) bool {
+	switch err := err.(type) {
+	case *pq.Error:
+		// class 08 are connection exceptions
+		return transientPQErrorCodes[err.Code] || err.Code.Class() == "08"
+	case net.Error:
+		return true
+	case *WriteHookError:
+		return isTransientDBError(err.Err)
+	default:
+		return err == driver.ErrBadConn
+	}
+}
+
+// withRetries runs the write and repeats it at most maxRetries times when it fails because of
+// a transient error, the delay between attempts starts at retryBackoff and it's doubled
+// after each attempt. Retries are stopped when the context is done.
+func (storage DBStorage) withRetries(ctx context.Context, name string, write func() error) error {
+	backoff := storage.retryBackoff
+
+	err := write()
+
+	for retry := 1; retry <= storage.maxRetries && isTransientDBError(err); retry++ {
+		log.Warn().Err(err).Int("retry", retry).Str("backoff", backoff.String()).Msgf("Retrying %v", name)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		err = write()
+	}
+
+	return err
+}
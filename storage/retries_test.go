@@ -0,0 +1,138 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+var (
+	serializationFailure = &pq.Error{Code: "40001", Message: "could not serialize access"}
+	uniqueViolation      = &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}
+)
+
+// mustGetPostgresStorageWithRetries returns mock Postgres storage retrying failed writes at most maxRetries times
+func mustGetPostgresStorageWithRetries(t *testing.T, maxRetries int) (storage.Storage, sqlmock.Sqlmock) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverPostgres)
+	storage.SetRetries(mockStorage.(*storage.DBStorage), maxRetries, time.Millisecond)
+
+	return mockStorage, expects
+}
+
+// expectReportStatementsPrepared expects preparation of the update of the duplicate report and of the report
+// upsert, they are prepared only by the first write of the report, retries use the cached statements
+func expectReportStatementsPrepared(expects sqlmock.Sqlmock) {
+	expects.ExpectPrepare("UPDATE report SET")
+	expects.ExpectPrepare("INSERT INTO report")
+}
+
+// expectFailedReportWrite expects the write of the report failing on the first query
+func expectFailedReportWrite(expects sqlmock.Sqlmock, err error) {
+	expects.ExpectBegin()
+	expects.ExpectExec("UPDATE report SET").WillReturnError(err)
+	expects.ExpectRollback()
+}
+
+func writeReport0Rules(mockStorage storage.Storage) error {
+	return mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+}
+
+func TestDBStorageWriteReportForClusterRetriesTransientErrors(t *testing.T) {
+	mockStorage, expects := mustGetPostgresStorageWithRetries(t, 3)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectReportStatementsPrepared(expects)
+	expectFailedReportWrite(expects, serializationFailure)
+	expectFailedReportWrite(expects, serializationFailure)
+
+	expects.ExpectBegin()
+	expects.ExpectExec("UPDATE report SET").WillReturnResult(sqlmock.NewResult(0, 0))
+	expects.ExpectExec("INSERT INTO report").WillReturnResult(sqlmock.NewResult(0, 1))
+	expects.ExpectExec("DELETE FROM rule_hit").WillReturnResult(driver.ResultNoRows)
+	expects.ExpectCommit()
+
+	helpers.FailOnError(t, writeReport0Rules(mockStorage))
+}
+
+func TestDBStorageWriteReportForClusterRetriesExhausted(t *testing.T) {
+	mockStorage, expects := mustGetPostgresStorageWithRetries(t, 1)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectReportStatementsPrepared(expects)
+	expectFailedReportWrite(expects, serializationFailure)
+	expectFailedReportWrite(expects, serializationFailure)
+
+	assert.Equal(t, serializationFailure, writeReport0Rules(mockStorage))
+}
+
+func TestDBStorageWriteReportForClusterDoesNotRetryOtherErrors(t *testing.T) {
+	mockStorage, expects := mustGetPostgresStorageWithRetries(t, 3)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectReportStatementsPrepared(expects)
+	expectFailedReportWrite(expects, uniqueViolation)
+
+	assert.Equal(t, uniqueViolation, writeReport0Rules(mockStorage))
+}
+
+func TestDBStorageAckRuleForOrgRetriesTransientErrors(t *testing.T) {
+	mockStorage, expects := mustGetPostgresStorageWithRetries(t, 3)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	startingUp := &pq.Error{Code: "57P03", Message: "the database system is starting up"}
+	expects.ExpectExec("INSERT INTO rule_ack").WillReturnError(startingUp)
+	expects.ExpectExec("INSERT INTO rule_ack").WillReturnError(startingUp)
+	expects.ExpectExec("INSERT INTO rule_ack").WillReturnResult(driver.ResultNoRows)
+
+	helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID, "justification"))
+}
+
+func TestIsTransientDBError(t *testing.T) {
+	for _, err := range []error{
+		serializationFailure,
+		&pq.Error{Code: "40P01"},
+		&pq.Error{Code: "57P03"},
+		&pq.Error{Code: "08006"},
+		&net.OpError{Op: "read", Err: errors.New("connection reset by peer")},
+		driver.ErrBadConn,
+	} {
+		assert.True(t, storage.IsTransientDBError(err), err)
+	}
+
+	for _, err := range []error{
+		nil,
+		uniqueViolation,
+		errors.New("syntax error"),
+	} {
+		assert.False(t, storage.IsTransientDBError(err), err)
+	}
+}
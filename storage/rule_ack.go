@@ -0,0 +1,164 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// RuleAck is an acknowledgement of the rule by the organization, acknowledged rule
+// is acknowledged for all clusters of the organization
+type RuleAck struct {
+	OrgID         types.OrgID  `json:"org_id"`
+	RuleID        types.RuleID `json:"rule"`
+	UserID        types.UserID `json:"user_id"`
+	Justification string       `json:"justification"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// ruleAckUpsert writes the acknowledgement, created_at of the stored acknowledgement is kept
+var ruleAckUpsert = upsertStatement{
+	table:           "rule_ack",
+	columns:         []string{"org_id", "rule_id", "user_id", "justification", "created_at", "updated_at"},
+	values:          []string{"$1", "$2", "$3", "$4", "$5", "$5"},
+	conflictColumns: []string{"org_id", "rule_id"},
+	updates:         []string{"user_id = $3", "justification = $4", "updated_at = $5"},
+}
+
+// AckRuleForOrg acknowledges the rule for all clusters of the organization. When the rule
+// is already acknowledged, the user and justification are overwritten and created_at is kept
+func (storage DBStorage) AckRuleForOrg(
+	orgID types.OrgID, ruleID types.RuleID, userID types.UserID, justification string,
+) (err error) {
+	op := storage.startOperation("AckRuleForOrg", write).forOrg(orgID)
+	defer op.finish(&err)
+
+	query, ok := storage.dialect().upsert(ruleAckUpsert)
+	if !ok {
+		return fmt.Errorf("acking rules with DB %v is not supported", storage.dbDriverType)
+	}
+
+	err = storage.withRetries(op.ctx, "AckRuleForOrg", func() error {
+		_, err := storage.connection.ExecContext(op.ctx, query, orgID, ruleID, userID, justification, time.Now())
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("AckRuleForOrg")
+		return err
+	}
+
+	return nil
+}
+
+// ListAcksForOrg returns all rules acknowledged by the organization,
+// the most recently updated acknowledgement goes first
+func (storage DBStorage) ListAcksForOrg(orgID types.OrgID) (_ []RuleAck, err error) {
+	op := storage.startOperation("ListAcksForOrg", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	acks := make([]RuleAck, 0)
+
+	rows, err := storage.reads().QueryContext(
+		op.ctx,
+		`SELECT org_id, rule_id, user_id, justification, created_at, updated_at
+		FROM rule_ack
+		WHERE org_id = $1
+		ORDER BY updated_at DESC, rule_id`,
+		orgID,
+	)
+	if err != nil {
+		return acks, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var ack RuleAck
+
+		err = rows.Scan(
+			&ack.OrgID,
+			&ack.RuleID,
+			&ack.UserID,
+			&ack.Justification,
+			scanTimestamp(&ack.CreatedAt),
+			scanTimestamp(&ack.UpdatedAt),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("ListAcksForOrg")
+			return acks, err
+		}
+
+		acks = append(acks, ack)
+	}
+
+	return acks, rows.Err()
+}
+
+// IsRuleAckedForOrg checks whether the rule is acknowledged by the organization,
+// it's a single lookup by the primary key of rule_ack table
+func (storage DBStorage) IsRuleAckedForOrg(orgID types.OrgID, ruleID types.RuleID) (_ bool, err error) {
+	op := storage.startOperation("IsRuleAckedForOrg", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	var acked int
+
+	err = storage.reads().QueryRowContext(
+		op.ctx, "SELECT 1 FROM rule_ack WHERE org_id = $1 AND rule_id = $2", orgID, ruleID,
+	).Scan(&acked)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteAckForOrg takes back the acknowledgement of the rule by the organization
+func (storage DBStorage) DeleteAckForOrg(orgID types.OrgID, ruleID types.RuleID) (err error) {
+	op := storage.startOperation("DeleteAckForOrg", write).forOrg(orgID)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx, "DELETE FROM rule_ack WHERE org_id = $1 AND rule_id = $2", orgID, ruleID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("DeleteAckForOrg")
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return newItemNotFoundError(ItemKindToggle, orgID, ruleID)
+	}
+
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "DeleteAckForOrg", OrgID: orgID, RuleID: ruleID, DeletedRows: int(deleted),
+	})
+
+	return nil
+}
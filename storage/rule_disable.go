@@ -0,0 +1,565 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// SilencingStats contains numbers of rules silenced for clusters of the organization
+type SilencingStats struct {
+	// DisabledRules counts distinct rules disabled for the organization or for any of its clusters
+	DisabledRules int `json:"disabled_rules"`
+	// ClustersWithDisabledRules counts clusters with at least one rule disabled for them,
+	// either by their own toggle or for the whole organization and not enabled by their toggle
+	ClustersWithDisabledRules int `json:"clusters_with_disabled_rules"`
+	AckedRules                int `json:"acked_rules"`
+	// SilencedRules counts rules which are disabled, acked or both only once
+	SilencedRules int `json:"silenced_rules"`
+}
+
+// ruleDisableUpsert writes the disable of the rule
+var ruleDisableUpsert = upsertStatement{
+	table:           "rule_disable_org",
+	columns:         []string{"org_id", "rule_id", "user_id", "disabled_at"},
+	conflictColumns: []string{"org_id", "rule_id"},
+	updates:         []string{"user_id = $3", "disabled_at = $4"},
+}
+
+// DisableRuleForOrg disables the rule for all clusters of the organization,
+// disabling already disabled rule only updates the user and time of the disable
+func (storage DBStorage) DisableRuleForOrg(orgID types.OrgID, ruleID types.RuleID, userID types.UserID) (err error) {
+	op := storage.startOperation("DisableRuleForOrg", write).forOrg(orgID)
+	defer op.finish(&err)
+
+	query, ok := storage.dialect().upsert(ruleDisableUpsert)
+	if !ok {
+		return fmt.Errorf("disabling rules with DB %v is not supported", storage.dbDriverType)
+	}
+
+	err = storage.withRetries(op.ctx, "DisableRuleForOrg", func() error {
+		statement, err := storage.statements.prepare(op.ctx, storage.connection, query)
+		if err != nil {
+			return err
+		}
+
+		_, err = statement.ExecContext(op.ctx, orgID, ruleID, userID, time.Now())
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("DisableRuleForOrg")
+		return err
+	}
+
+	return nil
+}
+
+// EnableRuleForOrg enables the rule disabled for all clusters of the organization,
+// enabling the rule which is not disabled does nothing
+func (storage DBStorage) EnableRuleForOrg(orgID types.OrgID, ruleID types.RuleID, userID types.UserID) (err error) {
+	op := storage.startOperation("EnableRuleForOrg", write).forOrg(orgID)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx, "DELETE FROM rule_disable_org WHERE org_id = $1 AND rule_id = $2", orgID, ruleID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("EnableRuleForOrg")
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// the user enabling the rule is the requester of the deletion
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "EnableRuleForOrg", OrgID: orgID, RuleID: ruleID, UserID: userID, DeletedRows: int(deleted),
+	})
+
+	log.Info().
+		Uint64("org_id", uint64(orgID)).
+		Str("rule_id", string(ruleID)).
+		Str("user_id", string(userID)).
+		Msg("Rule enabled for organization")
+
+	return nil
+}
+
+// ListOrgDisabledRules returns IDs of rules disabled for all clusters of the organization ordered by ID
+func (storage DBStorage) ListOrgDisabledRules(orgID types.OrgID) (_ []types.RuleID, err error) {
+	op := storage.startOperation("ListOrgDisabledRules", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	ruleIDs := make([]types.RuleID, 0)
+
+	rows, err := storage.reads().QueryContext(
+		op.ctx, "SELECT rule_id FROM rule_disable_org WHERE org_id = $1 ORDER BY rule_id", orgID,
+	)
+	if err != nil {
+		return ruleIDs, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var ruleID types.RuleID
+
+		if err := rows.Scan(&ruleID); err != nil {
+			return ruleIDs, err
+		}
+
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+
+	return ruleIDs, rows.Err()
+}
+
+// RuleToggle is the setting of the rule for a single cluster,
+// it takes precedence over the rule disabled for the whole organization
+type RuleToggle int
+
+const (
+	// RuleToggleEnable shows the rule for the cluster even when it's disabled for the organization
+	RuleToggleEnable RuleToggle = 0
+	// RuleToggleDisable hides the rule for the cluster
+	RuleToggleDisable RuleToggle = 1
+)
+
+// checkRuleToggle checks that the toggle is one of the known ones
+func checkRuleToggle(toggle RuleToggle) error {
+	if toggle != RuleToggleEnable && toggle != RuleToggleDisable {
+		return &ValidationError{ParamName: "toggle", ErrString: fmt.Sprintf("unknown rule toggle %v", toggle)}
+	}
+
+	return nil
+}
+
+// ClusterRuleDisable is the rule disabled for a single cluster by its toggle
+// with the justification of the user who disabled it
+type ClusterRuleDisable struct {
+	ClusterName   types.ClusterName `json:"cluster"`
+	RuleID        types.RuleID      `json:"rule_id"`
+	UserID        types.UserID      `json:"user_id"`
+	Justification string            `json:"justification"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// checkJustification checks that the justification isn't longer than maxLength characters
+func checkJustification(justification string, maxLength int) error {
+	if length := utf8.RuneCountInString(justification); length > maxLength {
+		return &ValidationError{
+			ParamName: "justification",
+			ErrString: fmt.Sprintf("at most %v characters expected, got %v", maxLength, length),
+		}
+	}
+
+	return nil
+}
+
+// clusterRuleToggleUpsert writes the toggle of the rule for the cluster
+var clusterRuleToggleUpsert = upsertStatement{
+	table:           "cluster_rule_toggle",
+	columns:         []string{"cluster_id", "rule_id", "user_id", "disabled", "updated_at", "justification"},
+	conflictColumns: []string{"cluster_id", "rule_id"},
+	updates:         []string{"user_id = $3", "disabled = $4", "updated_at = $5", "justification = $6"},
+}
+
+// ToggleRuleForCluster enables or disables the rule for the cluster regardless of the rules disabled
+// for its organization, toggling the rule again replaces its previous toggle and justification.
+// The justification is optional, justifications longer than maxFeedbackMessageLength characters are rejected.
+func (storage DBStorage) ToggleRuleForCluster(
+	clusterName types.ClusterName, ruleID types.RuleID, userID types.UserID, toggle RuleToggle, justification string,
+) (err error) {
+	op := storage.startOperation("ToggleRuleForCluster", write).forCluster(clusterName)
+	defer op.finish(&err)
+
+	if err := checkRuleToggle(toggle); err != nil {
+		return err
+	}
+
+	if err := checkJustification(justification, storage.maxFeedbackMessageLength); err != nil {
+		return err
+	}
+
+	query, ok := storage.dialect().upsert(clusterRuleToggleUpsert)
+	if !ok {
+		return fmt.Errorf("toggling rules with DB %v is not supported", storage.dbDriverType)
+	}
+
+	err = storage.withRetries(op.ctx, "ToggleRuleForCluster", func() error {
+		statement, err := storage.statements.prepare(op.ctx, storage.connection, query)
+		if err != nil {
+			return err
+		}
+
+		_, err = statement.ExecContext(op.ctx, clusterName, ruleID, userID, toggle, timeNow(), justification)
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("ToggleRuleForCluster")
+		return err
+	}
+
+	return nil
+}
+
+// AddFeedbackToRuleDisable replaces the justification of the rule disabled for the cluster
+// without changing its toggle, the user and time of the toggle are updated too.
+// ItemNotFoundError is returned when the rule isn't disabled for the cluster.
+func (storage DBStorage) AddFeedbackToRuleDisable(
+	clusterName types.ClusterName, ruleID types.RuleID, userID types.UserID, justification string,
+) (err error) {
+	op := storage.startOperation("AddFeedbackToRuleDisable", write).forCluster(clusterName)
+	defer op.finish(&err)
+
+	if err := checkJustification(justification, storage.maxFeedbackMessageLength); err != nil {
+		return err
+	}
+
+	var updated int64
+
+	err = storage.withRetries(op.ctx, "AddFeedbackToRuleDisable", func() error {
+		result, err := storage.connection.ExecContext(op.ctx, `
+			UPDATE cluster_rule_toggle
+			   SET user_id = $3, justification = $4, updated_at = $5
+			 WHERE cluster_id = $1 AND rule_id = $2 AND disabled = $6`,
+			clusterName, ruleID, userID, justification, timeNow(), RuleToggleDisable,
+		)
+		if err != nil {
+			return err
+		}
+
+		updated, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("AddFeedbackToRuleDisable")
+		return err
+	}
+
+	if updated == 0 {
+		return newItemNotFoundError(ItemKindToggle, clusterName, ruleID)
+	}
+
+	return nil
+}
+
+// ListDisabledRulesForCluster returns rules disabled for the cluster by its toggles with their
+// justifications, the most recently updated toggle goes first. Rules disabled for the whole
+// organization aren't included.
+func (storage DBStorage) ListDisabledRulesForCluster(clusterName types.ClusterName) (_ []ClusterRuleDisable, err error) {
+	op := storage.startOperation("ListDisabledRulesForCluster", fastRead).forCluster(clusterName)
+	defer op.finish(&err)
+
+	disables := make([]ClusterRuleDisable, 0)
+
+	rows, err := storage.reads().QueryContext(
+		op.ctx,
+		`SELECT cluster_id, rule_id, user_id, justification, updated_at
+		FROM cluster_rule_toggle
+		WHERE cluster_id = $1 AND disabled = $2
+		ORDER BY updated_at DESC, rule_id`,
+		clusterName, RuleToggleDisable,
+	)
+	if err != nil {
+		return disables, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var disable ClusterRuleDisable
+
+		err = rows.Scan(
+			&disable.ClusterName,
+			&disable.RuleID,
+			&disable.UserID,
+			&disable.Justification,
+			scanTimestamp(&disable.UpdatedAt),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("ListDisabledRulesForCluster")
+			return disables, err
+		}
+
+		disables = append(disables, disable)
+	}
+
+	return disables, rows.Err()
+}
+
+// DeleteRuleToggleForCluster removes the toggle of the rule for the cluster, so the rule follows
+// the organization again. ItemNotFoundError is returned when the rule isn't toggled for the cluster.
+func (storage DBStorage) DeleteRuleToggleForCluster(clusterName types.ClusterName, ruleID types.RuleID) (err error) {
+	op := storage.startOperation("DeleteRuleToggleForCluster", write).forCluster(clusterName)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx, "DELETE FROM cluster_rule_toggle WHERE cluster_id = $1 AND rule_id = $2", clusterName, ruleID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("DeleteRuleToggleForCluster")
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return newItemNotFoundError(ItemKindToggle, clusterName, ruleID)
+	}
+
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "DeleteRuleToggleForCluster", ClusterName: clusterName, RuleID: ruleID, DeletedRows: int(deleted),
+	})
+
+	return nil
+}
+
+// GetDisabledRulesForCluster returns IDs of rules hidden in the report of the cluster ordered by ID.
+// These are rules disabled for the cluster and rules disabled for the organization which are
+// not enabled for the cluster, the toggle of the cluster takes precedence over the organization.
+func (storage DBStorage) GetDisabledRulesForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) (_ []types.RuleID, err error) {
+	op := storage.startOperation("GetDisabledRulesForCluster", fastRead).forOrg(orgID).forCluster(clusterName)
+	defer op.finish(&err)
+
+	ruleIDs := make([]types.RuleID, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT rule_id FROM rule_disable_org
+		 WHERE org_id = $1 AND rule_id NOT IN (SELECT rule_id FROM cluster_rule_toggle WHERE cluster_id = $2)
+		UNION
+		SELECT rule_id FROM cluster_rule_toggle WHERE cluster_id = $2 AND disabled = $3
+		ORDER BY rule_id`,
+		orgID, clusterName, RuleToggleDisable,
+	)
+	if err != nil {
+		return ruleIDs, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var ruleID types.RuleID
+
+		if err := rows.Scan(&ruleID); err != nil {
+			return ruleIDs, err
+		}
+
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+
+	return ruleIDs, rows.Err()
+}
+
+// silencingStatsQuery counts rules silenced for the organization, rules toggled for clusters
+// are attributed to the organization by the reports of the clusters
+const silencingStatsQuery = `
+	SELECT
+		(SELECT COUNT(*) FROM (
+			SELECT rule_id FROM rule_disable_org WHERE org_id = $1
+			UNION
+			SELECT rule_id FROM cluster_rule_toggle
+			 WHERE disabled = $2
+			   AND cluster_id IN (SELECT cluster FROM report WHERE org_id = $1 AND deleted_at IS NULL)
+		) AS disabled),
+		(SELECT COUNT(*) FROM report
+		  WHERE org_id = $1 AND deleted_at IS NULL AND (
+			EXISTS (SELECT 1 FROM cluster_rule_toggle WHERE cluster_id = report.cluster AND disabled = $2)
+			OR EXISTS (
+				SELECT 1 FROM rule_disable_org
+				 WHERE org_id = $1 AND rule_id NOT IN (
+					SELECT rule_id FROM cluster_rule_toggle WHERE cluster_id = report.cluster AND disabled = $3
+				 )
+			)
+		)),
+		(SELECT COUNT(*) FROM rule_ack WHERE org_id = $1),
+		(SELECT COUNT(*) FROM (
+			SELECT rule_id FROM rule_disable_org WHERE org_id = $1
+			UNION
+			SELECT rule_id FROM cluster_rule_toggle
+			 WHERE disabled = $2
+			   AND cluster_id IN (SELECT cluster FROM report WHERE org_id = $1 AND deleted_at IS NULL)
+			UNION
+			SELECT rule_id FROM rule_ack WHERE org_id = $1
+		) AS silenced)`
+
+// GetSilencingStatsForOrg returns numbers of rules disabled and acked for the organization
+// and for its clusters and number of clusters with any rule disabled
+func (storage DBStorage) GetSilencingStatsForOrg(orgID types.OrgID) (stats SilencingStats, err error) {
+	op := storage.startOperation("GetSilencingStatsForOrg", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	err = storage.reads().QueryRowContext(
+		op.ctx, silencingStatsQuery, orgID, RuleToggleDisable, RuleToggleEnable,
+	).Scan(&stats.DisabledRules, &stats.ClustersWithDisabledRules, &stats.AckedRules, &stats.SilencedRules)
+	if err != nil {
+		log.Error().Err(err).Msg("GetSilencingStatsForOrg")
+		return SilencingStats{}, err
+	}
+
+	return stats, nil
+}
+
+// DisabledRuleSummary shows how the rule is silenced for the organization, it's disabled
+// for the whole organization, acked by it or disabled for ClusterCount of its clusters.
+// Users who silenced the rule are sorted by ID, Justification and UpdatedAt are the ones
+// of the most recent silencing with non-empty justification and the most recent silencing.
+type DisabledRuleSummary struct {
+	RuleID         types.RuleID   `json:"rule_id"`
+	DisabledForOrg bool           `json:"disabled_for_org"`
+	Acked          bool           `json:"acked"`
+	ClusterCount   int            `json:"cluster_count"`
+	Users          []types.UserID `json:"users"`
+	Justification  string         `json:"justification"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// Kinds of silencing of the rule summarized by DisabledRuleSummary
+const (
+	silencedByToggle = "toggle"
+	silencedForOrg   = "org"
+	silencedByAck    = "ack"
+)
+
+// ruleSilencing is the single disable, toggle or ack of the rule summarized by DisabledRuleSummary
+type ruleSilencing struct {
+	kind          string
+	ruleID        types.RuleID
+	userID        types.UserID
+	justification string
+	updatedAt     time.Time
+}
+
+// summarizeDisabledRules aggregates silencings of rules by rule ID, the summaries are ordered by rule ID
+func summarizeDisabledRules(silencings []ruleSilencing) []DisabledRuleSummary {
+	sort.SliceStable(silencings, func(i, j int) bool {
+		if silencings[i].ruleID != silencings[j].ruleID {
+			return silencings[i].ruleID < silencings[j].ruleID
+		}
+		return silencings[i].updatedAt.After(silencings[j].updatedAt)
+	})
+
+	summaries := make([]DisabledRuleSummary, 0)
+
+	for _, silencing := range silencings {
+		if len(summaries) == 0 || summaries[len(summaries)-1].RuleID != silencing.ruleID {
+			summaries = append(summaries, DisabledRuleSummary{
+				RuleID:    silencing.ruleID,
+				Users:     make([]types.UserID, 0),
+				UpdatedAt: silencing.updatedAt,
+			})
+		}
+		summary := &summaries[len(summaries)-1]
+
+		switch silencing.kind {
+		case silencedByToggle:
+			summary.ClusterCount++
+		case silencedForOrg:
+			summary.DisabledForOrg = true
+		case silencedByAck:
+			summary.Acked = true
+		}
+
+		if summary.Justification == "" {
+			summary.Justification = silencing.justification
+		}
+
+		if !containsUser(summary.Users, silencing.userID) {
+			summary.Users = append(summary.Users, silencing.userID)
+		}
+	}
+
+	for i := range summaries {
+		users := summaries[i].Users
+		sort.Slice(users, func(i, j int) bool {
+			return users[i] < users[j]
+		})
+	}
+
+	return summaries
+}
+
+// containsUser checks whether the user is in the list
+func containsUser(userIDs []types.UserID, userID types.UserID) bool {
+	for _, id := range userIDs {
+		if id == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleSilencingsQuery selects rules disabled for clusters of the organization by their toggles,
+// rules disabled for the whole organization and rules acked by it
+const ruleSilencingsQuery = `
+	SELECT 'toggle', rule_id, user_id, justification, updated_at
+	  FROM cluster_rule_toggle
+	 WHERE disabled = $2
+	   AND cluster_id IN (SELECT cluster FROM report WHERE org_id = $1 AND deleted_at IS NULL)
+	UNION ALL
+	SELECT 'org', rule_id, user_id, '', disabled_at FROM rule_disable_org WHERE org_id = $1
+	UNION ALL
+	SELECT 'ack', rule_id, user_id, justification, updated_at FROM rule_ack WHERE org_id = $1`
+
+// ListDisabledRulesForOrg returns summaries of rules disabled for clusters of the organization,
+// disabled for the whole organization or acked by it ordered by rule ID
+func (storage DBStorage) ListDisabledRulesForOrg(orgID types.OrgID) (_ []DisabledRuleSummary, err error) {
+	op := storage.startOperation("ListDisabledRulesForOrg", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	rows, err := storage.reads().QueryContext(op.ctx, ruleSilencingsQuery, orgID, RuleToggleDisable)
+	if err != nil {
+		return make([]DisabledRuleSummary, 0), err
+	}
+	defer closeRows(rows)
+
+	var silencings []ruleSilencing
+
+	for rows.Next() {
+		var silencing ruleSilencing
+
+		err = rows.Scan(
+			&silencing.kind,
+			&silencing.ruleID,
+			&silencing.userID,
+			&silencing.justification,
+			scanTimestamp(&silencing.updatedAt),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("ListDisabledRulesForOrg")
+			return make([]DisabledRuleSummary, 0), err
+		}
+
+		silencings = append(silencings, silencing)
+	}
+
+	if err := rows.Err(); err != nil {
+		return make([]DisabledRuleSummary, 0), err
+	}
+
+	return summarizeDisabledRules(silencings), nil
+}
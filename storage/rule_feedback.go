@@ -15,10 +15,12 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 
@@ -38,42 +40,129 @@ const (
 	UserVoteLike UserVote = 1
 )
 
-// UserFeedbackOnRule shows user's feedback on rule
+// feedbackMessageJoinCondition joins user's message to the vote row of the feedback
+const feedbackMessageJoinCondition = `msg.cluster_id = feedback.cluster_id
+		AND msg.rule_id = feedback.rule_id AND msg.error_key = feedback.error_key AND msg.user_id = feedback.user_id`
+
+// UserFeedbackOnRule shows user's feedback on rule or on its error key,
+// the error key is empty for feedback on the whole rule
 type UserFeedbackOnRule struct {
-	ClusterID types.ClusterName
-	RuleID    types.RuleID
-	UserID    types.UserID
-	Message   string
-	UserVote  UserVote
-	AddedAt   time.Time
-	UpdatedAt time.Time
+	ClusterID types.ClusterName `json:"cluster"`
+	RuleID    types.RuleID      `json:"rule"`
+	ErrorKey  types.ErrorKey    `json:"error_key"`
+	UserID    types.UserID      `json:"user_id"`
+	Message   string            `json:"message"`
+	UserVote  UserVote          `json:"user_vote"`
+	AddedAt   time.Time         `json:"added_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// RuleFeedbackStats summarizes feedback of users on the rule for clusters of an organization,
+// CommentingUsers is the number of distinct users who left a message on the rule
+type RuleFeedbackStats struct {
+	RuleID          types.RuleID `json:"rule_id"`
+	Likes           int          `json:"likes"`
+	Dislikes        int          `json:"dislikes"`
+	CommentingUsers int          `json:"commenting_users"`
+}
+
+// feedbackIDs returns IDs of the feedback reported by ItemNotFoundError,
+// the error key is left out for feedback on the whole rule
+func feedbackIDs(
+	clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+) []interface{} {
+	if len(errorKey) == 0 {
+		return []interface{}{clusterID, ruleID, userID}
+	}
+
+	return []interface{}{clusterID, ruleID, errorKey, userID}
 }
 
-// VoteOnRule likes or dislikes rule for cluster by user. If entry exists, it overwrites it
+// VoteOnRule likes or dislikes rule or its error key for cluster by user. If entry exists, it overwrites it.
+// Empty error key means the vote is on the whole rule, votes on the rule and on its error keys are independent.
 func (storage DBStorage) VoteOnRule(
 	clusterID types.ClusterName,
 	ruleID types.RuleID,
+	errorKey types.ErrorKey,
 	userID types.UserID,
 	userVote UserVote,
-) error {
-	return storage.addOrUpdateUserFeedbackOnRuleForCluster(clusterID, ruleID, userID, &userVote, nil)
+) (err error) {
+	op := storage.startOperation("VoteOnRule", write).forCluster(clusterID)
+	defer op.finish(&err)
+
+	return storage.addOrUpdateUserFeedbackOnRuleForCluster(
+		op.ctx, clusterID, ruleID, errorKey, userID, &userVote, nil,
+	)
 }
 
-// AddOrUpdateFeedbackOnRule adds feedback on rule for cluster by user. If entry exists, it overwrites it
+// ResetVoteOnRule takes back user's vote on rule or its error key for cluster. The feedback is deleted
+// when there is no message left by the user, otherwise only the vote is reset to UserVoteNone
+func (storage DBStorage) ResetVoteOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+) (err error) {
+	op := storage.startOperation("ResetVoteOnRule", write).forCluster(clusterID)
+	defer op.finish(&err)
+
+	tx, err := storage.connection.BeginTx(op.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(op.ctx, `
+		DELETE FROM cluster_rule_user_feedback
+		WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4 AND NOT EXISTS (
+			SELECT 1 FROM cluster_rule_user_message
+			WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4
+		)
+	`, clusterID, ruleID, errorKey, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("ResetVoteOnRule")
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(op.ctx, `
+		UPDATE cluster_rule_user_feedback SET user_vote = $5, updated_at = $6
+		WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4
+	`, clusterID, ruleID, errorKey, userID, UserVoteNone, timeNow())
+	if err != nil {
+		log.Error().Err(err).Msg("ResetVoteOnRule")
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddOrUpdateFeedbackOnRule adds feedback on rule or its error key for cluster by user.
+// If entry exists, it overwrites it. Empty error key means the feedback is on the whole rule.
 func (storage DBStorage) AddOrUpdateFeedbackOnRule(
 	clusterID types.ClusterName,
 	ruleID types.RuleID,
+	errorKey types.ErrorKey,
 	userID types.UserID,
 	message string,
-) error {
-	return storage.addOrUpdateUserFeedbackOnRuleForCluster(clusterID, ruleID, userID, nil, &message)
+) (err error) {
+	op := storage.startOperation("AddOrUpdateFeedbackOnRule", write).forCluster(clusterID)
+	defer op.finish(&err)
+
+	return storage.addOrUpdateUserFeedbackOnRuleForCluster(
+		op.ctx, clusterID, ruleID, errorKey, userID, nil, &message,
+	)
 }
 
 // addOrUpdateUserFeedbackOnRuleForCluster adds or updates feedback
-// will update user vote and messagePtr if the pointers are not nil
+// will update user vote and messagePtr if the pointers are not nil.
+// Votes and messages are stored in separate tables, so changing the vote
+// doesn't rewrite the message. Empty message is not stored at all.
 func (storage DBStorage) addOrUpdateUserFeedbackOnRuleForCluster(
+	ctx context.Context,
 	clusterID types.ClusterName,
 	ruleID types.RuleID,
+	errorKey types.ErrorKey,
 	userID types.UserID,
 	userVotePtr *UserVote,
 	messagePtr *string,
@@ -91,6 +180,15 @@ func (storage DBStorage) addOrUpdateUserFeedbackOnRuleForCluster(
 	if messagePtr != nil {
 		updateMessage = true
 		message = *messagePtr
+
+		if length := utf8.RuneCountInString(message); length > storage.maxFeedbackMessageLength {
+			return &ValidationError{
+				ParamName: "message",
+				ErrString: fmt.Sprintf(
+					"at most %v characters expected, got %v", storage.maxFeedbackMessageLength, length,
+				),
+			}
+		}
 	}
 
 	query, err := storage.constructUpsertClusterRuleUserFeedback(updateVote, updateMessage)
@@ -98,92 +196,445 @@ func (storage DBStorage) addOrUpdateUserFeedbackOnRuleForCluster(
 		return err
 	}
 
-	statement, err := storage.connection.Prepare(query)
+	err = storage.withRetries(ctx, "addOrUpdateUserFeedbackOnRuleForCluster", func() error {
+		return storage.writeUserFeedbackOnRule(
+			ctx, query, clusterID, ruleID, errorKey, userID, userVote, updateMessage, message,
+		)
+	})
+	if err != nil {
+		return err
+	}
+
+	metrics.FeedbackOnRules.Inc()
+
+	return nil
+}
+
+// writeUserFeedbackOnRule writes the vote and optionally the message of user's feedback in a single transaction
+func (storage DBStorage) writeUserFeedbackOnRule(
+	ctx context.Context,
+	query string,
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+	userVote UserVote,
+	updateMessage bool,
+	message string,
+) error {
+	statement, err := storage.statements.prepare(ctx, storage.connection, query)
+	if err != nil {
+		return err
+	}
+
+	tx, err := storage.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	now := timeNow()
+
+	_, err = tx.StmtContext(ctx, statement).ExecContext(ctx, clusterID, ruleID, errorKey, userID, userVote, now)
 	if err != nil {
+		log.Error().Err(err).Msg("addOrUpdateUserFeedbackOnRuleForCluster")
+		_ = tx.Rollback()
 		return err
 	}
-	defer func() {
-		err := statement.Close()
+
+	if updateMessage {
+		err = storage.writeUserMessageOnRule(ctx, tx, clusterID, ruleID, errorKey, userID, message, now)
 		if err != nil {
-			log.Error().Err(err).Msg("Unable to close statement")
+			log.Error().Err(err).Msg("addOrUpdateUserFeedbackOnRuleForCluster")
+			_ = tx.Rollback()
+			return err
 		}
-	}()
+	}
+
+	return tx.Commit()
+}
 
-	now := time.Now()
+// userMessageUpsert writes user's message on rule or its error key for cluster
+var userMessageUpsert = upsertStatement{
+	table:           "cluster_rule_user_message",
+	columns:         []string{"cluster_id", "rule_id", "error_key", "user_id", "message", "updated_at"},
+	conflictColumns: []string{"cluster_id", "rule_id", "error_key", "user_id"},
+	updates:         []string{"message = $5", "updated_at = $6"},
+}
+
+// writeUserMessageOnRule stores user's message on rule for cluster,
+// the message is deleted when it's empty
+func (storage DBStorage) writeUserMessageOnRule(
+	ctx context.Context,
+	tx *sql.Tx,
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+	message string,
+	updatedAt time.Time,
+) error {
+	if len(message) == 0 {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM cluster_rule_user_message
+			WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4
+		`, clusterID, ruleID, errorKey, userID)
+		return err
+	}
 
-	_, err = statement.Exec(clusterID, ruleID, userID, userVote, now, now, message)
+	query, ok := storage.dialect().upsert(userMessageUpsert)
+	if !ok {
+		return fmt.Errorf("writing feedback messages with DB %v is not supported", storage.dbDriverType)
+	}
+
+	_, err := tx.ExecContext(ctx, query, clusterID, ruleID, errorKey, userID, message, updatedAt)
+	return err
+}
+
+// DeleteUserFeedbackOnRule deletes user's feedback (both vote and message) on rule or its error key for cluster
+func (storage DBStorage) DeleteUserFeedbackOnRule(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+) (err error) {
+	op := storage.startOperation("DeleteUserFeedbackOnRule", write).forCluster(clusterID)
+	defer op.finish(&err)
+
+	tx, err := storage.connection.BeginTx(op.ctx, nil)
 	if err != nil {
-		log.Error().Err(err).Msg("addOrUpdateUserFeedbackOnRuleForCluster")
 		return err
 	}
 
-	metrics.FeedbackOnRules.Inc()
+	_, err = tx.ExecContext(
+		op.ctx,
+		"DELETE FROM cluster_rule_user_message WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4",
+		clusterID, ruleID, errorKey, userID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("DeleteUserFeedbackOnRule")
+		_ = tx.Rollback()
+		return err
+	}
+
+	result, err := tx.ExecContext(
+		op.ctx,
+		"DELETE FROM cluster_rule_user_feedback WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4",
+		clusterID, ruleID, errorKey, userID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("DeleteUserFeedbackOnRule")
+		_ = tx.Rollback()
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return newItemNotFoundError(ItemKindFeedback, feedbackIDs(clusterID, ruleID, errorKey, userID)...)
+	}
+
+	metrics.FeedbackOnRulesDeleted.Inc()
 
 	return nil
 }
 
+// constructUpsertClusterRuleUserFeedback constructs upsert of the vote row of the feedback,
+// the message itself is written separately by writeUserMessageOnRule. The time of the write
+// is both added_at and updated_at of the inserted row, added_at of the stored row is kept.
 func (storage DBStorage) constructUpsertClusterRuleUserFeedback(updateVote bool, updateMessage bool) (string, error) {
-	var query string
-
-	switch storage.dbDriverType {
-	case DBDriverSQLite3, DBDriverPostgres, DBDriverGeneral:
-		query = `
-			INSERT INTO cluster_rule_user_feedback
-			(cluster_id, rule_id, user_id, user_vote, added_at, updated_at, message)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-		`
-
-		var updates []string
+	statement := upsertStatement{
+		table:           "cluster_rule_user_feedback",
+		columns:         []string{"cluster_id", "rule_id", "error_key", "user_id", "user_vote", "added_at", "updated_at"},
+		values:          []string{"$1", "$2", "$3", "$4", "$5", "$6", "$6"},
+		conflictColumns: []string{"cluster_id", "rule_id", "error_key", "user_id"},
+	}
 
-		if updateVote {
-			updates = append(updates, "user_vote = $4")
-		}
+	if updateVote {
+		statement.updates = append(statement.updates, "user_vote = $5")
+	}
 
-		if updateMessage {
-			updates = append(updates, "message = $7")
-		}
+	if updateVote || updateMessage {
+		statement.updates = append(statement.updates, "updated_at = $6")
+	}
 
-		if len(updates) > 0 {
-			updates = append(updates, "updated_at = $6")
-			query += "ON CONFLICT (cluster_id, rule_id, user_id) DO UPDATE SET "
-			query += strings.Join(updates, ", ")
-		}
-	default:
+	query, ok := storage.dialect().upsert(statement)
+	if !ok {
 		return "", fmt.Errorf("DB driver %v is not supported", storage.dbDriverType)
 	}
 
 	return query, nil
 }
 
-// GetUserFeedbackOnRule gets user feedback from db
+// GetUserFeedbackOnRule gets user feedback on rule or its error key from db,
+// empty error key selects feedback on the whole rule
 func (storage DBStorage) GetUserFeedbackOnRule(
-	clusterID types.ClusterName, ruleID types.RuleID, userID types.UserID,
-) (*UserFeedbackOnRule, error) {
+	clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+) (_ *UserFeedbackOnRule, err error) {
+	op := storage.startOperation("GetUserFeedbackOnRule", fastRead).forCluster(clusterID)
+	defer op.finish(&err)
+
 	feedback := UserFeedbackOnRule{}
 
-	err := storage.connection.QueryRow(
-		`SELECT cluster_id, rule_id, user_id, message, user_vote, added_at, updated_at
-		FROM cluster_rule_user_feedback
-		WHERE cluster_id = $1 AND rule_id = $2 AND user_id = $3`,
-		clusterID, ruleID, userID,
+	err = storage.reads().QueryRowContext(
+		op.ctx,
+		`SELECT feedback.cluster_id, feedback.rule_id, feedback.error_key, feedback.user_id, COALESCE(msg.message, ''),
+			feedback.user_vote, feedback.added_at, feedback.updated_at
+		FROM cluster_rule_user_feedback feedback
+		LEFT JOIN cluster_rule_user_message msg ON `+feedbackMessageJoinCondition+`
+		WHERE feedback.cluster_id = $1 AND feedback.rule_id = $2 AND feedback.error_key = $3 AND feedback.user_id = $4`,
+		clusterID, ruleID, errorKey, userID,
 	).Scan(
 		&feedback.ClusterID,
 		&feedback.RuleID,
+		&feedback.ErrorKey,
 		&feedback.UserID,
 		&feedback.Message,
 		&feedback.UserVote,
-		&feedback.AddedAt,
-		&feedback.UpdatedAt,
+		scanTimestamp(&feedback.AddedAt),
+		scanTimestamp(&feedback.UpdatedAt),
 	)
 
 	switch {
-	case err == sql.ErrNoRows:
-		return nil, &ItemNotFoundError{
-			ItemID: fmt.Sprintf("%v/%v/%v", clusterID, ruleID, userID),
-		}
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, newItemNotFoundError(ItemKindFeedback, feedbackIDs(clusterID, ruleID, errorKey, userID)...).withCause(err)
 	case err != nil:
 		return nil, err
 	}
 
 	return &feedback, nil
 }
+
+// ListFeedbacksForCluster reads feedback of all users on all rules for the cluster,
+// the most recently updated feedback goes first
+func (storage DBStorage) ListFeedbacksForCluster(clusterID types.ClusterName) (_ []UserFeedbackOnRule, err error) {
+	op := storage.startOperation("ListFeedbacksForCluster", fastRead).forCluster(clusterID)
+	defer op.finish(&err)
+
+	feedbacks := make([]UserFeedbackOnRule, 0)
+
+	rows, err := storage.reads().QueryContext(
+		op.ctx,
+		`SELECT feedback.cluster_id, feedback.rule_id, feedback.error_key, feedback.user_id, COALESCE(msg.message, ''),
+			feedback.user_vote, feedback.added_at, feedback.updated_at
+		FROM cluster_rule_user_feedback feedback
+		LEFT JOIN cluster_rule_user_message msg ON `+feedbackMessageJoinCondition+`
+		WHERE feedback.cluster_id = $1
+		ORDER BY feedback.updated_at DESC`,
+		clusterID,
+	)
+	if err != nil {
+		return feedbacks, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var feedback UserFeedbackOnRule
+
+		err = rows.Scan(
+			&feedback.ClusterID,
+			&feedback.RuleID,
+			&feedback.ErrorKey,
+			&feedback.UserID,
+			&feedback.Message,
+			&feedback.UserVote,
+			scanTimestamp(&feedback.AddedAt),
+			scanTimestamp(&feedback.UpdatedAt),
+		)
+		if err == nil {
+			feedbacks = append(feedbacks, feedback)
+		} else {
+			log.Error().Err(err).Msg("ListFeedbacksForCluster")
+		}
+	}
+
+	return feedbacks, nil
+}
+
+// GetUserFeedbackOnRules gets user's votes on all specified rules for cluster by a single query,
+// UserVoteNone is returned for rules without any feedback. Only votes on the whole rules are returned.
+func (storage DBStorage) GetUserFeedbackOnRules(
+	clusterID types.ClusterName, ruleIDs []types.RuleID, userID types.UserID,
+) (_ map[types.RuleID]UserVote, err error) {
+	op := storage.startOperation("GetUserFeedbackOnRules", fastRead).forCluster(clusterID)
+	defer op.finish(&err)
+
+	votes := make(map[types.RuleID]UserVote, len(ruleIDs))
+
+	if len(ruleIDs) == 0 {
+		return votes, nil
+	}
+
+	ruleValues := make([]interface{}, len(ruleIDs))
+
+	for i, ruleID := range ruleIDs {
+		votes[ruleID] = UserVoteNone
+		ruleValues[i] = ruleID
+	}
+
+	args := storage.dialect().newQueryArgs(clusterID, userID)
+	query := `SELECT rule_id, user_vote FROM cluster_rule_user_feedback
+		WHERE cluster_id = $1 AND user_id = $2 AND error_key = '' AND rule_id IN ` + args.addList(ruleValues...)
+
+	rows, err := storage.reads().QueryContext(op.ctx, query, args.values...)
+	if err != nil {
+		return votes, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			ruleID types.RuleID
+			vote   UserVote
+		)
+
+		if err := rows.Scan(&ruleID, &vote); err != nil {
+			return votes, err
+		}
+
+		votes[ruleID] = vote
+	}
+
+	return votes, rows.Err()
+}
+
+// GetVotesForRule counts likes and dislikes of the rule from all users for all clusters,
+// votes on error keys of the rule are counted too
+func (storage DBStorage) GetVotesForRule(ruleID types.RuleID) (likes int, dislikes int, err error) {
+	op := storage.startOperation("GetVotesForRule", heavyAggregation)
+	defer op.finish(&err)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT user_vote, COUNT(*) FROM cluster_rule_user_feedback
+		WHERE rule_id = $1 AND user_vote IN ($2, $3)
+		GROUP BY user_vote`,
+		ruleID, UserVoteLike, UserVoteDislike,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closeRows(rows)
+
+	return countVotes(rows)
+}
+
+// GetVotesForRuleByOrg counts likes and dislikes of the rule from all users
+// for clusters of the given organization
+func (storage DBStorage) GetVotesForRuleByOrg(
+	orgID types.OrgID, ruleID types.RuleID,
+) (likes int, dislikes int, err error) {
+	op := storage.startOperation("GetVotesForRuleByOrg", heavyAggregation).forOrg(orgID)
+	defer op.finish(&err)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT feedback.user_vote, COUNT(*) FROM cluster_rule_user_feedback feedback
+		JOIN report ON report.cluster = feedback.cluster_id
+		WHERE report.org_id = $1 AND report.deleted_at IS NULL
+		  AND feedback.rule_id = $2 AND feedback.user_vote IN ($3, $4)
+		GROUP BY feedback.user_vote`,
+		orgID, ruleID, UserVoteLike, UserVoteDislike,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closeRows(rows)
+
+	return countVotes(rows)
+}
+
+// GetRuleFeedbackStatsForOrg summarizes feedback of users on each rule for clusters of the organization,
+// the most disliked rules go first
+func (storage DBStorage) GetRuleFeedbackStatsForOrg(orgID types.OrgID) (_ []RuleFeedbackStats, err error) {
+	op := storage.startOperation("GetRuleFeedbackStatsForOrg", heavyAggregation).forOrg(orgID)
+	defer op.finish(&err)
+
+	stats := make([]RuleFeedbackStats, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT feedback.rule_id,
+			SUM(CASE WHEN feedback.user_vote = $2 THEN 1 ELSE 0 END) AS likes,
+			SUM(CASE WHEN feedback.user_vote = $3 THEN 1 ELSE 0 END) AS dislikes,
+			COUNT(DISTINCT msg.user_id)
+		FROM cluster_rule_user_feedback feedback
+		JOIN report ON report.cluster = feedback.cluster_id
+		LEFT JOIN cluster_rule_user_message msg ON `+feedbackMessageJoinCondition+`
+		WHERE report.org_id = $1 AND report.deleted_at IS NULL
+		GROUP BY feedback.rule_id
+		ORDER BY dislikes DESC, feedback.rule_id`,
+		orgID, UserVoteLike, UserVoteDislike,
+	)
+	if err != nil {
+		return stats, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var ruleStats RuleFeedbackStats
+
+		err := rows.Scan(&ruleStats.RuleID, &ruleStats.Likes, &ruleStats.Dislikes, &ruleStats.CommentingUsers)
+		if err != nil {
+			return stats, err
+		}
+
+		stats = append(stats, ruleStats)
+	}
+
+	return stats, rows.Err()
+}
+
+// countVotes reads number of likes and dislikes from rows of (user_vote, count) pairs
+func countVotes(rows *sql.Rows) (likes int, dislikes int, err error) {
+	for rows.Next() {
+		var (
+			vote  UserVote
+			count int
+		)
+
+		if err := rows.Scan(&vote, &count); err != nil {
+			return 0, 0, err
+		}
+
+		switch vote {
+		case UserVoteLike:
+			likes = count
+		case UserVoteDislike:
+			dislikes = count
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	return likes, dislikes, nil
+}
+
+// CleanupOrphanedFeedback deletes users' feedback on clusters which don't have any report anymore
+// and returns number of deleted feedbacks. Feedback on clusters with soft-deleted reports is kept,
+// as the reports can still be restored. Messages of the deleted feedback are deleted by its foreign key.
+func (storage DBStorage) CleanupOrphanedFeedback() (_ int, err error) {
+	op := storage.startOperation("CleanupOrphanedFeedback", maintenance)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(op.ctx, `
+		DELETE FROM cluster_rule_user_feedback
+		WHERE NOT EXISTS (SELECT 1 FROM report WHERE report.cluster = cluster_rule_user_feedback.cluster_id)`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(deleted), nil
+}
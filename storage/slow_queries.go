@@ -0,0 +1,71 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// driverName returns name of the database driver used in logs
+func driverName(driverType DBDriver) string {
+	switch driverType {
+	case DBDriverSQLite3:
+		return "sqlite3"
+	case DBDriverPostgres:
+		return "postgres"
+	case DBDriverGeneral:
+		return "general"
+	default:
+		return "unknown"
+	}
+}
+
+// forOrg sets the organization the operation works with, it's logged when the operation is slow
+func (op *operation) forOrg(orgID types.OrgID) *operation {
+	op.orgID = &orgID
+	return op
+}
+
+// forCluster sets the cluster the operation works with, it's logged when the operation is slow
+func (op *operation) forCluster(clusterName types.ClusterName) *operation {
+	op.clusterName = &clusterName
+	return op
+}
+
+// logIfSlow logs a warning when the operation took longer than the slow query threshold,
+// only identifiers of the data are logged, never the data itself
+func (op *operation) logIfSlow(duration time.Duration) {
+	if op.slowQueryThreshold <= 0 || duration <= op.slowQueryThreshold {
+		return
+	}
+
+	event := log.Warn().
+		Str("method", op.name).
+		Str("duration", duration.String()).
+		Str("threshold", op.slowQueryThreshold.String()).
+		Str("driver", driverName(op.driver))
+	if op.orgID != nil {
+		event = event.Uint64("organization", uint64(*op.orgID))
+	}
+	if op.clusterName != nil {
+		event = event.Str("cluster", string(*op.clusterName))
+	}
+
+	event.Msg("Slow storage operation")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+)
+
+const (
+	slowQueryThreshold = 10 * time.Millisecond
+	slowQueryDelay     = 50 * time.Millisecond
+	secretReport       = `{"secret": "report payload"}`
+)
+
+func expectSlowReportRead(expects sqlmock.Sqlmock, delay time.Duration) {
+	expects.ExpectQuery("SELECT report, reported_at, last_checked_at FROM report").
+		WillDelayFor(delay).
+		WillReturnRows(sqlmock.NewRows([]string{"report", "reported_at", "last_checked_at"}).
+			AddRow(secretReport, time.Now(), time.Now()))
+}
+
+func TestDBStorageSlowQueryLogged(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.Logger = zerolog.New(buf)
+
+	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	storage.SetSlowQueryThreshold(mockStorage.(*storage.DBStorage), slowQueryThreshold)
+	expectSlowReportRead(expects, slowQueryDelay)
+
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	logged := buf.String()
+	assert.Contains(t, logged, "Slow storage operation")
+	assert.Contains(t, logged, `"method":"ReadReportForCluster"`)
+	assert.Contains(t, logged, `"organization":1`)
+	assert.Contains(t, logged, `"cluster":"`+string(testdata.ClusterName)+`"`)
+	assert.Contains(t, logged, `"driver":"postgres"`)
+	assert.NotContains(t, logged, "report payload")
+}
+
+func TestDBStorageFastQueryNotLogged(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.Logger = zerolog.New(buf)
+
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	storage.SetSlowQueryThreshold(mockStorage.(*storage.DBStorage), time.Minute)
+	expectSlowReportRead(expects, 0)
+
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	assert.NotContains(t, buf.String(), "Slow storage operation")
+}
+
+func TestDBStorageSlowQueryLoggingDisabled(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.Logger = zerolog.New(buf)
+
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	storage.SetSlowQueryThreshold(mockStorage.(*storage.DBStorage), 0)
+	expectSlowReportRead(expects, slowQueryDelay)
+
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	assert.NotContains(t, buf.String(), "Slow storage operation")
+}
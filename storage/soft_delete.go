@@ -0,0 +1,149 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// Reports are soft-deleted by setting deleted_at column of their rows. Soft-deleted reports
+// are not visible to any read, but their history, rule hits, users' feedback and rule toggles are kept,
+// so the cluster can be restored by RestoreCluster or just by writing a newer report of it.
+// Reports soft-deleted long enough are deleted for good by PurgeSoftDeleted.
+
+// SoftDeleteReportsForOrg marks all reports of the organization as deleted, so they are not visible
+// to reads until they're restored or purged, and returns number of soft-deleted reports
+func (storage DBStorage) SoftDeleteReportsForOrg(orgID types.OrgID) (_ int, err error) {
+	op := storage.startOperation("SoftDeleteReportsForOrg", write).forOrg(orgID)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx,
+		"UPDATE report SET deleted_at = $2 WHERE org_id = $1 AND deleted_at IS NULL",
+		orgID, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// clusters of the organization aren't known anymore
+	storage.orgIDs.purge()
+
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "SoftDeleteReportsForOrg", OrgID: orgID, DeletedRows: int(deleted),
+	})
+
+	return int(deleted), nil
+}
+
+// SoftDeleteReportsForCluster marks reports of the cluster as deleted, so they are not visible
+// to reads until they're restored or purged, and returns number of soft-deleted reports
+func (storage DBStorage) SoftDeleteReportsForCluster(clusterName types.ClusterName) (_ int, err error) {
+	op := storage.startOperation("SoftDeleteReportsForCluster", write).forCluster(clusterName)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx,
+		"UPDATE report SET deleted_at = $2 WHERE cluster = $1 AND deleted_at IS NULL",
+		clusterName, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	storage.orgIDs.forget(clusterName)
+
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "SoftDeleteReportsForCluster", ClusterName: clusterName, DeletedRows: int(deleted),
+	})
+
+	return int(deleted), nil
+}
+
+// RestoreCluster restores soft-deleted reports of the cluster, ItemNotFoundError is returned
+// when there is no soft-deleted report of the cluster
+func (storage DBStorage) RestoreCluster(clusterName types.ClusterName) (err error) {
+	op := storage.startOperation("RestoreCluster", write).forCluster(clusterName)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx,
+		"UPDATE report SET deleted_at = NULL WHERE cluster = $1 AND deleted_at IS NOT NULL",
+		clusterName,
+	)
+	if err != nil {
+		return err
+	}
+
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if restored == 0 {
+		return newItemNotFoundError(ItemKindReport, clusterName)
+	}
+
+	// the cached organization may not be the lowest one of the cluster anymore
+	storage.orgIDs.forget(clusterName)
+
+	// nothing is deleted by the restore, it's recorded to complete the trail of the soft-deletion
+	storage.recordAudit(op.ctx, AuditLogEntry{Operation: "RestoreCluster", ClusterName: clusterName})
+
+	return nil
+}
+
+// PurgeSoftDeleted deletes reports soft-deleted longer than olderThan ago together with their history,
+// rule hits, processing errors, users' feedback and rules toggled for the clusters and returns number
+// of deleted reports
+func (storage DBStorage) PurgeSoftDeleted(olderThan time.Duration) (_ int, err error) {
+	op := storage.startOperation("PurgeSoftDeleted", maintenance)
+	defer op.finish(&err)
+
+	const purgedClusters = "(SELECT cluster FROM report WHERE deleted_at < $1)"
+
+	var deleted DeletedRows
+
+	err = storage.deleteCounted(op.ctx, []countedDeletion{
+		{"DELETE FROM cluster_rule_user_message WHERE cluster_id IN " + purgedClusters, &deleted.FeedbackMessages},
+		{"DELETE FROM cluster_rule_user_feedback WHERE cluster_id IN " + purgedClusters, &deleted.Feedback},
+		{"DELETE FROM cluster_rule_toggle WHERE cluster_id IN " + purgedClusters, &deleted.Toggles},
+		{"DELETE FROM rule_hit WHERE cluster IN " + purgedClusters, &deleted.RuleHits},
+		{"DELETE FROM report_history WHERE cluster IN " + purgedClusters, &deleted.ReportHistory},
+		{"DELETE FROM consumer_error WHERE cluster IN " + purgedClusters, &deleted.ConsumerErrors},
+		{"DELETE FROM report WHERE deleted_at < $1", &deleted.Reports},
+	}, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+
+	storage.recordAudit(op.ctx, AuditLogEntry{Operation: "PurgeSoftDeleted", DeletedRows: deleted.total()})
+
+	return deleted.Reports, nil
+}
@@ -0,0 +1,237 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// assertClusterVisible checks whether the report of the cluster is visible to reads
+func assertClusterVisible(t *testing.T, mockStorage storage.Storage, visible bool) {
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	if visible {
+		helpers.FailOnError(t, err)
+	} else {
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+	}
+
+	clusters, err := mockStorage.ListOfClustersForOrg(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, visible, len(clusters) == 1, "clusters of organization: %v", clusters)
+
+	count, err := mockStorage.ReportsCount()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, visible, count == 1, "reports count: %v", count)
+}
+
+func TestSoftDeleteReportsForClusterAndRestore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 1,
+		))
+
+		deleted, err := mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, deleted)
+		assertClusterVisible(t, mockStorage, false)
+
+		_, err = mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+
+		// already soft-deleted report isn't soft-deleted again
+		deleted, err = mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		helpers.FailOnError(t, mockStorage.RestoreCluster(testdata.ClusterName))
+		assertClusterVisible(t, mockStorage, true)
+
+		ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Len(t, ruleHits, 3)
+	})
+}
+
+func TestSoftDeleteReportsForOrg(t *testing.T) {
+	const (
+		otherOrgID       = types.OrgID(2)
+		otherClusterName = types.ClusterName("9b4c1a3e-7c6d-4f0e-8a2b-3d5e6f7a8b9c")
+	)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 1,
+		))
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			otherOrgID, otherClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 2,
+		))
+
+		deleted, err := mockStorage.SoftDeleteReportsForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		orgs, err := mockStorage.ListOfOrgs()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.OrgID{otherOrgID}, orgs)
+
+		count, err := mockStorage.ReportsCount()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, count)
+
+		_, err = mockStorage.GetOrgIDByClusterID(testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindCluster, testdata.ClusterName)
+	})
+}
+
+func TestRestoreClusterNotSoftDeleted(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.RestoreCluster(testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.ClusterName)
+
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 1,
+		))
+
+		// live report can't be restored
+		err = mockStorage.RestoreCluster(testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.ClusterName)
+	})
+}
+
+func TestSoftDeletedClusterRestoredByNewerReport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 1,
+		))
+
+		_, err := mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		// older report doesn't restore the cluster
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt.Add(-time.Hour), 2,
+		))
+		assertClusterVisible(t, mockStorage, false)
+
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt.Add(time.Hour), 3,
+		))
+		assertClusterVisible(t, mockStorage, true)
+	})
+}
+
+func TestPurgeSoftDeleted(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 1,
+		))
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message",
+		))
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+		))
+
+		// live reports are never purged
+		purged, err := mockStorage.PurgeSoftDeleted(-time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, purged)
+
+		_, err = mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		purged, err = mockStorage.PurgeSoftDeleted(time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, purged)
+
+		// negative retention purges reports soft-deleted at this very moment as well
+		purged, err = mockStorage.PurgeSoftDeleted(-time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, purged)
+
+		err = mockStorage.RestoreCluster(testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.ClusterName)
+
+		_, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		assertItemNotFound(t, err, storage.ItemKindFeedback, testdata.ClusterName, testdata.Rule1ID, testdata.UserID)
+
+		err = mockStorage.DeleteRuleToggleForCluster(testdata.ClusterName, testdata.Rule1ID)
+		assertItemNotFound(t, err, storage.ItemKindToggle, testdata.ClusterName, testdata.Rule1ID)
+
+		_, err = mockStorage.GetLatestKafkaOffset()
+		helpers.FailOnError(t, err)
+	})
+}
+
+// TestSoftDeletedReportsNotCheckedForConsistency checks that soft-deleted reports are neither
+// checked for consistency nor counted for NULL timestamps and that their rule hits are not repaired
+func TestSoftDeletedReportsNotCheckedForConsistency(t *testing.T) {
+	mockStorage, connection := mustGetStorageWithConsistencyCheckReports(t)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	mustSeedRuleHitsDrift(t, connection)
+
+	for _, clusterName := range consistencyCheckClusters[:2] {
+		_, err := mockStorage.SoftDeleteReportsForCluster(clusterName)
+		helpers.FailOnError(t, err)
+	}
+	_, err := connection.Exec(
+		"UPDATE report SET last_checked_at = NULL WHERE cluster = $1", consistencyCheckClusters[1],
+	)
+	helpers.FailOnError(t, err)
+
+	summary, err := storage.CheckConsistency(context.Background(), mockStorage, 2, true)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, 1, summary.Checked)
+	assert.Len(t, summary.Issues, 1)
+	assert.Equal(t, consistencyCheckClusters[2], summary.Issues[0].ClusterName)
+	assert.Equal(t, 0, summary.NullTimestamps)
+
+	// missing rule hit of the soft-deleted cluster is not written back
+	var ruleHits int
+	err = connection.QueryRow(
+		"SELECT COUNT(*) FROM rule_hit WHERE cluster = $1", consistencyCheckClusters[0],
+	).Scan(&ruleHits)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 2, ruleHits)
+}
+
+func TestSoftDeleteClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.SoftDeleteReportsForOrg(testdata.OrgID)
+	expectErrorClosedStorage(t, err)
+
+	_, err = mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+	expectErrorClosedStorage(t, err)
+
+	err = mockStorage.RestoreCluster(testdata.ClusterName)
+	expectErrorClosedStorage(t, err)
+
+	_, err = mockStorage.PurgeSoftDeleted(time.Hour)
+	expectErrorClosedStorage(t, err)
+}
@@ -21,14 +21,21 @@ import (
 	"database/sql"
 	sql_driver "database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/gchaincl/sqlhooks"
 	"github.com/rs/zerolog"
 )
 
+// sqlHooks logs SQL queries. Values of query arguments are redacted (only their types
+// and lengths are logged) unless LogArgs is true, because they contain reports
+// and feedback messages. Queries longer than MaxQueryLength are truncated,
+// 0 means that queries are never truncated.
 type sqlHooks struct {
 	SQLQueriesLogger *zerolog.Logger
+	LogArgs          bool
+	MaxQueryLength   int
 }
 
 type sqlHooksKey int
@@ -42,15 +49,48 @@ const (
 // second arg is params array
 const logFormatterString = "query `%+v` with params `%+v`"
 
-// Before is called before the query was executed allowing yout to log what you asked db to do
-func (h *sqlHooks) Before(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+// redactArg replaces value of query argument by its type and length
+func redactArg(arg interface{}) string {
+	switch arg := arg.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return fmt.Sprintf("string(len=%d)", len(arg))
+	case []byte:
+		return fmt.Sprintf("[]byte(len=%d)", len(arg))
+	default:
+		return fmt.Sprintf("%T", arg)
+	}
+}
+
+// formatQuery returns truncated query and its arguments (redacted unless LogArgs is set)
+// as they are logged
+func (h *sqlHooks) formatQuery(query string, args []interface{}) (string, interface{}) {
+	if h.MaxQueryLength > 0 && len(query) > h.MaxQueryLength {
+		query = fmt.Sprintf("%v... (%d bytes truncated)", query[:h.MaxQueryLength], len(query)-h.MaxQueryLength)
+	}
+
+	if !h.LogArgs {
+		redactedArgs := make([]interface{}, len(args))
+		for i, arg := range args {
+			redactedArgs[i] = redactArg(arg)
+		}
+		args = redactedArgs
+	}
+
 	jsonArgs, err := json.Marshal(args)
-	if err == nil {
-		h.SQLQueriesLogger.Printf(logFormatterString+"\n", query, string(jsonArgs))
-	} else {
-		h.SQLQueriesLogger.Printf(logFormatterString+"\n", query, args)
+	if err != nil {
+		return query, args
 	}
 
+	return query, string(jsonArgs)
+}
+
+// Before is called before the query was executed allowing yout to log what you asked db to do
+func (h *sqlHooks) Before(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	loggedQuery, loggedArgs := h.formatQuery(query, args)
+	h.SQLQueriesLogger.Printf(logFormatterString+"\n", loggedQuery, loggedArgs)
+
 	return context.WithValue(ctx, sqlHooksKeyQueryBeginTime, time.Now()), nil
 }
 
@@ -59,33 +99,31 @@ func (h *sqlHooks) Before(ctx context.Context, query string, args ...interface{}
 func (h *sqlHooks) After(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
 	beginTime := ctx.Value(sqlHooksKeyQueryBeginTime).(time.Time)
 
-	jsonArgs, err := json.Marshal(args)
-	if err == nil {
-		h.SQLQueriesLogger.Printf(
-			logFormatterString+" took %s\n",
-			query, string(jsonArgs), time.Since(beginTime),
-		)
-	} else {
-		h.SQLQueriesLogger.Printf(
-			logFormatterString+" took %s\n",
-			query, args, time.Since(beginTime),
-		)
-	}
+	loggedQuery, loggedArgs := h.formatQuery(query, args)
+	h.SQLQueriesLogger.Printf(logFormatterString+" took %s\n", loggedQuery, loggedArgs, time.Since(beginTime))
 
 	return ctx, nil
 }
 
 // InitSQLDriverWithLogs initializes wrapped version of driver with logging sql queries
-// and returns its name
+// and returns its name. Values of query arguments are logged only when logArgs is true
+// and queries are truncated to maxQueryLength (0 means no limit). The driver is
+// registered once per process, so the first maxQueryLength is used for all connections.
 func InitSQLDriverWithLogs(
 	realDriver sql_driver.Driver,
 	realDriverName string,
 	logger *zerolog.Logger,
+	logArgs bool,
+	maxQueryLength int,
 ) string {
 	// linear search is not gonna be an issue since there's not many drivers
 	// and we call New() only ones/twice per process life
 	foundHooksDriver := false
 	hooksDriverName := realDriverName + "WithHooks"
+	if logArgs {
+		// separate driver, so redaction can't be disabled by a previous registration
+		hooksDriverName += "AndArgs"
+	}
 
 	for _, existingDriver := range sql.Drivers() {
 		if existingDriver == hooksDriverName {
@@ -97,6 +135,8 @@ func InitSQLDriverWithLogs(
 	if !foundHooksDriver {
 		sql.Register(hooksDriverName, sqlhooks.Wrap(realDriver, &sqlHooks{
 			SQLQueriesLogger: logger,
+			LogArgs:          logArgs,
+			MaxQueryLength:   maxQueryLength,
 		}))
 	}
 
@@ -19,18 +19,25 @@ package storage_test
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"math"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gchaincl/sqlhooks"
 	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 
 	"github.com/rs/zerolog"
 
 	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,6 +48,8 @@ func TestInitSQLDriverWithLogs(t *testing.T) {
 		&sqlite3.SQLiteDriver{},
 		"sqlite3",
 		&logger,
+		false,
+		0,
 	)
 	assert.Equal(t, "sqlite3WithHooks", driverName)
 
@@ -48,8 +57,19 @@ func TestInitSQLDriverWithLogs(t *testing.T) {
 		&pq.Driver{},
 		"postgres",
 		&logger,
+		false,
+		0,
 	)
 	assert.Equal(t, "postgresWithHooks", driverName)
+
+	driverName = storage.InitSQLDriverWithLogs(
+		&sqlite3.SQLiteDriver{},
+		"sqlite3",
+		&logger,
+		true,
+		0,
+	)
+	assert.Equal(t, "sqlite3WithHooksAndArgs", driverName)
 }
 
 // TestInitSQLDriverWithLogsMultipleCalls tests if InitSQLDriverWithLogs
@@ -62,6 +82,8 @@ func TestInitSQLDriverWithLogsMultipleCalls(t *testing.T) {
 			&sqlite3.SQLiteDriver{},
 			"sqlite3",
 			&logger,
+			false,
+			0,
 		)
 		assert.Equal(t, "sqlite3WithHooks", driverName)
 	}
@@ -109,7 +131,7 @@ func TestSQLHooksLoggingArgsNotJSON(t *testing.T) {
 
 	buf := new(bytes.Buffer)
 	logger := zerolog.New(buf).With().Str("type", "SQL").Logger()
-	hooks := storage.SQLHooks{SQLQueriesLogger: &logger}
+	hooks := storage.SQLHooks{SQLQueriesLogger: &logger, LogArgs: true}
 
 	_, err := hooks.Before(context.Background(), query, params...)
 	if err != nil {
@@ -137,3 +159,116 @@ func TestSQLHooksLoggingArgsNotJSON(t *testing.T) {
 		fmt.Sprintf(storage.LogFormatterString, query, params)+" took",
 	)
 }
+
+// loggedMessages returns messages of all entries written by the logger into the buffer
+func loggedMessages(t *testing.T, buf *bytes.Buffer) []string {
+	var messages []string
+
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var entry struct {
+			Message string `json:"message"`
+		}
+		helpers.FailOnError(t, decoder.Decode(&entry))
+		messages = append(messages, entry.Message)
+	}
+
+	return messages
+}
+
+func TestSQLHooksLoggingArgsRedacted(t *testing.T) {
+	const query = "INSERT INTO report VALUES ($1, $2, $3, $4, $5)"
+	params := []interface{}{"secret report", []byte("secret"), int64(42), nil, time.Now()}
+	const redactedParams = `["string(len=13)","[]byte(len=6)","int64","nil","time.Time"]`
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).With().Str("type", "SQL").Logger()
+	hooks := storage.SQLHooks{SQLQueriesLogger: &logger}
+
+	ctx, err := hooks.Before(context.Background(), query, params...)
+	helpers.FailOnError(t, err)
+
+	_, err = hooks.After(ctx, query, params...)
+	helpers.FailOnError(t, err)
+
+	messages := loggedMessages(t, buf)
+	assert.Len(t, messages, 2)
+	for _, message := range messages {
+		assert.Contains(t, message, fmt.Sprintf(storage.LogFormatterString, query, redactedParams))
+		assert.NotContains(t, message, "secret")
+		assert.NotContains(t, message, "42")
+	}
+}
+
+func TestSQLHooksLoggingArgsWithValues(t *testing.T) {
+	const query = "SELECT 1 WHERE $1 = $2"
+	params := []interface{}{"value", int64(42)}
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).With().Str("type", "SQL").Logger()
+	hooks := storage.SQLHooks{SQLQueriesLogger: &logger, LogArgs: true}
+
+	_, err := hooks.Before(context.Background(), query, params...)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, []string{
+		fmt.Sprintf(storage.LogFormatterString, query, `["value",42]`) + "\n",
+	}, loggedMessages(t, buf))
+}
+
+func TestSQLHooksLoggingQueryTruncated(t *testing.T) {
+	query := "SELECT 1" + strings.Repeat(" ", 100)
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).With().Str("type", "SQL").Logger()
+	hooks := storage.SQLHooks{SQLQueriesLogger: &logger, MaxQueryLength: 8}
+
+	ctx, err := hooks.Before(context.Background(), query)
+	helpers.FailOnError(t, err)
+
+	_, err = hooks.After(ctx, query)
+	helpers.FailOnError(t, err)
+
+	messages := loggedMessages(t, buf)
+	assert.Len(t, messages, 2)
+	for _, message := range messages {
+		assert.Contains(t, message, fmt.Sprintf(storage.LogFormatterString, "SELECT 1... (100 bytes truncated)", "[]"))
+	}
+}
+
+// TestSQLHooksReportNeverLogged checks that the report written to the storage
+// doesn't appear in the log of SQL queries
+func TestSQLHooksReportNeverLogged(t *testing.T) {
+	const driverName = "sqlite3WithRedactedLogsTest"
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).With().Str("type", "SQL").Logger()
+	sql.Register(driverName, sqlhooks.Wrap(&sqlite3.SQLiteDriver{}, &storage.SQLHooks{
+		SQLQueriesLogger: &logger,
+		MaxQueryLength:   storage.DefaultLogSQLQueriesMaxLength,
+	}))
+
+	connection, err := sql.Open(driverName, ":memory:")
+	helpers.FailOnError(t, err)
+
+	mockStorage := storage.NewFromConnection(connection, storage.DBDriverSQLite3)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	helpers.FailOnError(t, mockStorage.Init())
+
+	err = mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.AddOrUpdateFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "secret feedback message",
+	)
+	helpers.FailOnError(t, err)
+
+	log := strings.Join(loggedMessages(t, buf), "")
+	assert.Contains(t, log, "INSERT INTO report(")
+	assert.Contains(t, log, fmt.Sprintf("string(len=%d)", len(testdata.Report3Rules)))
+	assert.NotContains(t, log, string(testdata.Report3Rules))
+	assert.NotContains(t, log, testdata.Rule1Details)
+	assert.NotContains(t, log, "secret feedback message")
+}
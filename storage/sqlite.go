@@ -0,0 +1,75 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSQLiteJournalMode is the journal mode of SQLite database used when it's not configured,
+	// WAL lets readers work concurrently with the writer
+	DefaultSQLiteJournalMode = "WAL"
+	// DefaultSQLiteBusyTimeout is the time SQLite waits for a lock held by another connection
+	// before it fails with "database is locked" error, used when it's not configured
+	DefaultSQLiteBusyTimeout = 5 * time.Second
+)
+
+// sqliteDataSource returns data source of SQLite database with pragmas applied by the driver
+// to each new connection of the pool: journal mode, busy timeout and enforcement of foreign keys
+func sqliteDataSource(configuration Configuration) string {
+	journalMode := configuration.SQLiteJournalMode
+	if len(journalMode) == 0 {
+		journalMode = DefaultSQLiteJournalMode
+	}
+
+	busyTimeout := configuration.SQLiteBusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = DefaultSQLiteBusyTimeout
+	}
+
+	foreignKeys := "1"
+	if configuration.SQLiteDisableForeignKeys {
+		foreignKeys = "0"
+	}
+
+	pragmas := url.Values{}
+	pragmas.Set("_journal_mode", journalMode)
+	pragmas.Set("_busy_timeout", fmt.Sprint(busyTimeout.Milliseconds()))
+	pragmas.Set("_foreign_keys", foreignKeys)
+
+	separator := "?"
+	if strings.Contains(configuration.SQLiteDataSource, "?") {
+		separator = "&"
+	}
+
+	return configuration.SQLiteDataSource + separator + pragmas.Encode()
+}
+
+// checkSQLitePragmas opens the first connection to SQLite database, so pragmas which can't be
+// applied (like unknown journal mode) are reported when the storage is created
+func checkSQLitePragmas(connection *sql.DB) error {
+	if err := connection.Ping(); err != nil {
+		return fmt.Errorf("unable to apply pragmas to SQLite database: %w", err)
+	}
+
+	return nil
+}
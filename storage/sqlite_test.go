@@ -0,0 +1,114 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// mustGetSQLiteFileStorage returns initialized storage using SQLite database in a temporary file,
+// the returned function closes the storage and removes the file
+func mustGetSQLiteFileStorage(t *testing.T, configuration storage.Configuration) (storage.Storage, func()) {
+	dir, err := ioutil.TempDir("", "aggregator_sqlite_")
+	helpers.FailOnError(t, err)
+
+	configuration.Driver = "sqlite3"
+	configuration.SQLiteDataSource = filepath.Join(dir, "aggregator.db")
+
+	sqliteStorage, err := storage.New(configuration)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	helpers.FailOnError(t, sqliteStorage.Init())
+
+	return sqliteStorage, func() {
+		helpers.MustCloseStorage(t, sqliteStorage)
+		helpers.FailOnError(t, os.RemoveAll(dir))
+	}
+}
+
+func TestSQLiteConcurrentWritesAndReads(t *testing.T) {
+	const iterations = 200
+
+	sqliteStorage, cleanup := mustGetSQLiteFileStorage(t, storage.Configuration{})
+	defer cleanup()
+
+	err := sqliteStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*iterations)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			errs <- sqliteStorage.WriteReportForCluster(
+				testdata.OrgID,
+				types.ClusterName(fmt.Sprintf("%08d-0000-4000-8000-000000000000", i)),
+				testdata.Report3Rules,
+				testdata.LastCheckedAt.Add(time.Duration(i)*time.Second),
+				types.KafkaOffset(i),
+			)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			_, err := sqliteStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+			errs <- err
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	count, err := sqliteStorage.ReportsCount()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, iterations+1, count)
+}
+
+// TestSQLiteUnknownJournalMode checks that pragmas which can't be applied fail creation of the storage
+func TestSQLiteUnknownJournalMode(t *testing.T) {
+	_, err := storage.New(storage.Configuration{
+		Driver:            "sqlite3",
+		SQLiteDataSource:  ":memory:",
+		SQLiteJournalMode: "UNKNOWN",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to apply pragmas to SQLite database")
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// statementCache keeps statements of hot write paths prepared for the whole life of the storage,
+// statements are keyed by the query text only, because the storage uses a single database driver.
+// It's shared by all copies of DBStorage, so it's safe for concurrent use.
+type statementCache struct {
+	mutex      sync.Mutex
+	statements map[string]*sql.Stmt
+}
+
+// newStatementCache creates an empty cache of prepared statements
+func newStatementCache() *statementCache {
+	return &statementCache{statements: make(map[string]*sql.Stmt)}
+}
+
+// prepare returns the statement of the query prepared on the connection, the statement
+// is prepared when it's requested for the first time. It has to be called before the transaction
+// using the statement begins, so a connection is free for preparing it.
+func (cache *statementCache) prepare(ctx context.Context, connection *sql.DB, query string) (*sql.Stmt, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if statement, found := cache.statements[query]; found {
+		return statement, nil
+	}
+
+	statement, err := connection.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.statements[query] = statement
+
+	return statement, nil
+}
+
+// close closes all prepared statements, the first error is returned
+func (cache *statementCache) close() error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	var firstErr error
+
+	for query, statement := range cache.statements {
+		if err := statement.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(cache.statements, query)
+	}
+
+	return firstErr
+}
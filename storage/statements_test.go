@@ -0,0 +1,118 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// TestDBStorageFeedbackStatementPreparedOnce checks that the upsert of the feedback
+// is prepared by the first write only and closed together with the storage
+func TestDBStorageFeedbackStatementPreparedOnce(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+
+	expects.ExpectPrepare("INSERT INTO cluster_rule_user_feedback").WillBeClosed()
+	for i := 0; i < 3; i++ {
+		expects.ExpectBegin()
+		expects.ExpectExec("INSERT INTO cluster_rule_user_feedback").WillReturnResult(driver.ResultNoRows)
+		expects.ExpectCommit()
+	}
+
+	for _, vote := range []storage.UserVote{storage.UserVoteLike, storage.UserVoteDislike, storage.UserVoteLike} {
+		helpers.FailOnError(t, mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, vote))
+	}
+
+	helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+}
+
+// TestDBStorageReportStatementPreparedAgainAfterClose checks that closed statements
+// are removed from the cache, so they are prepared again by the next write
+func TestDBStorageReportStatementPreparedAgainAfterClose(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	dbStorage := mockStorage.(*storage.DBStorage)
+
+	helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	))
+	helpers.FailOnError(t, storage.CloseStatements(dbStorage))
+	helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt.Add(time.Minute),
+		types.UnknownKafkaOffset,
+	))
+}
+
+// BenchmarkAddOrUpdateFeedbackOnRule compares writes of the feedback using the cached
+// prepared statement with writes preparing the statement each time,
+// run it with -benchtime=10000x to measure 10k writes
+func BenchmarkAddOrUpdateFeedbackOnRule(b *testing.B) {
+	for _, benchmark := range []struct {
+		name   string
+		cached bool
+	}{
+		{"cached", true},
+		{"uncached", false},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			mockStorage, err := helpers.GetMockStorage(true)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() {
+				if err := mockStorage.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}()
+			dbStorage := mockStorage.(*storage.DBStorage)
+
+			err = mockStorage.WriteReportForCluster(
+				testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+				types.UnknownKafkaOffset,
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if err := mockStorage.LoadRuleContent(testdata.RuleContent3Rules); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if !benchmark.cached {
+					if err := storage.CloseStatements(dbStorage); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				err := mockStorage.AddOrUpdateFeedbackOnRule(
+					testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message",
+				)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
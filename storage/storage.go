@@ -22,15 +22,23 @@ See the License for the specific language governing permissions and
 // It is possible to configure connection to selected database by using Configuration
 // structure. Currently that structure contains two configurable parameter:
 //
-// Driver - a SQL driver, like "sqlite3", "pq" etc.
+// Driver - a SQL driver, like "sqlite3", "pq" etc., "noop" for storage without database
+// or "memory" for storage keeping data in memory.
 // DataSource - specification of data source. The content of this parameter depends on the database used.
 package storage
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	sql_driver "database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -49,72 +57,280 @@ import (
 	"github.com/RedHatInsights/insights-results-aggregator/types"
 )
 
-// Storage represents an interface to almost any database or storage system
+// Storage represents an interface to almost any database or storage system,
+// it's the union of interfaces of all parts of the storage
 type Storage interface {
 	Init() error
 	Close() error
+	Ping() error
+	Capabilities() Capabilities
+	ReportReader
+	ReportWriter
+	ReportCleaner
+	FeedbackStorage
+	RuleToggleStorage
+	RuleContentStorage
+	ConsistencyStorage
+	AuditLogStorage
+}
+
+// ReportReader reads reports, rules hit by them and statistics of clusters and organizations
+type ReportReader interface {
 	ListOfOrgs() ([]types.OrgID, error)
 	ListOfClustersForOrg(orgID types.OrgID) ([]types.ClusterName, error)
-	ReadReportForCluster(orgID types.OrgID, clusterName types.ClusterName) (types.ClusterReport, types.Timestamp, error)
-	ReadReportForClusterByClusterName(clusterName types.ClusterName) (types.ClusterReport, types.Timestamp, error)
+	ListOfClustersForOrgWithTimestamps(orgID types.OrgID) ([]types.ClusterListItem, error)
+	ListClustersCheckedInRange(orgID types.OrgID, from, to time.Time) ([]types.ClusterNameWithTimestamp, error)
+	ListStaleClusters(olderThan time.Duration) ([]types.ClusterName, error)
+	ClustersCountPerOrg() (map[types.OrgID]int, error)
+	ReadReportForCluster(orgID types.OrgID, clusterName types.ClusterName) (types.StoredReport, error)
+	ReadReportForClusterByClusterName(clusterName types.ClusterName) (types.StoredReport, error)
+	ReadReportMetainfo(orgID types.OrgID, clusterName types.ClusterName) (*types.ReportMetainfo, error)
+	ReadReportHistoryForCluster(
+		orgID types.OrgID, clusterName types.ClusterName, limit int,
+	) ([]types.ReportHistoryEntry, error)
+	GetHitsCountHistory(clusterName types.ClusterName, days int) ([]types.DailyHitsCount, error)
+	GetProcessingErrorsForCluster(clusterName types.ClusterName, limit int) ([]types.ProcessingError, error)
+	ListConsumerErrors(limit int) ([]ConsumerError, error)
+	GetRuleHitsForCluster(orgID types.OrgID, clusterName types.ClusterName) ([]types.RuleOnReport, error)
+	ReportsCount() (int, error)
+	ReportsCountForOrg(orgID types.OrgID) (int, error)
+	GetOrgStatistics(orgID types.OrgID) (types.OrgStats, error)
+	GetClustersHittingRule(ruleID types.RuleID) ([]types.ClusterName, error)
+	GetExistingClusters(clusterNames []types.ClusterName) ([]types.ClusterName, error)
+	GetOrgIDByClusterID(cluster types.ClusterName) (types.OrgID, error)
+	DoesClusterExist(clusterName types.ClusterName) (bool, error)
+	ExportReportsForOrg(orgID types.OrgID, writer io.Writer) error
+}
+
+// ReportWriter writes reports and keeps track of Kafka offsets of the written reports
+// and of failures of processing of consumed messages
+type ReportWriter interface {
 	WriteReportForCluster(
 		orgID types.OrgID,
 		clusterName types.ClusterName,
 		report types.ClusterReport,
 		collectedAtTime time.Time,
+		kafkaOffset types.KafkaOffset,
 	) error
-	ReportsCount() (int, error)
+	WriteReportsForClusters(reports []types.ReportItem) (int, error)
+	GetLatestKafkaOffset() (types.KafkaOffset, error)
+	WriteConsumerError(consumerError ConsumerError) error
+	ImportReports(reader io.Reader) (ImportStats, error)
+}
+
+// ReportCleaner deletes reports of removed clusters and organizations, old reports
+// and old failures of processing of consumed messages. Reports can be soft-deleted
+// first, so they can be restored until they're purged.
+type ReportCleaner interface {
+	DeleteReportsForOrg(orgID types.OrgID) (DeletedRows, error)
+	DeleteReportsForCluster(clusterName types.ClusterName) (DeletedRows, error)
+	SoftDeleteReportsForOrg(orgID types.OrgID) (int, error)
+	SoftDeleteReportsForCluster(clusterName types.ClusterName) (int, error)
+	RestoreCluster(clusterName types.ClusterName) error
+	PurgeSoftDeleted(olderThan time.Duration) (int, error)
+	CleanupOrphanedFeedback() (int, error)
+	DeleteReportsForClusters(clusterNames []types.ClusterName) ([]types.ClusterName, error)
+	CleanupOldReports(olderThan time.Duration) (int, error)
+	CleanupConsumerErrors(olderThan time.Duration) (int, error)
+	ForEachReportCheckedBefore(cutoff time.Time, process func(types.ArchivedReport) error) error
+	CleanupClustersCheckedBefore(cutoff time.Time, clusterNames []types.ClusterName) (int, error)
+}
+
+// FeedbackStorage stores votes and messages left by users on rules hit in clusters
+type FeedbackStorage interface {
 	VoteOnRule(
 		clusterID types.ClusterName,
 		ruleID types.RuleID,
+		errorKey types.ErrorKey,
 		userID types.UserID,
 		userVote UserVote,
 	) error
 	AddOrUpdateFeedbackOnRule(
 		clusterID types.ClusterName,
 		ruleID types.RuleID,
+		errorKey types.ErrorKey,
 		userID types.UserID,
 		message string,
 	) error
 	GetUserFeedbackOnRule(
-		clusterID types.ClusterName, ruleID types.RuleID, userID types.UserID,
+		clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
 	) (*UserFeedbackOnRule, error)
+	ResetVoteOnRule(
+		clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+	) error
+	DeleteUserFeedbackOnRule(
+		clusterID types.ClusterName, ruleID types.RuleID, errorKey types.ErrorKey, userID types.UserID,
+	) error
+	ListFeedbacksForCluster(clusterID types.ClusterName) ([]UserFeedbackOnRule, error)
+	GetUserFeedbackOnRules(
+		clusterID types.ClusterName, ruleIDs []types.RuleID, userID types.UserID,
+	) (map[types.RuleID]UserVote, error)
+	GetVotesForRule(ruleID types.RuleID) (likes int, dislikes int, err error)
+	GetVotesForRuleByOrg(orgID types.OrgID, ruleID types.RuleID) (likes int, dislikes int, err error)
+	GetRuleFeedbackStatsForOrg(orgID types.OrgID) ([]RuleFeedbackStats, error)
+}
+
+// RuleToggleReader reads rules disabled for clusters, they're hidden in reports of the clusters,
+// and statistics of rules silenced for organizations
+type RuleToggleReader interface {
+	GetDisabledRulesForCluster(orgID types.OrgID, clusterName types.ClusterName) ([]types.RuleID, error)
+	GetSilencingStatsForOrg(orgID types.OrgID) (SilencingStats, error)
+	ListDisabledRulesForOrg(orgID types.OrgID) ([]DisabledRuleSummary, error)
+}
+
+// RuleToggleStorage stores rules acked and disabled by organizations and rules toggled for clusters
+type RuleToggleStorage interface {
+	RuleToggleReader
+	AckRuleForOrg(orgID types.OrgID, ruleID types.RuleID, userID types.UserID, justification string) error
+	ListAcksForOrg(orgID types.OrgID) ([]RuleAck, error)
+	IsRuleAckedForOrg(orgID types.OrgID, ruleID types.RuleID) (bool, error)
+	DeleteAckForOrg(orgID types.OrgID, ruleID types.RuleID) error
+	DisableRuleForOrg(orgID types.OrgID, ruleID types.RuleID, userID types.UserID) error
+	EnableRuleForOrg(orgID types.OrgID, ruleID types.RuleID, userID types.UserID) error
+	ListOrgDisabledRules(orgID types.OrgID) ([]types.RuleID, error)
+	ToggleRuleForCluster(
+		clusterName types.ClusterName, ruleID types.RuleID, userID types.UserID, toggle RuleToggle, justification string,
+	) error
+	AddFeedbackToRuleDisable(
+		clusterName types.ClusterName, ruleID types.RuleID, userID types.UserID, justification string,
+	) error
+	ListDisabledRulesForCluster(clusterName types.ClusterName) ([]ClusterRuleDisable, error)
+	DeleteRuleToggleForCluster(clusterName types.ClusterName, ruleID types.RuleID) error
+}
+
+// RuleContentStorage stores content of rules and looks rules up
+type RuleContentStorage interface {
 	GetContentForRules(rules types.ReportRules) ([]types.RuleContentResponse, error)
-	DeleteReportsForOrg(orgID types.OrgID) error
-	DeleteReportsForCluster(clusterName types.ClusterName) error
 	LoadRuleContent(contentDir content.RuleContentDirectory) error
+	GetContentChanges(fromChecksum, toChecksum string) (types.ContentChanges, error)
 	GetRuleByID(ruleID types.RuleID) (*types.Rule, error)
-	GetOrgIDByClusterID(cluster types.ClusterName) (types.OrgID, error)
+	ListRules(filter RuleFilter) ([]types.Rule, error)
+	DeleteRule(ruleID types.RuleID) error
+	DeleteRuleErrorKey(ruleID types.RuleID, errorKey types.ErrorKey) error
+}
+
+// ConsistencyStorage checks consistency of reports and lists the found issues
+type ConsistencyStorage interface {
+	ConsistencyChecker
+	ListConsistencyIssues() ([]ConsistencyIssue, error)
+}
+
+// AuditLogStorage lists the trail of destructive operations of the storage
+type AuditLogStorage interface {
+	ListAuditLog(since time.Time, limit int) ([]AuditLogEntry, error)
 }
 
 // DBDriver type for db driver enum
-type DBDriver int
+type DBDriver = types.DBDriver
 
 const (
 	// DBDriverSQLite3 shows that db driver is sqlite
-	DBDriverSQLite3 DBDriver = iota
+	DBDriverSQLite3 = types.DBDriverSQLite3
 	// DBDriverPostgres shows that db driver is postrgres
-	DBDriverPostgres
+	DBDriverPostgres = types.DBDriverPostgres
 	// DBDriverGeneral general sql(used for mock now)
-	DBDriverGeneral
+	DBDriverGeneral = types.DBDriverGeneral
 )
 
+// timeNow returns the current time, it can be replaced in tests to control the clock
+var timeNow = time.Now
+
+// DefaultMaxFeedbackMessageLength is the maximum number of characters in feedback message
+// used when it's not configured
+const DefaultMaxFeedbackMessageLength = 2048
+
+// DefaultLogSQLQueriesMaxLength is the number of bytes of SQL query logged
+// when logging of SQL queries is enabled, used when it's not configured
+const DefaultLogSQLQueriesMaxLength = 1024
+
+// DefaultContentHistoryDepth is the number of the most recent versions of rule content
+// kept in the content history used when it's not configured
+const DefaultContentHistoryDepth = 10
+
 // DBStorage is an implementation of Storage interface that use selected SQL like database
 // like SQLite, PostgreSQL, MariaDB, RDS etc. That implementation is based on the standard
 // sql package. It is possible to configure connection via Configuration structure.
 // SQLQueriesLog is log for sql queries, default is nil which means nothing is logged
+// Reports are compressed before writing when compressReports is true
+// and encrypted by reportEncryption when it's configured.
+// At most reportHistoryDepth reports are kept in the history for each cluster.
+// Feedback messages longer than maxFeedbackMessageLength characters are rejected.
+// Checksums of at most contentHistoryDepth recently loaded versions of rule content are kept.
+// Operations are interrupted when they don't finish in the timeout of their class.
+// Statements are chosen according to capabilities of the database.
+// Writes failed because of transient errors are retried at most maxRetries times
+// with exponential backoff starting at retryBackoff.
+// Statements of hot write paths are prepared once and kept in statements cache.
+// Operations taking longer than slowQueryThreshold are logged, zero disables the logging.
+// Data derived from written reports are maintained by writeHooks run in the transaction of the write.
+// Tables written together with reports are selected by writeMode, rule hits are read from readSource
+// and reads sampled with readComparisonSampleRate are compared with the other source.
+// Organizations of clusters are cached in orgIDs when the cache is configured.
+// The report table is partitioned into reportPartitions partitions, zero means it's not partitioned.
+// Destructive operations are recorded in the audit log together with the requester.
+// Operations are reported to tracer when it's set and their contexts are derived from parentCtx.
 type DBStorage struct {
-	connection   *sql.DB
-	dbDriverType DBDriver
+	connection               *sql.DB
+	replica                  *sql.DB
+	dbDriverType             DBDriver
+	compressReports          bool
+	reportEncryption         *reportEncryption
+	reportHistoryDepth       int
+	maxFeedbackMessageLength int
+	contentHistoryDepth      int
+	timeouts                 operationTimeouts
+	capabilities             Capabilities
+	maxRetries               int
+	retryBackoff             time.Duration
+	statements               *statementCache
+	slowQueryThreshold       time.Duration
+	writeHooks               []WriteHook
+	writeMode                string
+	readSource               string
+	readComparisonSampleRate float64
+	orgIDs                   *orgIDCache
+	reportsBatchSize         int
+	reportPartitions         int
+	requester                types.UserID
+	tracer                   Tracer
+	parentCtx                context.Context
+}
+
+// New function creates and initializes a new instance of Storage interface.
+// The "noop" driver selects NoopStorage which doesn't use any database
+// and the "memory" driver selects InMemoryStorage keeping all data in memory.
+func New(configuration Configuration) (Storage, error) {
+	switch configuration.Driver {
+	case "noop":
+		log.Info().Msg("Using noop storage, no data will be stored")
+		return NewNoopStorage(), nil
+	case "memory":
+		log.Info().Msg("Using in-memory storage, data will be lost when aggregator stops")
+		return newInMemoryFromConfiguration(configuration), nil
+	}
+
+	storage, err := newDBStorage(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
 }
 
-// New function creates and initializes a new instance of Storage interface
-func New(configuration Configuration) (*DBStorage, error) {
+// newDBStorage creates a new instance of DBStorage with connection
+// specified by the configuration
+func newDBStorage(configuration Configuration) (*DBStorage, error) {
 	driverType, driverName, dataSource, err := initAndGetDriver(configuration)
 	if err != nil {
 		return nil, err
 	}
 
+	encryption, err := newReportEncryption(configuration.ReportEncryption)
+	if err != nil {
+		log.Error().Err(err).Msg("Can not read report encryption keys")
+		return nil, err
+	}
+
 	log.Printf(
 		"Making connection to data storage, driver=%s datasource=%s",
 		driverName, dataSource,
@@ -126,15 +342,117 @@ func New(configuration Configuration) (*DBStorage, error) {
 		return nil, err
 	}
 
-	return NewFromConnection(connection, driverType), nil
+	configureConnectionPool(connection, configuration)
+
+	if driverType == DBDriverSQLite3 {
+		if err := checkSQLitePragmas(connection); err != nil {
+			log.Error().Err(err).Msg("Can not configure SQLite database")
+			_ = connection.Close()
+			return nil, err
+		}
+	}
+
+	storage := NewFromConnection(connection, driverType)
+	if len(configuration.WriteMode) != 0 {
+		storage.writeMode = configuration.WriteMode
+	}
+	if len(configuration.ReadSource) != 0 {
+		storage.readSource = configuration.ReadSource
+	}
+	if err := checkReadWriteModes(storage.writeMode, storage.readSource); err != nil {
+		_ = connection.Close()
+		return nil, err
+	}
+	storage.readComparisonSampleRate = configuration.ReadComparisonSampleRate
+	storage.orgIDs = newOrgIDCache(configuration.OrgIDCacheSize, configuration.OrgIDCacheTTL)
+	storage.compressReports = configuration.CompressReports
+	storage.reportEncryption = encryption
+	storage.reportHistoryDepth = configuration.ReportHistoryDepth
+	if configuration.MaxFeedbackMessageLength > 0 {
+		storage.maxFeedbackMessageLength = configuration.MaxFeedbackMessageLength
+	}
+	if configuration.ContentHistoryDepth > 0 {
+		storage.contentHistoryDepth = configuration.ContentHistoryDepth
+	}
+	if configuration.ReportsBatchSize > 0 {
+		storage.reportsBatchSize = configuration.ReportsBatchSize
+	}
+	storage.reportPartitions = reportPartitionsOf(configuration, driverType)
+	storage.maxRetries = configuration.MaxRetries
+	storage.slowQueryThreshold = configuration.SlowQueryThreshold
+	if configuration.RetryBackoff > 0 {
+		storage.retryBackoff = configuration.RetryBackoff
+	}
+	if configuration.QueryTimeout > 0 {
+		for class := range storage.timeouts {
+			storage.timeouts[class] = configuration.QueryTimeout
+		}
+	}
+	for class, timeout := range map[operationClass]time.Duration{
+		fastRead:         configuration.FastReadTimeout,
+		heavyAggregation: configuration.HeavyAggregationTimeout,
+		write:            configuration.WriteTimeout,
+		maintenance:      configuration.MaintenanceTimeout,
+	} {
+		if timeout > 0 {
+			storage.timeouts[class] = timeout
+		}
+	}
+	storage.replica = openReplica(driverName, configuration, storage.timeouts[fastRead])
+
+	return storage, nil
 }
 
 // NewFromConnection function creates and initializes a new instance of Storage interface from prepared connection
 func NewFromConnection(connection *sql.DB, dbDriverType DBDriver) *DBStorage {
-	return &DBStorage{
-		connection:   connection,
-		dbDriverType: dbDriverType,
+	storage := &DBStorage{
+		connection:               connection,
+		dbDriverType:             dbDriverType,
+		maxFeedbackMessageLength: DefaultMaxFeedbackMessageLength,
+		contentHistoryDepth:      DefaultContentHistoryDepth,
+		timeouts:                 defaultOperationTimeouts(),
+		capabilities:             capabilitiesOfDriver(dbDriverType),
+		retryBackoff:             DefaultRetryBackoff,
+		statements:               newStatementCache(),
+		writeMode:                WriteModeDualWrite,
+		readSource:               ReadSourceRuleHit,
+		reportsBatchSize:         DefaultReportsBatchSize,
+	}
+	storage.writeHooks = defaultWriteHooks(storage)
+
+	return storage
+}
+
+// DefaultMaxIdleConnections is the number of idle connections kept by database/sql package
+// when it's not configured
+const DefaultMaxIdleConnections = 2
+
+// configureConnectionPool applies configured limits of the connection pool,
+// zero values keep defaults of database/sql package (unlimited open connections and lifetime)
+func configureConnectionPool(connection *sql.DB, configuration Configuration) {
+	maxIdleConnections := DefaultMaxIdleConnections
+
+	if configuration.MaxOpenConnections > 0 {
+		connection.SetMaxOpenConns(configuration.MaxOpenConnections)
+	}
+	if configuration.MaxIdleConnections > 0 {
+		maxIdleConnections = configuration.MaxIdleConnections
+		connection.SetMaxIdleConns(maxIdleConnections)
+	}
+	if configuration.ConnectionMaxLifetime > 0 {
+		connection.SetConnMaxLifetime(configuration.ConnectionMaxLifetime)
+	}
+
+	// SetMaxOpenConns lowers the number of idle connections when it's above the new limit
+	if configuration.MaxOpenConnections > 0 && configuration.MaxOpenConnections < maxIdleConnections {
+		maxIdleConnections = configuration.MaxOpenConnections
 	}
+
+	log.Info().
+		Int("max_open_connections", connection.Stats().MaxOpenConnections).
+		Int("max_idle_connections", maxIdleConnections).
+		Str("connection_max_lifetime", configuration.ConnectionMaxLifetime.String()).
+		Msg("Connection pool of data storage has been configured, zero means unlimited")
 }
 
 // initAndGetDriver initializes driver(with logs if logSQLQueries is true),
@@ -147,7 +465,7 @@ func initAndGetDriver(configuration Configuration) (driverType DBDriver, driverN
 	case "sqlite3":
 		driverType = DBDriverSQLite3
 		driver = &sqlite3.SQLiteDriver{}
-		dataSource = configuration.SQLiteDataSource
+		dataSource = sqliteDataSource(configuration)
 	case "postgres":
 		driverType = DBDriverPostgres
 		driver = &pq.Driver{}
@@ -166,25 +484,62 @@ func initAndGetDriver(configuration Configuration) (driverType DBDriver, driverN
 	}
 
 	if configuration.LogSQLQueries {
+		maxQueryLength := configuration.LogSQLQueriesMaxLength
+		if maxQueryLength <= 0 {
+			maxQueryLength = DefaultLogSQLQueriesMaxLength
+		}
+
 		logger := zerolog.New(os.Stdout).With().Str("type", "SQL").Logger()
-		driverName = InitSQLDriverWithLogs(driver, driverName, &logger)
+		driverName = InitSQLDriverWithLogs(
+			driver, driverName, &logger, configuration.LogSQLQueriesWithArgs, maxQueryLength,
+		)
 	}
 
 	return
 }
 
-// Init method is doing initialization like creating tables in underlying database
+// Init method is doing initialization like creating tables in underlying database,
+// the report table is partitioned when its partitioning is enabled. Init can be called
+// repeatedly on the same database, already existing tables and their data are kept.
 func (storage DBStorage) Init() error {
+	existingTables, err := storage.listTables()
+	if err != nil {
+		return err
+	}
+
 	if err := migration.InitInfoTable(storage.connection); err != nil {
 		return err
 	}
 
-	return migration.SetDBVersion(storage.connection, migration.GetMaxVersion())
+	if err := migration.SetDBVersion(storage.connection, storage.dbDriverType, migration.GetMaxVersion()); err != nil {
+		return err
+	}
+
+	if err := storage.initReportPartitions(); err != nil {
+		return err
+	}
+
+	tables, err := storage.listTables()
+	if err != nil {
+		return err
+	}
+
+	logInitializedTables(existingTables, tables)
+
+	return nil
 }
 
 // Close method closes the connection to database. Needs to be called at the end of application lifecycle.
 func (storage DBStorage) Close() error {
 	log.Print("Closing connection to data storage")
+	if err := storage.statements.close(); err != nil {
+		log.Error().Err(err).Msg("Can not close prepared statements")
+	}
+	if storage.replica != nil {
+		if err := storage.replica.Close(); err != nil {
+			log.Error().Err(err).Msg("Can not close connection to read replica")
+		}
+	}
 	if storage.connection != nil {
 		err := storage.connection.Close()
 		if err != nil {
@@ -195,6 +550,14 @@ func (storage DBStorage) Close() error {
 	return nil
 }
 
+// Ping checks that the database is reachable, it's cheap enough to be called by readiness probes
+func (storage DBStorage) Ping() (err error) {
+	op := storage.startOperation("Ping", fastRead)
+	defer op.finish(&err)
+
+	return storage.connection.PingContext(op.ctx)
+}
+
 // Report represents one (latest) cluster report.
 //     Org: organization ID
 //     Name: cluster GUID in the following format:
@@ -203,7 +566,7 @@ type Report struct {
 	Org        types.OrgID         `json:"org"`
 	Name       types.ClusterName   `json:"cluster"`
 	Report     types.ClusterReport `json:"report"`
-	ReportedAt types.Timestamp     `json:"reported_at"`
+	ReportedAt *types.Timestamp    `json:"reported_at,omitempty"`
 }
 
 func closeRows(rows *sql.Rows) {
@@ -211,10 +574,13 @@ func closeRows(rows *sql.Rows) {
 }
 
 // ListOfOrgs reads list of all organizations that have at least one cluster report
-func (storage DBStorage) ListOfOrgs() ([]types.OrgID, error) {
+func (storage DBStorage) ListOfOrgs() (_ []types.OrgID, err error) {
+	op := storage.startOperation("ListOfOrgs", heavyAggregation)
+	defer op.finish(&err)
+
 	orgs := make([]types.OrgID, 0)
 
-	rows, err := storage.connection.Query("SELECT DISTINCT org_id FROM report ORDER BY org_id")
+	rows, err := storage.reads().QueryContext(op.ctx, "SELECT DISTINCT org_id FROM report WHERE deleted_at IS NULL ORDER BY org_id")
 	if err != nil {
 		return orgs, err
 	}
@@ -233,11 +599,45 @@ func (storage DBStorage) ListOfOrgs() ([]types.OrgID, error) {
 	return orgs, nil
 }
 
+// ClustersCountPerOrg reads number of clusters for each organization
+func (storage DBStorage) ClustersCountPerOrg() (_ map[types.OrgID]int, err error) {
+	op := storage.startOperation("ClustersCountPerOrg", heavyAggregation)
+	defer op.finish(&err)
+
+	counts := make(map[types.OrgID]int)
+
+	rows, err := storage.reads().QueryContext(op.ctx, "SELECT org_id, COUNT(*) FROM report WHERE deleted_at IS NULL GROUP BY org_id")
+	if err != nil {
+		return counts, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			orgID types.OrgID
+			count int
+		)
+
+		err = rows.Scan(&orgID, &count)
+		if err == nil {
+			counts[orgID] = count
+		} else {
+			log.Error().Err(err).Msg("ClustersCountPerOrg")
+		}
+	}
+	return counts, nil
+}
+
 // ListOfClustersForOrg reads list of all clusters fro given organization
-func (storage DBStorage) ListOfClustersForOrg(orgID types.OrgID) ([]types.ClusterName, error) {
+func (storage DBStorage) ListOfClustersForOrg(orgID types.OrgID) (_ []types.ClusterName, err error) {
+	op := storage.startOperation("ListOfClustersForOrg", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
 	clusters := make([]types.ClusterName, 0)
 
-	rows, err := storage.connection.Query("SELECT cluster FROM report WHERE org_id = $1 ORDER BY cluster", orgID)
+	rows, err := storage.reads().QueryContext(
+		op.ctx, "SELECT cluster FROM report WHERE org_id = $1 AND deleted_at IS NULL ORDER BY cluster", orgID,
+	)
 	if err != nil {
 		return clusters, err
 	}
@@ -256,63 +656,284 @@ func (storage DBStorage) ListOfClustersForOrg(orgID types.OrgID) ([]types.Cluste
 	return clusters, nil
 }
 
-// GetOrgIDByClusterID reads OrgID for specified cluster
-func (storage DBStorage) GetOrgIDByClusterID(cluster types.ClusterName) (types.OrgID, error) {
-	row := storage.connection.QueryRow("SELECT org_id FROM report WHERE cluster = $1 ORDER BY org_id", cluster)
+// ListOfClustersForOrgWithTimestamps reads list of all clusters for given organization together with
+// the times when their reports were stored and checked, the clusters checked most recently go first
+func (storage DBStorage) ListOfClustersForOrgWithTimestamps(orgID types.OrgID) (_ []types.ClusterListItem, err error) {
+	op := storage.startOperation("ListOfClustersForOrgWithTimestamps", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	clusters := make([]types.ClusterListItem, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT cluster, reported_at, last_checked_at FROM report
+		 WHERE org_id = $1 AND deleted_at IS NULL
+		 ORDER BY last_checked_at DESC, cluster`,
+		orgID,
+	)
+	if err != nil {
+		return clusters, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			clusterName string
+			reported    time.Time
+			lastChecked time.Time
+		)
+
+		if err := rows.Scan(&clusterName, scanTimestamp(&reported), scanTimestamp(&lastChecked)); err != nil {
+			return clusters, err
+		}
+
+		clusters = append(clusters, types.ClusterListItem{
+			Name:          types.ClusterName(clusterName),
+			ReportedAt:    types.NewOptionalTimestamp(reported),
+			LastCheckedAt: types.NewOptionalTimestamp(lastChecked),
+		})
+	}
+
+	return clusters, rows.Err()
+}
+
+// ListClustersCheckedInRange reads clusters of the organization whose reports were last checked
+// between from and to (both inclusive), zero to means until now. The clusters checked most long ago go first.
+func (storage DBStorage) ListClustersCheckedInRange(
+	orgID types.OrgID, from, to time.Time,
+) (_ []types.ClusterNameWithTimestamp, err error) {
+	op := storage.startOperation("ListClustersCheckedInRange", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	if to.IsZero() {
+		to = timeNow()
+	}
+
+	clusters := make([]types.ClusterNameWithTimestamp, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT cluster, last_checked_at FROM report
+		 WHERE org_id = $1 AND last_checked_at >= $2 AND last_checked_at <= $3 AND deleted_at IS NULL
+		 ORDER BY last_checked_at, cluster`,
+		orgID, from, to,
+	)
+	if err != nil {
+		return clusters, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			clusterName string
+			lastChecked time.Time
+		)
+
+		if err := rows.Scan(&clusterName, scanTimestamp(&lastChecked)); err != nil {
+			return clusters, err
+		}
+
+		clusters = append(clusters, types.ClusterNameWithTimestamp{
+			Name:          types.ClusterName(clusterName),
+			LastCheckedAt: types.NewOptionalTimestamp(lastChecked),
+		})
+	}
+
+	return clusters, rows.Err()
+}
+
+// ListStaleClusters reads clusters of all organizations whose reports were last checked
+// longer than olderThan ago, ordered by cluster name
+func (storage DBStorage) ListStaleClusters(olderThan time.Duration) (_ []types.ClusterName, err error) {
+	op := storage.startOperation("ListStaleClusters", heavyAggregation)
+	defer op.finish(&err)
+
+	clusters := make([]types.ClusterName, 0)
+
+	rows, err := storage.reads().QueryContext(
+		op.ctx,
+		"SELECT cluster FROM report WHERE last_checked_at < $1 AND deleted_at IS NULL ORDER BY cluster",
+		timeNow().Add(-olderThan),
+	)
+	if err != nil {
+		return clusters, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var clusterName string
+
+		if err := rows.Scan(&clusterName); err != nil {
+			return clusters, err
+		}
+
+		clusters = append(clusters, types.ClusterName(clusterName))
+	}
+
+	return clusters, rows.Err()
+}
+
+// GetOrgIDByClusterID reads OrgID for specified cluster, it's read from the cache when it's configured.
+// ItemNotFoundError wrapping sql.ErrNoRows is returned when there's no report of the cluster.
+func (storage DBStorage) GetOrgIDByClusterID(cluster types.ClusterName) (_ types.OrgID, err error) {
+	op := storage.startOperation("GetOrgIDByClusterID", fastRead).forCluster(cluster)
+	defer op.finish(&err)
+
+	if orgID, found := storage.orgIDs.get(cluster); found {
+		return orgID, nil
+	}
+
+	row := storage.reads().QueryRowContext(
+		op.ctx, "SELECT org_id FROM report WHERE cluster = $1 AND deleted_at IS NULL ORDER BY org_id", cluster,
+	)
 
 	var orgID uint64
-	err := row.Scan(&orgID)
+	err = row.Scan(&orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, newItemNotFoundError(ItemKindCluster, cluster).withCause(err)
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("GetOrgIDByClusterID")
 		return 0, err
 	}
+
+	storage.orgIDs.add(cluster, types.OrgID(orgID))
 	return types.OrgID(orgID), nil
 }
 
+// DoesClusterExist checks whether the report of the cluster is stored for any organization,
+// the cluster which sent an empty report exists too. Soft-deleted reports are not taken into account.
+// Clusters found in the cache of organizations exist without querying the database.
+func (storage DBStorage) DoesClusterExist(clusterName types.ClusterName) (_ bool, err error) {
+	op := storage.startOperation("DoesClusterExist", fastRead).forCluster(clusterName)
+	defer op.finish(&err)
+
+	if _, found := storage.orgIDs.get(clusterName); found {
+		return true, nil
+	}
+
+	var exists bool
+	err = storage.reads().QueryRowContext(
+		op.ctx, "SELECT EXISTS (SELECT 1 FROM report WHERE cluster = $1 AND deleted_at IS NULL)", clusterName,
+	).Scan(&exists)
+
+	return exists, err
+}
+
 // ReadReportForCluster reads result (health status) for selected cluster for given organization
 func (storage DBStorage) ReadReportForCluster(
 	orgID types.OrgID, clusterName types.ClusterName,
-) (types.ClusterReport, types.Timestamp, error) {
-	var report string
-	var lastChecked time.Time
+) (_ types.StoredReport, err error) {
+	op := storage.startOperation("ReadReportForCluster", fastRead).forOrg(orgID).forCluster(clusterName)
+	defer op.finish(&err)
+
+	row := storage.reads().QueryRowContext(
+		op.ctx,
+		`SELECT report, reported_at, last_checked_at FROM report
+		 WHERE org_id = $1 AND cluster = $2 AND deleted_at IS NULL`,
+		orgID, clusterName,
+	)
+
+	return storage.scanStoredReport(row, orgID, clusterName)
+}
+
+// ReadReportForClusterByClusterName reads result (health status) for selected cluster for given organization
+func (storage DBStorage) ReadReportForClusterByClusterName(
+	clusterName types.ClusterName,
+) (_ types.StoredReport, err error) {
+	op := storage.startOperation("ReadReportForClusterByClusterName", fastRead).forCluster(clusterName)
+	defer op.finish(&err)
+
+	row := storage.reads().QueryRowContext(
+		op.ctx,
+		"SELECT report, reported_at, last_checked_at FROM report WHERE cluster = $1 AND deleted_at IS NULL",
+		clusterName,
+	)
 
-	err := storage.connection.QueryRow(
-		"SELECT report, last_checked_at FROM report WHERE org_id = $1 AND cluster = $2", orgID, clusterName,
-	).Scan(&report, &lastChecked)
+	return storage.scanStoredReport(row, clusterName)
+}
 
+// ReadReportMetainfo reads times of the latest report of the cluster, Kafka offset it was consumed from
+// and the number of rules hit by it without reading the report itself. Rules hit are counted in rule_hit table,
+// the report has to be read only in legacy write mode, which doesn't write rule hits into the table.
+func (storage DBStorage) ReadReportMetainfo(
+	orgID types.OrgID, clusterName types.ClusterName,
+) (_ *types.ReportMetainfo, err error) {
+	op := storage.startOperation("ReadReportMetainfo", fastRead).forOrg(orgID).forCluster(clusterName)
+	defer op.finish(&err)
+
+	var reportedAt, lastCheckedAt time.Time
+	var kafkaOffset sql.NullInt64
+	var ruleHitsCount int
+
+	err = storage.reads().QueryRowContext(op.ctx, `
+		SELECT reported_at, last_checked_at, kafka_offset,
+			(SELECT COUNT(*) FROM rule_hit WHERE rule_hit.org_id = report.org_id AND rule_hit.cluster = report.cluster)
+		FROM report
+		WHERE org_id = $1 AND cluster = $2 AND deleted_at IS NULL`,
+		orgID, clusterName,
+	).Scan(scanTimestamp(&reportedAt), scanTimestamp(&lastCheckedAt), &kafkaOffset, &ruleHitsCount)
 	switch {
-	case err == sql.ErrNoRows:
-		return "", "", &ItemNotFoundError{
-			ItemID: fmt.Sprintf("%v/%v", orgID, clusterName),
-		}
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, newItemNotFoundError(ItemKindReport, orgID, clusterName).withCause(err)
 	case err != nil:
-		return "", "", err
+		return nil, err
 	}
 
-	return types.ClusterReport(report), types.Timestamp(lastChecked.Format(time.RFC3339)), nil
+	if storage.writeMode == WriteModeLegacy {
+		ruleHits, err := storage.readRuleHitsFromReport(op.ctx, orgID, clusterName)
+		if err != nil {
+			return nil, err
+		}
+
+		ruleHitsCount = len(ruleHits)
+	}
+
+	metainfo := &types.ReportMetainfo{
+		ReportedAt:    types.NewOptionalTimestamp(reportedAt),
+		LastCheckedAt: types.NewOptionalTimestamp(lastCheckedAt),
+		KafkaOffset:   types.UnknownKafkaOffset,
+		RuleHitsCount: ruleHitsCount,
+	}
+	if kafkaOffset.Valid {
+		metainfo.KafkaOffset = types.KafkaOffset(kafkaOffset.Int64)
+	}
+
+	return metainfo, nil
 }
 
-// ReadReportForClusterByClusterName reads result (health status) for selected cluster for given organization
-func (storage DBStorage) ReadReportForClusterByClusterName(
-	clusterName types.ClusterName,
-) (types.ClusterReport, types.Timestamp, error) {
+// scanStoredReport scans the report with its times from the row and decodes it,
+// ItemNotFoundError of the report identified by ids is returned when there is no such report
+func (storage DBStorage) scanStoredReport(row *sql.Row, ids ...interface{}) (types.StoredReport, error) {
 	var report string
-	var lastChecked time.Time
-
-	err := storage.connection.QueryRow(
-		"SELECT report, last_checked_at FROM report WHERE cluster = $1", clusterName,
-	).Scan(&report, &lastChecked)
+	var reportedAt, lastCheckedAt time.Time
 
+	err := row.Scan(&report, scanTimestamp(&reportedAt), scanTimestamp(&lastCheckedAt))
 	switch {
-	case err == sql.ErrNoRows:
-		return "", "", &ItemNotFoundError{
-			ItemID: fmt.Sprintf("%v", clusterName),
-		}
+	case errors.Is(err, sql.ErrNoRows):
+		return types.StoredReport{}, newItemNotFoundError(ItemKindReport, ids...).withCause(err)
 	case err != nil:
-		return "", "", err
+		return types.StoredReport{}, err
+	}
+
+	decodedReport, err := storage.decodeReport(types.ClusterReport(report))
+	if err != nil {
+		return types.StoredReport{}, err
 	}
 
-	return types.ClusterReport(report), types.Timestamp(lastChecked.Format(time.RFC3339)), nil
+	return newStoredReport(decodedReport, reportedAt, lastCheckedAt), nil
+}
+
+// newStoredReport returns the report with its times and the number of rules hit by it,
+// the report which can't be parsed (written by older versions) is counted as hitting no rule
+func newStoredReport(report types.ClusterReport, reportedAt, lastCheckedAt time.Time) types.StoredReport {
+	var reportRules types.ReportRules
+	_ = json.Unmarshal([]byte(report), &reportRules)
+
+	return types.StoredReport{
+		Report:        report,
+		LastCheckedAt: lastCheckedAt,
+		ReportedAt:    reportedAt,
+		Count:         len(reportRules.HitRules),
+	}
 }
 
 // constructWhereClause constructs a dynamic WHERE .. IN clause
@@ -321,7 +942,7 @@ func constructWhereClauseForContent(reportRules types.ReportRules) string {
 	if len(reportRules.HitRules) == 0 {
 		return "NULL" // WHERE NULL
 	}
-	statement := "(error_key, rule_module) IN (%v)"
+	statement := "(rule_error_key.error_key, rule_error_key.rule_module) IN (%v)"
 	var values string
 
 	for i, rule := range reportRules.HitRules {
@@ -340,18 +961,24 @@ func constructWhereClauseForContent(reportRules types.ReportRules) string {
 }
 
 // GetContentForRules retrieves content for rules that were hit in the report
-func (storage DBStorage) GetContentForRules(reportRules types.ReportRules) ([]types.RuleContentResponse, error) {
+func (storage DBStorage) GetContentForRules(reportRules types.ReportRules) (_ []types.RuleContentResponse, err error) {
+	op := storage.startOperation("GetContentForRules", fastRead)
+	defer op.finish(&err)
+
 	rules := make([]types.RuleContentResponse, 0)
 
-	query := `SELECT error_key, rule_module, description, generic, publish_date,
-		impact, likelihood
+	// error keys are joined with their rules, so the content of an error key is returned only
+	// together with its rule from the same load of rule content
+	query := `SELECT rule_error_key.error_key, rule_error_key.rule_module, rule_error_key.description,
+		rule_error_key.generic, rule_error_key.publish_date, rule_error_key.impact, rule_error_key.likelihood
 		FROM rule_error_key
+		JOIN rule ON rule."module" = rule_error_key.rule_module
 		WHERE %v`
 
 	whereInStatement := constructWhereClauseForContent(reportRules)
 	query = fmt.Sprintf(query, whereInStatement)
 
-	rows, err := storage.connection.Query(query)
+	rows, err := storage.reads().QueryContext(op.ctx, query)
 
 	if err != nil {
 		return rules, err
@@ -389,88 +1016,1022 @@ func (storage DBStorage) GetContentForRules(reportRules types.ReportRules) ([]ty
 	return rules, nil
 }
 
-// WriteReportForCluster writes result (health status) for selected cluster for given organization
+// reportUpsert writes the report of the cluster, the stored report is replaced
+// only by a report which is not older than the stored one. The newer report
+// restores the soft-deleted report of the cluster.
+var reportUpsert = upsertStatement{
+	table: "report",
+	columns: []string{
+		"org_id", "cluster", "report", "reported_at", "last_checked_at", "kafka_offset", "report_checksum",
+	},
+	conflictColumns: []string{"org_id", "cluster"},
+	updates: []string{
+		"report = excluded.report",
+		"reported_at = excluded.reported_at",
+		"last_checked_at = excluded.last_checked_at",
+		"kafka_offset = excluded.kafka_offset",
+		"report_checksum = excluded.report_checksum",
+		"deleted_at = NULL",
+	},
+	where: "report.last_checked_at IS NULL OR report.last_checked_at <= excluded.last_checked_at",
+}
+
+// newerOffsetCondition doesn't allow to replace the stored report by the report consumed
+// from the same or older Kafka offset, unknown offsets are not compared
+const newerOffsetCondition = "report.kafka_offset IS NULL OR excluded.kafka_offset IS NULL" +
+	" OR report.kafka_offset < excluded.kafka_offset"
+
+// newerReportUpsert writes the report of the cluster like reportUpsert, but the stored report
+// consumed from the same or newer Kafka offset is not replaced either, so the offset doesn't
+// have to be checked before the report is written
+var newerReportUpsert = upsertStatement{
+	table:           reportUpsert.table,
+	columns:         reportUpsert.columns,
+	conflictColumns: reportUpsert.conflictColumns,
+	updates:         reportUpsert.updates,
+	where:           "(" + reportUpsert.where + ") AND (" + newerOffsetCondition + ")",
+}
+
+// WriteReportForCluster writes result (health status) for selected cluster for given organization.
+// The offset of Kafka message the report was consumed from is stored with the report and ErrOldReport
+// is returned without writing anything when the stored report was consumed from the same or newer offset.
+// The offsets are comparable because the consumer reads only a single partition.
+// When the report is identical to the stored one (their checksums are equal), only the time
+// of the last check is updated instead of rewriting the report and its rule hits.
 func (storage DBStorage) WriteReportForCluster(
 	orgID types.OrgID,
 	clusterName types.ClusterName,
 	report types.ClusterReport,
 	lastCheckedTime time.Time,
-) error {
-	var upsertQuery string
-
-	switch storage.dbDriverType {
-	case DBDriverSQLite3:
-		upsertQuery = `INSERT OR REPLACE INTO report(org_id, cluster, report, reported_at, last_checked_at)
-		 VALUES ($1, $2, $3, $4, $5)`
-	case DBDriverPostgres:
-		upsertQuery = `INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at)
-		 VALUES ($1, $2, $3, $4, $5)
-		 ON CONFLICT (org_id, cluster)
-		 DO UPDATE SET report = $3, reported_at = $4, last_checked_at = $5`
-	default:
-		return fmt.Errorf("writing report with DB %v is not supported", storage.dbDriverType)
+	kafkaOffset types.KafkaOffset,
+) (err error) {
+	op := storage.startOperation("WriteReportForCluster", write).forOrg(orgID).forCluster(clusterName)
+	defer op.finish(&err)
+
+	var reportRules types.ReportRules
+
+	// the report is converted only once, it's used both for parsing and for the checksum
+	reportBytes := []byte(report)
+
+	// the report is parsed here to fail early if it's malformed
+	if err := json.Unmarshal(reportBytes, &reportRules); err != nil {
+		return &InvalidReportError{OrgID: orgID, ClusterName: clusterName}
 	}
 
-	tx, err := storage.connection.Begin()
+	// the checksum is computed from the plain report, so it doesn't depend on the compression
+	// and encryption, which uses random nonce
+	checksum := reportBytesChecksum(reportBytes)
+
+	report, err = storage.encodeReport(report)
 	if err != nil {
 		return err
 	}
 
-	// Check if there is a more recent report for the cluster already in the database.
-	rows, err := tx.Query(
-		`SELECT last_checked_at FROM report WHERE org_id = $1 AND cluster = $2 AND last_checked_at > $3`,
-		orgID, clusterName, lastCheckedTime)
+	// The stored report is updated only when it's not more recent than the written one,
+	// so the newer report wins even when the reports are written concurrently.
+	upsertQuery, ok := storage.dialect().upsert(newerReportUpsert)
+	if !ok {
+		return fmt.Errorf("writing report with DB %v is not supported", storage.dbDriverType)
+	}
+
+	return storage.withRetries(op.ctx, "WriteReportForCluster", func() error {
+		return storage.writeReport(
+			op.ctx, upsertQuery, orgID, clusterName, report, checksum, reportRules, lastCheckedTime, kafkaOffset,
+		)
+	})
+}
+
+// writeReport writes the report and runs write hooks deriving data from it in a single transaction.
+// Both the update of the duplicate report and the upsert skip the stored report consumed from the same
+// or newer Kafka offset, so the offset is checked by a separate query only when nothing has been written.
+func (storage DBStorage) writeReport(
+	ctx context.Context,
+	upsertQuery string,
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	checksum string,
+	reportRules types.ReportRules,
+	lastCheckedTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) error {
+	// statements are prepared before the transaction begins, they are reused by next writes
+	duplicateStatement, err := storage.statements.prepare(ctx, storage.connection, newerDuplicateReportUpdate)
 	if err != nil {
-		log.Error().Err(err).Msg("Unable to find most recent report in database")
-		_ = tx.Rollback()
 		return err
 	}
-	defer closeRows(rows)
 
-	// If there is one, print a warning and discard the report (don't update it).
-	if rows.Next() {
-		log.Warn().Msgf("Database already contains report for organization %d and cluster name %s more recent than %v",
-			orgID, clusterName, lastCheckedTime)
+	upsertStatement, err := storage.statements.prepare(ctx, storage.connection, upsertQuery)
+	if err != nil {
+		return err
+	}
 
-		_ = tx.Rollback()
-		return nil
+	tx, err := storage.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
 
-	// Perform the report upsert.
-	reportedAtTime := time.Now()
-	_, err = tx.Exec(upsertQuery, orgID, clusterName, report, reportedAtTime, lastCheckedTime)
+	// Identical report is not rewritten, only the time of its last check
+	// (and the offset of the message) is updated.
+	duplicate, err := execUpdatingRows(
+		tx.StmtContext(ctx, duplicateStatement).ExecContext(
+			ctx, orgID, clusterName, lastCheckedTime, kafkaOffsetValue(kafkaOffset), checksum,
+		),
+	)
 	if err != nil {
-		log.Print(err)
+		log.Error().Err(err).Msg("Unable to update duplicate report in database")
 		_ = tx.Rollback()
 		return err
 	}
 
-	metrics.WrittenReports.Inc()
-	return tx.Commit()
-}
-
-// ReportsCount reads number of all records stored in database
-func (storage DBStorage) ReportsCount() (int, error) {
-	count := -1
-	err := storage.connection.QueryRow("SELECT count(*) FROM report").Scan(&count)
+	written := duplicate
+	if !duplicate {
+		// Perform the report upsert, it doesn't change anything when the stored report is more recent.
+		reportedAtTime := time.Now()
+		written, err = execUpdatingRows(
+			tx.StmtContext(ctx, upsertStatement).ExecContext(
+				ctx, orgID, clusterName, report, reportedAtTime, lastCheckedTime, kafkaOffsetValue(kafkaOffset), checksum,
+			),
+		)
+		if err != nil {
+			log.Print(err)
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	// Skip the report if its Kafka message has been already processed, for example after restart of the consumer.
+	if !written && kafkaOffset >= 0 {
+		oldReport, err := isOldReport(ctx, tx, orgID, clusterName, kafkaOffset)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to find Kafka offset of the report in database")
+			_ = tx.Rollback()
+			return err
+		}
+
+		if oldReport {
+			_ = tx.Rollback()
+			return ErrOldReport
+		}
+	}
+
+	outdated := !written
+	if outdated {
+		// If there is a more recent report, print a warning, the report is discarded (not updated),
+		// write hooks still get the report, so it's stored in the history.
+		log.Warn().Msgf("Database already contains report for organization %d and cluster name %s more recent than %v",
+			orgID, clusterName, lastCheckedTime)
+	}
+
+	err = storage.runWriteHooks(ctx, tx, ReportWrite{
+		OrgID:           orgID,
+		ClusterName:     clusterName,
+		Report:          report,
+		Rules:           reportRules,
+		LastCheckedTime: lastCheckedTime,
+		KafkaOffset:     kafkaOffset,
+		Outdated:        outdated,
+		Duplicate:       duplicate,
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// the cluster could be unknown for the organization, so the cached organization may not be the lowest one
+	storage.orgIDs.forgetUnlessOrg(clusterName, orgID)
+
+	if duplicate {
+		metrics.DuplicateReportsSkipped.Inc()
+	}
+
+	return nil
+}
+
+// execUpdatingRows returns whether the executed statement has changed any row
+func execUpdatingRows(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// isOldReport checks whether the report stored for the cluster was consumed from the same or newer Kafka offset
+func isOldReport(
+	ctx context.Context, tx *sql.Tx, orgID types.OrgID, clusterName types.ClusterName, kafkaOffset types.KafkaOffset,
+) (bool, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT kafka_offset FROM report WHERE org_id = $1 AND cluster = $2 AND kafka_offset >= $3`,
+		orgID, clusterName, kafkaOffset)
+	if err != nil {
+		return false, err
+	}
+	defer closeRows(rows)
+
+	return rows.Next(), rows.Err()
+}
+
+// reportChecksum returns hex encoded SHA-256 checksum of the report
+func reportChecksum(report types.ClusterReport) string {
+	return reportBytesChecksum([]byte(report))
+}
+
+// reportBytesChecksum returns hex encoded SHA-256 checksum of the report already converted to bytes
+func reportBytesChecksum(report []byte) string {
+	sum := sha256.Sum256(report)
+	return hex.EncodeToString(sum[:])
+}
+
+// duplicateReportUpdate updates the time of the last check and the offset of the report stored
+// for the cluster when it has the same checksum and it's not more recent than the written report,
+// the soft-deleted report is restored by the update
+const duplicateReportUpdate = `UPDATE report SET last_checked_at = $3, kafka_offset = $4, deleted_at = NULL
+		 WHERE org_id = $1 AND cluster = $2 AND report_checksum = $5
+		 AND (last_checked_at IS NULL OR last_checked_at <= $3)`
+
+// newerDuplicateReportUpdate is duplicateReportUpdate which doesn't update
+// the stored report consumed from the same or newer Kafka offset either
+const newerDuplicateReportUpdate = duplicateReportUpdate + `
+		 AND (kafka_offset IS NULL OR $4 IS NULL OR kafka_offset < $4)`
+
+// updateDuplicateReport updates the stored report by duplicateReportUpdate.
+// It returns whether the stored report has been updated
+func updateDuplicateReport(
+	ctx context.Context,
+	tx *sql.Tx,
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	checksum string,
+	lastCheckedTime time.Time,
+	kafkaOffset types.KafkaOffset,
+) (bool, error) {
+	return execUpdatingRows(tx.ExecContext(
+		ctx, duplicateReportUpdate, orgID, clusterName, lastCheckedTime, kafkaOffsetValue(kafkaOffset), checksum,
+	))
+}
+
+// kafkaOffsetValue returns value of the offset stored in the database, unknown offset is stored as NULL
+func kafkaOffsetValue(kafkaOffset types.KafkaOffset) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(kafkaOffset), Valid: kafkaOffset >= 0}
+}
+
+// GetRuleHitsForCluster returns rules hit by the latest report of the cluster,
+// they are read from the configured read source and sampled reads are compared
+// with the other source. ItemNotFoundError is returned if there is no report for the cluster.
+func (storage DBStorage) GetRuleHitsForCluster(
+	orgID types.OrgID, clusterName types.ClusterName,
+) (_ []types.RuleOnReport, err error) {
+	op := storage.startOperation("GetRuleHitsForCluster", fastRead).forOrg(orgID).forCluster(clusterName)
+	defer op.finish(&err)
+
+	ruleHits, err := storage.readRuleHits(op.ctx, storage.readSource, orgID, clusterName)
+	if err != nil {
+		return ruleHits, err
+	}
+
+	if storage.sampleReadComparison() {
+		storage.compareRuleHits(op.ctx, orgID, clusterName, ruleHits)
+	}
+
+	return ruleHits, nil
+}
+
+// readRuleHitsFromTable reads rule hits of the cluster from rule_hit table
+func (storage DBStorage) readRuleHitsFromTable(
+	ctx context.Context, orgID types.OrgID, clusterName types.ClusterName,
+) ([]types.RuleOnReport, error) {
+	ruleHits := make([]types.RuleOnReport, 0)
+
+	var reportExists int
+	err := storage.reads().QueryRowContext(
+		ctx,
+		"SELECT 1 FROM report WHERE org_id = $1 AND cluster = $2 AND deleted_at IS NULL", orgID, clusterName,
+	).Scan(&reportExists)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ruleHits, newItemNotFoundError(ItemKindReport, orgID, clusterName).withCause(err)
+	case err != nil:
+		return ruleHits, err
+	}
+
+	rows, err := storage.reads().QueryContext(ctx, `
+		SELECT rule_fqdn, error_key, template_data FROM rule_hit
+		 WHERE org_id = $1 AND cluster = $2
+		 ORDER BY rule_fqdn, error_key`, orgID, clusterName)
+	if err != nil {
+		return ruleHits, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			ruleHit      types.RuleOnReport
+			templateData string
+		)
+
+		err = rows.Scan(&ruleHit.Module, &ruleHit.ErrorKey, &templateData)
+		if err != nil {
+			return ruleHits, err
+		}
+
+		err = json.Unmarshal([]byte(templateData), &ruleHit.TemplateData)
+		if err != nil {
+			return ruleHits, err
+		}
+
+		ruleHits = append(ruleHits, ruleHit)
+	}
+
+	return ruleHits, rows.Err()
+}
+
+// ReadReportHistoryForCluster reads at most limit most recent reports kept in the history
+// for the cluster, the newest report goes first
+func (storage DBStorage) ReadReportHistoryForCluster(
+	orgID types.OrgID, clusterName types.ClusterName, limit int,
+) (_ []types.ReportHistoryEntry, err error) {
+	op := storage.startOperation("ReadReportHistoryForCluster", fastRead).forOrg(orgID).forCluster(clusterName)
+	defer op.finish(&err)
+
+	history := make([]types.ReportHistoryEntry, 0)
+
+	rows, err := storage.reads().QueryContext(op.ctx, `
+		SELECT report, last_checked_at FROM report_history
+		 WHERE org_id = $1 AND cluster = $2
+		   AND cluster NOT IN (SELECT cluster FROM report WHERE deleted_at IS NOT NULL)
+		 ORDER BY last_checked_at DESC
+		 LIMIT $3`, orgID, clusterName, limit)
+	if err != nil {
+		return history, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			report      types.ClusterReport
+			lastChecked time.Time
+		)
+
+		err = rows.Scan(&report, scanTimestamp(&lastChecked))
+		if err != nil {
+			return history, err
+		}
+
+		report, err = storage.decodeReport(report)
+		if err != nil {
+			return history, err
+		}
+
+		history = append(history, types.ReportHistoryEntry{
+			Report:        report,
+			LastCheckedAt: types.NewOptionalTimestamp(lastChecked),
+		})
+	}
+
+	return history, rows.Err()
+}
+
+// ReportsCount reads number of all records stored in database
+func (storage DBStorage) ReportsCount() (_ int, err error) {
+	op := storage.startOperation("ReportsCount", heavyAggregation)
+	defer op.finish(&err)
+
+	count := -1
+	err = storage.reads().QueryRowContext(op.ctx, "SELECT count(*) FROM report WHERE deleted_at IS NULL").Scan(&count)
+
+	return count, err
+}
+
+// GetLatestKafkaOffset returns the highest Kafka offset stored with reports, 0 is returned
+// when there is no report consumed from Kafka (empty table or only reports with NULL offset).
+// The consumer continues from this offset, so it's read from the primary database, the read replica
+// may lag behind and the consumer would process already stored messages again.
+func (storage DBStorage) GetLatestKafkaOffset() (_ types.KafkaOffset, err error) {
+	op := storage.startOperation("GetLatestKafkaOffset", heavyAggregation)
+	defer op.finish(&err)
+
+	var offset types.KafkaOffset
+	err = storage.connection.QueryRowContext(op.ctx, "SELECT COALESCE(MAX(kafka_offset), 0) FROM report").Scan(&offset)
+
+	return offset, err
+}
+
+// ReportsCountForOrg reads number of reports stored for the organization
+func (storage DBStorage) ReportsCountForOrg(orgID types.OrgID) (_ int, err error) {
+	op := storage.startOperation("ReportsCountForOrg", fastRead).forOrg(orgID)
+	defer op.finish(&err)
+
+	return storage.reportsCountForOrg(op.ctx, orgID)
+}
+
+// reportsCountForOrg implements ReportsCountForOrg using the context of the calling operation
+func (storage DBStorage) reportsCountForOrg(ctx context.Context, orgID types.OrgID) (int, error) {
+	count := -1
+	err := storage.reads().QueryRowContext(
+		ctx, "SELECT count(*) FROM report WHERE org_id = $1 AND deleted_at IS NULL", orgID,
+	).Scan(&count)
 
 	return count, err
 }
 
-// DeleteReportsForOrg deletes all reports related to the specified organization from the storage.
-func (storage DBStorage) DeleteReportsForOrg(orgID types.OrgID) error {
-	_, err := storage.connection.Exec("DELETE FROM report WHERE org_id = $1", orgID)
-	return err
+// GetOrgStatistics returns number of clusters and the oldest and the newest time
+// of the last check of reports stored for the organization
+func (storage DBStorage) GetOrgStatistics(orgID types.OrgID) (_ types.OrgStats, err error) {
+	op := storage.startOperation("GetOrgStatistics", heavyAggregation).forOrg(orgID)
+	defer op.finish(&err)
+
+	var stats types.OrgStats
+
+	clusterCount, err := storage.reportsCountForOrg(op.ctx, orgID)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.ClusterCount = clusterCount
+	if clusterCount == 0 {
+		return stats, nil
+	}
+
+	var oldest, newest time.Time
+
+	err = storage.reads().QueryRowContext(
+		op.ctx,
+		"SELECT MIN(last_checked_at), MAX(last_checked_at) FROM report WHERE org_id = $1 AND deleted_at IS NULL",
+		orgID,
+	).Scan(scanTimestamp(&oldest), scanTimestamp(&newest))
+	if err != nil {
+		return stats, err
+	}
+
+	stats.OldestLastCheckedAt = types.NewTimestamp(oldest)
+	stats.NewestLastCheckedAt = types.NewTimestamp(newest)
+
+	return stats, nil
+}
+
+// clustersHittingRuleQuery reads all reports, they're searched for the rule when they're read
+const clustersHittingRuleQuery = "SELECT cluster, report FROM report WHERE deleted_at IS NULL ORDER BY cluster"
+
+// clustersHittingRuleJSONBQuery searches reports by JSONB containment on PostgreSQL. Compressed and encrypted
+// reports are stored as JSON strings whose content is opaque to the database, so they are read to be searched
+// like by clustersHittingRuleQuery, report is NULL for other reports, they contain the hit of the rule.
+const clustersHittingRuleJSONBQuery = `
+	SELECT cluster, CASE WHEN jsonb_typeof(report) = 'string' THEN report::text END FROM report
+	WHERE (report @> $1::jsonb OR jsonb_typeof(report) = 'string') AND deleted_at IS NULL
+	ORDER BY cluster`
+
+// GetClustersHittingRule returns list of all clusters whose latest report contains hit of the specified rule
+func (storage DBStorage) GetClustersHittingRule(ruleID types.RuleID) (_ []types.ClusterName, err error) {
+	op := storage.startOperation("GetClustersHittingRule", heavyAggregation)
+	defer op.finish(&err)
+
+	clusters := make([]types.ClusterName, 0)
+	ruleModule := string(ruleID) + ".report"
+
+	query, args := clustersHittingRuleQuery, []interface{}(nil)
+	if storage.capabilities.JSONOperators {
+		var containedReport []byte
+
+		containedReport, err = json.Marshal(map[string][]map[string]string{
+			"reports": {{"component": ruleModule}},
+		})
+		if err != nil {
+			return clusters, err
+		}
+
+		query, args = clustersHittingRuleJSONBQuery, []interface{}{string(containedReport)}
+	}
+
+	rows, err := storage.reads().QueryContext(op.ctx, query, args...)
+	if err != nil {
+		return clusters, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var (
+			clusterName types.ClusterName
+			report      sql.NullString
+		)
+
+		err = rows.Scan(&clusterName, &report)
+		if err != nil {
+			log.Error().Err(err).Msg("GetClustersHittingRule")
+			continue
+		}
+
+		if !report.Valid || storage.reportHitsRule(clusterName, types.ClusterReport(report.String), ruleModule) {
+			clusters = append(clusters, clusterName)
+		}
+	}
+
+	return clusters, rows.Err()
+}
+
+// reportHitsRule checks whether the stored report, which is decoded first, contains hit of the rule module,
+// reports which can't be decoded or parsed are logged and they don't hit any rule
+func (storage DBStorage) reportHitsRule(
+	clusterName types.ClusterName, report types.ClusterReport, ruleModule string,
+) bool {
+	var reportRules types.ReportRules
+
+	report, err := storage.decodeReport(report)
+	if err == nil {
+		err = json.Unmarshal([]byte(report), &reportRules)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Unable to parse report for cluster %v", clusterName)
+		return false
+	}
+
+	for _, hitRule := range reportRules.HitRules {
+		if hitRule.Module == ruleModule {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeletedRows contains numbers of rows deleted from each table together with reports
+type DeletedRows struct {
+	Reports          int `json:"report"`
+	ReportHistory    int `json:"report_history"`
+	RuleHits         int `json:"rule_hit"`
+	ConsumerErrors   int `json:"consumer_error"`
+	Feedback         int `json:"cluster_rule_user_feedback"`
+	FeedbackMessages int `json:"cluster_rule_user_message"`
+	RuleAcks         int `json:"rule_ack"`
+	DisabledRules    int `json:"rule_disable_org"`
+	Toggles          int `json:"cluster_rule_toggle"`
+}
+
+// countedDeletion is a delete statement together with the counter of rows deleted by it
+type countedDeletion struct {
+	query   string
+	deleted *int
+}
+
+// deleteCounted runs all the deletions with the same arguments in a single transaction
+// and stores the number of rows deleted by each of them into its counter
+func (storage DBStorage) deleteCounted(ctx context.Context, deletions []countedDeletion, args ...interface{}) error {
+	tx, err := storage.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := execCountedInTx(ctx, tx, deletions, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execCountedInTx runs all the deletions with the same arguments in the transaction
+// and stores the number of rows deleted by each of them into its counter
+func execCountedInTx(ctx context.Context, tx *sql.Tx, deletions []countedDeletion, args ...interface{}) error {
+	for _, deletion := range deletions {
+		result, err := tx.ExecContext(ctx, deletion.query, args...)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		*deletion.deleted = int(deleted)
+	}
+
+	return nil
+}
+
+// DeleteReportsForOrg deletes all reports related to the specified organization from the storage
+// together with users' feedback on clusters of the organization, rules toggled for them and rules
+// acked or disabled by it. Clusters of the organization are resolved from its reports, so the feedback
+// and toggles are deleted first.
+func (storage DBStorage) DeleteReportsForOrg(orgID types.OrgID) (_ DeletedRows, err error) {
+	op := storage.startOperation("DeleteReportsForOrg", maintenance).forOrg(orgID)
+	defer op.finish(&err)
+
+	const clustersOfOrg = "(SELECT cluster FROM report WHERE org_id = $1)"
+
+	var deleted DeletedRows
+
+	err = storage.deleteCounted(op.ctx, []countedDeletion{
+		{"DELETE FROM cluster_rule_user_message WHERE cluster_id IN " + clustersOfOrg, &deleted.FeedbackMessages},
+		{"DELETE FROM cluster_rule_user_feedback WHERE cluster_id IN " + clustersOfOrg, &deleted.Feedback},
+		{"DELETE FROM cluster_rule_toggle WHERE cluster_id IN " + clustersOfOrg, &deleted.Toggles},
+		{"DELETE FROM rule_ack WHERE org_id = $1", &deleted.RuleAcks},
+		{"DELETE FROM rule_disable_org WHERE org_id = $1", &deleted.DisabledRules},
+		{"DELETE FROM rule_hit WHERE org_id = $1", &deleted.RuleHits},
+		{"DELETE FROM report_history WHERE org_id = $1", &deleted.ReportHistory},
+		{"DELETE FROM consumer_error WHERE org_id = $1", &deleted.ConsumerErrors},
+		{"DELETE FROM report WHERE org_id = $1", &deleted.Reports},
+	}, orgID)
+	if err != nil {
+		return DeletedRows{}, err
+	}
+
+	// clusters of the organization aren't known anymore
+	storage.orgIDs.purge()
+
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "DeleteReportsForOrg", OrgID: orgID, DeletedRows: deleted.total(),
+	})
+
+	return deleted, nil
+}
+
+// DeleteReportsForCluster deletes all reports related to the specified cluster from the storage
+// together with users' feedback on the cluster and rules toggled for it. Rules acked or disabled
+// by the organization are kept.
+func (storage DBStorage) DeleteReportsForCluster(clusterName types.ClusterName) (_ DeletedRows, err error) {
+	op := storage.startOperation("DeleteReportsForCluster", maintenance).forCluster(clusterName)
+	defer op.finish(&err)
+
+	var deleted DeletedRows
+
+	err = storage.deleteCounted(op.ctx, []countedDeletion{
+		{"DELETE FROM cluster_rule_user_message WHERE cluster_id = $1", &deleted.FeedbackMessages},
+		{"DELETE FROM cluster_rule_user_feedback WHERE cluster_id = $1", &deleted.Feedback},
+		{"DELETE FROM cluster_rule_toggle WHERE cluster_id = $1", &deleted.Toggles},
+		{"DELETE FROM rule_hit WHERE cluster = $1", &deleted.RuleHits},
+		{"DELETE FROM report_history WHERE cluster = $1", &deleted.ReportHistory},
+		{"DELETE FROM consumer_error WHERE cluster = $1", &deleted.ConsumerErrors},
+		{"DELETE FROM report WHERE cluster = $1", &deleted.Reports},
+	}, clusterName)
+	if err != nil {
+		return DeletedRows{}, err
+	}
+
+	storage.orgIDs.forget(clusterName)
+
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "DeleteReportsForCluster", ClusterName: clusterName, DeletedRows: deleted.total(),
+	})
+
+	return deleted, nil
+}
+
+// clusterValues converts cluster names to arguments of a query
+func clusterValues(clusterNames []types.ClusterName) []interface{} {
+	values := make([]interface{}, len(clusterNames))
+	for i, clusterName := range clusterNames {
+		values[i] = clusterName
+	}
+
+	return values
+}
+
+// DeleteReportsForClusters deletes reports, their history, rule hits, processing errors, users' feedback
+// and rules toggled for all specified clusters in a single transaction and returns clusters whose reports
+// were deleted, soft-deleted reports included.
+func (storage DBStorage) DeleteReportsForClusters(clusterNames []types.ClusterName) (_ []types.ClusterName, err error) {
+	op := storage.startOperation("DeleteReportsForClusters", maintenance)
+	defer op.finish(&err)
+
+	if len(clusterNames) == 0 {
+		return []types.ClusterName{}, nil
+	}
+
+	args := storage.dialect().newQueryArgs()
+	inClause := args.addList(clusterValues(clusterNames)...)
+
+	tx, err := storage.connection.BeginTx(op.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var deleted DeletedRows
+	err = execCountedInTx(op.ctx, tx, []countedDeletion{
+		{"DELETE FROM cluster_rule_user_message WHERE cluster_id IN " + inClause, &deleted.FeedbackMessages},
+		{"DELETE FROM cluster_rule_user_feedback WHERE cluster_id IN " + inClause, &deleted.Feedback},
+		{"DELETE FROM cluster_rule_toggle WHERE cluster_id IN " + inClause, &deleted.Toggles},
+		{"DELETE FROM report_history WHERE cluster IN " + inClause, &deleted.ReportHistory},
+		{"DELETE FROM rule_hit WHERE cluster IN " + inClause, &deleted.RuleHits},
+		{"DELETE FROM consumer_error WHERE cluster IN " + inClause, &deleted.ConsumerErrors},
+	}, args.values...)
+	if err != nil {
+		return nil, err
+	}
+
+	deletedClusters, err := storage.deleteReportsReturningClusters(op.ctx, tx, inClause, args.values)
+	if err != nil {
+		return nil, err
+	}
+	deleted.Reports = len(deletedClusters)
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	storage.orgIDs.forget(clusterNames...)
+	storage.recordAudit(op.ctx, AuditLogEntry{Operation: "DeleteReportsForClusters", DeletedRows: deleted.total()})
+
+	return deletedClusters, nil
+}
+
+// deleteReportsReturningClusters deletes reports of clusters from the IN clause in the transaction
+// and returns the clusters whose reports were deleted, sorted by name. PostgreSQL returns them from
+// the DELETE itself. SQLite bundled with the driver doesn't support RETURNING clause, so the clusters
+// are selected first; a write committed by other connection in between makes the DELETE fail
+// instead of deleting a report of unlisted cluster.
+func (storage DBStorage) deleteReportsReturningClusters(
+	ctx context.Context, tx *sql.Tx, inClause string, args []interface{},
+) ([]types.ClusterName, error) {
+	query := "SELECT cluster FROM report WHERE cluster IN " + inClause
+	if storage.dbDriverType == DBDriverPostgres {
+		query = "DELETE FROM report WHERE cluster IN " + inClause + " RETURNING cluster"
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := make([]types.ClusterName, 0)
+	for rows.Next() {
+		var clusterName types.ClusterName
+		if err := rows.Scan(&clusterName); err != nil {
+			closeRows(rows)
+			return nil, err
+		}
+
+		clusters = append(clusters, clusterName)
+	}
+	closeRows(rows)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if storage.dbDriverType != DBDriverPostgres {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM report WHERE cluster IN "+inClause, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i] < clusters[j] })
+
+	return clusters, nil
+}
+
+// CleanupOldReports deletes reports not checked for longer than olderThan together with their history,
+// rule hits and users' feedback and returns number of deleted reports.
+func (storage DBStorage) CleanupOldReports(olderThan time.Duration) (_ int, err error) {
+	op := storage.startOperation("CleanupOldReports", maintenance)
+	defer op.finish(&err)
+
+	return storage.cleanupReportsCheckedBefore(op.ctx, timeNow().Add(-olderThan), nil)
+}
+
+// CleanupClustersCheckedBefore deletes reports of the specified clusters last checked before the cutoff time
+// together with their history, rule hits and users' feedback and returns number of deleted reports.
+// Reports of the clusters checked since the cutoff time are kept.
+func (storage DBStorage) CleanupClustersCheckedBefore(
+	cutoff time.Time, clusterNames []types.ClusterName,
+) (_ int, err error) {
+	op := storage.startOperation("CleanupClustersCheckedBefore", maintenance)
+	defer op.finish(&err)
+
+	if len(clusterNames) == 0 {
+		return 0, nil
+	}
+
+	return storage.cleanupReportsCheckedBefore(op.ctx, cutoff, clusterNames)
+}
+
+// cleanupReportsCheckedBefore deletes reports last checked before the cutoff time together with
+// their history, rule hits, users' feedback and rules toggled for the clusters in a single transaction,
+// only reports of the specified clusters are deleted when clusterNames is not nil.
+// Processing errors of messages consumed before the cutoff time are deleted as well.
+func (storage DBStorage) cleanupReportsCheckedBefore(
+	ctx context.Context, cutoff time.Time, clusterNames []types.ClusterName,
+) (int, error) {
+	args := storage.dialect().newQueryArgs()
+	clustersCondition := ""
+
+	if clusterNames != nil {
+		clustersCondition = "cluster IN " + args.addList(clusterValues(clusterNames)...) + " AND "
+	}
+
+	cutoffPlaceholder := args.add(cutoff)
+	oldReportsCondition := clustersCondition + "last_checked_at < " + cutoffPlaceholder
+	oldConsumerErrorsCondition := clustersCondition + "consumed_at < " + cutoffPlaceholder
+
+	oldClustersQuery := "SELECT cluster FROM report WHERE " + oldReportsCondition
+
+	tx, err := storage.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx, "DELETE FROM cluster_rule_user_message WHERE cluster_id IN ("+oldClustersQuery+")", args.values...,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx, "DELETE FROM cluster_rule_user_feedback WHERE cluster_id IN ("+oldClustersQuery+")", args.values...,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx, "DELETE FROM cluster_rule_toggle WHERE cluster_id IN ("+oldClustersQuery+")", args.values...,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM report_history WHERE cluster IN ("+oldClustersQuery+")", args.values...)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM rule_hit WHERE cluster IN ("+oldClustersQuery+")", args.values...)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM consumer_error WHERE "+oldConsumerErrorsCondition, args.values...)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM report WHERE "+oldReportsCondition, args.values...)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// deleted clusters aren't known, they're usually not looked up anymore anyway
+	storage.orgIDs.purge()
+
+	return int(deleted), nil
+}
+
+// reportsCheckedBeforeQuery selects reports last checked before the cutoff time followed by their history,
+// the newest report of the history goes first. Rows of the history have the same organization as the report
+// they belong to, so all rows of a cluster are next to each other.
+const reportsCheckedBeforeQuery = `
+	SELECT org_id, cluster, 0 AS history, report, last_checked_at FROM report
+	 WHERE last_checked_at < $1
+	UNION ALL
+	SELECT report.org_id, report_history.cluster, 1, report_history.report, report_history.last_checked_at
+	  FROM report_history JOIN report ON report.cluster = report_history.cluster
+	 WHERE report.last_checked_at < $1
+	 ORDER BY org_id, cluster, history, last_checked_at DESC`
+
+// ForEachReportCheckedBefore passes reports last checked before the cutoff time together with their history,
+// these are the reports which are going to be deleted by the cleanup, to the process function ordered
+// by organization and cluster. Reports are passed one by one as they are read from the database, so all
+// of them don't have to fit in memory. The first error returned by the process function stops the reading.
+func (storage DBStorage) ForEachReportCheckedBefore(
+	cutoff time.Time, process func(types.ArchivedReport) error,
+) (err error) {
+	op := storage.startOperation("ForEachReportCheckedBefore", maintenance)
+	defer op.finish(&err)
+
+	rows, err := storage.connection.QueryContext(op.ctx, reportsCheckedBeforeQuery, cutoff)
+	if err != nil {
+		return err
+	}
+	defer closeRows(rows)
+
+	var current *types.ArchivedReport
+
+	for rows.Next() {
+		var (
+			orgID       types.OrgID
+			clusterName types.ClusterName
+			history     int
+			report      types.ClusterReport
+			lastChecked time.Time
+		)
+
+		err = rows.Scan(&orgID, &clusterName, &history, &report, scanTimestamp(&lastChecked))
+		if err != nil {
+			return err
+		}
+
+		report, err = storage.decodeReport(report)
+		if err != nil {
+			return err
+		}
+
+		if history != 0 {
+			current.History = append(current.History, types.ReportHistoryEntry{
+				Report:        report,
+				LastCheckedAt: types.NewOptionalTimestamp(lastChecked),
+			})
+			continue
+		}
+
+		if current != nil {
+			if err = process(*current); err != nil {
+				return err
+			}
+		}
+
+		current = &types.ArchivedReport{
+			OrgID:         orgID,
+			ClusterName:   clusterName,
+			Report:        report,
+			LastCheckedAt: types.NewOptionalTimestamp(lastChecked),
+			History:       make([]types.ReportHistoryEntry, 0),
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if current != nil {
+		return process(*current)
+	}
+
+	return nil
 }
 
-// DeleteReportsForCluster deletes all reports related to the specified cluster from the storage.
-func (storage DBStorage) DeleteReportsForCluster(clusterName types.ClusterName) error {
-	_, err := storage.connection.Exec("DELETE FROM report WHERE cluster = $1", clusterName)
-	return err
+// GetExistingClusters returns those of the specified clusters that have a report stored
+func (storage DBStorage) GetExistingClusters(clusterNames []types.ClusterName) (_ []types.ClusterName, err error) {
+	op := storage.startOperation("GetExistingClusters", fastRead)
+	defer op.finish(&err)
+
+	clusters := make([]types.ClusterName, 0)
+
+	if len(clusterNames) == 0 {
+		return clusters, nil
+	}
+
+	args := storage.dialect().newQueryArgs()
+	inClause := args.addList(clusterValues(clusterNames)...)
+
+	rows, err := storage.reads().QueryContext(
+		op.ctx, "SELECT cluster FROM report WHERE deleted_at IS NULL AND cluster IN "+inClause, args.values...,
+	)
+	if err != nil {
+		return clusters, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var clusterName types.ClusterName
+
+		err = rows.Scan(&clusterName)
+		if err == nil {
+			clusters = append(clusters, clusterName)
+		} else {
+			log.Error().Err(err).Msg("GetExistingClusters")
+		}
+	}
+
+	return clusters, rows.Err()
 }
 
 // loadRuleErrorKeyContent inserts the error key contents of all available rules into the database.
-func loadRuleErrorKeyContent(tx *sql.Tx, ruleModuleName string, errorKeys map[string]content.RuleErrorKeyContent) error {
+func loadRuleErrorKeyContent(
+	ctx context.Context, tx *sql.Tx, ruleModuleName string, errorKeys map[string]content.RuleErrorKeyContent,
+) error {
 	for errName, errProperties := range errorKeys {
 		var errIsActiveStatus bool
 		switch strings.ToLower(errProperties.Metadata.Status) {
@@ -483,7 +2044,7 @@ func loadRuleErrorKeyContent(tx *sql.Tx, ruleModuleName string, errorKeys map[st
 			return fmt.Errorf("invalid rule error key status: '%s'", errProperties.Metadata.Status)
 		}
 
-		_, err := tx.Exec(`INSERT INTO rule_error_key(error_key, rule_module, condition,
+		_, err := tx.ExecContext(ctx, `INSERT INTO rule_error_key(error_key, rule_module, condition,
 				description, impact, likelihood, publish_date, active, generic)
 				VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 			errName,
@@ -505,21 +2066,29 @@ func loadRuleErrorKeyContent(tx *sql.Tx, ruleModuleName string, errorKeys map[st
 	return nil
 }
 
-// LoadRuleContent loads the parsed rule content into the database.
-func (storage DBStorage) LoadRuleContent(contentDir content.RuleContentDirectory) error {
-	tx, err := storage.connection.Begin()
+// LoadRuleContent loads the parsed rule content into the database
+// and records checksums of its rules into the content history.
+// The old content is replaced in a single transaction. Readers of rule content
+// query it in single statements (GetContentForRules joins error keys with their rules)
+// or in one read-only transaction (GetContentChanges), so they see either the old
+// or the new complete content, never a partially loaded one.
+func (storage DBStorage) LoadRuleContent(contentDir content.RuleContentDirectory) (err error) {
+	op := storage.startOperation("LoadRuleContent", maintenance)
+	defer op.finish(&err)
+
+	tx, err := storage.connection.BeginTx(op.ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	// SQLite doesn't support `TRUNCATE`, so it's necessary to use `DELETE` and then `VACUUM`.
-	if _, err := tx.Exec("DELETE FROM rule_error_key; DELETE FROM rule;"); err != nil {
+	if _, err := tx.ExecContext(op.ctx, "DELETE FROM rule_error_key; DELETE FROM rule;"); err != nil {
 		_ = tx.Rollback()
 		return err
 	}
 
 	for _, rule := range contentDir {
-		_, err := tx.Exec(`INSERT INTO rule(module, "name", summary, reason, resolution, more_info)
+		_, err := tx.ExecContext(op.ctx, `INSERT INTO rule(module, "name", summary, reason, resolution, more_info)
 				VALUES($1, $2, $3, $4, $5, $6)`,
 			rule.Plugin.PythonModule,
 			rule.Plugin.Name,
@@ -533,12 +2102,17 @@ func (storage DBStorage) LoadRuleContent(contentDir content.RuleContentDirectory
 			return err
 		}
 
-		if err := loadRuleErrorKeyContent(tx, rule.Plugin.PythonModule, rule.ErrorKeys); err != nil {
+		if err := loadRuleErrorKeyContent(op.ctx, tx, rule.Plugin.PythonModule, rule.ErrorKeys); err != nil {
 			_ = tx.Rollback()
 			return err
 		}
 	}
 
+	if err := storage.writeContentVersion(op.ctx, tx, contentDir); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
@@ -547,10 +2121,13 @@ func (storage DBStorage) LoadRuleContent(contentDir content.RuleContentDirectory
 }
 
 // GetRuleByID gets a rule by ID
-func (storage DBStorage) GetRuleByID(ruleID types.RuleID) (*types.Rule, error) {
+func (storage DBStorage) GetRuleByID(ruleID types.RuleID) (_ *types.Rule, err error) {
+	op := storage.startOperation("GetRuleByID", fastRead)
+	defer op.finish(&err)
+
 	var rule types.Rule
 
-	err := storage.connection.QueryRow(`
+	err = storage.reads().QueryRowContext(op.ctx, `
 		SELECT
 			"module",
 			"name",
@@ -567,9 +2144,176 @@ func (storage DBStorage) GetRuleByID(ruleID types.RuleID) (*types.Rule, error) {
 		&rule.Resolution,
 		&rule.MoreInfo,
 	)
-	if err == sql.ErrNoRows {
-		return nil, &ItemNotFoundError{ItemID: ruleID}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, newItemNotFoundError(ItemKindRule, ruleID).withCause(err)
 	}
 
 	return &rule, err
 }
+
+// RuleFilter restricts rules returned by ListRules, zero value of the filter
+// matches all rules
+//
+// Active - when set, only rules with (true) or without (false) any active error key are returned
+//
+// ModulePrefix - only rules whose module starts with the prefix are returned
+//
+// Limit and Offset - page of the rules ordered by module, zero Limit means no limit
+type RuleFilter struct {
+	Active       *bool
+	ModulePrefix string
+	Limit        int
+	Offset       int
+}
+
+// likePatternEscaper escapes characters with special meaning in LIKE patterns,
+// the escape character is set by ESCAPE clause of the query
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ListRules returns rules matching the filter ordered by module
+func (storage DBStorage) ListRules(filter RuleFilter) (_ []types.Rule, err error) {
+	op := storage.startOperation("ListRules", fastRead)
+	defer op.finish(&err)
+
+	rules := make([]types.Rule, 0)
+
+	if filter.Limit < 0 {
+		return rules, &ValidationError{ParamName: "limit", ErrString: "non-negative value expected"}
+	}
+	if filter.Offset < 0 {
+		return rules, &ValidationError{ParamName: "offset", ErrString: "non-negative value expected"}
+	}
+
+	var conditions []string
+	args := storage.dialect().newQueryArgs()
+
+	if filter.Active != nil {
+		condition := `EXISTS (
+			SELECT 1 FROM rule_error_key
+			 WHERE rule_error_key.rule_module = rule."module" AND rule_error_key.active
+		)`
+		if !*filter.Active {
+			condition = "NOT " + condition
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if len(filter.ModulePrefix) > 0 {
+		pattern := args.add(likePatternEscaper.Replace(filter.ModulePrefix) + "%")
+		conditions = append(conditions, `"module" LIKE `+pattern+` ESCAPE '\'`)
+	}
+
+	query := `SELECT "module", "name", "summary", "reason", "resolution", "more_info" FROM rule`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY "module"`
+
+	limitOffset, ok := args.limitOffset(filter.Limit, filter.Offset)
+	if !ok {
+		return rules, fmt.Errorf("listing rules with DB %v is not supported", storage.dbDriverType)
+	}
+	query += limitOffset
+
+	rows, err := storage.reads().QueryContext(op.ctx, query, args.values...)
+	if err != nil {
+		return rules, err
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var rule types.Rule
+
+		err = rows.Scan(
+			&rule.Module,
+			&rule.Name,
+			&rule.Summary,
+			&rule.Reason,
+			&rule.Resolution,
+			&rule.MoreInfo,
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("ListRules")
+			return rules, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// DeleteRule deletes the rule together with all its error keys
+func (storage DBStorage) DeleteRule(ruleID types.RuleID) (err error) {
+	op := storage.startOperation("DeleteRule", maintenance)
+	defer op.finish(&err)
+
+	tx, err := storage.connection.BeginTx(op.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(op.ctx, "DELETE FROM rule_error_key WHERE rule_module = $1", ruleID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	deletedErrorKeys, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	result, err = tx.ExecContext(op.ctx, `DELETE FROM rule WHERE "module" = $1`, ruleID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if deleted == 0 {
+		_ = tx.Rollback()
+		return newItemNotFoundError(ItemKindRule, ruleID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	storage.recordAudit(op.ctx, AuditLogEntry{
+		Operation: "DeleteRule", RuleID: ruleID, DeletedRows: int(deletedErrorKeys + deleted),
+	})
+
+	return nil
+}
+
+// DeleteRuleErrorKey deletes the error key of the rule, the rule itself is kept
+func (storage DBStorage) DeleteRuleErrorKey(ruleID types.RuleID, errorKey types.ErrorKey) (err error) {
+	op := storage.startOperation("DeleteRuleErrorKey", maintenance)
+	defer op.finish(&err)
+
+	result, err := storage.connection.ExecContext(
+		op.ctx,
+		"DELETE FROM rule_error_key WHERE rule_module = $1 AND error_key = $2", ruleID, errorKey,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return newItemNotFoundError(ItemKindErrorKey, ruleID, errorKey)
+	}
+
+	return nil
+}
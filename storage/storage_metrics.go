@@ -0,0 +1,54 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+)
+
+// countedTables are tables whose rows are counted by CollectStorageMetrics
+// together with setters of the gauges the numbers of rows are exposed by
+var countedTables = []struct {
+	table string
+	set   func(float64)
+}{
+	{"report", metrics.DBReportRows.Set},
+	{"cluster_rule_user_feedback", metrics.DBFeedbackRows.Set},
+	{"rule", metrics.DBRuleRows.Set},
+}
+
+// CollectStorageMetrics counts rows of the main tables and sets the gauges of the numbers of rows.
+// Each table is counted by its own query outside of any transaction (on the read replica when it's
+// configured), so the counting doesn't block writes and the numbers can be slightly outdated.
+// Gauges of the tables which weren't counted keep their previous values when an error is returned.
+func (storage DBStorage) CollectStorageMetrics() (err error) {
+	op := storage.startOperation("CollectStorageMetrics", heavyAggregation)
+	defer op.finish(&err)
+
+	for _, counted := range countedTables {
+		var count int64
+
+		err = storage.reads().QueryRowContext(op.ctx, "SELECT COUNT(*) FROM "+counted.table).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		counted.set(float64(count))
+	}
+
+	return nil
+}
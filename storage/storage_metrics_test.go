@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+)
+
+func TestDBStorageCollectStorageMetrics(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustWriteReport3Rules(t, mockStorage)
+	helpers.FailOnError(t, mockStorage.VoteOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+	))
+
+	helpers.FailOnError(t, mockStorage.(*storage.DBStorage).CollectStorageMetrics())
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DBReportRows))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DBFeedbackRows))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.DBRuleRows))
+}
+
+// TestDBStorageCollectStorageMetricsError checks that gauges of the tables which weren't counted
+// keep their values when the counting fails
+func TestDBStorageCollectStorageMetricsError(t *testing.T) {
+	const errStr = "count error"
+
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	metrics.DBFeedbackRows.Set(5)
+
+	expects.ExpectQueryWithArgs("SELECT COUNT(*) FROM report").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	expects.ExpectQueryWithArgs("SELECT COUNT(*) FROM cluster_rule_user_feedback").
+		WillReturnError(fmt.Errorf(errStr))
+
+	err := mockStorage.(*storage.DBStorage).CollectStorageMetrics()
+	assert.EqualError(t, err, errStr)
+
+	assert.Equal(t, 42.0, testutil.ToFloat64(metrics.DBReportRows))
+	assert.Equal(t, 5.0, testutil.ToFloat64(metrics.DBFeedbackRows))
+}
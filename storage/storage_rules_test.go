@@ -19,6 +19,9 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
@@ -135,7 +138,7 @@ var (
 
 func mustWriteReport3Rules(t *testing.T, mockStorage storage.Storage) {
 	err := mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
 	)
 	helpers.FailOnError(t, err)
 
@@ -144,11 +147,64 @@ func mustWriteReport3Rules(t *testing.T, mockStorage storage.Storage) {
 }
 
 func TestDBStorageLoadRuleContentActiveOK(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.LoadRuleContent(ruleContentActiveOK)
+		helpers.FailOnError(t, err)
+	})
+}
+
+// TestDBStorageLoadRuleContentFromDirectory checks that the content parsed from directory
+// can be read back after it's loaded, even when it's loaded repeatedly
+func TestDBStorageLoadRuleContentFromDirectory(t *testing.T) {
+	const ruleID = "ccx_rules_ocp.external.rules.rule1"
+
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	err := mockStorage.LoadRuleContent(ruleContentActiveOK)
+	contentDir, err := content.ParseRuleContentDir("../tests/content/ok/")
+	helpers.FailOnError(t, err)
+
+	for i := 0; i < 2; i++ {
+		helpers.FailOnError(t, mockStorage.LoadRuleContent(contentDir))
+	}
+
+	rule, err := mockStorage.GetRuleByID(ruleID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, &types.Rule{
+		Module:     ruleID,
+		Name:       "Rule 1 name",
+		Summary:    "# Rule 1 Summary\n",
+		Reason:     "Rule 1 reason\n",
+		Resolution: "Rule 1 resolution\n",
+		MoreInfo:   "# Some more information\n\n## would be put\n\n### into this file\n",
+	}, rule)
+
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
+
+	var (
+		errorKey, condition, description, generic string
+		impact, likelihood                        int
+		active                                    bool
+		count                                     int
+	)
+
+	err = connection.QueryRow(`
+		SELECT error_key, condition, description, impact, likelihood, active, generic
+		FROM rule_error_key WHERE rule_module = $1`, ruleID,
+	).Scan(&errorKey, &condition, &description, &impact, &likelihood, &active, &generic)
 	helpers.FailOnError(t, err)
+
+	assert.Equal(t, "err_key", errorKey)
+	assert.Equal(t, "Rule 1 condition", condition)
+	assert.Equal(t, "Rule 1 error key description", description)
+	assert.Equal(t, 2, impact)
+	assert.Equal(t, 3, likelihood)
+	assert.True(t, active)
+	assert.Equal(t, "Rule 1 error key generic text\n", generic)
+
+	err = connection.QueryRow("SELECT count(*) FROM rule_error_key").Scan(&count)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, count)
 }
 
 func TestDBStorageLoadRuleContentDBError(t *testing.T) {
@@ -189,6 +245,64 @@ func TestDBStorageLoadRuleContentInsertIntoRuleErrorKeyError(t *testing.T) {
 	assert.EqualError(t, err, "CHECK constraint failed: rule_error_key")
 }
 
+// TestDBStorageLoadRuleContentConcurrentReads checks that rules read from another connection
+// during reloads of rule content are always the complete old or new content
+func TestDBStorageLoadRuleContentConcurrentReads(t *testing.T) {
+	// in-memory database can't be shared by more connections
+	dir, err := ioutil.TempDir("", "rule_content")
+	helpers.FailOnError(t, err)
+	defer func() {
+		helpers.FailOnError(t, os.RemoveAll(dir))
+	}()
+
+	dbStorage, err := storage.New(storage.Configuration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: filepath.Join(dir, "aggregator.db"),
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, dbStorage)
+
+	helpers.FailOnError(t, dbStorage.Init())
+
+	oneRule := content.RuleContentDirectory{}
+	for name, rule := range testdata.RuleContent3Rules {
+		oneRule[name] = rule
+		break
+	}
+	contents := []content.RuleContentDirectory{testdata.RuleContent3Rules, oneRule}
+
+	helpers.FailOnError(t, dbStorage.LoadRuleContent(contents[0]))
+
+	loaded := make(chan error)
+	go func() {
+		for i := 1; i <= 20; i++ {
+			if err := dbStorage.LoadRuleContent(contents[i%len(contents)]); err != nil {
+				loaded <- err
+				return
+			}
+		}
+		loaded <- nil
+	}()
+
+	for {
+		select {
+		case err := <-loaded:
+			helpers.FailOnError(t, err)
+			return
+		default:
+		}
+
+		rules, err := dbStorage.ListRules(storage.RuleFilter{})
+		helpers.FailOnError(t, err)
+
+		if len(rules) != len(contents[0]) && len(rules) != len(contents[1]) {
+			// wait for the loader, so the storage isn't closed under it
+			<-loaded
+			t.Fatalf("partially loaded rule content has been read: %v rules", len(rules))
+		}
+	}
+}
+
 func TestDBStorageLoadRuleContentDeleteDBError(t *testing.T) {
 	const errorStr = "delete error"
 	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
@@ -204,58 +318,434 @@ func TestDBStorageLoadRuleContentDeleteDBError(t *testing.T) {
 
 func TestDBStorageLoadRuleContentCommitDBError(t *testing.T) {
 	const errorStr = "commit error"
-	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverSQLite3)
 	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
 
 	expects.ExpectBegin()
 	expects.ExpectExec("DELETE FROM rule_error_key").WillReturnResult(driver.ResultNoRows)
+	expects.ExpectExec("INSERT OR REPLACE INTO content_version").WillReturnResult(driver.ResultNoRows)
+	expects.ExpectExec("DELETE FROM content_version").WillReturnResult(driver.ResultNoRows)
 	expects.ExpectCommit().WillReturnError(fmt.Errorf(errorStr))
 
 	err := mockStorage.LoadRuleContent(content.RuleContentDirectory{})
 	assert.EqualError(t, err, errorStr)
 }
 
-func TestDBStorageLoadRuleContentInactiveOK(t *testing.T) {
+func TestDBStorageLoadRuleContentContentVersionDBError(t *testing.T) {
+	const errorStr = "content version error"
+	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverSQLite3)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExec("DELETE FROM rule_error_key").WillReturnResult(driver.ResultNoRows)
+	expects.ExpectExec("INSERT OR REPLACE INTO content_version").WillReturnError(fmt.Errorf(errorStr))
+	expects.ExpectRollback()
+
+	err := mockStorage.LoadRuleContent(content.RuleContentDirectory{})
+	assert.EqualError(t, err, errorStr)
+}
+
+// contentChecksum returns checksum of the rule content as it's stored in the content history
+func contentChecksum(t *testing.T, contentDir content.RuleContentDirectory) string {
+	ruleChecksums, err := content.RuleChecksums(contentDir)
+	helpers.FailOnError(t, err)
+
+	checksum, err := content.Checksum(ruleChecksums)
+	helpers.FailOnError(t, err)
+
+	return checksum
+}
+
+func TestDBStorageGetContentChanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		versions := []content.RuleContentDirectory{
+			testdata.RuleContentVersion1, testdata.RuleContentVersion2, testdata.RuleContentVersion3,
+		}
+		for _, contentDir := range versions {
+			helpers.FailOnError(t, mockStorage.LoadRuleContent(contentDir))
+		}
+
+		changes, err := mockStorage.GetContentChanges(contentChecksum(t, versions[0]), contentChecksum(t, versions[1]))
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.ContentChanges{
+			Added:    []types.RuleID{testdata.Rule3ID},
+			Removed:  []types.RuleID{},
+			Modified: []types.RuleID{testdata.Rule1ID},
+		}, changes)
+
+		changes, err = mockStorage.GetContentChanges(contentChecksum(t, versions[1]), contentChecksum(t, versions[2]))
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.ContentChanges{
+			Added:    []types.RuleID{},
+			Removed:  []types.RuleID{testdata.Rule2ID},
+			Modified: []types.RuleID{},
+		}, changes)
+
+		changes, err = mockStorage.GetContentChanges(contentChecksum(t, versions[0]), contentChecksum(t, versions[2]))
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.ContentChanges{
+			Added:    []types.RuleID{testdata.Rule3ID},
+			Removed:  []types.RuleID{testdata.Rule2ID},
+			Modified: []types.RuleID{testdata.Rule1ID},
+		}, changes)
+
+		// no changes between the same versions
+		changes, err = mockStorage.GetContentChanges(contentChecksum(t, versions[2]), contentChecksum(t, versions[2]))
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.ContentChanges{
+			Added:    []types.RuleID{},
+			Removed:  []types.RuleID{},
+			Modified: []types.RuleID{},
+		}, changes)
+	})
+}
+
+func TestDBStorageGetContentChangesVersionNotRetained(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		storage.SetContentHistoryDepth(mockStorage, 2)
+
+		versions := []content.RuleContentDirectory{
+			testdata.RuleContentVersion1, testdata.RuleContentVersion2, testdata.RuleContentVersion3,
+		}
+		for _, contentDir := range versions {
+			helpers.FailOnError(t, mockStorage.LoadRuleContent(contentDir))
+		}
+
+		oldestChecksum := contentChecksum(t, versions[0])
+		_, err := mockStorage.GetContentChanges(oldestChecksum, contentChecksum(t, versions[2]))
+		assertItemNotFound(t, err, storage.ItemKindContentVersion, oldestChecksum)
+
+		_, err = mockStorage.GetContentChanges(contentChecksum(t, versions[1]), contentChecksum(t, versions[2]))
+		helpers.FailOnError(t, err)
+
+		// loading the oldest version again makes it the most recent one
+		helpers.FailOnError(t, mockStorage.LoadRuleContent(versions[0]))
+
+		_, err = mockStorage.GetContentChanges(contentChecksum(t, versions[2]), oldestChecksum)
+		helpers.FailOnError(t, err)
+
+		_, err = mockStorage.GetContentChanges(contentChecksum(t, versions[1]), oldestChecksum)
+		if _, ok := err.(*storage.ItemNotFoundError); !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+	})
+}
+
+func TestDBStorageGetContentChangesUnknownChecksum(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContentVersion1))
+
+		_, err := mockStorage.GetContentChanges(contentChecksum(t, testdata.RuleContentVersion1), "unknown")
+		assertItemNotFound(t, err, storage.ItemKindContentVersion, "unknown")
+	})
+}
+
+func TestDBStorageGetContentChangesDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.GetContentChanges("from", "to")
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+// TestDBStorageGetContentChangesSingleTransaction checks that both versions of rule content
+// are read in one transaction, so they can't be removed from the history between the reads
+func TestDBStorageGetContentChangesSingleTransaction(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
 
-	err := mockStorage.LoadRuleContent(ruleContentInactiveOK)
+	expects.ExpectBegin()
+	expects.ExpectQuery("SELECT rule_checksums FROM content_version").
+		WithArgs("from").
+		WillReturnRows(sqlmock.NewRows([]string{"rule_checksums"}).AddRow(`{"rule1": "a", "rule2": "b"}`))
+	expects.ExpectQuery("SELECT rule_checksums FROM content_version").
+		WithArgs("to").
+		WillReturnRows(sqlmock.NewRows([]string{"rule_checksums"}).AddRow(`{"rule1": "c"}`))
+	expects.ExpectRollback()
+
+	changes, err := mockStorage.GetContentChanges("from", "to")
 	helpers.FailOnError(t, err)
+	assert.Equal(t, types.ContentChanges{
+		Added:    []types.RuleID{},
+		Removed:  []types.RuleID{"rule2"},
+		Modified: []types.RuleID{"rule1"},
+	}, changes)
 }
 
-func TestDBStorageLoadRuleContentNull(t *testing.T) {
+// countRuleErrorKeys returns number of error keys of the rule stored in the database
+func countRuleErrorKeys(t *testing.T, mockStorage storage.Storage, ruleID types.RuleID) int {
+	var count int
+
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
+	err := connection.QueryRow("SELECT count(*) FROM rule_error_key WHERE rule_module = $1", ruleID).Scan(&count)
+	helpers.FailOnError(t, err)
+
+	return count
+}
+
+// TestDBStorageLoadRuleContentPrunesMissingRules checks that rules missing in the loaded content
+// are deleted together with their error keys
+func TestDBStorageLoadRuleContentPrunesMissingRules(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	err := mockStorage.LoadRuleContent(ruleContentNull)
-	if err == nil || err.Error() != "NOT NULL constraint failed: rule.summary" {
-		t.Fatal(err)
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContent3Rules))
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContentVersion1))
+
+	_, err := mockStorage.GetRuleByID(testdata.Rule3ID)
+	if _, ok := err.(*storage.ItemNotFoundError); !ok {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+	assert.Equal(t, 0, countRuleErrorKeys(t, mockStorage, testdata.Rule3ID))
+
+	for _, ruleID := range []types.RuleID{testdata.Rule1ID, testdata.Rule2ID} {
+		_, err := mockStorage.GetRuleByID(ruleID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, countRuleErrorKeys(t, mockStorage, ruleID))
 	}
 }
 
-func TestDBStorageLoadRuleContentBadStatus(t *testing.T) {
+func TestDBStorageDeleteRule(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	err := mockStorage.LoadRuleContent(ruleContentBadStatus)
-	if err == nil || err.Error() != "invalid rule error key status: 'bad'" {
-		t.Fatal(err)
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContent3Rules))
+
+	helpers.FailOnError(t, mockStorage.DeleteRule(testdata.Rule1ID))
+
+	_, err := mockStorage.GetRuleByID(testdata.Rule1ID)
+	if _, ok := err.(*storage.ItemNotFoundError); !ok {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+	assert.Equal(t, 0, countRuleErrorKeys(t, mockStorage, testdata.Rule1ID))
+
+	// other rules are untouched
+	for _, ruleID := range []types.RuleID{testdata.Rule2ID, testdata.Rule3ID} {
+		_, err := mockStorage.GetRuleByID(ruleID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, countRuleErrorKeys(t, mockStorage, ruleID))
 	}
+
+	err = mockStorage.DeleteRule(testdata.Rule1ID)
+	assertItemNotFound(t, err, storage.ItemKindRule, testdata.Rule1ID)
 }
 
-func TestDBStorageGetContentForRulesEmpty(t *testing.T) {
+func TestDBStorageDeleteRuleErrorKey(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	res, err := mockStorage.GetContentForRules(types.ReportRules{
-		HitRules:     nil,
-		SkippedRules: nil,
-		PassedRules:  nil,
-		TotalCount:   0,
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContent3Rules))
+
+	helpers.FailOnError(t, mockStorage.DeleteRuleErrorKey(testdata.Rule2ID, testdata.ErrorKey2))
+
+	// the rule itself is kept
+	_, err := mockStorage.GetRuleByID(testdata.Rule2ID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, countRuleErrorKeys(t, mockStorage, testdata.Rule2ID))
+
+	for _, ruleID := range []types.RuleID{testdata.Rule1ID, testdata.Rule3ID} {
+		assert.Equal(t, 1, countRuleErrorKeys(t, mockStorage, ruleID))
+	}
+
+	err = mockStorage.DeleteRuleErrorKey(testdata.Rule2ID, testdata.ErrorKey2)
+	assertItemNotFound(t, err, storage.ItemKindErrorKey, testdata.Rule2ID, testdata.ErrorKey2)
+
+	// error key of another rule is not found
+	err = mockStorage.DeleteRuleErrorKey(testdata.Rule1ID, testdata.ErrorKey3)
+	if _, ok := err.(*storage.ItemNotFoundError); !ok {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+	assert.Equal(t, 1, countRuleErrorKeys(t, mockStorage, testdata.Rule3ID))
+}
+
+func TestDBStorageDeleteRuleDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.DeleteRule(testdata.Rule1ID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	err = mockStorage.DeleteRuleErrorKey(testdata.Rule1ID, testdata.ErrorKey1)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+// TestDBStorageDeleteRuleErrorKeysDeleteError checks that the rule is not deleted
+// when its error keys can't be deleted
+func TestDBStorageDeleteRuleErrorKeysDeleteError(t *testing.T) {
+	const errorStr = "delete error"
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExec("DELETE FROM rule_error_key").WillReturnError(fmt.Errorf(errorStr))
+	expects.ExpectRollback()
+
+	err := mockStorage.DeleteRule(testdata.Rule1ID)
+	assert.EqualError(t, err, errorStr)
+}
+
+// ruleContentWithStatus returns content of the rule with one error key in the status
+func ruleContentWithStatus(module types.RuleID, status string) content.RuleContent {
+	return content.RuleContent{
+		Summary:    []byte("summary"),
+		Reason:     []byte("reason"),
+		Resolution: []byte("resolution"),
+		MoreInfo:   []byte("more info"),
+		Plugin: content.RulePluginInfo{
+			Name:         string(module) + " name",
+			ProductCode:  "product code",
+			PythonModule: string(module),
+		},
+		ErrorKeys: map[string]content.RuleErrorKeyContent{
+			"ek": {
+				Generic: []byte("generic"),
+				Metadata: content.ErrorKeyMetadata{
+					Condition:   "condition",
+					Description: "description",
+					Impact:      1,
+					Likelihood:  1,
+					PublishDate: "1970-01-01 00:00:00",
+					Status:      status,
+				},
+			},
+		},
+	}
+}
+
+func TestDBStorageListRules(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.LoadRuleContent(content.RuleContentDirectory{
+			"a": ruleContentWithStatus("ccx.rules.a", "active"),
+			"b": ruleContentWithStatus("ccx.rules.b", "inactive"),
+			"c": ruleContentWithStatus("ccx.rules_extra.c", "active"),
+			"d": ruleContentWithStatus("other.d", "inactive"),
+		}))
+
+		active, inactive := true, false
+
+		for _, testCase := range []struct {
+			name     string
+			filter   storage.RuleFilter
+			expected []types.RuleID
+		}{
+			{"empty filter", storage.RuleFilter{},
+				[]types.RuleID{"ccx.rules.a", "ccx.rules.b", "ccx.rules_extra.c", "other.d"}},
+			{"active", storage.RuleFilter{Active: &active},
+				[]types.RuleID{"ccx.rules.a", "ccx.rules_extra.c"}},
+			{"inactive", storage.RuleFilter{Active: &inactive},
+				[]types.RuleID{"ccx.rules.b", "other.d"}},
+			{"module prefix", storage.RuleFilter{ModulePrefix: "ccx."},
+				[]types.RuleID{"ccx.rules.a", "ccx.rules.b", "ccx.rules_extra.c"}},
+			// underscore is not a wildcard
+			{"module prefix with underscore", storage.RuleFilter{ModulePrefix: "ccx.rules_"},
+				[]types.RuleID{"ccx.rules_extra.c"}},
+			{"unknown module prefix", storage.RuleFilter{ModulePrefix: "unknown"},
+				[]types.RuleID{}},
+			{"active with module prefix", storage.RuleFilter{Active: &inactive, ModulePrefix: "ccx."},
+				[]types.RuleID{"ccx.rules.b"}},
+			{"limit", storage.RuleFilter{Limit: 2},
+				[]types.RuleID{"ccx.rules.a", "ccx.rules.b"}},
+			{"offset", storage.RuleFilter{Offset: 3},
+				[]types.RuleID{"other.d"}},
+			{"limit and offset", storage.RuleFilter{Limit: 2, Offset: 1},
+				[]types.RuleID{"ccx.rules.b", "ccx.rules_extra.c"}},
+			{"all filters", storage.RuleFilter{Active: &active, ModulePrefix: "ccx.", Limit: 1, Offset: 1},
+				[]types.RuleID{"ccx.rules_extra.c"}},
+		} {
+			t.Run(testCase.name, func(t *testing.T) {
+				rules, err := mockStorage.ListRules(testCase.filter)
+				helpers.FailOnError(t, err)
+
+				modules := make([]types.RuleID, 0, len(rules))
+				for _, rule := range rules {
+					modules = append(modules, rule.Module)
+				}
+				assert.Equal(t, testCase.expected, modules)
+			})
+		}
+
+		rules, err := mockStorage.ListRules(storage.RuleFilter{ModulePrefix: "other."})
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.Rule{{
+			Module:     "other.d",
+			Name:       "other.d name",
+			Summary:    "summary",
+			Reason:     "reason",
+			Resolution: "resolution",
+			MoreInfo:   "more info",
+		}}, rules)
+	})
+}
+
+func TestDBStorageListRulesBadPaging(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		_, err := mockStorage.ListRules(storage.RuleFilter{Limit: -1})
+		assert.EqualError(t, err, "Invalid value of 'limit': non-negative value expected")
+
+		_, err = mockStorage.ListRules(storage.RuleFilter{Offset: -1})
+		assert.EqualError(t, err, "Invalid value of 'offset': non-negative value expected")
 	})
+}
+
+func TestDBStorageListRulesFakePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectQuery(`FROM rule ORDER BY "module" LIMIT ALL OFFSET \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"module", "name", "summary", "reason", "resolution", "more_info"}))
+
+	rules, err := mockStorage.ListRules(storage.RuleFilter{Offset: 2})
 	helpers.FailOnError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestDBStorageListRulesDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
 
-	assert.Empty(t, res)
+	_, err := mockStorage.ListRules(storage.RuleFilter{})
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageLoadRuleContentInactiveOK(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.LoadRuleContent(ruleContentInactiveOK)
+		helpers.FailOnError(t, err)
+	})
+}
+
+func TestDBStorageLoadRuleContentNull(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.LoadRuleContent(ruleContentNull)
+	if err == nil || err.Error() != "NOT NULL constraint failed: rule.summary" {
+		t.Fatal(err)
+	}
+}
+
+func TestDBStorageLoadRuleContentBadStatus(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.LoadRuleContent(ruleContentBadStatus)
+		if err == nil || err.Error() != "invalid rule error key status: 'bad'" {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestDBStorageGetContentForRulesEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		res, err := mockStorage.GetContentForRules(types.ReportRules{
+			HitRules:     nil,
+			SkippedRules: nil,
+			PassedRules:  nil,
+			TotalCount:   0,
+		})
+		helpers.FailOnError(t, err)
+
+		assert.Empty(t, res)
+	})
 }
 
 func TestDBStorageGetContentForRulesDBError(t *testing.T) {
@@ -274,100 +764,98 @@ func TestDBStorageGetContentForRulesDBError(t *testing.T) {
 }
 
 func TestDBStorageGetContentForRulesOK(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.LoadRuleContent(ruleContentExample1)
+		helpers.FailOnError(t, err)
 
-	err := mockStorage.LoadRuleContent(ruleContentExample1)
-	helpers.FailOnError(t, err)
+		res, err := mockStorage.GetContentForRules(types.ReportRules{
+			HitRules: []types.RuleOnReport{
+				{
+					Module:   string(testRuleID),
+					ErrorKey: "ek",
+				},
+			},
+			TotalCount: 1,
+		})
+		helpers.FailOnError(t, err)
 
-	res, err := mockStorage.GetContentForRules(types.ReportRules{
-		HitRules: []types.RuleOnReport{
+		assert.Equal(t, []types.RuleContentResponse{
 			{
-				Module:   string(testRuleID),
-				ErrorKey: "ek",
+				ErrorKey:     "ek",
+				RuleModule:   string(testRuleID),
+				Description:  "description",
+				Generic:      "generic",
+				CreatedAt:    "1970-01-01T00:00:00Z",
+				TotalRisk:    1,
+				RiskOfChange: 0,
 			},
-		},
-		TotalCount: 1,
+		}, res)
 	})
-	helpers.FailOnError(t, err)
-
-	assert.Equal(t, []types.RuleContentResponse{
-		{
-			ErrorKey:     "ek",
-			RuleModule:   string(testRuleID),
-			Description:  "description",
-			Generic:      "generic",
-			CreatedAt:    "1970-01-01T00:00:00Z",
-			TotalRisk:    1,
-			RiskOfChange: 0,
-		},
-	}, res)
 }
 
 func TestDBStorageGetContentForMultipleRulesOK(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+		helpers.FailOnError(t, err)
 
-	err := mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
-	helpers.FailOnError(t, err)
+		res, err := mockStorage.GetContentForRules(types.ReportRules{
+			HitRules: []types.RuleOnReport{
+				{
+					Module:   "test.rule1.report",
+					ErrorKey: "ek1",
+				},
+				{
+					Module:   "test.rule2.report",
+					ErrorKey: "ek2",
+				},
+				{
+					Module:   "test.rule3.report",
+					ErrorKey: "ek3",
+				},
+			},
+			TotalCount: 3,
+		})
+		helpers.FailOnError(t, err)
 
-	res, err := mockStorage.GetContentForRules(types.ReportRules{
-		HitRules: []types.RuleOnReport{
+		assert.Len(t, res, 3)
+
+		// db doesn't and shouldn't guarantee order
+		sort.Slice(res, func(firstIndex, secondIndex int) bool {
+			return res[firstIndex].ErrorKey < res[secondIndex].ErrorKey
+		})
+
+		// TODO: check risk of change when it will be returned correctly
+		// total risk is `(impact + likelihood) / 2`
+		assert.Equal(t, []types.RuleContentResponse{
 			{
-				Module:   "test.rule1.report",
-				ErrorKey: "ek1",
+				ErrorKey:     "ek1",
+				RuleModule:   "test.rule1",
+				Description:  "rule 1 description",
+				Generic:      "rule 1 details",
+				CreatedAt:    "1970-01-01T00:00:00Z",
+				TotalRisk:    3,
+				RiskOfChange: 0,
 			},
 			{
-				Module:   "test.rule2.report",
-				ErrorKey: "ek2",
+				ErrorKey:     "ek2",
+				RuleModule:   "test.rule2",
+				Description:  "rule 2 description",
+				Generic:      "rule 2 details",
+				CreatedAt:    "1970-01-02T00:00:00Z",
+				TotalRisk:    4,
+				RiskOfChange: 0,
 			},
 			{
-				Module:   "test.rule3.report",
-				ErrorKey: "ek3",
+				ErrorKey:     "ek3",
+				RuleModule:   "test.rule3",
+				Description:  "rule 3 description",
+				Generic:      "rule 3 details",
+				CreatedAt:    "1970-01-03T00:00:00Z",
+				TotalRisk:    2,
+				RiskOfChange: 0,
 			},
-		},
-		TotalCount: 3,
-	})
-	helpers.FailOnError(t, err)
-
-	assert.Len(t, res, 3)
-
-	// db doesn't and shouldn't guarantee order
-	sort.Slice(res, func(firstIndex, secondIndex int) bool {
-		return res[firstIndex].ErrorKey < res[secondIndex].ErrorKey
+		}, res)
 	})
-
-	// TODO: check risk of change when it will be returned correctly
-	// total risk is `(impact + likelihood) / 2`
-	assert.Equal(t, []types.RuleContentResponse{
-		{
-			ErrorKey:     "ek1",
-			RuleModule:   "test.rule1",
-			Description:  "rule 1 description",
-			Generic:      "rule 1 details",
-			CreatedAt:    "1970-01-01T00:00:00Z",
-			TotalRisk:    3,
-			RiskOfChange: 0,
-		},
-		{
-			ErrorKey:     "ek2",
-			RuleModule:   "test.rule2",
-			Description:  "rule 2 description",
-			Generic:      "rule 2 details",
-			CreatedAt:    "1970-01-02T00:00:00Z",
-			TotalRisk:    4,
-			RiskOfChange: 0,
-		},
-		{
-			ErrorKey:     "ek3",
-			RuleModule:   "test.rule3",
-			Description:  "rule 3 description",
-			Generic:      "rule 3 details",
-			CreatedAt:    "1970-01-03T00:00:00Z",
-			TotalRisk:    2,
-			RiskOfChange: 0,
-		},
-	}, res)
 }
 
 func TestDBStorageGetContentForRulesScanError(t *testing.T) {
@@ -462,10 +950,10 @@ func TestDBStorageVoteOnRule(t *testing.T) {
 		mustWriteReport3Rules(t, mockStorage)
 
 		helpers.FailOnError(t, mockStorage.VoteOnRule(
-			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, vote,
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, vote,
 		))
 
-		feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, testdata.UserID)
+		feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
 		helpers.FailOnError(t, err)
 
 		assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
@@ -478,6 +966,47 @@ func TestDBStorageVoteOnRule(t *testing.T) {
 	}
 }
 
+// TestDBStorageFeedbackKeepsAddedAt checks that updates of the feedback move
+// only the time of the last update, the time the feedback was added is kept
+func TestDBStorageFeedbackKeepsAddedAt(t *testing.T) {
+	addedAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		now := addedAt
+		defer storage.SetTimeNow(func() time.Time { return now })()
+
+		assertFeedbackTimes := func(expectedUpdatedAt time.Time) {
+			feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+			helpers.FailOnError(t, err)
+			assert.Equal(t, addedAt, feedback.AddedAt)
+			assert.Equal(t, expectedUpdatedAt, feedback.UpdatedAt)
+		}
+
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+		))
+		assertFeedbackTimes(addedAt)
+
+		now = addedAt.Add(time.Hour)
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteDislike,
+		))
+		assertFeedbackTimes(now)
+
+		now = addedAt.Add(2 * time.Hour)
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message",
+		))
+		assertFeedbackTimes(now)
+
+		now = addedAt.Add(3 * time.Hour)
+		helpers.FailOnError(t, mockStorage.ResetVoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID))
+		assertFeedbackTimes(now)
+	})
+}
+
 func TestDBStorageVoteOnRule_NoCluster(t *testing.T) {
 	for _, vote := range []storage.UserVote{
 		storage.UserVoteDislike, storage.UserVoteLike, storage.UserVoteNone,
@@ -485,7 +1014,7 @@ func TestDBStorageVoteOnRule_NoCluster(t *testing.T) {
 		mockStorage := helpers.MustGetMockStorage(t, true)
 
 		err := mockStorage.VoteOnRule(
-			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, vote,
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, vote,
 		)
 		assert.EqualError(t, err, "FOREIGN KEY constraint failed")
 	}
@@ -499,119 +1028,635 @@ func TestDBStorageVoteOnRule_NoRule(t *testing.T) {
 
 		err := mockStorage.WriteReportForCluster(
 			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+			types.UnknownKafkaOffset,
 		)
 		helpers.FailOnError(t, err)
 
 		err = mockStorage.VoteOnRule(
-			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, vote,
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, vote,
 		)
 		assert.EqualError(t, err, "FOREIGN KEY constraint failed")
 	}
 }
 
 func TestDBStorageChangeVote(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
-
-	mustWriteReport3Rules(t, mockStorage)
-
-	helpers.FailOnError(t, mockStorage.VoteOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.UserVoteLike,
-	))
-	// just to be sure that addedAt != to updatedAt
-	time.Sleep(1 * time.Millisecond)
-	helpers.FailOnError(t, mockStorage.VoteOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.UserVoteDislike,
-	))
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
 
-	feedback, err := mockStorage.GetUserFeedbackOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID,
-	)
-	helpers.FailOnError(t, err)
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+		))
+		// just to be sure that addedAt != to updatedAt
+		time.Sleep(1 * time.Millisecond)
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteDislike,
+		))
 
-	assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
-	assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
-	assert.Equal(t, testdata.UserID, feedback.UserID)
-	assert.Equal(t, "", feedback.Message)
-	assert.Equal(t, storage.UserVoteDislike, feedback.UserVote)
-	assert.NotEqual(t, feedback.AddedAt, feedback.UpdatedAt)
-}
+		feedback, err := mockStorage.GetUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID,
+		)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
+		assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
+		assert.Equal(t, testdata.UserID, feedback.UserID)
+		assert.Equal(t, "", feedback.Message)
+		assert.Equal(t, storage.UserVoteDislike, feedback.UserVote)
+		assert.NotEqual(t, feedback.AddedAt, feedback.UpdatedAt)
+	})
+}
+
+func TestDBStorageResetVoteOnRule(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+		))
+		helpers.FailOnError(t, mockStorage.ResetVoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID,
+		))
+
+		// there was no message, so nothing is left from the feedback
+		_, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+
+		likes, dislikes, err := mockStorage.GetVotesForRule(testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, likes)
+		assert.Equal(t, 0, dislikes)
+	})
+}
+
+func TestDBStorageResetVoteOnRuleKeepsMessage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteDislike,
+		))
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "test feedback",
+		))
+		// just to be sure that addedAt != to updatedAt
+		time.Sleep(1 * time.Millisecond)
+		helpers.FailOnError(t, mockStorage.ResetVoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID,
+		))
+
+		feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, "test feedback", feedback.Message)
+		assert.Equal(t, storage.UserVoteNone, feedback.UserVote)
+		assert.NotEqual(t, feedback.AddedAt, feedback.UpdatedAt)
+	})
+}
+
+func TestDBStorageResetVoteOnRuleNoFeedback(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.ResetVoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID,
+		))
+
+		_, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+	})
+}
+
+func TestDBStorageResetVoteOnRuleDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.ResetVoteOnRule(testClusterName, testRuleID, "", testUserID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageResetVoteOnRuleUpdateError(t *testing.T) {
+	const errStr = "update error"
+
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectBegin()
+	expects.ExpectExecWithArgs(`
+		DELETE FROM cluster_rule_user_feedback
+		WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4 AND NOT EXISTS (
+			SELECT 1 FROM cluster_rule_user_message
+			WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4
+		)`,
+		testClusterName, testRuleID, "", testUserID,
+	).WillReturnResult(driver.ResultNoRows)
+	expects.ExpectExecWithArgs(`
+		UPDATE cluster_rule_user_feedback SET user_vote = $5, updated_at = $6
+		WHERE cluster_id = $1 AND rule_id = $2 AND error_key = $3 AND user_id = $4`,
+		testClusterName, testRuleID, "", testUserID, storage.UserVoteNone, helpers.RecentTime(),
+	).WillReturnError(fmt.Errorf(errStr))
+	expects.ExpectRollback()
+
+	err := mockStorage.ResetVoteOnRule(testClusterName, testRuleID, "", testUserID)
+	assert.EqualError(t, err, errStr)
+}
 
 func TestDBStorageTextFeedback(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "test feedback",
+		))
+
+		feedback, err := mockStorage.GetUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID,
+		)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
+		assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
+		assert.Equal(t, testdata.UserID, feedback.UserID)
+		assert.Equal(t, "test feedback", feedback.Message)
+		assert.Equal(t, storage.UserVoteNone, feedback.UserVote)
+	})
+}
+
+func TestDBStorageFeedbackChangeMessage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message1",
+		))
+		// just to be sure that addedAt != to updatedAt
+		time.Sleep(1 * time.Millisecond)
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message2",
+		))
+
+		feedback, err := mockStorage.GetUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID,
+		)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
+		assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
+		assert.Equal(t, testdata.UserID, feedback.UserID)
+		assert.Equal(t, "message2", feedback.Message)
+		assert.Equal(t, storage.UserVoteNone, feedback.UserVote)
+		assert.NotEqual(t, feedback.AddedAt, feedback.UpdatedAt)
+	})
+}
+
+// TestDBStorageFeedbackMessageLength checks that messages are limited by number of characters, not bytes
+func TestDBStorageFeedbackMessageLength(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		for _, character := range []string{"a", "€", "🙂"} {
+			message := strings.Repeat(character, storage.DefaultMaxFeedbackMessageLength)
+
+			helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+				testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, message,
+			))
+
+			feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+			helpers.FailOnError(t, err)
+			assert.Equal(t, message, feedback.Message)
+
+			err = mockStorage.AddOrUpdateFeedbackOnRule(
+				testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, message+character,
+			)
+			assert.EqualError(t, err, "Invalid value of 'message': at most 2048 characters expected, got 2049")
+			if _, ok := err.(*storage.ValidationError); !ok {
+				t.Fatalf("expected ValidationError, got %T, %+v", err, err)
+			}
+
+			// too long message is rejected, not truncated
+			feedback, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+			helpers.FailOnError(t, err)
+			assert.Equal(t, message, feedback.Message)
+		}
+	})
+}
+
+func TestDBStorageFeedbackMessageLengthConfigured(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		storage.SetMaxFeedbackMessageLength(mockStorage, 5)
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "12345",
+		))
+
+		err := mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "123456")
+		assert.EqualError(t, err, "Invalid value of 'message': at most 5 characters expected, got 6")
+
+		// votes are not affected by the limit
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+		))
+	})
+}
+
+func TestDBStorageFeedbackErrorItemNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		_, err := mockStorage.GetUserFeedbackOnRule(testClusterName, testRuleID, "", testUserID)
+		if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+	})
+}
+
+func TestDBStorageFeedbackErrorDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	helpers.MustCloseStorage(t, mockStorage)
 
-	mustWriteReport3Rules(t, mockStorage)
+	_, err := mockStorage.GetUserFeedbackOnRule(testClusterName, testRuleID, "", testUserID)
+	if err == nil || !strings.Contains(err.Error(), "database is closed") {
+		t.Fatalf("expected sql database is closed error, got %T, %+v", err, err)
+	}
+}
 
-	helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, "test feedback",
-	))
+func TestDBStorageDeleteUserFeedbackOnRule(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+		))
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "test feedback",
+		))
+		// feedback of other users has to stay untouched
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", "2", storage.UserVoteDislike,
+		))
+
+		helpers.FailOnError(t, mockStorage.DeleteUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID,
+		))
+
+		_, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+
+		feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", "2")
+		helpers.FailOnError(t, err)
+		assert.Equal(t, storage.UserVoteDislike, feedback.UserVote)
+	})
+}
+
+func TestDBStorageDeleteUserFeedbackOnRuleNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		err := mockStorage.DeleteUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		assertItemNotFound(t, err, storage.ItemKindFeedback, testdata.ClusterName, testdata.Rule1ID, testdata.UserID)
+	})
+}
+
+func TestDBStorageDeleteUserFeedbackOnRuleDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
 
-	feedback, err := mockStorage.GetUserFeedbackOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID,
+	err := mockStorage.DeleteUserFeedbackOnRule(testClusterName, testRuleID, "", testUserID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageListFeedbacksForCluster(t *testing.T) {
+	const otherClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, otherClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		))
+
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", "1", "message from user 1",
+		))
+		time.Sleep(1 * time.Millisecond)
+		// vote without any message has to be listed too
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule2ID, "", "2", storage.UserVoteDislike,
+		))
+		time.Sleep(1 * time.Millisecond)
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule2ID, "", "1", "message on rule 2",
+		))
+		// feedback on other cluster is not listed
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			otherClusterName, testdata.Rule1ID, "", "1", "other cluster",
+		))
+
+		feedbacks, err := mockStorage.ListFeedbacksForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		assert.Len(t, feedbacks, 3)
+
+		type feedbackKey struct {
+			ruleID  types.RuleID
+			userID  types.UserID
+			message string
+			vote    storage.UserVote
+		}
+
+		var keys []feedbackKey
+		for _, feedback := range feedbacks {
+			assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
+			keys = append(keys, feedbackKey{feedback.RuleID, feedback.UserID, feedback.Message, feedback.UserVote})
+		}
+
+		// the most recently updated feedback goes first
+		assert.Equal(t, []feedbackKey{
+			{testdata.Rule2ID, "1", "message on rule 2", storage.UserVoteNone},
+			{testdata.Rule2ID, "2", "", storage.UserVoteDislike},
+			{testdata.Rule1ID, "1", "message from user 1", storage.UserVoteNone},
+		}, keys)
+	})
+}
+
+func TestDBStorageListFeedbacksForClusterEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		feedbacks, err := mockStorage.ListFeedbacksForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []storage.UserFeedbackOnRule{}, feedbacks)
+	})
+}
+
+func TestDBStorageListFeedbacksForClusterLogError(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.Logger = zerolog.New(buf)
+
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	now := time.Now()
+	expects.ExpectQuery("SELECT .* FROM cluster_rule_user_feedback").WillReturnRows(
+		sqlmock.NewRows(
+			[]string{"cluster_id", "rule_id", "error_key", "user_id", "message", "user_vote", "added_at", "updated_at"},
+		).
+			AddRow(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, nil, 1, now, now).
+			AddRow(testdata.ClusterName, testdata.Rule2ID, "", testdata.UserID, "message", 0, now, now),
 	)
+
+	feedbacks, err := mockStorage.ListFeedbacksForCluster(testdata.ClusterName)
 	helpers.FailOnError(t, err)
 
-	assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
-	assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
-	assert.Equal(t, testdata.UserID, feedback.UserID)
-	assert.Equal(t, "test feedback", feedback.Message)
-	assert.Equal(t, storage.UserVoteNone, feedback.UserVote)
+	assert.Len(t, feedbacks, 1)
+	assert.Equal(t, testdata.Rule2ID, feedbacks[0].RuleID)
+	assert.Contains(t, buf.String(), "sql: Scan error")
 }
 
-func TestDBStorageFeedbackChangeMessage(t *testing.T) {
+func TestDBStorageListFeedbacksForClusterDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	helpers.MustCloseStorage(t, mockStorage)
 
-	mustWriteReport3Rules(t, mockStorage)
+	_, err := mockStorage.ListFeedbacksForCluster(testdata.ClusterName)
+	assert.EqualError(t, err, "sql: database is closed")
+}
 
-	helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, "message1",
-	))
-	// just to be sure that addedAt != to updatedAt
-	time.Sleep(1 * time.Millisecond)
-	helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID, "message2",
-	))
+func TestDBStorageGetVotesForRule(t *testing.T) {
+	const (
+		otherOrgID   = types.OrgID(2)
+		otherCluster = types.ClusterName("2b8c4bb6-1d5d-47d1-8f0e-4d6b0b6ef8e5")
+	)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		err := mockStorage.WriteReportForCluster(
+			otherOrgID, otherCluster, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		for _, feedback := range []struct {
+			cluster types.ClusterName
+			ruleID  types.RuleID
+			userID  types.UserID
+			vote    storage.UserVote
+		}{
+			{testdata.ClusterName, testdata.Rule1ID, "1", storage.UserVoteLike},
+			{testdata.ClusterName, testdata.Rule1ID, "2", storage.UserVoteLike},
+			{testdata.ClusterName, testdata.Rule1ID, "3", storage.UserVoteDislike},
+			{testdata.ClusterName, testdata.Rule1ID, "4", storage.UserVoteNone},
+			{otherCluster, testdata.Rule1ID, "1", storage.UserVoteDislike},
+			{otherCluster, testdata.Rule1ID, "5", storage.UserVoteDislike},
+			// votes for other rules are not counted
+			{testdata.ClusterName, testdata.Rule2ID, "1", storage.UserVoteDislike},
+			{otherCluster, testdata.Rule2ID, "1", storage.UserVoteLike},
+		} {
+			helpers.FailOnError(t, mockStorage.VoteOnRule(feedback.cluster, feedback.ruleID, "", feedback.userID, feedback.vote))
+		}
+
+		// text feedback without any vote is not counted either
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", "6", "message"))
+
+		likes, dislikes, err := mockStorage.GetVotesForRule(testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 2, likes)
+		assert.Equal(t, 3, dislikes)
+
+		likes, dislikes, err = mockStorage.GetVotesForRuleByOrg(testdata.OrgID, testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 2, likes)
+		assert.Equal(t, 1, dislikes)
+
+		likes, dislikes, err = mockStorage.GetVotesForRuleByOrg(otherOrgID, testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, likes)
+		assert.Equal(t, 2, dislikes)
+
+		likes, dislikes, err = mockStorage.GetVotesForRule(testdata.Rule3ID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, likes)
+		assert.Equal(t, 0, dislikes)
+	})
+}
+
+func TestDBStorageGetVotesForRuleDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, _, err := mockStorage.GetVotesForRule(testRuleID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, _, err = mockStorage.GetVotesForRuleByOrg(testdata.OrgID, testRuleID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+// TestDBStorageGetRuleFeedbackStatsForOrg checks that feedback on clusters of other organizations
+// is not counted and that the most disliked rules go first
+func TestDBStorageGetRuleFeedbackStatsForOrg(t *testing.T) {
+	const otherCluster = types.ClusterName("2b8c4bb6-1d5d-47d1-8f0e-4d6b0b6ef8e5")
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+		writeReportForCluster(t, mockStorage, otherOrgID, otherCluster, testdata.Report3Rules)
+
+		for _, feedback := range []struct {
+			cluster types.ClusterName
+			ruleID  types.RuleID
+			userID  types.UserID
+			vote    storage.UserVote
+		}{
+			{testdata.ClusterName, testdata.Rule1ID, "1", storage.UserVoteLike},
+			{testdata.ClusterName, testdata.Rule1ID, "2", storage.UserVoteLike},
+			{testdata.ClusterName, testdata.Rule1ID, "3", storage.UserVoteDislike},
+			{testdata.ClusterName, testdata.Rule2ID, "1", storage.UserVoteDislike},
+			{testdata.ClusterName, testdata.Rule2ID, "2", storage.UserVoteDislike},
+			{otherCluster, testdata.Rule1ID, "1", storage.UserVoteDislike},
+			{otherCluster, testdata.Rule1ID, "5", storage.UserVoteDislike},
+			{otherCluster, testdata.Rule3ID, "1", storage.UserVoteLike},
+		} {
+			helpers.FailOnError(t, mockStorage.VoteOnRule(feedback.cluster, feedback.ruleID, "", feedback.userID, feedback.vote))
+		}
+
+		for _, message := range []struct {
+			cluster types.ClusterName
+			ruleID  types.RuleID
+			userID  types.UserID
+		}{
+			{testdata.ClusterName, testdata.Rule1ID, "3"},
+			// user without any vote is counted as commenting one
+			{testdata.ClusterName, testdata.Rule1ID, "6"},
+			{testdata.ClusterName, testdata.Rule2ID, "1"},
+			{otherCluster, testdata.Rule1ID, "5"},
+		} {
+			helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+				message.cluster, message.ruleID, "", message.userID, "message",
+			))
+		}
+
+		stats, err := mockStorage.GetRuleFeedbackStatsForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []storage.RuleFeedbackStats{
+			{RuleID: testdata.Rule2ID, Likes: 0, Dislikes: 2, CommentingUsers: 1},
+			{RuleID: testdata.Rule1ID, Likes: 2, Dislikes: 1, CommentingUsers: 2},
+		}, stats)
+
+		stats, err = mockStorage.GetRuleFeedbackStatsForOrg(otherOrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []storage.RuleFeedbackStats{
+			{RuleID: testdata.Rule1ID, Likes: 0, Dislikes: 2, CommentingUsers: 1},
+			{RuleID: testdata.Rule3ID, Likes: 1, Dislikes: 0, CommentingUsers: 0},
+		}, stats)
+
+		stats, err = mockStorage.GetRuleFeedbackStatsForOrg(types.OrgID(3))
+		helpers.FailOnError(t, err)
+		assert.Empty(t, stats)
+	})
+}
+
+func TestDBStorageGetRuleFeedbackStatsForOrgDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.GetRuleFeedbackStatsForOrg(testdata.OrgID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageGetUserFeedbackOnRules(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule2ID, "", testdata.UserID, storage.UserVoteDislike,
+		))
+		// votes of other users are not returned
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", "2", storage.UserVoteLike,
+		))
+
+		votes, err := mockStorage.GetUserFeedbackOnRules(
+			testdata.ClusterName,
+			[]types.RuleID{testdata.Rule1ID, testdata.Rule2ID, testdata.Rule3ID},
+			testdata.UserID,
+		)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, map[types.RuleID]storage.UserVote{
+			testdata.Rule1ID: storage.UserVoteNone,
+			testdata.Rule2ID: storage.UserVoteDislike,
+			testdata.Rule3ID: storage.UserVoteNone,
+		}, votes)
+	})
+}
+
+func TestDBStorageGetUserFeedbackOnRulesFakePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
 
-	feedback, err := mockStorage.GetUserFeedbackOnRule(
-		testdata.ClusterName, testdata.Rule1ID, testdata.UserID,
+	expects.ExpectQueryWithArgs(`
+		SELECT rule_id, user_vote FROM cluster_rule_user_feedback
+		WHERE cluster_id = $1 AND user_id = $2 AND error_key = '' AND rule_id IN ($3, $4, $5)`,
+		testdata.ClusterName, testdata.UserID, testdata.Rule1ID, testdata.Rule2ID, testdata.Rule3ID,
+	).WillReturnRows(
+		sqlmock.NewRows([]string{"rule_id", "user_vote"}).AddRow(string(testdata.Rule2ID), storage.UserVoteLike),
+	)
+
+	votes, err := mockStorage.GetUserFeedbackOnRules(
+		testdata.ClusterName,
+		[]types.RuleID{testdata.Rule1ID, testdata.Rule2ID, testdata.Rule3ID},
+		testdata.UserID,
 	)
 	helpers.FailOnError(t, err)
 
-	assert.Equal(t, testdata.ClusterName, feedback.ClusterID)
-	assert.Equal(t, testdata.Rule1ID, feedback.RuleID)
-	assert.Equal(t, testdata.UserID, feedback.UserID)
-	assert.Equal(t, "message2", feedback.Message)
-	assert.Equal(t, storage.UserVoteNone, feedback.UserVote)
-	assert.NotEqual(t, feedback.AddedAt, feedback.UpdatedAt)
+	assert.Equal(t, map[types.RuleID]storage.UserVote{
+		testdata.Rule1ID: storage.UserVoteNone,
+		testdata.Rule2ID: storage.UserVoteLike,
+		testdata.Rule3ID: storage.UserVoteNone,
+	}, votes)
 }
 
-func TestDBStorageFeedbackErrorItemNotFound(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+// TestDBStorageGetUserFeedbackOnRulesNoRules checks that DB is not queried at all for empty list of rules
+func TestDBStorageGetUserFeedbackOnRulesNoRules(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
 
-	_, err := mockStorage.GetUserFeedbackOnRule(testClusterName, testRuleID, testUserID)
-	if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
-		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
-	}
+	votes, err := mockStorage.GetUserFeedbackOnRules(testdata.ClusterName, []types.RuleID{}, testdata.UserID)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, votes)
+	assert.NotNil(t, votes)
 }
 
-func TestDBStorageFeedbackErrorDBError(t *testing.T) {
+func TestDBStorageGetUserFeedbackOnRulesDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	helpers.MustCloseStorage(t, mockStorage)
 
-	_, err := mockStorage.GetUserFeedbackOnRule(testClusterName, testRuleID, testUserID)
-	if err == nil || !strings.Contains(err.Error(), "database is closed") {
-		t.Fatalf("expected sql database is closed error, got %T, %+v", err, err)
-	}
+	_, err := mockStorage.GetUserFeedbackOnRules(testdata.ClusterName, []types.RuleID{testRuleID}, testUserID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageVoteOnRuleFakePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectUpsertFeedback(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike, "", true, false,
+	)
+	expects.ExpectUpsertFeedback(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteNone, "message", false, true,
+	)
+
+	err := mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message")
+	helpers.FailOnError(t, err)
 }
 
 func TestDBStorageVoteOnRuleDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	helpers.MustCloseStorage(t, mockStorage)
 
-	err := mockStorage.VoteOnRule(testClusterName, testRuleID, testUserID, storage.UserVoteNone)
+	err := mockStorage.VoteOnRule(testClusterName, testRuleID, "", testUserID, storage.UserVoteNone)
 	assert.EqualError(t, err, "sql: database is closed")
 }
 
@@ -625,7 +1670,7 @@ func TestDBStorageVoteOnRuleUnsupportedDriverError(t *testing.T) {
 	err = mockStorage.Init()
 	helpers.FailOnError(t, err)
 
-	err = mockStorage.VoteOnRule(testClusterName, testRuleID, testUserID, storage.UserVoteNone)
+	err = mockStorage.VoteOnRule(testClusterName, testRuleID, "", testUserID, storage.UserVoteNone)
 	assert.EqualError(t, err, "DB driver -1 is not supported")
 }
 
@@ -639,44 +1684,874 @@ func TestDBStorageVoteOnRuleDBExecError(t *testing.T) {
 		CREATE TABLE cluster_rule_user_feedback (
 			cluster_id INTEGER NOT NULL CHECK(typeof(cluster_id) = 'integer'),
 			rule_id INTEGER NOT NULL,
+			error_key INTEGER NOT NULL,
 			user_id INTEGER NOT NULL,
-			message INTEGER NOT NULL,
 			user_vote INTEGER NOT NULL,
 			added_at INTEGER NOT NULL,
 			updated_at INTEGER NOT NULL,
 
-			PRIMARY KEY(cluster_id, rule_id, user_id)
+			PRIMARY KEY(cluster_id, rule_id, error_key, user_id)
 		)
 	`)
 	helpers.FailOnError(t, err)
 
-	err = mockStorage.VoteOnRule("non int", testRuleID, testUserID, storage.UserVoteNone)
+	err = mockStorage.VoteOnRule("non int", testRuleID, "", testUserID, storage.UserVoteNone)
 	assert.EqualError(t, err, "CHECK constraint failed: cluster_rule_user_feedback")
 }
 
-func TestDBStorageVoteOnRuleDBCloseError(t *testing.T) {
-	// TODO: seems to be not coverable because of the bug in golang
-	// related issues:
-	// https://github.com/DATA-DOG/go-sqlmock/issues/185
-	// https://github.com/golang/go/issues/37973
-
-	const errStr = "close error"
-
-	buf := new(bytes.Buffer)
-	log.Logger = zerolog.New(buf)
+func TestDBStorageVoteOnRuleCommitError(t *testing.T) {
+	const errStr = "commit error"
 
 	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
 	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
 
-	expects.ExpectPrepare("INSERT").
-		WillBeClosed().
-		WillReturnCloseError(fmt.Errorf(errStr)).
-		ExpectExec().
-		WillReturnResult(driver.ResultNoRows)
+	expects.ExpectPrepare("INSERT INTO cluster_rule_user_feedback")
+	expects.ExpectBegin()
+	expects.ExpectExec("INSERT INTO cluster_rule_user_feedback").WillReturnResult(driver.ResultNoRows)
+	expects.ExpectCommit().WillReturnError(fmt.Errorf(errStr))
 
-	err := mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, testUserID, storage.UserVoteNone)
-	helpers.FailOnError(t, err)
+	err := mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testUserID, storage.UserVoteNone)
+	assert.EqualError(t, err, errStr)
+}
 
-	// TODO: uncomment when issues upthere resolved
-	//assert.Contains(t, buf.String(), errStr)
+func TestDBStorageFeedbackMessageWriteError(t *testing.T) {
+	const errStr = "message error"
+
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectPrepare("INSERT INTO cluster_rule_user_feedback")
+	expects.ExpectBegin()
+	expects.ExpectExec("INSERT INTO cluster_rule_user_feedback").WillReturnResult(driver.ResultNoRows)
+	expects.ExpectExec("INSERT INTO cluster_rule_user_message").WillReturnError(fmt.Errorf(errStr))
+	expects.ExpectRollback()
+
+	err := mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testUserID, "message")
+	assert.EqualError(t, err, errStr)
+}
+
+// TestDBStorageVoteKeepsMessage checks that changing the vote doesn't touch the message
+// and that the empty message removes the stored one
+func TestDBStorageVoteKeepsMessage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	mustWriteReport3Rules(t, mockStorage)
+
+	helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "test feedback",
+	))
+	helpers.FailOnError(t, mockStorage.VoteOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+	))
+
+	feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "test feedback", feedback.Message)
+	assert.Equal(t, storage.UserVoteLike, feedback.UserVote)
+
+	helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "",
+	))
+
+	var messages int
+	err = storage.GetConnection(mockStorage.(*storage.DBStorage)).
+		QueryRow("SELECT COUNT(*) FROM cluster_rule_user_message").Scan(&messages)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, messages)
+
+	feedback, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "", feedback.Message)
+	assert.Equal(t, storage.UserVoteLike, feedback.UserVote)
+}
+
+// TestDBStorageFeedbackOnErrorKey checks that the feedback on the error key of the rule
+// coexists with the feedback on the whole rule left by the same user in the same cluster
+func TestDBStorageFeedbackOnErrorKey(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+		))
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "rule message",
+		))
+		helpers.FailOnError(t, mockStorage.VoteOnRule(
+			testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey1, testdata.UserID, storage.UserVoteDislike,
+		))
+		helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey1, testdata.UserID, "error key message",
+		))
+
+		feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.ErrorKey(""), feedback.ErrorKey)
+		assert.Equal(t, storage.UserVoteLike, feedback.UserVote)
+		assert.Equal(t, "rule message", feedback.Message)
+
+		feedback, err = mockStorage.GetUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey1, testdata.UserID,
+		)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.ErrorKey(testdata.ErrorKey1), feedback.ErrorKey)
+		assert.Equal(t, storage.UserVoteDislike, feedback.UserVote)
+		assert.Equal(t, "error key message", feedback.Message)
+
+		_, err = mockStorage.GetUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey2, testdata.UserID,
+		)
+		assertItemNotFound(
+			t, err, storage.ItemKindFeedback,
+			testdata.ClusterName, testdata.Rule1ID, types.ErrorKey(testdata.ErrorKey2), testdata.UserID,
+		)
+
+		feedbacks, err := mockStorage.ListFeedbacksForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Len(t, feedbacks, 2)
+
+		// votes on the rules are the ones on the whole rules
+		votes, err := mockStorage.GetUserFeedbackOnRules(
+			testdata.ClusterName, []types.RuleID{testdata.Rule1ID}, testdata.UserID,
+		)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, map[types.RuleID]storage.UserVote{testdata.Rule1ID: storage.UserVoteLike}, votes)
+
+		// deleting the feedback on the error key keeps the feedback on the whole rule
+		helpers.FailOnError(t, mockStorage.DeleteUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey1, testdata.UserID,
+		))
+
+		feedback, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, "rule message", feedback.Message)
+
+		_, err = mockStorage.GetUserFeedbackOnRule(
+			testdata.ClusterName, testdata.Rule1ID, testdata.ErrorKey1, testdata.UserID,
+		)
+		assertItemNotFound(
+			t, err, storage.ItemKindFeedback,
+			testdata.ClusterName, testdata.Rule1ID, types.ErrorKey(testdata.ErrorKey1), testdata.UserID,
+		)
+	})
+}
+
+// disableForeignKeys lets the SQL storage keep feedback on clusters without reports,
+// like PostgreSQL does when the report table is partitioned
+func disableForeignKeys(t *testing.T, mockStorage storage.Storage) {
+	dbStorage, ok := mockStorage.(*storage.DBStorage)
+	if !ok {
+		return
+	}
+
+	connection := storage.GetConnection(dbStorage)
+	// the pragma is set per connection, the in-memory database has just one
+	connection.SetMaxOpenConns(1)
+	_, err := connection.Exec("PRAGMA foreign_keys = OFF")
+	helpers.FailOnError(t, err)
+}
+
+// TestDBStorageCleanupOrphanedFeedback checks that only feedback on clusters without any report
+// is deleted, feedback on clusters with live and soft-deleted reports is kept
+func TestDBStorageCleanupOrphanedFeedback(t *testing.T) {
+	const (
+		softDeletedClusterName = types.ClusterName("5d5892d3-1f74-4ccf-91af-548dfc9767aa")
+		orphanedClusterName    = types.ClusterName("9f6bb9e6-7b5e-4a4f-9d1c-2a0b5f7e3c11")
+	)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		disableForeignKeys(t, mockStorage)
+		mustWriteReport3Rules(t, mockStorage)
+		writeReportForCluster(t, mockStorage, testdata.OrgID, softDeletedClusterName, testdata.Report3Rules)
+
+		for _, clusterName := range []types.ClusterName{testdata.ClusterName, softDeletedClusterName, orphanedClusterName} {
+			helpers.FailOnError(t, mockStorage.VoteOnRule(
+				clusterName, testdata.Rule1ID, "", testdata.UserID, storage.UserVoteLike,
+			))
+			helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+				clusterName, testdata.Rule2ID, testdata.ErrorKey1, "2", "message",
+			))
+		}
+
+		_, err := mockStorage.SoftDeleteReportsForCluster(softDeletedClusterName)
+		helpers.FailOnError(t, err)
+
+		deleted, err := mockStorage.CleanupOrphanedFeedback()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 2, deleted)
+
+		// nothing is left to delete
+		deleted, err = mockStorage.CleanupOrphanedFeedback()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		_, err = mockStorage.GetUserFeedbackOnRule(orphanedClusterName, testdata.Rule1ID, "", testdata.UserID)
+		assertItemNotFound(t, err, storage.ItemKindFeedback, orphanedClusterName, testdata.Rule1ID, testdata.UserID)
+
+		helpers.FailOnError(t, mockStorage.RestoreCluster(softDeletedClusterName))
+
+		for _, clusterName := range []types.ClusterName{testdata.ClusterName, softDeletedClusterName} {
+			feedbacks, err := mockStorage.ListFeedbacksForCluster(clusterName)
+			helpers.FailOnError(t, err)
+			assert.Len(t, feedbacks, 2, clusterName)
+		}
+	})
+}
+
+func TestDBStorageCleanupOrphanedFeedbackFakePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectExecWithArgs(`
+		DELETE FROM cluster_rule_user_feedback
+		WHERE NOT EXISTS (SELECT 1 FROM report WHERE report.cluster = cluster_rule_user_feedback.cluster_id)`,
+	).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	deleted, err := mockStorage.CleanupOrphanedFeedback()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 3, deleted)
+}
+
+func TestDBStorageCleanupOrphanedFeedbackDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.CleanupOrphanedFeedback()
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+// BenchmarkVoteOnRuleWithLongMessage measures changing of the vote on rule with the longest allowed
+// message and reports the size of the row rewritten by each vote
+func BenchmarkVoteOnRuleWithLongMessage(b *testing.B) {
+	mockStorage, err := helpers.GetMockStorage(true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		if err := mockStorage.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+
+	err = mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, time.Now(), types.UnknownKafkaOffset,
+	)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if err := mockStorage.LoadRuleContent(testdata.RuleContent3Rules); err != nil {
+		b.Fatal(err)
+	}
+
+	message := strings.Repeat("m", storage.DefaultMaxFeedbackMessageLength)
+	err = mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, message)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	votes := []storage.UserVote{storage.UserVoteLike, storage.UserVoteDislike}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		err := mockStorage.VoteOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, votes[n%len(votes)])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	// all columns of the vote row are rewritten by the update
+	var rowSize int64
+	err = storage.GetConnection(mockStorage.(*storage.DBStorage)).QueryRow(`
+		SELECT LENGTH(cluster_id) + LENGTH(rule_id) + LENGTH(error_key) + LENGTH(user_id) + LENGTH(user_vote)
+			+ LENGTH(added_at) + LENGTH(updated_at)
+		FROM cluster_rule_user_feedback`,
+	).Scan(&rowSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(rowSize), "rewritten-bytes/vote")
+}
+
+func TestDBStorageAckRuleForOrg(t *testing.T) {
+	const otherOrgID = types.OrgID(2)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID, "not relevant"))
+		// acks of other organizations have to stay untouched
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(otherOrgID, testdata.Rule2ID, testdata.UserID, "other"))
+
+		acked, err := mockStorage.IsRuleAckedForOrg(testdata.OrgID, testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.True(t, acked)
+
+		acked, err = mockStorage.IsRuleAckedForOrg(testdata.OrgID, testdata.Rule2ID)
+		helpers.FailOnError(t, err)
+		assert.False(t, acked)
+
+		acks, err := mockStorage.ListAcksForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Len(t, acks, 1)
+		assert.Equal(t, testdata.OrgID, acks[0].OrgID)
+		assert.Equal(t, testdata.Rule1ID, acks[0].RuleID)
+		assert.Equal(t, testdata.UserID, acks[0].UserID)
+		assert.Equal(t, "not relevant", acks[0].Justification)
+		assert.Equal(t, acks[0].CreatedAt, acks[0].UpdatedAt)
+	})
+}
+
+func TestDBStorageAckRuleForOrgReAck(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID, "first"))
+
+		acks, err := mockStorage.ListAcksForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Len(t, acks, 1)
+		createdAt := acks[0].CreatedAt
+
+		time.Sleep(10 * time.Millisecond)
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, "2", "second"))
+
+		acks, err = mockStorage.ListAcksForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Len(t, acks, 1)
+		assert.Equal(t, types.UserID("2"), acks[0].UserID)
+		assert.Equal(t, "second", acks[0].Justification)
+		assert.Equal(t, createdAt, acks[0].CreatedAt)
+		assert.True(t, acks[0].UpdatedAt.After(createdAt))
+	})
+}
+
+func TestDBStorageListAcksForOrgEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		acks, err := mockStorage.ListAcksForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, acks)
+	})
+}
+
+func TestDBStorageDeleteAckForOrg(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID, "ack"))
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID, "ack"))
+
+		helpers.FailOnError(t, mockStorage.DeleteAckForOrg(testdata.OrgID, testdata.Rule1ID))
+
+		acked, err := mockStorage.IsRuleAckedForOrg(testdata.OrgID, testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.False(t, acked)
+
+		acks, err := mockStorage.ListAcksForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Len(t, acks, 1)
+		assert.Equal(t, testdata.Rule2ID, acks[0].RuleID)
+	})
+}
+
+func TestDBStorageDeleteAckForOrgNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.DeleteAckForOrg(testdata.OrgID, testdata.Rule1ID)
+		assertItemNotFound(t, err, storage.ItemKindToggle, testdata.OrgID, testdata.Rule1ID)
+	})
+}
+
+func TestDBStorageAcksDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.AckRuleForOrg(testdata.OrgID, testRuleID, testUserID, "ack")
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, err = mockStorage.ListAcksForOrg(testdata.OrgID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, err = mockStorage.IsRuleAckedForOrg(testdata.OrgID, testRuleID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	err = mockStorage.DeleteAckForOrg(testdata.OrgID, testRuleID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageAckRuleForOrgUnsupportedDriverError(t *testing.T) {
+	connection, err := sql.Open("sqlite3", ":memory:")
+	helpers.FailOnError(t, err)
+
+	mockStorage := storage.NewFromConnection(connection, -1)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err = mockStorage.AckRuleForOrg(testdata.OrgID, testRuleID, testUserID, "ack")
+	assert.EqualError(t, err, "acking rules with DB -1 is not supported")
+}
+
+func TestDBStorageDisableRuleForOrg(t *testing.T) {
+	const otherOrgID = types.OrgID(2)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID))
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+		// disabling the rule again is not an error
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, "2"))
+		// rules disabled by other organizations are not returned
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(otherOrgID, testdata.Rule3ID, testdata.UserID))
+
+		ruleIDs, err := mockStorage.ListOrgDisabledRules(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleID{testdata.Rule1ID, testdata.Rule2ID}, ruleIDs)
+	})
+}
+
+func TestDBStorageEnableRuleForOrg(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID))
+
+		helpers.FailOnError(t, mockStorage.EnableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+		// enabling the rule which is not disabled does nothing
+		helpers.FailOnError(t, mockStorage.EnableRuleForOrg(testdata.OrgID, testdata.Rule3ID, testdata.UserID))
+
+		ruleIDs, err := mockStorage.ListOrgDisabledRules(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleID{testdata.Rule2ID}, ruleIDs)
+	})
+}
+
+func TestDBStorageListOrgDisabledRulesEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		ruleIDs, err := mockStorage.ListOrgDisabledRules(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, ruleIDs)
+	})
+}
+
+func TestDBStorageOrgDisabledRulesDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.DisableRuleForOrg(testdata.OrgID, testRuleID, testUserID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	err = mockStorage.EnableRuleForOrg(testdata.OrgID, testRuleID, testUserID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, err = mockStorage.ListOrgDisabledRules(testdata.OrgID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, err = mockStorage.GetSilencingStatsForOrg(testdata.OrgID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageGetSilencingStatsForOrg(t *testing.T) {
+	const otherOrgID = types.OrgID(2)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID))
+		// rule disabled and acked at the same time is silenced only once
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID, "ack"))
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule3ID, testdata.UserID, "ack"))
+		// silenced rules of other organizations are not counted
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(otherOrgID, testdata.Rule3ID, testdata.UserID))
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(otherOrgID, testdata.Rule1ID, testdata.UserID, "ack"))
+
+		stats, err := mockStorage.GetSilencingStatsForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, storage.SilencingStats{DisabledRules: 2, AckedRules: 2, SilencedRules: 3}, stats)
+	})
+}
+
+// TestDBStorageGetSilencingStatsForOrgWithToggles checks that rules toggled for clusters of the organization
+// are counted together with rules disabled for the whole organization
+func TestDBStorageGetSilencingStatsForOrgWithToggles(t *testing.T) {
+	clusters := []types.ClusterName{
+		"52ab955f-b769-444d-8170-4b676c5d3c85",
+		"741b1a0c-4f39-4a81-9a5e-6d1c1c3f4b4a",
+		"8f2e3b60-5d5c-4b1c-9e58-2f8c46c1a7e3",
+		"a1c4a3f6-3e54-4a8b-8f6c-1a3f6b0d2e9c",
+	}
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		for _, clusterName := range clusters {
+			writeReportForCluster(t, mockStorage, testdata.OrgID, clusterName, testClusterEmptyReport)
+		}
+		writeReportForCluster(t, mockStorage, otherOrgID, otherOrgClusterName, testClusterEmptyReport)
+
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+
+		for _, toggle := range []struct {
+			clusterName types.ClusterName
+			ruleID      types.RuleID
+			toggle      storage.RuleToggle
+		}{
+			// the rule disabled for the organization is enabled again for the cluster
+			{clusters[1], testdata.Rule1ID, storage.RuleToggleEnable},
+			// the rule is enabled, but other rule is disabled for the cluster
+			{clusters[2], testdata.Rule1ID, storage.RuleToggleEnable},
+			{clusters[2], testdata.Rule2ID, storage.RuleToggleDisable},
+			// the same rule disabled for more clusters is counted once
+			{clusters[3], testdata.Rule2ID, storage.RuleToggleDisable},
+			// toggles of other organizations are not counted
+			{otherOrgClusterName, testdata.Rule3ID, storage.RuleToggleDisable},
+		} {
+			helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+				toggle.clusterName, toggle.ruleID, testdata.UserID, toggle.toggle, "",
+			))
+		}
+
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID, "ack"))
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule3ID, testdata.UserID, "ack"))
+
+		stats, err := mockStorage.GetSilencingStatsForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, storage.SilencingStats{
+			DisabledRules:             2,
+			ClustersWithDisabledRules: 3,
+			AckedRules:                2,
+			SilencedRules:             3,
+		}, stats)
+	})
+}
+
+func TestDBStorageGetSilencingStatsForOrgEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		stats, err := mockStorage.GetSilencingStatsForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, storage.SilencingStats{}, stats)
+	})
+}
+
+// TestDBStorageListDisabledRulesForOrg checks that toggles of clusters of the organization are aggregated
+// by rules together with rules disabled for the whole organization and acked by it
+func TestDBStorageListDisabledRulesForOrg(t *testing.T) {
+	const (
+		otherClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+		otherUserID      = types.UserID("2")
+		orgAdminID       = types.UserID("3")
+	)
+	firstDisabledAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	lastDisabledAt := firstDisabledAt.Add(time.Hour)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, testdata.OrgID, otherClusterName, testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, otherOrgID, otherOrgClusterName, testClusterEmptyReport)
+
+		now := firstDisabledAt
+		defer storage.SetTimeNow(func() time.Time { return now })()
+
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "noisy",
+		))
+		now = lastDisabledAt
+		// the most recent justification is kept, the empty one isn't
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			otherClusterName, testdata.Rule1ID, otherUserID, storage.RuleToggleDisable, "",
+		))
+		// rules enabled for clusters and toggles of other organizations are not listed
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			otherClusterName, testdata.Rule2ID, otherUserID, storage.RuleToggleEnable, "needed",
+		))
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			otherOrgClusterName, testdata.Rule3ID, otherUserID, storage.RuleToggleDisable, "other",
+		))
+
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule2ID, orgAdminID))
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule3ID, orgAdminID, "known issue"))
+
+		summaries, err := mockStorage.ListDisabledRulesForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Len(t, summaries, 3)
+
+		// rules disabled for the organization and acked by it are updated at the current time
+		for i := 1; i < len(summaries); i++ {
+			assert.True(t, summaries[i].UpdatedAt.After(lastDisabledAt))
+			summaries[i].UpdatedAt = time.Time{}
+		}
+
+		assert.Equal(t, []storage.DisabledRuleSummary{
+			{
+				RuleID:        testdata.Rule1ID,
+				ClusterCount:  2,
+				Users:         []types.UserID{testdata.UserID, otherUserID},
+				Justification: "noisy",
+				UpdatedAt:     lastDisabledAt,
+			},
+			{
+				RuleID:         testdata.Rule2ID,
+				DisabledForOrg: true,
+				Users:          []types.UserID{orgAdminID},
+			},
+			{
+				RuleID:        testdata.Rule3ID,
+				Acked:         true,
+				Users:         []types.UserID{orgAdminID},
+				Justification: "known issue",
+			},
+		}, summaries)
+	})
+}
+
+// TestDBStorageListDisabledRulesForOrgAckAndToggle checks that the justification of the most recent
+// silencing of the rule is used and that the user who silenced the rule more times is listed once
+func TestDBStorageListDisabledRulesForOrgAckAndToggle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testClusterEmptyReport)
+
+		defer storage.SetTimeNow(func() time.Time { return time.Now().Add(-time.Hour) })()
+
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "noisy",
+		))
+		helpers.FailOnError(t, mockStorage.AckRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID, "known issue"))
+
+		summaries, err := mockStorage.ListDisabledRulesForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Len(t, summaries, 1)
+		assert.Equal(t, testdata.Rule1ID, summaries[0].RuleID)
+		assert.Equal(t, 1, summaries[0].ClusterCount)
+		assert.True(t, summaries[0].Acked)
+		assert.False(t, summaries[0].DisabledForOrg)
+		assert.Equal(t, []types.UserID{testdata.UserID}, summaries[0].Users)
+		assert.Equal(t, "known issue", summaries[0].Justification)
+	})
+}
+
+func TestDBStorageListDisabledRulesForOrgEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		// toggles of clusters without reports aren't attributed to any organization
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+		))
+
+		summaries, err := mockStorage.ListDisabledRulesForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.NotNil(t, summaries)
+		assert.Empty(t, summaries)
+	})
+}
+
+func TestDBStorageListDisabledRulesForOrgDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.ListDisabledRulesForOrg(testdata.OrgID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageDisableRuleForOrgUnsupportedDriverError(t *testing.T) {
+	connection, err := sql.Open("sqlite3", ":memory:")
+	helpers.FailOnError(t, err)
+
+	mockStorage := storage.NewFromConnection(connection, -1)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err = mockStorage.DisableRuleForOrg(testdata.OrgID, testRuleID, testUserID)
+	assert.EqualError(t, err, "disabling rules with DB -1 is not supported")
+}
+
+func TestDBStorageGetDisabledRulesForCluster(t *testing.T) {
+	const otherClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule2ID, testdata.UserID))
+		// the toggle of the cluster takes precedence over the organization
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule2ID, testdata.UserID, storage.RuleToggleEnable, "",
+		))
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule3ID, testdata.UserID, storage.RuleToggleDisable, "",
+		))
+		// toggles of other clusters are not applied
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			otherClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleEnable, "",
+		))
+
+		ruleIDs, err := mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleID{testdata.Rule1ID, testdata.Rule3ID}, ruleIDs)
+
+		// the rule disabled for the cluster stays disabled when the organization enables it
+		helpers.FailOnError(t, mockStorage.EnableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+		))
+
+		ruleIDs, err = mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleID{testdata.Rule1ID, testdata.Rule3ID}, ruleIDs)
+	})
+}
+
+func TestDBStorageGetDisabledRulesForClusterEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		ruleIDs, err := mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, ruleIDs)
+	})
+}
+
+func TestDBStorageDeleteRuleToggleForCluster(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.DisableRuleForOrg(testdata.OrgID, testdata.Rule1ID, testdata.UserID))
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleEnable, "",
+		))
+
+		helpers.FailOnError(t, mockStorage.DeleteRuleToggleForCluster(testdata.ClusterName, testdata.Rule1ID))
+
+		// the rule follows the organization again
+		ruleIDs, err := mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleID{testdata.Rule1ID}, ruleIDs)
+
+		err = mockStorage.DeleteRuleToggleForCluster(testdata.ClusterName, testdata.Rule1ID)
+		assertItemNotFound(t, err, storage.ItemKindToggle, testdata.ClusterName, testdata.Rule1ID)
+	})
+}
+
+func TestDBStorageToggleRuleForClusterUnknownToggle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.ToggleRuleForCluster(testdata.ClusterName, testdata.Rule1ID, testdata.UserID, 2, "")
+		if _, ok := err.(*storage.ValidationError); !ok {
+			t.Fatalf("expected ValidationError, got %T, %+v", err, err)
+		}
+	})
+}
+
+// TestDBStorageAddFeedbackToRuleDisable checks that the justification of the disabled rule
+// is updated without flipping its toggle
+func TestDBStorageAddFeedbackToRuleDisable(t *testing.T) {
+	disabledAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	updatedAt := disabledAt.Add(time.Hour)
+	const otherUserID = types.UserID("2")
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		now := disabledAt
+		defer storage.SetTimeNow(func() time.Time { return now })()
+
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "noisy",
+		))
+		// enabled rules are not listed
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule2ID, testdata.UserID, storage.RuleToggleEnable, "needed",
+		))
+
+		disables, err := mockStorage.ListDisabledRulesForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []storage.ClusterRuleDisable{{
+			ClusterName:   testdata.ClusterName,
+			RuleID:        testdata.Rule1ID,
+			UserID:        testdata.UserID,
+			Justification: "noisy",
+			UpdatedAt:     disabledAt,
+		}}, disables)
+
+		now = updatedAt
+		helpers.FailOnError(t, mockStorage.AddFeedbackToRuleDisable(
+			testdata.ClusterName, testdata.Rule1ID, otherUserID, "false positive",
+		))
+
+		disables, err = mockStorage.ListDisabledRulesForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []storage.ClusterRuleDisable{{
+			ClusterName:   testdata.ClusterName,
+			RuleID:        testdata.Rule1ID,
+			UserID:        otherUserID,
+			Justification: "false positive",
+			UpdatedAt:     updatedAt,
+		}}, disables)
+
+		// the rule stays disabled for the cluster
+		ruleIDs, err := mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleID{testdata.Rule1ID}, ruleIDs)
+
+		// the justification is replaced when the rule is toggled again
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+		))
+
+		disables, err = mockStorage.ListDisabledRulesForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Len(t, disables, 1)
+		assert.Equal(t, "", disables[0].Justification)
+	})
+}
+
+func TestDBStorageAddFeedbackToRuleDisableNotDisabled(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.AddFeedbackToRuleDisable(testdata.ClusterName, testdata.Rule1ID, testdata.UserID, "reason")
+		assertItemNotFound(t, err, storage.ItemKindToggle, testdata.ClusterName, testdata.Rule1ID)
+
+		// the rule enabled for the cluster isn't disabled
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleEnable, "",
+		))
+
+		err = mockStorage.AddFeedbackToRuleDisable(testdata.ClusterName, testdata.Rule1ID, testdata.UserID, "reason")
+		assertItemNotFound(t, err, storage.ItemKindToggle, testdata.ClusterName, testdata.Rule1ID)
+
+		ruleIDs, err := mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, ruleIDs)
+	})
+}
+
+// TestDBStorageRuleDisableJustificationLength checks that justifications are limited by number of characters
+func TestDBStorageRuleDisableJustificationLength(t *testing.T) {
+	justification := strings.Repeat("€", storage.DefaultMaxFeedbackMessageLength)
+	const expectedError = "Invalid value of 'justification': at most 2048 characters expected, got 2049"
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, justification,
+		))
+		helpers.FailOnError(t, mockStorage.AddFeedbackToRuleDisable(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, justification,
+		))
+
+		err := mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, justification+"€",
+		)
+		assert.EqualError(t, err, expectedError)
+		if _, ok := err.(*storage.ValidationError); !ok {
+			t.Fatalf("expected ValidationError, got %T, %+v", err, err)
+		}
+
+		err = mockStorage.AddFeedbackToRuleDisable(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, justification+"€",
+		)
+		assert.EqualError(t, err, expectedError)
+
+		// too long justification is rejected, not truncated
+		disables, err := mockStorage.ListDisabledRulesForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Len(t, disables, 1)
+		assert.Equal(t, justification, disables[0].Justification)
+	})
+}
+
+func TestDBStorageClusterRuleTogglesDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.ToggleRuleForCluster(
+		testdata.ClusterName, testRuleID, testUserID, storage.RuleToggleDisable, "",
+	)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	err = mockStorage.DeleteRuleToggleForCluster(testdata.ClusterName, testRuleID)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	err = mockStorage.AddFeedbackToRuleDisable(testdata.ClusterName, testRuleID, testUserID, "reason")
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, err = mockStorage.ListDisabledRulesForCluster(testdata.ClusterName)
+	assert.EqualError(t, err, "sql: database is closed")
+
+	_, err = mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+	assert.EqualError(t, err, "sql: database is closed")
 }
@@ -18,9 +18,11 @@ package storage_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
-	"database/sql/driver"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -53,11 +55,11 @@ func checkReportForCluster(
 	expected types.ClusterReport,
 ) {
 	// try to read report for cluster
-	result, _, err := s.ReadReportForCluster(orgID, clusterName)
+	storedReport, err := s.ReadReportForCluster(orgID, clusterName)
 	helpers.FailOnError(t, err)
 
 	// and check the read report with expected one
-	assert.Equal(t, expected, result)
+	assert.Equal(t, expected, storedReport.Report)
 }
 
 func writeReportForCluster(
@@ -67,7 +69,7 @@ func writeReportForCluster(
 	clusterName types.ClusterName,
 	clusterReport types.ClusterReport,
 ) {
-	err := storage.WriteReportForCluster(orgID, clusterName, clusterReport, time.Now())
+	err := storage.WriteReportForCluster(orgID, clusterName, clusterReport, time.Now(), types.UnknownKafkaOffset)
 	helpers.FailOnError(t, err)
 }
 
@@ -106,6 +108,47 @@ func TestNewStorageError(t *testing.T) {
 	}
 }
 
+// TestNewStorageConnectionPool checks that configured limits of the connection pool are applied
+func TestNewStorageConnectionPool(t *testing.T) {
+	dbStorage, err := storage.New(storage.Configuration{
+		Driver:                "sqlite3",
+		SQLiteDataSource:      ":memory:",
+		MaxOpenConnections:    3,
+		MaxIdleConnections:    1,
+		ConnectionMaxLifetime: time.Minute,
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, dbStorage)
+
+	connection := storage.GetConnection(dbStorage.(*storage.DBStorage))
+	assert.Equal(t, 3, connection.Stats().MaxOpenConnections)
+
+	// only one of released connections is kept idle
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := connection.Conn(context.Background())
+		helpers.FailOnError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		helpers.FailOnError(t, conn.Close())
+	}
+	assert.Equal(t, 1, connection.Stats().Idle)
+}
+
+// TestNewStorageConnectionPoolDefaults checks that defaults of database/sql are kept
+// when the connection pool is not configured
+func TestNewStorageConnectionPoolDefaults(t *testing.T) {
+	dbStorage, err := storage.New(storage.Configuration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: ":memory:",
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, dbStorage)
+
+	assert.Equal(t, 0, storage.GetConnection(dbStorage.(*storage.DBStorage)).Stats().MaxOpenConnections)
+}
+
 // TestNewStorageWithLogging tests creatign new storage with logs
 func TestNewStorageWithLoggingError(t *testing.T) {
 	s, _ := storage.New(storage.Configuration{
@@ -129,22 +172,10 @@ func TestNewStorageWithLoggingError(t *testing.T) {
 
 // TestDBStorageReadReportForClusterEmptyTable check the behaviour of method ReadReportForCluster
 func TestDBStorageReadReportForClusterEmptyTable(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
-
-	_, _, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
-	if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
-		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
-	}
-
-	assert.Equal(
-		t,
-		fmt.Sprintf(
-			"Item with ID %+v/%+v was not found in the storage",
-			testOrgID, testClusterName,
-		),
-		err.Error(),
-	)
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		_, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testOrgID, testClusterName)
+	})
 }
 
 // TestDBStorageReadReportForClusterClosedStorage check the behaviour of method ReadReportForCluster
@@ -153,28 +184,86 @@ func TestDBStorageReadReportForClusterClosedStorage(t *testing.T) {
 	// we need to close storage right now
 	helpers.MustCloseStorage(t, mockStorage)
 
-	_, _, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
+	_, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
 	expectErrorClosedStorage(t, err)
 }
 
 // TestDBStorageReadReportForCluster check the behaviour of method ReadReportForCluster
 func TestDBStorageReadReportForCluster(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
-
-	writeReportForCluster(t, mockStorage, testOrgID, testClusterName, `{"report":{}}`)
-	checkReportForCluster(t, mockStorage, testOrgID, testClusterName, `{"report":{}}`)
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testOrgID, testClusterName, `{"report":{}}`)
+		checkReportForCluster(t, mockStorage, testOrgID, testClusterName, `{"report":{}}`)
+	})
 }
 
 // TestDBStorageGetOrgIDByClusterID check the behaviour of method GetOrgIDByClusterID
 func TestDBStorageGetOrgIDByClusterID(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testOrgID, testClusterName, `{"report":{}}`)
+		orgID, err := mockStorage.GetOrgIDByClusterID(testClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, orgID, testOrgID)
+	})
+}
+
+// TestDBStorageReadEmptyReport checks that the cluster which sent an empty report exists
+// and its empty report is read with its timestamps, unlike the report of unknown cluster
+func TestDBStorageReadEmptyReport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testClusterEmptyReport, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		))
+
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testClusterEmptyReport, storedReport.Report)
+		assert.Equal(t, 0, storedReport.Count)
+		assert.True(t, testdata.LastCheckedAt.Equal(storedReport.LastCheckedAt))
+		assert.False(t, storedReport.ReportedAt.IsZero())
+
+		exists, err := mockStorage.DoesClusterExist(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.True(t, exists)
+
+		const unknownCluster = types.ClusterName("00000000-0000-0000-0000-000000000000")
+
+		_, err = mockStorage.ReadReportForCluster(testdata.OrgID, unknownCluster)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, unknownCluster)
+
+		exists, err = mockStorage.DoesClusterExist(unknownCluster)
+		helpers.FailOnError(t, err)
+		assert.False(t, exists)
+	})
+}
+
+// TestDBStorageDoesClusterExist checks that unknown cluster and cluster with soft-deleted report don't exist
+func TestDBStorageDoesClusterExist(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		exists, err := mockStorage.DoesClusterExist(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.False(t, exists)
+
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+		exists, err = mockStorage.DoesClusterExist(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.True(t, exists)
+
+		_, err = mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		exists, err = mockStorage.DoesClusterExist(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.False(t, exists)
+	})
+}
+
+func TestDBStorageDoesClusterExistDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	helpers.MustCloseStorage(t, mockStorage)
 
-	writeReportForCluster(t, mockStorage, testOrgID, testClusterName, `{"report":{}}`)
-	orgID, err := mockStorage.GetOrgIDByClusterID(testClusterName)
-	helpers.FailOnError(t, err)
-	assert.Equal(t, orgID, testOrgID)
+	_, err := mockStorage.DoesClusterExist(testdata.ClusterName)
+	expectErrorClosedStorage(t, err)
 }
 
 // TestDBStorageReadReportNoTable check the behaviour of method ReadReportForCluster
@@ -183,7 +272,7 @@ func TestDBStorageReadReportNoTable(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, false)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	_, _, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
+	_, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
 	expectErrorEmptyTable(t, err)
 }
 
@@ -198,6 +287,7 @@ func TestDBStorageWriteReportForClusterClosedStorage(t *testing.T) {
 		testClusterName,
 		testClusterEmptyReport,
 		time.Now(),
+		types.UnknownKafkaOffset,
 	)
 	expectErrorClosedStorage(t, err)
 }
@@ -212,44 +302,197 @@ func TestDBStorageWriteReportForClusterUnsupportedDriverError(t *testing.T) {
 		testClusterName,
 		testClusterEmptyReport,
 		time.Now(),
+		types.UnknownKafkaOffset,
 	)
 	assert.EqualError(t, err, "writing report with DB -1 is not supported")
 }
 
+// TestDBStorageCapabilities checks capabilities declared for each driver
+func TestDBStorageCapabilities(t *testing.T) {
+	for driverType, expected := range map[storage.DBDriver]storage.Capabilities{
+		storage.DBDriverSQLite3:  {Upsert: true, InsertOrReplace: true},
+		storage.DBDriverPostgres: {Upsert: true, JSONOperators: true, ApproximateCounts: true},
+		storage.DBDriverGeneral:  {Upsert: true},
+		-1:                       {},
+	} {
+		assert.Equal(t, expected, storage.NewFromConnection(nil, driverType).Capabilities(), driverType)
+	}
+}
+
+// TestDBStorageUnsupportedCapabilityError checks that operations needing the capability
+// which is not available fail without touching the database
+func TestDBStorageUnsupportedCapabilityError(t *testing.T) {
+	fakeStorage := storage.NewFromConnection(nil, -1)
+	// no need to close it
+
+	err := fakeStorage.AckRuleForOrg(testOrgID, testRuleID, testUserID, "justification")
+	assert.EqualError(t, err, "acking rules with DB -1 is not supported")
+
+	err = fakeStorage.DisableRuleForOrg(testOrgID, testRuleID, testUserID)
+	assert.EqualError(t, err, "disabling rules with DB -1 is not supported")
+}
+
 // TestDBStorageWriteReportForClusterMoreRecentInDB checks that older report
 // will not replace a more recent one when writing a report to storage.
 func TestDBStorageWriteReportForClusterMoreRecentInDB(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		newerTime := time.Now()
+		olderTime := newerTime.Add(-time.Hour)
+
+		// Insert newer report.
+		err := mockStorage.WriteReportForCluster(
+			testOrgID,
+			testClusterName,
+			testClusterEmptyReport,
+			newerTime,
+			types.UnknownKafkaOffset,
+		)
+		assert.NoError(t, err)
+
+		// Try to insert older report.
+		// If there's a way to check for a warning being logged,
+		// it would be quite handy to add it here.
+		err = mockStorage.WriteReportForCluster(
+			testOrgID,
+			testClusterName,
+			testClusterEmptyReport,
+			olderTime,
+			types.UnknownKafkaOffset,
+		)
+		assert.NoError(t, err)
+
+		storedReport, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
+		assert.NoError(t, err)
+		assert.Equal(t, newerTime.UTC(), storedReport.LastCheckedAt)
+	})
+}
+
+// TestDBStorageWriteReportForClusterOlderReportIgnored checks that older report
+// with different content doesn't replace the more recent one
+func TestDBStorageWriteReportForClusterOlderReportIgnored(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		err = mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt.Add(-time.Hour),
+			types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+		assert.Equal(t, testdata.LastCheckedAt.UTC(), storedReport.LastCheckedAt)
+
+		ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Len(t, ruleHits, 3)
+	})
+}
+
+// TestDBStorageWriteReportForClusterConcurrentWriters checks that no update is lost
+// and the most recent report wins when reports of the cluster are written concurrently
+func TestDBStorageWriteReportForClusterConcurrentWriters(t *testing.T) {
+	const writers = 20
+
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	newerTime := time.Now()
-	olderTime := newerTime.Add(-time.Hour)
+	// in-memory database is visible only to the connection which created it
+	storage.GetConnection(mockStorage.(*storage.DBStorage)).SetMaxOpenConns(1)
+
+	newest := time.Now()
+	start := make(chan struct{})
+	errs := make(chan error, writers)
+
+	// the most recent report is written first, so any later write of an older report could replace it
+	for i := 0; i < writers; i++ {
+		report := testdata.Report0Rules
+		if i == 0 {
+			report = testdata.Report3Rules
+		}
+		lastChecked := newest.Add(-time.Duration(i) * time.Minute)
+
+		go func() {
+			<-start
+			errs <- mockStorage.WriteReportForCluster(
+				testdata.OrgID, testdata.ClusterName, report, lastChecked, types.UnknownKafkaOffset,
+			)
+		}()
+	}
 
-	// Insert newer report.
-	err := mockStorage.WriteReportForCluster(
-		testOrgID,
-		testClusterName,
-		testClusterEmptyReport,
-		newerTime,
-	)
-	assert.NoError(t, err)
+	close(start)
+	for i := 0; i < writers; i++ {
+		helpers.FailOnError(t, <-errs)
+	}
 
-	// Try to insert older report.
-	// If there's a way to check for a warning being logged,
-	// it would be quite handy to add it here.
-	err = mockStorage.WriteReportForCluster(
-		testOrgID,
-		testClusterName,
-		testClusterEmptyReport,
-		olderTime,
-	)
-	assert.NoError(t, err)
+	storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+	assert.Equal(t, newest.UTC(), storedReport.LastCheckedAt)
+}
 
-	_, timestamp, err := mockStorage.ReadReportForCluster(testOrgID, testClusterName)
-	assert.NoError(t, err)
-	// Unfortunately, the ReadReport returns the timestamp as a different type than
-	// what has been initially inserted, so we need to format it in the same way here.
-	assert.Equal(t, types.Timestamp(newerTime.Format(time.RFC3339)), timestamp)
+// TestDBStorageWriteReportForClusterKafkaOffsetReplay checks that report consumed
+// from already processed Kafka offset doesn't replace the stored one
+func TestDBStorageWriteReportForClusterKafkaOffsetReplay(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+		)
+		helpers.FailOnError(t, err)
+
+		err = mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt.Add(time.Minute), 6,
+		)
+		helpers.FailOnError(t, err)
+
+		// the replayed report is newer, but it was consumed from already processed offset
+		err = mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt.Add(time.Hour), 5,
+		)
+		assert.Equal(t, storage.ErrOldReport, err)
+
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.Report0Rules, storedReport.Report)
+		assert.Equal(t, testdata.LastCheckedAt.Add(time.Minute).UTC(), storedReport.LastCheckedAt.UTC())
+
+		// the same offset is considered already processed too
+		err = mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt.Add(time.Hour), 6,
+		)
+		assert.Equal(t, storage.ErrOldReport, err)
+	})
+}
+
+// TestDBStorageWriteReportForClusterUnknownKafkaOffset checks that reports with unknown
+// offset, like uploaded reports, are always written
+func TestDBStorageWriteReportForClusterUnknownKafkaOffset(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+		)
+		helpers.FailOnError(t, err)
+
+		err = mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt.Add(time.Minute),
+			types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		// the offset is not known anymore, so any offset is written
+		err = mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt.Add(time.Hour), 5,
+		)
+		helpers.FailOnError(t, err)
+
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+	})
 }
 
 // TestDBStorageWriteReportForClusterDroppedReportTable checks the error
@@ -262,7 +505,9 @@ func TestDBStorageWriteReportForClusterDroppedReportTable(t *testing.T) {
 	_, err := connection.Exec("DROP TABLE report")
 	assert.NoError(t, err)
 
-	err = mockStorage.WriteReportForCluster(testOrgID, testClusterName, testClusterEmptyReport, time.Now())
+	err = mockStorage.WriteReportForCluster(
+		testOrgID, testClusterName, testClusterEmptyReport, time.Now(), types.UnknownKafkaOffset,
+	)
 	assert.EqualError(t, err, "no such table: report")
 }
 
@@ -279,52 +524,254 @@ func TestDBStorageWriteReportForClusterExecError(t *testing.T) {
 			report          VARCHAR NOT NULL,
 			reported_at     TIMESTAMP,
 			last_checked_at TIMESTAMP,
+			kafka_offset    BIGINT,
+			report_checksum VARCHAR,
 			PRIMARY KEY(org_id, cluster)
 		)
 	`)
 	helpers.FailOnError(t, err)
 
 	err = mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
 	)
 	assert.EqualError(t, err, "CHECK constraint failed: report")
 }
 
 func TestDBStorageWriteReportForClusterFakePostgresOK(t *testing.T) {
-	mockStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverPostgres)
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
 	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
 
-	expects.ExpectBegin()
+	expects.ExpectPostgresWriteReport(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
 
-	expects.ExpectQuery(`SELECT last_checked_at FROM report`).
-		WillReturnRows(expects.NewRows([]string{"last_checked_at"})).
-		RowsWillBeClosed()
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+}
 
-	expects.ExpectExec("INSERT INTO report").
-		WillReturnResult(driver.ResultNoRows)
+func TestDBStorageWriteReportForClusterFakePostgresKafkaOffset(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
 
-	expects.ExpectCommit()
+	expects.ExpectPostgresWriteReport(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+	)
 
 	err := mockStorage.WriteReportForCluster(
-		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt,
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
 	)
 	helpers.FailOnError(t, err)
 }
 
-// TestDBStorageListOfOrgs check the behaviour of method ListOfOrgs
-func TestDBStorageListOfOrgs(t *testing.T) {
+// TestDBStorageWriteReportForClusterFakePostgresDuplicate checks that identical report
+// is not rewritten, only the time of the last check is updated
+func TestDBStorageWriteReportForClusterFakePostgresDuplicate(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	lastChecked := testdata.LastCheckedAt.Add(time.Hour)
+	expects.ExpectPostgresWriteDuplicateReport(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, lastChecked, 5,
+	)
+
+	err := mockStorage.WriteReportForCluster(testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, lastChecked, 5)
+	helpers.FailOnError(t, err)
+}
+
+// TestDBStorageWriteReportForClusterFakePostgresOutdated checks that the report
+// is discarded when the upsert finds more recent report stored
+func TestDBStorageWriteReportForClusterFakePostgresOutdated(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectPostgresWriteOutdatedReport(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+	)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+	)
+	helpers.FailOnError(t, err)
+}
+
+// TestDBStorageWriteReportForClusterFakePostgresOldReport checks that the offset of the stored report
+// is checked only when nothing has been written and the transaction is rolled back for old report
+func TestDBStorageWriteReportForClusterFakePostgresOldReport(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectPostgresWriteOldReport(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+	)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+	)
+	assert.Equal(t, storage.ErrOldReport, err)
+}
+
+// TestDBStorageWriteReportForClusterDuplicate checks that only the time of the last check
+// of the report is updated when the same report is written again
+func TestDBStorageWriteReportForClusterDuplicate(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	defer helpers.MustCloseStorage(t, mockStorage)
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
 
-	writeReportForCluster(t, mockStorage, 1, "1deb586c-fb85-4db4-ae5b-139cdbdf77ae", testClusterEmptyReport)
-	writeReportForCluster(t, mockStorage, 3, "a1bf5b15-5229-4042-9825-c69dc36b57f5", testClusterEmptyReport)
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
 
-	result, err := mockStorage.ListOfOrgs()
-	if err != nil {
-		t.Fatal(err)
+	var reportedAt, checksum string
+	err := connection.QueryRow(
+		"SELECT reported_at, report_checksum FROM report WHERE cluster = $1", testdata.ClusterName,
+	).Scan(&reportedAt, &checksum)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, helpers.ReportChecksum(testdata.Report3Rules), checksum)
+
+	lastChecked := time.Now().Add(time.Hour)
+	err = mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, lastChecked, types.KafkaOffset(7),
+	)
+	helpers.FailOnError(t, err)
+
+	storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+	assert.Equal(t, lastChecked.UTC().Truncate(time.Second), storedReport.LastCheckedAt.UTC().Truncate(time.Second))
+
+	// the report has not been rewritten
+	var storedReportedAt string
+	err = connection.QueryRow(
+		"SELECT reported_at FROM report WHERE cluster = $1", testdata.ClusterName,
+	).Scan(&storedReportedAt)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, reportedAt, storedReportedAt)
+
+	latestOffset, err := mockStorage.GetLatestKafkaOffset()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.KafkaOffset(7), latestOffset)
+
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Len(t, ruleHits, 3)
+}
+
+// TestDBStorageWriteReportForClusterChangedReport checks that changed report is rewritten
+// together with its checksum
+func TestDBStorageWriteReportForClusterChangedReport(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report0Rules)
+
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report0Rules)
+
+	var checksum string
+	err := storage.GetConnection(mockStorage.(*storage.DBStorage)).QueryRow(
+		"SELECT report_checksum FROM report WHERE cluster = $1", testdata.ClusterName,
+	).Scan(&checksum)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, helpers.ReportChecksum(testdata.Report0Rules), checksum)
+
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, ruleHits)
+}
+
+// TestDBStorageReportHistoryDuplicateReport checks that each check of the cluster is kept
+// in the history even when its report didn't change
+func TestDBStorageReportHistoryDuplicateReport(t *testing.T) {
+	forEachBackendWithReportHistory(t, 10, func(t *testing.T, mockStorage storage.Storage) {
+		for _, lastChecked := range []time.Time{time.Unix(10, 0), time.Unix(20, 0)} {
+			helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+				testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, lastChecked, types.UnknownKafkaOffset,
+			))
+		}
+
+		history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 10)
+		helpers.FailOnError(t, err)
+		assert.Len(t, history, 2)
+	})
+}
+
+// TestDBStorageListOfOrgs check the behaviour of method ListOfOrgs
+func TestDBStorageListOfOrgs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, 1, "1deb586c-fb85-4db4-ae5b-139cdbdf77ae", testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, 3, "a1bf5b15-5229-4042-9825-c69dc36b57f5", testClusterEmptyReport)
+
+		result, err := mockStorage.ListOfOrgs()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []types.OrgID{1, 3}, result)
+	})
+}
+
+// TestDBStorageOrgIDAbove31Bits checks that organization IDs not fitting into signed 32-bit integer,
+// up to the highest one which can be stored, are written, read, listed and deleted correctly
+func TestDBStorageOrgIDAbove31Bits(t *testing.T) {
+	for _, orgID := range []types.OrgID{1<<31 + 1, 1<<32 + 1, types.MaxOrgID} {
+		t.Run(fmt.Sprint(orgID), func(t *testing.T) {
+			testOrgIDRoundTrip(t, orgID)
+		})
 	}
+}
+
+// testOrgIDRoundTrip writes, reads, lists and deletes the report of the organization
+func testOrgIDRoundTrip(t *testing.T, orgID types.OrgID) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.WriteReportForCluster(
+			orgID, testClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		storedReport, err := mockStorage.ReadReportForCluster(orgID, testClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+
+		orgs, err := mockStorage.ListOfOrgs()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.OrgID{orgID}, orgs)
+
+		foundOrgID, err := mockStorage.GetOrgIDByClusterID(testClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, orgID, foundOrgID)
+
+		_, err = mockStorage.DeleteReportsForOrg(orgID)
+		helpers.FailOnError(t, err)
+
+		_, err = mockStorage.ReadReportForCluster(orgID, testClusterName)
+		if _, ok := err.(*storage.ItemNotFoundError); err == nil || !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+	})
+}
+
+// TestDBStorageOrgIDAbove31BitsFakePostgres checks that organization ID is passed to PostgreSQL unchanged
+func TestDBStorageOrgIDAbove31BitsFakePostgres(t *testing.T) {
+	const orgID = types.MaxOrgID
+
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectQueryWithArgs(
+		"SELECT cluster FROM report WHERE org_id = $1 AND deleted_at IS NULL ORDER BY cluster", orgID,
+	).WillReturnRows(sqlmock.NewRows([]string{"cluster"}).AddRow(string(testClusterName)))
+
+	expects.ExpectQueryWithArgs(
+		"SELECT DISTINCT org_id FROM report WHERE deleted_at IS NULL ORDER BY org_id",
+	).WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(int64(orgID)))
 
-	assert.Equal(t, []types.OrgID{1, 3}, result)
+	clusters, err := mockStorage.ListOfClustersForOrg(orgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{testClusterName}, clusters)
+
+	orgs, err := mockStorage.ListOfOrgs()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.OrgID{orgID}, orgs)
 }
 
 func TestDBStorageListOfOrgsNoTable(t *testing.T) {
@@ -345,33 +792,79 @@ func TestDBStorageListOfOrgsClosedStorage(t *testing.T) {
 	expectErrorClosedStorage(t, err)
 }
 
-// TestDBStorageListOfClustersFor check the behaviour of method ListOfClustersForOrg
-func TestDBStorageListOfClustersForOrg(t *testing.T) {
+// TestDBStorageClustersCountPerOrg check the behaviour of method ClustersCountPerOrg
+func TestDBStorageClustersCountPerOrg(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		result, err := mockStorage.ClustersCountPerOrg()
+		helpers.FailOnError(t, err)
+		assert.Empty(t, result)
+
+		writeReportForCluster(t, mockStorage, 1, "1deb586c-fb85-4db4-ae5b-139cdbdf77ae", testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, 3, "a1bf5b15-5229-4042-9825-c69dc36b57f5", testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, 3, "e8cbe2b2-1a0e-4d1e-8a6b-3c0c0dc4e9b4", testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, 3, "f2b4e4a8-4d8a-4a61-a8b3-59cc0e64a7c2", testClusterEmptyReport)
+
+		result, err = mockStorage.ClustersCountPerOrg()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, map[types.OrgID]int{1: 1, 3: 3}, result)
+	})
+}
+
+// TestDBStorageClustersCountPerOrgClosedStorage check the behaviour of method ClustersCountPerOrg
+func TestDBStorageClustersCountPerOrgClosedStorage(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	// we need to close storage right now
+	helpers.MustCloseStorage(t, mockStorage)
 
-	writeReportForCluster(t, mockStorage, 1, "eabb4fbf-edfa-45d0-9352-fb05332fdb82", testClusterEmptyReport)
-	writeReportForCluster(t, mockStorage, 1, "edf5f242-0c12-4307-8c9f-29dcd289d045", testClusterEmptyReport)
+	_, err := mockStorage.ClustersCountPerOrg()
+	expectErrorClosedStorage(t, err)
+}
 
-	// also pushing cluster for different org
-	writeReportForCluster(t, mockStorage, 5, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", testClusterEmptyReport)
+func TestDBStorageClustersCountPerOrgLogError(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.Logger = zerolog.New(buf)
 
-	result, err := mockStorage.ListOfClustersForOrg(1)
-	if err != nil {
-		t.Fatal(err)
-	}
+	s := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, s)
 
-	assert.Equal(t, []types.ClusterName{
-		"eabb4fbf-edfa-45d0-9352-fb05332fdb82",
-		"edf5f242-0c12-4307-8c9f-29dcd289d045",
-	}, result)
+	connection := storage.GetConnection(s.(*storage.DBStorage))
+	// write illegal negative org_id
+	mustWriteReport(t, connection, -1, testClusterName, testClusterEmptyReport)
+	writeReportForCluster(t, s, 1, "1deb586c-fb85-4db4-ae5b-139cdbdf77ae", testClusterEmptyReport)
 
-	result, err = mockStorage.ListOfClustersForOrg(5)
-	if err != nil {
-		t.Fatal(err)
-	}
+	result, err := s.ClustersCountPerOrg()
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, map[types.OrgID]int{1: 1}, result)
+	assert.Contains(t, buf.String(), "sql: Scan error")
+}
+
+// TestDBStorageListOfClustersFor check the behaviour of method ListOfClustersForOrg
+func TestDBStorageListOfClustersForOrg(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, 1, "eabb4fbf-edfa-45d0-9352-fb05332fdb82", testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, 1, "edf5f242-0c12-4307-8c9f-29dcd289d045", testClusterEmptyReport)
+
+		// also pushing cluster for different org
+		writeReportForCluster(t, mockStorage, 5, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", testClusterEmptyReport)
+
+		result, err := mockStorage.ListOfClustersForOrg(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []types.ClusterName{
+			"eabb4fbf-edfa-45d0-9352-fb05332fdb82",
+			"edf5f242-0c12-4307-8c9f-29dcd289d045",
+		}, result)
 
-	assert.Equal(t, []types.ClusterName{"4016d01b-62a1-4b49-a36e-c1c5a3d02750"}, result)
+		result, err = mockStorage.ListOfClustersForOrg(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []types.ClusterName{"4016d01b-62a1-4b49-a36e-c1c5a3d02750"}, result)
+	})
 }
 
 func TestDBStorageListOfClustersNoTable(t *testing.T) {
@@ -392,35 +885,365 @@ func TestDBStorageListOfClustersClosedStorage(t *testing.T) {
 	expectErrorClosedStorage(t, err)
 }
 
-// TestMockDBReportsCount check the behaviour of method ReportsCount
-func TestMockDBReportsCount(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+// TestDBStorageListClustersCheckedInRange checks that both ends of the range are inclusive
+// and that clusters of other organizations are not listed
+// TestDBStorageListOfClustersForOrgWithTimestamps checks that the clusters checked most recently go first
+// and that the plain list of clusters is still ordered by name
+func TestDBStorageListOfClustersForOrgWithTimestamps(t *testing.T) {
+	const (
+		clusterOldest = types.ClusterName("1deb586c-fb85-4db4-ae5b-139cdbdf77ae")
+		clusterNewest = types.ClusterName("4016d01b-62a1-4b49-a36e-c1c5a3d02750")
+		clusterMiddle = types.ClusterName("eabb4fbf-edfa-45d0-9352-fb05332fdb82")
+	)
 
-	cnt, err := mockStorage.ReportsCount()
-	if err != nil {
-		t.Fatal(err)
+	lastChecked := time.Date(2020, 3, 5, 10, 0, 0, 0, time.UTC)
+	checkedAt := map[types.ClusterName]time.Time{
+		clusterOldest: lastChecked.Add(-time.Hour),
+		clusterNewest: lastChecked.Add(time.Hour),
+		clusterMiddle: lastChecked,
 	}
 
-	assert.Equal(t, cnt, 0)
-
-	writeReportForCluster(t, mockStorage, 5, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", testClusterEmptyReport)
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		for clusterName, lastChecked := range checkedAt {
+			err := mockStorage.WriteReportForCluster(
+				testdata.OrgID, clusterName, testClusterEmptyReport, lastChecked, types.UnknownKafkaOffset,
+			)
+			helpers.FailOnError(t, err)
+		}
+		writeReportForCluster(t, mockStorage, otherOrgID, otherOrgClusterName, testClusterEmptyReport)
+
+		clusters, err := mockStorage.ListOfClustersForOrgWithTimestamps(testdata.OrgID)
+		helpers.FailOnError(t, err)
+
+		assert.Len(t, clusters, 3)
+		for i, clusterName := range []types.ClusterName{clusterNewest, clusterMiddle, clusterOldest} {
+			assert.Equal(t, clusterName, clusters[i].Name)
+			assert.Equal(t, types.NewOptionalTimestamp(checkedAt[clusterName]), clusters[i].LastCheckedAt)
+			assert.NotNil(t, clusters[i].ReportedAt)
+		}
+
+		names, err := mockStorage.ListOfClustersForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterName{clusterOldest, clusterNewest, clusterMiddle}, names)
+	})
+}
 
-	cnt, err = mockStorage.ReportsCount()
-	if err != nil {
-		t.Fatal(err)
-	}
+func TestDBStorageListOfClustersForOrgWithTimestampsClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
 
-	assert.Equal(t, cnt, 1)
+	_, err := mockStorage.ListOfClustersForOrgWithTimestamps(testdata.OrgID)
+	expectErrorClosedStorage(t, err)
 }
 
-func TestMockDBReportsCountNoTable(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, false)
-	defer helpers.MustCloseStorage(t, mockStorage)
+func TestDBStorageListClustersCheckedInRange(t *testing.T) {
+	const (
+		clusterBefore = types.ClusterName("eabb4fbf-edfa-45d0-9352-fb05332fdb82")
+		clusterFrom   = types.ClusterName("edf5f242-0c12-4307-8c9f-29dcd289d045")
+		clusterTo     = types.ClusterName("4016d01b-62a1-4b49-a36e-c1c5a3d02750")
+		clusterAfter  = types.ClusterName("1deb586c-fb85-4db4-ae5b-139cdbdf77ae")
+	)
 
-	_, err := mockStorage.ReportsCount()
-	expectErrorEmptyTable(t, err)
-}
+	from := time.Date(2020, 3, 5, 10, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		for clusterName, lastChecked := range map[types.ClusterName]time.Time{
+			clusterBefore: from.Add(-time.Second),
+			clusterFrom:   from,
+			clusterTo:     to,
+			clusterAfter:  to.Add(time.Second),
+		} {
+			err := mockStorage.WriteReportForCluster(
+				testdata.OrgID, clusterName, testClusterEmptyReport, lastChecked, types.UnknownKafkaOffset,
+			)
+			helpers.FailOnError(t, err)
+		}
+		writeReportForCluster(t, mockStorage, otherOrgID, otherOrgClusterName, testClusterEmptyReport)
+
+		clusters, err := mockStorage.ListClustersCheckedInRange(testdata.OrgID, from, to)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterNameWithTimestamp{
+			{Name: clusterFrom, LastCheckedAt: types.NewOptionalTimestamp(from)},
+			{Name: clusterTo, LastCheckedAt: types.NewOptionalTimestamp(to)},
+		}, clusters)
+	})
+}
+
+// TestDBStorageListClustersCheckedInRangeUntilNow checks that zero end of the range means now
+func TestDBStorageListClustersCheckedInRangeUntilNow(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		clusters, err := mockStorage.ListClustersCheckedInRange(testdata.OrgID, testdata.LastCheckedAt, time.Time{})
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterNameWithTimestamp{
+			{Name: testdata.ClusterName, LastCheckedAt: types.NewOptionalTimestamp(testdata.LastCheckedAt)},
+		}, clusters)
+
+		clusters, err = mockStorage.ListClustersCheckedInRange(
+			testdata.OrgID, testdata.LastCheckedAt.Add(time.Second), time.Time{},
+		)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, clusters)
+	})
+}
+
+// TestDBStorageListClustersCheckedInRangeUntilNowClock checks that now of the zero end
+// of the range is taken from the clock of the storage
+func TestDBStorageListClustersCheckedInRangeUntilNowClock(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		// the report is checked after now
+		defer storage.SetTimeNow(func() time.Time { return testdata.LastCheckedAt.Add(-time.Second) })()
+
+		clusters, err := mockStorage.ListClustersCheckedInRange(testdata.OrgID, time.Time{}, time.Time{})
+		helpers.FailOnError(t, err)
+		assert.Empty(t, clusters)
+	})
+}
+
+func TestDBStorageListClustersCheckedInRangeClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.ListClustersCheckedInRange(testdata.OrgID, time.Time{}, time.Time{})
+	expectErrorClosedStorage(t, err)
+}
+
+// TestDBStorageListStaleClusters checks that only clusters of all organizations not checked for longer
+// than the given time are listed, the reports are far enough from the cutoff to tolerate the test duration
+func TestDBStorageListStaleClusters(t *testing.T) {
+	const oldClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		clusters, err := mockStorage.ListStaleClusters(24 * time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, clusters)
+
+		for _, report := range []struct {
+			orgID       types.OrgID
+			clusterName types.ClusterName
+			lastChecked time.Time
+		}{
+			{testdata.OrgID, testdata.ClusterName, time.Now().Add(-time.Hour)},
+			{testdata.OrgID, oldClusterName, time.Now().Add(-48 * time.Hour)},
+			{otherOrgID, otherOrgClusterName, time.Now().Add(-72 * time.Hour)},
+		} {
+			err := mockStorage.WriteReportForCluster(
+				report.orgID, report.clusterName, testClusterEmptyReport, report.lastChecked, types.UnknownKafkaOffset,
+			)
+			helpers.FailOnError(t, err)
+		}
+
+		clusters, err = mockStorage.ListStaleClusters(24 * time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterName{otherOrgClusterName, oldClusterName}, clusters)
+
+		clusters, err = mockStorage.ListStaleClusters(96 * time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, clusters)
+	})
+}
+
+// TestDBStorageStaleClustersClock checks that the age of reports listed as stale
+// and cleaned up is computed from the clock of the storage
+func TestDBStorageStaleClustersClock(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		defer storage.SetTimeNow(func() time.Time { return testdata.LastCheckedAt.Add(48 * time.Hour) })()
+
+		clusters, err := mockStorage.ListStaleClusters(24 * time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterName{testdata.ClusterName}, clusters)
+
+		deleted, err := mockStorage.CleanupOldReports(24 * time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, deleted)
+	})
+}
+
+func TestDBStorageListStaleClustersClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.ListStaleClusters(24 * time.Hour)
+	expectErrorClosedStorage(t, err)
+}
+
+// TestMockDBReportsCount check the behaviour of method ReportsCount
+func TestMockDBReportsCount(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		cnt, err := mockStorage.ReportsCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, cnt, 0)
+
+		writeReportForCluster(t, mockStorage, 5, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", testClusterEmptyReport)
+
+		cnt, err = mockStorage.ReportsCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, cnt, 1)
+	})
+}
+
+// TestDBStorageReportsCountForOrg checks that only reports of the given organization are counted
+func TestDBStorageReportsCountForOrg(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, 1, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, 1, "5d5892d3-1f74-4ccf-91af-548dfc9767aa", testClusterEmptyReport)
+		writeReportForCluster(t, mockStorage, 2, "b0c2d108-0603-41c3-9a8f-0a37eba5df48", testClusterEmptyReport)
+
+		for _, testCase := range []struct {
+			orgID         types.OrgID
+			expectedCount int
+		}{
+			{1, 2},
+			{2, 1},
+			{3, 0},
+		} {
+			count, err := mockStorage.ReportsCountForOrg(testCase.orgID)
+			helpers.FailOnError(t, err)
+			assert.Equal(t, testCase.expectedCount, count, "organization %v", testCase.orgID)
+		}
+	})
+}
+
+func TestDBStorageGetLatestKafkaOffsetEmptyTable(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		offset, err := mockStorage.GetLatestKafkaOffset()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.KafkaOffset(0), offset)
+	})
+}
+
+func TestDBStorageGetLatestKafkaOffset(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		for _, report := range []struct {
+			clusterName types.ClusterName
+			offset      types.KafkaOffset
+		}{
+			{"4016d01b-62a1-4b49-a36e-c1c5a3d02750", 5},
+			{"5d5892d3-1f74-4ccf-91af-548dfc9767aa", 9},
+			{"b0c2d108-0603-41c3-9a8f-0a37eba5df48", 7},
+			{"84f7eedc-0dd8-49cd-9d4d-f6646df3a5bc", types.UnknownKafkaOffset},
+		} {
+			err := mockStorage.WriteReportForCluster(
+				testdata.OrgID, report.clusterName, testClusterEmptyReport, time.Now(), report.offset,
+			)
+			helpers.FailOnError(t, err)
+		}
+
+		offset, err := mockStorage.GetLatestKafkaOffset()
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.KafkaOffset(9), offset)
+	})
+}
+
+// TestDBStorageGetLatestKafkaOffsetNullOffsets checks that rows written before
+// the kafka_offset column was added are ignored
+func TestDBStorageGetLatestKafkaOffsetNullOffsets(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
+	mustWriteReport(t, connection, testdata.OrgID, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", testClusterEmptyReport)
+	mustWriteReport(t, connection, testdata.OrgID, "5d5892d3-1f74-4ccf-91af-548dfc9767aa", testClusterEmptyReport)
+
+	offset, err := mockStorage.GetLatestKafkaOffset()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.KafkaOffset(0), offset)
+
+	err = mockStorage.WriteReportForCluster(
+		testdata.OrgID, "b0c2d108-0603-41c3-9a8f-0a37eba5df48", testClusterEmptyReport, time.Now(), 3,
+	)
+	helpers.FailOnError(t, err)
+
+	offset, err = mockStorage.GetLatestKafkaOffset()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.KafkaOffset(3), offset)
+}
+
+func TestDBStorageGetLatestKafkaOffsetClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	// we need to close storage right now
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.GetLatestKafkaOffset()
+	expectErrorClosedStorage(t, err)
+}
+
+// TestDBStorageGetOrgStatistics checks statistics of organizations with several reports,
+// with one report and without any report
+func TestDBStorageGetOrgStatistics(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		oldest := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+		newest := time.Date(2020, 3, 5, 10, 0, 0, 0, time.UTC)
+
+		for _, report := range []struct {
+			orgID       types.OrgID
+			clusterName types.ClusterName
+			lastChecked time.Time
+		}{
+			{1, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", newest},
+			{1, "5d5892d3-1f74-4ccf-91af-548dfc9767aa", oldest},
+			{1, "6a5fd9ee-c1f8-4a57-b5a1-2f1e3d4c5b6a", time.Date(2020, 3, 3, 10, 0, 0, 0, time.UTC)},
+			// reports of another organization must not affect the statistics
+			{2, "b0c2d108-0603-41c3-9a8f-0a37eba5df48", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{2, "c1d3e219-1714-42d4-8b9a-1b48fcb6e059", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{3, "d2e4f32a-2825-43e5-9cab-2c59adc7f16a", oldest},
+		} {
+			err := mockStorage.WriteReportForCluster(
+				report.orgID, report.clusterName, testClusterEmptyReport, report.lastChecked, types.UnknownKafkaOffset,
+			)
+			helpers.FailOnError(t, err)
+		}
+
+		stats, err := mockStorage.GetOrgStatistics(1)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.OrgStats{
+			ClusterCount:        3,
+			OldestLastCheckedAt: types.NewOptionalTimestamp(oldest),
+			NewestLastCheckedAt: types.NewOptionalTimestamp(newest),
+		}, stats)
+
+		stats, err = mockStorage.GetOrgStatistics(3)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.OrgStats{
+			ClusterCount:        1,
+			OldestLastCheckedAt: types.NewOptionalTimestamp(oldest),
+			NewestLastCheckedAt: types.NewOptionalTimestamp(oldest),
+		}, stats)
+
+		stats, err = mockStorage.GetOrgStatistics(4)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.OrgStats{}, stats)
+	})
+}
+
+func TestDBStorageGetOrgStatisticsClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	// we need to close storage right now
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.GetOrgStatistics(1)
+	expectErrorClosedStorage(t, err)
+}
+
+func TestMockDBReportsCountNoTable(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, false)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.ReportsCount()
+	expectErrorEmptyTable(t, err)
+}
 
 func TestMockDBReportsCountClosedStorage(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, false)
@@ -444,12 +1267,60 @@ func TestDBStorageNewPostgresqlError(t *testing.T) {
 	}
 }
 
+// TestDBStorageInitTwice checks that Init can be called repeatedly on the same database,
+// the stored data are kept and no table is created by the repeated Init
+func TestDBStorageInitTwice(t *testing.T) {
+	buf := new(bytes.Buffer)
+	originalLogger := log.Logger
+	log.Logger = zerolog.New(buf)
+	defer func() {
+		log.Logger = originalLogger
+	}()
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	assert.Contains(t, buf.String(), `"created":["audit_log","cluster_rule_toggle","cluster_rule_user_feedback",`)
+	assert.Contains(t, buf.String(), `"skipped":[]`)
+
+	mustWriteReport3Rules(t, mockStorage)
+	helpers.FailOnError(t, mockStorage.AddOrUpdateFeedbackOnRule(
+		testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message",
+	))
+
+	buf.Reset()
+	helpers.FailOnError(t, mockStorage.Init())
+
+	assert.Contains(t, buf.String(), `"created":[]`)
+	assert.Contains(t, buf.String(), `"skipped":["audit_log","cluster_rule_toggle","cluster_rule_user_feedback",`)
+
+	checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	feedback, err := mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, "message", feedback.Message)
+}
+
 func mustWriteReport(
 	t *testing.T,
 	connection *sql.DB,
 	orgID interface{},
 	clusterName interface{},
 	clusterReport interface{},
+) {
+	mustWriteReportWithTimestamps(t, connection, orgID, clusterName, clusterReport, time.Now(), time.Now())
+}
+
+// mustWriteReportWithTimestamps writes the report directly into the database,
+// so the timestamps can be NULL like in rows written by older versions
+func mustWriteReportWithTimestamps(
+	t *testing.T,
+	connection *sql.DB,
+	orgID interface{},
+	clusterName interface{},
+	clusterReport interface{},
+	reportedAt interface{},
+	lastCheckedAt interface{},
 ) {
 	query := `
 		INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at)
@@ -465,8 +1336,8 @@ func mustWriteReport(
 		orgID,
 		clusterName,
 		clusterReport,
-		time.Now(),
-		time.Now(),
+		reportedAt,
+		lastCheckedAt,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -505,6 +1376,21 @@ func TestDBStorageCloseError(t *testing.T) {
 	assert.EqualError(t, err, errString)
 }
 
+func TestDBStoragePing(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	helpers.FailOnError(t, mockStorage.Ping())
+}
+
+func TestDBStoragePingClosedStorage(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.Ping()
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
 func TestDBStorageListOfClustersForOrgScanError(t *testing.T) {
 	// just for the coverage, because this error can't happen ever because we use
 	// not null in table creation
@@ -534,9 +1420,7 @@ func TestDBStorageDeleteReports(t *testing.T) {
 	for _, functionName := range []string{
 		"DeleteReportsForOrg", "DeleteReportsForCluster",
 	} {
-		func() {
-			mockStorage := helpers.MustGetMockStorage(t, true)
-			defer helpers.MustCloseStorage(t, mockStorage)
+		forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
 			assertNumberOfReports(t, mockStorage, 0)
 
 			err := mockStorage.WriteReportForCluster(
@@ -544,6 +1428,7 @@ func TestDBStorageDeleteReports(t *testing.T) {
 				testdata.ClusterName,
 				testdata.Report3Rules,
 				testdata.LastCheckedAt,
+				types.UnknownKafkaOffset,
 			)
 			helpers.FailOnError(t, err)
 
@@ -551,88 +1436,1022 @@ func TestDBStorageDeleteReports(t *testing.T) {
 
 			switch functionName {
 			case "DeleteReportsForOrg":
-				err = mockStorage.DeleteReportsForOrg(testdata.OrgID)
+				_, err = mockStorage.DeleteReportsForOrg(testdata.OrgID)
 			case "DeleteReportsForCluster":
-				err = mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+				_, err = mockStorage.DeleteReportsForCluster(testdata.ClusterName)
 			default:
 				t.Fatal(fmt.Errorf("unexpected function name"))
 			}
 			helpers.FailOnError(t, err)
 
 			assertNumberOfReports(t, mockStorage, 0)
-		}()
+		})
 	}
 }
 
-func TestDBStorage_ReadReportForClusterByClusterName_OK(t *testing.T) {
-	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+const otherOrgID = types.OrgID(2)
+const otherOrgClusterName = types.ClusterName("4016d01b-62a1-4b49-a36e-c1c5a3d02750")
 
-	mustWriteReport3Rules(t, mockStorage)
+// writeFeedbackInTwoOrgs writes a report and users' feedback for a cluster of testdata.OrgID
+// and for a cluster of another organization and acks a rule by both organizations
+func writeFeedbackInTwoOrgs(t *testing.T, mockStorage storage.Storage) {
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testClusterEmptyReport)
+	writeReportForCluster(t, mockStorage, otherOrgID, otherOrgClusterName, testClusterEmptyReport)
+	helpers.FailOnError(t, mockStorage.LoadRuleContent(testdata.RuleContent3Rules))
 
-	report, lastCheckedAt, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+	for orgID, clusterName := range map[types.OrgID]types.ClusterName{
+		testdata.OrgID: testdata.ClusterName,
+		otherOrgID:     otherOrgClusterName,
+	} {
+		err := mockStorage.AddOrUpdateFeedbackOnRule(clusterName, testdata.Rule1ID, "", testdata.UserID, "message")
+		helpers.FailOnError(t, err)
+
+		err = mockStorage.VoteOnRule(clusterName, testdata.Rule2ID, "", testdata.UserID, storage.UserVoteLike)
+		helpers.FailOnError(t, err)
+
+		err = mockStorage.AckRuleForOrg(orgID, testdata.Rule1ID, testdata.UserID, "justification")
+		helpers.FailOnError(t, err)
+	}
+}
+
+func assertFeedbacksForCluster(
+	t *testing.T, mockStorage storage.Storage, clusterName types.ClusterName, expected int,
+) {
+	feedbacks, err := mockStorage.ListFeedbacksForCluster(clusterName)
 	helpers.FailOnError(t, err)
+	assert.Len(t, feedbacks, expected)
+}
 
-	assert.Equal(t, testdata.Report3Rules, report)
-	assert.Equal(t, types.Timestamp(testdata.LastCheckedAt.Format(time.RFC3339)), lastCheckedAt)
+func TestDBStorageDeleteReportsForOrgDeletesFeedbackAndAcks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeFeedbackInTwoOrgs(t, mockStorage)
+
+		deleted, err := mockStorage.DeleteReportsForOrg(testdata.OrgID)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, 1, deleted.Reports)
+		assert.Equal(t, 2, deleted.Feedback)
+		assert.Equal(t, 1, deleted.FeedbackMessages)
+		assert.Equal(t, 1, deleted.RuleAcks)
+
+		assertFeedbacksForCluster(t, mockStorage, testdata.ClusterName, 0)
+		assertFeedbacksForCluster(t, mockStorage, otherOrgClusterName, 2)
+
+		acked, err := mockStorage.IsRuleAckedForOrg(testdata.OrgID, testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.False(t, acked)
+
+		acked, err = mockStorage.IsRuleAckedForOrg(otherOrgID, testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.True(t, acked)
+
+		assertNumberOfReports(t, mockStorage, 1)
+	})
 }
 
-func TestDBStorage_CheckIfClusterExists_ClusterDoesNotExist(t *testing.T) {
+func TestDBStorageDeleteReportsForClusterDeletesFeedback(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeFeedbackInTwoOrgs(t, mockStorage)
+
+		deleted, err := mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, storage.DeletedRows{Reports: 1, Feedback: 2, FeedbackMessages: 1}, deleted)
+
+		assertFeedbacksForCluster(t, mockStorage, testdata.ClusterName, 0)
+		assertFeedbacksForCluster(t, mockStorage, otherOrgClusterName, 2)
+
+		// acks belong to the organization, not to the cluster
+		acked, err := mockStorage.IsRuleAckedForOrg(testdata.OrgID, testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.True(t, acked)
+	})
+}
+
+// assertClusterHasNoRuleToggle checks that no rule toggle of the cluster survived
+// its deletion, so a cluster re-registered under the same UUID starts clean
+func assertClusterHasNoRuleToggle(t *testing.T, mockStorage storage.Storage, clusterName types.ClusterName) {
+	disabledRules, err := mockStorage.GetDisabledRulesForCluster(testdata.OrgID, clusterName)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, disabledRules)
+
+	err = mockStorage.DeleteRuleToggleForCluster(clusterName, testdata.Rule1ID)
+	assertItemNotFound(t, err, storage.ItemKindToggle, clusterName, testdata.Rule1ID)
+}
+
+func TestDBStorageDeleteReportsDeletesRuleToggles(t *testing.T) {
+	for name, deleteReports := range map[string]func(storage.Storage) (int, error){
+		"cluster": func(mockStorage storage.Storage) (int, error) {
+			deleted, err := mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+			return deleted.Toggles, err
+		},
+		"org": func(mockStorage storage.Storage) (int, error) {
+			deleted, err := mockStorage.DeleteReportsForOrg(testdata.OrgID)
+			return deleted.Toggles, err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+				mustWriteReport3Rules(t, mockStorage)
+
+				err := mockStorage.ToggleRuleForCluster(
+					testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+				)
+				helpers.FailOnError(t, err)
+
+				deletedToggles, err := deleteReports(mockStorage)
+				helpers.FailOnError(t, err)
+				assert.Equal(t, 1, deletedToggles)
+
+				assertClusterHasNoRuleToggle(t, mockStorage, testdata.ClusterName)
+			})
+		})
+	}
+}
+
+func TestDBStorageDeleteReportsForOrgDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
-	defer helpers.MustCloseStorage(t, mockStorage)
+	helpers.MustCloseStorage(t, mockStorage)
 
-	_, _, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
-	assert.EqualError(
-		t,
-		err,
-		fmt.Sprintf("Item with ID %v was not found in the storage", testdata.ClusterName),
-	)
+	_, err := mockStorage.DeleteReportsForOrg(testdata.OrgID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+// TestDBStorageReadStoredReport checks that the time of the last check, the time when the report
+// was stored and the number of rules hit by the report are read back with the report
+func TestDBStorageReadStoredReport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writtenAt := time.Now()
+		mustWriteReport3Rules(t, mockStorage)
+
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+		assert.Equal(t, testdata.LastCheckedAt.UTC(), storedReport.LastCheckedAt)
+		assert.WithinDuration(t, writtenAt, storedReport.ReportedAt, time.Minute)
+		assert.Equal(t, 3, storedReport.Count)
+
+		storedReportByClusterName, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, storedReport, storedReportByClusterName)
+	})
+}
+
+// TestDBStorageReadStoredReportNoRuleHit checks that the report without any rule hit has zero count
+func TestDBStorageReadStoredReportNoRuleHit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report0Rules)
+
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 0, storedReport.Count)
+	})
+}
+
+func TestDBStorage_ReadReportForClusterByClusterName_OK(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		storedReport, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+		assert.Equal(t, testdata.LastCheckedAt.UTC(), storedReport.LastCheckedAt)
+	})
+}
+
+func TestDBStorage_CheckIfClusterExists_ClusterDoesNotExist(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		_, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.ClusterName)
+	})
 }
 
 func TestDBStorage_CheckIfClusterExists_DBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	helpers.MustCloseStorage(t, mockStorage)
 
-	_, _, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+	_, err := mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
 	assert.EqualError(t, err, "sql: database is closed")
 }
 
 func TestDBStorage_CheckIfRuleExists_OK(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+
+		rule, err := mockStorage.GetRuleByID(testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, &testdata.Rule1, rule)
+	})
+}
+
+func TestDBStorage_CheckIfRuleExists_ClusterDoesNotExist(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		_, err := mockStorage.GetRuleByID(testdata.Rule1ID)
+		assertItemNotFound(t, err, storage.ItemKindRule, testdata.Rule1ID)
+	})
+}
+
+func TestDBStorage_CheckIfRuleExists_DBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.GetRuleByID(testdata.Rule1ID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorage_NewSQLite(t *testing.T) {
+	_, err := storage.New(storage.Configuration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: ":memory:",
+	})
+	helpers.FailOnError(t, err)
+}
+
+func TestDBStorageWriteReportForClusterInvalidJSON(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, "not-json", testdata.LastCheckedAt, types.UnknownKafkaOffset,
+		)
+		if _, ok := err.(*storage.InvalidReportError); !ok {
+			t.Fatalf("expected InvalidReportError, got %T, %+v", err, err)
+		}
+
+		assertNumberOfReports(t, mockStorage, 0)
+	})
+}
+
+func TestDBStorageGetClustersHittingRule(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		const otherClusterName = types.ClusterName("4016d01b-62a1-4b49-a36e-c1c5a3d02750")
+
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+		writeReportForCluster(t, mockStorage, testdata.OrgID, otherClusterName, testdata.Report0Rules)
+
+		clusters, err := mockStorage.GetClustersHittingRule(testdata.Rule1ID)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterName{testdata.ClusterName}, clusters)
+
+		clusters, err = mockStorage.GetClustersHittingRule("not.hit.rule")
+		helpers.FailOnError(t, err)
+		assert.Empty(t, clusters)
+	})
+}
+
+func TestDBStorageGetClustersHittingRuleFakePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expectClustersHittingRuleJSONBQuery(expects).
+		WillReturnRows(sqlmock.NewRows([]string{"cluster", "report"}).AddRow(string(testdata.ClusterName), nil))
+
+	clusters, err := mockStorage.GetClustersHittingRule(testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{testdata.ClusterName}, clusters)
+}
+
+// TestDBStorageGetClustersHittingRuleCompressedFakePostgres checks that compressed reports,
+// which can't be searched by JSONB containment, are searched after they're read
+func TestDBStorageGetClustersHittingRuleCompressedFakePostgres(t *testing.T) {
+	const otherClusterName = types.ClusterName("4016d01b-62a1-4b49-a36e-c1c5a3d02750")
+
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+	storage.SetCompressReports(mockStorage.(*storage.DBStorage), true)
+
+	compressedHit, err := storage.CompressReport(testdata.Report3Rules)
+	helpers.FailOnError(t, err)
+	compressedNoHit, err := storage.CompressReport(testdata.Report0Rules)
+	helpers.FailOnError(t, err)
+
+	expectClustersHittingRuleJSONBQuery(expects).
+		WillReturnRows(sqlmock.NewRows([]string{"cluster", "report"}).
+			AddRow(string(otherClusterName), string(compressedNoHit)).
+			AddRow(string(testdata.ClusterName), string(compressedHit)))
+
+	clusters, err := mockStorage.GetClustersHittingRule(testdata.Rule1ID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.ClusterName{testdata.ClusterName}, clusters)
+}
+
+func expectClustersHittingRuleJSONBQuery(expects *helpers.StrictExpects) *sqlmock.ExpectedQuery {
+	return expects.ExpectQueryWithArgs(
+		`SELECT cluster, CASE WHEN jsonb_typeof(report) = 'string' THEN report::text END FROM report
+		 WHERE (report @> $1::jsonb OR jsonb_typeof(report) = 'string') AND deleted_at IS NULL
+		 ORDER BY cluster`,
+		`{"reports":[{"component":"`+string(testdata.Rule1ID)+`.report"}]}`,
+	)
+}
+
+func TestDBStorageDeleteReportsForClusters(t *testing.T) {
+	const unknownClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		mustWriteReport3Rules(t, mockStorage)
+		writeReportForCluster(t, mockStorage, testdata.OrgID, "4016d01b-62a1-4b49-a36e-c1c5a3d02750", testClusterEmptyReport)
+
+		err := mockStorage.AddOrUpdateFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID, "message")
+		helpers.FailOnError(t, err)
+
+		err = mockStorage.ToggleRuleForCluster(
+			testdata.ClusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+		)
+		helpers.FailOnError(t, err)
+
+		existing, err := mockStorage.GetExistingClusters(
+			[]types.ClusterName{testdata.ClusterName, unknownClusterName},
+		)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterName{testdata.ClusterName}, existing)
+
+		deleted, err := mockStorage.DeleteReportsForClusters(
+			[]types.ClusterName{unknownClusterName, testdata.ClusterName},
+		)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.ClusterName{testdata.ClusterName}, deleted)
+
+		// the report for other cluster is kept
+		assertNumberOfReports(t, mockStorage, 1)
+
+		_, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		if _, ok := err.(*storage.ItemNotFoundError); !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+
+		assertClusterHasNoRuleToggle(t, mockStorage, testdata.ClusterName)
+	})
+}
+
+func TestDBStorageDeleteReportsForClustersDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.DeleteReportsForClusters([]types.ClusterName{testdata.ClusterName})
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func mustGetStorageWithReportHistory(t *testing.T, depth int) storage.Storage {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	storage.SetReportHistoryDepth(mockStorage.(*storage.DBStorage), depth)
+
+	return mockStorage
+}
+
+func writeReportsToHistory(t *testing.T, mockStorage storage.Storage, lastCheckedTimes ...time.Time) {
+	for i, lastCheckedTime := range lastCheckedTimes {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, types.ClusterReport(fmt.Sprintf(`{"report": %d}`, i)), lastCheckedTime,
+			types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+	}
+}
+
+// TestDBStorageReadReportHistoryForCluster checks that the history is returned newest-first
+func TestDBStorageReadReportHistoryForCluster(t *testing.T) {
+	forEachBackendWithReportHistory(t, 10, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportsToHistory(t, mockStorage, time.Unix(10, 0), time.Unix(30, 0), time.Unix(20, 0))
+
+		history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 10)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, []types.ReportHistoryEntry{
+			{Report: `{"report": 1}`, LastCheckedAt: types.NewOptionalTimestamp(time.Unix(30, 0))},
+			{Report: `{"report": 2}`, LastCheckedAt: types.NewOptionalTimestamp(time.Unix(20, 0))},
+			{Report: `{"report": 0}`, LastCheckedAt: types.NewOptionalTimestamp(time.Unix(10, 0))},
+		}, history)
+
+		history, err = mockStorage.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 1)
+		helpers.FailOnError(t, err)
+		assert.Len(t, history, 1)
+		assert.Equal(t, types.ClusterReport(`{"report": 1}`), history[0].Report)
+	})
+}
+
+// TestDBStorageReportHistoryDepth checks that the oldest entries exceeding the history depth are pruned
+func TestDBStorageReportHistoryDepth(t *testing.T) {
+	forEachBackendWithReportHistory(t, 2, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportsToHistory(t, mockStorage, time.Unix(10, 0), time.Unix(20, 0), time.Unix(30, 0), time.Unix(40, 0))
+
+		history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 10)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, []types.ReportHistoryEntry{
+			{Report: `{"report": 3}`, LastCheckedAt: types.NewOptionalTimestamp(time.Unix(40, 0))},
+			{Report: `{"report": 2}`, LastCheckedAt: types.NewOptionalTimestamp(time.Unix(30, 0))},
+		}, history)
+	})
+}
+
+// TestDBStorageReportHistoryOlderReport checks that older report is kept in the history
+// while the main report table still keeps the most recent report
+func TestDBStorageReportHistoryOlderReport(t *testing.T) {
+	forEachBackendWithReportHistory(t, 10, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportsToHistory(t, mockStorage, time.Unix(20, 0), time.Unix(10, 0))
+
+		checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, `{"report": 0}`)
+
+		history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 10)
+		helpers.FailOnError(t, err)
+		assert.Len(t, history, 2)
+		assert.Equal(t, types.ClusterReport(`{"report": 1}`), history[1].Report)
+	})
+}
+
+// TestDBStorageReportHistoryDisabled checks that no history is kept with zero depth
+func TestDBStorageReportHistoryDisabled(t *testing.T) {
+	forEachBackendWithReportHistory(t, 0, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportsToHistory(t, mockStorage, time.Unix(10, 0), time.Unix(20, 0))
+
+		history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 10)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, history)
+	})
+}
+
+// TestDBStorageDeleteReportsForClusterDeletesHistory checks that the history is deleted together with the report
+// TestDBStorageGetHitsCountHistory checks that the latest report of each day is counted
+// and days without reports are zero-filled
+func TestDBStorageGetHitsCountHistory(t *testing.T) {
+	forEachBackendWithReportHistory(t, 10, func(t *testing.T, mockStorage storage.Storage) {
+		now := time.Now().UTC()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+		for _, report := range []struct {
+			lastChecked time.Time
+			report      types.ClusterReport
+		}{
+			// out of the requested history
+			{today.AddDate(0, 0, -10), testdata.Report3Rules},
+			{today.AddDate(0, 0, -4), testdata.Report3Rules},
+			// the later report of the day is counted
+			{today.AddDate(0, 0, -1), testdata.Report3Rules},
+			{today.AddDate(0, 0, -1).Add(time.Hour), testdata.Report0Rules},
+			{today, testdata.Report3Rules},
+		} {
+			helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+				testdata.OrgID, testdata.ClusterName, report.report, report.lastChecked, types.UnknownKafkaOffset,
+			))
+		}
+
+		history, err := mockStorage.GetHitsCountHistory(testdata.ClusterName, 5)
+		helpers.FailOnError(t, err)
+
+		expectedCounts := []int{3, 0, 0, 0, 3}
+		assert.Len(t, history, len(expectedCounts))
+		for i, day := range history {
+			assert.Equal(t, today.AddDate(0, 0, i-4).Format("2006-01-02"), day.Date)
+			assert.Equal(t, expectedCounts[i], day.HitsCount, day.Date)
+		}
+	})
+}
+
+func TestDBStorageGetHitsCountHistoryDays(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		history, err := mockStorage.GetHitsCountHistory(testdata.ClusterName, 1000)
+		helpers.FailOnError(t, err)
+		assert.Len(t, history, storage.MaxHitsCountHistoryDays)
+
+		_, err = mockStorage.GetHitsCountHistory(testdata.ClusterName, 0)
+		assert.EqualError(t, err, "Invalid value of 'days': positive integer expected")
+	})
+}
+
+func TestDBStorageGetHitsCountHistoryDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.GetHitsCountHistory(testdata.ClusterName, 30)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestDBStorageDeleteReportsForClusterDeletesHistory(t *testing.T) {
+	forEachBackendWithReportHistory(t, 10, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportsToHistory(t, mockStorage, time.Unix(10, 0), time.Unix(20, 0))
+
+		_, err := mockStorage.DeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, testdata.ClusterName, 10)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, history)
+	})
+}
+
+// TestDBStorageCleanupReportsBoundary checks that only reports last checked before the cutoff are deleted
+func TestDBStorageCleanupReportsBoundary(t *testing.T) {
+	const (
+		oldClusterName      = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+		boundaryClusterName = types.ClusterName("8083c377-8a05-4922-af8d-e7d0970c1f49")
+	)
+
+	mockStorage := mustGetStorageWithReportHistory(t, 10)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	mustWriteReport3Rules(t, mockStorage)
+	cutoff := time.Now().Add(-time.Hour)
 
-	rule, err := mockStorage.GetRuleByID(testdata.Rule1ID)
+	for clusterName, lastChecked := range map[types.ClusterName]time.Time{
+		oldClusterName:       cutoff.Add(-time.Second),
+		boundaryClusterName:  cutoff,
+		testdata.ClusterName: time.Now(),
+	} {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, clusterName, testdata.Report3Rules, lastChecked, types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+	}
+
+	deleted, err := storage.CleanupReportsCheckedBefore(mockStorage.(*storage.DBStorage), cutoff)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	existing, err := mockStorage.GetExistingClusters(
+		[]types.ClusterName{oldClusterName, boundaryClusterName, testdata.ClusterName},
+	)
 	helpers.FailOnError(t, err)
+	assert.ElementsMatch(t, []types.ClusterName{boundaryClusterName, testdata.ClusterName}, existing)
 
-	assert.Equal(t, &testdata.Rule1, rule)
+	history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, oldClusterName, 10)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, history)
 }
 
-func TestDBStorage_CheckIfRuleExists_ClusterDoesNotExist(t *testing.T) {
+// TestDBStorageCleanupOldReports checks that recent reports and their feedback survive the cleanup
+func TestDBStorageCleanupOldReports(t *testing.T) {
+	const oldClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.LoadRuleContent(testdata.RuleContent3Rules)
+		helpers.FailOnError(t, err)
+
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+		err = mockStorage.WriteReportForCluster(
+			testdata.OrgID, oldClusterName, testdata.Report3Rules, time.Now().Add(-48*time.Hour), types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		for _, clusterName := range []types.ClusterName{testdata.ClusterName, oldClusterName} {
+			err = mockStorage.AddOrUpdateFeedbackOnRule(clusterName, testdata.Rule1ID, "", testdata.UserID, "message")
+			helpers.FailOnError(t, err)
+
+			err = mockStorage.ToggleRuleForCluster(
+				clusterName, testdata.Rule1ID, testdata.UserID, storage.RuleToggleDisable, "",
+			)
+			helpers.FailOnError(t, err)
+		}
+
+		deleted, err := mockStorage.CleanupOldReports(24 * time.Hour)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		assertNumberOfReports(t, mockStorage, 1)
+
+		_, err = mockStorage.GetUserFeedbackOnRule(testdata.ClusterName, testdata.Rule1ID, "", testdata.UserID)
+		helpers.FailOnError(t, err)
+
+		_, err = mockStorage.GetUserFeedbackOnRule(oldClusterName, testdata.Rule1ID, "", testdata.UserID)
+		if _, ok := err.(*storage.ItemNotFoundError); !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+
+		disabledRules, err := mockStorage.GetDisabledRulesForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleID{testdata.Rule1ID}, disabledRules)
+
+		assertClusterHasNoRuleToggle(t, mockStorage, oldClusterName)
+	})
+}
+
+func TestDBStorageCleanupOldReportsDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.CleanupOldReports(time.Hour)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+// mustCollectReportsCheckedBefore returns all reports passed by ForEachReportCheckedBefore
+func mustCollectReportsCheckedBefore(
+	t *testing.T, mockStorage storage.Storage, cutoff time.Time,
+) []types.ArchivedReport {
+	var reports []types.ArchivedReport
+
+	err := mockStorage.ForEachReportCheckedBefore(cutoff, func(report types.ArchivedReport) error {
+		reports = append(reports, report)
+		return nil
+	})
+	helpers.FailOnError(t, err)
+
+	return reports
+}
+
+// TestDBStorageForEachReportCheckedBefore checks that old reports are passed together with their history
+func TestDBStorageForEachReportCheckedBefore(t *testing.T) {
+	const recentClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	mockStorage := mustGetStorageWithReportHistory(t, 10)
 	defer helpers.MustCloseStorage(t, mockStorage)
 
-	_, err := mockStorage.GetRuleByID(testdata.Rule1ID)
-	assert.EqualError(
-		t,
-		err,
-		fmt.Sprintf("Item with ID %v was not found in the storage", testdata.Rule1ID),
+	storage.SetCompressReports(mockStorage.(*storage.DBStorage), true)
+
+	writeReportsToHistory(t, mockStorage, time.Unix(10, 0), time.Unix(20, 0))
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, recentClusterName, testdata.Report3Rules, time.Unix(40, 0), types.UnknownKafkaOffset,
 	)
+	helpers.FailOnError(t, err)
+
+	reports := mustCollectReportsCheckedBefore(t, mockStorage, time.Unix(30, 0))
+
+	assert.Equal(t, []types.ArchivedReport{{
+		OrgID:         testdata.OrgID,
+		ClusterName:   testdata.ClusterName,
+		Report:        `{"report": 1}`,
+		LastCheckedAt: types.NewOptionalTimestamp(time.Unix(20, 0)),
+		History: []types.ReportHistoryEntry{
+			{Report: `{"report": 1}`, LastCheckedAt: types.NewOptionalTimestamp(time.Unix(20, 0))},
+			{Report: `{"report": 0}`, LastCheckedAt: types.NewOptionalTimestamp(time.Unix(10, 0))},
+		},
+	}}, reports)
 }
 
-func TestDBStorage_CheckIfRuleExists_DBError(t *testing.T) {
+func TestDBStorageForEachReportCheckedBeforeNoHistory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, time.Unix(10, 0), types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+
+		reports := mustCollectReportsCheckedBefore(t, mockStorage, time.Unix(30, 0))
+
+		assert.Len(t, reports, 1)
+		assert.Equal(t, testdata.Report3Rules, reports[0].Report)
+		assert.Equal(t, []types.ReportHistoryEntry{}, reports[0].History)
+	})
+}
+
+// TestDBStorageForEachReportCheckedBeforeOrder checks that reports are passed ordered by organization
+// and cluster, each with its own history, and that an error of the process function stops the iteration
+func TestDBStorageForEachReportCheckedBeforeOrder(t *testing.T) {
+	const otherClusterName = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+
+	mockStorage := mustGetStorageWithReportHistory(t, 10)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	for _, key := range []storage.ReportKey{
+		{OrgID: otherOrgID, ClusterName: otherOrgClusterName},
+		{OrgID: testdata.OrgID, ClusterName: testdata.ClusterName},
+		{OrgID: testdata.OrgID, ClusterName: otherClusterName},
+	} {
+		err := mockStorage.WriteReportForCluster(
+			key.OrgID, key.ClusterName, testdata.Report0Rules, time.Unix(10, 0), types.UnknownKafkaOffset,
+		)
+		helpers.FailOnError(t, err)
+	}
+
+	var keys []storage.ReportKey
+	for _, report := range mustCollectReportsCheckedBefore(t, mockStorage, time.Unix(30, 0)) {
+		keys = append(keys, storage.ReportKey{OrgID: report.OrgID, ClusterName: report.ClusterName})
+		assert.Len(t, report.History, 1)
+	}
+	assert.Equal(t, []storage.ReportKey{
+		{OrgID: testdata.OrgID, ClusterName: otherClusterName},
+		{OrgID: testdata.OrgID, ClusterName: testdata.ClusterName},
+		{OrgID: otherOrgID, ClusterName: otherOrgClusterName},
+	}, keys)
+
+	processed := 0
+	err := mockStorage.ForEachReportCheckedBefore(time.Unix(30, 0), func(types.ArchivedReport) error {
+		processed++
+		return errors.New("process error")
+	})
+	assert.EqualError(t, err, "process error")
+	assert.Equal(t, 1, processed)
+}
+
+func TestDBStorageForEachReportCheckedBeforeDBError(t *testing.T) {
 	mockStorage := helpers.MustGetMockStorage(t, true)
 	helpers.MustCloseStorage(t, mockStorage)
 
-	_, err := mockStorage.GetRuleByID(testdata.Rule1ID)
+	err := mockStorage.ForEachReportCheckedBefore(time.Now(), func(types.ArchivedReport) error {
+		return nil
+	})
 	assert.EqualError(t, err, "sql: database is closed")
 }
 
-func TestDBStorage_NewSQLite(t *testing.T) {
-	_, err := storage.New(storage.Configuration{
-		Driver:           "sqlite3",
-		SQLiteDataSource: ":memory:",
+// TestDBStorageCleanupClustersCheckedBefore checks that only specified clusters not checked since the cutoff
+// are deleted
+func TestDBStorageCleanupClustersCheckedBefore(t *testing.T) {
+	const (
+		oldClusterName          = types.ClusterName("52ab955f-b769-444d-8170-4b676c5d3c85")
+		notSpecifiedClusterName = types.ClusterName("8083c377-8a05-4922-af8d-e7d0970c1f49")
+	)
+
+	forEachBackendWithReportHistory(t, 10, func(t *testing.T, mockStorage storage.Storage) {
+		cutoff := time.Now().Add(-time.Hour)
+
+		for clusterName, lastChecked := range map[types.ClusterName]time.Time{
+			oldClusterName:          cutoff.Add(-time.Second),
+			notSpecifiedClusterName: cutoff.Add(-time.Second),
+			testdata.ClusterName:    time.Now(),
+		} {
+			err := mockStorage.WriteReportForCluster(
+				testdata.OrgID, clusterName, testdata.Report3Rules, lastChecked, types.UnknownKafkaOffset,
+			)
+			helpers.FailOnError(t, err)
+		}
+
+		// the recent report of testdata.ClusterName has to be kept even if it's specified
+		deleted, err := mockStorage.CleanupClustersCheckedBefore(
+			cutoff, []types.ClusterName{oldClusterName, testdata.ClusterName},
+		)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		existing, err := mockStorage.GetExistingClusters(
+			[]types.ClusterName{oldClusterName, notSpecifiedClusterName, testdata.ClusterName},
+		)
+		helpers.FailOnError(t, err)
+		assert.ElementsMatch(t, []types.ClusterName{notSpecifiedClusterName, testdata.ClusterName}, existing)
+
+		history, err := mockStorage.ReadReportHistoryForCluster(testdata.OrgID, oldClusterName, 10)
+		helpers.FailOnError(t, err)
+		assert.Empty(t, history)
+	})
+}
+
+func TestDBStorageCleanupClustersCheckedBeforeNoClusters(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	deleted, err := mockStorage.CleanupClustersCheckedBefore(time.Now(), []types.ClusterName{})
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, deleted)
+}
+
+// TestDBStorageGetRuleHitsForCluster checks that rule hits are stored together with the report
+// and replaced by the next report
+func TestDBStorageGetRuleHitsForCluster(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+		ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleOnReport{
+			{Module: string(testdata.Rule1ID) + ".report", ErrorKey: testdata.ErrorKey1},
+			{Module: string(testdata.Rule2ID) + ".report", ErrorKey: testdata.ErrorKey2},
+			{Module: string(testdata.Rule3ID) + ".report", ErrorKey: testdata.ErrorKey3},
+		}, ruleHits)
+
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, `{
+			"reports": [{"component": "test.rule2.report", "key": "ek2", "details": {"nodes": ["node1"]}}]
+		}`)
+
+		ruleHits, err = mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, []types.RuleOnReport{
+			{
+				Module:       "test.rule2.report",
+				ErrorKey:     "ek2",
+				TemplateData: map[string]interface{}{"nodes": []interface{}{"node1"}},
+			},
+		}, ruleHits)
+
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report0Rules)
+
+		ruleHits, err = mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.NotNil(t, ruleHits)
+		assert.Empty(t, ruleHits)
+	})
+}
+
+// TestDBStorageGetRuleHitsForClusterRepeatedRuleHit checks that the last of repeated
+// hits of the same rule and error key is stored, like when the hits are written one by one
+func TestDBStorageGetRuleHitsForClusterRepeatedRuleHit(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, `{
+		"reports": [
+			{"component": "test.rule2.report", "key": "ek2", "details": {"nodes": ["node1"]}},
+			{"component": "test.rule1.report", "key": "ek1", "details": null},
+			{"component": "test.rule2.report", "key": "ek2", "details": {"nodes": ["node2"]}}
+		]
+	}`)
+
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []types.RuleOnReport{
+		{Module: "test.rule1.report", ErrorKey: "ek1"},
+		{
+			Module:       "test.rule2.report",
+			ErrorKey:     "ek2",
+			TemplateData: map[string]interface{}{"nodes": []interface{}{"node2"}},
+		},
+	}, ruleHits)
+}
+
+// TestDBStorageGetRuleHitsForClusterNoReport checks that ItemNotFoundError is returned
+// for cluster without any report
+func TestDBStorageGetRuleHitsForClusterNoReport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		_, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		if _, ok := err.(*storage.ItemNotFoundError); !ok {
+			t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+		}
+	})
+}
+
+func TestDBStorageReadReportMetainfo(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, 5,
+		))
+
+		metainfo, err := mockStorage.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.True(t, testdata.LastCheckedAt.Equal(metainfo.LastCheckedAt.Time))
+		assert.NotNil(t, metainfo.ReportedAt)
+		assert.Equal(t, types.KafkaOffset(5), metainfo.KafkaOffset)
+		assert.Equal(t, 3, metainfo.RuleHitsCount)
+
+		// the report not consumed from Kafka has no offset
+		helpers.FailOnError(t, mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, testdata.Report0Rules, testdata.LastCheckedAt.Add(time.Hour),
+			types.UnknownKafkaOffset,
+		))
+
+		metainfo, err = mockStorage.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, types.UnknownKafkaOffset, metainfo.KafkaOffset)
+		assert.Equal(t, 0, metainfo.RuleHitsCount)
+	})
+}
+
+// TestDBStorageReadReportMetainfoLegacyWriteMode checks that rules hit are counted in the report
+// when they are not written into rule_hit table
+func TestDBStorageReadReportMetainfoLegacyWriteMode(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	storage.SetReadWriteModes(mockStorage.(*storage.DBStorage), storage.WriteModeLegacy, storage.ReadSourceReport, 0)
+	writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+	metainfo, err := mockStorage.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 3, metainfo.RuleHitsCount)
+}
+
+func TestDBStorageReadReportMetainfoNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		_, err := mockStorage.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+
+		// soft-deleted report is not found either
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+		_, err = mockStorage.SoftDeleteReportsForCluster(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		_, err = mockStorage.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
+		assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
 	})
+}
+
+// TestDBStorageReadReportMetainfoFakePostgres checks that the report itself is not read
+func TestDBStorageReadReportMetainfoFakePostgres(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithStrictExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	expects.ExpectQueryWithArgs(`
+		SELECT reported_at, last_checked_at, kafka_offset,
+			(SELECT COUNT(*) FROM rule_hit WHERE rule_hit.org_id = report.org_id AND rule_hit.cluster = report.cluster)
+		FROM report
+		WHERE org_id = $1 AND cluster = $2 AND deleted_at IS NULL`,
+		testdata.OrgID, testdata.ClusterName,
+	).WillReturnRows(
+		sqlmock.NewRows([]string{"reported_at", "last_checked_at", "kafka_offset", "count"}).
+			AddRow(testdata.LastCheckedAt, testdata.LastCheckedAt, nil, 2),
+	).RowsWillBeClosed()
+
+	metainfo, err := mockStorage.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
 	helpers.FailOnError(t, err)
+	assert.Equal(t, &types.ReportMetainfo{
+		ReportedAt:    types.NewOptionalTimestamp(testdata.LastCheckedAt),
+		LastCheckedAt: types.NewOptionalTimestamp(testdata.LastCheckedAt),
+		KafkaOffset:   types.UnknownKafkaOffset,
+		RuleHitsCount: 2,
+	}, metainfo)
+}
+
+func TestDBStorageReadReportMetainfoDBError(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	helpers.MustCloseStorage(t, mockStorage)
+
+	_, err := mockStorage.ReadReportMetainfo(testdata.OrgID, testdata.ClusterName)
+	expectErrorClosedStorage(t, err)
+}
+
+// TestDBStorageWriteReportForClusterMalformedHits checks that report with malformed
+// rule hits is not stored at all
+func TestDBStorageWriteReportForClusterMalformedHits(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, mockStorage storage.Storage) {
+		writeReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+		err := mockStorage.WriteReportForCluster(
+			testdata.OrgID, testdata.ClusterName, `{"reports": [{"component": 42}]}`, time.Now(), types.UnknownKafkaOffset,
+		)
+		if _, ok := err.(*storage.InvalidReportError); !ok {
+			t.Fatalf("expected InvalidReportError, got %T, %+v", err, err)
+		}
+
+		checkReportForCluster(t, mockStorage, testdata.OrgID, testdata.ClusterName, testdata.Report3Rules)
+
+		ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Len(t, ruleHits, 3)
+	})
+}
+
+// benchmarkReportVariants returns two different reports of each of the benchmarked clusters,
+// so the report written next for the same cluster is never identical to the stored one
+func benchmarkReportVariants() [2][]types.ClusterReport {
+	var variants [2][]types.ClusterReport
+	for variant := range variants {
+		variants[variant] = make([]types.ClusterReport, benchmarkReportsCount)
+		for i := range variants[variant] {
+			variants[variant][i] = syntheticReport(variant*benchmarkReportsCount+i, 5)
+		}
+	}
+
+	return variants
+}
+
+// mustGetBenchmarkStorage returns SQLite storage with single connection, so all writes
+// share the same in-memory database even when they are concurrent
+func mustGetBenchmarkStorage(b *testing.B) storage.Storage {
+	mockStorage, err := helpers.GetMockStorage(true)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	storage.GetConnection(mockStorage.(*storage.DBStorage)).SetMaxOpenConns(1)
+
+	return mockStorage
+}
+
+// writeBenchmarkReport writes the n-th report of the benchmark, the reports are written
+// repeatedly for the same clusters with newer time of the last check and newer Kafka offset
+func writeBenchmarkReport(mockStorage storage.Storage, variants [2][]types.ClusterReport, n int) error {
+	i := n % benchmarkReportsCount
+	variant := (n / benchmarkReportsCount) % len(variants)
+
+	return mockStorage.WriteReportForCluster(
+		testdata.OrgID, benchmarkClusterName(i), variants[variant][i],
+		testdata.LastCheckedAt.Add(time.Duration(n)*time.Second), types.KafkaOffset(n),
+	)
+}
+
+// BenchmarkWriteReportForCluster measures writing of reports with rule hits one by one
+func BenchmarkWriteReportForCluster(b *testing.B) {
+	variants := benchmarkReportVariants()
+
+	mockStorage := mustGetBenchmarkStorage(b)
+	defer func() {
+		if err := mockStorage.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := writeBenchmarkReport(mockStorage, variants, n); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteReportConcurrent measures writing of reports with rule hits from concurrent goroutines
+func BenchmarkWriteReportConcurrent(b *testing.B) {
+	variants := benchmarkReportVariants()
+
+	mockStorage := mustGetBenchmarkStorage(b)
+	defer func() {
+		if err := mockStorage.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+
+	var written int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := int(atomic.AddInt64(&written, 1) - 1)
+			if err := writeBenchmarkReport(mockStorage, variants, n); err != nil && err != storage.ErrOldReport {
+				b.Error(err)
+				return
+			}
+		}
+	})
 }
@@ -0,0 +1,143 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// Default timeouts of classes of storage operations used when they are not configured,
+// they are long enough not to interrupt any operation finishing in a reasonable time
+const (
+	DefaultFastReadTimeout         = time.Minute
+	DefaultHeavyAggregationTimeout = 10 * time.Minute
+	DefaultWriteTimeout            = 2 * time.Minute
+	DefaultMaintenanceTimeout      = time.Hour
+)
+
+// operationClass groups storage operations with similar expected duration,
+// each class has its own timeout
+type operationClass int
+
+const (
+	// fastRead are lookups of data of a single organization, cluster or rule
+	fastRead operationClass = iota
+	// heavyAggregation are reads going through reports or feedback of all organizations
+	heavyAggregation
+	// write stores reports, users' feedback and acknowledgements of rules
+	write
+	// maintenance deletes data and loads rule content
+	maintenance
+)
+
+// operationTimeouts holds the timeout of each class of storage operations
+type operationTimeouts map[operationClass]time.Duration
+
+// defaultOperationTimeouts returns default timeouts of all classes of storage operations
+func defaultOperationTimeouts() operationTimeouts {
+	return operationTimeouts{
+		fastRead:         DefaultFastReadTimeout,
+		heavyAggregation: DefaultHeavyAggregationTimeout,
+		write:            DefaultWriteTimeout,
+		maintenance:      DefaultMaintenanceTimeout,
+	}
+}
+
+// operation is a single call of a storage method limited by the timeout of its class,
+// the organization and the cluster are set only for operations working with them
+type operation struct {
+	name               string
+	timeout            time.Duration
+	started            time.Time
+	slowQueryThreshold time.Duration
+	driver             DBDriver
+	orgID              *types.OrgID
+	clusterName        *types.ClusterName
+	tracer             Tracer
+	ctx                context.Context
+	cancel             context.CancelFunc
+}
+
+// startOperation starts the storage operation, the context of the returned operation
+// has to be used for all queries of the operation and the operation has to be finished.
+// The context is derived from the context of the storage set by WithContext.
+func (storage DBStorage) startOperation(name string, class operationClass) *operation {
+	parent := storage.parentCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if storage.tracer != nil {
+		parent = storage.tracer.StartOperation(parent, name)
+	}
+
+	timeout := storage.timeouts[class]
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	return &operation{
+		name:               name,
+		timeout:            timeout,
+		started:            time.Now(),
+		slowQueryThreshold: storage.slowQueryThreshold,
+		driver:             storage.dbDriverType,
+		tracer:             storage.tracer,
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+}
+
+// finish releases resources of the operation and replaces its error by QueryTimeoutError
+// when the operation has failed because its deadline has been exceeded.
+// Duration of the operation and its failure are recorded in metrics, slow operations are logged.
+func (op *operation) finish(err *error) {
+	op.cancel()
+
+	if *err != nil && op.ctx.Err() == context.DeadlineExceeded {
+		*err = &QueryTimeoutError{Operation: op.name, Timeout: op.timeout}
+	}
+
+	if op.tracer != nil {
+		op.tracer.EndOperation(op.ctx, op.name, *err)
+	}
+
+	duration := time.Since(op.started)
+	op.logIfSlow(duration)
+
+	metrics.SQLQueryDuration.WithLabelValues(op.name).Observe(duration.Seconds())
+	if isQueryError(*err) {
+		metrics.SQLQueryErrors.WithLabelValues(op.name).Inc()
+	}
+}
+
+// isQueryError checks whether the operation failed because of the database,
+// errors of invalid input and not found items are not caused by queries
+func isQueryError(err error) bool {
+	if errors.Is(err, ErrOldReport) || errors.Is(err, ErrNotFound) {
+		return false
+	}
+
+	switch err.(type) {
+	case nil, *ValidationError, *InvalidReportError:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/sqlhooks"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+const (
+	slowQueriesDriverName = "sqlite3WithSlowQueriesTest"
+	slowQueryDuration     = 200 * time.Millisecond
+	shortTimeout          = 20 * time.Millisecond
+)
+
+// slowQueryHooks makes SQLite queries containing slowQuery take slowQueryDuration,
+// the query fails when its context is done earlier
+type slowQueryHooks struct {
+	slowQuery string
+}
+
+func (h *slowQueryHooks) Before(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	if len(h.slowQuery) == 0 || !strings.Contains(query, h.slowQuery) {
+		return ctx, nil
+	}
+
+	select {
+	case <-time.After(slowQueryDuration):
+		return ctx, nil
+	case <-ctx.Done():
+		return ctx, ctx.Err()
+	}
+}
+
+func (h *slowQueryHooks) After(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	return ctx, nil
+}
+
+var slowHooks = &slowQueryHooks{}
+
+func init() {
+	sql.Register(slowQueriesDriverName, sqlhooks.Wrap(&sqlite3.SQLiteDriver{}, slowHooks))
+}
+
+// mustGetStorageWithSlowQuery returns initialized SQLite storage with the report of the cluster
+// in which queries containing slowQuery are slow
+func mustGetStorageWithSlowQuery(t *testing.T, slowQuery string) *storage.DBStorage {
+	slowHooks.slowQuery = ""
+
+	connection, err := sql.Open(slowQueriesDriverName, ":memory:")
+	helpers.FailOnError(t, err)
+
+	dbStorage := storage.NewFromConnection(connection, storage.DBDriverSQLite3)
+	helpers.FailOnError(t, dbStorage.Init())
+
+	err = dbStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	slowHooks.slowQuery = slowQuery
+
+	return dbStorage
+}
+
+var operationsOfClasses = []struct {
+	class     storage.OperationClass
+	name      string
+	slowQuery string
+	run       func(dbStorage *storage.DBStorage) error
+}{
+	{storage.FastRead, "ReadReportForCluster", "SELECT report, reported_at, last_checked_at FROM report",
+		func(dbStorage *storage.DBStorage) error {
+			_, err := dbStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+			return err
+		}},
+	{storage.HeavyAggregation, "GetOrgStatistics", "MIN(last_checked_at)",
+		func(dbStorage *storage.DBStorage) error {
+			_, err := dbStorage.GetOrgStatistics(testdata.OrgID)
+			return err
+		}},
+	{storage.Write, "WriteReportForCluster", "INTO report(",
+		func(dbStorage *storage.DBStorage) error {
+			return dbStorage.WriteReportForCluster(
+				testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, time.Now(), types.UnknownKafkaOffset,
+			)
+		}},
+	{storage.Maintenance, "DeleteReportsForCluster", "DELETE FROM report WHERE",
+		func(dbStorage *storage.DBStorage) error {
+			_, err := dbStorage.DeleteReportsForCluster(testdata.ClusterName)
+			return err
+		}},
+}
+
+func TestDBStorageOperationTimeout(t *testing.T) {
+	for _, operation := range operationsOfClasses {
+		t.Run(operation.name, func(t *testing.T) {
+			dbStorage := mustGetStorageWithSlowQuery(t, operation.slowQuery)
+			defer helpers.MustCloseStorage(t, dbStorage)
+
+			storage.SetOperationTimeout(dbStorage, operation.class, shortTimeout)
+
+			err := operation.run(dbStorage)
+			assert.Equal(t, &storage.QueryTimeoutError{Operation: operation.name, Timeout: shortTimeout}, err)
+			assert.EqualError(
+				t, err, "Storage operation "+operation.name+" has not finished in "+shortTimeout.String(),
+			)
+		})
+	}
+}
+
+// TestDBStorageOperationTimeoutOtherClasses checks that the slow operation
+// is not limited by timeouts of other classes
+func TestDBStorageOperationTimeoutOtherClasses(t *testing.T) {
+	for _, operation := range operationsOfClasses {
+		t.Run(operation.name, func(t *testing.T) {
+			dbStorage := mustGetStorageWithSlowQuery(t, operation.slowQuery)
+			defer helpers.MustCloseStorage(t, dbStorage)
+
+			for _, other := range operationsOfClasses {
+				if other.class != operation.class {
+					storage.SetOperationTimeout(dbStorage, other.class, shortTimeout)
+				}
+			}
+
+			helpers.FailOnError(t, operation.run(dbStorage))
+		})
+	}
+}
+
+func TestNewStorageOperationTimeouts(t *testing.T) {
+	s, err := storage.New(storage.Configuration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: ":memory:",
+		FastReadTimeout:  5 * time.Second,
+		WriteTimeout:     10 * time.Second,
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, s)
+
+	dbStorage := s.(*storage.DBStorage)
+
+	assert.Equal(t, 5*time.Second, storage.GetOperationTimeout(dbStorage, storage.FastRead))
+	assert.Equal(t, 10*time.Second, storage.GetOperationTimeout(dbStorage, storage.Write))
+
+	// default timeouts are used for classes which are not configured
+	assert.Equal(
+		t, storage.DefaultHeavyAggregationTimeout, storage.GetOperationTimeout(dbStorage, storage.HeavyAggregation),
+	)
+	assert.Equal(
+		t, storage.DefaultMaintenanceTimeout, storage.GetOperationTimeout(dbStorage, storage.Maintenance),
+	)
+}
+
+func TestNewStorageQueryTimeout(t *testing.T) {
+	s, err := storage.New(storage.Configuration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: ":memory:",
+		QueryTimeout:     3 * time.Second,
+		WriteTimeout:     10 * time.Second,
+	})
+	helpers.FailOnError(t, err)
+	defer helpers.MustCloseStorage(t, s)
+
+	dbStorage := s.(*storage.DBStorage)
+
+	// the timeout of the class takes precedence over the query timeout
+	assert.Equal(t, 10*time.Second, storage.GetOperationTimeout(dbStorage, storage.Write))
+
+	for _, class := range []storage.OperationClass{storage.FastRead, storage.HeavyAggregation, storage.Maintenance} {
+		assert.Equal(t, 3*time.Second, storage.GetOperationTimeout(dbStorage, class))
+	}
+}
+
+// TestDBStorageQueryTimeoutDelayedQuery checks that the operation waiting for a slow query
+// is interrupted in the configured time
+func TestDBStorageQueryTimeoutDelayedQuery(t *testing.T) {
+	mockStorage, expects := helpers.MustGetMockStorageWithExpects(t)
+	defer helpers.MustCloseMockStorageWithExpects(t, mockStorage, expects)
+
+	storage.SetOperationTimeout(mockStorage.(*storage.DBStorage), storage.FastRead, shortTimeout)
+
+	expects.ExpectQuery("SELECT report, reported_at, last_checked_at FROM report").WillDelayFor(slowQueryDuration)
+
+	started := time.Now()
+	_, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+
+	assert.Equal(t, &storage.QueryTimeoutError{Operation: "ReadReportForCluster", Timeout: shortTimeout}, err)
+	assert.True(t, time.Since(started) < slowQueryDuration, "the query has not been interrupted")
+}
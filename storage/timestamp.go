@@ -0,0 +1,74 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// timestampScanner stores the scanned timestamp into dest in UTC
+type timestampScanner struct {
+	dest *time.Time
+}
+
+// scanTimestamp returns scanner for timestamp columns which yields the same time.Time
+// in UTC for all drivers. PostgreSQL returns timestamps in the location of the connection
+// and SQLite returns them in the location they were written in or even as strings
+// when the type of the column is lost (e.g. for results of aggregate functions).
+// NULL is scanned as zero time, which is served as missing timestamp by types.NewTimestamp.
+func scanTimestamp(dest *time.Time) sql.Scanner {
+	return timestampScanner{dest: dest}
+}
+
+// Scan implements sql.Scanner interface
+func (scanner timestampScanner) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case time.Time:
+		*scanner.dest = value.UTC()
+		return nil
+	case string:
+		return scanner.parse(value)
+	case []byte:
+		return scanner.parse(string(value))
+	case nil:
+		// rows written by older versions or fixed manually can miss timestamps
+		*scanner.dest = time.Time{}
+		return nil
+	default:
+		return fmt.Errorf("unable to scan value of type %T into timestamp", value)
+	}
+}
+
+// parse parses timestamp in any of the formats SQLite uses for storing timestamps,
+// timestamps without time zone are considered to be in UTC
+func (scanner timestampScanner) parse(value string) error {
+	trimmed := strings.TrimSuffix(value, "Z")
+
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if timestamp, err := time.ParseInLocation(format, trimmed, time.UTC); err == nil {
+			*scanner.dest = timestamp.UTC()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unable to parse timestamp '%v'", value)
+}
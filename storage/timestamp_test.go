@@ -0,0 +1,167 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// lastCheckedInUTC is a timestamp with fractional seconds as it should be read from any database
+var lastCheckedInUTC = time.Date(2020, 3, 5, 10, 20, 30, 123456789, time.UTC)
+
+// TestScanTimestamp checks that timestamps in all forms returned by database drivers are scanned
+// into the same time in UTC
+func TestScanTimestamp(t *testing.T) {
+	for _, value := range []interface{}{
+		lastCheckedInUTC,
+		lastCheckedInUTC.In(time.FixedZone("CEST", 2*60*60)),
+		lastCheckedInUTC.In(time.FixedZone("EST", -5*60*60)),
+		"2020-03-05 10:20:30.123456789+00:00",
+		"2020-03-05 12:20:30.123456789+02:00",
+		"2020-03-05T05:20:30.123456789-05:00",
+		"2020-03-05 10:20:30.123456789",
+		"2020-03-05T10:20:30.123456789Z",
+		[]byte("2020-03-05 12:20:30.123456789+02:00"),
+	} {
+		var timestamp time.Time
+
+		helpers.FailOnError(t, storage.ScanTimestamp(&timestamp).Scan(value))
+		assert.Equal(t, lastCheckedInUTC, timestamp, "%v", value)
+	}
+}
+
+func TestScanTimestampInvalidValue(t *testing.T) {
+	var timestamp time.Time
+
+	err := storage.ScanTimestamp(&timestamp).Scan("not a timestamp")
+	assert.EqualError(t, err, "unable to parse timestamp 'not a timestamp'")
+
+	err = storage.ScanTimestamp(&timestamp).Scan(int64(42))
+	assert.EqualError(t, err, "unable to scan value of type int64 into timestamp")
+
+}
+
+func TestScanTimestampNull(t *testing.T) {
+	timestamp := lastCheckedInUTC
+
+	helpers.FailOnError(t, storage.ScanTimestamp(&timestamp).Scan(nil))
+	assert.True(t, timestamp.IsZero())
+	assert.Equal(t, types.Timestamp{}, types.NewTimestamp(timestamp))
+	assert.Nil(t, types.NewOptionalTimestamp(timestamp))
+}
+
+// TestDBStorageReadReportTimestampSameForAllDrivers checks that the timestamp written
+// in any location is read back in UTC by SQLite as well as by PostgreSQL
+func TestDBStorageReadReportTimestampSameForAllDrivers(t *testing.T) {
+	writtenTime := lastCheckedInUTC.In(time.FixedZone("CEST", 2*60*60))
+
+	sqliteStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, sqliteStorage)
+
+	err := sqliteStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, writtenTime, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	// lib/pq returns timestamps in the time zone of the connection
+	postgresStorage, expects := helpers.MustGetMockStorageWithExpectsForDriver(t, storage.DBDriverPostgres)
+	defer helpers.MustCloseMockStorageWithExpects(t, postgresStorage, expects)
+
+	expects.ExpectQuery("SELECT report, reported_at, last_checked_at FROM report").
+		WillReturnRows(sqlmock.NewRows([]string{"report", "reported_at", "last_checked_at"}).
+			AddRow(testdata.Report3Rules, nil, lastCheckedInUTC.In(time.FixedZone("EST", -5*60*60))))
+	expects.ExpectQuery("SELECT count").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	expects.ExpectQuery("SELECT MIN").
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(writtenTime, writtenTime))
+
+	for _, mockStorage := range []storage.Storage{sqliteStorage, postgresStorage} {
+		storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, testdata.Report3Rules, storedReport.Report)
+		assert.Equal(t, lastCheckedInUTC, storedReport.LastCheckedAt)
+
+		stats, err := mockStorage.GetOrgStatistics(testdata.OrgID)
+		helpers.FailOnError(t, err)
+
+		assert.Equal(t, types.OrgStats{
+			ClusterCount:        1,
+			OldestLastCheckedAt: types.NewTimestamp(lastCheckedInUTC),
+			NewestLastCheckedAt: types.NewTimestamp(lastCheckedInUTC),
+		}, stats)
+	}
+}
+
+// TestDBStorageNullTimestamps checks that reports with NULL timestamps written by older versions
+// are read with zero time of the last check and that they are counted by the consistency check
+func TestDBStorageNullTimestamps(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+	connection := storage.GetConnection(mockStorage.(*storage.DBStorage))
+
+	mustWriteReportWithTimestamps(
+		t, connection, testdata.OrgID, testdata.ClusterName, string(testdata.Report0Rules), nil, nil,
+	)
+
+	storedReport, err := mockStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, testdata.Report0Rules, storedReport.Report)
+	assert.True(t, storedReport.LastCheckedAt.IsZero())
+	assert.True(t, storedReport.ReportedAt.IsZero())
+
+	storedReport, err = mockStorage.ReadReportForClusterByClusterName(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.True(t, storedReport.LastCheckedAt.IsZero())
+
+	stats, err := mockStorage.GetOrgStatistics(testdata.OrgID)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, types.OrgStats{ClusterCount: 1}, stats)
+
+	count, err := mockStorage.CountReportsWithNullTimestamps()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, count)
+
+	summary, err := storage.CheckConsistency(context.Background(), mockStorage, 10, false)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 1, summary.Checked)
+	assert.Equal(t, 1, summary.NullTimestamps)
+}
+
+func TestDBStorageCountReportsWithNullTimestampsNone(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	count, err := mockStorage.CountReportsWithNullTimestamps()
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, count)
+}
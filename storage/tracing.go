@@ -0,0 +1,43 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import "context"
+
+// Tracer is notified about the start and the end of each operation of the storage,
+// the operation is named after the method of the storage, like in metrics of durations of queries.
+// Package oteltracing provides the tracer creating spans of OpenTelemetry.
+type Tracer interface {
+	// StartOperation is called before the operation runs any query, the returned context
+	// is used by all queries of the operation and it's passed to EndOperation
+	StartOperation(ctx context.Context, operation string) context.Context
+	// EndOperation is called when the operation finishes with its error, nil when it has succeeded
+	EndOperation(ctx context.Context, operation string, err error)
+}
+
+// SetTracer sets the tracer notified about operations of the storage, nil disables tracing
+func (storage *DBStorage) SetTracer(tracer Tracer) {
+	storage.tracer = tracer
+}
+
+// WithContext returns the storage running its operations in the context, so operations are
+// interrupted when the context is cancelled and the tracer gets values of the context, like its span.
+// The returned storage shares the connection with this one, so it must not be closed.
+func (storage DBStorage) WithContext(ctx context.Context) Storage {
+	storage.parentCtx = ctx
+	return &storage
+}
@@ -0,0 +1,175 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// spanKey is the key of the recorded span in the context of the operation
+type spanKey struct{}
+
+// recordedSpan is the operation recorded by recordingTracer, parent is the span
+// found in the context of the storage when the operation has started
+type recordedSpan struct {
+	name   string
+	parent string
+	err    error
+	ended  bool
+}
+
+// recordingTracer records spans of all operations of the storage
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+func (tracer *recordingTracer) StartOperation(ctx context.Context, operation string) context.Context {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	parent, _ := ctx.Value(spanKey{}).(string)
+	span := &recordedSpan{name: operation, parent: parent}
+	tracer.spans = append(tracer.spans, span)
+
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (tracer *recordingTracer) EndOperation(ctx context.Context, operation string, err error) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	span := ctx.Value(spanKey{}).(*recordedSpan)
+	span.err = err
+	span.ended = true
+}
+
+// mustGetTracedStorage returns SQLite storage reporting its operations to the returned tracer
+func mustGetTracedStorage(t *testing.T) (*storage.DBStorage, *recordingTracer) {
+	dbStorage := helpers.MustGetMockStorage(t, true).(*storage.DBStorage)
+	tracer := &recordingTracer{}
+	dbStorage.SetTracer(tracer)
+
+	return dbStorage, tracer
+}
+
+// TestDBStorageTracerWriteAndRead checks that the write and the read are traced as separate operations
+func TestDBStorageTracerWriteAndRead(t *testing.T) {
+	dbStorage, tracer := mustGetTracedStorage(t)
+	defer helpers.MustCloseStorage(t, dbStorage)
+
+	helpers.FailOnError(t, dbStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	))
+	_, err := dbStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, []*recordedSpan{
+		{name: "WriteReportForCluster", ended: true},
+		{name: "ReadReportForCluster", ended: true},
+	}, tracer.spans)
+}
+
+// TestDBStorageTracerCachedLookup checks that lookups answered by the cache of organizations are traced too
+func TestDBStorageTracerCachedLookup(t *testing.T) {
+	dbStorage, tracer := mustGetTracedStorage(t)
+	defer helpers.MustCloseStorage(t, dbStorage)
+	storage.SetOrgIDCache(dbStorage, 10, 0, time.Now)
+
+	helpers.FailOnError(t, dbStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	))
+	for i := 0; i < 2; i++ {
+		orgID, err := dbStorage.GetOrgIDByClusterID(testdata.ClusterName)
+		helpers.FailOnError(t, err)
+		assert.Equal(t, testdata.OrgID, orgID)
+	}
+	exists, err := dbStorage.DoesClusterExist(testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.True(t, exists)
+
+	assert.Equal(t, []*recordedSpan{
+		{name: "WriteReportForCluster", ended: true},
+		{name: "GetOrgIDByClusterID", ended: true},
+		{name: "GetOrgIDByClusterID", ended: true},
+		{name: "DoesClusterExist", ended: true},
+	}, tracer.spans)
+}
+
+// TestDBStorageTracerFailedOperations checks that errors of the operations are passed to the tracer
+func TestDBStorageTracerFailedOperations(t *testing.T) {
+	dbStorage, tracer := mustGetTracedStorage(t)
+
+	_, err := dbStorage.ReadReportForCluster(testdata.OrgID, testdata.ClusterName)
+	assertItemNotFound(t, err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+
+	helpers.MustCloseStorage(t, dbStorage)
+
+	err = dbStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	expectErrorClosedStorage(t, err)
+
+	if assert.Len(t, tracer.spans, 2) {
+		assert.Equal(t, "ReadReportForCluster", tracer.spans[0].name)
+		assertItemNotFound(t, tracer.spans[0].err, storage.ItemKindReport, testdata.OrgID, testdata.ClusterName)
+		assert.Equal(t, "WriteReportForCluster", tracer.spans[1].name)
+		assert.Equal(t, err, tracer.spans[1].err)
+		assert.True(t, tracer.spans[1].ended)
+	}
+}
+
+// TestDBStorageTracerWithContext checks that operations of the storage with context
+// get the span of the context as their parent and the storage itself is not affected
+func TestDBStorageTracerWithContext(t *testing.T) {
+	dbStorage, tracer := mustGetTracedStorage(t)
+	defer helpers.MustCloseStorage(t, dbStorage)
+
+	requestStorage := dbStorage.WithContext(context.WithValue(context.Background(), spanKey{}, "request"))
+
+	_, err := requestStorage.ReportsCount()
+	helpers.FailOnError(t, err)
+	_, err = dbStorage.ReportsCount()
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, []*recordedSpan{
+		{name: "ReportsCount", parent: "request", ended: true},
+		{name: "ReportsCount", ended: true},
+	}, tracer.spans)
+}
+
+// TestDBStorageWithCancelledContext checks that operations of the storage with cancelled context fail
+func TestDBStorageWithCancelledContext(t *testing.T) {
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := mockStorage.(*storage.DBStorage).WithContext(ctx).ReportsCount()
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+)
+
+// writtenReportsHook counts reports written into the database,
+// outdated and duplicate reports are not counted
+type writtenReportsHook struct{}
+
+// Name identifies the hook in logs and errors
+func (hook writtenReportsHook) Name() string {
+	return "written_reports_metric"
+}
+
+// AfterWrite is called in the transaction of the write
+func (hook writtenReportsHook) AfterWrite(ctx context.Context, tx *sql.Tx, write ReportWrite) error {
+	if !write.Outdated && !write.Duplicate {
+		metrics.WrittenReports.Inc()
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// reportHistoryHook stores the report into the report history and removes the oldest
+// entries exceeding the configured history depth for the cluster, outdated and duplicate
+// reports are stored too, so the history contains all checks of the cluster
+type reportHistoryHook struct {
+	storage *DBStorage
+}
+
+// reportHistoryUpsert writes the report to the history of the cluster
+var reportHistoryUpsert = upsertStatement{
+	table:           "report_history",
+	columns:         []string{"org_id", "cluster", "report", "last_checked_at"},
+	conflictColumns: []string{"org_id", "cluster", "last_checked_at"},
+	updates:         []string{"report = $3"},
+	replaceable:     true,
+}
+
+// Name identifies the hook in logs and errors
+func (hook reportHistoryHook) Name() string {
+	return "report_history"
+}
+
+// AfterWrite is called in the transaction of the write
+func (hook reportHistoryHook) AfterWrite(ctx context.Context, tx *sql.Tx, write ReportWrite) error {
+	depth := hook.storage.reportHistoryDepth
+	if depth <= 0 {
+		return nil
+	}
+
+	insertQuery, ok := hook.storage.dialect().upsert(reportHistoryUpsert)
+	if !ok {
+		return fmt.Errorf("writing report history with DB %v is not supported", hook.storage.dbDriverType)
+	}
+
+	_, err := tx.ExecContext(ctx, insertQuery, write.OrgID, write.ClusterName, write.Report, write.LastCheckedTime)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM report_history
+		 WHERE org_id = $1 AND cluster = $2 AND last_checked_at NOT IN (
+			SELECT last_checked_at FROM report_history
+			 WHERE org_id = $1 AND cluster = $2
+			 ORDER BY last_checked_at DESC
+			 LIMIT $3
+		 )`, write.OrgID, write.ClusterName, depth)
+
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// ruleHitsHook replaces rule hits stored for the cluster by rules hit by its latest report,
+// rule hits of outdated reports are not stored and rule hits of duplicate reports don't change.
+// Rule hits are not stored at all in legacy write mode.
+type ruleHitsHook struct {
+	storage *DBStorage
+}
+
+// Name identifies the hook in logs and errors
+func (hook ruleHitsHook) Name() string {
+	return "rule_hits"
+}
+
+// AfterWrite is called in the transaction of the write
+func (hook ruleHitsHook) AfterWrite(ctx context.Context, tx *sql.Tx, write ReportWrite) error {
+	if write.Outdated || write.Duplicate || hook.storage.writeMode == WriteModeLegacy {
+		return nil
+	}
+
+	return hook.storage.updateRuleHits(ctx, tx, write.OrgID, write.ClusterName, write.Rules.HitRules)
+}
+
+// ruleHitUpsert writes rule hits of the cluster, several rule hits can be written by a single statement
+var ruleHitUpsert = upsertStatement{
+	table:           "rule_hit",
+	columns:         []string{"org_id", "cluster", "rule_fqdn", "error_key", "template_data"},
+	conflictColumns: []string{"org_id", "cluster", "rule_fqdn", "error_key"},
+	updates:         []string{"template_data = excluded.template_data"},
+	replaceable:     true,
+}
+
+// updateRuleHits replaces rule hits stored for the cluster by rules hit by its latest report,
+// the rule hits are inserted in batches limited by the number of arguments of a single query
+func (storage DBStorage) updateRuleHits(
+	ctx context.Context,
+	tx *sql.Tx,
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	hitRules []types.RuleOnReport,
+) error {
+	if _, ok := storage.dialect().upsertRows(ruleHitUpsert, 1); !ok {
+		return fmt.Errorf("writing rule hits with DB %v is not supported", storage.dbDriverType)
+	}
+
+	_, err := tx.ExecContext(ctx, "DELETE FROM rule_hit WHERE org_id = $1 AND cluster = $2", orgID, clusterName)
+	if err != nil {
+		return err
+	}
+
+	hitRules = uniqueRuleHits(hitRules)
+	batchSize := storage.dialect().maxPlaceholders() / len(ruleHitUpsert.columns)
+
+	for start := 0; start < len(hitRules); start += batchSize {
+		end := start + batchSize
+		if end > len(hitRules) {
+			end = len(hitRules)
+		}
+
+		if err := storage.insertRuleHits(ctx, tx, orgID, clusterName, hitRules[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertRuleHits writes the batch of rule hits of the cluster by a single statement
+func (storage DBStorage) insertRuleHits(
+	ctx context.Context,
+	tx *sql.Tx,
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	hitRules []types.RuleOnReport,
+) error {
+	insertQuery, _ := storage.dialect().upsertRows(ruleHitUpsert, len(hitRules))
+
+	args := make([]interface{}, 0, len(ruleHitUpsert.columns)*len(hitRules))
+	for _, hitRule := range hitRules {
+		templateData, err := json.Marshal(hitRule.TemplateData)
+		if err != nil {
+			return err
+		}
+
+		args = append(args, orgID, clusterName, hitRule.Module, hitRule.ErrorKey, string(templateData))
+	}
+
+	_, err := tx.ExecContext(ctx, insertQuery, args...)
+	return err
+}
+
+// uniqueRuleHits returns the rule hits without repeated hits of the same rule and error key,
+// which can't be inserted by a single statement. The last of the repeated hits is kept,
+// like when the rule hits are written one by one.
+func uniqueRuleHits(hitRules []types.RuleOnReport) []types.RuleOnReport {
+	type ruleHitKey struct {
+		module   string
+		errorKey string
+	}
+
+	indexes := make(map[ruleHitKey]int, len(hitRules))
+	unique := make([]types.RuleOnReport, 0, len(hitRules))
+
+	for _, hitRule := range hitRules {
+		key := ruleHitKey{module: hitRule.Module, errorKey: hitRule.ErrorKey}
+		if index, found := indexes[key]; found {
+			unique[index] = hitRule
+			continue
+		}
+
+		indexes[key] = len(unique)
+		unique = append(unique, hitRule)
+	}
+
+	return unique
+}
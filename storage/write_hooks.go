@@ -0,0 +1,92 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// ReportWrite describes the report written by WriteReportForCluster to write hooks.
+// Report is the report as stored in the database, so it's compressed when compression
+// of reports is enabled, Rules contains the parsed content of the report.
+// Outdated is set when a more recent report of the cluster is already stored,
+// the stored report is kept then and the written one goes only to the history.
+// Duplicate is set when the report is identical to the stored one, only the time
+// of its last check is updated then, so data derived from its content stay the same.
+type ReportWrite struct {
+	OrgID           types.OrgID
+	ClusterName     types.ClusterName
+	Report          types.ClusterReport
+	Rules           types.ReportRules
+	LastCheckedTime time.Time
+	KafkaOffset     types.KafkaOffset
+	Outdated        bool
+	Duplicate       bool
+}
+
+// WriteHook derives data from the report written by WriteReportForCluster.
+// Hooks are run in the order of their registration in the transaction of the write
+// after the report is upserted, an error returned by a hook rolls back the whole write.
+type WriteHook interface {
+	// Name identifies the hook in logs and errors
+	Name() string
+	// AfterWrite is called in the transaction of the write
+	AfterWrite(ctx context.Context, tx *sql.Tx, write ReportWrite) error
+}
+
+// WriteHookError shows that the report was not written, because one of write hooks failed
+type WriteHookError struct {
+	Hook string
+	Err  error
+}
+
+// Error returns error string
+func (e *WriteHookError) Error() string {
+	return fmt.Sprintf("write hook %v failed: %v", e.Hook, e.Err)
+}
+
+// Unwrap returns the error returned by the hook
+func (e *WriteHookError) Unwrap() error {
+	return e.Err
+}
+
+// defaultWriteHooks returns hooks maintaining data derived from reports,
+// rule hits are updated before the report is added to the history
+func defaultWriteHooks(storage *DBStorage) []WriteHook {
+	return []WriteHook{
+		ruleHitsHook{storage: storage},
+		writtenReportsHook{},
+		reportHistoryHook{storage: storage},
+	}
+}
+
+// runWriteHooks runs all registered write hooks and stops at the first failing one
+func (storage DBStorage) runWriteHooks(ctx context.Context, tx *sql.Tx, write ReportWrite) error {
+	for _, hook := range storage.writeHooks {
+		if err := hook.AfterWrite(ctx, tx, write); err != nil {
+			log.Error().Err(err).Str("hook", hook.Name()).Msg("Write hook failed")
+			return &WriteHookError{Hook: hook.Name(), Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/helpers"
+	"github.com/RedHatInsights/insights-results-aggregator/tests/testdata"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// recordingHook records names of called hooks and writes passed to them
+type recordingHook struct {
+	name   string
+	err    error
+	called *[]string
+	writes *[]storage.ReportWrite
+}
+
+func (hook recordingHook) Name() string {
+	return hook.name
+}
+
+func (hook recordingHook) AfterWrite(ctx context.Context, tx *sql.Tx, write storage.ReportWrite) error {
+	*hook.called = append(*hook.called, hook.name)
+	if hook.writes != nil {
+		*hook.writes = append(*hook.writes, write)
+	}
+	return hook.err
+}
+
+func TestDBStorageWriteHooksOrder(t *testing.T) {
+	var (
+		called []string
+		writes []storage.ReportWrite
+	)
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	dbStorage := mockStorage.(*storage.DBStorage)
+	storage.AddWriteHook(dbStorage, recordingHook{name: "first", called: &called, writes: &writes})
+	storage.AddWriteHook(dbStorage, recordingHook{name: "second", called: &called})
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.KafkaOffset(7),
+	)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, []string{"first", "second"}, called)
+	assert.Len(t, writes, 1)
+	assert.Equal(t, testdata.OrgID, writes[0].OrgID)
+	assert.Equal(t, testdata.ClusterName, writes[0].ClusterName)
+	assert.Equal(t, testdata.Report3Rules, writes[0].Report)
+	assert.Len(t, writes[0].Rules.HitRules, 3)
+	assert.Equal(t, testdata.LastCheckedAt, writes[0].LastCheckedTime)
+	assert.Equal(t, types.KafkaOffset(7), writes[0].KafkaOffset)
+	assert.False(t, writes[0].Outdated)
+
+	// rule hits are maintained by the default hooks registered before the added ones
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Len(t, ruleHits, 3)
+}
+
+func TestDBStorageWriteHooksOutdatedReport(t *testing.T) {
+	var (
+		called []string
+		writes []storage.ReportWrite
+	)
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	storage.AddWriteHook(mockStorage.(*storage.DBStorage), recordingHook{name: "hook", called: &called, writes: &writes})
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	err = mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report0Rules,
+		testdata.LastCheckedAt.Add(-time.Hour), types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+
+	assert.Equal(t, []string{"hook", "hook"}, called)
+	assert.False(t, writes[0].Outdated)
+	assert.True(t, writes[1].Outdated)
+
+	// rule hits of the outdated report are not stored
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Len(t, ruleHits, 3)
+}
+
+func TestDBStorageWriteHookFailureRollsBackWrite(t *testing.T) {
+	var called []string
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	dbStorage := mockStorage.(*storage.DBStorage)
+	storage.AddWriteHook(dbStorage, recordingHook{name: "failing", err: errors.New("hook error"), called: &called})
+	storage.AddWriteHook(dbStorage, recordingHook{name: "next", called: &called})
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	assert.EqualError(t, err, "write hook failing failed: hook error")
+	if hookErr, ok := err.(*storage.WriteHookError); assert.True(t, ok) {
+		assert.Equal(t, "failing", hookErr.Hook)
+	}
+
+	// hooks registered after the failing one are not run
+	assert.Equal(t, []string{"failing"}, called)
+
+	// neither the report nor data written by the preceding hooks are kept
+	_, err = mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	if _, ok := err.(*storage.ItemNotFoundError); !ok {
+		t.Fatalf("expected ItemNotFoundError, got %T, %+v", err, err)
+	}
+
+	var ruleHitsCount int
+	err = storage.GetConnection(dbStorage).QueryRow("SELECT COUNT(*) FROM rule_hit").Scan(&ruleHitsCount)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, 0, ruleHitsCount)
+}
+
+func TestDBStorageWriteHooksReplaced(t *testing.T) {
+	var called []string
+
+	mockStorage := helpers.MustGetMockStorage(t, true)
+	defer helpers.MustCloseStorage(t, mockStorage)
+
+	storage.SetWriteHooks(mockStorage.(*storage.DBStorage), []storage.WriteHook{
+		recordingHook{name: "only", called: &called},
+	})
+
+	err := mockStorage.WriteReportForCluster(
+		testdata.OrgID, testdata.ClusterName, testdata.Report3Rules, testdata.LastCheckedAt, types.UnknownKafkaOffset,
+	)
+	helpers.FailOnError(t, err)
+	assert.Equal(t, []string{"only"}, called)
+
+	// the report itself is written by the storage, rule hits are derived by the default hooks only
+	ruleHits, err := mockStorage.GetRuleHitsForCluster(testdata.OrgID, testdata.ClusterName)
+	helpers.FailOnError(t, err)
+	assert.Empty(t, ruleHits)
+}
+
+func TestIsTransientDBErrorWriteHookError(t *testing.T) {
+	assert.True(t, storage.IsTransientDBError(&storage.WriteHookError{Hook: "hook", Err: driver.ErrBadConn}))
+	assert.False(t, storage.IsTransientDBError(&storage.WriteHookError{Hook: "hook", Err: errors.New("hook error")}))
+}
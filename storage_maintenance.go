@@ -0,0 +1,105 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Implementation of periodic maintenance of the database for aggregator
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/RedHatInsights/insights-results-aggregator/metrics"
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+)
+
+// Results of maintenance runs used as labels of the metric
+const (
+	maintenanceSucceeded = "success"
+	maintenanceFailed    = "error"
+	maintenanceSkipped   = "skipped"
+)
+
+// maintenanceRunner deletes orphaned users' feedback and runs VACUUM and ANALYZE on the database,
+// it's usually the SQL storage
+type maintenanceRunner interface {
+	CleanupOrphanedFeedback() (int, error)
+	RunMaintenance() error
+}
+
+// cleanupOrphanedFeedback deletes users' feedback on clusters whose reports were deleted
+func cleanupOrphanedFeedback(runner maintenanceRunner) {
+	deleted, err := runner.CleanupOrphanedFeedback()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to delete orphaned feedback")
+		return
+	}
+
+	metrics.OrphanedFeedbackDeleted.Add(float64(deleted))
+	log.Info().Int("deleted", deleted).Msg("Feedback on clusters without reports deleted")
+}
+
+// runMaintenance deletes orphaned users' feedback, so the space left by it is reclaimed, and runs
+// maintenance of the database recording its result and duration, the maintenance is skipped
+// when another one is in progress
+func runMaintenance(runner maintenanceRunner) {
+	cleanupOrphanedFeedback(runner)
+
+	started := time.Now()
+
+	err := runner.RunMaintenance()
+	switch {
+	case errors.Is(err, storage.ErrMaintenanceInProgress):
+		log.Info().Msg("Maintenance of the database skipped, another one is in progress")
+		metrics.StorageMaintenanceRuns.WithLabelValues(maintenanceSkipped).Inc()
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("Unable to run maintenance of the database")
+		metrics.StorageMaintenanceRuns.WithLabelValues(maintenanceFailed).Inc()
+	default:
+		log.Info().Msg("Maintenance of the database finished")
+		metrics.StorageMaintenanceRuns.WithLabelValues(maintenanceSucceeded).Inc()
+	}
+
+	metrics.StorageMaintenanceDuration.Observe(time.Since(started).Seconds())
+}
+
+// startMaintenance opens the storage connection and runs periodic maintenance of the database
+// until the context is cancelled, storages without database are not maintained
+func startMaintenance(ctx context.Context, maintenanceCfg maintenanceConfiguration) {
+	dbStorage, err := startStorageConnection()
+	if err != nil {
+		log.Error().Err(err).Msg("Periodic maintenance of the database can't be started")
+		return
+	}
+	defer closeStorage(dbStorage)
+
+	runner, ok := dbStorage.(maintenanceRunner)
+	if !ok {
+		log.Info().Msg("Storage doesn't use any database, periodic maintenance is not started")
+		return
+	}
+
+	log.Info().
+		Str("interval", maintenanceCfg.Interval.String()).
+		Msg("Periodic maintenance of the database has been started")
+
+	runPeriodically(ctx, maintenanceCfg.Interval, func() {
+		runMaintenance(runner)
+	})
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Implementation of periodic collection of metrics of the database for aggregator
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// storageMetricsCollector sets the gauges of numbers of rows of the main tables,
+// it's usually the SQL storage
+type storageMetricsCollector interface {
+	CollectStorageMetrics() error
+}
+
+// collectStorageMetrics refreshes metrics of the database, the metrics are kept when the storage fails
+func collectStorageMetrics(collector storageMetricsCollector) {
+	if err := collector.CollectStorageMetrics(); err != nil {
+		log.Error().Err(err).Msg("Unable to collect metrics of the database")
+		return
+	}
+
+	log.Debug().Msg("Metrics of the database collected")
+}
+
+// startStorageMetricsCollection opens the storage connection and periodically collects metrics
+// of the database until the context is cancelled, storages without database are not counted
+func startStorageMetricsCollection(ctx context.Context, storageMetricsCfg storageMetricsConfiguration) {
+	dbStorage, err := startStorageConnection()
+	if err != nil {
+		log.Error().Err(err).Msg("Periodic collection of metrics of the database can't be started")
+		return
+	}
+	defer closeStorage(dbStorage)
+
+	collector, ok := dbStorage.(storageMetricsCollector)
+	if !ok {
+		log.Info().Msg("Storage doesn't use any database, metrics of the database are not collected")
+		return
+	}
+
+	log.Info().
+		Str("interval", storageMetricsCfg.Interval.String()).
+		Msg("Periodic collection of metrics of the database has been started")
+
+	// the metrics are set right away, not only after the first interval
+	collectStorageMetrics(collector)
+	runPeriodically(ctx, storageMetricsCfg.Interval, func() {
+		collectStorageMetrics(collector)
+	})
+}
@@ -40,6 +40,7 @@ import (
 // UserID is a user id for methods requiring user id (leave empty to not use it)
 // XRHIdentity is an authentication token (leave empty to not use it)
 // AuthorizationToken is an authentication token (leave empty to not use it)
+// Headers are additional headers of the request (leave nil to not use them)
 type APIRequest struct {
 	Method             string
 	Endpoint           string
@@ -48,6 +49,7 @@ type APIRequest struct {
 	UserID             types.UserID
 	XRHIdentity        string
 	AuthorizationToken string
+	Headers            map[string]string
 }
 
 // APIResponse is an expected api response to use in AssertAPIRequest
@@ -55,10 +57,12 @@ type APIRequest struct {
 // StatusCode is an expected http status code (leave empty to not check for status code)
 // Body is an expected body string (leave empty to not check for body)
 // BodyChecker is a custom body checker function (leave empty to use default one - CheckResponseBodyJSON)
+// Headers are expected response headers, empty value means the header must not be present (leave nil to not check)
 type APIResponse struct {
 	StatusCode  int
 	Body        string
 	BodyChecker func(t *testing.T, expected, got string)
+	Headers     map[string]string
 }
 
 // AssertAPIRequest creates new server with provided mockStorage
@@ -100,11 +104,18 @@ func AssertAPIRequest(
 		req.Header.Set("Authorization", request.AuthorizationToken)
 	}
 
+	for headerName, value := range request.Headers {
+		req.Header.Set(headerName, value)
+	}
+
 	response := ExecuteRequest(testServer, req, serverConfig).Result()
 
 	if expectedResponse.StatusCode != 0 {
 		assert.Equal(t, expectedResponse.StatusCode, response.StatusCode, "Expected different status code")
 	}
+	for headerName, expectedValue := range expectedResponse.Headers {
+		assert.Equal(t, expectedValue, response.Header.Get(headerName), "Expected different header "+headerName)
+	}
 	if expectedResponse.BodyChecker != nil {
 		bodyBytes, err := ioutil.ReadAll(response.Body)
 		FailOnError(t, err)
@@ -0,0 +1,405 @@
+// Copyright 2020 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/RedHatInsights/insights-results-aggregator/storage"
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+// recentTimeTolerance is the tolerance of arguments set to the current time by the storage
+const recentTimeTolerance = time.Minute
+
+// postgresReportUpsertQuery is the query writing reports on PostgreSQL
+const postgresReportUpsertQuery = `
+	INSERT INTO report(org_id, cluster, report, reported_at, last_checked_at, kafka_offset, report_checksum)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (org_id, cluster)
+	DO UPDATE SET report = excluded.report, reported_at = excluded.reported_at,
+		last_checked_at = excluded.last_checked_at, kafka_offset = excluded.kafka_offset,
+		report_checksum = excluded.report_checksum, deleted_at = NULL
+	WHERE (report.last_checked_at IS NULL OR report.last_checked_at <= excluded.last_checked_at)
+	AND (report.kafka_offset IS NULL OR excluded.kafka_offset IS NULL OR report.kafka_offset < excluded.kafka_offset)`
+
+// duplicateReportUpdateQuery is the query updating the stored report when it's identical to the written one
+const duplicateReportUpdateQuery = `
+	UPDATE report SET last_checked_at = $3, kafka_offset = $4, deleted_at = NULL
+	WHERE org_id = $1 AND cluster = $2 AND report_checksum = $5
+	AND (last_checked_at IS NULL OR last_checked_at <= $3)
+	AND (kafka_offset IS NULL OR $4 IS NULL OR kafka_offset < $4)`
+
+// ruleHitsInsertQuery returns the query writing the given number of rule hits on PostgreSQL
+func ruleHitsInsertQuery(count int) string {
+	rows := make([]string, count)
+	for i := range rows {
+		first := i*5 + 1
+		rows[i] = fmt.Sprintf("($%v, $%v, $%v, $%v, $%v)", first, first+1, first+2, first+3, first+4)
+	}
+
+	return `INSERT INTO rule_hit(org_id, cluster, rule_fqdn, error_key, template_data)
+		VALUES ` + strings.Join(rows, ", ") + `
+		ON CONFLICT (org_id, cluster, rule_fqdn, error_key)
+		DO UPDATE SET template_data = excluded.template_data`
+}
+
+var (
+	sqlWhitespaceRegex  = regexp.MustCompile(`\s+`)
+	sqlPunctuationRegex = regexp.MustCompile(`\s*([(),])\s*`)
+	sqlPlaceholderRegex = regexp.MustCompile(`\$(\d+)`)
+)
+
+// NormalizeSQL removes differences in whitespace from SQL query, so queries
+// formatted in different ways can be compared
+func NormalizeSQL(query string) string {
+	query = sqlWhitespaceRegex.ReplaceAllString(strings.TrimSpace(query), " ")
+	query = sqlPunctuationRegex.ReplaceAllString(query, "$1")
+
+	return strings.TrimSuffix(query, ";")
+}
+
+// CountPlaceholders returns number of arguments required by the query,
+// i.e. the highest index of $N placeholder used in it
+func CountPlaceholders(query string) int {
+	count := 0
+
+	for _, match := range sqlPlaceholderRegex.FindAllStringSubmatch(query, -1) {
+		index, err := strconv.Atoi(match[1])
+		if err == nil && index > count {
+			count = index
+		}
+	}
+
+	return count
+}
+
+// NormalizedQueryMatcher matches whole queries which are the same after normalization by NormalizeSQL
+var NormalizedQueryMatcher = sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	expected := NormalizeSQL(expectedSQL)
+	actual := NormalizeSQL(actualSQL)
+
+	if expected != actual {
+		return fmt.Errorf(`actual query "%v" does not match expected "%v"`, actual, expected)
+	}
+
+	return nil
+})
+
+// timeArgument matches time.Time argument within the tolerance
+type timeArgument struct {
+	expected  time.Time
+	tolerance time.Duration
+}
+
+// Match checks that the value is time.Time within the tolerance from the expected time
+func (argument timeArgument) Match(value driver.Value) bool {
+	actual, ok := value.(time.Time)
+	if !ok {
+		return false
+	}
+
+	difference := actual.Sub(argument.expected)
+	if difference < 0 {
+		difference = -difference
+	}
+
+	return difference <= argument.tolerance
+}
+
+// TimeWithin returns argument matcher for time.Time differing at most by tolerance from the expected time
+func TimeWithin(expected time.Time, tolerance time.Duration) sqlmock.Argument {
+	return timeArgument{expected: expected, tolerance: tolerance}
+}
+
+// TimeEqual returns argument matcher for exactly the expected time (in any location)
+func TimeEqual(expected time.Time) sqlmock.Argument {
+	return TimeWithin(expected, 0)
+}
+
+// RecentTime returns argument matcher for the current time, like times of creation set by the storage
+func RecentTime() sqlmock.Argument {
+	return TimeWithin(time.Now(), recentTimeTolerance)
+}
+
+// ReportChecksum returns checksum of the report stored with it by the storage
+func ReportChecksum(report types.ClusterReport) string {
+	sum := sha256.Sum256([]byte(report))
+	return hex.EncodeToString(sum[:])
+}
+
+// StrictExpects is sqlmock which matches whole normalized queries instead of regular expressions
+// and provides builders of expectations asserting queries together with their arguments
+type StrictExpects struct {
+	sqlmock.Sqlmock
+	t *testing.T
+}
+
+// MustGetMockDBWithStrictExpects returns mock db with StrictExpects
+// don't forget to call MustCloseMockDBWithExpects
+func MustGetMockDBWithStrictExpects(t *testing.T) (*sql.DB, *StrictExpects) {
+	db, expects, err := sqlmock.New(sqlmock.QueryMatcherOption(NormalizedQueryMatcher))
+	FailOnError(t, err)
+
+	return db, &StrictExpects{Sqlmock: expects, t: t}
+}
+
+// MustGetMockStorageWithStrictExpectsForDriver returns mock db storage with specified driver type
+// and with StrictExpects
+// don't forget to call MustCloseMockStorageWithExpects
+func MustGetMockStorageWithStrictExpectsForDriver(
+	t *testing.T, driverType storage.DBDriver,
+) (storage.Storage, *StrictExpects) {
+	db, expects := MustGetMockDBWithStrictExpects(t)
+
+	return storage.NewFromConnection(db, driverType), expects
+}
+
+// checkArguments fails the test when number of expected arguments doesn't match the query
+func (expects *StrictExpects) checkArguments(query string, args []driver.Value) {
+	if placeholders := CountPlaceholders(query); placeholders != len(args) {
+		expects.t.Fatalf(
+			"query %q requires %v arguments, but %v arguments are expected",
+			NormalizeSQL(query), placeholders, len(args),
+		)
+	}
+}
+
+// ExpectQueryWithArgs expects the query with exactly the given arguments
+func (expects *StrictExpects) ExpectQueryWithArgs(query string, args ...driver.Value) *sqlmock.ExpectedQuery {
+	expects.checkArguments(query, args)
+
+	// empty (not nil) list of arguments makes sqlmock check that there are no arguments
+	return expects.ExpectQuery(query).WithArgs(append([]driver.Value{}, args...)...)
+}
+
+// ExpectExecWithArgs expects execution of the query with exactly the given arguments
+func (expects *StrictExpects) ExpectExecWithArgs(query string, args ...driver.Value) *sqlmock.ExpectedExec {
+	expects.checkArguments(query, args)
+
+	return expects.ExpectExec(query).WithArgs(append([]driver.Value{}, args...)...)
+}
+
+// expectPostgresReportChecks expects queries executed by WriteReportForCluster on PostgreSQL
+// before the report is written, the report is not found already stored unless duplicate
+// is set, then the stored report has the same checksum and it's updated.
+// The value of the offset stored with the report is returned.
+func (expects *StrictExpects) expectPostgresReportChecks(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+	duplicate bool,
+) driver.Value {
+	// the update and the upsert are prepared before the transaction begins
+	expects.ExpectPrepare(duplicateReportUpdateQuery)
+	expects.ExpectPrepare(postgresReportUpsertQuery)
+	expects.ExpectBegin()
+
+	// unknown offset is stored as NULL
+	var storedOffset driver.Value
+	if kafkaOffset >= 0 {
+		storedOffset = int64(kafkaOffset)
+	}
+
+	var updatedRows int64
+	if duplicate {
+		updatedRows = 1
+	}
+
+	expects.ExpectExecWithArgs(
+		duplicateReportUpdateQuery,
+		orgID, clusterName, TimeEqual(lastChecked), storedOffset, ReportChecksum(report),
+	).WillReturnResult(sqlmock.NewResult(0, updatedRows))
+
+	return storedOffset
+}
+
+// expectPostgresReportUpsert expects the upsert of the report changing the given number of rows,
+// the offset is checked when the upsert doesn't change any row and the offset is known,
+// the stored report is found consumed from the same or newer offset when oldReport is set
+func (expects *StrictExpects) expectPostgresReportUpsert(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+	storedOffset driver.Value,
+	upsertedRows int64,
+	oldReport bool,
+) {
+	expects.ExpectExecWithArgs(
+		postgresReportUpsertQuery,
+		orgID, clusterName, string(report), RecentTime(), TimeEqual(lastChecked), storedOffset, ReportChecksum(report),
+	).WillReturnResult(sqlmock.NewResult(0, upsertedRows))
+
+	if upsertedRows > 0 || kafkaOffset < 0 {
+		return
+	}
+
+	rows := sqlmock.NewRows([]string{"kafka_offset"})
+	if oldReport {
+		rows.AddRow(int64(kafkaOffset))
+	}
+
+	expects.ExpectQueryWithArgs(
+		`SELECT kafka_offset FROM report WHERE org_id = $1 AND cluster = $2 AND kafka_offset >= $3`,
+		orgID, clusterName, kafkaOffset,
+	).WillReturnRows(rows).RowsWillBeClosed()
+}
+
+// ExpectPostgresWriteReport expects all queries executed by the first WriteReportForCluster on PostgreSQL
+// when there is no more recent report for the cluster and the report history is disabled
+func (expects *StrictExpects) ExpectPostgresWriteReport(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+) {
+	var reportRules types.ReportRules
+	FailOnError(expects.t, json.Unmarshal([]byte(report), &reportRules))
+
+	storedOffset := expects.expectPostgresReportChecks(orgID, clusterName, report, lastChecked, kafkaOffset, false)
+	expects.expectPostgresReportUpsert(orgID, clusterName, report, lastChecked, kafkaOffset, storedOffset, 1, false)
+
+	expects.ExpectExecWithArgs(
+		`DELETE FROM rule_hit WHERE org_id = $1 AND cluster = $2`, orgID, clusterName,
+	).WillReturnResult(driver.ResultNoRows)
+
+	// all rule hits are inserted by a single query
+	if len(reportRules.HitRules) > 0 {
+		var args []driver.Value
+		for _, hitRule := range reportRules.HitRules {
+			templateData, err := json.Marshal(hitRule.TemplateData)
+			FailOnError(expects.t, err)
+
+			args = append(args, orgID, clusterName, hitRule.Module, hitRule.ErrorKey, string(templateData))
+		}
+
+		expects.ExpectExecWithArgs(
+			ruleHitsInsertQuery(len(reportRules.HitRules)), args...,
+		).WillReturnResult(driver.ResultNoRows)
+	}
+
+	expects.ExpectCommit()
+}
+
+// ExpectPostgresWriteDuplicateReport expects all queries executed by WriteReportForCluster on PostgreSQL
+// when the report is identical to the stored one and the report history is disabled,
+// only the time of the last check and the offset are updated then
+func (expects *StrictExpects) ExpectPostgresWriteDuplicateReport(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+) {
+	expects.expectPostgresReportChecks(orgID, clusterName, report, lastChecked, kafkaOffset, true)
+
+	expects.ExpectCommit()
+}
+
+// ExpectPostgresWriteOutdatedReport expects all queries executed by WriteReportForCluster on PostgreSQL
+// when the stored report is more recent than the written one and the report history is disabled,
+// the upsert doesn't change any row then
+func (expects *StrictExpects) ExpectPostgresWriteOutdatedReport(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+) {
+	storedOffset := expects.expectPostgresReportChecks(orgID, clusterName, report, lastChecked, kafkaOffset, false)
+	expects.expectPostgresReportUpsert(orgID, clusterName, report, lastChecked, kafkaOffset, storedOffset, 0, false)
+
+	expects.ExpectCommit()
+}
+
+// ExpectPostgresWriteOldReport expects all queries executed by WriteReportForCluster on PostgreSQL
+// when the stored report was consumed from the same or newer Kafka offset, nothing is written then
+func (expects *StrictExpects) ExpectPostgresWriteOldReport(
+	orgID types.OrgID,
+	clusterName types.ClusterName,
+	report types.ClusterReport,
+	lastChecked time.Time,
+	kafkaOffset types.KafkaOffset,
+) {
+	storedOffset := expects.expectPostgresReportChecks(orgID, clusterName, report, lastChecked, kafkaOffset, false)
+	expects.expectPostgresReportUpsert(orgID, clusterName, report, lastChecked, kafkaOffset, storedOffset, 0, true)
+
+	expects.ExpectRollback()
+}
+
+// ExpectUpsertFeedback expects all queries of user feedback on rule or its error key written for the first time
+// with the same combination of updateVote and updateMessage, which specify which columns are updated
+// when the feedback exists already, like in VoteOnRule (only vote) or AddOrUpdateFeedbackOnRule
+// (only message). The message is expected to be written only when updateMessage is set.
+func (expects *StrictExpects) ExpectUpsertFeedback(
+	clusterID types.ClusterName,
+	ruleID types.RuleID,
+	errorKey types.ErrorKey,
+	userID types.UserID,
+	userVote storage.UserVote,
+	message string,
+	updateVote bool,
+	updateMessage bool,
+) {
+	query := `
+		INSERT INTO cluster_rule_user_feedback
+		(cluster_id, rule_id, error_key, user_id, user_vote, added_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	var updates []string
+	if updateVote {
+		updates = append(updates, "user_vote = $5")
+	}
+	if updateVote || updateMessage {
+		updates = append(updates, "updated_at = $7")
+		query += " ON CONFLICT (cluster_id, rule_id, error_key, user_id) DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+
+	// the upsert is prepared before the transaction begins
+	expects.ExpectPrepare(query)
+	expects.ExpectBegin()
+
+	expects.ExpectExecWithArgs(
+		query, clusterID, ruleID, errorKey, userID, userVote, RecentTime(), RecentTime(),
+	).WillReturnResult(driver.ResultNoRows)
+
+	if updateMessage {
+		expects.ExpectExecWithArgs(`
+			INSERT INTO cluster_rule_user_message(cluster_id, rule_id, error_key, user_id, message, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (cluster_id, rule_id, error_key, user_id) DO UPDATE SET message = $5, updated_at = $6`,
+			clusterID, ruleID, errorKey, userID, message, RecentTime(),
+		).WillReturnResult(driver.ResultNoRows)
+	}
+
+	expects.ExpectCommit()
+}
@@ -205,7 +205,7 @@ var (
   "report": {
     "meta": {
       "count": 3,
-      "last_checked_at": "` + LastCheckedAt.Format(time.RFC3339) + `"
+      "last_checked_at": "` + LastCheckedAt.UTC().Format(time.RFC3339) + `"
     },
     "data": [
       {
@@ -238,3 +238,26 @@ var (
 }
 `
 )
+
+// successive versions of rule content, the second one adds rule 3 and modifies
+// summary of rule 1, the third one removes rule 2
+var (
+	RuleContentVersion1 = content.RuleContentDirectory{
+		"rc1": RuleContent3Rules["rc1"],
+		"rc2": RuleContent3Rules["rc2"],
+	}
+	RuleContentVersion2 = content.RuleContentDirectory{
+		"rc1": withSummary(RuleContent3Rules["rc1"], "rule 1 modified summary"),
+		"rc2": RuleContent3Rules["rc2"],
+		"rc3": RuleContent3Rules["rc3"],
+	}
+	RuleContentVersion3 = content.RuleContentDirectory{
+		"rc1": RuleContentVersion2["rc1"],
+		"rc3": RuleContent3Rules["rc3"],
+	}
+)
+
+func withSummary(ruleContent content.RuleContent, summary string) content.RuleContent {
+	ruleContent.Summary = []byte(summary)
+	return ruleContent
+}
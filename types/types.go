@@ -16,8 +16,18 @@ limitations under the License.
 
 package types
 
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
 // OrgID represents organization ID
-type OrgID uint32
+type OrgID uint64
+
+// MaxOrgID is the highest organization ID which can be stored, org_id columns are BIGINT,
+// so IDs are limited by the highest signed 64-bit integer
+const MaxOrgID OrgID = math.MaxInt64
 
 // ClusterName represents name of cluster in format c8590f31-e97e-4b85-b506-c45ce1911a12
 type ClusterName string
@@ -25,14 +35,79 @@ type ClusterName string
 // ClusterReport represents cluster report
 type ClusterReport string
 
-// Timestamp represents any timestamp in a form gathered from database
-// TODO: need to be improved
-type Timestamp string
+// KafkaOffset represents offset of the message in the partition of Kafka topic
+type KafkaOffset int64
+
+// UnknownKafkaOffset is used for reports which were not consumed from Kafka, like uploaded reports,
+// all negative offsets are considered unknown
+const UnknownKafkaOffset KafkaOffset = -1
+
+// Timestamp represents any timestamp, it's kept in UTC and sent in API responses in RFC3339 format,
+// zero timestamp (unknown or NULL in the storage) is sent as empty string
+type Timestamp struct {
+	time.Time
+}
+
+// NewTimestamp converts time to Timestamp in UTC
+func NewTimestamp(t time.Time) Timestamp {
+	if t.IsZero() {
+		return Timestamp{}
+	}
+
+	return Timestamp{Time: t.UTC()}
+}
+
+// NewOptionalTimestamp converts time to Timestamp for fields omitted when empty, zero time
+// is converted to nil, so it's omitted instead of being sent as empty string
+func NewOptionalTimestamp(t time.Time) *Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	timestamp := NewTimestamp(t)
+	return &timestamp
+}
+
+// String formats the timestamp in RFC3339 format as it's sent in API responses
+func (timestamp Timestamp) String() string {
+	if timestamp.IsZero() {
+		return ""
+	}
+
+	return timestamp.UTC().Format(time.RFC3339)
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (timestamp Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(timestamp.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface, empty string and null are read as zero timestamp
+func (timestamp *Timestamp) UnmarshalJSON(data []byte) error {
+	var formatted *string
+	if err := json.Unmarshal(data, &formatted); err != nil {
+		return err
+	}
+
+	if formatted == nil || *formatted == "" {
+		*timestamp = Timestamp{}
+		return nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, *formatted)
+	if err != nil {
+		return err
+	}
+
+	*timestamp = NewTimestamp(parsed)
+	return nil
+}
 
 // RuleOnReport represents a single (hit) rule of the string encoded report
 type RuleOnReport struct {
-	Module   string `json:"component"`
-	ErrorKey string `json:"key"`
+	Module       string      `json:"component"`
+	ErrorKey     string      `json:"key"`
+	TemplateData interface{} `json:"details"`
 }
 
 // ReportRules is a helper struct for easy JSON unmarshalling of string encoded report
@@ -43,16 +118,107 @@ type ReportRules struct {
 	TotalCount   int
 }
 
+// ClusterNameWithTimestamp represents name of a cluster together with the time of the last check of its report
+type ClusterNameWithTimestamp struct {
+	Name          ClusterName `json:"cluster"`
+	LastCheckedAt *Timestamp  `json:"last_checked_at,omitempty"`
+}
+
+// ClusterListItem represents a cluster in the list of clusters of an organization together with
+// the time when its latest report was stored and the time when the cluster was analyzed
+type ClusterListItem struct {
+	Name          ClusterName `json:"cluster"`
+	ReportedAt    *Timestamp  `json:"reported_at,omitempty"`
+	LastCheckedAt *Timestamp  `json:"last_checked_at,omitempty"`
+}
+
+// StoredReport represents the latest report of a cluster read from the storage: LastCheckedAt
+// is the time when the cluster was analyzed, ReportedAt is the time when the report was stored
+// and Count is the number of rules hit by the report
+type StoredReport struct {
+	Report        ClusterReport
+	LastCheckedAt time.Time
+	ReportedAt    time.Time
+	Count         int
+}
+
+// ReportMetainfo describes the latest report of a cluster without the report itself:
+// the times when it was stored and when the cluster was analyzed, Kafka offset the report
+// was consumed from (UnknownKafkaOffset when it wasn't consumed from Kafka) and the number
+// of rules hit by it
+type ReportMetainfo struct {
+	ReportedAt    *Timestamp  `json:"reported_at,omitempty"`
+	LastCheckedAt *Timestamp  `json:"last_checked_at,omitempty"`
+	KafkaOffset   KafkaOffset `json:"kafka_offset"`
+	RuleHitsCount int         `json:"rule_hits_count"`
+}
+
+// ReportItem is a report of a cluster written together with reports of other clusters,
+// e.g. when reports are backfilled. KafkaOffset is UnknownKafkaOffset (or any negative value)
+// when the report was not consumed from Kafka.
+type ReportItem struct {
+	OrgID         OrgID
+	ClusterName   ClusterName
+	Report        ClusterReport
+	LastCheckedAt time.Time
+	KafkaOffset   KafkaOffset
+}
+
+// ReportHistoryEntry represents one report kept in the history of reports for a cluster
+type ReportHistoryEntry struct {
+	Report        ClusterReport `json:"report"`
+	LastCheckedAt *Timestamp    `json:"last_checked_at,omitempty"`
+}
+
+// DailyHitsCount is the number of rules hit by the latest report of the cluster
+// checked during the day (in UTC)
+type DailyHitsCount struct {
+	Date      string `json:"date"`
+	HitsCount int    `json:"hits_count"`
+}
+
+// ProcessingError is a failure of processing of a report consumed for the cluster,
+// only the sanitized category of the error is shown to users
+type ProcessingError struct {
+	Category string    `json:"category"`
+	FailedAt Timestamp `json:"failed_at"`
+}
+
+// ArchivedReport represents a report of a cluster together with its history,
+// it's stored in the archive before the report is deleted by the cleanup of old reports
+type ArchivedReport struct {
+	OrgID         OrgID                `json:"org_id"`
+	ClusterName   ClusterName          `json:"cluster"`
+	Report        ClusterReport        `json:"report"`
+	LastCheckedAt *Timestamp           `json:"last_checked_at,omitempty"`
+	History       []ReportHistoryEntry `json:"history"`
+}
+
+// OrgStats contains statistics about reports stored for an organization,
+// the timestamps are empty when there is no report for the organization
+type OrgStats struct {
+	ClusterCount        int       `json:"cluster_count"`
+	OldestLastCheckedAt Timestamp `json:"oldest_last_checked_at"`
+	NewestLastCheckedAt Timestamp `json:"newest_last_checked_at"`
+}
+
 // ReportResponse represents the response of /report endpoint
 type ReportResponse struct {
 	Meta  ReportResponseMeta    `json:"meta"`
 	Rules []RuleContentResponse `json:"data"`
 }
 
-// ReportResponseMeta contains metadata about the report
+// ReportResponseMeta contains metadata about the report,
+// LastCheckedAt is the time of the analysis of the cluster and ReportedAt is the time when the report was stored,
+// FilteredCount is the number of rules passing the filter and it's set only when the rules are filtered,
+// LastProcessingErrorAt is set when processing of a newer report of the cluster failed
 type ReportResponseMeta struct {
-	Count         int       `json:"count"`
-	LastCheckedAt Timestamp `json:"last_checked_at"`
+	Count                 int        `json:"count"`
+	FilteredCount         *int       `json:"filtered_count,omitempty"`
+	LastCheckedAt         *Timestamp `json:"last_checked_at,omitempty"`
+	ReportedAt            *Timestamp `json:"reported_at,omitempty"`
+	Stale                 bool       `json:"stale,omitempty"`
+	LastProcessingErrorAt *Timestamp `json:"last_processing_error_at,omitempty"`
 }
 
 // RuleContentResponse represents a single rule in the response of /report endpoint
@@ -64,11 +230,38 @@ type RuleContentResponse struct {
 	CreatedAt    string `json:"created_at"`
 	TotalRisk    int    `json:"total_risk"`
 	RiskOfChange int    `json:"risk_of_change"`
+	// ResolutionIncomplete is set when some placeholders in the content couldn't be resolved
+	ResolutionIncomplete bool `json:"resolution_incomplete,omitempty"`
 }
 
+// BatchItemStatus represents result of a batch operation for a single item
+type BatchItemStatus string
+
+const (
+	// BatchItemDeleted shows that the item has been deleted
+	BatchItemDeleted BatchItemStatus = "deleted"
+	// BatchItemNotFound shows that the item was not found in the storage
+	BatchItemNotFound BatchItemStatus = "not_found"
+)
+
+// DBDriver type for db driver enum
+type DBDriver int
+
+const (
+	// DBDriverSQLite3 shows that db driver is sqlite
+	DBDriverSQLite3 DBDriver = iota
+	// DBDriverPostgres shows that db driver is postrgres
+	DBDriverPostgres
+	// DBDriverGeneral general sql(used for mock now)
+	DBDriverGeneral
+)
+
 // RuleID represents type for rule id
 type RuleID string
 
+// ErrorKey represents type for error key of a rule
+type ErrorKey string
+
 // UserID represents type for user id
 type UserID string
 
@@ -81,3 +274,11 @@ type Rule struct {
 	Resolution string `json:"resolution"`
 	MoreInfo   string `json:"more_info"`
 }
+
+// ContentChanges lists rules that were added, removed or modified between two versions
+// of rule content
+type ContentChanges struct {
+	Added    []RuleID `json:"added"`
+	Removed  []RuleID `json:"removed"`
+	Modified []RuleID `json:"modified"`
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RedHatInsights/insights-results-aggregator/types"
+)
+
+var checkedAt = time.Date(2020, 3, 5, 10, 20, 30, 123456789, time.FixedZone("CEST", 2*60*60))
+
+// TestTimestampJSON checks that timestamps are sent in RFC3339 format in UTC
+// and that empty timestamps are omitted when the field allows it
+func TestTimestampJSON(t *testing.T) {
+	for _, testCase := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{types.NewTimestamp(checkedAt), `"2020-03-05T08:20:30Z"`},
+		{types.NewTimestamp(time.Time{}), `""`},
+		{
+			types.OrgStats{ClusterCount: 1, OldestLastCheckedAt: types.NewTimestamp(checkedAt)},
+			`{"cluster_count":1,"oldest_last_checked_at":"2020-03-05T08:20:30Z","newest_last_checked_at":""}`,
+		},
+		{
+			types.ReportHistoryEntry{Report: "{}", LastCheckedAt: types.NewOptionalTimestamp(checkedAt)},
+			`{"report":"{}","last_checked_at":"2020-03-05T08:20:30Z"}`,
+		},
+		{
+			types.ReportHistoryEntry{Report: "{}", LastCheckedAt: types.NewOptionalTimestamp(time.Time{})},
+			`{"report":"{}"}`,
+		},
+	} {
+		encoded, err := json.Marshal(testCase.value)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, string(encoded))
+	}
+}
+
+func TestTimestampUnmarshalJSON(t *testing.T) {
+	for _, testCase := range []struct {
+		encoded  string
+		expected types.Timestamp
+	}{
+		{`"2020-03-05T08:20:30Z"`, types.NewTimestamp(checkedAt.Truncate(time.Second))},
+		{`"2020-03-05T10:20:30.123456789+02:00"`, types.NewTimestamp(checkedAt)},
+		{`""`, types.Timestamp{}},
+		{`null`, types.Timestamp{}},
+	} {
+		var timestamp types.Timestamp
+
+		assert.NoError(t, json.Unmarshal([]byte(testCase.encoded), &timestamp))
+		assert.Equal(t, testCase.expected, timestamp, testCase.encoded)
+	}
+
+	var timestamp types.Timestamp
+	assert.Error(t, json.Unmarshal([]byte(`"yesterday"`), &timestamp))
+}
+
+// TestTimestampArithmetic checks that timestamps keep sub-second precision of the time
+func TestTimestampArithmetic(t *testing.T) {
+	timestamp := types.NewTimestamp(checkedAt)
+
+	assert.True(t, timestamp.Equal(checkedAt))
+	assert.Equal(t, 123456789*time.Nanosecond, timestamp.Sub(checkedAt.Truncate(time.Second)))
+}